@@ -7,15 +7,27 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/yourusername/webtunnel/internal/config"
 	"github.com/yourusername/webtunnel/internal/handlers"
+	"github.com/yourusername/webtunnel/internal/kv"
 	"github.com/yourusername/webtunnel/internal/middleware"
 	"github.com/yourusername/webtunnel/internal/services/auth"
-	"github.com/yourusername/webtunnel/internal/services/terminal"
+	"github.com/yourusername/webtunnel/internal/services/branding"
+	"github.com/yourusername/webtunnel/internal/services/checksum"
+	"github.com/yourusername/webtunnel/internal/services/history"
+	"github.com/yourusername/webtunnel/internal/services/notify"
+	"github.com/yourusername/webtunnel/internal/services/policy"
+	"github.com/yourusername/webtunnel/internal/services/prefs"
+	"github.com/yourusername/webtunnel/internal/services/push"
+	"github.com/yourusername/webtunnel/internal/services/shares"
+	"github.com/yourusername/webtunnel/internal/services/uploads"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/meter"
+	"github.com/yourusername/webtunnel/pkg/terminal"
 	"go.uber.org/zap"
 )
 
@@ -33,10 +45,12 @@ func main() {
 	// Create local config
 	cfg := &config.Config{
 		Server: config.ServerConfig{
-			Host:      "127.0.0.1",
-			Port:      8081,
-			TLS:       false,
-			StaticDir: "./web/dist",
+			Environment: config.EnvDevelopment,
+			Host:        "127.0.0.1",
+			Port:        8081,
+			TLS:         false,
+			StaticDir:   "./web/dist",
+			VerboseLogs: true,
 		},
 		Auth: config.AuthConfig{
 			JWTSecret:     "local-test-secret",
@@ -44,28 +58,83 @@ func main() {
 			RateLimit:     1000,
 		},
 		Session: config.SessionConfig{
-			MaxSessions:      10,
-			MaxMemoryMB:      512,
-			MaxCPUPercent:    80,
-			SessionTimeout:   "1h",
-			WorkingDirectory: "/tmp/webtunnel-local",
-			BlockedCommands:  []string{"rm", "sudo", "dd"},
+			MaxSessions:          10,
+			MaxMemoryMB:          512,
+			MaxCPUPercent:        80,
+			SessionTimeout:       "1h",
+			WorkingDirectory:     "/tmp/webtunnel-local",
+			DefaultCols:          80,
+			DefaultRows:          24,
+			Locale:               "C.UTF-8",
+			FanoutWorkers:        4,
+			LatencyProbeInterval: "10s",
+			BlockedCommands:      []string{"rm", "sudo", "dd"},
+			Prewarm:              []config.PrewarmPool{{Command: "bash", Size: 2}},
 			EnvironmentVars: map[string]string{
 				"TERM":  "xterm-256color",
 				"SHELL": "/bin/bash",
 			},
 		},
+		Timeouts: config.TimeoutsConfig{
+			CreateSession: "10s",
+			Input:         "5s",
+			FileList:      "30s",
+			FileTransfer:  "10m",
+		},
 	}
 
 	// Create services (no database required)
 	authService := &MockAuthService{}
 	termService := terminal.New(cfg.Session, logger)
+	notifier := notify.New(cfg.Notify, logger)
+	policyService := policy.New(config.PolicyConfig{FallbackAllow: true}, authService, logger)
+	termService.SetAuthorizer(policyService)
+	pushService, err := push.New(config.PushConfig{Subject: "mailto:admin@localhost", TTL: 3600}, nil, logger)
+	if err != nil {
+		log.Fatal("Failed to create push service:", err)
+	}
+	termService.OnEvent(pushService.HandleSessionEvent)
+	meterService := meter.New(cfg.Bandwidth, authService.UserRole, logger)
+	termService.SetMeter(meterService)
+	checksumService := checksum.New(nil, logger)
+	uploadService := uploads.New(cfg.Uploads, authService.UserRole, logger)
+	prefService := prefs.New(nil, logger)
+	termService.SetSessionStore(history.New(nil, logger))
+	brandingService, err := branding.New(cfg.Branding, authService, logger)
+	if err != nil {
+		log.Fatal("Failed to create branding service:", err)
+	}
+	shareService, err := shares.New(cfg.Shares, cfg.Auth.JWTSecret, nil, logger)
+	if err != nil {
+		log.Fatal("Failed to create share service:", err)
+	}
+
+	// Without Postgres, preferences and share revocations are kept in an
+	// embedded store so they survive restarts
+	statePath := os.Getenv("WEBTUNNEL_STATE")
+	if statePath == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			dir = cfg.Session.WorkingDirectory
+		}
+		statePath = filepath.Join(dir, "webtunnel", "local.kv")
+	}
+	store, err := kv.Open(statePath)
+	if err != nil {
+		log.Fatal("Failed to open local state:", err)
+	}
+	defer store.Close()
+	prefService.SetStore(store)
+	if err := shareService.SetStore(store); err != nil {
+		log.Fatal("Failed to load share links:", err)
+	}
+	logger.Info("Keeping local state", zap.String("path", statePath))
 
 	// Setup HTTP server
 	router := gin.Default()
 
 	// Middleware
-	router.Use(middleware.Logger(logger))
+	router.Use(middleware.Logger(logger, cfg.Server.VerboseLogs))
 	router.Use(middleware.Recovery(logger))
 	router.Use(middleware.CORS([]string{"*"}))
 
@@ -79,39 +148,76 @@ func main() {
 	// Health check
 	router.GET("/health", handlers.Health)
 
+	// Shared session links
+	shareHandler := handlers.NewShare(termService, shareService, brandingService, logger)
+	router.GET("/shared/:token", shareHandler.Snapshot)
+	router.GET("/shared/:token/stream", shareHandler.Stream)
+
 	// API routes
 	api := router.Group("/api/v1")
 	{
 		// Auth routes
 		auth := api.Group("/auth")
 		{
-			authHandler := handlers.NewAuth(authService, logger)
+			authHandler := handlers.NewAuth(authService, notifier, logger)
 			auth.POST("/login", authHandler.Login)
 			auth.POST("/logout", authHandler.Logout)
 		}
 
 		// Protected routes (no real auth in local mode)
 		protected := api.Group("")
+		protected.Use(middleware.Timestamps(prefService, logger))
 		{
 			// Session management with REAL terminal functionality
 			sessions := protected.Group("/sessions")
 			{
-				sessHandler := handlers.NewSession(termService, nil, logger)
+				sessHandler := handlers.NewSession(termService, nil, nil, prefService, notifier, logger)
 				sessions.GET("", sessHandler.List)
-				sessions.POST("", sessHandler.Create)
+				sessions.GET("/history", sessHandler.History)
+				sessions.POST("", middleware.Timeout(cfg.Timeouts.CreateSession), sessHandler.Create)
 				sessions.GET("/:id", sessHandler.Get)
 				sessions.DELETE("/:id", sessHandler.Delete)
-				sessions.POST("/:id/input", sessHandler.SendInput)
+				sessions.POST("/:id/input", middleware.Timeout(cfg.Timeouts.Input), sessHandler.SendInput)
+				sessions.POST("/:id/expect", sessHandler.Expect)
+				sessions.POST("/:id/presentation", sessHandler.SetPresentation)
+				sessions.GET("/:id/bookmarks", sessHandler.Bookmarks)
+				sessions.POST("/:id/bookmarks", sessHandler.Mark)
+				sessions.GET("/:id/transcript", sessHandler.Transcript)
+				sessions.GET("/:id/scrollback", sessHandler.Scrollback)
+				sessions.GET("/:id/search", sessHandler.Search)
+				sessions.GET("/:id/log", sessHandler.Log)
+				sessions.GET("/:id/recording", middleware.Timeout(cfg.Timeouts.FileTransfer), sessHandler.Recording)
 				sessions.GET("/:id/stream", sessHandler.Stream) // Real WebSocket streaming!
+				sessions.POST("/:id/poll", sessHandler.PollAttach)
+				sessions.GET("/:id/poll/:client", sessHandler.Poll)
+				sessions.POST("/:id/poll/:client", sessHandler.PollSend)
+				sessions.DELETE("/:id/poll/:client", sessHandler.PollDetach)
+				sessions.GET("/:id/share", shareHandler.Create)
+				sessions.DELETE("/:id/share", shareHandler.Revoke)
+				sessions.DELETE("/:id/share/:share_id", shareHandler.Revoke)
 			}
 
 			// File management routes
 			files := protected.Group("/files")
 			{
-				fileHandler := handlers.NewFile(logger)
-				files.GET("/browse", fileHandler.Browse)
-				files.POST("/upload/:session_id", fileHandler.Upload)
-				files.GET("/download", fileHandler.Download)
+				fileHandler := handlers.NewFile(policyService, meterService, checksumService, uploadService, nil, logger)
+				files.GET("/browse", middleware.Timeout(cfg.Timeouts.FileList), fileHandler.Browse)
+				files.GET("/search", middleware.RateLimitUser(cfg.FileSearch.RateLimit), middleware.Timeout(cfg.Timeouts.FileSearch), fileHandler.Search)
+				files.POST("/upload/:session_id", middleware.Timeout(cfg.Timeouts.FileTransfer), fileHandler.Upload)
+				files.GET("/download", middleware.Timeout(cfg.Timeouts.FileTransfer), fileHandler.Download)
+			}
+
+			// Bandwidth usage
+			bandwidthHandler := handlers.NewBandwidth(meterService, termService, logger)
+			protected.GET("/usage/bandwidth", bandwidthHandler.Usage)
+
+			// Web Push notifications
+			pushRoutes := protected.Group("/notifications/push")
+			{
+				pushHandler := handlers.NewPush(pushService, logger)
+				pushRoutes.GET("/key", pushHandler.PublicKey)
+				pushRoutes.POST("", pushHandler.Subscribe)
+				pushRoutes.DELETE("", pushHandler.Unsubscribe)
 			}
 		}
 	}
@@ -122,6 +228,10 @@ func main() {
 		Handler: router,
 	}
 
+	// Keep a couple of shells ready while serving
+	prewarmCtx, stopPrewarm := context.WithCancel(context.Background())
+	go termService.Prewarm(prewarmCtx)
+
 	// Start server in goroutine
 	go func() {
 		fmt.Printf("🚀 WebTunnel Local starting on http://%s:%d\n", cfg.Server.Host, cfg.Server.Port)
@@ -146,6 +256,7 @@ func main() {
 	defer cancel()
 
 	// Stop terminal sessions
+	stopPrewarm()
 	termService.Shutdown()
 
 	if err := server.Shutdown(ctx); err != nil {
@@ -182,4 +293,7 @@ func (m *MockAuthService) GetUserByID(userID string) (*auth.User, error) {
 		Username: "local",
 		Role:     "admin",
 	}, nil
-}
\ No newline at end of file
+}
+func (m *MockAuthService) UserRole(userID string) (string, error) {
+	return "admin", nil
+}
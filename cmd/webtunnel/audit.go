@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"go.uber.org/zap"
+)
+
+func newAuditCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the audit log",
+	}
+	cmd.AddCommand(newAuditSearchCommand())
+	cmd.AddCommand(newAuditVerifyCommand())
+	return cmd
+}
+
+func newAuditVerifyCommand() *cobra.Command {
+	var configFile, since, until, format string
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the audit log's hash chain",
+		Long: `Recompute the hash chain over the audit log, or the entries created
+between --since and --until, and check it against the recorded anchors.
+Both take a duration (24h, 7d), a date or an RFC 3339 timestamp. Exits
+non-zero when an entry was changed, removed or reordered.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var from, to time.Time
+			var err error
+			now := time.Now()
+			if since != "" {
+				if from, err = audit.ParseTime(since, now); err != nil {
+					return fmt.Errorf("invalid --since: %w", err)
+				}
+			}
+			if until != "" {
+				if to, err = audit.ParseTime(until, now); err != nil {
+					return fmt.Errorf("invalid --until: %w", err)
+				}
+			}
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q (want text or json)", format)
+			}
+
+			return withDatabase(configFile, func(db *database.DB, logger *zap.Logger) error {
+				proof, err := audit.New(db, logger).Verify(cmd.Context(), from, to)
+				if err != nil {
+					return err
+				}
+				if format == "json" {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					if err := enc.Encode(proof); err != nil {
+						return err
+					}
+				} else {
+					fmt.Printf("Chained entries: %d (ids %d to %d)\n", proof.Entries, proof.FirstID, proof.LastID)
+					if proof.Unchained > 0 {
+						fmt.Printf("Unchained entries: %d\n", proof.Unchained)
+					}
+					fmt.Printf("Anchors checked: %d\n", len(proof.Anchors))
+					if proof.EndHash != "" {
+						fmt.Printf("Chain head: %s\n", proof.EndHash)
+					}
+				}
+				if !proof.Valid {
+					return fmt.Errorf("audit chain is broken at entry %d: %s", proof.Break.EntryID, proof.Break.Reason)
+				}
+				if format == "text" {
+					fmt.Println("OK")
+				}
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file (default is $HOME/.webtunnel.yaml)")
+	cmd.Flags().StringVar(&since, "since", "", "verify entries created from this time")
+	cmd.Flags().StringVar(&until, "until", "", "verify entries created before this time")
+	cmd.Flags().StringVarP(&format, "format", "f", "text", "output format: text or json")
+
+	return cmd
+}
+
+func newAuditSearchCommand() *cobra.Command {
+	var configFile, cursor, format string
+	var limit int
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "search [query...]",
+		Short: "Search the audit log",
+		Long: `Search the audit log with field filters, for example:
+
+  webtunnel audit search user:alice action:session.create since:24h
+  webtunnel audit search 'action:session.* -user:bob ip:10.0.0.0/8 "rm -rf"' --format csv --all
+
+Fields are user, action, resource, id and ip; since and until take a
+duration (24h, 7d), a date or an RFC 3339 timestamp. Commas separate
+alternatives, a leading minus negates a term, and bare words match the
+resource ID and details.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query, err := audit.ParseQuery(strings.Join(args, " "), time.Now())
+			if err != nil {
+				return err
+			}
+
+			var write func([]*audit.Entry) error
+			var finish func() error
+			switch format {
+			case "table":
+				tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "TIME\tACTOR\tACTION\tRESOURCE\tIP")
+				write = func(entries []*audit.Entry) error {
+					for _, e := range entries {
+						resource := e.ResourceType
+						if e.ResourceID != "" {
+							resource += "/" + e.ResourceID
+						}
+						fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+							e.CreatedAt.Local().Format("2006-01-02 15:04:05"), e.ActorID, e.Action, resource, e.IPAddress)
+					}
+					return nil
+				}
+				finish = tw.Flush
+			case "json":
+				enc := json.NewEncoder(os.Stdout)
+				write = func(entries []*audit.Entry) error {
+					for _, e := range entries {
+						if err := enc.Encode(e); err != nil {
+							return err
+						}
+					}
+					return nil
+				}
+				finish = func() error { return nil }
+			case "csv":
+				out := audit.NewCSVWriter(os.Stdout)
+				write = out.Write
+				finish = out.Flush
+			default:
+				return fmt.Errorf("unknown format %q (want table, json or csv)", format)
+			}
+
+			return withDatabase(configFile, func(db *database.DB, logger *zap.Logger) error {
+				svc := audit.New(db, logger)
+				page := audit.Page{Limit: limit, Cursor: cursor}
+				for {
+					result, err := svc.Search(cmd.Context(), query, page)
+					if err != nil {
+						return err
+					}
+					if err := write(result.Entries); err != nil {
+						return err
+					}
+					if result.NextCursor == "" {
+						break
+					}
+					if !all {
+						fmt.Fprintf(os.Stderr, "more results: --cursor %s\n", result.NextCursor)
+						break
+					}
+					page.Cursor = result.NextCursor
+				}
+				return finish()
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file (default is $HOME/.webtunnel.yaml)")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 100, "entries per page (max 1000)")
+	cmd.Flags().StringVar(&cursor, "cursor", "", "continue from a previous page")
+	cmd.Flags().StringVarP(&format, "format", "f", "table", "output format: table, json or csv")
+	cmd.Flags().BoolVar(&all, "all", false, "fetch every page")
+
+	return cmd
+}
@@ -8,9 +8,12 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/gin-gonic/gin"
 	"github.com/spf13/cobra"
-	"github.com/yourusername/webtunnel/internal/config"
+	"github.com/yourusername/webtunnel/internal/database"
 	"github.com/yourusername/webtunnel/internal/server"
+	"github.com/yourusername/webtunnel/internal/services/backup"
+	"github.com/yourusername/webtunnel/pkg/config"
 	"go.uber.org/zap"
 )
 
@@ -30,6 +33,12 @@ func main() {
 	rootCmd.AddCommand(
 		newServeCommand(),
 		newVersionCommand(),
+		newExportCommand(),
+		newImportCommand(),
+		newAuditCommand(),
+		newMetricsCommand(),
+		newSelftestCommand(),
+		newSandboxExecCommand(),
 	)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -38,13 +47,21 @@ func main() {
 }
 
 func newServeCommand() *cobra.Command {
-	var configFile string
+	var configFile, waitForDeps string
+	var degraded bool
 	
 	cmd := &cobra.Command{
 		Use:   "serve",
 		Short: "Start the WebTunnel server",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runServer(configFile)
+			return runServer(configFile, func(cfg *config.Config) {
+				if cmd.Flags().Changed("wait-for-deps") {
+					cfg.Startup.WaitForDeps = waitForDeps
+				}
+				if cmd.Flags().Changed("degraded") {
+					cfg.Startup.Degraded = degraded
+				}
+			})
 		},
 	}
 
@@ -54,6 +71,8 @@ func newServeCommand() *cobra.Command {
 	cmd.Flags().Bool("tls", true, "use TLS")
 	cmd.Flags().String("db-url", "postgres://localhost/webtunnel?sslmode=disable", "database URL")
 	cmd.Flags().String("redis-url", "redis://localhost:6379", "Redis URL")
+	cmd.Flags().StringVar(&waitForDeps, "wait-for-deps", "30s", "how long to wait for the database and Redis at startup")
+	cmd.Flags().BoolVar(&degraded, "degraded", false, "start without the database, deferring login and admin routes until it connects")
 
 	return cmd
 }
@@ -70,42 +89,164 @@ func newVersionCommand() *cobra.Command {
 	}
 }
 
-func runServer(configFile string) error {
+func newExportCommand() *cobra.Command {
+	var configFile, output, passphrase string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export an encrypted backup of server state",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withBackupService(configFile, func(svc *backup.Service) error {
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("failed to create output file: %w", err)
+				}
+				defer f.Close()
+
+				if err := svc.Export(cmd.Context(), f, backupPassphrase(passphrase)); err != nil {
+					os.Remove(output)
+					return err
+				}
+				fmt.Printf("Exported server state to %s\n", output)
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file (default is $HOME/.webtunnel.yaml)")
+	cmd.Flags().StringVarP(&output, "output", "o", "webtunnel-backup.wtbk", "archive file to write")
+	cmd.Flags().StringVar(&passphrase, "passphrase", "", "archive passphrase (default is $WEBTUNNEL_BACKUP_PASSPHRASE)")
+
+	return cmd
+}
+
+func newImportCommand() *cobra.Command {
+	var configFile, input, passphrase string
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Restore server state from an encrypted backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withBackupService(configFile, func(svc *backup.Service) error {
+				f, err := os.Open(input)
+				if err != nil {
+					return fmt.Errorf("failed to open archive: %w", err)
+				}
+				defer f.Close()
+
+				result, err := svc.Import(cmd.Context(), f, backupPassphrase(passphrase))
+				if err != nil {
+					return err
+				}
+				for table, n := range result.Tables {
+					fmt.Printf("  %s: %d rows restored\n", table, n)
+				}
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file (default is $HOME/.webtunnel.yaml)")
+	cmd.Flags().StringVarP(&input, "input", "i", "webtunnel-backup.wtbk", "archive file to read")
+	cmd.Flags().StringVar(&passphrase, "passphrase", "", "archive passphrase (default is $WEBTUNNEL_BACKUP_PASSPHRASE)")
+
+	return cmd
+}
+
+func withBackupService(configFile string, fn func(*backup.Service) error) error {
+	return withDatabase(configFile, func(db *database.DB, logger *zap.Logger) error {
+		return fn(backup.New(db, logger))
+	})
+}
+
+func withDatabase(configFile string, fn func(*database.DB, *zap.Logger) error) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+	defer logger.Sync()
+
+	db, err := database.New(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	return fn(db, logger)
+}
+
+func backupPassphrase(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	return os.Getenv("WEBTUNNEL_BACKUP_PASSPHRASE")
+}
+
+func runServer(configFile string, override func(*config.Config)) error {
 	// Load configuration
 	cfg, err := config.Load(configFile)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	override(cfg)
 
 	// Setup logger
-	logger, err := zap.NewProduction()
+	newLogger := zap.NewProduction
+	if cfg.Server.Environment == config.EnvDevelopment {
+		newLogger = zap.NewDevelopment
+	}
+	logger, err := newLogger()
 	if err != nil {
 		return fmt.Errorf("failed to create logger: %w", err)
 	}
 	defer logger.Sync()
 
+	// Route dumps and debug warnings are for development only
+	if cfg.Server.Environment == config.EnvDevelopment {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
 	// Create server
 	srv, err := server.New(cfg, logger)
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
+	srv.SetBuildInfo(version, commit, date)
 
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Handle shutdown signals
+	// Handle shutdown signals; SIGHUP upgrades to the binary now on disk
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
-		<-sigChan
-		logger.Info("Received shutdown signal, gracefully shutting down...")
-		cancel()
+		for sig := range sigChan {
+			if sig == syscall.SIGHUP {
+				logger.Info("Received SIGHUP, starting upgraded process...")
+				if err := srv.Upgrade(); err != nil {
+					logger.Error("Upgrade failed, continuing with current process", zap.Error(err))
+				}
+				continue
+			}
+			logger.Info("Received shutdown signal, gracefully shutting down...")
+			cancel()
+			return
+		}
 	}()
 
 	// Start server
 	logger.Info("Starting WebTunnel server", 
+		zap.String("version", version),
+		zap.String("environment", cfg.Server.Environment),
 		zap.String("host", cfg.Server.Host),
 		zap.Int("port", cfg.Server.Port),
 		zap.Bool("tls", cfg.Server.TLS),
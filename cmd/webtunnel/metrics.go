@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newMetricsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "metrics",
+		Short: "Work with the server's Prometheus metrics",
+	}
+	cmd.AddCommand(newMetricsRulesCommand())
+	return cmd
+}
+
+// alertRule is one Prometheus alerting rule. Expr has a %s for each metric
+// selector's extra labels, filled in by selector.
+type alertRule struct {
+	Name        string
+	Expr        string
+	For         string
+	Severity    string
+	Summary     string
+	Description string
+}
+
+// alertRules watch the counters and gauges served at
+// /api/v1/admin/metrics. sel adds the scrape's labels to a selector.
+func alertRules(sel func(labels ...string) string) []alertRule {
+	return []alertRule{
+		{
+			Name:        "WebTunnelPTYStartFailures",
+			Expr:        fmt.Sprintf("sum by (instance) (increase(webtunnel_session_failures_total%s[10m])) > 0", sel(`reason="pty_start_failed"`)),
+			Severity:    "critical",
+			Summary:     "Sessions fail to start on {{ $labels.instance }}",
+			Description: "{{ $value }} terminal processes failed to start in 10 minutes. Check the sandbox, working directory and command policy; the exemplar names the last session.",
+		},
+		{
+			Name:        "WebTunnelWebSocketWriteFailures",
+			Expr:        fmt.Sprintf("sum by (instance) (rate(webtunnel_session_failures_total%s[5m])) > 0.5", sel(`reason="ws_write_failed"`)),
+			For:         "10m",
+			Severity:    "warning",
+			Summary:     "Clients are dropped on {{ $labels.instance }}",
+			Description: "Writes to attached clients fail at {{ $value }}/s. Look for a proxy closing idle WebSockets or clients on bad links.",
+		},
+		{
+			Name:        "WebTunnelQuotaRejections",
+			Expr:        fmt.Sprintf("sum by (instance) (increase(webtunnel_session_failures_total%s[15m])) > 20", sel(`reason="quota_rejected"`)),
+			Severity:    "info",
+			Summary:     "Session limits refuse many sessions on {{ $labels.instance }}",
+			Description: "{{ $value }} sessions were refused by max_sessions or max_total_sessions in 15 minutes.",
+		},
+		{
+			Name:        "WebTunnelSheddingLoad",
+			Expr:        fmt.Sprintf("max by (instance) (webtunnel_load_shed_level%s) >= 1", sel()),
+			For:         "5m",
+			Severity:    "warning",
+			Summary:     "{{ $labels.instance }} is shedding load",
+			Description: "New sessions are refused (level 1) or attaches too (level 2) because memory or goroutines are near their limits.",
+		},
+		{
+			Name:        "WebTunnelJanitorFailures",
+			Expr:        fmt.Sprintf("sum by (instance) (increase(webtunnel_janitor_failures_total%s[1h])) > 0", sel()),
+			Severity:    "warning",
+			Summary:     "Session directories are not cleaned up on {{ $labels.instance }}",
+			Description: "The janitor failed to remove {{ $value }} directories in the last hour; disk usage will grow.",
+		},
+		{
+			Name:        "WebTunnelHighLatency",
+			Expr:        fmt.Sprintf("avg by (instance) (webtunnel_connection_echo_milliseconds%s) > 500", sel()),
+			For:         "15m",
+			Severity:    "warning",
+			Summary:     "Typing is slow on {{ $labels.instance }}",
+			Description: "Input takes {{ $value }}ms on average to echo back to attached clients.",
+		},
+	}
+}
+
+func newMetricsRulesCommand() *cobra.Command {
+	var selector, group, runbook string
+
+	cmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Print example Prometheus alerting rules",
+		Long: `Print example Prometheus alerting rules for the metrics served at
+/api/v1/admin/metrics, ready for a rule_files entry:
+
+  webtunnel metrics rules --selector 'job="webtunnel"' > webtunnel-rules.yml
+  promtool check rules webtunnel-rules.yml
+
+The thresholds are starting points; tune them to your traffic.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sel := func(labels ...string) string {
+				if selector != "" {
+					labels = append(labels, selector)
+				}
+				if len(labels) == 0 {
+					return ""
+				}
+				return "{" + strings.Join(labels, ",") + "}"
+			}
+			return writeRules(os.Stdout, group, runbook, alertRules(sel))
+		},
+	}
+
+	cmd.Flags().StringVar(&selector, "selector", "", `label matchers added to every metric, e.g. job="webtunnel"`)
+	cmd.Flags().StringVar(&group, "group", "webtunnel", "rule group name")
+	cmd.Flags().StringVar(&runbook, "runbook-url", "", "base URL for runbook_url annotations; the alert name is appended")
+
+	return cmd
+}
+
+// writeRules renders the rules as a Prometheus rule file. Strings are
+// double quoted, which YAML reads the same way Go writes them.
+func writeRules(w io.Writer, group, runbook string, rules []alertRule) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "groups:\n  - name: %s\n    rules:\n", strconv.Quote(group))
+	for _, rule := range rules {
+		fmt.Fprintf(&b, "      - alert: %s\n", rule.Name)
+		fmt.Fprintf(&b, "        expr: %s\n", strconv.Quote(rule.Expr))
+		if rule.For != "" {
+			fmt.Fprintf(&b, "        for: %s\n", rule.For)
+		}
+		fmt.Fprintf(&b, "        labels:\n          severity: %s\n", rule.Severity)
+		fmt.Fprintf(&b, "        annotations:\n")
+		fmt.Fprintf(&b, "          summary: %s\n", strconv.Quote(rule.Summary))
+		fmt.Fprintf(&b, "          description: %s\n", strconv.Quote(rule.Description))
+		if runbook != "" {
+			fmt.Fprintf(&b, "          runbook_url: %s\n", strconv.Quote(strings.TrimSuffix(runbook, "/")+"/"+rule.Name))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/yourusername/webtunnel/internal/services/sandbox"
+)
+
+// newSandboxExecCommand is run by the server in place of a confined
+// session's command; it applies the profile and execs the command
+func newSandboxExecCommand() *cobra.Command {
+	var spec sandbox.Spec
+
+	cmd := &cobra.Command{
+		Use:          sandbox.Command + " [flags] -- command [args...]",
+		Short:        "Run a command under a sandbox profile",
+		Hidden:       true,
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return sandbox.Exec(spec, args)
+		},
+	}
+
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().StringSliceVar(&spec.DenySyscalls, "deny-syscalls", nil, "system calls to fail with EPERM")
+	cmd.Flags().StringVar(&spec.AppArmor, "apparmor", "", "AppArmor profile to exec under")
+	cmd.Flags().StringVar(&spec.SELinux, "selinux", "", "SELinux context to exec under")
+
+	return cmd
+}
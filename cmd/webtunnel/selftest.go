@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yourusername/webtunnel/internal/conformance"
+	"github.com/yourusername/webtunnel/pkg/client"
+)
+
+func newSelftestCommand() *cobra.Command {
+	var opts conformance.Options
+	var tlsOpts client.TLSOptions
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "Check a running server end to end",
+		Long: `Check a running server end to end: authentication, creating a session,
+attaching, typing, resizing, reconnecting and exiting over WebSocket.
+
+  webtunnel selftest --server https://tunnel.example.com --token $TOKEN
+  webtunnel selftest --server http://localhost:8080 --email ops@example.com --password ...
+  webtunnel selftest --server https://tunnel.internal --ca-file ca.pem --pin sha256/...
+
+A server with a self-signed certificate is trusted through --ca-file, or
+--pin with --insecure; --insecure alone accepts any certificate.
+--cert and --key present a client certificate to servers that require
+one.
+
+The session runs --command, sh by default, which must be allowed for the
+user. A few requests are deliberately unauthenticated, which abuse
+detection counts as auth failures.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Token == "" {
+				opts.Token = os.Getenv("WEBTUNNEL_TOKEN")
+			}
+			c := client.New(opts.Server, "")
+			if err := c.SetTLS(tlsOpts); err != nil {
+				return err
+			}
+			opts.HTTPClient, opts.Dialer = c.HTTPClient, c.Dialer
+
+			results := conformance.Run(cmd.Context(), opts)
+			if asJSON {
+				if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
+					return err
+				}
+			} else {
+				for _, r := range results {
+					switch {
+					case r.Skipped:
+						fmt.Printf("SKIP  %s\n", r.Name)
+					case r.Err != nil:
+						fmt.Printf("FAIL  %s (%s): %v\n", r.Name, r.Duration.Round(time.Millisecond), r.Err)
+					default:
+						fmt.Printf("PASS  %s (%s)\n", r.Name, r.Duration.Round(time.Millisecond))
+					}
+				}
+			}
+			if conformance.Failed(results) {
+				return fmt.Errorf("selftest failed")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Server, "server", "http://localhost:8080", "server base URL")
+	cmd.Flags().StringVar(&opts.Token, "token", "", "bearer token (default $WEBTUNNEL_TOKEN)")
+	cmd.Flags().StringVar(&opts.Email, "email", "", "log in with this email instead of a token")
+	cmd.Flags().StringVar(&opts.Password, "password", "", "password for --email")
+	cmd.Flags().StringVar(&opts.Command, "command", "sh", "POSIX shell to start the test session with")
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", 10*time.Second, "time allowed for each check")
+	cmd.Flags().StringVar(&tlsOpts.CAFile, "ca-file", "", "PEM bundle of CAs to trust instead of the system's")
+	cmd.Flags().StringArrayVar(&tlsOpts.Pins, "pin", nil, "sha256/<base64> hash of a public key the server must present (repeatable)")
+	cmd.Flags().StringVar(&tlsOpts.CertFile, "cert", "", "PEM client certificate for mTLS")
+	cmd.Flags().StringVar(&tlsOpts.KeyFile, "key", "", "PEM key for --cert")
+	cmd.Flags().BoolVar(&tlsOpts.InsecureSkipVerify, "insecure", false, "skip TLS certificate verification; pins are still checked")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print results as JSON")
+
+	return cmd
+}
@@ -0,0 +1,438 @@
+// Package conformance checks a webtunnel deployment end to end through its
+// public REST and WebSocket API: authentication, the attach, input, resize
+// and exit flow on a real PTY, reconnection, and the WebSocket protocol.
+// The integration tests run it against an in-process server, and
+// "webtunnel selftest" against a live one.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/pkg/client"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+// Options says where and as whom to run the checks. Token is used as is;
+// otherwise Email and Password log in first.
+type Options struct {
+	Server   string
+	Token    string
+	Email    string
+	Password string
+	// Command is the shell the checks type into; it must accept POSIX
+	// shell syntax. Defaults to sh.
+	Command string
+	// Timeout bounds each check. Defaults to 10 seconds.
+	Timeout    time.Duration
+	HTTPClient *http.Client
+	Dialer     *websocket.Dialer
+}
+
+// Result is the outcome of one check. A check is skipped when one it
+// depends on failed.
+type Result struct {
+	Name     string        `json:"name"`
+	Err      error         `json:"-"`
+	Error    string        `json:"error,omitempty"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Failed reports whether any result is a failure
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+type check struct {
+	name string
+	// session checks are skipped once the session could not be created
+	session bool
+	run     func(r *runner, ctx context.Context) error
+}
+
+// checks run in order; later ones build on the session and connection the
+// earlier ones left behind
+var checks = []check{
+	{name: "health", run: (*runner).health},
+	{name: "login", run: (*runner).login},
+	{name: "auth rejected", run: (*runner).authRejected},
+	{name: "unknown session", run: (*runner).unknownSession},
+	{name: "create session", run: (*runner).createSession},
+	{name: "attach and input", session: true, run: (*runner).attachAndInput},
+	{name: "resize", session: true, run: (*runner).resize},
+	{name: "protocol", session: true, run: (*runner).protocol},
+	{name: "reconnect", session: true, run: (*runner).reconnect},
+	{name: "exit", session: true, run: (*runner).exit},
+	{name: "attach after exit", session: true, run: (*runner).attachAfterExit},
+}
+
+// Run runs every check in order and cleans up the session it created
+func Run(ctx context.Context, opts Options) []Result {
+	if opts.Command == "" {
+		opts.Command = "sh"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.Dialer == nil {
+		opts.Dialer = websocket.DefaultDialer
+	}
+	r := &runner{opts: opts, server: strings.TrimRight(opts.Server, "/"), token: opts.Token}
+	defer r.cleanup()
+
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		result := Result{Name: c.name}
+		if c.session && r.session == nil {
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		start := time.Now()
+		result.Err = c.run(r, checkCtx)
+		result.Duration = time.Since(start)
+		cancel()
+
+		if result.Err != nil {
+			result.Error = result.Err.Error()
+			if c.session {
+				// The session is in an unknown state; stop using it
+				r.cleanup()
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+type runner struct {
+	opts    Options
+	server  string
+	token   string
+	client  *client.Client
+	session *client.Session
+	stream  *stream
+}
+
+func (r *runner) health(ctx context.Context) error {
+	resp, err := r.get(ctx, "/health", "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET /health: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (r *runner) login(ctx context.Context) error {
+	if r.token == "" {
+		if r.opts.Email == "" {
+			return errors.New("no token, and no email and password to log in with")
+		}
+		body, _ := json.Marshal(map[string]string{"email": r.opts.Email, "password": r.opts.Password})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.server+"/api/v1/auth/login", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := r.opts.HTTPClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("POST /api/v1/auth/login: status %d", resp.StatusCode)
+		}
+		var login struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&login); err != nil || login.Token == "" {
+			return errors.New("login response has no token")
+		}
+		r.token = login.Token
+	}
+
+	r.client = client.New(r.server, r.token)
+	r.client.HTTPClient = r.opts.HTTPClient
+	r.client.Dialer = r.opts.Dialer
+	_, err := r.client.ListSessions(ctx)
+	return err
+}
+
+// authRejected checks that the API and the stream refuse missing and
+// forged tokens
+func (r *runner) authRejected(ctx context.Context) error {
+	for _, token := range []string{"", "not-a-token"} {
+		resp, err := r.get(ctx, "/api/v1/sessions", token)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			return fmt.Errorf("GET /api/v1/sessions with token %q: status %d, want 401", token, resp.StatusCode)
+		}
+	}
+
+	forged := client.New(r.server, "not-a-token")
+	forged.HTTPClient, forged.Dialer = r.opts.HTTPClient, r.opts.Dialer
+	_, err := forged.Attach(ctx, "any")
+	return wantAPIError(err, http.StatusUnauthorized, "")
+}
+
+func (r *runner) unknownSession(ctx context.Context) error {
+	if r.client == nil {
+		return errors.New("not logged in")
+	}
+	_, err := r.client.Attach(ctx, "00000000-0000-0000-0000-000000000000")
+	return wantAPIError(err, http.StatusNotFound, protocol.CodeSessionNotFound)
+}
+
+func (r *runner) createSession(ctx context.Context) error {
+	if r.client == nil {
+		return errors.New("not logged in")
+	}
+	session, err := r.client.CreateSession(ctx, client.CreateRequest{Command: r.opts.Command, Cols: 80, Rows: 24})
+	if err != nil {
+		return err
+	}
+	r.session = session
+	if session.Status != "running" {
+		return fmt.Errorf("new session is %s, want running", session.Status)
+	}
+	return nil
+}
+
+// attachAndInput types into the shell and waits for output only the shell
+// could have produced, not the echo of the input
+func (r *runner) attachAndInput(ctx context.Context) error {
+	stream, err := r.attach(ctx)
+	if err != nil {
+		return err
+	}
+	r.stream = stream
+	if err := stream.conn.Input("echo webtunnel-selftest-$((6*7))\n"); err != nil {
+		return err
+	}
+	return stream.output(ctx, "webtunnel-selftest-42")
+}
+
+func (r *runner) resize(ctx context.Context) error {
+	if err := r.stream.conn.Resize(100, 40); err != nil {
+		return err
+	}
+	if err := r.stream.conn.Input("stty size\n"); err != nil {
+		return err
+	}
+	return r.stream.output(ctx, "40 100")
+}
+
+// protocol checks the WebSocket upgrade is required, that the client's
+// protocol version was negotiated, and that frames the server does not
+// know are ignored rather than dropping the connection
+func (r *runner) protocol(ctx context.Context) error {
+	resp, err := r.get(ctx, "/api/v1/sessions/"+r.session.ID+"/stream", r.token)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 400 || resp.StatusCode > 499 {
+		return fmt.Errorf("stream without a WebSocket upgrade: status %d, want 4xx", resp.StatusCode)
+	}
+	if version := r.stream.conn.Version(); version != protocol.LatestVersion {
+		return fmt.Errorf("negotiated protocol version %d, want %d", version, protocol.LatestVersion)
+	}
+
+	if err := r.stream.conn.Send(protocol.Message{Type: "selftest-unknown", Data: "x"}); err != nil {
+		return err
+	}
+	if err := r.stream.conn.Input("echo webtunnel-selftest-$((7*8))\n"); err != nil {
+		return err
+	}
+	return r.stream.output(ctx, "webtunnel-selftest-56")
+}
+
+// reconnect detaches and attaches again: the session keeps running and
+// the new connection starts from the current screen
+func (r *runner) reconnect(ctx context.Context) error {
+	r.stream.close()
+	r.stream = nil
+
+	stream, err := r.attach(ctx)
+	if err != nil {
+		return err
+	}
+	r.stream = stream
+	if err := stream.output(ctx, "webtunnel-selftest-56"); err != nil {
+		return fmt.Errorf("replay after reconnect: %w", err)
+	}
+	if err := stream.conn.Input("echo webtunnel-selftest-$((8*9))\n"); err != nil {
+		return err
+	}
+	return stream.output(ctx, "webtunnel-selftest-72")
+}
+
+// exit ends the shell and expects an exit frame after the output, and the
+// exit code on the session
+func (r *runner) exit(ctx context.Context) error {
+	if err := r.stream.conn.Input("exit 7\n"); err != nil {
+		return err
+	}
+	msg, err := r.stream.next(ctx, func(msg protocol.Message) bool { return msg.Type == protocol.TypeExit })
+	if err != nil {
+		return fmt.Errorf("exit frame: %w", err)
+	}
+	var exit protocol.Exit
+	if err := msg.Decode(&exit); err != nil {
+		return err
+	}
+	if exit.Code == nil || *exit.Code != 7 || exit.Reason != "exited" {
+		return fmt.Errorf("exit frame %s, want code 7 and reason exited", msg.Data)
+	}
+
+	session, err := r.client.GetSession(ctx, r.session.ID)
+	if err != nil {
+		return err
+	}
+	if session.Status != "stopped" || session.ExitCode == nil || *session.ExitCode != 7 {
+		return fmt.Errorf("ended session is %s with exit code %v, want stopped with 7", session.Status, session.ExitCode)
+	}
+	return nil
+}
+
+func (r *runner) attachAfterExit(ctx context.Context) error {
+	r.stream.close()
+	r.stream = nil
+	_, err := r.client.Attach(ctx, r.session.ID)
+	return wantAPIError(err, http.StatusGone, protocol.CodeSessionExited)
+}
+
+func (r *runner) cleanup() {
+	if r.stream != nil {
+		r.stream.close()
+		r.stream = nil
+	}
+	if r.session != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), r.opts.Timeout)
+		r.client.KillSession(ctx, r.session.ID)
+		cancel()
+		r.session = nil
+	}
+}
+
+func (r *runner) attach(ctx context.Context) (*stream, error) {
+	conn, err := r.client.Attach(ctx, r.session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newStream(conn), nil
+}
+
+func (r *runner) get(ctx context.Context, path, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r.opts.HTTPClient.Do(req)
+}
+
+func wantAPIError(err error, status int, code string) error {
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("got %v, want status %d", err, status)
+	}
+	if apiErr.StatusCode != status || (code != "" && apiErr.Code != code) {
+		return fmt.Errorf("got status %d code %q, want %d %q", apiErr.StatusCode, apiErr.Code, status, code)
+	}
+	return nil
+}
+
+// stream reads an attached connection on its own goroutine, so checks can
+// wait for output with a deadline
+type stream struct {
+	conn *client.Conn
+	msgs chan protocol.Message
+	err  error           // set before msgs is closed
+	seen strings.Builder // output not yet matched by output()
+}
+
+func newStream(conn *client.Conn) *stream {
+	s := &stream{conn: conn, msgs: make(chan protocol.Message, 64)}
+	go func() {
+		defer close(s.msgs)
+		for {
+			msg, err := conn.Receive()
+			if err != nil {
+				s.err = err
+				return
+			}
+			s.msgs <- msg
+		}
+	}()
+	return s
+}
+
+// next returns the first message matching
+func (s *stream) next(ctx context.Context, match func(protocol.Message) bool) (protocol.Message, error) {
+	for {
+		select {
+		case msg, ok := <-s.msgs:
+			if !ok {
+				if s.err == nil {
+					s.err = io.EOF
+				}
+				return protocol.Message{}, fmt.Errorf("connection closed: %w", s.err)
+			}
+			if msg.Type == protocol.TypeOutput {
+				s.seen.WriteString(msg.Data)
+			}
+			if match(msg) {
+				return msg, nil
+			}
+		case <-ctx.Done():
+			return protocol.Message{}, ctx.Err()
+		}
+	}
+}
+
+// output waits until the output since the last match contains want
+func (s *stream) output(ctx context.Context, want string) error {
+	for !strings.Contains(s.seen.String(), want) {
+		if _, err := s.next(ctx, func(msg protocol.Message) bool { return msg.Type == protocol.TypeOutput }); err != nil {
+			return fmt.Errorf("waiting for %q: %w", want, err)
+		}
+	}
+	seen := s.seen.String()
+	s.seen.Reset()
+	s.seen.WriteString(seen[strings.Index(seen, want)+len(want):])
+	return nil
+}
+
+func (s *stream) close() {
+	s.conn.Close()
+	for range s.msgs {
+	}
+}
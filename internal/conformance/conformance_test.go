@@ -0,0 +1,81 @@
+//go:build integration
+
+package conformance
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/server"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// newTestServer runs the full server in process, degraded since there is
+// no database or Redis: sessions work, login does not
+func newTestServer(t *testing.T) (*httptest.Server, *config.Config) {
+	t.Helper()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "webtunnel.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+server:
+  environment: production
+database:
+  url: "postgres://webtunnel@127.0.0.1:1/webtunnel?sslmode=disable&connect_timeout=1"
+redis:
+  url: "redis://127.0.0.1:1"
+startup:
+  wait_for_deps: "0"
+  degraded: true
+auth:
+  jwt_secret: "conformance"
+session:
+  working_directory: "`+dir+`"
+`), 0600))
+
+	cfg, err := config.Load(file)
+	require.NoError(t, err)
+	srv, err := server.New(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts, cfg
+}
+
+func TestConformance(t *testing.T) {
+	ts, cfg := newTestServer(t)
+	token, err := auth.New(cfg.Auth, nil, zap.NewNop()).GenerateToken("user_conformance", "conformance@example.com", "user")
+	require.NoError(t, err)
+
+	results := Run(context.Background(), Options{Server: ts.URL, Token: token})
+	for _, r := range results {
+		assert.NoError(t, r.Err, r.Name)
+		assert.False(t, r.Skipped, r.Name)
+	}
+	assert.Len(t, results, len(checks))
+	assert.False(t, Failed(results))
+}
+
+func TestConformanceReportsFailures(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	// Without credentials nothing past the public checks can run
+	results := Run(context.Background(), Options{Server: ts.URL})
+	require.True(t, Failed(results))
+	byName := make(map[string]Result)
+	for _, r := range results {
+		byName[r.Name] = r
+	}
+	assert.NoError(t, byName["health"].Err)
+	assert.NoError(t, byName["auth rejected"].Err)
+	assert.Error(t, byName["login"].Err)
+	assert.Error(t, byName["create session"].Err)
+	assert.True(t, byName["exit"].Skipped)
+}
@@ -6,7 +6,7 @@ import (
 	"time"
 
 	_ "github.com/lib/pq"
-	"github.com/yourusername/webtunnel/internal/config"
+	"github.com/yourusername/webtunnel/pkg/config"
 )
 
 type DB struct {
@@ -14,6 +14,23 @@ type DB struct {
 }
 
 func New(cfg config.DatabaseConfig) (*DB, error) {
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Test connection
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return db, nil
+}
+
+// Open configures the pool without connecting; connections are made on
+// first use, so the server can start before Postgres is reachable
+func Open(cfg config.DatabaseConfig) (*DB, error) {
 	db, err := sql.Open("postgres", cfg.URL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -31,11 +48,6 @@ func New(cfg config.DatabaseConfig) (*DB, error) {
 		db.SetConnMaxLifetime(duration)
 	}
 
-	// Test connection
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
-	}
-
 	return &DB{db}, nil
 }
 
@@ -0,0 +1,91 @@
+package diagnostics
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// BuildInfo is stamped into the binary by the Makefile through -ldflags
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+type RuntimeStats struct {
+	Goroutines   int     `json:"goroutines"`
+	OpenFDs      int     `json:"open_fds"` // -1 when unavailable
+	NumCPU       int     `json:"num_cpu"`
+	GOMAXPROCS   int     `json:"gomaxprocs"`
+	HeapAlloc    uint64  `json:"heap_alloc_bytes"`
+	HeapInuse    uint64  `json:"heap_inuse_bytes"`
+	HeapObjects  uint64  `json:"heap_objects"`
+	Sys          uint64  `json:"sys_bytes"`
+	NumGC        uint32  `json:"num_gc"`
+	GCPauseTotal string  `json:"gc_pause_total"`
+	GCCPUPercent float64 `json:"gc_cpu_percent"`
+}
+
+// Collector reports on the running process
+type Collector struct {
+	build   BuildInfo
+	started time.Time
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		build:   BuildInfo{Version: "dev", Commit: "unknown", Date: "unknown", GoVersion: runtime.Version()},
+		started: time.Now(),
+	}
+}
+
+// SetBuild records the version information from main
+func (c *Collector) SetBuild(version, commit, date string) {
+	c.build = BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+	}
+}
+
+func (c *Collector) Build() BuildInfo {
+	return c.build
+}
+
+func (c *Collector) Uptime() time.Duration {
+	return time.Since(c.started)
+}
+
+// Runtime reads current runtime statistics. ReadMemStats briefly stops the
+// world, so this is meant for on-demand diagnostics only.
+func (c *Collector) Runtime() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return RuntimeStats{
+		Goroutines:   runtime.NumGoroutine(),
+		OpenFDs:      openFDs(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		Sys:          mem.Sys,
+		NumGC:        mem.NumGC,
+		GCPauseTotal: time.Duration(mem.PauseTotalNs).String(),
+		GCCPUPercent: mem.GCCPUFraction * 100,
+	}
+}
+
+// openFDs counts this process's open file descriptors on Linux
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	// ReadDir's own descriptor is listed too
+	return len(entries) - 1
+}
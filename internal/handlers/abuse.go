@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/abuse"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"go.uber.org/zap"
+)
+
+// Abuse handlers
+type AbuseHandler struct {
+	abuseService *abuse.Service
+	auditService *audit.Service
+	logger       *zap.Logger
+}
+
+func NewAbuse(abuseService *abuse.Service, auditService *audit.Service, logger *zap.Logger) *AbuseHandler {
+	return &AbuseHandler{
+		abuseService: abuseService,
+		auditService: auditService,
+		logger:       logger,
+	}
+}
+
+// List returns the active bans
+func (h *AbuseHandler) List(c *gin.Context) {
+	bans, err := h.abuseService.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list bans", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list bans"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": h.abuseService.Enabled(),
+		"bans":    bans,
+	})
+}
+
+// Create bans a subject by hand. Without a duration the ban escalates like
+// an automatic one.
+func (h *AbuseHandler) Create(c *gin.Context) {
+	var req struct {
+		Subject  string `json:"subject" binding:"required"`
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		parsed, err := time.ParseDuration(req.Duration)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration"})
+			return
+		}
+		duration = parsed
+	}
+
+	ban, err := h.abuseService.Ban(c.Request.Context(), req.Subject, abuse.SignalManual, req.Reason, duration)
+	if err != nil {
+		if errors.Is(err, abuse.ErrInvalidSubject) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to ban", zap.String("subject", req.Subject), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ban"})
+		return
+	}
+
+	h.record(c, audit.ActionBan, req.Subject, map[string]interface{}{
+		"reason":     req.Reason,
+		"expires_at": ban.ExpiresAt,
+		"strike":     ban.Strike,
+	})
+	c.JSON(http.StatusCreated, ban)
+}
+
+// Delete lifts a ban and forgets the subject's earlier bans
+func (h *AbuseHandler) Delete(c *gin.Context) {
+	subject := c.Param("subject")
+	if err := h.abuseService.Unban(c.Request.Context(), subject); err != nil {
+		if errors.Is(err, abuse.ErrInvalidSubject) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to lift ban", zap.String("subject", subject), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lift ban"})
+		return
+	}
+
+	h.record(c, audit.ActionUnban, subject, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Ban lifted"})
+}
+
+func (h *AbuseHandler) record(c *gin.Context, action, subject string, details map[string]interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	entry := &audit.Entry{
+		ActorID:      c.GetString("user_id"),
+		Action:       action,
+		ResourceType: "ban",
+		ResourceID:   subject,
+		Details:      details,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := h.auditService.Record(context.Background(), entry); err != nil {
+		h.logger.Error("Failed to record ban change", zap.Error(err))
+	}
+}
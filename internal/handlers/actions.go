@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/actions"
+	"go.uber.org/zap"
+)
+
+// Action handlers
+type ActionHandler struct {
+	actionService *actions.Service
+	logger        *zap.Logger
+}
+
+func NewAction(actionService *actions.Service, logger *zap.Logger) *ActionHandler {
+	return &ActionHandler{
+		actionService: actionService,
+		logger:        logger,
+	}
+}
+
+// List returns the actions the caller may take, narrowed by ?kind= and
+// ?q=, for UIs and the CLI to offer in a command palette
+func (h *ActionHandler) List(c *gin.Context) {
+	list := h.actionService.List(c.Request.Context(), c.GetString("user_id"), actions.Filter{
+		Kind:  c.Query("kind"),
+		Query: c.Query("q"),
+	})
+	c.JSON(http.StatusOK, gin.H{"actions": list})
+}
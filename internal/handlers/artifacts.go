@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/artifacts"
+	"github.com/yourusername/webtunnel/internal/services/checksum"
+	"go.uber.org/zap"
+)
+
+// Artifact handlers. Files kept from a command are served to the user it
+// ran as and to admins.
+type ArtifactHandler struct {
+	artifactService *artifacts.Service
+	roles           func(userID string) (string, error)
+	logger          *zap.Logger
+}
+
+func NewArtifact(artifactService *artifacts.Service, roles func(userID string) (string, error), logger *zap.Logger) *ArtifactHandler {
+	return &ArtifactHandler{
+		artifactService: artifactService,
+		roles:           roles,
+		logger:          logger,
+	}
+}
+
+// List returns what was kept from a command, including skipped matches
+func (h *ArtifactHandler) List(c *gin.Context) {
+	manifest, ok := h.manifest(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, manifest)
+}
+
+// Download serves one kept file
+func (h *ArtifactHandler) Download(c *gin.Context) {
+	manifest, ok := h.manifest(c)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	file, artifact, err := h.artifactService.Open(manifest, name)
+	if errors.Is(err, artifacts.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to open artifact", zap.String("exec_id", manifest.ExecID),
+			zap.String("name", name), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open artifact"})
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open artifact"})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+path.Base(name))
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Digest", checksum.DigestHeader(artifact.SHA256))
+	c.Header("ETag", `"`+artifact.SHA256+`"`)
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// manifest loads the command's manifest if the caller may see it; someone
+// else's artifacts are not found rather than forbidden
+func (h *ArtifactHandler) manifest(c *gin.Context) (*artifacts.Manifest, bool) {
+	manifest, err := h.artifactService.Manifest(c.Param("id"))
+	if err == nil {
+		userID := c.GetString("user_id")
+		if role, roleErr := h.roles(userID); manifest.UserID != userID && (roleErr != nil || role != "admin") {
+			err = artifacts.ErrNotFound
+		}
+	}
+	switch {
+	case errors.Is(err, artifacts.ErrDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	case errors.Is(err, artifacts.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifacts not found"})
+		return nil, false
+	case err != nil:
+		h.logger.Error("Failed to read artifacts", zap.String("exec_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read artifacts"})
+		return nil, false
+	}
+	return manifest, true
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"go.uber.org/zap"
+)
+
+// Audit handlers
+type AuditHandler struct {
+	auditService *audit.Service
+	logger       *zap.Logger
+}
+
+func NewAudit(auditService *audit.Service, logger *zap.Logger) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+		logger:       logger,
+	}
+}
+
+func (h *AuditHandler) Query(c *gin.Context) {
+	filter := audit.Filter{
+		ActorID:      c.Query("actor_id"),
+		Action:       c.Query("action"),
+		ResourceType: c.Query("resource_type"),
+		ResourceID:   c.Query("resource_id"),
+	}
+
+	var err error
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp"})
+			return
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until timestamp"})
+			return
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	entries, err := h.auditService.Query(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to query audit log", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// Search runs an audit query (user:alice action:session.* since:24h). With
+// format=csv every matching entry is exported rather than a single page.
+func (h *AuditHandler) Search(c *gin.Context) {
+	query, err := audit.ParseQuery(c.Query("q"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page := audit.Page{Cursor: c.Query("cursor")}
+	if limit := c.Query("limit"); limit != "" {
+		if page.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	if c.Query("format") == "csv" {
+		h.exportCSV(c, query, page)
+		return
+	}
+
+	result, err := h.auditService.Search(c.Request.Context(), query, page)
+	if err != nil {
+		h.logger.Error("Failed to search audit log", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *AuditHandler) exportCSV(c *gin.Context, query *audit.Query, page audit.Page) {
+	page.Limit = 1000
+	result, err := h.auditService.Search(c.Request.Context(), query, page)
+	if err != nil {
+		h.logger.Error("Failed to search audit log", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search audit log"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="audit.csv"`)
+	c.Status(http.StatusOK)
+
+	out := audit.NewCSVWriter(c.Writer)
+	for {
+		if err := out.Write(result.Entries); err != nil {
+			h.logger.Warn("Audit export interrupted", zap.Error(err))
+			return
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		page.Cursor = result.NextCursor
+		if result, err = h.auditService.Search(c.Request.Context(), query, page); err != nil {
+			// Headers are already sent; all we can do is cut the export short
+			h.logger.Error("Audit export failed", zap.Error(err))
+			break
+		}
+	}
+	if err := out.Flush(); err != nil {
+		h.logger.Warn("Audit export interrupted", zap.Error(err))
+	}
+}
+
+// Proof verifies the hash chain over entries created between since and
+// until (RFC 3339, both optional) and returns the proof. A broken chain is
+// still a 200, with valid false and where it broke.
+func (h *AuditHandler) Proof(c *gin.Context) {
+	var since, until time.Time
+	var err error
+	if value := c.Query("since"); value != "" {
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp"})
+			return
+		}
+	}
+	if value := c.Query("until"); value != "" {
+		if until, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until timestamp"})
+			return
+		}
+	}
+
+	proof, err := h.auditService.Verify(c.Request.Context(), since, until)
+	if err != nil {
+		h.logger.Error("Failed to verify audit chain", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit chain"})
+		return
+	}
+	if !proof.Valid {
+		h.logger.Warn("Audit chain is broken",
+			zap.Int64("entry_id", proof.Break.EntryID),
+			zap.String("reason", proof.Break.Reason))
+	}
+
+	c.JSON(http.StatusOK, proof)
+}
+
+// Fingerprints lists the TLS clients a user has connected with
+func (h *AuditHandler) Fingerprints(c *gin.Context) {
+	summaries, err := h.auditService.Fingerprints(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		h.logger.Error("Failed to query client fingerprints", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query client fingerprints"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      c.Param("user_id"),
+		"fingerprints": summaries,
+	})
+}
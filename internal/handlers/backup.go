@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/backup"
+	"go.uber.org/zap"
+)
+
+// Backup handlers
+type BackupHandler struct {
+	backupService *backup.Service
+	logger        *zap.Logger
+}
+
+func NewBackup(backupService *backup.Service, logger *zap.Logger) *BackupHandler {
+	return &BackupHandler{
+		backupService: backupService,
+		logger:        logger,
+	}
+}
+
+func (h *BackupHandler) Export(c *gin.Context) {
+	var req struct {
+		Passphrase string `json:"passphrase" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("webtunnel-backup-%s.wtbk", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Header("Content-Type", "application/octet-stream")
+
+	if err := h.backupService.Export(c.Request.Context(), c.Writer, req.Passphrase); err != nil {
+		h.logger.Error("Failed to export server state", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export server state"})
+		return
+	}
+}
+
+func (h *BackupHandler) Import(c *gin.Context) {
+	passphrase := c.PostForm("passphrase")
+	if passphrase == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passphrase required"})
+		return
+	}
+
+	file, _, err := c.Request.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get archive"})
+		return
+	}
+	defer file.Close()
+
+	result, err := h.backupService.Import(c.Request.Context(), file, passphrase)
+	if err != nil {
+		h.logger.Error("Failed to import server state", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
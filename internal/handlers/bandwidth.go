@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/pkg/meter"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Bandwidth handlers
+type BandwidthHandler struct {
+	meterService *meter.Service
+	termService  *terminal.Service
+	logger       *zap.Logger
+}
+
+func NewBandwidth(meterService *meter.Service, termService *terminal.Service, logger *zap.Logger) *BandwidthHandler {
+	return &BandwidthHandler{
+		meterService: meterService,
+		termService:  termService,
+		logger:       logger,
+	}
+}
+
+// Usage returns the caller's totals and those of their sessions
+func (h *BandwidthHandler) Usage(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var sessions []meter.Usage
+	for _, session := range h.termService.ListSessions(userID) {
+		sessions = append(sessions, h.meterService.SessionUsage(session.ID))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":     h.meterService.UserUsage(userID),
+		"sessions": sessions,
+	})
+}
+
+func (h *BandwidthHandler) All(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"users":    h.meterService.Users(),
+		"sessions": h.meterService.Sessions(),
+	})
+}
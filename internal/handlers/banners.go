@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/banners"
+	"go.uber.org/zap"
+)
+
+// Session banner handlers
+type BannerHandler struct {
+	bannerService *banners.Service
+	auditService  *audit.Service
+	logger        *zap.Logger
+}
+
+func NewBanner(bannerService *banners.Service, auditService *audit.Service, logger *zap.Logger) *BannerHandler {
+	return &BannerHandler{
+		bannerService: bannerService,
+		auditService:  auditService,
+		logger:        logger,
+	}
+}
+
+// Get returns the caller's banner, null when they have none, and whether
+// they have acknowledged it
+func (h *BannerHandler) Get(c *gin.Context) {
+	userID := c.GetString("user_id")
+	banner, err := h.bannerService.Banner(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to look up banner", zap.String("user_id", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up banner"})
+		return
+	}
+	if banner == nil {
+		c.JSON(http.StatusOK, gin.H{"banner": nil})
+		return
+	}
+
+	acknowledged, err := h.bannerService.Acknowledged(c.Request.Context(), userID, banner.ID)
+	if err != nil {
+		h.logger.Error("Failed to check banner acknowledgment", zap.String("user_id", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up banner"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"banner": banner, "acknowledged": acknowledged})
+}
+
+// Acknowledge records that the caller has read the banner with the given
+// ID, which must be their current one
+func (h *BannerHandler) Acknowledge(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var req struct {
+		ID string `json:"id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ack, err := h.bannerService.Acknowledge(c.Request.Context(), userID, req.ID)
+	if errors.Is(err, banners.ErrStale) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to acknowledge banner", zap.String("user_id", userID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to acknowledge banner"})
+		return
+	}
+
+	if h.auditService != nil {
+		entry := &audit.Entry{
+			ActorID:      userID,
+			Action:       audit.ActionBannerAck,
+			ResourceType: "banner",
+			ResourceID:   ack.BannerID,
+			IPAddress:    c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+		}
+		if err := h.auditService.Record(context.Background(), entry); err != nil {
+			h.logger.Error("Failed to record banner acknowledgment", zap.Error(err))
+		}
+	}
+	c.JSON(http.StatusOK, ack)
+}
+
+// List returns the configured banners with their IDs
+func (h *BannerHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"banners": h.bannerService.List()})
+}
+
+// Acks lists who acknowledged a banner
+func (h *BannerHandler) Acks(c *gin.Context) {
+	acks, err := h.bannerService.Acks(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.logger.Error("Failed to list banner acknowledgments", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list banner acknowledgments"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"acks": acks})
+}
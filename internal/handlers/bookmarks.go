@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Bookmark handlers
+
+// Mark drops a named bookmark at the session's current output offset.
+// Viewers with read access may mark too; it does not touch the PTY.
+func (h *SessionHandler) Mark(c *gin.Context) {
+	if _, ok := h.authorize(c, terminal.AccessRead); !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bookmark, err := h.termService.Mark(c.Param("id"), c.GetString("user_id"), req.Name)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, terminal.ErrBookmarkExists):
+			status = http.StatusConflict
+		case errors.Is(err, terminal.ErrSessionNotFound):
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, bookmark)
+}
+
+// Bookmarks lists the session's bookmarks, oldest first
+func (h *SessionHandler) Bookmarks(c *gin.Context) {
+	if _, ok := h.authorize(c, terminal.AccessRead); !ok {
+		return
+	}
+
+	bookmarks, err := h.termService.Bookmarks(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"bookmarks": bookmarks})
+}
+
+// Transcript returns the output between ?since= and ?until=, each a
+// bookmark name or byte offset
+func (h *SessionHandler) Transcript(c *gin.Context) {
+	if _, ok := h.authorize(c, terminal.AccessRead); !ok {
+		return
+	}
+
+	transcript, err := h.termService.Transcript(c.Param("id"), c.Query("since"), c.Query("until"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, terminal.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, transcript)
+}
+
+// Scrollback returns a chunk of the session's output from ?offset=, of at
+// most ?limit= bytes, reading output that has left memory from disk when
+// the server spills scrollback
+func (h *SessionHandler) Scrollback(c *gin.Context) {
+	if _, ok := h.authorize(c, terminal.AccessRead); !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	chunk, err := h.termService.Scrollback(c.Param("id"), offset, limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, terminal.ErrSessionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, terminal.ErrInvalidAnchor):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to read scrollback", zap.String("session_id", c.Param("id")), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read scrollback"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, chunk)
+}
+
+// Search finds ?q= in the session's output with escape sequences
+// stripped, between the optional ?since= and ?until= anchors. ?literal=true
+// matches q as plain text rather than a regular expression, and
+// ?ignore_case=true ignores case.
+func (h *SessionHandler) Search(c *gin.Context) {
+	if _, ok := h.authorize(c, terminal.AccessRead); !ok {
+		return
+	}
+
+	results, err := h.termService.SearchOutput(c.Param("id"), protocol.Search{
+		Query:      c.Query("q"),
+		Literal:    c.Query("literal") == "true",
+		IgnoreCase: c.Query("ignore_case") == "true",
+		Limit:      queryInt(c, "limit", 0),
+		Since:      c.Query("since"),
+		Until:      c.Query("until"),
+	})
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, terminal.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// Log downloads the session's output as a file: ?format=txt, the default,
+// with escape sequences stripped, or ?format=raw as the terminal got it
+func (h *SessionHandler) Log(c *gin.Context) {
+	if _, ok := h.authorize(c, terminal.AccessRead); !ok {
+		return
+	}
+
+	sessionID := c.Param("id")
+	format := c.DefaultQuery("format", "txt")
+	switch format {
+	case "txt":
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+	case "raw":
+		c.Header("Content-Type", "application/octet-stream")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format; want txt or raw"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%s.%s"`, sessionID, format))
+	c.Status(http.StatusOK)
+
+	if _, err := h.termService.WriteLog(sessionID, c.Writer, format == "txt"); err != nil {
+		// Headers are already sent; all we can do is cut the download short
+		h.logger.Warn("Log download interrupted", zap.String("session_id", sessionID), zap.Error(err))
+	}
+}
+
+// checkSince rejects a bad ?since= replay anchor before a client attaches
+func (h *SessionHandler) checkSince(c *gin.Context) (string, bool) {
+	since := c.Query("since")
+	if since == "" {
+		return "", true
+	}
+	if _, err := h.termService.ResolveAnchor(c.Param("id"), since); err != nil {
+		h.attachFailed(c, err)
+		return "", false
+	}
+	return since, true
+}
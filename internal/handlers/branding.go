@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/branding"
+	"go.uber.org/zap"
+)
+
+// Branding handlers
+type BrandingHandler struct {
+	brandingService *branding.Service
+	logger          *zap.Logger
+}
+
+func NewBranding(brandingService *branding.Service, logger *zap.Logger) *BrandingHandler {
+	return &BrandingHandler{
+		brandingService: brandingService,
+		logger:          logger,
+	}
+}
+
+// Get returns the default branding, or with ?org= that org's, for pages
+// shown before login. It needs no authentication.
+func (h *BrandingHandler) Get(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	if org := c.Query("org"); org != "" {
+		c.JSON(http.StatusOK, h.brandingService.ForOrg(org))
+		return
+	}
+	c.JSON(http.StatusOK, h.brandingService.Default())
+}
+
+// Mine returns the branding of the caller's org
+func (h *BrandingHandler) Mine(c *gin.Context) {
+	c.JSON(http.StatusOK, h.brandingService.ForUser(c.GetString("user_id")))
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Presence lists who is attached to a session and who may type
+func (h *SessionHandler) Presence(c *gin.Context) {
+	session, ok := h.authorize(c, terminal.AccessRead)
+	if !ok {
+		return
+	}
+	presence, err := h.termService.Presence(session.ID)
+	if err != nil {
+		h.controlFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, presence)
+}
+
+// RequestControl asks for the session's control. It is granted at once
+// when nobody holds it; otherwise the driver and the owner see the request
+// in a presence event.
+func (h *SessionHandler) RequestControl(c *gin.Context) {
+	presence, err := h.termService.RequestControl(c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		h.controlFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, presence)
+}
+
+// GrantControl hands the session's control to another user
+func (h *SessionHandler) GrantControl(c *gin.Context) {
+	var req struct {
+		UserID string `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	presence, err := h.termService.GrantControl(c.Param("id"), c.GetString("user_id"), req.UserID)
+	if err != nil {
+		h.controlFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, presence)
+}
+
+// ReleaseControl lets anyone with write access type again
+func (h *SessionHandler) ReleaseControl(c *gin.Context) {
+	presence, err := h.termService.ReleaseControl(c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		h.controlFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, presence)
+}
+
+func (h *SessionHandler) controlFailed(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, terminal.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found", "code": protocol.CodeSessionNotFound})
+	case errors.Is(err, terminal.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": protocol.CodeForbidden})
+	case errors.Is(err, terminal.ErrCannotDrive):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Failed to change session control", zap.String("session_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/diagnostics"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Debug handlers
+type DebugHandler struct {
+	collector   *diagnostics.Collector
+	termService *terminal.Service
+	enablePprof bool
+	logger      *zap.Logger
+}
+
+func NewDebug(collector *diagnostics.Collector, termService *terminal.Service, enablePprof bool, logger *zap.Logger) *DebugHandler {
+	return &DebugHandler{
+		collector:   collector,
+		termService: termService,
+		enablePprof: enablePprof,
+		logger:      logger,
+	}
+}
+
+func (h *DebugHandler) Info(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"build":    h.collector.Build(),
+		"uptime":   h.collector.Uptime().String(),
+		"runtime":  h.collector.Runtime(),
+		"sessions": h.termService.Stats(),
+		"pprof":    h.enablePprof,
+	})
+}
+
+// Pprof serves net/http/pprof under the admin API when enabled in config
+func (h *DebugHandler) Pprof(c *gin.Context) {
+	if !h.enablePprof {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Profiling is disabled"})
+		return
+	}
+
+	h.logger.Info("Serving pprof profile",
+		zap.String("profile", c.Param("profile")),
+		zap.String("user_id", c.GetString("user_id")))
+
+	switch name := strings.TrimPrefix(c.Param("profile"), "/"); name {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/elevation"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Elevation handlers
+type ElevationHandler struct {
+	termService      *terminal.Service
+	elevationService *elevation.Service
+	auditService     *audit.Service
+	logger           *zap.Logger
+}
+
+func NewElevation(termService *terminal.Service, elevationService *elevation.Service, auditService *audit.Service, logger *zap.Logger) *ElevationHandler {
+	return &ElevationHandler{
+		termService:      termService,
+		elevationService: elevationService,
+		auditService:     auditService,
+		logger:           logger,
+	}
+}
+
+// Request asks for elevated mode on a session the caller may write to.
+// It answers 201 when the caller's role approved it, 202 when it waits for
+// an admin.
+func (h *ElevationHandler) Request(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := h.termService.CheckAccess(sessionID, c.GetString("user_id"), terminal.AccessWrite); err != nil {
+		if errors.Is(err, terminal.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Read-only access to this session"})
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		}
+		return
+	}
+
+	var req struct {
+		Minutes       int    `json:"minutes" binding:"required"`
+		Justification string `json:"justification" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request, err := h.elevationService.Request(c.Request.Context(), sessionID, c.GetString("user_id"), req.Minutes, req.Justification)
+	if errors.Is(err, elevation.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to elevate session", zap.String("session_id", sessionID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to elevate session"})
+		return
+	}
+
+	details := map[string]interface{}{
+		"request_id":    request.ID,
+		"minutes":       request.Minutes,
+		"justification": request.Justification,
+	}
+	if request.Status == elevation.StatusApproved {
+		details["self_approved"] = true
+		details["until"] = request.Until
+		h.record(c, audit.ActionElevationGrant, sessionID, details)
+		c.JSON(http.StatusCreated, request)
+		return
+	}
+	h.record(c, audit.ActionElevationAsk, sessionID, details)
+	c.JSON(http.StatusAccepted, request)
+}
+
+// End drops a session back to the standard policy early
+func (h *ElevationHandler) End(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := h.termService.CheckAccess(sessionID, c.GetString("user_id"), terminal.AccessWrite); err != nil {
+		if errors.Is(err, terminal.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Read-only access to this session"})
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		}
+		return
+	}
+
+	if err := h.elevationService.End(sessionID); err != nil {
+		if errors.Is(err, terminal.ErrNotElevated) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Session is not elevated"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	h.record(c, audit.ActionElevationEnd, sessionID, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Elevated mode ended"})
+}
+
+// Pending lists the requests waiting for an admin
+func (h *ElevationHandler) Pending(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"requests": h.elevationService.Pending()})
+}
+
+// Approve elevates the session of a pending request
+func (h *ElevationHandler) Approve(c *gin.Context) {
+	request, err := h.elevationService.Approve(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if errors.Is(err, elevation.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Elevation request not found"})
+		return
+	}
+	if errors.Is(err, terminal.ErrSessionNotFound) {
+		c.JSON(http.StatusGone, gin.H{"error": "The session has ended"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to approve elevation", zap.String("request_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve elevation"})
+		return
+	}
+
+	h.record(c, audit.ActionElevationGrant, request.SessionID, map[string]interface{}{
+		"request_id":    request.ID,
+		"requested_by":  request.UserID,
+		"minutes":       request.Minutes,
+		"justification": request.Justification,
+		"until":         request.Until,
+	})
+	c.JSON(http.StatusOK, request)
+}
+
+// Deny turns a pending request down
+func (h *ElevationHandler) Deny(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// The reason is optional, and so is the body
+	_ = c.ShouldBindJSON(&req)
+
+	request, err := h.elevationService.Deny(c.Request.Context(), c.Param("id"), c.GetString("user_id"), req.Reason)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Elevation request not found"})
+		return
+	}
+
+	h.record(c, audit.ActionElevationDeny, request.SessionID, map[string]interface{}{
+		"request_id":   request.ID,
+		"requested_by": request.UserID,
+		"reason":       request.Reason,
+	})
+	c.JSON(http.StatusOK, request)
+}
+
+func (h *ElevationHandler) record(c *gin.Context, action, sessionID string, details map[string]interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	entry := &audit.Entry{
+		ActorID:      c.GetString("user_id"),
+		Action:       action,
+		ResourceType: "session",
+		ResourceID:   sessionID,
+		Details:      details,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := h.auditService.Record(context.Background(), entry); err != nil {
+		h.logger.Error("Failed to record elevation", zap.Error(err))
+	}
+}
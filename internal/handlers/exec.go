@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/maintenance"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Exec handlers
+type ExecHandler struct {
+	termService  *terminal.Service
+	auditService *audit.Service
+	logger       *zap.Logger
+}
+
+func NewExec(termService *terminal.Service, auditService *audit.Service, logger *zap.Logger) *ExecHandler {
+	return &ExecHandler{
+		termService:  termService,
+		auditService: auditService,
+		logger:       logger,
+	}
+}
+
+// Run executes a one-shot command and answers with its output and exit
+// code once it is done. A command that fails still answers 200; only one
+// that could not be started is an error.
+func (h *ExecHandler) Run(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Command    string `json:"command" binding:"required"`
+		WorkingDir string `json:"working_dir"`
+		Stdin      string `json:"stdin"`
+		Timeout    string `json:"timeout"` // e.g. "10s"; the route's own timeout still applies
+
+		// Artifacts are globs of files to keep, e.g. "dist/*.tar.gz"
+		Artifacts []string `json:"artifacts"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var timeout time.Duration
+	if req.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil || timeout <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timeout must be a positive duration such as 30s"})
+			return
+		}
+	}
+
+	result, err := h.termService.Exec(c.Request.Context(), userID, terminal.ExecOptions{
+		Command:    req.Command,
+		WorkingDir: req.WorkingDir,
+		Stdin:      req.Stdin,
+		Timeout:    timeout,
+		Artifacts:  req.Artifacts,
+	})
+	if err != nil {
+		var closed *maintenance.ClosedError
+		switch {
+		case errors.As(err, &closed):
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(closed.Until).Seconds())+1))
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":  err.Error(),
+				"window": closed.Window,
+				"eta":    closed.Until,
+			})
+		case abortOnContext(c, err):
+		case errors.Is(err, terminal.ErrInvalidExec):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, terminal.ErrCommandNotAllowed), errors.Is(err, terminal.ErrCommandBlocked),
+			errors.Is(err, terminal.ErrPolicyDenied):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case refuseQuota(c, err):
+		default:
+			h.logger.Error("Failed to run command", zap.String("user_id", userID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if h.auditService != nil {
+		entry := &audit.Entry{
+			ActorID:      userID,
+			Action:       audit.ActionExec,
+			ResourceType: "exec",
+			ResourceID:   result.ID,
+			Details: map[string]interface{}{
+				"command":   req.Command,
+				"exit_code": result.ExitCode,
+				"timed_out": result.TimedOut,
+				"artifacts": len(result.Artifacts),
+			},
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}
+		if err := h.auditService.Record(context.Background(), entry); err != nil {
+			h.logger.Error("Failed to record exec", zap.Error(err))
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/fileacl"
+)
+
+// File ACL handlers
+type FileACLHandler struct {
+	aclService *fileacl.Service
+}
+
+func NewFileACL(aclService *fileacl.Service) *FileACLHandler {
+	return &FileACLHandler{aclService: aclService}
+}
+
+// List returns the configured ACLs. With user_id and path it also reports
+// the access that user has there, for checking a rule set.
+func (h *FileACLHandler) List(c *gin.Context) {
+	response := gin.H{
+		"default": h.aclService.Default(),
+		"rules":   h.aclService.Rules(),
+	}
+	if userID, path := c.Query("user_id"), c.Query("path"); userID != "" && path != "" {
+		response["access"] = h.aclService.Access(userID, path)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/internal/services/flags"
+	"go.uber.org/zap"
+)
+
+// Feature flag handlers
+type FlagHandler struct {
+	flagService FlagServiceInterface
+	authService AuthServiceInterface
+	logger      *zap.Logger
+}
+
+type FlagServiceInterface interface {
+	Evaluate(ctx context.Context, userID, orgID string) (map[string]bool, error)
+	List(ctx context.Context) ([]*flags.Flag, error)
+	Upsert(ctx context.Context, flag *flags.Flag) error
+	Delete(ctx context.Context, name string) error
+}
+
+func NewFlag(flagService FlagServiceInterface, authService AuthServiceInterface, logger *zap.Logger) *FlagHandler {
+	return &FlagHandler{
+		flagService: flagService,
+		authService: authService,
+		logger:      logger,
+	}
+}
+
+// Evaluate returns the flag states for the calling user
+func (h *FlagHandler) Evaluate(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	orgID := ""
+	if user, err := h.authService.GetUserByID(userID); err == nil {
+		orgID = user.OrgID
+	}
+
+	result, err := h.flagService.Evaluate(c.Request.Context(), userID, orgID)
+	if err != nil {
+		h.logger.Error("Failed to evaluate feature flags", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate feature flags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"flags": result})
+}
+
+func (h *FlagHandler) List(c *gin.Context) {
+	result, err := h.flagService.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list feature flags", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list feature flags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"flags": result})
+}
+
+func (h *FlagHandler) Update(c *gin.Context) {
+	var req struct {
+		Description       string   `json:"description"`
+		Enabled           bool     `json:"enabled"`
+		RolloutPercentage int      `json:"rollout_percentage"`
+		UserIDs           []string `json:"user_ids"`
+		OrgIDs            []string `json:"org_ids"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	flag := &flags.Flag{
+		Name:              c.Param("name"),
+		Description:       req.Description,
+		Enabled:           req.Enabled,
+		RolloutPercentage: req.RolloutPercentage,
+		UserIDs:           req.UserIDs,
+		OrgIDs:            req.OrgIDs,
+	}
+
+	if err := h.flagService.Upsert(c.Request.Context(), flag); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user, ok := c.Get("user"); ok {
+		h.logger.Info("Feature flag changed by admin",
+			zap.String("flag", flag.Name),
+			zap.String("admin_id", user.(*auth.User).ID))
+	}
+
+	c.JSON(http.StatusOK, flag)
+}
+
+func (h *FlagHandler) Delete(c *gin.Context) {
+	if err := h.flagService.Delete(c.Request.Context(), c.Param("name")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Feature flag deleted"})
+}
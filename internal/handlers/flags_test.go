@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/middleware"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/internal/services/flags"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// orgDirectory is a user directory whose users belong to orgs, as rows in
+// users with org_id set do
+type orgDirectory struct {
+	mu   sync.Mutex
+	orgs map[string]string // by email
+}
+
+func (d *orgDirectory) user(email string) (*auth.User, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	org, ok := d.orgs[email]
+	if !ok {
+		return nil, false
+	}
+	return &auth.User{ID: "user_" + email, Email: email, Username: email, Role: "user", OrgID: org}, true
+}
+
+func (d *orgDirectory) Authenticate(login, _ string) (*auth.User, bool, error) {
+	user, ok := d.user(login)
+	return user, ok, nil
+}
+
+func (d *orgDirectory) Lookup(userID string) (*auth.User, bool, error) {
+	email, ok := strings.CutPrefix(userID, "user_")
+	if !ok {
+		return nil, false, nil
+	}
+	user, ok := d.user(email)
+	return user, ok, nil
+}
+
+// memoryFlags keeps flags in memory, turning them on for listed users and
+// orgs
+type memoryFlags struct {
+	mu    sync.Mutex
+	flags map[string]flags.Flag
+}
+
+func (m *memoryFlags) Evaluate(_ context.Context, userID, orgID string) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make(map[string]bool, len(m.flags))
+	for name, flag := range m.flags {
+		result[name] = flag.Enabled && (slices.Contains(flag.UserIDs, userID) ||
+			(orgID != "" && slices.Contains(flag.OrgIDs, orgID)))
+	}
+	return result, nil
+}
+
+func (m *memoryFlags) List(context.Context) ([]*flags.Flag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []*flags.Flag
+	for _, flag := range m.flags {
+		list = append(list, &flag)
+	}
+	return list, nil
+}
+
+func (m *memoryFlags) Upsert(_ context.Context, flag *flags.Flag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flags[flag.Name] = *flag
+	return nil
+}
+
+func (m *memoryFlags) Delete(_ context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.flags[name]; !ok {
+		return errors.New("feature flag not found")
+	}
+	delete(m.flags, name)
+	return nil
+}
+
+func TestFlagForOrg(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	directory := &orgDirectory{orgs: map[string]string{"ada@acme.com": "acme", "bob@globex.com": "globex"}}
+	authService := auth.New(config.AuthConfig{JWTSecret: "secret", SessionExpiry: "1h"}, nil, zap.NewNop())
+	authService.SetDirectory(directory)
+
+	handler := NewFlag(&memoryFlags{flags: make(map[string]flags.Flag)}, authService, zap.NewNop())
+	router := gin.New()
+	router.PUT("/admin/flags/:name", handler.Update)
+	router.GET("/flags", middleware.JWTAuth(authService), handler.Evaluate)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/flags/split-panes",
+		strings.NewReader(`{"enabled":true,"org_ids":["acme"]}`)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Users log in, and their org comes with them to flag evaluation
+	evaluate := func(email string) bool {
+		user, err := authService.AuthenticateUser(email, "password")
+		require.NoError(t, err)
+		token, err := authService.GenerateToken(user.ID, user.Email, user.Role)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/flags", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var body struct {
+			Flags map[string]bool `json:"flags"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Flags["split-panes"]
+	}
+	assert.True(t, evaluate("ada@acme.com"))
+	assert.False(t, evaluate("bob@globex.com"))
+	assert.False(t, evaluate("eve@example.com"))
+
+	// Leaving the org turns it off
+	directory.mu.Lock()
+	directory.orgs["ada@acme.com"] = ""
+	directory.mu.Unlock()
+	assert.False(t, evaluate("ada@acme.com"))
+}
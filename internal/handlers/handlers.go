@@ -1,19 +1,38 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/internal/middleware"
+	"github.com/yourusername/webtunnel/internal/services/audit"
 	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/internal/services/browse"
+	"github.com/yourusername/webtunnel/internal/services/checksum"
+	"github.com/yourusername/webtunnel/internal/services/maintenance"
+	"github.com/yourusername/webtunnel/internal/services/notify"
+	"github.com/yourusername/webtunnel/internal/services/policy"
+	"github.com/yourusername/webtunnel/internal/services/prefs"
 	"github.com/yourusername/webtunnel/internal/services/session"
-	"github.com/yourusername/webtunnel/internal/services/terminal"
+	"github.com/yourusername/webtunnel/internal/services/snapshots"
+	"github.com/yourusername/webtunnel/internal/services/sshkeys"
+	"github.com/yourusername/webtunnel/internal/services/templates"
+	"github.com/yourusername/webtunnel/internal/services/uploads"
+	"github.com/yourusername/webtunnel/pkg/meter"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"github.com/yourusername/webtunnel/pkg/terminal"
 	"go.uber.org/zap"
 )
 
@@ -30,6 +49,7 @@ func Health(c *gin.Context) {
 // Auth handlers
 type AuthHandler struct {
 	authService AuthServiceInterface
+	notifier    *notify.Service
 	logger      *zap.Logger
 }
 
@@ -41,9 +61,10 @@ type AuthServiceInterface interface {
 	GetUserByID(userID string) (*auth.User, error)
 }
 
-func NewAuth(authService AuthServiceInterface, logger *zap.Logger) *AuthHandler {
+func NewAuth(authService AuthServiceInterface, notifier *notify.Service, logger *zap.Logger) *AuthHandler {
 	return &AuthHandler{
 		authService: authService,
+		notifier:    notifier,
 		logger:      logger,
 	}
 }
@@ -71,6 +92,11 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	h.notifier.LoginFrom(user.ID, user.Email, c.ClientIP())
+
+	// Attribute the login for audit middleware
+	c.Set("user_id", user.ID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"token": token,
 		"user":  user,
@@ -104,40 +130,254 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 
 // Session handlers
 type SessionHandler struct {
-	termService *terminal.Service
-	sessService *session.Service
-	logger      *zap.Logger
+	termService     *terminal.Service
+	sessService     *session.Service
+	templateService *templates.Service // nil when templates are not available
+	prefService     *prefs.Service
+	notifier        *notify.Service
+	logger          *zap.Logger
 }
 
-func NewSession(termService *terminal.Service, sessService *session.Service, logger *zap.Logger) *SessionHandler {
+func NewSession(termService *terminal.Service, sessService *session.Service, templateService *templates.Service, prefService *prefs.Service, notifier *notify.Service, logger *zap.Logger) *SessionHandler {
 	return &SessionHandler{
-		termService: termService,
-		sessService: sessService,
-		logger:      logger,
+		termService:     termService,
+		sessService:     sessService,
+		templateService: templateService,
+		prefService:     prefService,
+		notifier:        notifier,
+		logger:          logger,
 	}
 }
 
+// List returns a page of the sessions the caller may read, narrowed by
+// ?status= and any number of ?tag=, all of which a session must carry.
+// Pages are chosen with limit and either offset or cursor, and ordered by
+// sort: created_at (the default) or last_active, with a leading - for
+// newest first.
 func (h *SessionHandler) List(c *gin.Context) {
 	userID := c.GetString("user_id")
-	sessions := h.termService.ListSessions(userID)
-	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+
+	filter := terminal.SessionFilter{
+		Tags:   c.QueryArray("tag"),
+		Status: terminal.Status(c.Query("status")),
+	}
+	switch filter.Status {
+	case "", terminal.StatusRunning, terminal.StatusStopped, terminal.StatusError:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be running, stopped or error"})
+		return
+	}
+
+	page := terminal.SessionPage{Cursor: c.Query("cursor"), Sort: c.Query("sort")}
+	for name, value := range map[string]*int{"limit": &page.Limit, "offset": &page.Offset} {
+		if raw := c.Query(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return
+			}
+			*value = n
+		}
+	}
+
+	list, err := page.Page(h.termService.FindSessions(userID, filter))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// Summary lists the caller's sessions with only their viewers and recent
+// output, the busiest first, for dashboards that poll it. It takes the
+// same tag and status filters as List.
+func (h *SessionHandler) Summary(c *gin.Context) {
+	filter := terminal.SessionFilter{
+		Tags:   c.QueryArray("tag"),
+		Status: terminal.Status(c.Query("status")),
+	}
+	switch filter.Status {
+	case "", terminal.StatusRunning, terminal.StatusStopped, terminal.StatusError:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be running, stopped or error"})
+		return
+	}
+
+	summaries := terminal.Summaries(h.termService.FindSessions(c.GetString("user_id"), filter))
+	c.JSON(http.StatusOK, gin.H{"sessions": summaries, "total": len(summaries)})
+}
+
+// authorize checks the caller's access to the session in the path,
+// answering 404 or 403 when it falls short of need
+func (h *SessionHandler) authorize(c *gin.Context, need terminal.Access) (*terminal.Session, bool) {
+	session, err := h.termService.CheckAccess(c.Param("id"), c.GetString("user_id"), need)
+	switch {
+	case err == nil:
+		return session, true
+	case errors.Is(err, terminal.ErrForbidden):
+		if need == terminal.AccessWrite {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Read-only access to this session", "code": protocol.CodeForbidden})
+		} else {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No access to this session", "code": protocol.CodeForbidden})
+		}
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found", "code": protocol.CodeSessionNotFound})
+	}
+	return nil, false
+}
+
+// attachStatus is the HTTP status for each attach error code
+var attachStatus = map[string]int{
+	protocol.CodeSessionNotFound: http.StatusNotFound,
+	protocol.CodeSessionExited:   http.StatusGone,
+	protocol.CodeForbidden:       http.StatusForbidden,
+	protocol.CodeInvalidAnchor:   http.StatusBadRequest,
+	protocol.CodeProtocolTooOld:  http.StatusUpgradeRequired,
+	protocol.CodeInternal:        http.StatusInternalServerError,
+}
+
+// checkAttachable refuses an attach before upgrading, so the client gets a
+// status and code rather than a socket that closes at once
+func (h *SessionHandler) checkAttachable(c *gin.Context, session *terminal.Session) bool {
+	if session.Status() != terminal.StatusRunning {
+		h.attachFailed(c, fmt.Errorf("%w: %s", terminal.ErrSessionExited, session.ID))
+		return false
+	}
+	return true
+}
+
+// attachFailed writes an attach error with its machine readable code
+func (h *SessionHandler) attachFailed(c *gin.Context, err error) {
+	code := terminal.AttachErrorCode(err)
+	if code == protocol.CodeInternal {
+		h.logger.Error("Failed to attach", zap.String("session_id", c.Param("id")), zap.Error(err))
+	}
+	c.JSON(attachStatus[code], gin.H{"error": err.Error(), "code": code})
 }
 
 func (h *SessionHandler) Create(c *gin.Context) {
 	userID := c.GetString("user_id")
 	
 	var req struct {
-		Command    string `json:"command" binding:"required"`
-		WorkingDir string `json:"working_dir"`
+		Command      string              `json:"command"`
+		Name         string              `json:"name"`
+		Tags         []string            `json:"tags"`
+		WorkingDir   string              `json:"working_dir"`
+		Snapshot     string              `json:"snapshot"`
+		Cols         uint16              `json:"cols"`
+		Rows         uint16              `json:"rows"`
+		SSH          *terminal.SSHTarget `json:"ssh"`         // instead of command
+		TemplateID   string              `json:"template_id"` // ID or name, instead of command
+		ResizePolicy string              `json:"resize_policy"`
+		Scrollback   int                 `json:"scrollback"`  // bytes kept in memory
+		Shell        string              `json:"shell"`       // e.g. zsh; the user's preference if empty
+		LoginShell   *bool               `json:"login_shell"` // the user's preference if unset
+		Env          map[string]string   `json:"env"`         // added to the configured environment_vars
+		Charset      string              `json:"charset"`     // e.g. ISO-8859-1; UTF-8 if empty
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.TemplateID != "" && (req.Command != "" || req.WorkingDir != "" || req.SSH != nil || req.Shell != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "template_id cannot be combined with command, working_dir, ssh or shell"})
+		return
+	}
+	if req.Command == "" && req.SSH == nil && req.TemplateID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "command, ssh or template_id is required"})
+		return
+	}
 
-	session, err := h.termService.CreateSession(userID, req.Command, req.WorkingDir)
+	opts := terminal.CreateOptions{
+		Command:    req.Command,
+		WorkingDir: req.WorkingDir,
+		SSH:        req.SSH,
+		Shell:      req.Shell,
+	}
+	if req.TemplateID == "" && h.prefService != nil {
+		shell, err := h.prefService.Shell(c.Request.Context(), userID)
+		if err != nil {
+			h.logger.Error("Failed to load preferences", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load preferences"})
+			return
+		}
+		if opts.Shell == "" {
+			opts.Shell = shell.Shell
+		}
+		opts.Login = shell.Login
+	}
+	if req.TemplateID != "" {
+		if h.templateService == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Session templates are not available"})
+			return
+		}
+		t, err := h.templateService.Get(c.Request.Context(), req.TemplateID)
+		if errors.Is(err, templates.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			h.logger.Error("Failed to load session template", zap.String("template", req.TemplateID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session template"})
+			return
+		}
+		opts = t.CreateOptions()
+	}
+	opts.Name, opts.Tags, opts.Snapshot = req.Name, req.Tags, req.Snapshot
+	opts.Cols, opts.Rows, opts.TraceID = req.Cols, req.Rows, traceID(c)
+	opts.ResizePolicy, opts.Scrollback = req.ResizePolicy, req.Scrollback
+	opts.UserEnv, opts.Charset = req.Env, req.Charset
+	if req.LoginShell != nil {
+		opts.Login = req.LoginShell
+	}
+
+	session, err := h.termService.CreateSessionWithOptions(c.Request.Context(), userID, opts)
 	if err != nil {
+		var closed *maintenance.ClosedError
+		if errors.As(err, &closed) {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(closed.Until).Seconds())+1))
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":  err.Error(),
+				"window": closed.Window,
+				"eta":    closed.Until,
+			})
+			return
+		}
+		if abortOnContext(c, err) {
+			return
+		}
+		var unacknowledged *terminal.BannerError
+		if errors.As(err, &unacknowledged) {
+			c.JSON(http.StatusPreconditionRequired, gin.H{
+				"error":  err.Error(),
+				"code":   "banner_ack_required",
+				"banner": unacknowledged.Banner,
+			})
+			return
+		}
+		if errors.Is(err, snapshots.ErrNotFound) || errors.Is(err, snapshots.ErrDisabled) ||
+			errors.Is(err, terminal.ErrInvalidName) || errors.Is(err, terminal.ErrInvalidTags) ||
+			errors.Is(err, terminal.ErrInvalidLimits) || errors.Is(err, terminal.ErrInvalidResizePolicy) ||
+			errors.Is(err, terminal.ErrInvalidScrollback) || errors.Is(err, terminal.ErrShellNotAllowed) ||
+			errors.Is(err, terminal.ErrEnvNotAllowed) || errors.Is(err, terminal.ErrInvalidCharset) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		switch {
+		case errors.Is(err, sshkeys.ErrNotFound), errors.Is(err, sshkeys.ErrNoPrivateKey),
+			errors.Is(err, sshkeys.ErrInvalidTarget), errors.Is(err, sshkeys.ErrUnknownHost):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, sshkeys.ErrHostKeyMismatch):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+
+		h.notifyCreateFailure(userID, opts.Command, err)
+		if refuseQuota(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -145,12 +385,68 @@ func (h *SessionHandler) Create(c *gin.Context) {
 	c.JSON(http.StatusCreated, session)
 }
 
+func (h *SessionHandler) notifyCreateFailure(userID, command string, err error) {
+	switch {
+	case errors.Is(err, terminal.ErrCommandBlocked), errors.Is(err, terminal.ErrCommandNotAllowed),
+		errors.Is(err, terminal.ErrPolicyDenied):
+		h.notifier.Notify(notify.Event{
+			Type:  notify.EventPolicyViolation,
+			Title: "Command policy violation",
+			Text:  err.Error(),
+			Fields: map[string]string{
+				"user_id": userID,
+				"command": command,
+			},
+		})
+	case errors.Is(err, terminal.ErrServerAtCapacity):
+		h.notifier.Notify(notify.Event{
+			Type:  notify.EventServerCapacity,
+			Title: "Server out of session capacity",
+			Text:  err.Error(),
+			Fields: map[string]string{
+				"user_id": userID,
+			},
+		})
+	}
+}
+
 func (h *SessionHandler) Get(c *gin.Context) {
-	sessionID := c.Param("id")
-	
-	session, exists := h.termService.GetSession(sessionID)
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	session, ok := h.authorize(c, terminal.AccessRead)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// Update renames the session or replaces its tags; fields left out are
+// kept
+func (h *SessionHandler) Update(c *gin.Context) {
+	session, ok := h.authorize(c, terminal.AccessWrite)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name *string   `json:"name"`
+		Tags *[]string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == nil && req.Tags == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name or tags is required"})
+		return
+	}
+
+	session, err := h.termService.Update(session.ID, terminal.SessionUpdate{Name: req.Name, Tags: req.Tags})
+	if err != nil {
+		if errors.Is(err, terminal.ErrInvalidName) || errors.Is(err, terminal.ErrInvalidTags) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "code": protocol.CodeSessionNotFound})
 		return
 	}
 
@@ -159,7 +455,10 @@ func (h *SessionHandler) Get(c *gin.Context) {
 
 func (h *SessionHandler) Delete(c *gin.Context) {
 	sessionID := c.Param("id")
-	
+	if _, ok := h.authorize(c, terminal.AccessWrite); !ok {
+		return
+	}
+
 	if err := h.termService.KillSession(sessionID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -170,6 +469,14 @@ func (h *SessionHandler) Delete(c *gin.Context) {
 
 func (h *SessionHandler) SendInput(c *gin.Context) {
 	sessionID := c.Param("id")
+	session, ok := h.authorize(c, terminal.AccessWrite)
+	if !ok {
+		return
+	}
+	if err := h.termService.CheckControl(session, c.GetString("user_id")); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	
 	var req struct {
 		Input string `json:"input" binding:"required"`
@@ -180,7 +487,10 @@ func (h *SessionHandler) SendInput(c *gin.Context) {
 		return
 	}
 
-	if err := h.termService.SendInput(sessionID, []byte(req.Input)); err != nil {
+	if err := h.termService.SendInputAs(c.Request.Context(), sessionID, c.GetString("user_id"), []byte(req.Input)); err != nil {
+		if abortOnContext(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -188,46 +498,259 @@ func (h *SessionHandler) SendInput(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Input sent"})
 }
 
+func (h *SessionHandler) Expect(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, ok := h.authorize(c, terminal.AccessRead); !ok {
+		return
+	}
+
+	var req struct {
+		Pattern   string `json:"pattern" binding:"required"`
+		Timeout   string `json:"timeout"`
+		FromStart bool   `json:"from_start"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	timeout := 30 * time.Second
+	if req.Timeout != "" {
+		parsed, err := time.ParseDuration(req.Timeout)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timeout"})
+			return
+		}
+		timeout = parsed
+	}
+	if timeout > 5*time.Minute {
+		timeout = 5 * time.Minute
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	result, err := h.termService.Expect(ctx, sessionID, req.Pattern, req.FromStart)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, terminal.ErrExpectTimeout) {
+			status = http.StatusRequestTimeout
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// attachOptions reads what a client asks for at attach: ?since= to replay
+// from an anchor, plus everything attachViewOptions reads.
+func (h *SessionHandler) attachOptions(c *gin.Context) (terminal.AttachOptions, bool) {
+	opts, ok := attachViewOptions(c)
+	if !ok {
+		return opts, false
+	}
+	since, ok := h.checkSince(c)
+	opts.Since = since
+	return opts, ok
+}
+
+// attachViewOptions reads how a client wants to watch: ?mode=read-only to
+// attach without input or resize, ?max_frame= bytes of output per frame and
+// ?flush_interval= to coalesce output. Small or slow clients use the last
+// two to get many small frames, or fewer, instead of whatever the session
+// produces.
+func attachViewOptions(c *gin.Context) (terminal.AttachOptions, bool) {
+	var opts terminal.AttachOptions
+	switch c.Query("mode") {
+	case "", "read-write":
+	case "read-only":
+		opts.ReadOnly = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be read-write or read-only"})
+		return opts, false
+	}
+	if maxFrame := c.Query("max_frame"); maxFrame != "" {
+		n, err := strconv.Atoi(maxFrame)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max_frame"})
+			return opts, false
+		}
+		opts.MaxFrame = n
+	}
+	if interval := c.Query("flush_interval"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid flush_interval"})
+			return opts, false
+		}
+		opts.FlushInterval = d
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return opts, false
+	}
+	return opts, true
+}
+
+// negotiate picks the protocol version for an attach from the versions the
+// client offered as WebSocket subprotocols or ?protocol=, answering 426
+// when it is older than the server allows
+func negotiate(c *gin.Context, termService *terminal.Service, sessionID, userID, transport string) (terminal.Negotiation, bool) {
+	offers := append(websocket.Subprotocols(c.Request), c.QueryArray("protocol")...)
+	negotiation, err := termService.Negotiate(offers, terminal.NegotiationInfo{
+		UserID:    userID,
+		SessionID: sessionID,
+		Transport: transport,
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		c.JSON(http.StatusUpgradeRequired, gin.H{
+			"error":       err.Error(),
+			"code":        protocol.CodeProtocolTooOld,
+			"min_version": termService.MinProtocolVersion(),
+		})
+		return negotiation, false
+	}
+	return negotiation, true
+}
+
+// subprotocolHeader answers the WebSocket upgrade with the negotiated
+// version, if the client negotiated one
+func subprotocolHeader(negotiation terminal.Negotiation) http.Header {
+	if negotiation.Subprotocol() == "" {
+		return nil
+	}
+	return http.Header{"Sec-WebSocket-Protocol": {negotiation.Subprotocol()}}
+}
+
 func (h *SessionHandler) Stream(c *gin.Context) {
 	sessionID := c.Param("id")
-	
+	session, ok := h.authorize(c, terminal.AccessRead)
+	if !ok || !h.checkAttachable(c, session) {
+		return
+	}
+	opts, ok := h.attachOptions(c)
+	if !ok {
+		return
+	}
+	negotiation, ok := negotiate(c, h.termService, sessionID, c.GetString("user_id"), "websocket")
+	if !ok {
+		return
+	}
+
 	// Upgrade to WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, subprotocolHeader(negotiation))
 	if err != nil {
 		h.logger.Error("Failed to upgrade to WebSocket", zap.Error(err))
 		return
 	}
 
-	if err := h.termService.AttachWebSocket(sessionID, conn); err != nil {
-		h.logger.Error("Failed to attach WebSocket", zap.Error(err))
+	// The session can still exit between the checks and here; the client
+	// then gets an attach-error frame before the socket closes
+	if err := h.termService.AttachWebSocketWith(sessionID, c.GetString("user_id"), opts, conn); err != nil {
+		h.logger.Warn("Failed to attach WebSocket", zap.String("code", terminal.AttachErrorCode(err)), zap.Error(err))
 		conn.Close()
 		return
 	}
 }
 
-func (h *SessionHandler) Share(c *gin.Context) {
+func (h *SessionHandler) SetPresentation(c *gin.Context) {
 	sessionID := c.Param("id")
-	
-	// Generate shareable URL
-	shareURL := "https://" + c.Request.Host + "/shared/" + sessionID
-	
-	c.JSON(http.StatusOK, gin.H{
-		"share_url": shareURL,
-		"expires_at": "24h", // Demo value
-	})
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.termService.SetPresentation(sessionID, userID, req.Enabled); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presenting": req.Enabled})
+}
+
+// FanoutStats reports each user's share of output bandwidth
+func (h *SessionHandler) FanoutStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"users": h.termService.FanoutStats()})
+}
+
+// ProtocolReport shows which protocol versions clients attach with and
+// the latest that were downgraded or refused
+func (h *SessionHandler) ProtocolReport(c *gin.Context) {
+	c.JSON(http.StatusOK, h.termService.ProtocolReport())
+}
+
+// ConnectionQuality reports input latency for every attached connection
+func (h *SessionHandler) ConnectionQuality(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"connections": h.termService.ConnectionQualities()})
+}
+
+// Logs returns the server log lines captured for one session, including
+// debug lines, for looking into attach and I/O problems
+func (h *SessionHandler) Logs(c *gin.Context) {
+	logs, err := h.termService.SessionLogs(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"logs": logs})
 }
 
 // File handlers
 type FileHandler struct {
-	logger *zap.Logger
+	authz           terminal.Authorizer
+	meterService    *meter.Service
+	checksumService *checksum.Service
+	uploadService   *uploads.Service
+	auditService    *audit.Service
+	logger          *zap.Logger
 }
 
-func NewFile(logger *zap.Logger) *FileHandler {
+// NewFile takes the same authorizer as the terminal service, normally the
+// policy engine. auditService may be nil, in which case rejected uploads
+// are only logged.
+func NewFile(authz terminal.Authorizer, meterService *meter.Service, checksumService *checksum.Service, uploadService *uploads.Service, auditService *audit.Service, logger *zap.Logger) *FileHandler {
 	return &FileHandler{
-		logger: logger,
+		authz:           authz,
+		meterService:    meterService,
+		checksumService: checksumService,
+		uploadService:   uploadService,
+		auditService:    auditService,
+		logger:          logger,
 	}
 }
 
+// cleanPath cleans a path given to the file API, so policy judges the
+// path that is opened. Paths with .. anywhere in them, which could climb
+// out of the directories policy allows, or a NUL byte are refused.
+func cleanPath(path string) (string, bool) {
+	if strings.Contains(path, "..") || strings.IndexByte(path, 0) >= 0 {
+		return "", false
+	}
+	return filepath.Clean(path), true
+}
+
+// authorize checks file access against policy and writes a 403 if denied
+func (h *FileHandler) authorize(c *gin.Context, action, path string) bool {
+	allowed, reason := h.authz.Authorize(c.Request.Context(), c.GetString("user_id"), action, map[string]interface{}{
+		"path": path,
+	})
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "reason": reason})
+		return false
+	}
+	return true
+}
+
 func (h *FileHandler) Browse(c *gin.Context) {
 	path := c.Query("path")
 	if path == "" {
@@ -235,43 +758,197 @@ func (h *FileHandler) Browse(c *gin.Context) {
 	}
 
 	// Security check - prevent directory traversal
-	if strings.Contains(path, "..") {
+	path, ok := cleanPath(path)
+	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
 		return
 	}
 
-	// Read directory
-	entries, err := os.ReadDir(path)
+	if !h.authorize(c, policy.ActionFileRead, path) {
+		return
+	}
+
+	opts := browse.Options{
+		Offset:  queryInt(c, "offset", 0),
+		Limit:   queryInt(c, "limit", browse.DefaultLimit),
+		Pattern: c.Query("pattern"),
+		Hidden:  c.DefaultQuery("hidden", "true") == "true",
+		Sort:    c.Query("sort"),
+		Desc:    c.Query("order") == "desc",
+	}
+	if ext := c.Query("ext"); ext != "" {
+		opts.Extensions = strings.Split(ext, ",")
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("stream") == "true" || c.GetHeader("Accept") == "application/x-ndjson" {
+		h.streamBrowse(c, path, opts)
+		return
+	}
+
+	listing, err := browse.List(c.Request.Context(), path, opts)
 	if err != nil {
+		if abortOnContext(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read directory"})
 		return
 	}
 
-	var files []gin.H
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
+	c.JSON(http.StatusOK, listing)
+}
 
-		fileType := "file"
-		if entry.IsDir() {
-			fileType = "directory"
+// Search finds files by name, and optionally by what they contain, in the
+// tree under path. Directories the caller may not read are skipped. A
+// search that runs out of time returns what it found with "truncated".
+func (h *FileHandler) Search(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" {
+		path = "/tmp"
+	}
+
+	// Security check - prevent directory traversal
+	path, ok := cleanPath(path)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		return
+	}
+
+	if !h.authorize(c, policy.ActionFileRead, path) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.GetString("user_id")
+	opts := browse.SearchOptions{
+		Query:    c.Query("q"),
+		Content:  c.Query("content"),
+		Hidden:   c.Query("hidden") == "true",
+		MaxDepth: queryInt(c, "max_depth", 0),
+		Offset:   queryInt(c, "offset", 0),
+		Limit:    queryInt(c, "limit", browse.DefaultSearchLimit),
+		Allow: func(path string) bool {
+			allowed, _ := h.authz.Authorize(ctx, userID, policy.ActionFileRead, map[string]interface{}{"path": path})
+			return allowed
+		},
+	}
+	if err := opts.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := browse.Search(ctx, path, opts)
+	if err != nil {
+		if abortOnContext(c, err) {
+			return
 		}
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Directory not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to search directory"})
+		return
+	}
 
-		files = append(files, gin.H{
-			"name": entry.Name(),
-			"type": fileType,
-			"size": info.Size(),
-			"modified": info.ModTime().Format(time.RFC3339),
-			"permissions": info.Mode().String(),
-		})
+	c.JSON(http.StatusOK, result)
+}
+
+// streamBrowse writes one JSON entry per line in directory order, then a
+// final line with the total, flushing as it goes so huge directories show
+// up incrementally.
+func (h *FileHandler) streamBrowse(c *gin.Context, path string, opts browse.Options) {
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read directory"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"path": path,
-		"files": files,
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	written := 0
+	total, err := browse.Stream(c.Request.Context(), path, opts, func(entry browse.Entry) error {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+		if written++; written%256 == 0 {
+			c.Writer.Flush()
+		}
+		return nil
 	})
+
+	summary := gin.H{"path": path, "total": total, "done": err == nil}
+	if err != nil {
+		h.logger.Warn("Directory stream ended early", zap.String("path", path), zap.Error(err))
+		summary["error"] = "Failed to read directory"
+	}
+	encoder.Encode(summary)
+	c.Writer.Flush()
+}
+
+// quotaRetry is the Retry-After given for a session quota; nothing says
+// when a session will end, so it is only how often to check
+const quotaRetry = 30
+
+// refuseQuota answers 429 with the session quota that refused a request.
+// It reports whether err was one.
+func refuseQuota(c *gin.Context, err error) bool {
+	var quota *terminal.QuotaError
+	if !errors.As(err, &quota) {
+		return false
+	}
+	limit := protocol.Limit{
+		Type:       protocol.LimitUserSessions,
+		Limit:      quota.Limit,
+		Used:       quota.Used,
+		RetryAfter: quotaRetry,
+	}
+	if errors.Is(err, terminal.ErrServerAtCapacity) {
+		limit.Type = protocol.LimitServerSessions
+	}
+	middleware.RefuseLimit(c, protocol.CodeQuotaExceeded, err.Error(), limit)
+	return true
+}
+
+// abortOnContext answers a request whose timeout passed and drops one whose
+// client went away. It reports whether err was either.
+func abortOnContext(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Operation timed out"})
+	case errors.Is(err, context.Canceled):
+		c.Abort()
+	default:
+		return false
+	}
+	return true
+}
+
+// traceID returns the trace ID of a W3C traceparent header
+// (00-<trace id>-<span id>-<flags>), or "" when there is none or it is
+// malformed
+func traceID(c *gin.Context) string {
+	parts := strings.Split(c.GetHeader("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	for _, r := range parts[1] {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return ""
+		}
+	}
+	return parts[1]
+}
+
+func queryInt(c *gin.Context, name string, fallback int) int {
+	value, err := strconv.Atoi(c.Query(name))
+	if err != nil {
+		return fallback
+	}
+	return value
 }
 
 func (h *FileHandler) Upload(c *gin.Context) {
@@ -281,40 +958,193 @@ func (h *FileHandler) Upload(c *gin.Context) {
 		return
 	}
 
-	file, header, err := c.Request.FormFile("file")
+	// Multipart uploads carry options as form fields. Any other body is
+	// streamed as the file itself, which lets large artifacts be sent with
+	// chunked transfer encoding.
+	var src io.Reader
+	var targetPath, expected string
+	declared := c.Request.ContentLength
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, header, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file"})
+			return
+		}
+		defer file.Close()
+
+		src = file
+		declared = header.Size
+		targetPath = c.PostForm("path")
+		if targetPath == "" {
+			targetPath = "/tmp/" + header.Filename
+		}
+		expected = c.PostForm("sha256")
+	} else {
+		src = c.Request.Body
+		targetPath = c.Query("path")
+		if targetPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File path required"})
+			return
+		}
+		expected = c.Query("sha256")
+	}
+	if expected == "" {
+		expected = c.GetHeader("X-Checksum-SHA256")
+	}
+	if expected == "" {
+		expected = c.GetHeader("Digest")
+	}
+
+	verifier, err := checksum.NewVerifier(expected)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	defer file.Close()
 
-	targetPath := c.PostForm("path")
-	if targetPath == "" {
-		targetPath = "/tmp/" + header.Filename
+	// Security check - prevent directory traversal
+	targetPath, ok := cleanPath(targetPath)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path"})
+		return
 	}
 
-	// Create target file
-	dst, err := os.Create(targetPath)
+	if !h.authorize(c, policy.ActionFileWrite, targetPath) {
+		return
+	}
+
+	// Judge the file by its name and content before writing anything
+	var limit int64
+	if h.uploadService.Enabled() {
+		buffered := bufio.NewReaderSize(src, uploads.SniffLen)
+		head, err := buffered.Peek(uploads.SniffLen)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+			return
+		}
+		src = buffered
+
+		verdict, err := h.uploadService.Check(c.GetString("user_id"), targetPath, head)
+		var rejected *uploads.RejectedError
+		if errors.As(err, &rejected) {
+			h.rejectUpload(c, http.StatusUnsupportedMediaType, targetPath, rejected.Reason, rejected.MIME, declared)
+			return
+		}
+		if verdict.Limit > 0 {
+			if declared > verdict.Limit {
+				h.rejectUpload(c, http.StatusRequestEntityTooLarge, targetPath,
+					fmt.Sprintf("file exceeds the %d MB limit for %s", verdict.Limit>>20, verdict.MIME), verdict.MIME, declared)
+				return
+			}
+			// One byte over the limit is enough to tell a body of unknown
+			// length is too large
+			limit = verdict.Limit
+			src = io.LimitReader(src, limit+1)
+		}
+	}
+
+	// Write beside the target and rename into place, so a failed or
+	// corrupted upload never replaces an existing file
+	dst, err := os.CreateTemp(filepath.Dir(targetPath), ".upload-*")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
 		return
 	}
-	defer dst.Close()
+	defer os.Remove(dst.Name())
 
 	// Copy file content
-	written, err := io.Copy(dst, file)
+	traffic := h.meterService.Connection(c.GetString("user_id"), sessionID, meter.KindFile)
+	defer traffic.Close()
+	written, err := io.Copy(io.MultiWriter(dst, verifier), traffic.Reader(c.Request.Context(), src))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		if abortOnContext(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
 		return
 	}
 
+	if limit > 0 && written > limit {
+		h.rejectUpload(c, http.StatusRequestEntityTooLarge, targetPath,
+			fmt.Sprintf("file exceeds the %d MB limit", limit>>20), "", written)
+		return
+	}
+
+	if err := verifier.Verify(); err != nil {
+		h.logger.Warn("Upload checksum mismatch",
+			zap.String("path", targetPath),
+			zap.String("user_id", c.GetString("user_id")),
+			zap.Error(err))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  err.Error(),
+			"sha256": verifier.Hex(),
+		})
+		return
+	}
+
+	if err := os.Chmod(dst.Name(), 0644); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	if err := os.Rename(dst.Name(), targetPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	if info, err := os.Stat(targetPath); err == nil {
+		if err := h.checksumService.Store(c.Request.Context(), targetPath, verifier.Sum(nil), info); err != nil {
+			h.logger.Error("Failed to store upload checksum", zap.Error(err))
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "File uploaded successfully",
 		"path": targetPath,
 		"size": written,
+		"sha256": verifier.Hex(),
+		"verified": expected != "",
 	})
 }
 
+// rejectUpload answers a refused upload and records it in the audit log
+func (h *FileHandler) rejectUpload(c *gin.Context, status int, path, reason, contentType string, size int64) {
+	userID := c.GetString("user_id")
+	h.logger.Warn("Upload rejected",
+		zap.String("user_id", userID),
+		zap.String("path", path),
+		zap.String("mime", contentType),
+		zap.String("reason", reason))
+
+	if h.auditService != nil {
+		details := map[string]interface{}{
+			"reason": reason,
+			"status": status,
+		}
+		if contentType != "" {
+			details["mime"] = contentType
+		}
+		if size >= 0 {
+			details["size"] = size
+		}
+		entry := &audit.Entry{
+			ActorID:      userID,
+			Action:       audit.ActionUploadRejected,
+			ResourceType: "file",
+			ResourceID:   path,
+			Details:      details,
+			IPAddress:    c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+		}
+		if err := h.auditService.Record(context.Background(), entry); err != nil {
+			h.logger.Error("Failed to record rejected upload", zap.Error(err))
+		}
+	}
+
+	c.JSON(status, gin.H{"error": "Upload rejected", "reason": reason})
+}
+
 func (h *FileHandler) Download(c *gin.Context) {
 	filePath := c.Query("path")
 	if filePath == "" {
@@ -323,13 +1153,18 @@ func (h *FileHandler) Download(c *gin.Context) {
 	}
 
 	// Security check - prevent directory traversal
-	if strings.Contains(filePath, "..") {
+	filePath, ok := cleanPath(filePath)
+	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file path"})
 		return
 	}
 
+	if !h.authorize(c, policy.ActionFileRead, filePath) {
+		return
+	}
+
 	// Check if file exists
-	info, err := os.Stat(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
@@ -338,7 +1173,13 @@ func (h *FileHandler) Download(c *gin.Context) {
 		}
 		return
 	}
+	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to access file"})
+		return
+	}
 	if info.IsDir() {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot download directory"})
 		return
@@ -349,25 +1190,85 @@ func (h *FileHandler) Download(c *gin.Context) {
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", "attachment; filename="+filepath.Base(filePath))
 	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", fmt.Sprintf("%d", info.Size()))
 
-	// Send file
-	c.File(filePath)
+	record, err := h.checksumService.Lookup(c.Request.Context(), filePath, info)
+	if err != nil {
+		h.logger.Error("Failed to look up file checksum", zap.Error(err))
+	} else if record != nil {
+		c.Header("Digest", checksum.DigestHeader(record.SHA256))
+	}
+	// ServeContent answers Range requests, checking If-Range and
+	// If-None-Match against this, so an interrupted download resumes only
+	// if the file is unchanged
+	c.Header("ETag", checksum.ETag(info, record))
+
+	traffic := h.meterService.Connection(c.GetString("user_id"), "", meter.KindFile)
+	defer traffic.Close()
+	c.Writer = &meteredResponseWriter{
+		ResponseWriter: c.Writer,
+		body:           traffic.Writer(c.Request.Context(), c.Writer),
+	}
+
+	// Send file, or the requested ranges of it
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// meteredResponseWriter routes the response body through a bandwidth meter
+type meteredResponseWriter struct {
+	gin.ResponseWriter
+	body io.Writer
+}
+
+func (w *meteredResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+func (w *meteredResponseWriter) WriteString(s string) (int, error) {
+	return w.body.Write([]byte(s))
 }
 
 // User handlers
 type UserHandler struct {
 	authService *auth.Service
+	prefService *prefs.Service
+	termService *terminal.Service
 	logger      *zap.Logger
 }
 
-func NewUser(authService *auth.Service, logger *zap.Logger) *UserHandler {
+func NewUser(authService *auth.Service, prefService *prefs.Service, termService *terminal.Service, logger *zap.Logger) *UserHandler {
 	return &UserHandler{
 		authService: authService,
+		prefService: prefService,
+		termService: termService,
 		logger:      logger,
 	}
 }
 
+// profile is the user plus their preferences
+type profile struct {
+	*auth.User
+	Timezone string `json:"timezone"`
+	prefs.ShellPrefs
+}
+
+// profile loads the user's preferences
+func (h *UserHandler) profile(c *gin.Context, user *auth.User) (profile, bool) {
+	ctx := c.Request.Context()
+	loc, err := h.prefService.Timezone(ctx, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to load preferences", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load preferences"})
+		return profile{}, false
+	}
+	shell, err := h.prefService.Shell(ctx, user.ID)
+	if err != nil {
+		h.logger.Error("Failed to load preferences", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load preferences"})
+		return profile{}, false
+	}
+	return profile{User: user, Timezone: loc.String(), ShellPrefs: shell}, true
+}
+
 func (h *UserHandler) GetProfile(c *gin.Context) {
 	userID := c.GetString("user_id")
 	
@@ -377,11 +1278,80 @@ func (h *UserHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	if p, ok := h.profile(c, user); ok {
+		c.JSON(http.StatusOK, p)
+	}
 }
 
+// UpdateProfile changes the user's preferences; fields left out are kept.
+// The time zone is used by ?tz=user to format timestamps in API responses.
+// The shell, one of allowed_shells, and login_shell are what the user's
+// sessions start with unless they ask otherwise; an empty shell goes back
+// to the server's default.
 func (h *UserHandler) UpdateProfile(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Update profile not implemented yet"})
+	var req struct {
+		Timezone   *string `json:"timezone"`
+		Shell      *string `json:"shell"`
+		LoginShell *bool   `json:"login_shell"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Timezone == nil && req.Shell == nil && req.LoginShell == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "timezone, shell or login_shell is required"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	user, err := h.authService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.Shell != nil {
+		// Checked now so a bad choice is not found when a session starts
+		if _, err := h.termService.ResolveShell(*req.Shell); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Timezone != nil {
+		_, err := h.prefService.SetTimezone(ctx, userID, *req.Timezone)
+		if errors.Is(err, prefs.ErrInvalidTimezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			h.logger.Error("Failed to save preferences", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save preferences"})
+			return
+		}
+	}
+	if req.Shell != nil || req.LoginShell != nil {
+		shell, err := h.prefService.Shell(ctx, userID)
+		if err == nil {
+			if req.Shell != nil {
+				shell.Shell = *req.Shell
+			}
+			if req.LoginShell != nil {
+				shell.Login = req.LoginShell
+			}
+			err = h.prefService.SetShell(ctx, userID, shell)
+		}
+		if err != nil {
+			h.logger.Error("Failed to save preferences", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save preferences"})
+			return
+		}
+	}
+
+	if p, ok := h.profile(c, user); ok {
+		c.JSON(http.StatusOK, p)
+	}
 }
 
 // WebSocket upgrader
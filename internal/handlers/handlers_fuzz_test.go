@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/checksum"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/meter"
+	"go.uber.org/zap"
+)
+
+// prefixAuthorizer allows paths under root, as a policy path rule does
+type prefixAuthorizer struct{ root string }
+
+func (a prefixAuthorizer) Authorize(_ context.Context, _, _ string, resource map[string]interface{}) (bool, string) {
+	path, _ := resource["path"].(string)
+	return strings.HasPrefix(path, a.root+"/"), "outside " + a.root
+}
+
+// FuzzFilePath checks that no path under a directory policy allows reaches
+// a file outside it: cleanPath's result has no .. element and is already
+// clean, and downloading it never serves the file next to the directory
+func FuzzFilePath(f *testing.F) {
+	for _, seed := range []string{"inside", "./inside", "../secret", "..", "a/../../secret", "%2e%2e/secret", "/../secret", ".//..//secret", "inside\x00", "..\\secret", "...", "a..b"} {
+		f.Add(seed)
+	}
+
+	gin.SetMode(gin.TestMode)
+	dir := f.TempDir()
+	jail := filepath.Join(dir, "jail")
+	require.NoError(f, os.Mkdir(jail, 0o755))
+	require.NoError(f, os.WriteFile(filepath.Join(jail, "inside"), []byte("inside"), 0o644))
+	require.NoError(f, os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644))
+
+	handler := NewFile(prefixAuthorizer{root: jail}, meter.New(config.BandwidthConfig{}, nil, zap.NewNop()),
+		checksum.New(nil, zap.NewNop()), nil, nil, zap.NewNop())
+	router := gin.New()
+	router.GET("/download", handler.Download)
+
+	f.Fuzz(func(t *testing.T, rest string) {
+		path, ok := cleanPath(jail + "/" + rest)
+		if ok {
+			assert.Equal(t, filepath.Clean(path), path)
+			assert.NotContains(t, strings.Split(path, "/"), "..")
+			assert.NotContains(t, path, "\x00")
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/download?path="+url.QueryEscape(jail+"/"+rest), nil))
+		if w.Code == http.StatusOK {
+			assert.Equal(t, "inside", w.Body.String(), "%q", rest)
+		}
+	})
+}
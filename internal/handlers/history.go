@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+)
+
+// History lists the caller's recorded sessions, including ended ones with
+// their final status and exit code
+func (h *SessionHandler) History(c *gin.Context) {
+	records, err := h.termService.History(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, terminal.ErrNoSessionStore) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": records})
+}
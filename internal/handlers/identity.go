@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/identity"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Identity handlers
+type IdentityHandler struct {
+	termService     *terminal.Service
+	identityService *identity.Service
+	auditService    *audit.Service
+	logger          *zap.Logger
+}
+
+func NewIdentity(termService *terminal.Service, identityService *identity.Service, auditService *audit.Service, logger *zap.Logger) *IdentityHandler {
+	return &IdentityHandler{
+		termService:     termService,
+		identityService: identityService,
+		auditService:    auditService,
+		logger:          logger,
+	}
+}
+
+// Issue exchanges the caller's token for an assertion that they drive a
+// session they may write to, for one allowed audience
+func (h *IdentityHandler) Issue(c *gin.Context) {
+	userID := c.GetString("user_id")
+	session, err := h.termService.CheckAccess(c.Param("id"), userID, terminal.AccessWrite)
+	if err != nil {
+		if errors.Is(err, terminal.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Read-only access to this session"})
+		} else {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		}
+		return
+	}
+
+	var req struct {
+		Audience string `json:"audience" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	assertion, err := h.identityService.Issue(userID, session.ID, session.UserID, req.Audience)
+	switch {
+	case errors.Is(err, identity.ErrDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, identity.ErrAudience):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.logger.Error("Failed to issue identity assertion", zap.String("session_id", session.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue identity assertion"})
+		return
+	}
+
+	if h.auditService != nil {
+		entry := &audit.Entry{
+			ActorID:      userID,
+			Action:       audit.ActionIdentityIssue,
+			ResourceType: "session",
+			ResourceID:   session.ID,
+			Details: map[string]interface{}{
+				"audience":   assertion.Audience,
+				"expires_at": assertion.ExpiresAt,
+			},
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}
+		if err := h.auditService.Record(context.Background(), entry); err != nil {
+			h.logger.Error("Failed to record identity assertion", zap.Error(err))
+		}
+	}
+
+	c.JSON(http.StatusCreated, assertion)
+}
+
+// JWKS serves the public keys that verify assertions
+func (h *IdentityHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.identityService.JWKS())
+}
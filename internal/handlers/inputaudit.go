@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/inputaudit"
+	"go.uber.org/zap"
+)
+
+// Input audit handlers
+type InputAuditHandler struct {
+	inputAudit   *inputaudit.Service
+	auditService *audit.Service
+	logger       *zap.Logger
+}
+
+func NewInputAudit(inputAudit *inputaudit.Service, auditService *audit.Service, logger *zap.Logger) *InputAuditHandler {
+	return &InputAuditHandler{
+		inputAudit:   inputAudit,
+		auditService: auditService,
+		logger:       logger,
+	}
+}
+
+// Session returns what was typed into a session and by whom, oldest first:
+// raw input and the command lines reconstructed from it, or only one kind
+// with ?kind=. Reading it is itself audited.
+func (h *InputAuditHandler) Session(c *gin.Context) {
+	if !h.inputAudit.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Input auditing is not enabled"})
+		return
+	}
+
+	filter := inputaudit.Filter{Kind: c.Query("kind")}
+	var err error
+	if since := c.Query("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since timestamp"})
+			return
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until timestamp"})
+			return
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+	switch filter.Kind {
+	case "", inputaudit.KindInput, inputaudit.KindCommand:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be input or command"})
+		return
+	}
+	if filter.Limit < 0 || filter.Limit > inputaudit.MaxQueryLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+
+	sessionID := c.Param("id")
+	records, err := h.inputAudit.Query(c.Request.Context(), sessionID, filter)
+	if err != nil {
+		h.logger.Error("Failed to query input audit", zap.String("session_id", sessionID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query input audit"})
+		return
+	}
+
+	entry := &audit.Entry{
+		ActorID:      c.GetString("user_id"),
+		Action:       audit.ActionInputAuditRead,
+		ResourceType: "session",
+		ResourceID:   sessionID,
+		Details:      map[string]interface{}{"kind": filter.Kind, "records": len(records)},
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := h.auditService.Record(c.Request.Context(), entry); err != nil {
+		h.logger.Error("Failed to record input audit read", zap.Error(err))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "records": records})
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// PollAttach attaches a long-polling client, the fallback for networks
+// that block WebSockets
+func (h *SessionHandler) PollAttach(c *gin.Context) {
+	session, ok := h.authorize(c, terminal.AccessRead)
+	if !ok || !h.checkAttachable(c, session) {
+		return
+	}
+	opts, ok := h.attachOptions(c)
+	if !ok {
+		return
+	}
+	negotiation, ok := negotiate(c, h.termService, session.ID, c.GetString("user_id"), "long-poll")
+	if !ok {
+		return
+	}
+
+	clientID, err := h.termService.AttachLongPollWith(c.Param("id"), c.GetString("user_id"), opts)
+	if err != nil {
+		h.attachFailed(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"client_id": clientID, "cursor": 0, "protocol": negotiation.Version})
+}
+
+// Poll waits for session messages after the given cursor
+func (h *SessionHandler) Poll(c *gin.Context) {
+	cursor, err := strconv.ParseUint(c.DefaultQuery("cursor", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	var wait time.Duration
+	if timeout := c.Query("timeout"); timeout != "" {
+		if wait, err = time.ParseDuration(timeout); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timeout"})
+			return
+		}
+	}
+
+	// Polls are held open longer than the server's write timeout allows
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(time.Minute))
+
+	result, err := h.termService.Poll(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("client"), cursor, wait)
+	if err != nil {
+		h.pollError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, result)
+}
+
+// PollSend accepts client messages, either one message or
+// {"messages": [...]} with several in order
+func (h *SessionHandler) PollSend(c *gin.Context) {
+	var req struct {
+		Messages []json.RawMessage `json:"messages"`
+		Type     string            `json:"type"`
+	}
+	body, err := c.GetRawData()
+	if err != nil || json.Unmarshal(body, &req) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message"})
+		return
+	}
+	messages := req.Messages
+	if req.Type != "" {
+		messages = []json.RawMessage{body}
+	}
+	if len(messages) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No messages"})
+		return
+	}
+
+	if err := h.termService.PostMessages(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("client"), messages); err != nil {
+		h.pollError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accepted": len(messages)})
+}
+
+// PollDetach disconnects a long-polling client
+func (h *SessionHandler) PollDetach(c *gin.Context) {
+	if err := h.termService.DetachLongPoll(c.Param("id"), c.GetString("user_id"), c.Param("client")); err != nil {
+		h.pollError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Detached"})
+}
+
+func (h *SessionHandler) pollError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, terminal.ErrPollClientNotFound):
+		// The client reattaches on 404, e.g. after idling out
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, terminal.ErrMessageTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		h.logger.Debug("Long-poll request failed", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/maintenance"
+	"go.uber.org/zap"
+)
+
+// Maintenance handlers
+type MaintenanceHandler struct {
+	maintenanceService *maintenance.Service
+	logger             *zap.Logger
+}
+
+func NewMaintenance(maintenanceService *maintenance.Service, logger *zap.Logger) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		maintenanceService: maintenanceService,
+		logger:             logger,
+	}
+}
+
+// Status reports the open window, if any, and the next scheduled one
+func (h *MaintenanceHandler) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, h.maintenanceService.Status(time.Now()))
+}
+
+// Override lets sessions be created during maintenance for a while
+func (h *MaintenanceHandler) Override(c *gin.Context) {
+	var req struct {
+		Duration string `json:"duration" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration"})
+		return
+	}
+
+	h.maintenanceService.Override(time.Now().Add(duration), c.GetString("user_id"))
+	c.JSON(http.StatusOK, h.maintenanceService.Status(time.Now()))
+}
+
+func (h *MaintenanceHandler) ClearOverride(c *gin.Context) {
+	h.maintenanceService.ClearOverride()
+	h.logger.Info("Maintenance override cleared", zap.String("by", c.GetString("user_id")))
+	c.JSON(http.StatusOK, h.maintenanceService.Status(time.Now()))
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// MetricsWriter renders counters in the Prometheus text format
+type MetricsWriter interface {
+	WriteMetrics(w io.Writer) error
+}
+
+// OpenMetricsWriter is implemented by writers that can add exemplars when
+// the scraper accepts the OpenMetrics format
+type OpenMetricsWriter interface {
+	WriteOpenMetrics(w io.Writer) error
+}
+
+// Metrics handlers
+type MetricsHandler struct {
+	writers []MetricsWriter
+	logger  *zap.Logger
+}
+
+func NewMetrics(logger *zap.Logger, writers ...MetricsWriter) *MetricsHandler {
+	return &MetricsHandler{
+		writers: writers,
+		logger:  logger,
+	}
+}
+
+func (h *MetricsHandler) Serve(c *gin.Context) {
+	openMetrics := strings.Contains(c.GetHeader("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		c.Header("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "text/plain; version=0.0.4")
+	}
+	c.Status(http.StatusOK)
+	for _, writer := range h.writers {
+		var err error
+		if om, ok := writer.(OpenMetricsWriter); ok && openMetrics {
+			err = om.WriteOpenMetrics(c.Writer)
+		} else {
+			err = writer.WriteMetrics(c.Writer)
+		}
+		if err != nil {
+			h.logger.Error("Failed to write metrics", zap.Error(err))
+			return
+		}
+	}
+	if openMetrics {
+		io.WriteString(c.Writer, "# EOF\n")
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/push"
+	"go.uber.org/zap"
+)
+
+// Push notification handlers
+type PushHandler struct {
+	pushService *push.Service
+	logger      *zap.Logger
+}
+
+func NewPush(pushService *push.Service, logger *zap.Logger) *PushHandler {
+	return &PushHandler{
+		pushService: pushService,
+		logger:      logger,
+	}
+}
+
+// PublicKey returns the VAPID key for PushManager.subscribe
+func (h *PushHandler) PublicKey(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"public_key": h.pushService.PublicKey()})
+}
+
+func (h *PushHandler) Subscribe(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var sub push.Subscription
+	if err := c.ShouldBindJSON(&sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.pushService.Subscribe(c.Request.Context(), userID, &sub); err != nil {
+		h.logger.Warn("Push subscription rejected", zap.Error(err), zap.String("user_id", userID))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Subscribed"})
+}
+
+func (h *PushHandler) Unsubscribe(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Endpoint string `json:"endpoint" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.pushService.Unsubscribe(c.Request.Context(), userID, req.Endpoint); err != nil {
+		h.logger.Error("Failed to unsubscribe", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsubscribe"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed"})
+}
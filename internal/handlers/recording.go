@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+)
+
+// Recording downloads the session's asciicast v2 recording. While the
+// session runs this is the cast so far.
+func (h *SessionHandler) Recording(c *gin.Context) {
+	if _, ok := h.authorize(c, terminal.AccessRead); !ok {
+		return
+	}
+
+	path, err := h.termService.Recording(c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, terminal.ErrSessionNotFound), errors.Is(err, terminal.ErrNoRecording):
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-asciicast")
+	c.FileAttachment(path, c.Param("id")+".cast")
+}
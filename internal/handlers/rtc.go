@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/rtc"
+	"go.uber.org/zap"
+)
+
+// WebRTC handlers
+type RTCHandler struct {
+	rtcService *rtc.Service
+	logger     *zap.Logger
+}
+
+func NewRTC(rtcService *rtc.Service, logger *zap.Logger) *RTCHandler {
+	return &RTCHandler{
+		rtcService: rtcService,
+		logger:     logger,
+	}
+}
+
+// Config returns ICE servers with TURN credentials for the caller. The
+// credentials are per user and expire, so the response is never cached.
+func (h *RTCHandler) Config(c *gin.Context) {
+	conf, err := h.rtcService.Configuration(c.GetString("user_id"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, conf)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/scheduler"
+	"go.uber.org/zap"
+)
+
+// Schedule handlers. Admins see every schedule; other users only the ones
+// that run as them.
+type ScheduleHandler struct {
+	scheduler *scheduler.Service
+	roles     func(userID string) (string, error)
+	logger    *zap.Logger
+}
+
+func NewSchedule(schedulerService *scheduler.Service, roles func(userID string) (string, error), logger *zap.Logger) *ScheduleHandler {
+	return &ScheduleHandler{
+		scheduler: schedulerService,
+		roles:     roles,
+		logger:    logger,
+	}
+}
+
+func (h *ScheduleHandler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"schedules": h.scheduler.List(c.Request.Context(), h.visibleTo(c))})
+}
+
+// Runs lists a schedule's runs with their output, newest first
+func (h *ScheduleHandler) Runs(c *gin.Context) {
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	schedule, err := h.scheduler.Get(c.Request.Context(), c.Param("id"))
+	if err == nil {
+		if userID := h.visibleTo(c); userID != "" && schedule.UserID != userID {
+			err = scheduler.ErrNotFound
+		}
+	}
+	if errors.Is(err, scheduler.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	runs, err := h.scheduler.Runs(c.Request.Context(), c.Param("id"), limit)
+	if err != nil {
+		h.logger.Error("Failed to list scheduled runs", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list scheduled runs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedule": schedule, "runs": runs})
+}
+
+// visibleTo returns the user whose schedules the caller sees, or "" for an
+// admin, who sees all of them
+func (h *ScheduleHandler) visibleTo(c *gin.Context) string {
+	userID := c.GetString("user_id")
+	if role, err := h.roles(userID); err == nil && role == "admin" {
+		return ""
+	}
+	return userID
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/serviceaccounts"
+	"go.uber.org/zap"
+)
+
+// Service account handlers
+type ServiceAccountHandler struct {
+	accountService *serviceaccounts.Service
+	auditService   *audit.Service
+	logger         *zap.Logger
+}
+
+func NewServiceAccount(accountService *serviceaccounts.Service, auditService *audit.Service, logger *zap.Logger) *ServiceAccountHandler {
+	return &ServiceAccountHandler{
+		accountService: accountService,
+		auditService:   auditService,
+		logger:         logger,
+	}
+}
+
+// Create adds a service account and returns its API key. This is the only
+// time the key is shown.
+func (h *ServiceAccountHandler) Create(c *gin.Context) {
+	var req struct {
+		Name              string   `json:"name" binding:"required"`
+		OwnerID           string   `json:"owner_id"`
+		Scopes            []string `json:"scopes" binding:"required"`
+		MaxSessions       int      `json:"max_sessions"`
+		RequestsPerMinute int      `json:"requests_per_minute"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, err := h.accountService.Create(c.Request.Context(), c.GetString("user_id"), serviceaccounts.Request{
+		Name:              req.Name,
+		OwnerID:           req.OwnerID,
+		Scopes:            req.Scopes,
+		MaxSessions:       req.MaxSessions,
+		RequestsPerMinute: req.RequestsPerMinute,
+	})
+	switch {
+	case errors.Is(err, serviceaccounts.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, serviceaccounts.ErrNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.logger.Error("Failed to create service account", zap.String("name", req.Name), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+
+	h.record(c, audit.ActionAccountCreate, account.ID, map[string]interface{}{
+		"name":                account.Name,
+		"owner_id":            account.OwnerID,
+		"scopes":              account.Scopes,
+		"max_sessions":        account.MaxSessions,
+		"requests_per_minute": account.RequestsPerMinute,
+	})
+	c.JSON(http.StatusCreated, account)
+}
+
+// List returns every service account, without keys
+func (h *ServiceAccountHandler) List(c *gin.Context) {
+	accounts, err := h.accountService.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list service accounts", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list service accounts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"service_accounts": accounts})
+}
+
+// RotateKey issues a new API key and revokes the old one
+func (h *ServiceAccountHandler) RotateKey(c *gin.Context) {
+	id := c.Param("id")
+	account, err := h.accountService.RotateKey(c.Request.Context(), id)
+	if errors.Is(err, serviceaccounts.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to rotate service account key", zap.String("account_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate API key"})
+		return
+	}
+
+	h.record(c, audit.ActionAccountRotate, id, nil)
+	c.JSON(http.StatusOK, account)
+}
+
+// Delete removes a service account and kills its sessions
+func (h *ServiceAccountHandler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	err := h.accountService.Delete(c.Request.Context(), id)
+	if errors.Is(err, serviceaccounts.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to delete service account", zap.String("account_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service account"})
+		return
+	}
+
+	h.record(c, audit.ActionAccountDelete, id, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Service account deleted"})
+}
+
+func (h *ServiceAccountHandler) record(c *gin.Context, action, accountID string, details map[string]interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	entry := &audit.Entry{
+		ActorID:      c.GetString("user_id"),
+		Action:       action,
+		ResourceType: "service_account",
+		ResourceID:   accountID,
+		Details:      details,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := h.auditService.Record(context.Background(), entry); err != nil {
+		h.logger.Error("Failed to record service account change", zap.Error(err))
+	}
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/internal/services/branding"
+	"github.com/yourusername/webtunnel/internal/services/shares"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// guestPrefix marks the user IDs of guests attached with a share link
+const guestPrefix = "share:"
+
+// Share handlers
+type ShareHandler struct {
+	termService     *terminal.Service
+	shareService    *shares.Service
+	brandingService *branding.Service
+	logger          *zap.Logger
+}
+
+func NewShare(termService *terminal.Service, shareService *shares.Service, brandingService *branding.Service, logger *zap.Logger) *ShareHandler {
+	return &ShareHandler{
+		termService:     termService,
+		shareService:    shareService,
+		brandingService: brandingService,
+		logger:          logger,
+	}
+}
+
+// Create issues a link to one of the caller's sessions. ?mode= is what
+// the link grants: snapshot (the default) renders the current screen as a
+// page, read-only also lets its holder watch live and read-write lets them
+// type and resize as well.
+func (h *ShareHandler) Create(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, ok := h.ownSession(c, sessionID); !ok {
+		return
+	}
+
+	token, share, err := h.shareService.IssueMode(sessionID, c.DefaultQuery("mode", shares.ModeSnapshot))
+	if errors.Is(err, shares.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be snapshot, read-only or read-write"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to issue share link", zap.String("session_id", sessionID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue share link"})
+		return
+	}
+
+	result := gin.H{
+		"id":         share.ID,
+		"share_url":  "https://" + c.Request.Host + "/shared/" + token,
+		"mode":       share.Mode,
+		"expires_at": share.ExpiresAt,
+	}
+	if share.Mode != shares.ModeSnapshot {
+		result["stream_url"] = "wss://" + c.Request.Host + "/shared/" + token
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Revoke stops one link to the caller's session, or with no share ID every
+// link issued so far, and disconnects the guests who came in on them
+func (h *ShareHandler) Revoke(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, ok := h.ownSession(c, sessionID); !ok {
+		return
+	}
+
+	shareID := c.Param("share_id")
+	var err error
+	if shareID == "" {
+		err = h.shareService.RevokeSession(c.Request.Context(), sessionID)
+	} else {
+		err = h.shareService.Revoke(c.Request.Context(), sessionID, shareID)
+	}
+	if err != nil {
+		h.logger.Error("Failed to revoke share links", zap.String("session_id", sessionID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share links"})
+		return
+	}
+
+	disconnected := h.termService.Disconnect(sessionID, "This share link has been revoked", func(userID string) bool {
+		if shareID == "" {
+			return strings.HasPrefix(userID, guestPrefix)
+		}
+		return userID == guestPrefix+shareID
+	})
+	c.JSON(http.StatusOK, gin.H{"revoked": true, "disconnected": disconnected})
+}
+
+// Stream attaches the holder of a live link to the session as a guest,
+// with the access the link grants: read-only guests watch and scroll but
+// never type or resize
+func (h *ShareHandler) Stream(c *gin.Context) {
+	share, err := h.shareService.Verify(c.Param("token"))
+	switch {
+	case errors.Is(err, shares.ErrExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Share link has expired"})
+		return
+	case errors.Is(err, shares.ErrRevoked):
+		c.JSON(http.StatusGone, gin.H{"error": "Share link has been revoked"})
+		return
+	case errors.Is(err, shares.ErrInvalid):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	case err != nil:
+		h.logger.Error("Failed to verify share link", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify share link"})
+		return
+	}
+
+	access := terminal.AccessRead
+	switch share.Mode {
+	case shares.ModeSnapshot:
+		c.JSON(http.StatusForbidden, gin.H{"error": "This share link is a snapshot only"})
+		return
+	case shares.ModeReadWrite:
+		access = terminal.AccessWrite
+	}
+	if _, exists := h.termService.GetSession(share.SessionID); !exists {
+		c.JSON(http.StatusGone, gin.H{"error": "Session has ended"})
+		return
+	}
+	opts, ok := attachViewOptions(c)
+	if !ok {
+		return
+	}
+	negotiation, ok := negotiate(c, h.termService, share.SessionID, guestPrefix+share.ID, "websocket")
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, subprotocolHeader(negotiation))
+	if err != nil {
+		h.logger.Error("Failed to upgrade to WebSocket", zap.Error(err))
+		return
+	}
+
+	// Guests have no account; one link is one guest in logs, metering and
+	// revocation
+	if err := h.termService.AttachWebSocketShared(share.SessionID, guestPrefix+share.ID, access, opts, conn); err != nil {
+		h.logger.Warn("Failed to attach share guest", zap.String("code", terminal.AttachErrorCode(err)), zap.Error(err))
+		conn.Close()
+	}
+}
+
+// ownSession finds a session only its owner may share
+func (h *ShareHandler) ownSession(c *gin.Context, sessionID string) (*terminal.Session, bool) {
+	session, exists := h.termService.GetSession(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return nil, false
+	}
+	if session.UserID != c.GetString("user_id") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the session owner can share it"})
+		return nil, false
+	}
+	return session, true
+}
+
+// Snapshot renders the shared session's current screen as a standalone
+// HTML page with inline styles, so it can be previewed by chat unfurlers
+// and pasted into email without running the web client. WebSocket
+// upgrades attach the guest instead, as Stream.
+func (h *ShareHandler) Snapshot(c *gin.Context) {
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.Stream(c)
+		return
+	}
+
+	share, err := h.shareService.Verify(c.Param("token"))
+	switch {
+	case errors.Is(err, shares.ErrExpired):
+		c.String(http.StatusGone, "This share link has expired.")
+		return
+	case errors.Is(err, shares.ErrRevoked):
+		c.String(http.StatusGone, "This share link has been revoked.")
+		return
+	case errors.Is(err, shares.ErrInvalid):
+		c.String(http.StatusNotFound, "Share link not found.")
+		return
+	case err != nil:
+		h.logger.Error("Failed to verify share link", zap.Error(err))
+		c.String(http.StatusInternalServerError, "Something went wrong.")
+		return
+	}
+
+	session, exists := h.termService.GetSession(share.SessionID)
+	if !exists {
+		c.String(http.StatusGone, "This session has ended.")
+		return
+	}
+	screen, err := h.termService.Screen(share.SessionID)
+	if err != nil {
+		c.String(http.StatusGone, "This session has ended.")
+		return
+	}
+
+	// The page is self-contained: no scripts, no external requests, so
+	// it carries the owner's product name and colors but not their logo
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	c.Header("X-Robots-Tag", "noindex")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	err = sharePage.Execute(c.Writer, gin.H{
+		"Command":     session.Command,
+		"Description": screenSummary(screen.Text()),
+		"Lines":       screen.Lines(),
+		"Foreground":  terminal.DefaultForeground,
+		"Background":  terminal.DefaultBackground,
+		"CapturedAt":  time.Now().UTC(),
+		"ExpiresAt":   share.ExpiresAt.UTC(),
+		"Brand":       h.brandingService.ForUser(session.UserID),
+	})
+	if err != nil {
+		h.logger.Error("Failed to render share snapshot", zap.String("session_id", share.SessionID), zap.Error(err))
+	}
+}
+
+// screenSummary is the last few non-blank lines, which is where the prompt
+// and latest output are, for link previews
+func screenSummary(lines []string) string {
+	var tail []string
+	for i := len(lines) - 1; i >= 0 && len(tail) < 4; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			tail = append([]string{line}, tail...)
+		}
+	}
+	summary := strings.Join(tail, "\n")
+	if runes := []rune(summary); len(runes) > 280 {
+		summary = string(runes[len(runes)-280:])
+	}
+	return summary
+}
+
+var sharePage = template.Must(template.New("share").Funcs(template.FuncMap{
+	// Styles are generated from parsed color numbers, never from output
+	"css": func(s string) template.CSS { return template.CSS(s) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Command}} · {{.Brand.ProductName}}</title>
+<meta property="og:type" content="website">
+<meta property="og:title" content="{{.Command}} · {{.Brand.ProductName}}">
+<meta property="og:site_name" content="{{.Brand.ProductName}}">
+<meta property="og:description" content="{{.Description}}">
+<meta name="twitter:card" content="summary">
+</head>
+<body style="margin:0;padding:16px;background:{{css .Background}}">
+<pre style="margin:0;padding:12px;font-family:Menlo,Consolas,'DejaVu Sans Mono',monospace;font-size:13px;line-height:1.25;color:{{css .Foreground}};background:{{css .Background}};overflow-x:auto">
+{{- range .Lines}}{{range .}}{{with .Style.CSS}}<span style="{{css .}}">{{end}}{{.Text}}{{if .Style.CSS}}</span>{{end}}{{end}}
+{{end -}}
+</pre>
+<p style="font-family:sans-serif;font-size:12px;color:#777"><strong style="color:{{css .Brand.Colors.Primary}}">{{.Brand.ProductName}}</strong> · Read-only snapshot taken {{.CapturedAt.Format "2006-01-02 15:04:05 MST"}} · link expires {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}{{with .Brand.SupportURL}} · <a href="{{.}}" style="color:#777">Support</a>{{end}}</p>
+</body>
+</html>
+`))
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/shed"
+	"go.uber.org/zap"
+)
+
+// Load shedding handlers
+type LoadShedHandler struct {
+	shedService *shed.Service
+	logger      *zap.Logger
+}
+
+func NewLoadShed(shedService *shed.Service, logger *zap.Logger) *LoadShedHandler {
+	return &LoadShedHandler{
+		shedService: shedService,
+		logger:      logger,
+	}
+}
+
+// Status reports memory pressure and how much load is being shed
+func (h *LoadShedHandler) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": h.shedService.Enabled(),
+		"load":    h.shedService.Stats(),
+	})
+}
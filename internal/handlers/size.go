@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Size returns the session's terminal size, the policy that picks it and
+// its recent changes
+func (h *SessionHandler) Size(c *gin.Context) {
+	session, ok := h.authorize(c, terminal.AccessRead)
+	if !ok {
+		return
+	}
+	size, err := h.termService.Size(session.ID)
+	if err != nil {
+		h.sizeFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, size)
+}
+
+// SetResizePolicy changes how the session's size is picked when several
+// clients attach, and with the fixed policy the size to keep
+func (h *SessionHandler) SetResizePolicy(c *gin.Context) {
+	var req struct {
+		Policy string `json:"policy"` // empty for the server default
+		Cols   uint16 `json:"cols"`
+		Rows   uint16 `json:"rows"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	size, err := h.termService.SetResizePolicy(c.Param("id"), c.GetString("user_id"), req.Policy, req.Cols, req.Rows)
+	if err != nil {
+		h.sizeFailed(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, size)
+}
+
+func (h *SessionHandler) sizeFailed(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, terminal.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found", "code": protocol.CodeSessionNotFound})
+	case errors.Is(err, terminal.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": protocol.CodeForbidden})
+	case errors.Is(err, terminal.ErrInvalidResizePolicy):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Failed to resize session", zap.String("session_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/snapshots"
+	"go.uber.org/zap"
+)
+
+// Snapshot handlers
+type SnapshotHandler struct {
+	snapshotService *snapshots.Service
+	logger          *zap.Logger
+}
+
+func NewSnapshot(snapshotService *snapshots.Service, logger *zap.Logger) *SnapshotHandler {
+	return &SnapshotHandler{
+		snapshotService: snapshotService,
+		logger:          logger,
+	}
+}
+
+// List returns the latest version of each snapshot for the session dialog
+func (h *SnapshotHandler) List(c *gin.Context) {
+	result, err := h.snapshotService.List()
+	if err != nil {
+		h.logger.Error("Failed to list snapshots", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list snapshots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"snapshots": result})
+}
+
+// Versions returns the version history of one snapshot
+func (h *SnapshotHandler) Versions(c *gin.Context) {
+	versions, err := h.snapshotService.Versions(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// Create stores the request body, a gzipped tarball, as the next version
+func (h *SnapshotHandler) Create(c *gin.Context) {
+	snapshot, err := h.snapshotService.Create(c.Request.Context(), c.Param("name"),
+		c.Query("description"), c.GetString("user_id"), c.Request.Body)
+	if err != nil {
+		if abortOnContext(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, snapshots.ErrTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		case errors.Is(err, snapshots.ErrDisabled):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, snapshot)
+}
+
+func (h *SnapshotHandler) Delete(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	if err := h.snapshotService.Delete(c.Param("name"), version); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Snapshot deleted"})
+}
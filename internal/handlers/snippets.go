@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/snippets"
+	"go.uber.org/zap"
+)
+
+// Snippet handlers
+type SnippetHandler struct {
+	snippetService *snippets.Service
+	logger         *zap.Logger
+}
+
+func NewSnippet(snippetService *snippets.Service, logger *zap.Logger) *SnippetHandler {
+	return &SnippetHandler{
+		snippetService: snippetService,
+		logger:         logger,
+	}
+}
+
+func (h *SnippetHandler) List(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	result, err := h.snippetService.List(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to list snippets", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list snippets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"snippets": result})
+}
+
+func (h *SnippetHandler) Save(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req struct {
+		Name    string `json:"name" binding:"required"`
+		Content string `json:"content" binding:"required"`
+		Shared  bool   `json:"shared"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	snippet, err := h.snippetService.Save(c.Request.Context(), userID, req.Name, req.Content, req.Shared)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, snippet)
+}
+
+func (h *SnippetHandler) Delete(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.snippetService.Delete(c.Request.Context(), userID, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Snippet deleted"})
+}
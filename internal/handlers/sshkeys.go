@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/sshkeys"
+	"go.uber.org/zap"
+)
+
+// SSH key handlers
+type SSHKeyHandler struct {
+	sshService   *sshkeys.Service
+	auditService *audit.Service
+	logger       *zap.Logger
+}
+
+func NewSSHKey(sshService *sshkeys.Service, auditService *audit.Service, logger *zap.Logger) *SSHKeyHandler {
+	return &SSHKeyHandler{
+		sshService:   sshService,
+		auditService: auditService,
+		logger:       logger,
+	}
+}
+
+// List returns the user's SSH keys; private keys are never returned
+func (h *SSHKeyHandler) List(c *gin.Context) {
+	keys, err := h.sshService.List(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		h.logger.Error("Failed to list SSH keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list SSH keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// Create generates a key pair on the server ({"name", "generate": true,
+// "type": "ed25519"|"rsa"}) or stores an uploaded public or private key
+func (h *SSHKeyHandler) Create(c *gin.Context) {
+	var req struct {
+		Name       string `json:"name" binding:"required"`
+		Generate   bool   `json:"generate"`
+		Type       string `json:"type"`
+		PublicKey  string `json:"public_key"`
+		PrivateKey string `json:"private_key"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	var key *sshkeys.Key
+	var err error
+	switch {
+	case req.Generate:
+		key, err = h.sshService.Generate(c.Request.Context(), userID, req.Name, req.Type)
+	case req.PublicKey != "" || req.PrivateKey != "":
+		key, err = h.sshService.Import(c.Request.Context(), userID, req.Name, req.PublicKey, req.PrivateKey)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set generate, public_key or private_key"})
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, sshkeys.ErrInvalidKey):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, sshkeys.ErrKeyExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to save SSH key", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save SSH key"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// Delete removes one of the user's SSH keys
+func (h *SSHKeyHandler) Delete(c *gin.Context) {
+	if err := h.sshService.Delete(c.Request.Context(), c.GetString("user_id"), c.Param("id")); err != nil {
+		if errors.Is(err, sshkeys.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "SSH key not found"})
+			return
+		}
+		h.logger.Error("Failed to delete SSH key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete SSH key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "SSH key deleted"})
+}
+
+// KnownHosts lists the host keys SSH sessions check against
+func (h *SSHKeyHandler) KnownHosts(c *gin.Context) {
+	hosts, err := h.sshService.KnownHosts(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list known hosts", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list known hosts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"hosts": hosts})
+}
+
+// PinHost sets the key a host must present
+func (h *SSHKeyHandler) PinHost(c *gin.Context) {
+	var req struct {
+		Host      string `json:"host" binding:"required"`
+		PublicKey string `json:"public_key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	host, err := h.sshService.Pin(c.Request.Context(), c.GetString("user_id"), req.Host, req.PublicKey)
+	if err != nil {
+		if errors.Is(err, sshkeys.ErrInvalidKey) || errors.Is(err, sshkeys.ErrInvalidTarget) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to pin host key", zap.String("host", req.Host), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to pin host key"})
+		return
+	}
+
+	h.record(c, audit.ActionHostKeyPin, host.Host, map[string]interface{}{
+		"fingerprint": host.Fingerprint,
+	})
+	c.JSON(http.StatusCreated, host)
+}
+
+// ForgetHost drops a host's key, e.g. after the host was rebuilt
+func (h *SSHKeyHandler) ForgetHost(c *gin.Context) {
+	host := c.Param("host")
+	if err := h.sshService.Forget(c.Request.Context(), host); err != nil {
+		switch {
+		case errors.Is(err, sshkeys.ErrInvalidTarget):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, sshkeys.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Host not known"})
+		default:
+			h.logger.Error("Failed to forget host key", zap.String("host", host), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to forget host key"})
+		}
+		return
+	}
+
+	h.record(c, audit.ActionHostKeyForget, host, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Host key removed"})
+}
+
+func (h *SSHKeyHandler) record(c *gin.Context, action, host string, details map[string]interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	entry := &audit.Entry{
+		ActorID:      c.GetString("user_id"),
+		Action:       action,
+		ResourceType: "ssh_host",
+		ResourceID:   host,
+		Details:      details,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := h.auditService.Record(context.Background(), entry); err != nil {
+		h.logger.Error("Failed to record host key change", zap.Error(err))
+	}
+}
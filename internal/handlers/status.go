@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/status"
+	"go.uber.org/zap"
+)
+
+// Status page handlers
+type StatusHandler struct {
+	statusService *status.Service
+	logger        *zap.Logger
+}
+
+func NewStatus(statusService *status.Service, logger *zap.Logger) *StatusHandler {
+	return &StatusHandler{
+		statusService: statusService,
+		logger:        logger,
+	}
+}
+
+// Page serves the public status summary, as HTML for browsers and JSON
+// otherwise. It needs no authentication and is safe to cache.
+func (h *StatusHandler) Page(c *gin.Context) {
+	summary := h.statusService.Summary(c.Request.Context())
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.statusService.CacheTTL().Seconds())))
+	c.Header("ETag", summary.ETag)
+	c.Header("Vary", "Accept")
+	if c.GetHeader("If-None-Match") == summary.ETag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	if strings.HasSuffix(c.Request.URL.Path, ".json") || !strings.Contains(c.GetHeader("Accept"), "text/html") {
+		c.JSON(http.StatusOK, summary)
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := statusPage.Execute(c.Writer, summary); err != nil {
+		h.logger.Error("Failed to render status page", zap.Error(err))
+	}
+}
+
+// SetIncident publishes an incident message on the status page
+func (h *StatusHandler) SetIncident(c *gin.Context) {
+	var req struct {
+		Message  string `json:"message" binding:"required"`
+		Severity string `json:"severity"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	incident, err := h.statusService.SetIncident(req.Message, req.Severity, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, incident)
+}
+
+// ClearIncident removes the incident message
+func (h *StatusHandler) ClearIncident(c *gin.Context) {
+	h.statusService.ClearIncident(c.GetString("user_id"))
+	c.JSON(http.StatusOK, gin.H{"message": "Incident cleared"})
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; background: #1e1e1e; color: #d4d4d4; max-width: 640px; margin: 40px auto; padding: 0 16px; }
+h1 { font-size: 1.4em; }
+.banner { padding: 14px 16px; border-radius: 6px; font-weight: 600; margin-bottom: 20px; }
+.incident { padding: 12px 16px; border-left: 4px solid #d7ba7d; background: #2d2d30; margin-bottom: 20px; }
+ul { list-style: none; padding: 0; }
+li { display: flex; justify-content: space-between; padding: 10px 0; border-bottom: 1px solid #333; }
+.detail { color: #999; font-size: 0.9em; }
+.operational { color: #4ec9b0; } .maintenance { color: #569cd6; } .degraded { color: #d7ba7d; } .outage { color: #f44747; }
+.banner.operational { background: #1e3a32; } .banner.maintenance { background: #1e2d3a; } .banner.degraded { background: #3a341e; } .banner.outage { background: #3a1e1e; }
+footer { color: #777; font-size: 0.8em; margin-top: 20px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="banner {{.State}}">{{if eq .State "operational"}}All systems operational{{else if eq .State "maintenance"}}Scheduled maintenance in progress{{else if eq .State "degraded"}}Some systems are degraded{{else}}Service disruption{{end}}</div>
+{{with .Incident}}<div class="incident"><strong>{{if eq .Severity "major"}}Major incident{{else}}Incident{{end}}</strong> &middot; since {{.Since.UTC.Format "Jan 2 15:04 MST"}}<p>{{.Message}}</p></div>{{end}}
+<ul>
+{{range .Components}}<li><span>{{.Name}}{{with .Detail}}<br><span class="detail">{{.}}</span>{{end}}</span><span class="{{.State}}">{{.State}}</span></li>
+{{end}}</ul>
+<footer>Last checked {{.CheckedAt.UTC.Format "2006-01-02 15:04:05 MST"}}</footer>
+</body>
+</html>
+`))
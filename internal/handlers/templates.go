@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/templates"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Template handlers
+type TemplateHandler struct {
+	templateService *templates.Service
+	auditService    *audit.Service
+	logger          *zap.Logger
+}
+
+func NewTemplate(templateService *templates.Service, auditService *audit.Service, logger *zap.Logger) *TemplateHandler {
+	return &TemplateHandler{
+		templateService: templateService,
+		auditService:    auditService,
+		logger:          logger,
+	}
+}
+
+// templateRequest is the body of a template create or update
+type templateRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Description string            `json:"description"`
+	Command     string            `json:"command"`
+	WorkingDir  string            `json:"working_dir"`
+	Env         map[string]string `json:"env"`
+	Shell       string            `json:"shell"`
+	Limits      terminal.Limits   `json:"limits"`
+}
+
+func (r templateRequest) template() templates.Template {
+	return templates.Template{
+		Name:        r.Name,
+		Description: r.Description,
+		Command:     r.Command,
+		WorkingDir:  r.WorkingDir,
+		Env:         r.Env,
+		Shell:       r.Shell,
+		Limits:      r.Limits,
+	}
+}
+
+// List returns the templates users can start sessions from
+func (h *TemplateHandler) List(c *gin.Context) {
+	result, err := h.templateService.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list session templates", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list session templates"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": result})
+}
+
+// Create defines a new template
+func (h *TemplateHandler) Create(c *gin.Context) {
+	var req templateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, err := h.templateService.Create(c.Request.Context(), c.GetString("user_id"), req.template())
+	if !h.ok(c, err, "create") {
+		return
+	}
+	h.record(c, audit.ActionTemplateCreate, t)
+	c.JSON(http.StatusCreated, t)
+}
+
+// Update replaces a template; sessions started from it keep their settings
+func (h *TemplateHandler) Update(c *gin.Context) {
+	var req templateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	t, err := h.templateService.Update(c.Request.Context(), c.Param("id"), req.template())
+	if !h.ok(c, err, "update") {
+		return
+	}
+	h.record(c, audit.ActionTemplateUpdate, t)
+	c.JSON(http.StatusOK, t)
+}
+
+// Delete removes a template; sessions started from it keep running
+func (h *TemplateHandler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	if !h.ok(c, h.templateService.Delete(c.Request.Context(), id), "delete") {
+		return
+	}
+	h.record(c, audit.ActionTemplateDelete, &templates.Template{ID: id})
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted"})
+}
+
+// ok writes the error response for a failed change, if it failed
+func (h *TemplateHandler) ok(c *gin.Context, err error, verb string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, templates.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, templates.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+	case errors.Is(err, templates.ErrExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("Failed to "+verb+" session template", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + verb + " session template"})
+	}
+	return false
+}
+
+func (h *TemplateHandler) record(c *gin.Context, action string, t *templates.Template) {
+	if h.auditService == nil {
+		return
+	}
+	var details map[string]interface{}
+	if t.Name != "" {
+		details = map[string]interface{}{
+			"name":    t.Name,
+			"command": t.Command,
+			"shell":   t.Shell,
+			"limits":  t.Limits,
+		}
+	}
+	entry := &audit.Entry{
+		ActorID:      c.GetString("user_id"),
+		Action:       action,
+		ResourceType: "template",
+		ResourceID:   t.ID,
+		Details:      details,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := h.auditService.Record(context.Background(), entry); err != nil {
+		h.logger.Error("Failed to record template change", zap.Error(err))
+	}
+}
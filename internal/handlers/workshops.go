@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/workshop"
+	"go.uber.org/zap"
+)
+
+// Workshop handlers
+type WorkshopHandler struct {
+	workshopService *workshop.Service
+	auditService    *audit.Service
+	logger          *zap.Logger
+}
+
+func NewWorkshop(workshopService *workshop.Service, auditService *audit.Service, logger *zap.Logger) *WorkshopHandler {
+	return &WorkshopHandler{
+		workshopService: workshopService,
+		auditService:    auditService,
+		logger:          logger,
+	}
+}
+
+// Create provisions a workshop's users, count generated ones or one per CSV
+// row, and returns their credentials and login links. This is the only time
+// the passwords and tokens are shown.
+func (h *WorkshopHandler) Create(c *gin.Context) {
+	var req struct {
+		Name   string    `json:"name" binding:"required"`
+		Count  int       `json:"count"`
+		CSV    string    `json:"csv"`
+		Preset string    `json:"preset"`
+		EndsAt time.Time `json:"ends_at" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	w, err := h.workshopService.Provision(c.Request.Context(), c.GetString("user_id"), workshop.Request{
+		Name:   req.Name,
+		Count:  req.Count,
+		CSV:    req.CSV,
+		Preset: req.Preset,
+		EndsAt: req.EndsAt,
+	})
+	switch {
+	case errors.Is(err, workshop.ErrInvalid), errors.Is(err, workshop.ErrUnknownPreset):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, workshop.ErrLoginTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.logger.Error("Failed to provision workshop", zap.String("name", req.Name), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision workshop: " + err.Error()})
+		return
+	}
+
+	// The token rides in the fragment, which browsers never send to the
+	// server, so links do not end up in access logs
+	users := make([]gin.H, len(w.Users))
+	for i, u := range w.Users {
+		users[i] = gin.H{
+			"id":         u.ID,
+			"username":   u.Username,
+			"email":      u.Email,
+			"password":   u.Password,
+			"session_id": u.SessionID,
+			"link":       "https://" + c.Request.Host + "/#token=" + u.Token,
+		}
+	}
+
+	h.record(c, audit.ActionWorkshopCreate, w.ID, map[string]interface{}{
+		"name":    w.Name,
+		"users":   len(w.Users),
+		"preset":  w.Preset,
+		"ends_at": w.EndsAt,
+	})
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      w.ID,
+		"name":    w.Name,
+		"preset":  w.Preset,
+		"role":    w.Role,
+		"ends_at": w.EndsAt,
+		"users":   users,
+	})
+}
+
+// List returns the running workshops and their users
+func (h *WorkshopHandler) List(c *gin.Context) {
+	workshops, err := h.workshopService.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list workshops", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list workshops"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"workshops": workshops})
+}
+
+// Get returns one workshop and its users
+func (h *WorkshopHandler) Get(c *gin.Context) {
+	w, err := h.workshopService.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, workshop.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workshop not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load workshop", zap.String("workshop_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load workshop"})
+		return
+	}
+	c.JSON(http.StatusOK, w)
+}
+
+// End tears a workshop down before its end time
+func (h *WorkshopHandler) End(c *gin.Context) {
+	id := c.Param("id")
+	err := h.workshopService.End(c.Request.Context(), id)
+	if errors.Is(err, workshop.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workshop not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to end workshop", zap.String("workshop_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end workshop"})
+		return
+	}
+
+	h.record(c, audit.ActionWorkshopEnd, id, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Workshop ended"})
+}
+
+func (h *WorkshopHandler) record(c *gin.Context, action, workshopID string, details map[string]interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	entry := &audit.Entry{
+		ActorID:      c.GetString("user_id"),
+		Action:       action,
+		ResourceType: "workshop",
+		ResourceID:   workshopID,
+		Details:      details,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := h.auditService.Record(context.Background(), entry); err != nil {
+		h.logger.Error("Failed to record workshop change", zap.Error(err))
+	}
+}
@@ -0,0 +1,234 @@
+// Package kv is a small embedded key-value store for running without
+// Postgres or Redis, as local mode does. Services that otherwise keep state
+// only in memory persist it here instead, so it survives a restart.
+package kv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// Store holds values by bucket and key. Buckets keep services apart; each
+// names its own.
+type Store interface {
+	// Get returns ErrNotFound for a missing key
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	// Delete succeeds for a missing key
+	Delete(bucket, key string) error
+	// List returns every key in the bucket with its value
+	List(bucket string) (map[string][]byte, error)
+}
+
+// record is one line of the file, a put or a delete
+type record struct {
+	Bucket  string `json:"b"`
+	Key     string `json:"k"`
+	Value   []byte `json:"v,omitempty"`
+	Deleted bool   `json:"d,omitempty"`
+}
+
+// minCompact is how many superseded records the file may hold before it
+// is rewritten
+const minCompact = 1000
+
+// File is a Store kept in one file as a log of JSON lines, replayed on
+// open. Every change is appended and synced before it returns; once the
+// log holds more superseded records than live ones, it is rewritten with
+// just the live ones.
+type File struct {
+	path    string
+	file    *os.File
+	data    map[string]map[string][]byte
+	records int // lines in the file
+	live    int // keys across all buckets
+	mu      sync.Mutex
+}
+
+var _ Store = (*File)(nil)
+
+// Open opens the store at path, creating it and its directory if needed.
+// A torn last line, from a crash mid-write, is dropped.
+func Open(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+
+	f := &File{path: path, file: file, data: make(map[string]map[string][]byte)}
+	var good int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A line without its newline never finished writing
+			break
+		}
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("corrupt store %s at byte %d: %w", path, good, err)
+		}
+		f.apply(r)
+		f.records++
+		good += int64(len(line))
+	}
+	if err := file.Truncate(good); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	if _, err := file.Seek(good, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	return f, nil
+}
+
+// apply changes the in-memory copy. f.mu must be held.
+func (f *File) apply(r record) {
+	bucket := f.data[r.Bucket]
+	_, exists := bucket[r.Key]
+	if r.Deleted {
+		if exists {
+			delete(bucket, r.Key)
+			f.live--
+		}
+		return
+	}
+	if bucket == nil {
+		bucket = make(map[string][]byte)
+		f.data[r.Bucket] = bucket
+	}
+	bucket[r.Key] = r.Value
+	if !exists {
+		f.live++
+	}
+}
+
+func (f *File) Get(bucket, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.data[bucket][key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, bucket, key)
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (f *File) Put(bucket, key string, value []byte) error {
+	return f.write(record{Bucket: bucket, Key: key, Value: append([]byte{}, value...)})
+}
+
+func (f *File) Delete(bucket, key string) error {
+	f.mu.Lock()
+	_, exists := f.data[bucket][key]
+	f.mu.Unlock()
+	if !exists {
+		return nil
+	}
+	return f.write(record{Bucket: bucket, Key: key, Deleted: true})
+}
+
+func (f *File) List(bucket string) (map[string][]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(map[string][]byte, len(f.data[bucket]))
+	for key, value := range f.data[bucket] {
+		result[key] = append([]byte{}, value...)
+	}
+	return result, nil
+}
+
+// write appends the record, syncs it and only then applies it
+func (f *File) write(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return fmt.Errorf("store %s is closed", f.path)
+	}
+	if _, err := f.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write store: %w", err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync store: %w", err)
+	}
+	f.apply(r)
+	f.records++
+	if f.records-f.live > max(f.live, minCompact) {
+		if err := f.compact(); err != nil {
+			// The log is still complete, just longer than it needs to be
+			return nil
+		}
+	}
+	return nil
+}
+
+// compact rewrites the file with only the live records and swaps it in.
+// f.mu must be held.
+func (f *File) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	buckets := make([]string, 0, len(f.data))
+	for bucket := range f.data {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		for key, value := range f.data[bucket] {
+			if err := encoder.Encode(record{Bucket: bucket, Key: key, Value: value}); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		tmp.Close()
+		return err
+	}
+	f.file.Close()
+	f.file, f.records = tmp, f.live
+	return nil
+}
+
+// Close closes the file; the store cannot be used afterwards
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
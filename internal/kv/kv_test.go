@@ -0,0 +1,77 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "local.kv")
+	store, err := Open(path)
+	require.NoError(t, err)
+
+	_, err = store.Get("prefs", "alice")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, store.Put("prefs", "alice", []byte("Europe/Berlin")))
+	require.NoError(t, store.Put("prefs", "bob", []byte("UTC")))
+	require.NoError(t, store.Put("shares", "alice", []byte{}))
+	require.NoError(t, store.Delete("prefs", "bob"))
+	require.NoError(t, store.Delete("prefs", "nobody"))
+
+	value, err := store.Get("prefs", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Berlin", string(value))
+	require.NoError(t, store.Close())
+	assert.Error(t, store.Put("prefs", "carol", []byte("UTC")), "closed")
+
+	// Everything is there after reopening, and a torn write is dropped
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"b":"prefs","k":"carol","v":`)
+	require.NoError(t, err)
+	file.Close()
+
+	store, err = Open(path)
+	require.NoError(t, err)
+	defer store.Close()
+	prefs, err := store.List("prefs")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"alice": []byte("Europe/Berlin")}, prefs)
+	value, err = store.Get("shares", "alice")
+	require.NoError(t, err)
+	assert.Empty(t, value, "an empty value is not a delete")
+	require.NoError(t, store.Put("prefs", "carol", []byte("UTC")))
+	_, err = store.Get("prefs", "carol")
+	assert.NoError(t, err)
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local.kv")
+	store, err := Open(path)
+	require.NoError(t, err)
+
+	for i := 0; i < 3*minCompact; i++ {
+		require.NoError(t, store.Put("counter", "n", []byte(strconv.Itoa(i))))
+	}
+	require.NoError(t, store.Put("counter", "other", []byte("kept")))
+	assert.Less(t, store.records, minCompact+2, "superseded records are dropped")
+	require.NoError(t, store.Close())
+
+	store, err = Open(path)
+	require.NoError(t, err)
+	defer store.Close()
+	counters, err := store.List("counter")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"n":     []byte(strconv.Itoa(3*minCompact - 1)),
+		"other": []byte("kept"),
+	}, counters)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left")
+}
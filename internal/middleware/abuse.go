@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/abuse"
+)
+
+// AbuseGuard keeps the ban list and counts suspicious events
+type AbuseGuard interface {
+	Check(ctx context.Context, subjects ...string) *abuse.Ban
+	Observe(ctx context.Context, signal string, subjects ...string) *abuse.Ban
+}
+
+// BlockBanned refuses banned IP addresses. Every 401 counts toward the
+// address's auth failure limit, which catches password guessing and stuffed
+// tokens alike.
+func BlockBanned(guard AbuseGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subject := abuse.IP(c.ClientIP())
+		if ban := guard.Check(c.Request.Context(), subject); ban != nil {
+			refuseBanned(c, ban)
+			return
+		}
+
+		c.Next()
+
+		if c.Writer.Status() == http.StatusUnauthorized {
+			guard.Observe(c.Request.Context(), abuse.SignalAuthFailure, subject)
+		}
+	}
+}
+
+// BlockBannedUsers must run after JWTAuth. It refuses banned users from any
+// address.
+func BlockBannedUsers(guard AbuseGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ban := guard.Check(c.Request.Context(), abuse.User(c.GetString("user_id"))); ban != nil {
+			refuseBanned(c, ban)
+			return
+		}
+		c.Next()
+	}
+}
+
+// CountAbuse counts one signal event for the authenticated user and
+// refuses the request that crosses the limit
+func CountAbuse(guard AbuseGuard, signal string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ban := guard.Observe(c.Request.Context(), signal, abuse.User(c.GetString("user_id"))); ban != nil {
+			refuseBanned(c, ban)
+			return
+		}
+		c.Next()
+	}
+}
+
+func refuseBanned(c *gin.Context, ban *abuse.Ban) {
+	retryAfter := int(time.Until(ban.ExpiresAt).Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "Temporarily banned for abuse",
+		"until": ban.ExpiresAt,
+	})
+	c.Abort()
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	generated := regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+	for _, tc := range []struct {
+		name, incoming string
+		kept           bool
+	}{
+		{"kept", "req-42", true},
+		{"longest kept", strings.Repeat("a", maxRequestID), true},
+		{"missing", "", false},
+		{"too long", strings.Repeat("a", maxRequestID+1), false},
+		{"space", "req 42", false},
+		{"quote", `req"42`, false},
+		{"control", "req\x0142", false},
+		{"non-ASCII", "réq", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var seen string
+			router := gin.New()
+			router.Use(RequestID())
+			router.GET("/", func(c *gin.Context) { seen = c.GetString("request_id") })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.incoming != "" {
+				req.Header.Set("X-Request-ID", tc.incoming)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// The response echoes the ID the handlers saw
+			assert.Equal(t, seen, w.Header().Get("X-Request-ID"))
+			if tc.kept {
+				assert.Equal(t, tc.incoming, seen)
+			} else {
+				assert.Regexp(t, generated, seen)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tc := range []struct {
+		name     string
+		format   string
+		verbose  bool
+		target   string
+		userID   string
+		status   int
+		body     string
+		referer  string
+		agent    string
+		expected string // with the time as [T]
+	}{
+		{
+			name: "common", format: AccessLogCommon, target: "/files?path=/etc", userID: "alice",
+			status: 200, body: "hello",
+			expected: `192.0.2.1 - alice [T] "GET /files HTTP/1.1" 200 5 "req-1"`,
+		},
+		{
+			name: "common verbose keeps the query", format: AccessLogCommon, verbose: true, target: "/files?path=/etc",
+			status: 404, body: "nope",
+			expected: `192.0.2.1 - - [T] "GET /files?path=/etc HTTP/1.1" 404 4 "req-1"`,
+		},
+		{
+			name: "combined", format: AccessLogCombined, target: "/", userID: "bob",
+			status: 200, body: "ok", referer: "https://example.com/", agent: "curl/8.0",
+			expected: `192.0.2.1 - bob [T] "GET / HTTP/1.1" 200 2 "https://example.com/" "curl/8.0" "req-1"`,
+		},
+		{
+			name: "combined empty fields", format: AccessLogCombined, target: "/",
+			status:   204,
+			expected: `192.0.2.1 - - [T] "GET / HTTP/1.1" 204 - "-" "-" "req-1"`,
+		},
+		{
+			name: "escaped", format: AccessLogCombined, target: "/", userID: `eve "x" y`,
+			status: 200, body: "ok", referer: `https://e.example/\"`, agent: "evil\" 200 \"\x1b[31m\x7f",
+			expected: `192.0.2.1 - eve__x__y [T] "GET / HTTP/1.1" 200 2 "https://e.example/\\\"" "evil\" 200 \"\x1b[31m\x7f" "req-1"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			router := gin.New()
+			router.Use(RequestID(), AccessLog(&out, tc.format, tc.verbose))
+			router.GET("/*path", func(c *gin.Context) {
+				if tc.userID != "" {
+					c.Set("user_id", tc.userID)
+				}
+				c.String(tc.status, tc.body)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			req.Header.Set("X-Request-ID", "req-1")
+			if tc.referer != "" {
+				req.Header.Set("Referer", tc.referer)
+			}
+			if tc.agent != "" {
+				req.Header.Set("User-Agent", tc.agent)
+			}
+			before := time.Now()
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			line := out.String()
+			require.True(t, strings.HasSuffix(line, "\n"), "%q", line)
+			head, rest, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "[")
+			require.True(t, ok, line)
+			stamp, tail, ok := strings.Cut(rest, "]")
+			require.True(t, ok, line)
+			logged, err := time.Parse("02/Jan/2006:15:04:05 -0700", stamp)
+			require.NoError(t, err)
+			assert.WithinDuration(t, before, logged, 2*time.Second)
+
+			assert.Equal(t, tc.expected, head+"[T]"+tail)
+		})
+	}
+}
+
+func TestCLFFields(t *testing.T) {
+	for in, out := range map[string]string{
+		"":          "-",
+		"alice":     "alice",
+		"a b\tc\"d": "a_b_c_d",
+	} {
+		assert.Equal(t, out, clfField(in), "%q", in)
+	}
+	for in, out := range map[string]string{
+		"":           `"-"`,
+		"plain":      `"plain"`,
+		`say "hi"`:   `"say \"hi\""`,
+		`back\slash`: `"back\\slash"`,
+		"line\nfake": `"line\x0afake"`,
+		"tab\there":  `"tab\x09here"`,
+		"del\x7f":    `"del\x7f"`,
+		"naïve":      `"naïve"`,
+	} {
+		assert.Equal(t, out, clfQuote(in), "%q", in)
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"golang.org/x/time/rate"
+)
+
+// quotaHelpKey holds server.quota_help in the request context
+const quotaHelpKey = "quota_help"
+
+// QuotaHelp tells every request refused by a rate limit or quota later on
+// how to ask for a higher one
+func QuotaHelp(help string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if help != "" {
+			c.Set(quotaHelpKey, help)
+		}
+		c.Next()
+	}
+}
+
+// RefuseLimit answers 429 with the limit that refused the request, under
+// code (see protocol.CodeRateLimited), and its Retry-After
+func RefuseLimit(c *gin.Context, code, message string, limit protocol.Limit) {
+	if limit.Increase == "" {
+		limit.Increase = c.GetString(quotaHelpKey)
+	}
+	c.Header("Retry-After", strconv.Itoa(limit.RetryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": message,
+		"code":  code,
+		"limit": limit,
+	})
+	c.Abort()
+}
+
+// RateLimited describes a refused request rate of perMinute, the most a
+// limiter of that rate and burst allows, with the delay until a request
+// may pass
+func RateLimited(kind string, perMinute int, delay time.Duration) protocol.Limit {
+	reset := time.Now().Add(delay).UTC()
+	return protocol.Limit{
+		Type:       kind,
+		Limit:      perMinute,
+		Used:       perMinute,
+		Window:     "1m",
+		ResetAt:    &reset,
+		RetryAfter: int(math.Ceil(delay.Seconds())),
+	}
+}
+
+// take takes a request from limiter if it has one, and otherwise returns
+// how long until it will, leaving it as it was
+func take(limiter *rate.Limiter) (time.Duration, bool) {
+	r := limiter.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return delay, false
+	}
+	return 0, true
+}
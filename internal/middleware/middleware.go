@@ -1,23 +1,49 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/internal/services/serviceaccounts"
+	"github.com/yourusername/webtunnel/internal/tlsinfo"
+	"github.com/yourusername/webtunnel/pkg/protocol"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
 
-func Logger(logger *zap.Logger) gin.HandlerFunc {
+// Logger logs every request. Verbose logs add the query string, user agent,
+// response size and handler errors; queries can carry share and WebSocket
+// tokens, so they are off outside development unless asked for.
+func Logger(logger *zap.Logger, verbose bool) gin.HandlerFunc {
 	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		logger.Info("HTTP Request",
+		fields := []zap.Field{
 			zap.String("method", param.Method),
 			zap.String("path", param.Path),
 			zap.Int("status", param.StatusCode),
 			zap.Duration("latency", param.Latency),
 			zap.String("client_ip", param.ClientIP),
-		)
+		}
+		if id, ok := param.Keys["request_id"].(string); ok {
+			fields = append(fields, zap.String("request_id", id))
+		}
+		if verbose {
+			fields = append(fields,
+				zap.String("query", param.Request.URL.RawQuery),
+				zap.String("user_agent", param.Request.UserAgent()),
+				zap.Int("body_size", param.BodySize),
+			)
+			if param.ErrorMessage != "" {
+				fields = append(fields, zap.String("errors", param.ErrorMessage))
+			}
+		}
+		logger.Info("HTTP Request", fields...)
 		return ""
 	})
 }
@@ -48,8 +74,8 @@ func CORS(allowOrigins []string) gin.HandlerFunc {
 			}
 		}
 		
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, traceparent")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
@@ -65,9 +91,105 @@ func RateLimit(requestsPerMinute int) gin.HandlerFunc {
 	limiter := rate.NewLimiter(rate.Every(time.Minute/time.Duration(requestsPerMinute)), requestsPerMinute)
 	
 	return func(c *gin.Context) {
-		if !limiter.Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
+		if delay, ok := take(limiter); !ok {
+			RefuseLimit(c, protocol.CodeRateLimited, "Rate limit exceeded",
+				RateLimited(protocol.LimitServerRequests, requestsPerMinute, delay))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RateLimitUser allows each user requestsPerMinute requests, in bursts of
+// up to that many, and answers 429 past it. It must run after JWTAuth; 0
+// or less turns it off.
+func RateLimitUser(requestsPerMinute int) gin.HandlerFunc {
+	if requestsPerMinute <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	var mu sync.Mutex
+	limiters := make(map[string]*rate.Limiter)
+
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		mu.Lock()
+		limiter, ok := limiters[userID]
+		if !ok {
+			limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(requestsPerMinute)), requestsPerMinute)
+			limiters[userID] = limiter
+		}
+		mu.Unlock()
+
+		if delay, ok := take(limiter); !ok {
+			RefuseLimit(c, protocol.CodeRateLimited, "Rate limit exceeded",
+				RateLimited(protocol.LimitUserRequests, requestsPerMinute, delay))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Timeout cancels the request context after the configured duration and
+// moves the connection's read and write deadlines to match, which lets long
+// transfers outlast the server-wide timeouts. An empty or invalid duration
+// leaves the request alone.
+func Timeout(timeout string) gin.HandlerFunc {
+	d, _ := time.ParseDuration(timeout)
+	return func(c *gin.Context) {
+		if d <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+
+		// Leave time to write the timeout response itself
+		deadline := time.Now().Add(d)
+		rc := http.NewResponseController(c.Writer)
+		rc.SetReadDeadline(deadline)
+		rc.SetWriteDeadline(deadline.Add(5 * time.Second))
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// ReadinessChecker reports whether startup dependencies have connected
+type ReadinessChecker interface {
+	Ready(names ...string) bool
+}
+
+// RequireReady answers 503 while any of the named dependencies is still
+// connecting, so a server started in degraded mode fails fast on routes
+// that cannot work yet
+func RequireReady(deps ReadinessChecker, names ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !deps.Ready(names...) {
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "Service is starting up",
+				"pending": names,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// LoadShedder refuses work while the server is short on memory
+type LoadShedder interface {
+	Admit(action string) (time.Duration, bool)
+}
+
+// ShedLoad answers 503 with Retry-After when the shedder refuses action
+func ShedLoad(shedder LoadShedder, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if retryAfter, ok := shedder.Admit(action); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Server is under heavy load, try again later",
 			})
 			c.Abort()
 			return
@@ -109,4 +231,112 @@ func JWTAuth(authService AuthServiceInterface) gin.HandlerFunc {
 		c.Set("user_id", userID)
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// ScopeChecker limits what callers authenticated with an API key may do
+type ScopeChecker interface {
+	Permit(userID, method, route string) error
+}
+
+// RequireScope must run after JWTAuth. It answers 429 to service accounts
+// over their request quota and 403 when the route is outside their scopes;
+// other users pass through.
+func RequireScope(checker ScopeChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := checker.Permit(c.GetString("user_id"), c.Request.Method, c.FullPath())
+		var limited *serviceaccounts.RateLimitError
+		switch {
+		case err == nil:
+			c.Next()
+			return
+		case errors.As(err, &limited):
+			RefuseLimit(c, protocol.CodeRateLimited, err.Error(),
+				RateLimited(protocol.LimitAPIKeyRequests, limited.RequestsPerMinute, limited.RetryAfter))
+			return
+		case errors.Is(err, serviceaccounts.ErrRateLimited):
+			c.Header("Retry-After", "60")
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		case errors.Is(err, serviceaccounts.ErrScope):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		}
+		c.Abort()
+	}
+}
+
+// UserLookup resolves the authenticated user for role checks
+type UserLookup interface {
+	GetUserByID(userID string) (*auth.User, error)
+}
+
+// RequireRole must run after JWTAuth. It rejects users whose role is not one
+// of the given roles and stores the resolved user in the context.
+func RequireRole(users UserLookup, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := users.GetUserByID(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "User not found",
+			})
+			c.Abort()
+			return
+		}
+
+		for _, role := range roles {
+			if user.Role == role {
+				c.Set("user", user)
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Insufficient permissions",
+		})
+		c.Abort()
+	}
+}
+
+// AuditRecorder persists audit entries
+type AuditRecorder interface {
+	Record(ctx context.Context, entry *audit.Entry) error
+}
+
+// ClientAttestation records who connected, from where and with which TLS
+// client for the routes it wraps. The fingerprint is read before the handler
+// runs because WebSocket upgrades hijack the connection.
+func ClientAttestation(registry *tlsinfo.Registry, recorder AuditRecorder, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fingerprint, hasTLS := registry.Lookup(c.Request.RemoteAddr)
+
+		c.Next()
+
+		details := map[string]interface{}{
+			"route":  c.FullPath(),
+			"method": c.Request.Method,
+			"status": c.Writer.Status(),
+		}
+		if hasTLS {
+			details["tls_fingerprint"] = fingerprint.Hash
+			details["tls_fingerprint_raw"] = fingerprint.Raw
+			details["tls_version"] = fingerprint.TLSVersion
+			details["tls_server_name"] = fingerprint.ServerName
+			details["tls_alpn"] = fingerprint.ALPN
+		}
+
+		entry := &audit.Entry{
+			ActorID:      c.GetString("user_id"),
+			Action:       audit.ActionClientAttest,
+			ResourceType: "connection",
+			ResourceID:   c.Param("id"),
+			Details:      details,
+			IPAddress:    c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+		}
+		if err := recorder.Record(context.Background(), entry); err != nil {
+			// Auditing must not fail the request
+			logger.Error("Failed to record client attestation", zap.Error(err))
+		}
+	}
+}
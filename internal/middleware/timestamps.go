@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/prefs"
+	"go.uber.org/zap"
+)
+
+// DisplayFormat is used for the _display fields added by ?tz=
+const DisplayFormat = "2006-01-02 15:04:05 MST"
+
+// TimezoneLookup returns a user's preferred time zone
+type TimezoneLookup interface {
+	Timezone(ctx context.Context, userID string) (*time.Location, error)
+}
+
+// Timestamps rewrites timestamp fields of JSON responses so every handler
+// emits RFC 3339 in UTC, whatever the server's local zone. With ?tz=user,
+// or ?tz=<IANA zone>, timestamps are given in that zone instead and each
+// gains a <field>_display sibling formatted for people.
+func Timestamps(zones TimezoneLookup, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loc := time.UTC
+		display := false
+		switch tz := c.Query("tz"); tz {
+		case "":
+		case "user":
+			userLoc, err := zones.Timezone(c.Request.Context(), c.GetString("user_id"))
+			if err != nil {
+				logger.Warn("Failed to look up user time zone", zap.Error(err))
+			} else {
+				loc = userLoc
+			}
+			display = true
+		default:
+			zoneLoc, err := prefs.LoadTimezone(tz)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+			loc, display = zoneLoc, true
+		}
+
+		writer := &timestampWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.buf == nil {
+			return
+		}
+		body := writer.buf.Bytes()
+		if rewritten, err := rewriteTimestamps(body, loc, display); err == nil {
+			body = rewritten
+			writer.Header().Del("Content-Length")
+		}
+		writer.ResponseWriter.Write(body)
+	}
+}
+
+// timestampWriter holds back JSON bodies so they can be rewritten; anything
+// else, including WebSocket upgrades and NDJSON streams, passes straight
+// through
+type timestampWriter struct {
+	gin.ResponseWriter
+	buf     *bytes.Buffer
+	decided bool
+}
+
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			w.buf = &bytes.Buffer{}
+		}
+	}
+	if w.buf != nil {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *timestampWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timestampWriter) Flush() {
+	if w.buf == nil {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// timestampKeys are field names holding times besides the *_at ones
+var timestampKeys = map[string]bool{
+	"timestamp":   true,
+	"time":        true,
+	"last_active": true,
+	"last_seen":   true,
+	"since":       true,
+	"until":       true,
+	"start":       true,
+	"end":         true,
+	"modified":    true,
+}
+
+func isTimestampKey(key string) bool {
+	return strings.HasSuffix(key, "_at") || timestampKeys[key]
+}
+
+func rewriteTimestamps(body []byte, loc *time.Location, display bool) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if !convertTimestamps(doc, loc, display) {
+		return body, nil
+	}
+	return json.Marshal(doc)
+}
+
+// convertTimestamps walks a decoded document and reports whether anything
+// changed. Only strings under timestamp keys are touched, so terminal
+// output that happens to contain a date is left alone.
+func convertTimestamps(node interface{}, loc *time.Location, display bool) bool {
+	changed := false
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && isTimestampKey(key) {
+				t, err := time.Parse(time.RFC3339Nano, s)
+				if err != nil {
+					continue
+				}
+				t = t.In(loc)
+				v[key] = t.Format(time.RFC3339Nano)
+				if display {
+					v[key+"_display"] = t.Format(DisplayFormat)
+				}
+				changed = true
+				continue
+			}
+			if convertTimestamps(value, loc, display) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if convertTimestamps(value, loc, display) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yourusername/webtunnel/internal/services/identity"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+)
+
+// Stage is a point in the request pipeline where embedders can add
+// middleware. Stages run in the order listed.
+type Stage int
+
+const (
+	// StagePre runs before the built-in logging, recovery, CORS and rate
+	// limiting, e.g. to assign request IDs the logger should see
+	StagePre Stage = iota
+	// StageGlobal runs after the built-in middleware on every route
+	StageGlobal
+	// StageAPI runs on /api/v1 routes before authentication
+	StageAPI
+	// StageProtected runs after authentication, with user_id set
+	StageProtected
+	// StageAdmin runs on admin routes after the admin check
+	StageAdmin
+)
+
+// Routes are the router and groups handed to route registrars
+type Routes struct {
+	Router    *gin.Engine
+	API       *gin.RouterGroup // /api/v1, unauthenticated
+	Protected *gin.RouterGroup // /api/v1, authenticated
+	Admin     *gin.RouterGroup // /api/v1/admin, admins only
+}
+
+// Option customizes a Server built by New
+type Option func(*options)
+
+type options struct {
+	middleware   map[Stage][]gin.HandlerFunc
+	authenticate gin.HandlerFunc
+	authorize    terminal.Authorizer
+	requireAdmin gin.HandlerFunc
+	routes       []func(Routes)
+	signer       identity.Signer
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{middleware: make(map[Stage][]gin.HandlerFunc)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMiddleware adds middleware at the given stage, after any added there
+// before
+func WithMiddleware(stage Stage, handlers ...gin.HandlerFunc) Option {
+	return func(o *options) {
+		o.middleware[stage] = append(o.middleware[stage], handlers...)
+	}
+}
+
+// WithAuthenticator replaces JWT authentication. The handler must set
+// "user_id" in the context or abort the request.
+func WithAuthenticator(handler gin.HandlerFunc) Option {
+	return func(o *options) {
+		o.authenticate = handler
+	}
+}
+
+// WithAuthorizer replaces the policy engine for session commands and file
+// access
+func WithAuthorizer(authz terminal.Authorizer) Option {
+	return func(o *options) {
+		o.authorize = authz
+	}
+}
+
+// WithAdminCheck replaces the admin role check on /api/v1/admin. It runs
+// after authentication and must abort requests it refuses.
+func WithAdminCheck(handler gin.HandlerFunc) Option {
+	return func(o *options) {
+		o.requireAdmin = handler
+	}
+}
+
+// WithIdentitySigner signs identity assertions with signer, e.g. one
+// backed by a KMS, instead of the configured Ed25519 key
+func WithIdentitySigner(signer identity.Signer) Option {
+	return func(o *options) {
+		o.signer = signer
+	}
+}
+
+// WithRoutes registers extra routes once the built-in ones are in place
+func WithRoutes(register func(Routes)) Option {
+	return func(o *options) {
+		o.routes = append(o.routes, register)
+	}
+}
@@ -4,33 +4,119 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/yourusername/webtunnel/internal/config"
 	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/diagnostics"
+	"github.com/yourusername/webtunnel/internal/handlers"
 	"github.com/yourusername/webtunnel/internal/middleware"
+	"github.com/yourusername/webtunnel/internal/services/abuse"
+	"github.com/yourusername/webtunnel/internal/services/actions"
+	"github.com/yourusername/webtunnel/internal/services/artifacts"
+	"github.com/yourusername/webtunnel/internal/services/audit"
 	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/internal/services/backup"
+	"github.com/yourusername/webtunnel/internal/services/banners"
+	"github.com/yourusername/webtunnel/internal/services/branding"
+	"github.com/yourusername/webtunnel/internal/services/checksum"
+	"github.com/yourusername/webtunnel/internal/services/deps"
+	"github.com/yourusername/webtunnel/internal/services/elevation"
+	"github.com/yourusername/webtunnel/internal/services/fileacl"
+	"github.com/yourusername/webtunnel/internal/services/flags"
+	"github.com/yourusername/webtunnel/internal/services/history"
+	"github.com/yourusername/webtunnel/internal/services/identity"
+	"github.com/yourusername/webtunnel/internal/services/inputaudit"
+	"github.com/yourusername/webtunnel/internal/services/janitor"
+	"github.com/yourusername/webtunnel/internal/services/maintenance"
+	"github.com/yourusername/webtunnel/internal/services/notify"
+	"github.com/yourusername/webtunnel/internal/services/permissions"
+	"github.com/yourusername/webtunnel/internal/services/policy"
+	"github.com/yourusername/webtunnel/internal/services/prefs"
+	"github.com/yourusername/webtunnel/internal/services/push"
+	"github.com/yourusername/webtunnel/internal/services/rtc"
+	"github.com/yourusername/webtunnel/internal/services/sandbox"
+	"github.com/yourusername/webtunnel/internal/services/scheduler"
+	"github.com/yourusername/webtunnel/internal/services/serviceaccounts"
 	"github.com/yourusername/webtunnel/internal/services/session"
-	"github.com/yourusername/webtunnel/internal/services/terminal"
-	"github.com/yourusername/webtunnel/internal/handlers"
+	"github.com/yourusername/webtunnel/internal/services/shares"
+	"github.com/yourusername/webtunnel/internal/services/shed"
+	"github.com/yourusername/webtunnel/internal/services/snapshots"
+	"github.com/yourusername/webtunnel/internal/services/snippets"
+	"github.com/yourusername/webtunnel/internal/services/sshkeys"
+	"github.com/yourusername/webtunnel/internal/services/status"
+	"github.com/yourusername/webtunnel/internal/services/templates"
+	"github.com/yourusername/webtunnel/internal/services/uploads"
+	"github.com/yourusername/webtunnel/internal/services/workshop"
+	"github.com/yourusername/webtunnel/internal/tlsinfo"
+	"github.com/yourusername/webtunnel/internal/upgrade"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/meter"
+	"github.com/yourusername/webtunnel/pkg/terminal"
 	"go.uber.org/zap"
 )
 
 type Server struct {
-	config       *config.Config
-	logger       *zap.Logger
-	httpServer   *http.Server
-	db           *database.DB
-	authService  *auth.Service
-	termService  *terminal.Service
-	sessService  *session.Service
+	config             *config.Config
+	logger             *zap.Logger
+	httpServer         *http.Server
+	db                 *database.DB
+	authService        *auth.Service
+	termService        *terminal.Service
+	sessService        *session.Service
+	deps               *deps.Service
+	flagService        *flags.Service
+	notifier           *notify.Service
+	backupService      *backup.Service
+	snippetService     *snippets.Service
+	auditService       *audit.Service
+	tlsRegistry        *tlsinfo.Registry
+	policyService      *policy.Service
+	pushService        *push.Service
+	meterService       *meter.Service
+	checksumService    *checksum.Service
+	uploadService      *uploads.Service
+	prefService        *prefs.Service
+	shareService       *shares.Service
+	diagnostics        *diagnostics.Collector
+	maintenanceService *maintenance.Service
+	janitor            *janitor.Service
+	statusService      *status.Service
+	rtcService         *rtc.Service
+	snapshotService    *snapshots.Service
+	shed               *shed.Service
+	abuse              *abuse.Service
+	sshService         *sshkeys.Service
+	upgrader           *upgrade.Upgrader
+	authorizer         terminal.Authorizer
+	fileACLService     *fileacl.Service
+	workshopService    *workshop.Service
+	elevationService   *elevation.Service
+	identityService    *identity.Service
+	templateService    *templates.Service
+	schedulerService   *scheduler.Service
+	artifactService    *artifacts.Service
+	bannerService      *banners.Service
+	inputAudit         *inputaudit.Service
+	brandingService    *branding.Service
+	accountService     *serviceaccounts.Service
+	actionService      *actions.Service
+	accessLog          io.Writer // nil unless access logs are on
+	options            *options
 }
 
-func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
-	// Initialize database
-	db, err := database.New(cfg.Database)
+// New builds the server. Options let embedders add middleware, replace
+// authentication and authorization, and register extra routes. gin's mode
+// is process-wide, so it is left to the caller.
+func New(cfg *config.Config, logger *zap.Logger, opts ...Option) (*Server, error) {
+	o := newOptions(opts)
+
+	// Initialize database; it is connected below, with the other
+	// dependencies
+	db, err := database.Open(cfg.Database)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
@@ -38,16 +124,223 @@ func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 	// Initialize services
 	authService := auth.New(cfg.Auth, db, logger)
 	termService := terminal.New(cfg.Session, logger)
-	sessService := session.New(cfg.Redis, logger)
+	sessService, err := session.New(cfg.Redis, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize session store: %w", err)
+	}
+	depService, err := deps.New(cfg.Startup, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize startup checks: %w", err)
+	}
+	depService.Add("database", db.PingContext)
+	depService.Add("redis", sessService.Ping)
+	if err := depService.Start(context.Background()); err != nil {
+		db.Close()
+		sessService.Close()
+		return nil, err
+	}
+	flagService := flags.New(cfg.Flags, db, logger)
+	notifier := notify.New(cfg.Notify, logger)
+	backupService := backup.New(db, logger)
+	snippetService := snippets.New(db, authService, logger)
+	termService.SetSnippetResolver(snippetService)
+	auditService := audit.New(db, logger)
+	if cfg.Audit.Chain {
+		interval, err := time.ParseDuration(cfg.Audit.AnchorInterval)
+		if err != nil || interval < time.Minute {
+			return nil, fmt.Errorf("invalid audit anchor_interval %q", cfg.Audit.AnchorInterval)
+		}
+		auditService.EnableChain(audit.ChainConfig{AnchorURL: cfg.Audit.AnchorURL, AnchorInterval: interval})
+	}
+	policyService := policy.New(cfg.Policy, authService, logger)
+	var authorizer terminal.Authorizer = policyService
+	if o.authorize != nil {
+		authorizer = o.authorize
+	}
+	termService.SetAuthorizer(authorizer)
+	fileACLService, err := fileacl.New(cfg.FileACL, authorizer, authService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize file ACLs: %w", err)
+	}
+	permService, err := permissions.New(cfg.Permissions, authService.UserRole, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize permissions: %w", err)
+	}
+	termService.SetPermissions(permService)
+	pushService, err := push.New(cfg.Push, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize push service: %w", err)
+	}
+	termService.OnEvent(pushService.HandleSessionEvent)
+	termService.OnEvent(notifier.HandleSessionEvent)
+	meterService := meter.New(cfg.Bandwidth, authService.UserRole, logger)
+	termService.SetMeter(meterService)
+	checksumService := checksum.New(db, logger)
+	uploadService := uploads.New(cfg.Uploads, authService.UserRole, logger)
+	prefService := prefs.New(db, logger)
+	shareService, err := shares.New(cfg.Shares, cfg.Auth.JWTSecret, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize share links: %w", err)
+	}
+	maintenanceService, err := maintenance.New(cfg.Maintenance, authService, termService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize maintenance windows: %w", err)
+	}
+	termService.SetAdmission(maintenanceService)
+	janitorService, err := janitor.New(cfg.Janitor, cfg.Session.WorkingDirectory, termService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize janitor: %w", err)
+	}
+	if cfg.Janitor.OnEnd {
+		termService.SetReclaimer(janitorService)
+	}
+	statusService, err := status.New(cfg.Status, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize status page: %w", err)
+	}
+	rtcService, err := rtc.New(cfg.RTC, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize WebRTC config: %w", err)
+	}
+	shedService, err := shed.New(cfg.LoadShed, notifier, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize load shedding: %w", err)
+	}
+	abuseService, err := abuse.New(cfg.Abuse, sessService.Client(), logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize abuse detection: %w", err)
+	}
+	termService.SetInputGuard(abuseService)
+	snapshotService, err := snapshots.New(cfg.Snapshots, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize snapshots: %w", err)
+	}
+	if snapshotService.Enabled() {
+		termService.SetProvisioner(snapshotService)
+	}
+	sshService, err := sshkeys.New(cfg.SSH, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize SSH keys: %w", err)
+	}
+	termService.SetSSHConnector(sshService)
+	sandboxService, err := sandbox.New(cfg.Sandbox, authService.UserRole, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize sandbox: %w", err)
+	}
+	termService.SetSandbox(sandboxService)
+	historyService := history.New(db, logger)
+	termService.SetSessionStore(historyService)
+	workshopService, err := workshop.New(cfg.Workshop, db, authService, termService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize workshops: %w", err)
+	}
+	authService.SetDirectory(workshopService)
+	accountService := serviceaccounts.New(cfg.ServiceAccounts, db, authService.UserRole, termService, logger)
+	authService.SetDirectory(accountService)
+	authService.SetKeyVerifier(accountService)
+	termService.SetQuotas(accountService)
+	elevationService, err := elevation.New(cfg.Elevation, authService.UserRole, termService, auditService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize elevated mode: %w", err)
+	}
+	termService.OnEvent(elevationService.HandleSessionEvent)
+	identityService, err := identity.New(cfg.Identity, authService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize identity assertions: %w", err)
+	}
+	if o.signer != nil {
+		identityService.SetSigner(o.signer)
+	}
+	bannerService, err := banners.New(cfg.Banner, db, authService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize banner: %w", err)
+	}
+	if bannerService.Enabled() {
+		termService.SetBanners(bannerService)
+	}
+	artifactService, err := artifacts.New(cfg.Artifacts, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize artifacts: %w", err)
+	}
+	if artifactService.Enabled() {
+		termService.SetArtifactStore(artifactService)
+	}
+	brandingService, err := branding.New(cfg.Branding, authService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize branding: %w", err)
+	}
+	inputAudit, err := inputaudit.New(cfg.InputAudit, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize input audit: %w", err)
+	}
+	if inputAudit.Enabled() {
+		termService.SetInputRecorder(inputAudit)
+		termService.OnEvent(inputAudit.HandleSessionEvent)
+	}
+	schedulerService, err := scheduler.New(cfg.Schedules, db, termService, auditService, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize schedules: %w", err)
+	}
+	templateService := templates.New(db, logger)
+	actionService := actions.New(templateService, snippetService, sshService, authorizer, logger)
+	upgrader, err := upgrade.New(logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize upgrader: %w", err)
+	}
+	upgrader.PIDFile = cfg.Server.PIDFile
+	accessLog, err := openAccessLog(cfg.Server.AccessLog)
+	if err != nil {
+		return nil, err
+	}
 
 	server := &Server{
-		config:      cfg,
-		logger:      logger,
-		db:          db,
-		authService: authService,
-		termService: termService,
-		sessService: sessService,
+		config:             cfg,
+		logger:             logger,
+		db:                 db,
+		authService:        authService,
+		termService:        termService,
+		sessService:        sessService,
+		deps:               depService,
+		flagService:        flagService,
+		notifier:           notifier,
+		backupService:      backupService,
+		snippetService:     snippetService,
+		auditService:       auditService,
+		tlsRegistry:        tlsinfo.NewRegistry(),
+		policyService:      policyService,
+		pushService:        pushService,
+		meterService:       meterService,
+		checksumService:    checksumService,
+		uploadService:      uploadService,
+		prefService:        prefService,
+		shareService:       shareService,
+		diagnostics:        diagnostics.NewCollector(),
+		maintenanceService: maintenanceService,
+		janitor:            janitorService,
+		statusService:      statusService,
+		rtcService:         rtcService,
+		snapshotService:    snapshotService,
+		shed:               shedService,
+		abuse:              abuseService,
+		sshService:         sshService,
+		upgrader:           upgrader,
+		authorizer:         authorizer,
+		fileACLService:     fileACLService,
+		workshopService:    workshopService,
+		elevationService:   elevationService,
+		identityService:    identityService,
+		templateService:    templateService,
+		schedulerService:   schedulerService,
+		artifactService:    artifactService,
+		bannerService:      bannerService,
+		inputAudit:         inputAudit,
+		brandingService:    brandingService,
+		accountService:     accountService,
+		actionService:      actionService,
+		accessLog:          accessLog,
+		options:            o,
 	}
+	server.registerStatusChecks()
 
 	// Setup HTTP server
 	server.setupHTTPServer()
@@ -56,66 +349,300 @@ func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 }
 
 func (s *Server) setupHTTPServer() {
-	// Set Gin mode
-	if s.config.Server.TLS {
-		gin.SetMode(gin.ReleaseMode)
-	}
-
 	router := gin.New()
 	
 	// Global middleware
-	router.Use(middleware.Logger(s.logger))
+	router.Use(s.options.middleware[StagePre]...)
+	router.Use(middleware.RequestID())
+	router.Use(middleware.Logger(s.logger, s.config.Server.VerboseLogs))
+	if s.accessLog != nil {
+		router.Use(middleware.AccessLog(s.accessLog, s.config.Server.AccessLog.Format, s.config.Server.VerboseLogs))
+	}
 	router.Use(middleware.Recovery(s.logger))
 	router.Use(middleware.CORS(s.config.Server.AllowOrigins))
+	router.Use(middleware.QuotaHelp(s.config.Server.QuotaHelp))
 	router.Use(middleware.RateLimit(s.config.Auth.RateLimit))
+	router.Use(middleware.BlockBanned(s.abuse))
+	router.Use(s.options.middleware[StageGlobal]...)
 
 	// Health check endpoint
 	router.GET("/health", handlers.Health)
 
+	// Public status page
+	statusHandler := handlers.NewStatus(s.statusService, s.logger)
+	router.GET("/status", statusHandler.Page)
+	router.GET("/status.json", statusHandler.Page)
+
+	// Session snapshots and guest attach behind signed share links
+	shareHandler := handlers.NewShare(s.termService, s.shareService, s.brandingService, s.logger)
+	router.GET("/shared/:token", shareHandler.Snapshot)
+	router.GET("/shared/:token/stream", shareHandler.Stream)
+
+	// Keys that verify identity assertions, for downstream services
+	identityHandler := handlers.NewIdentity(s.termService, s.identityService, s.auditService, s.logger)
+	router.GET("/.well-known/jwks.json", identityHandler.JWKS)
+
+	// Records client metadata and TLS fingerprints on sensitive routes
+	attest := middleware.ClientAttestation(s.tlsRegistry, s.auditService, s.logger)
+
+	// Login, user data and admin checks need the database; in degraded
+	// mode they answer 503 until it connects
+	needsDB := middleware.RequireReady(s.deps, "database")
+
 	// API routes
 	api := router.Group("/api/v1")
+	api.Use(s.options.middleware[StageAPI]...)
 	{
+		// White-labeling for pages shown before login
+		brandingHandler := handlers.NewBranding(s.brandingService, s.logger)
+		api.GET("/branding", brandingHandler.Get)
+
 		// Auth routes
-		auth := api.Group("/auth")
+		auth := api.Group("/auth", needsDB)
 		{
-			authHandler := handlers.NewAuth(s.authService, s.logger)
-			auth.POST("/login", authHandler.Login)
+			authHandler := handlers.NewAuth(s.authService, s.notifier, s.logger)
+			auth.POST("/login", attest, authHandler.Login)
 			auth.POST("/logout", authHandler.Logout)
 			auth.POST("/refresh", authHandler.Refresh)
 		}
 
 		// Protected routes
+		authenticate := s.options.authenticate
+		if authenticate == nil {
+			authenticate = middleware.JWTAuth(s.authService)
+		}
 		protected := api.Group("")
-		protected.Use(middleware.JWTAuth(s.authService))
+		protected.Use(authenticate)
+		protected.Use(middleware.RequireScope(s.accountService))
+		protected.Use(middleware.BlockBannedUsers(s.abuse))
+		protected.Use(middleware.Timestamps(s.prefService, s.logger))
+		protected.Use(s.options.middleware[StageProtected]...)
 		{
 			// Session management
+			sessHandler := handlers.NewSession(s.termService, s.sessService, s.templateService, s.prefService, s.notifier, s.logger)
+			elevationHandler := handlers.NewElevation(s.termService, s.elevationService, s.auditService, s.logger)
 			sessions := protected.Group("/sessions")
 			{
-				sessHandler := handlers.NewSession(s.termService, s.sessService, s.logger)
 				sessions.GET("", sessHandler.List)
-				sessions.POST("", sessHandler.Create)
+				sessions.GET("/summary", sessHandler.Summary)
+				sessions.GET("/history", sessHandler.History)
+				sessions.POST("", middleware.ShedLoad(s.shed, shed.ActionCreate), attest, middleware.Timeout(s.config.Timeouts.CreateSession), sessHandler.Create)
 				sessions.GET("/:id", sessHandler.Get)
+				sessions.PATCH("/:id", sessHandler.Update)
 				sessions.DELETE("/:id", sessHandler.Delete)
-				sessions.POST("/:id/input", sessHandler.SendInput)
-				sessions.GET("/:id/stream", sessHandler.Stream)
-				sessions.GET("/:id/share", sessHandler.Share)
+				sessions.POST("/:id/input", middleware.CountAbuse(s.abuse, abuse.SignalInput), middleware.Timeout(s.config.Timeouts.Input), sessHandler.SendInput)
+				sessions.POST("/:id/expect", sessHandler.Expect)
+				sessions.POST("/:id/presentation", sessHandler.SetPresentation)
+				sessions.GET("/:id/presence", sessHandler.Presence)
+				sessions.POST("/:id/control", sessHandler.RequestControl)
+				sessions.POST("/:id/control/grant", sessHandler.GrantControl)
+				sessions.DELETE("/:id/control", sessHandler.ReleaseControl)
+				sessions.GET("/:id/size", sessHandler.Size)
+				sessions.PUT("/:id/size", sessHandler.SetResizePolicy)
+				sessions.GET("/:id/bookmarks", sessHandler.Bookmarks)
+				sessions.POST("/:id/bookmarks", sessHandler.Mark)
+				sessions.GET("/:id/transcript", sessHandler.Transcript)
+				sessions.GET("/:id/scrollback", sessHandler.Scrollback)
+				sessions.GET("/:id/search", sessHandler.Search)
+				sessions.GET("/:id/log", sessHandler.Log)
+				sessions.GET("/:id/recording", middleware.Timeout(s.config.Timeouts.FileTransfer), sessHandler.Recording)
+				sessions.GET("/:id/stream", middleware.CountAbuse(s.abuse, abuse.SignalReconnect), middleware.ShedLoad(s.shed, shed.ActionAttach), attest, sessHandler.Stream)
+				sessions.POST("/:id/poll", middleware.CountAbuse(s.abuse, abuse.SignalReconnect), middleware.ShedLoad(s.shed, shed.ActionAttach), attest, sessHandler.PollAttach)
+				sessions.GET("/:id/poll/:client", sessHandler.Poll)
+				sessions.POST("/:id/poll/:client", sessHandler.PollSend)
+				sessions.DELETE("/:id/poll/:client", sessHandler.PollDetach)
+				sessions.GET("/:id/share", shareHandler.Create)
+				sessions.DELETE("/:id/share", shareHandler.Revoke)
+				sessions.DELETE("/:id/share/:share_id", shareHandler.Revoke)
+				sessions.POST("/:id/elevation", elevationHandler.Request)
+				sessions.DELETE("/:id/elevation", elevationHandler.End)
+				sessions.POST("/:id/identity", identityHandler.Issue)
 			}
 
+			// One-shot commands
+			execHandler := handlers.NewExec(s.termService, s.auditService, s.logger)
+			protected.POST("/exec", middleware.ShedLoad(s.shed, shed.ActionCreate), attest, middleware.Timeout(s.config.Timeouts.Exec), execHandler.Run)
+
 			// File operations
 			files := protected.Group("/files")
 			{
-				fileHandler := handlers.NewFile(s.logger)
-				files.GET("/browse", fileHandler.Browse)
-				files.POST("/upload", fileHandler.Upload)
-				files.GET("/download", fileHandler.Download)
+				fileHandler := handlers.NewFile(s.fileACLService, s.meterService, s.checksumService, s.uploadService, s.auditService, s.logger)
+				files.GET("/browse", middleware.Timeout(s.config.Timeouts.FileList), fileHandler.Browse)
+				files.GET("/search", middleware.RateLimitUser(s.config.FileSearch.RateLimit), middleware.Timeout(s.config.Timeouts.FileSearch), fileHandler.Search)
+				files.POST("/upload", middleware.Timeout(s.config.Timeouts.FileTransfer), fileHandler.Upload)
+				files.GET("/download", middleware.Timeout(s.config.Timeouts.FileTransfer), fileHandler.Download)
 			}
 
 			// User management
-			users := protected.Group("/users")
+			sshKeyHandler := handlers.NewSSHKey(s.sshService, s.auditService, s.logger)
+			users := protected.Group("/users", needsDB)
 			{
-				userHandler := handlers.NewUser(s.authService, s.logger)
+				userHandler := handlers.NewUser(s.authService, s.prefService, s.termService, s.logger)
 				users.GET("/profile", userHandler.GetProfile)
 				users.PUT("/profile", userHandler.UpdateProfile)
+
+				users.GET("/sshkeys", sshKeyHandler.List)
+				users.POST("/sshkeys", sshKeyHandler.Create)
+				users.DELETE("/sshkeys/:id", sshKeyHandler.Delete)
+			}
+
+			// Snippet library
+			snippetRoutes := protected.Group("/snippets", needsDB)
+			{
+				snippetHandler := handlers.NewSnippet(s.snippetService, s.logger)
+				snippetRoutes.GET("", snippetHandler.List)
+				snippetRoutes.POST("", snippetHandler.Save)
+				snippetRoutes.DELETE("/:id", snippetHandler.Delete)
+			}
+
+			// Web Push notifications
+			pushRoutes := protected.Group("/notifications/push", needsDB)
+			{
+				pushHandler := handlers.NewPush(s.pushService, s.logger)
+				pushRoutes.GET("/key", pushHandler.PublicKey)
+				pushRoutes.POST("", pushHandler.Subscribe)
+				pushRoutes.DELETE("", pushHandler.Unsubscribe)
+			}
+
+			// Bandwidth usage
+			bandwidthHandler := handlers.NewBandwidth(s.meterService, s.termService, s.logger)
+			protected.GET("/usage/bandwidth", bandwidthHandler.Usage)
+
+			// Maintenance windows
+			maintenanceHandler := handlers.NewMaintenance(s.maintenanceService, s.logger)
+			protected.GET("/maintenance", maintenanceHandler.Status)
+
+			// ICE servers for the WebRTC data channel
+			rtcHandler := handlers.NewRTC(s.rtcService, s.logger)
+			protected.GET("/rtc/config", rtcHandler.Config)
+
+			// Session templates
+			templateHandler := handlers.NewTemplate(s.templateService, s.auditService, s.logger)
+			protected.GET("/templates", needsDB, templateHandler.List)
+
+			// What the caller can do, for command palettes
+			actionHandler := handlers.NewAction(s.actionService, s.logger)
+			protected.GET("/actions", actionHandler.List)
+
+			// Scheduled commands and their run history
+			scheduleHandler := handlers.NewSchedule(s.schedulerService, s.authService.UserRole, s.logger)
+			protected.GET("/schedules", scheduleHandler.List)
+			protected.GET("/schedules/:id/runs", needsDB, scheduleHandler.Runs)
+
+			// Files kept from one-shot commands and scheduled runs
+			artifactHandler := handlers.NewArtifact(s.artifactService, s.authService.UserRole, s.logger)
+			protected.GET("/artifacts/:id", artifactHandler.List)
+			protected.GET("/artifacts/:id/*name", middleware.Timeout(s.config.Timeouts.FileTransfer), artifactHandler.Download)
+
+			// The banner shown at session start and its acknowledgment
+			bannerHandler := handlers.NewBanner(s.bannerService, s.auditService, s.logger)
+			protected.GET("/banner", bannerHandler.Get)
+			protected.GET("/branding/mine", brandingHandler.Mine)
+			protected.POST("/banner/ack", bannerHandler.Acknowledge)
+
+			// Workspace snapshots
+			snapshotHandler := handlers.NewSnapshot(s.snapshotService, s.logger)
+			protected.GET("/snapshots", snapshotHandler.List)
+
+			// Feature flags
+			flagHandler := handlers.NewFlag(s.flagService, s.authService, s.logger)
+			protected.GET("/flags", needsDB, flagHandler.Evaluate)
+
+			// Admin routes
+			admin := protected.Group("/admin", needsDB)
+			requireAdmin := s.options.requireAdmin
+			if requireAdmin == nil {
+				requireAdmin = middleware.RequireRole(s.authService, "admin")
+			}
+
+			admin.Use(requireAdmin)
+			admin.Use(s.options.middleware[StageAdmin]...)
+			{
+				admin.GET("/flags", flagHandler.List)
+				admin.PUT("/flags/:name", flagHandler.Update)
+				admin.DELETE("/flags/:name", flagHandler.Delete)
+
+				backupHandler := handlers.NewBackup(s.backupService, s.logger)
+				admin.POST("/export", backupHandler.Export)
+				admin.POST("/import", backupHandler.Import)
+
+				auditHandler := handlers.NewAudit(s.auditService, s.logger)
+				admin.GET("/audit", auditHandler.Query)
+				admin.GET("/audit/search", auditHandler.Search)
+				admin.GET("/audit/fingerprints/:user_id", auditHandler.Fingerprints)
+				admin.GET("/audit/proof", auditHandler.Proof)
+
+				// What was typed into a session, for compliance
+				inputAuditHandler := handlers.NewInputAudit(s.inputAudit, s.auditService, s.logger)
+				admin.GET("/audit/sessions/:id", inputAuditHandler.Session)
+
+				admin.PUT("/status/incident", statusHandler.SetIncident)
+				admin.DELETE("/status/incident", statusHandler.ClearIncident)
+
+				admin.GET("/fanout", sessHandler.FanoutStats)
+				admin.GET("/latency", sessHandler.ConnectionQuality)
+				admin.GET("/protocol", sessHandler.ProtocolReport)
+				admin.GET("/sessions/:id/logs", sessHandler.Logs)
+				admin.GET("/bandwidth", bandwidthHandler.All)
+
+				metricsHandler := handlers.NewMetrics(s.logger, s.meterService, s.termService, s.janitor, s.shed, s.abuse)
+				admin.GET("/metrics", metricsHandler.Serve)
+
+				loadShedHandler := handlers.NewLoadShed(s.shed, s.logger)
+				admin.GET("/load", loadShedHandler.Status)
+
+				abuseHandler := handlers.NewAbuse(s.abuse, s.auditService, s.logger)
+				admin.GET("/bans", abuseHandler.List)
+				admin.POST("/bans", abuseHandler.Create)
+				admin.DELETE("/bans/:subject", abuseHandler.Delete)
+
+				fileACLHandler := handlers.NewFileACL(s.fileACLService)
+				admin.GET("/file-acls", fileACLHandler.List)
+
+				admin.GET("/ssh/known-hosts", sshKeyHandler.KnownHosts)
+				admin.POST("/ssh/known-hosts", sshKeyHandler.PinHost)
+				admin.DELETE("/ssh/known-hosts/:host", sshKeyHandler.ForgetHost)
+
+				admin.GET("/snapshots/:name", snapshotHandler.Versions)
+				admin.POST("/snapshots/:name", middleware.Timeout(s.config.Timeouts.FileTransfer), snapshotHandler.Create)
+				admin.DELETE("/snapshots/:name/:version", snapshotHandler.Delete)
+
+				admin.GET("/elevations", elevationHandler.Pending)
+				admin.POST("/elevations/:id/approve", elevationHandler.Approve)
+				admin.POST("/elevations/:id/deny", elevationHandler.Deny)
+
+				admin.POST("/templates", templateHandler.Create)
+				admin.PUT("/templates/:id", templateHandler.Update)
+				admin.DELETE("/templates/:id", templateHandler.Delete)
+
+				accountHandler := handlers.NewServiceAccount(s.accountService, s.auditService, s.logger)
+				admin.GET("/service-accounts", accountHandler.List)
+				admin.POST("/service-accounts", accountHandler.Create)
+				admin.POST("/service-accounts/:id/key", accountHandler.RotateKey)
+				admin.DELETE("/service-accounts/:id", accountHandler.Delete)
+
+				admin.GET("/banners", bannerHandler.List)
+				admin.GET("/banners/:id/acks", bannerHandler.Acks)
+
+				workshopHandler := handlers.NewWorkshop(s.workshopService, s.auditService, s.logger)
+				admin.GET("/workshops", workshopHandler.List)
+				admin.POST("/workshops", workshopHandler.Create)
+				admin.GET("/workshops/:id", workshopHandler.Get)
+				admin.DELETE("/workshops/:id", workshopHandler.End)
+
+				admin.POST("/maintenance/override", maintenanceHandler.Override)
+				admin.DELETE("/maintenance/override", maintenanceHandler.ClearOverride)
+
+				debugHandler := handlers.NewDebug(s.diagnostics, s.termService, s.config.Server.EnablePprof, s.logger)
+				admin.GET("/debug", debugHandler.Info)
+				admin.GET("/debug/pprof/*profile", debugHandler.Pprof)
+				admin.POST("/debug/pprof/*profile", debugHandler.Pprof)
+			}
+
+			// Routes registered by embedders
+			routes := Routes{Router: router, API: api, Protected: protected, Admin: admin}
+			for _, register := range s.options.routes {
+				register(routes)
 			}
 		}
 	}
@@ -134,6 +661,7 @@ func (s *Server) setupHTTPServer() {
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ConnState:    s.tlsRegistry.ConnState,
 	}
 
 	// Configure TLS if enabled
@@ -141,7 +669,8 @@ func (s *Server) setupHTTPServer() {
 		if s.config.Server.CertFile != "" && s.config.Server.KeyFile != "" {
 			// Use provided certificates
 			s.httpServer.TLSConfig = &tls.Config{
-				MinVersion: tls.VersionTLS12,
+				MinVersion:         tls.VersionTLS12,
+				GetConfigForClient: s.tlsRegistry.GetConfigForClient,
 			}
 		} else {
 			// Generate self-signed certificates
@@ -151,9 +680,64 @@ func (s *Server) setupHTTPServer() {
 	}
 }
 
+// SetBuildInfo records the version stamped into main for the debug endpoint
+func (s *Server) SetBuildInfo(version, commit, date string) {
+	s.diagnostics.SetBuild(version, commit, date)
+}
+
+// registerStatusChecks adds the components shown on the public status page
+func (s *Server) registerStatusChecks() {
+	s.statusService.AddCheck("Terminal sessions", func(ctx context.Context) error {
+		now := time.Now()
+		if m := s.maintenanceService.Status(now); m.Active != nil && m.OverrideUntil == nil {
+			return status.Maintenance("%s until %s", m.Active.Name, m.Active.End.UTC().Format("15:04 MST"))
+		}
+		switch s.shed.Level() {
+		case shed.LevelSessions:
+			return status.Degraded("Under memory pressure, new sessions are refused")
+		case shed.LevelAttach:
+			return status.Degraded("Under memory pressure, new sessions and connections are refused")
+		}
+		stats := s.termService.Stats()
+		if limit := s.config.Session.MaxTotalSessions; limit > 0 && stats.Total+stats.Pending >= limit {
+			return status.Degraded("At capacity, new sessions may be refused")
+		}
+		return nil
+	})
+	s.statusService.AddCheck("Database", func(ctx context.Context) error {
+		return s.db.PingContext(ctx)
+	})
+	s.statusService.AddCheck("Session store", s.sessService.Ping)
+}
+
+// Handler returns the router so the server can be mounted inside another
+// HTTP server. Cleanup and maintenance routines only start with Run.
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
 func (s *Server) Run(ctx context.Context) error {
 	// Start cleanup routines
 	go s.startCleanupRoutines(ctx)
+	go s.maintenanceService.Run(ctx)
+	go s.janitor.Run(ctx)
+	go s.sessService.Run(ctx)
+	go s.deps.Run(ctx)
+	go s.shed.Run(ctx)
+	go s.abuse.Run(ctx)
+	go s.termService.Prewarm(ctx)
+	go s.termService.Restore(ctx)
+	go s.workshopService.Run(ctx)
+	go s.auditService.Run(ctx)
+	go s.schedulerService.Run(ctx)
+	go s.artifactService.Run(ctx)
+	go s.inputAudit.Run(ctx)
+
+	// Listeners are inherited when this process was started by an upgrade
+	ln, err := s.upgrader.Listen(s.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
 
 	// Start HTTP server
 	errChan := make(chan error, 1)
@@ -162,18 +746,19 @@ func (s *Server) Run(ctx context.Context) error {
 		s.logger.Info("Starting HTTP server",
 			zap.String("addr", s.httpServer.Addr),
 			zap.Bool("tls", s.config.Server.TLS),
+			zap.Bool("inherited", s.upgrader.Inherited()),
 		)
 
 		var err error
 		if s.config.Server.TLS {
 			if s.config.Server.CertFile != "" && s.config.Server.KeyFile != "" {
-				err = s.httpServer.ListenAndServeTLS(s.config.Server.CertFile, s.config.Server.KeyFile)
+				err = s.httpServer.ServeTLS(ln, s.config.Server.CertFile, s.config.Server.KeyFile)
 			} else {
 				// Would use auto-generated certs
-				err = s.httpServer.ListenAndServe()
+				err = s.httpServer.Serve(ln)
 			}
 		} else {
-			err = s.httpServer.ListenAndServe()
+			err = s.httpServer.Serve(ln)
 		}
 
 		if err != nil && err != http.ErrServerClosed {
@@ -181,16 +766,89 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}()
 
+	if err := s.upgrader.Ready(); err != nil {
+		s.logger.Error("Failed to complete upgrade handoff", zap.Error(err))
+	}
+
 	// Wait for shutdown signal or error
 	select {
 	case <-ctx.Done():
 		s.logger.Info("Shutdown signal received, gracefully shutting down...")
 		return s.shutdown()
+	case <-s.upgrader.Exit():
+		return s.drain(ctx)
 	case err := <-errChan:
 		return fmt.Errorf("server error: %w", err)
 	}
 }
 
+// openAccessLog opens the access log file for appending, so an external
+// rotation that truncates it keeps working. It returns nil when access
+// logs are off.
+func openAccessLog(cfg config.AccessLogConfig) (io.Writer, error) {
+	switch cfg.Format {
+	case "":
+		return nil, nil
+	case middleware.AccessLogCommon, middleware.AccessLogCombined:
+	default:
+		return nil, fmt.Errorf("unknown server.access_log.format %q: use common or combined", cfg.Format)
+	}
+	if cfg.Path == "" || cfg.Path == "-" {
+		return os.Stdout, nil
+	}
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	return f, nil
+}
+
+// Upgrade hands the listeners to a new copy of the binary. Once it is
+// serving, this process stops accepting connections and drains.
+func (s *Server) Upgrade() error {
+	return s.upgrader.Upgrade()
+}
+
+// drain runs after an upgrade. Terminals attached here keep running until
+// their clients leave or the drain timeout passes; everything new goes to
+// the upgraded process.
+func (s *Server) drain(ctx context.Context) error {
+	timeout := time.Hour
+	if s.config.Server.DrainTimeout != "" {
+		if parsed, err := time.ParseDuration(s.config.Server.DrainTimeout); err == nil {
+			timeout = parsed
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	s.logger.Info("Upgraded process is serving, draining", zap.Time("deadline", deadline))
+
+	// Stop accepting; hijacked WebSockets are not affected
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		s.logger.Error("Error shutting down HTTP server", zap.Error(err))
+	}
+	cancel()
+
+	if s.termService.Stats().Connections > 0 {
+		s.termService.Notice(fmt.Sprintf(
+			"WebTunnel was upgraded. This session stays up until you disconnect or %s; new sessions use the new version.",
+			deadline.UTC().Format("15:04 MST")))
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for s.termService.Stats().Connections > 0 && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			deadline = time.Now()
+		case <-ticker.C:
+		}
+	}
+
+	s.closeServices()
+	return nil
+}
+
 func (s *Server) shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -200,14 +858,22 @@ func (s *Server) shutdown() error {
 		s.logger.Error("Error shutting down HTTP server", zap.Error(err))
 	}
 
+	s.closeServices()
+	return nil
+}
+
+func (s *Server) closeServices() {
 	// Close terminal sessions
 	s.termService.Shutdown()
 
 	// Close database connections
 	s.db.Close()
+	s.sessService.Close()
+	if closer, ok := s.accessLog.(io.Closer); ok && s.accessLog != os.Stdout {
+		closer.Close()
+	}
 
 	s.logger.Info("Server shutdown complete")
-	return nil
 }
 
 func (s *Server) startCleanupRoutines(ctx context.Context) {
@@ -221,6 +887,34 @@ func (s *Server) startCleanupRoutines(ctx context.Context) {
 			return
 		case <-ticker.C:
 			s.termService.CleanupStaleSessions()
+			s.checkLongRunningSessions()
+			s.meterService.Prune(time.Hour)
+		}
+	}
+}
+
+func (s *Server) checkLongRunningSessions() {
+	threshold := s.notifier.LongRunningThreshold()
+	if threshold == 0 {
+		return
+	}
+
+	for _, sess := range s.termService.ListAllSessions() {
+		if sess.Status() != terminal.StatusRunning || time.Since(sess.CreatedAt) < threshold {
+			continue
 		}
+		s.notifier.NotifyOnce("long_running:"+sess.ID, notify.Event{
+			Type:  notify.EventLongRunningSession,
+			Title: "Long-running terminal session",
+			Text:  fmt.Sprintf("Session %s has been running for more than %s", sess.ID, threshold),
+			Fields: map[string]string{
+				"session_id": sess.ID,
+				"user_id":    sess.UserID,
+				"command":    sess.Command,
+			},
+		})
 	}
+
+	// Drop old dedupe keys so sessions still running much later get a reminder
+	s.notifier.Forget(4 * threshold)
 }
\ No newline at end of file
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/deps"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// newTestServer builds the server without a database or Redis, in degraded
+// mode
+func newTestServer(t *testing.T, environment string, opts ...Option) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "webtunnel.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+server:
+  environment: `+environment+`
+database:
+  url: "postgres://webtunnel@127.0.0.1:1/webtunnel?sslmode=disable&connect_timeout=1"
+redis:
+  url: "redis://127.0.0.1:1"
+startup:
+  wait_for_deps: "0"
+  degraded: true
+auth:
+  jwt_secret: "test"
+session:
+  working_directory: "`+dir+`"
+`), 0600))
+
+	cfg, err := config.Load(file)
+	require.NoError(t, err)
+	srv, err := New(cfg, zap.NewNop(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(srv.closeServices)
+	return srv
+}
+
+func TestMiddlewareStages(t *testing.T) {
+	var mu sync.Mutex
+	var ran []string
+	record := func(name string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, name)
+		}
+	}
+	handler := func(c *gin.Context) {
+		record("handler")(c)
+		c.Status(http.StatusNoContent)
+	}
+
+	srv := newTestServer(t, config.EnvProduction,
+		WithMiddleware(StagePre, record("pre"), func(c *gin.Context) {
+			// Built-in middleware has not run yet
+			_, ok := c.Get("request_id")
+			assert.False(t, ok)
+		}),
+		WithMiddleware(StageGlobal, record("global"), func(c *gin.Context) {
+			assert.NotEmpty(t, c.GetString("request_id"))
+		}),
+		WithMiddleware(StageAPI, record("api")),
+		WithMiddleware(StageProtected, record("protected"), func(c *gin.Context) {
+			assert.Equal(t, "user_stages", c.GetString("user_id"))
+		}),
+		WithMiddleware(StageAdmin, record("admin")),
+		WithMiddleware(StagePre, record("pre 2")),
+		WithAuthenticator(func(c *gin.Context) {
+			record("authenticate")(c)
+			c.Set("user_id", "user_stages")
+		}),
+		WithAdminCheck(record("admin check")),
+		WithRoutes(func(r Routes) {
+			r.Router.GET("/stages", handler)
+			r.API.GET("/public-stages", handler)
+			r.Protected.GET("/stages", handler)
+			r.Admin.GET("/stages", handler)
+		}),
+	)
+	// Admin routes wait for the database; rebuild the router without it
+	ready, err := deps.New(config.StartupConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	srv.deps = ready
+	srv.setupHTTPServer()
+
+	for _, tc := range []struct {
+		path     string
+		expected []string
+	}{
+		{"/stages", []string{"pre", "pre 2", "global", "handler"}},
+		{"/api/v1/public-stages", []string{"pre", "pre 2", "global", "api", "handler"}},
+		{"/api/v1/stages", []string{"pre", "pre 2", "global", "api", "authenticate", "protected", "handler"}},
+		{"/api/v1/admin/stages", []string{"pre", "pre 2", "global", "api", "authenticate", "protected", "admin check", "admin", "handler"}},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			ran = nil
+			w := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+			assert.Equal(t, tc.expected, ran)
+		})
+	}
+}
+
+func TestNewLeavesGinMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newTestServer(t, config.EnvDevelopment)
+	assert.Equal(t, gin.TestMode, gin.Mode())
+	newTestServer(t, config.EnvProduction)
+	assert.Equal(t, gin.TestMode, gin.Mode())
+}
@@ -0,0 +1,379 @@
+package abuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Signals are the patterns that lead to a ban
+const (
+	SignalReconnect   = "reconnect"
+	SignalAuthFailure = "auth_failure"
+	SignalInput       = "input"
+	SignalManual      = "manual"
+)
+
+// banCacheTTL is how long a ban lookup is trusted locally; bans and lifts
+// made on other instances take at most this long to apply here
+const banCacheTTL = 5 * time.Second
+
+var ErrInvalidSubject = errors.New("subject must be ip:<address> or user:<id>")
+
+// Ban keeps an IP or user out until ExpiresAt
+type Ban struct {
+	Subject   string    `json:"subject"`
+	Signal    string    `json:"signal"`
+	Reason    string    `json:"reason,omitempty"`
+	Strike    int       `json:"strike"` // how many bans in a row, manual ones included
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IP and User build ban subjects
+func IP(addr string) string {
+	return "ip:" + addr
+}
+
+func User(userID string) string {
+	return "user:" + userID
+}
+
+// ValidSubject checks a subject given through the admin API
+func ValidSubject(subject string) error {
+	kind, value, ok := strings.Cut(subject, ":")
+	if !ok || value == "" || (kind != "ip" && kind != "user") {
+		return ErrInvalidSubject
+	}
+	return nil
+}
+
+type rule struct {
+	limit  int
+	window time.Duration
+}
+
+// counter counts events in a fixed window
+type counter struct {
+	start time.Time
+	count int
+}
+
+type cachedBan struct {
+	ban   *Ban
+	until time.Time
+}
+
+// Service detects abuse and keeps the ban list. Events are counted per
+// instance, which keeps the hot path off Redis; bans and strikes live in
+// Redis so every instance enforces them and escalation survives restarts.
+type Service struct {
+	enabled     bool
+	rules       map[string]rule
+	banDuration time.Duration
+	maxBan      time.Duration
+	strikeTTL   time.Duration
+	store       store
+	logger      *zap.Logger
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	cache    map[string]cachedBan
+	issued   map[string]int64
+}
+
+// New creates the service. Without a Redis client bans are kept in memory
+// and last only until restart.
+func New(cfg config.AbuseConfig, rdb redis.UniversalClient, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		enabled:     cfg.Enabled,
+		rules:       make(map[string]rule),
+		banDuration: 5 * time.Minute,
+		maxBan:      24 * time.Hour,
+		strikeTTL:   24 * time.Hour,
+		logger:      logger,
+		counters:    make(map[string]*counter),
+		cache:       make(map[string]cachedBan),
+		issued:      make(map[string]int64),
+	}
+	if rdb != nil {
+		s.store = &redisStore{redis: rdb}
+	} else {
+		s.store = newMemoryStore()
+	}
+
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"ban_duration", cfg.BanDuration, &s.banDuration},
+		{"max_ban_duration", cfg.MaxBanDuration, &s.maxBan},
+		{"strike_ttl", cfg.StrikeTTL, &s.strikeTTL},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid abuse %s %q", d.name, d.value)
+		}
+		*d.dst = parsed
+	}
+	if s.maxBan < s.banDuration {
+		s.maxBan = s.banDuration
+	}
+
+	for signal, r := range map[string]config.AbuseRule{
+		SignalReconnect:   cfg.Reconnect,
+		SignalAuthFailure: cfg.AuthFailure,
+		SignalInput:       cfg.Input,
+	} {
+		if r.Limit <= 0 {
+			continue
+		}
+		window, err := time.ParseDuration(r.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid abuse %s window %q", signal, r.Window)
+		}
+		s.rules[signal] = rule{limit: r.Limit, window: window}
+	}
+	return s, nil
+}
+
+// Enabled reports whether bans are applied automatically. Manual bans are
+// enforced either way.
+func (s *Service) Enabled() bool {
+	return s.enabled
+}
+
+// Check returns the first active ban on any of the subjects. Lookups fail
+// open: a Redis outage must not lock everyone out.
+func (s *Service) Check(ctx context.Context, subjects ...string) *Ban {
+	now := time.Now()
+	for _, subject := range subjects {
+		s.mu.Lock()
+		cached, ok := s.cache[subject]
+		s.mu.Unlock()
+
+		ban := cached.ban
+		if !ok || now.After(cached.until) {
+			var err error
+			if ban, err = s.store.get(ctx, subject); err != nil {
+				// Also cached, so an outage costs one lookup per interval
+				s.logger.Warn("Failed to look up ban", zap.String("subject", subject), zap.Error(err))
+			}
+			s.remember(subject, ban, now)
+		}
+		if ban != nil && now.Before(ban.ExpiresAt) {
+			return ban
+		}
+	}
+	return nil
+}
+
+// Observe counts one event of signal for each subject and bans any subject
+// over the limit, returning the new ban
+func (s *Service) Observe(ctx context.Context, signal string, subjects ...string) *Ban {
+	r, ok := s.rules[signal]
+	if !s.enabled || !ok {
+		return nil
+	}
+
+	now := time.Now()
+	var over []string
+	s.mu.Lock()
+	for _, subject := range subjects {
+		key := signal + "|" + subject
+		c := s.counters[key]
+		if c == nil || now.Sub(c.start) >= r.window {
+			c = &counter{start: now}
+			s.counters[key] = c
+		}
+		c.count++
+		if c.count > r.limit {
+			// Start over, so the subject is not banned again the moment
+			// this ban expires
+			delete(s.counters, key)
+			over = append(over, subject)
+		}
+	}
+	s.mu.Unlock()
+
+	var result *Ban
+	for _, subject := range over {
+		reason := fmt.Sprintf("more than %d %s events in %s", r.limit, signal, r.window)
+		ban, err := s.Ban(ctx, subject, signal, reason, 0)
+		if err != nil {
+			s.logger.Error("Failed to ban", zap.String("subject", subject), zap.String("signal", signal), zap.Error(err))
+			continue
+		}
+		result = ban
+	}
+	return result
+}
+
+// AllowInput counts an input message from userID and reports whether the
+// user may keep sending; it is the terminal's input guard
+func (s *Service) AllowInput(userID string) bool {
+	ctx := context.Background()
+	subject := User(userID)
+	return s.Check(ctx, subject) == nil && s.Observe(ctx, SignalInput, subject) == nil
+}
+
+// Ban bans subject for duration, or for the escalating duration when
+// duration is zero. Every ban counts as a strike.
+func (s *Service) Ban(ctx context.Context, subject, signal, reason string, duration time.Duration) (*Ban, error) {
+	if err := ValidSubject(subject); err != nil {
+		return nil, err
+	}
+
+	strike, err := s.store.strike(ctx, subject, s.strikeTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count strike: %w", err)
+	}
+	if duration <= 0 {
+		duration = s.escalate(strike)
+	}
+
+	now := time.Now()
+	ban := &Ban{
+		Subject:   subject,
+		Signal:    signal,
+		Reason:    reason,
+		Strike:    strike,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+	if err := s.store.put(ctx, ban); err != nil {
+		return nil, fmt.Errorf("failed to store ban: %w", err)
+	}
+
+	s.mu.Lock()
+	s.cache[subject] = cachedBan{ban: ban, until: now.Add(banCacheTTL)}
+	s.issued[signal]++
+	s.mu.Unlock()
+
+	s.logger.Warn("Banned client",
+		zap.String("subject", subject),
+		zap.String("signal", signal),
+		zap.String("reason", reason),
+		zap.Int("strike", strike),
+		zap.Duration("duration", duration))
+	return ban, nil
+}
+
+// escalate doubles the ban for every strike, up to the maximum
+func (s *Service) escalate(strike int) time.Duration {
+	d := s.banDuration
+	for i := 1; i < strike && d < s.maxBan; i++ {
+		d *= 2
+	}
+	if d > s.maxBan {
+		d = s.maxBan
+	}
+	return d
+}
+
+// Unban lifts the ban on subject and forgets its strikes
+func (s *Service) Unban(ctx context.Context, subject string) error {
+	if err := ValidSubject(subject); err != nil {
+		return err
+	}
+	if err := s.store.remove(ctx, subject); err != nil {
+		return fmt.Errorf("failed to lift ban: %w", err)
+	}
+
+	s.mu.Lock()
+	delete(s.cache, subject)
+	s.mu.Unlock()
+	return nil
+}
+
+// List returns the active bans, the longest first
+func (s *Service) List(ctx context.Context) ([]Ban, error) {
+	bans, err := s.store.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bans: %w", err)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].ExpiresAt.After(bans[j].ExpiresAt)
+	})
+	return bans, nil
+}
+
+func (s *Service) remember(subject string, ban *Ban, now time.Time) {
+	s.mu.Lock()
+	s.cache[subject] = cachedBan{ban: ban, until: now.Add(banCacheTTL)}
+	s.mu.Unlock()
+}
+
+// Run drops stale counters and cached lookups until ctx is done, so a
+// spray of addresses does not grow them without bound
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.prune(now)
+		}
+	}
+}
+
+func (s *Service) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, c := range s.counters {
+		signal, _, _ := strings.Cut(key, "|")
+		if now.Sub(c.start) >= s.rules[signal].window {
+			delete(s.counters, key)
+		}
+	}
+	for subject, cached := range s.cache {
+		if now.After(cached.until) {
+			delete(s.cache, subject)
+		}
+	}
+}
+
+// WriteMetrics renders the bans issued by this instance in the Prometheus
+// text format
+func (s *Service) WriteMetrics(w io.Writer) error {
+	s.mu.Lock()
+	signals := make([]string, 0, len(s.issued))
+	for signal := range s.issued {
+		signals = append(signals, signal)
+	}
+	sort.Strings(signals)
+
+	lines := []string{
+		"# HELP webtunnel_abuse_bans_total Bans issued by this instance, by the signal that caused them.",
+		"# TYPE webtunnel_abuse_bans_total counter",
+	}
+	for _, signal := range signals {
+		lines = append(lines, fmt.Sprintf("webtunnel_abuse_bans_total{signal=%q} %d", signal, s.issued[signal]))
+	}
+	s.mu.Unlock()
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
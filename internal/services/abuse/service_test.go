@@ -0,0 +1,166 @@
+package abuse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+func testConfig() config.AbuseConfig {
+	return config.AbuseConfig{
+		Enabled:        true,
+		Reconnect:      config.AbuseRule{Limit: 3, Window: "1m"},
+		AuthFailure:    config.AbuseRule{Limit: 2, Window: "1m"},
+		BanDuration:    "1m",
+		MaxBanDuration: "5m",
+		StrikeTTL:      "1h",
+	}
+}
+
+func TestObserve(t *testing.T) {
+	service, err := New(testConfig(), nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+	alice, bob := User("alice"), User("bob")
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, service.Observe(ctx, SignalReconnect, alice))
+	}
+	ban := service.Observe(ctx, SignalReconnect, alice)
+	require.NotNil(t, ban)
+	assert.Equal(t, SignalReconnect, ban.Signal)
+	assert.Equal(t, 1, ban.Strike)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), ban.ExpiresAt, time.Second)
+
+	assert.NotNil(t, service.Check(ctx, bob, alice))
+	assert.Nil(t, service.Check(ctx, bob))
+
+	// Input has no rule, so it is never counted
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, service.Observe(ctx, SignalInput, bob))
+	}
+	assert.True(t, service.AllowInput("bob"))
+	assert.False(t, service.AllowInput("alice"))
+
+	var metrics strings.Builder
+	require.NoError(t, service.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), `webtunnel_abuse_bans_total{signal="reconnect"} 1`)
+}
+
+func TestDisabled(t *testing.T) {
+	cfg := testConfig()
+	cfg.Enabled = false
+	service, err := New(cfg, nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, service.Observe(ctx, SignalAuthFailure, IP("10.0.0.1")))
+	}
+
+	// Manual bans still apply
+	_, err = service.Ban(ctx, IP("10.0.0.1"), SignalManual, "test", time.Minute)
+	require.NoError(t, err)
+	assert.NotNil(t, service.Check(ctx, IP("10.0.0.1")))
+}
+
+func TestEscalation(t *testing.T) {
+	service, err := New(testConfig(), nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+	subject := IP("10.0.0.1")
+
+	var durations []time.Duration
+	for i := 0; i < 5; i++ {
+		ban, err := service.Ban(ctx, subject, SignalAuthFailure, "", 0)
+		require.NoError(t, err)
+		durations = append(durations, ban.ExpiresAt.Sub(ban.CreatedAt))
+	}
+	assert.Equal(t, []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}, durations)
+
+	bans, err := service.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+	assert.Equal(t, 5, bans[0].Strike)
+
+	// Lifting a ban forgets the strikes
+	require.NoError(t, service.Unban(ctx, subject))
+	assert.Nil(t, service.Check(ctx, subject))
+	ban, err := service.Ban(ctx, subject, SignalAuthFailure, "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ban.Strike)
+}
+
+func TestValidation(t *testing.T) {
+	service, err := New(testConfig(), nil, zap.NewNop())
+	require.NoError(t, err)
+
+	for _, subject := range []string{"", "10.0.0.1", "ip:", "host:example.com"} {
+		_, err := service.Ban(context.Background(), subject, SignalManual, "", time.Minute)
+		assert.ErrorIs(t, err, ErrInvalidSubject, subject)
+		assert.ErrorIs(t, service.Unban(context.Background(), subject), ErrInvalidSubject, subject)
+	}
+
+	cfg := testConfig()
+	cfg.Input = config.AbuseRule{Limit: 10, Window: "often"}
+	_, err = New(cfg, nil, zap.NewNop())
+	assert.Error(t, err)
+
+	cfg = testConfig()
+	cfg.BanDuration = "-1m"
+	_, err = New(cfg, nil, zap.NewNop())
+	assert.Error(t, err)
+}
+
+// TestRedisStore runs against the Redis in WEBTUNNEL_TEST_REDIS_URL
+func TestRedisStore(t *testing.T) {
+	url := os.Getenv("WEBTUNNEL_TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("WEBTUNNEL_TEST_REDIS_URL not set")
+	}
+	opts, err := redis.ParseURL(url)
+	require.NoError(t, err)
+	rdb := redis.NewClient(opts)
+	t.Cleanup(func() { rdb.Close() })
+
+	service, err := New(testConfig(), rdb, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+	subject := User(fmt.Sprintf("test-%d", time.Now().UnixNano()))
+	t.Cleanup(func() { service.Unban(ctx, subject) })
+
+	_, err = service.Ban(ctx, subject, SignalManual, "test", time.Minute)
+	require.NoError(t, err)
+	second, err := service.Ban(ctx, subject, SignalManual, "test", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, second.Strike)
+
+	// Another instance sees the ban
+	other, err := New(testConfig(), rdb, zap.NewNop())
+	require.NoError(t, err)
+	ban := other.Check(ctx, subject)
+	require.NotNil(t, ban)
+	assert.Equal(t, "test", ban.Reason)
+
+	bans, err := other.List(ctx)
+	require.NoError(t, err)
+	found := false
+	for _, b := range bans {
+		found = found || b.Subject == subject
+	}
+	assert.True(t, found)
+
+	require.NoError(t, other.Unban(ctx, subject))
+	stored, err := other.store.get(ctx, subject)
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+}
@@ -0,0 +1,200 @@
+package abuse
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// store keeps bans and strike counts
+type store interface {
+	// get returns nil when subject is not banned
+	get(ctx context.Context, subject string) (*Ban, error)
+	put(ctx context.Context, ban *Ban) error
+	// remove lifts the ban and forgets the strikes
+	remove(ctx context.Context, subject string) error
+	list(ctx context.Context) ([]Ban, error)
+	// strike counts a ban and returns how many are remembered; the count
+	// expires ttl after the latest one
+	strike(ctx context.Context, subject string, ttl time.Duration) (int, error)
+}
+
+// Bans are stored under abuse:ban:<subject> and expire with the ban; the
+// sorted set abuse:bans indexes them by expiry for listing. As with the
+// session index, no command spans keys, so this works on a cluster.
+const banIndexKey = "abuse:bans"
+
+func banKey(subject string) string {
+	return "abuse:ban:" + subject
+}
+
+func strikeKey(subject string) string {
+	return "abuse:strikes:" + subject
+}
+
+type redisStore struct {
+	redis redis.UniversalClient
+}
+
+func (r *redisStore) get(ctx context.Context, subject string) (*Ban, error) {
+	data, err := r.redis.Get(ctx, banKey(subject)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ban Ban
+	if err := json.Unmarshal(data, &ban); err != nil {
+		return nil, err
+	}
+	return &ban, nil
+}
+
+func (r *redisStore) put(ctx context.Context, ban *Ban) error {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+	_, err = r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, banKey(ban.Subject), data, time.Until(ban.ExpiresAt))
+		pipe.ZAdd(ctx, banIndexKey, redis.Z{Score: float64(ban.ExpiresAt.UnixMilli()), Member: ban.Subject})
+		return nil
+	})
+	return err
+}
+
+func (r *redisStore) remove(ctx context.Context, subject string) error {
+	_, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, banKey(subject))
+		pipe.Del(ctx, strikeKey(subject))
+		pipe.ZRem(ctx, banIndexKey, subject)
+		return nil
+	})
+	return err
+}
+
+func (r *redisStore) list(ctx context.Context) ([]Ban, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := r.redis.ZRemRangeByScore(ctx, banIndexKey, "-inf", now).Err(); err != nil {
+		return nil, err
+	}
+	subjects, err := r.redis.ZRange(ctx, banIndexKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	gets := make([]*redis.StringCmd, len(subjects))
+	if _, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, subject := range subjects {
+			gets[i] = pipe.Get(ctx, banKey(subject))
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	bans := []Ban{}
+	for _, get := range gets {
+		data, err := get.Bytes()
+		if err != nil {
+			// Lifted or expired since the index was read
+			continue
+		}
+		var ban Ban
+		if err := json.Unmarshal(data, &ban); err == nil {
+			bans = append(bans, ban)
+		}
+	}
+	return bans, nil
+}
+
+func (r *redisStore) strike(ctx context.Context, subject string, ttl time.Duration) (int, error) {
+	var incr *redis.IntCmd
+	if _, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, strikeKey(subject))
+		pipe.Expire(ctx, strikeKey(subject), ttl)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+// memoryStore serves a single instance without Redis
+type memoryStore struct {
+	mu      sync.Mutex
+	bans    map[string]Ban
+	strikes map[string]memoryStrike
+}
+
+type memoryStrike struct {
+	count   int
+	expires time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		bans:    make(map[string]Ban),
+		strikes: make(map[string]memoryStrike),
+	}
+}
+
+func (m *memoryStore) get(ctx context.Context, subject string) (*Ban, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ban, ok := m.bans[subject]
+	if !ok || time.Now().After(ban.ExpiresAt) {
+		delete(m.bans, subject)
+		return nil, nil
+	}
+	return &ban, nil
+}
+
+func (m *memoryStore) put(ctx context.Context, ban *Ban) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bans[ban.Subject] = *ban
+	return nil
+}
+
+func (m *memoryStore) remove(ctx context.Context, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bans, subject)
+	delete(m.strikes, subject)
+	return nil
+}
+
+func (m *memoryStore) list(ctx context.Context) ([]Ban, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	bans := []Ban{}
+	for subject, ban := range m.bans {
+		if now.After(ban.ExpiresAt) {
+			delete(m.bans, subject)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+func (m *memoryStore) strike(ctx context.Context, subject string, ttl time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	s := m.strikes[subject]
+	if now.After(s.expires) {
+		s.count = 0
+	}
+	s.count++
+	s.expires = now.Add(ttl)
+	m.strikes[subject] = s
+	return s.count, nil
+}
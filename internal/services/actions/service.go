@@ -0,0 +1,284 @@
+package actions
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/webtunnel/internal/services/snippets"
+	"github.com/yourusername/webtunnel/internal/services/sshkeys"
+	"github.com/yourusername/webtunnel/internal/services/templates"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Kinds of action
+const (
+	KindNewSession    = "new-session"
+	KindStartTemplate = "start-template"
+	KindConnectHost   = "connect-host"
+	KindRunSnippet    = "run-snippet"
+)
+
+// Where a parameter goes
+const (
+	InBody    = "body"    // a field of the JSON body; dots name nested fields
+	InSession = "session" // the session whose WebSocket the Frame is sent on
+)
+
+// Param is a value the caller supplies to run an action
+type Param struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Type        string `json:"type"` // string or session
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Action is something the caller may do, with the request that does it:
+// Method and Path with the fixed fields of a JSON Body, or a Frame to send
+// on an attached session's WebSocket. Params are filled in by the caller,
+// e.g. from a prompt in a command palette.
+type Action struct {
+	ID          string                 `json:"id"`
+	Kind        string                 `json:"kind"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Method      string                 `json:"method,omitempty"`
+	Path        string                 `json:"path,omitempty"`
+	Body        map[string]interface{} `json:"body,omitempty"`
+	Frame       map[string]string      `json:"frame,omitempty"` // type and data of a protocol.Message
+	Params      []Param                `json:"params,omitempty"`
+}
+
+// Filter narrows a listing. Query matches titles and descriptions,
+// ignoring case.
+type Filter struct {
+	Kind  string
+	Query string
+}
+
+// TemplateLister lists the session templates admins defined
+type TemplateLister interface {
+	List(ctx context.Context) ([]*templates.Template, error)
+}
+
+// SnippetLister lists the snippets a user can run
+type SnippetLister interface {
+	List(ctx context.Context, userID string) ([]*snippets.Snippet, error)
+}
+
+// HostLister lists the SSH hosts the server knows keys for
+type HostLister interface {
+	KnownHosts(ctx context.Context) ([]*sshkeys.HostKey, error)
+}
+
+// Service lists the actions each caller may take, from the templates,
+// known hosts and snippets there are and what the authorizer allows. Any
+// source may be nil; a source that fails leaves its actions out.
+type Service struct {
+	templates  TemplateLister
+	snippets   SnippetLister
+	hosts      HostLister
+	authorizer terminal.Authorizer
+	logger     *zap.Logger
+}
+
+func New(templates TemplateLister, snippets SnippetLister, hosts HostLister, authorizer terminal.Authorizer, logger *zap.Logger) *Service {
+	return &Service{
+		templates:  templates,
+		snippets:   snippets,
+		hosts:      hosts,
+		authorizer: authorizer,
+		logger:     logger,
+	}
+}
+
+// List returns the actions the user may take that match the filter,
+// grouped by kind
+func (s *Service) List(ctx context.Context, userID string, filter Filter) []Action {
+	var result []Action
+	add := func(kind string, build func() []Action) {
+		if filter.Kind != "" && filter.Kind != kind {
+			return
+		}
+		for _, action := range build() {
+			if filter.matches(action) {
+				result = append(result, action)
+			}
+		}
+	}
+	add(KindNewSession, func() []Action { return s.newSession(ctx, userID) })
+	add(KindStartTemplate, func() []Action { return s.startTemplates(ctx, userID) })
+	add(KindConnectHost, func() []Action { return s.connectHosts(ctx, userID) })
+	add(KindRunSnippet, func() []Action { return s.runSnippets(ctx, userID) })
+	if result == nil {
+		result = []Action{}
+	}
+	return result
+}
+
+func (f Filter) matches(action Action) bool {
+	if f.Query == "" {
+		return true
+	}
+	query := strings.ToLower(f.Query)
+	return strings.Contains(strings.ToLower(action.Title), query) ||
+		strings.Contains(strings.ToLower(action.Description), query)
+}
+
+// allowed asks the authorizer, as session creation would
+func (s *Service) allowed(ctx context.Context, userID, action string, resource map[string]interface{}) bool {
+	if s.authorizer == nil {
+		return true
+	}
+	allowed, _ := s.authorizer.Authorize(ctx, userID, action, resource)
+	return allowed
+}
+
+func (s *Service) newSession(ctx context.Context, userID string) []Action {
+	if !s.allowed(ctx, userID, "session.create", map[string]interface{}{"command": "bash", "working_dir": ""}) {
+		return nil
+	}
+	return []Action{{
+		ID:     KindNewSession,
+		Kind:   KindNewSession,
+		Title:  "New session",
+		Method: http.MethodPost,
+		Path:   "/api/v1/sessions",
+		Body:   map[string]interface{}{"command": "bash"},
+		Params: []Param{
+			{Name: "command", In: InBody, Type: "string", Description: "command to run instead of a shell"},
+			{Name: "working_dir", In: InBody, Type: "string"},
+			{Name: "name", In: InBody, Type: "string"},
+		},
+	}}
+}
+
+func (s *Service) startTemplates(ctx context.Context, userID string) []Action {
+	if s.templates == nil {
+		return nil
+	}
+	list, err := s.templates.List(ctx)
+	if err != nil {
+		s.logger.Debug("Leaving templates out of actions", zap.Error(err))
+		return nil
+	}
+	var result []Action
+	for _, t := range list {
+		resource := map[string]interface{}{
+			"command":     t.Command,
+			"working_dir": t.WorkingDir,
+			"template":    t.ID,
+		}
+		if !s.allowed(ctx, userID, "session.create", resource) {
+			continue
+		}
+		result = append(result, Action{
+			ID:          KindStartTemplate + ":" + t.ID,
+			Kind:        KindStartTemplate,
+			Title:       "Start " + t.Name,
+			Description: t.Description,
+			Method:      http.MethodPost,
+			Path:        "/api/v1/sessions",
+			Body:        map[string]interface{}{"template_id": t.ID},
+			Params:      []Param{{Name: "name", In: InBody, Type: "string"}},
+		})
+	}
+	return result
+}
+
+func (s *Service) connectHosts(ctx context.Context, userID string) []Action {
+	if s.hosts == nil {
+		return nil
+	}
+	keys, err := s.hosts.KnownHosts(ctx)
+	if err != nil {
+		s.logger.Debug("Leaving known hosts out of actions", zap.Error(err))
+		return nil
+	}
+	// A host may be known by several key types
+	seen := make(map[string]bool)
+	var result []Action
+	for _, key := range keys {
+		if seen[key.Host] {
+			continue
+		}
+		seen[key.Host] = true
+		host, port := splitKnownHost(key.Host)
+		resource := map[string]interface{}{"command": "ssh", "working_dir": "", "ssh_host": host}
+		if !s.allowed(ctx, userID, "session.create", resource) {
+			continue
+		}
+		target := map[string]interface{}{"host": host}
+		title := "Connect to " + host
+		if port != 22 {
+			target["port"] = port
+			title += ":" + strconv.Itoa(port)
+		}
+		result = append(result, Action{
+			ID:     KindConnectHost + ":" + key.Host,
+			Kind:   KindConnectHost,
+			Title:  title,
+			Method: http.MethodPost,
+			Path:   "/api/v1/sessions",
+			Body:   map[string]interface{}{"ssh": target},
+			Params: []Param{
+				{Name: "ssh.user", In: InBody, Type: "string", Required: true},
+				{Name: "ssh.key", In: InBody, Type: "string", Required: true, Description: "ID or name of one of your SSH keys"},
+			},
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Title < result[j].Title })
+	return result
+}
+
+// splitKnownHost undoes the known_hosts form, host or [host]:port
+func splitKnownHost(known string) (string, int) {
+	if strings.HasPrefix(known, "[") {
+		if host, port, err := net.SplitHostPort(known); err == nil {
+			if n, err := strconv.Atoi(port); err == nil {
+				return strings.Trim(host, "[]"), n
+			}
+		}
+	}
+	return known, 22
+}
+
+func (s *Service) runSnippets(ctx context.Context, userID string) []Action {
+	if s.snippets == nil {
+		return nil
+	}
+	list, err := s.snippets.List(ctx, userID)
+	if err != nil {
+		s.logger.Debug("Leaving snippets out of actions", zap.Error(err))
+		return nil
+	}
+	var result []Action
+	for _, snippet := range list {
+		// Each line is checked against the command policy when it runs
+		result = append(result, Action{
+			ID:          KindRunSnippet + ":" + snippet.ID,
+			Kind:        KindRunSnippet,
+			Title:       "Run " + snippet.Name,
+			Description: firstLine(snippet.Content),
+			Frame:       map[string]string{"type": protocol.TypeRunSnippet, "data": snippet.Name},
+			Params:      []Param{{Name: "session", In: InSession, Type: "session", Required: true}},
+		})
+	}
+	return result
+}
+
+// firstLine is the first line of a snippet that is not blank or a comment
+func firstLine(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			return line
+		}
+	}
+	return ""
+}
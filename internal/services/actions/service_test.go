@@ -0,0 +1,128 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/snippets"
+	"github.com/yourusername/webtunnel/internal/services/sshkeys"
+	"github.com/yourusername/webtunnel/internal/services/templates"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+type fakeTemplates []*templates.Template
+
+func (f fakeTemplates) List(ctx context.Context) ([]*templates.Template, error) {
+	return f, nil
+}
+
+type fakeSnippets map[string][]*snippets.Snippet
+
+func (f fakeSnippets) List(ctx context.Context, userID string) ([]*snippets.Snippet, error) {
+	return f[userID], nil
+}
+
+type fakeHosts struct {
+	keys []*sshkeys.HostKey
+	err  error
+}
+
+func (f fakeHosts) KnownHosts(ctx context.Context) ([]*sshkeys.HostKey, error) {
+	return f.keys, f.err
+}
+
+// fakeAuthorizer lets everyone do everything but start the "prod"
+// template or reach db.internal, which only admin may
+type fakeAuthorizer struct{}
+
+func (fakeAuthorizer) Authorize(ctx context.Context, userID, action string, resource map[string]interface{}) (bool, string) {
+	if userID == "admin" {
+		return true, ""
+	}
+	if resource["template"] == "tpl-prod" || resource["ssh_host"] == "db.internal" {
+		return false, "denied"
+	}
+	return true, ""
+}
+
+func newTestService(hosts HostLister) *Service {
+	return New(
+		fakeTemplates{
+			{ID: "tpl-dev", Name: "dev", Description: "Development shell"},
+			{ID: "tpl-prod", Name: "prod", Command: "prod-console"},
+		},
+		fakeSnippets{
+			"user123": {{ID: "snip-1", Name: "deploy", Content: "# ship it\nmake deploy\n"}},
+		},
+		hosts,
+		fakeAuthorizer{},
+		zap.NewNop(),
+	)
+}
+
+func ids(list []Action) []string {
+	result := []string{}
+	for _, action := range list {
+		result = append(result, action.ID)
+	}
+	return result
+}
+
+func TestList(t *testing.T) {
+	service := newTestService(fakeHosts{keys: []*sshkeys.HostKey{
+		{Host: "web.internal", Type: "ssh-ed25519"},
+		{Host: "web.internal", Type: "ssh-rsa"},
+		{Host: "[bastion.example.com]:2222", Type: "ssh-ed25519"},
+		{Host: "db.internal", Type: "ssh-ed25519"},
+	}})
+	ctx := context.Background()
+
+	// Only what the caller may do is offered
+	list := service.List(ctx, "user123", Filter{})
+	assert.Equal(t, []string{
+		"new-session",
+		"start-template:tpl-dev",
+		"connect-host:[bastion.example.com]:2222",
+		"connect-host:web.internal",
+		"run-snippet:snip-1",
+	}, ids(list))
+	assert.Contains(t, ids(service.List(ctx, "admin", Filter{})), "start-template:tpl-prod")
+	assert.Contains(t, ids(service.List(ctx, "admin", Filter{})), "connect-host:db.internal")
+
+	template := list[1]
+	assert.Equal(t, "POST", template.Method)
+	assert.Equal(t, "/api/v1/sessions", template.Path)
+	assert.Equal(t, map[string]interface{}{"template_id": "tpl-dev"}, template.Body)
+
+	bastion := list[2]
+	assert.Equal(t, "Connect to bastion.example.com:2222", bastion.Title)
+	assert.Equal(t, map[string]interface{}{"host": "bastion.example.com", "port": 2222}, bastion.Body["ssh"])
+	require.Len(t, bastion.Params, 2)
+	assert.True(t, bastion.Params[0].Required)
+
+	snippet := list[4]
+	assert.Equal(t, "make deploy", snippet.Description)
+	assert.Equal(t, map[string]string{"type": protocol.TypeRunSnippet, "data": "deploy"}, snippet.Frame)
+	assert.Equal(t, InSession, snippet.Params[0].In)
+
+	// Filters
+	assert.Equal(t, []string{"start-template:tpl-dev"}, ids(service.List(ctx, "user123", Filter{Kind: KindStartTemplate})))
+	assert.Equal(t, []string{"start-template:tpl-dev"}, ids(service.List(ctx, "user123", Filter{Query: "DEVELOP"})))
+	assert.Empty(t, service.List(ctx, "user123", Filter{Query: "nothing like it"}))
+	assert.Empty(t, ids(service.List(ctx, "other", Filter{Kind: KindRunSnippet})))
+}
+
+func TestListSourceFails(t *testing.T) {
+	service := newTestService(fakeHosts{err: errors.New("database is down")})
+	list := service.List(context.Background(), "user123", Filter{})
+	assert.NotContains(t, ids(list), "connect-host:web.internal")
+	assert.Contains(t, ids(list), "start-template:tpl-dev")
+
+	// Nothing configured still offers a new session
+	bare := New(nil, nil, nil, nil, zap.NewNop())
+	assert.Equal(t, []string{"new-session"}, ids(bare.List(context.Background(), "user123", Filter{})))
+}
@@ -0,0 +1,335 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	manifestFile = "manifest.json"
+	filesDir     = "files"
+
+	pruneInterval = time.Hour
+
+	// Used when the config leaves them zero
+	defaultMaxFileMB  = 100
+	defaultMaxTotalMB = 500
+	defaultMaxFiles   = 100
+)
+
+var (
+	ErrDisabled = errors.New("artifacts are not enabled")
+	ErrNotFound = errors.New("artifact not found")
+)
+
+// execIDs are the terminal service's session IDs
+var validExecID = regexp.MustCompile(`^sess_[0-9]+_[0-9a-f]+$`)
+
+// Manifest is what was kept from one command
+type Manifest struct {
+	ExecID    string              `json:"exec_id"`
+	UserID    string              `json:"user_id"`
+	CreatedAt time.Time           `json:"created_at"`
+	Artifacts []terminal.Artifact `json:"artifacts"`
+}
+
+// Service keeps the files one-shot commands declare as artifacts, copied
+// out of the command's directory before it is removed. Each command's
+// files are kept until the retention passes:
+//
+//	<dir>/<exec id>/manifest.json
+//	<dir>/<exec id>/files/<name>
+type Service struct {
+	dir       string
+	maxFile   int64
+	maxTotal  int64
+	maxFiles  int
+	retention time.Duration
+	logger    *zap.Logger
+
+	now func() time.Time
+}
+
+func New(cfg config.ArtifactsConfig, logger *zap.Logger) (*Service, error) {
+	if cfg.MaxFileMB <= 0 {
+		cfg.MaxFileMB = defaultMaxFileMB
+	}
+	if cfg.MaxTotalMB <= 0 {
+		cfg.MaxTotalMB = defaultMaxTotalMB
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = defaultMaxFiles
+	}
+	s := &Service{
+		dir:       cfg.Dir,
+		maxFile:   int64(cfg.MaxFileMB) << 20,
+		maxTotal:  int64(cfg.MaxTotalMB) << 20,
+		maxFiles:  cfg.MaxFiles,
+		retention: 7 * 24 * time.Hour,
+		logger:    logger,
+		now:       time.Now,
+	}
+	if cfg.Retention != "" {
+		retention, err := time.ParseDuration(cfg.Retention)
+		if err != nil || retention <= 0 {
+			return nil, fmt.Errorf("invalid artifacts retention %q", cfg.Retention)
+		}
+		s.retention = retention
+	}
+	if s.dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	return s, nil
+}
+
+// Enabled reports whether an artifact directory is configured
+func (s *Service) Enabled() bool {
+	return s.dir != ""
+}
+
+// Collect copies the regular files under dir whose path matches one of
+// globs, as filepath.Match does, so * does not cross directories.
+// Symlinks are never followed. Matches past the size or count limits are
+// listed as skipped rather than failing the rest.
+func (s *Service) Collect(execID, userID, dir string, globs []string) ([]terminal.Artifact, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if !validExecID.MatchString(execID) {
+		return nil, fmt.Errorf("invalid exec ID %q", execID)
+	}
+
+	var matches []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		for _, glob := range globs {
+			if ok, _ := filepath.Match(glob, name); ok {
+				matches = append(matches, name)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find artifacts: %w", err)
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	root := filepath.Join(s.dir, execID)
+	manifest := &Manifest{ExecID: execID, UserID: userID, CreatedAt: s.now()}
+	var total int64
+	var count int
+	for _, name := range matches {
+		artifact := terminal.Artifact{Name: name}
+		if count >= s.maxFiles {
+			artifact.Skipped = fmt.Sprintf("more than %d files", s.maxFiles)
+			manifest.Artifacts = append(manifest.Artifacts, artifact)
+			continue
+		}
+		size, sum, err := s.copy(filepath.Join(dir, name), filepath.Join(root, filesDir, name), s.maxTotal-total)
+		artifact.Size = size
+		switch {
+		case errors.Is(err, errFileTooLarge):
+			artifact.Skipped = fmt.Sprintf("larger than %d MB", s.maxFile>>20)
+		case errors.Is(err, errTotalTooLarge):
+			artifact.Skipped = fmt.Sprintf("over the %d MB total", s.maxTotal>>20)
+		case err != nil:
+			s.logger.Warn("Failed to collect artifact",
+				zap.String("exec_id", execID), zap.String("name", name), zap.Error(err))
+			artifact.Skipped = "could not be read"
+		default:
+			total += size
+			count++
+			artifact.SHA256 = sum
+			artifact.URL = downloadURL(execID, name)
+		}
+		manifest.Artifacts = append(manifest.Artifacts, artifact)
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(root, manifestFile), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save artifact manifest: %w", err)
+	}
+	return manifest.Artifacts, nil
+}
+
+var (
+	errFileTooLarge  = errors.New("file over the size limit")
+	errTotalTooLarge = errors.New("files over the total size limit")
+)
+
+// copy copies one file and returns its size and SHA-256. room is what is
+// left of the total; nothing is kept of a file that does not fit.
+func (s *Service) copy(src, dst string, room int64) (int64, string, error) {
+	// The command's leftovers may still be running and swap the file for
+	// a symlink
+	in, err := os.OpenFile(src, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, "", fmt.Errorf("%s is not a regular file", src)
+	}
+	if info.Size() > s.maxFile {
+		return info.Size(), "", errFileTooLarge
+	}
+	if info.Size() > room {
+		return info.Size(), "", errTotalTooLarge
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, "", err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, "", err
+	}
+	hash := sha256.New()
+	// The file may still be growing; the limits hold for what is copied
+	limit := min(s.maxFile, room)
+	size, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(in, limit+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > limit {
+		err = errFileTooLarge
+		if limit < s.maxFile {
+			err = errTotalTooLarge
+		}
+	}
+	if err != nil {
+		os.Remove(dst)
+		return size, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Manifest returns what was kept from a command
+func (s *Service) Manifest(execID string) (*Manifest, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if !validExecID.MatchString(execID) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, execID, manifestFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to read artifact manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// Open returns a kept file for download and its entry. Only names in the
+// manifest are served.
+func (s *Service) Open(manifest *Manifest, name string) (*os.File, *terminal.Artifact, error) {
+	for i := range manifest.Artifacts {
+		artifact := &manifest.Artifacts[i]
+		if artifact.Name != name || artifact.Skipped != "" {
+			continue
+		}
+		file, err := os.Open(filepath.Join(s.dir, manifest.ExecID, filesDir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, ErrNotFound
+		}
+		return file, artifact, err
+	}
+	return nil, nil, ErrNotFound
+}
+
+// Run removes artifacts past the retention until ctx is done
+func (s *Service) Run(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		s.prune()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) prune() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		s.logger.Warn("Failed to list artifacts", zap.Error(err))
+		return
+	}
+	cutoff := s.now().Add(-s.retention)
+	for _, entry := range entries {
+		manifest, err := s.Manifest(entry.Name())
+		if err != nil {
+			// Left by a collection that failed; judged by its age instead
+			info, statErr := entry.Info()
+			if statErr != nil || !info.ModTime().Before(cutoff) {
+				continue
+			}
+		} else if !manifest.CreatedAt.Before(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, entry.Name())); err != nil {
+			s.logger.Warn("Failed to remove artifacts", zap.String("exec_id", entry.Name()), zap.Error(err))
+		}
+	}
+}
+
+func downloadURL(execID, name string) string {
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return "/api/v1/artifacts/" + execID + "/" + strings.Join(parts, "/")
+}
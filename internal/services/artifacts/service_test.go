@@ -0,0 +1,111 @@
+package artifacts
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+const execID = "sess_1700000000_0123456789ab"
+
+func writeFile(t *testing.T, path string, size int) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+}
+
+func TestCollect(t *testing.T) {
+	service, err := New(config.ArtifactsConfig{Dir: t.TempDir(), MaxFileMB: 1, MaxTotalMB: 2, MaxFiles: 4}, zap.NewNop())
+	require.NoError(t, err)
+
+	work := t.TempDir()
+	writeFile(t, filepath.Join(work, "dist", "a.tar.gz"), 10)
+	writeFile(t, filepath.Join(work, "dist", "big.tar.gz"), 1<<20+1)
+	writeFile(t, filepath.Join(work, "dist", "c.tar.gz"), 1<<20)
+	writeFile(t, filepath.Join(work, "dist", "d.tar.gz"), 1<<20)
+	writeFile(t, filepath.Join(work, "dist", "e.tar.gz"), 1)
+	writeFile(t, filepath.Join(work, "dist", "g.tar.gz"), 1)
+	writeFile(t, filepath.Join(work, "dist", "nested", "f.tar.gz"), 1)
+	writeFile(t, filepath.Join(work, "build.log"), 5)
+	require.NoError(t, os.Symlink("/etc/passwd", filepath.Join(work, "dist", "passwd.tar.gz")))
+
+	kept, err := service.Collect(execID, "alice", work, []string{"dist/*.tar.gz", "build.log"})
+	require.NoError(t, err)
+
+	byName := map[string]string{}
+	for _, artifact := range kept {
+		byName[artifact.Name] = artifact.Skipped
+	}
+	assert.Equal(t, map[string]string{
+		"build.log":       "",
+		"dist/a.tar.gz":   "",
+		"dist/big.tar.gz": "larger than 1 MB",
+		"dist/c.tar.gz":   "",
+		"dist/d.tar.gz":   "over the 2 MB total",
+		"dist/e.tar.gz":   "",
+		"dist/g.tar.gz":   "more than 4 files",
+	}, byName, "* does not cross directories and symlinks are not followed")
+	assert.Equal(t, "/api/v1/artifacts/"+execID+"/dist/a.tar.gz", kept[1].URL)
+
+	manifest, err := service.Manifest(execID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", manifest.UserID)
+
+	file, artifact, err := service.Open(manifest, "dist/a.tar.gz")
+	require.NoError(t, err)
+	data, _ := io.ReadAll(file)
+	file.Close()
+	assert.Len(t, data, 10)
+	assert.Equal(t, "01d448afd928065458cf670b60f5a594d735af0172c8d67f22a81680132681ca", artifact.SHA256)
+
+	_, _, err = service.Open(manifest, "dist/big.tar.gz")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, _, err = service.Open(manifest, "../manifest.json")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = service.Manifest("../" + execID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Nothing matched, nothing kept
+	kept, err = service.Collect("sess_1700000000_0123456789ac", "alice", work, []string{"*.zip"})
+	require.NoError(t, err)
+	assert.Empty(t, kept)
+	_, err = service.Manifest("sess_1700000000_0123456789ac")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestPrune(t *testing.T) {
+	service, err := New(config.ArtifactsConfig{Dir: t.TempDir(), Retention: "1h"}, zap.NewNop())
+	require.NoError(t, err)
+
+	work := t.TempDir()
+	writeFile(t, filepath.Join(work, "out.txt"), 1)
+	_, err = service.Collect(execID, "alice", work, []string{"out.txt"})
+	require.NoError(t, err)
+
+	service.prune()
+	_, err = service.Manifest(execID)
+	require.NoError(t, err, "kept within the retention")
+
+	service.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	service.prune()
+	_, err = service.Manifest(execID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDisabled(t *testing.T) {
+	service, err := New(config.ArtifactsConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, service.Enabled())
+	_, err = service.Collect(execID, "alice", t.TempDir(), []string{"*"})
+	assert.ErrorIs(t, err, ErrDisabled)
+
+	_, err = New(config.ArtifactsConfig{Retention: "forever"}, zap.NewNop())
+	assert.Error(t, err)
+}
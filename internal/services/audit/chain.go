@@ -0,0 +1,447 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Hash chaining
+//
+// With the chain on, every entry stores the hash of the chained entry
+// before it and a SHA-256 over that hash and its own fields. Changing or
+// deleting an entry breaks every link after it; deleting the newest ones
+// is caught by anchors, copies of the chain head sent elsewhere.
+
+// chainLock serializes chained writers with a Postgres advisory lock, so
+// each entry links to the one written just before it
+const chainLock = 0x77746175 // "wtau"
+
+// maxReceipt bounds what is kept of an anchor service's response
+const maxReceipt = 4096
+
+// ChainConfig turns on hash chaining and, with an AnchorURL, posts the
+// chain head there every AnchorInterval
+type ChainConfig struct {
+	AnchorURL      string
+	AnchorInterval time.Duration
+}
+
+// Anchor is a chain head as sent to an outside service
+type Anchor struct {
+	ID             int64     `json:"id"`
+	EntryID        int64     `json:"entry_id"`
+	EntryCreatedAt time.Time `json:"entry_created_at"`
+	Hash           string    `json:"hash"`
+	Target         string    `json:"target"`
+	Receipt        string    `json:"receipt,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Proof is the outcome of checking the chain over a time range. Entries
+// counts the chained entries checked; Unchained those written with the
+// chain off, which nothing protects.
+type Proof struct {
+	Since      *time.Time `json:"since,omitempty"`
+	Until      *time.Time `json:"until,omitempty"`
+	Valid      bool       `json:"valid"`
+	Entries    int        `json:"entries"`
+	Unchained  int        `json:"unchained"`
+	FirstID    int64      `json:"first_id,omitempty"`
+	LastID     int64      `json:"last_id,omitempty"`
+	StartHash  string     `json:"start_hash,omitempty"` // the hash the range links back to
+	EndHash    string     `json:"end_hash,omitempty"`
+	Anchors    []*Anchor  `json:"anchors"` // anchors of entries in the range, all confirmed when Valid
+	Break      *Break     `json:"break,omitempty"`
+	VerifiedAt time.Time  `json:"verified_at"`
+}
+
+// Break is the first place the chain does not hold
+type Break struct {
+	EntryID int64  `json:"entry_id"`
+	Reason  string `json:"reason"`
+}
+
+// EnableChain hash-chains entries recorded from now on
+func (s *Service) EnableChain(cfg ChainConfig) {
+	s.chain = &cfg
+}
+
+// chainRecord is what an entry's hash covers
+type chainRecord struct {
+	Prev         string          `json:"prev"`
+	ActorID      string          `json:"actor_id"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id"`
+	Details      json.RawMessage `json:"details"`
+	IPAddress    string          `json:"ip_address"`
+	UserAgent    string          `json:"user_agent"`
+	CreatedAt    string          `json:"created_at"`
+}
+
+// chainHash hashes an entry linked to prev. Details and the IP address are
+// put in the form they read back from Postgres in, so the hash of a stored
+// entry can be recomputed.
+func chainHash(prev string, e *Entry) (string, error) {
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(details, &decoded); err != nil {
+		return "", fmt.Errorf("failed to decode audit details: %w", err)
+	}
+	if details, err = json.Marshal(decoded); err != nil {
+		return "", fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+
+	ip := e.IPAddress
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+
+	data, err := json.Marshal(chainRecord{
+		Prev:         prev,
+		ActorID:      e.ActorID,
+		Action:       e.Action,
+		ResourceType: e.ResourceType,
+		ResourceID:   e.ResourceID,
+		Details:      details,
+		IPAddress:    ip,
+		UserAgent:    e.UserAgent,
+		CreatedAt:    e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recordChained links the entry to the chain head and inserts it
+func (s *Service) recordChained(ctx context.Context, entry *Entry, details []byte) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin audit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", chainLock); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+	var prev string
+	err = tx.QueryRowContext(ctx,
+		"SELECT hash FROM audit_logs WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1").Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+
+	// Postgres keeps microseconds
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	hash, err := chainHash(prev, entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO audit_logs (actor_id, action, resource_type, resource_id, details, ip_address, user_agent, created_at, prev_hash, hash)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, '')::inet, NULLIF($7, ''), $8, NULLIF($9, ''), $10)`,
+		entry.ActorID,
+		entry.Action,
+		entry.ResourceType,
+		entry.ResourceID,
+		details,
+		entry.IPAddress,
+		entry.UserAgent,
+		entry.CreatedAt,
+		prev,
+		hash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// Verify walks the chain over entries created in [since, until), either
+// of which may be zero, recomputing every hash and link and checking the
+// anchors of entries in the range. A broken chain is reported in the
+// proof, not as an error.
+func (s *Service) Verify(ctx context.Context, since, until time.Time) (*Proof, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("audit storage is not configured")
+	}
+
+	proof := &Proof{Valid: true, Anchors: []*Anchor{}}
+	if !since.IsZero() {
+		proof.Since = &since
+	}
+	if !until.IsZero() {
+		proof.Until = &until
+	}
+
+	anchors, err := s.anchors(ctx, since, until)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[int64][]*Anchor)
+	for _, a := range anchors {
+		pending[a.EntryID] = append(pending[a.EntryID], a)
+	}
+
+	where, args := timeRange("created_at", since, until)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, COALESCE(actor_id, ''), action, resource_type, COALESCE(resource_id, ''),
+		       COALESCE(details, '{}'), COALESCE(host(ip_address), ''), COALESCE(user_agent, ''), created_at,
+		       COALESCE(prev_hash, ''), COALESCE(hash, '')
+		FROM audit_logs
+		WHERE %s
+		ORDER BY id`, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	fail := func(id int64, reason string, a ...interface{}) {
+		if proof.Break == nil {
+			proof.Valid = false
+			proof.Break = &Break{EntryID: id, Reason: fmt.Sprintf(reason, a...)}
+		}
+	}
+
+	var last string
+	for rows.Next() {
+		entry := &Entry{}
+		var details []byte
+		var prev, hash string
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.Action,
+			&entry.ResourceType,
+			&entry.ResourceID,
+			&details,
+			&entry.IPAddress,
+			&entry.UserAgent,
+			&entry.CreatedAt,
+			&prev,
+			&hash,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if hash == "" {
+			proof.Unchained++
+			continue
+		}
+		if err := json.Unmarshal(details, &entry.Details); err != nil {
+			return nil, fmt.Errorf("failed to decode audit details: %w", err)
+		}
+
+		if proof.Entries == 0 {
+			// The range links back to the last chained entry before it
+			if last, err = s.chainHeadBefore(ctx, entry.ID); err != nil {
+				return nil, err
+			}
+			proof.FirstID, proof.StartHash = entry.ID, last
+		}
+		proof.Entries++
+		proof.LastID, proof.EndHash = entry.ID, hash
+
+		if prev != last {
+			fail(entry.ID, "entry does not link to the chained entry before it; one was removed or reordered")
+		}
+		want, err := chainHash(prev, entry)
+		if err != nil {
+			return nil, err
+		}
+		if want != hash {
+			fail(entry.ID, "entry does not match its hash; it was changed")
+		}
+		for _, a := range pending[entry.ID] {
+			if a.Hash != hash {
+				fail(entry.ID, "entry does not match anchor %d", a.ID)
+			}
+		}
+		delete(pending, entry.ID)
+		last = hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+
+	// Anchored entries that were not seen have been deleted
+	for _, a := range anchors {
+		if _, missing := pending[a.EntryID]; missing {
+			fail(a.EntryID, "anchored entry is missing (anchor %d)", a.ID)
+		}
+	}
+
+	proof.Anchors = anchors
+	proof.VerifiedAt = time.Now()
+	return proof, nil
+}
+
+// chainHeadBefore returns the hash of the last chained entry before id,
+// or "" at the start of the chain
+func (s *Service) chainHeadBefore(ctx context.Context, id int64) (string, error) {
+	var hash string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT hash FROM audit_logs WHERE hash IS NOT NULL AND id < $1 ORDER BY id DESC LIMIT 1", id).Scan(&hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to read audit chain: %w", err)
+	}
+	return hash, nil
+}
+
+// timeRange is the condition for column in [since, until), either of
+// which may be zero
+func timeRange(column string, since, until time.Time) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if !since.IsZero() {
+		args = append(args, since)
+		conditions = append(conditions, fmt.Sprintf("%s >= $%d", column, len(args)))
+	}
+	if !until.IsZero() {
+		args = append(args, until)
+		conditions = append(conditions, fmt.Sprintf("%s < $%d", column, len(args)))
+	}
+	if len(conditions) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// anchors lists the anchors of entries created in the range, oldest first
+func (s *Service) anchors(ctx context.Context, since, until time.Time) ([]*Anchor, error) {
+	where, args := timeRange("entry_created_at", since, until)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, entry_id, entry_created_at, hash, target, receipt, created_at
+		FROM audit_anchors
+		WHERE `+where+`
+		ORDER BY id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit anchors: %w", err)
+	}
+	defer rows.Close()
+
+	result := []*Anchor{}
+	for rows.Next() {
+		a := &Anchor{}
+		if err := rows.Scan(&a.ID, &a.EntryID, &a.EntryCreatedAt, &a.Hash, &a.Target, &a.Receipt, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit anchor: %w", err)
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+// Run anchors the chain head every AnchorInterval until ctx is done. It
+// returns at once unless chaining is on with an AnchorURL.
+func (s *Service) Run(ctx context.Context) {
+	if s.db == nil || s.chain == nil || s.chain.AnchorURL == "" {
+		return
+	}
+	ticker := time.NewTicker(s.chain.AnchorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.Anchor(ctx); err != nil {
+			s.logger.Warn("Failed to anchor audit chain", zap.Error(err))
+		}
+	}
+}
+
+// Anchor posts the chain head to the anchor URL and records the receipt.
+// It returns nil when there is nothing new to anchor.
+func (s *Service) Anchor(ctx context.Context) (*Anchor, error) {
+	if s.db == nil || s.chain == nil || s.chain.AnchorURL == "" {
+		return nil, fmt.Errorf("audit anchoring is not configured")
+	}
+
+	a := &Anchor{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, created_at, hash FROM audit_logs WHERE hash IS NOT NULL ORDER BY id DESC LIMIT 1",
+	).Scan(&a.EntryID, &a.EntryCreatedAt, &a.Hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+	var anchored int64
+	err = s.db.QueryRowContext(ctx, "SELECT entry_id FROM audit_anchors ORDER BY id DESC LIMIT 1").Scan(&anchored)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to read audit anchors: %w", err)
+	}
+	if anchored == a.EntryID {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"entry_id":   a.EntryID,
+		"created_at": a.EntryCreatedAt,
+		"hash":       a.Hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.chain.AnchorURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid anchor URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to post audit anchor: %w", err)
+	}
+	defer resp.Body.Close()
+	receipt, _ := io.ReadAll(io.LimitReader(resp.Body, maxReceipt))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("anchor service returned %d", resp.StatusCode)
+	}
+
+	// The host only; the URL may carry a token
+	a.Target = s.chain.AnchorURL
+	if u, err := url.Parse(s.chain.AnchorURL); err == nil {
+		a.Target = u.Host
+	}
+	a.Receipt = string(receipt)
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO audit_anchors (entry_id, entry_created_at, hash, target, receipt)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		a.EntryID, a.EntryCreatedAt, a.Hash, a.Target, a.Receipt,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record audit anchor: %w", err)
+	}
+
+	s.logger.Info("Audit chain anchored",
+		zap.Int64("entry_id", a.EntryID),
+		zap.String("hash", a.Hash),
+		zap.String("target", a.Target))
+	return a, nil
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainHash(t *testing.T) {
+	createdAt := time.Date(2026, 3, 10, 12, 0, 0, 123456000, time.UTC)
+	entry := &Entry{
+		ActorID:      "alice",
+		Action:       ActionExec,
+		ResourceType: "command",
+		ResourceID:   "abc",
+		Details:      map[string]interface{}{"command": "ls", "exit_code": 0},
+		IPAddress:    "::ffff:10.0.0.1",
+		UserAgent:    "curl/8",
+		CreatedAt:    createdAt,
+	}
+	hash, err := chainHash("", entry)
+	require.NoError(t, err)
+	assert.Len(t, hash, 64)
+
+	// The same entry as read back from Postgres hashes the same
+	details, err := json.Marshal(entry.Details)
+	require.NoError(t, err)
+	stored := *entry
+	stored.Details = nil
+	require.NoError(t, json.Unmarshal(details, &stored.Details))
+	stored.IPAddress = "10.0.0.1"
+	stored.CreatedAt = createdAt.In(time.FixedZone("", 0))
+	again, err := chainHash("", &stored)
+	require.NoError(t, err)
+	assert.Equal(t, hash, again)
+
+	// Any change to the entry or its link changes the hash
+	linked, err := chainHash(hash, entry)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, linked)
+
+	changed := *entry
+	changed.Details = map[string]interface{}{"command": "rm -rf /", "exit_code": 0}
+	other, err := chainHash("", &changed)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	changed = *entry
+	changed.CreatedAt = createdAt.Add(time.Microsecond)
+	other, err = chainHash("", &changed)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+}
+
+func TestTimeRange(t *testing.T) {
+	since := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	until := since.Add(24 * time.Hour)
+
+	where, args := timeRange("created_at", time.Time{}, time.Time{})
+	assert.Equal(t, "TRUE", where)
+	assert.Empty(t, args)
+
+	where, args = timeRange("entry_created_at", since, until)
+	assert.Equal(t, "entry_created_at >= $1 AND entry_created_at < $2", where)
+	assert.Equal(t, []interface{}{since, until}, args)
+}
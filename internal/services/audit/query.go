@@ -0,0 +1,388 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search query syntax
+//
+//	user:alice action:session.create since:24h
+//	action:session.* -user:bob,carol ip:10.0.0.0/8 "rm -rf"
+//
+// Terms are ANDed. A comma separates alternatives for one field, a leading
+// minus negates a term, and a trailing * in action or resource matches a
+// prefix. Bare words match against the resource ID and details.
+
+// fieldColumns maps query fields to the columns they filter on
+var fieldColumns = map[string]string{
+	"user":     "actor_id",
+	"actor":    "actor_id",
+	"action":   "action",
+	"resource": "resource_type",
+	"type":     "resource_type",
+	"id":       "resource_id",
+	"ip":       "ip_address",
+}
+
+var nullableColumns = map[string]bool{
+	"actor_id":    true,
+	"resource_id": true,
+	"ip_address":  true,
+}
+
+// Term is one field filter in a search query. An empty Field is free text.
+type Term struct {
+	Field  string
+	Values []string
+	Negate bool
+}
+
+// Query is a parsed search query
+type Query struct {
+	Terms []Term
+	Since time.Time
+	Until time.Time
+}
+
+// ParseQuery parses the search syntax. Relative times in since and until
+// are resolved against now.
+func ParseQuery(input string, now time.Time) (*Query, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &Query{}
+	for _, token := range tokens {
+		negate := false
+		if strings.HasPrefix(token.text, "-") && len(token.text) > 1 && !token.quoted {
+			negate = true
+			token.text = token.text[1:]
+		}
+
+		field, value, ok := strings.Cut(token.text, ":")
+		if !ok || token.quoted {
+			query.Terms = append(query.Terms, Term{Values: []string{token.text}, Negate: negate})
+			continue
+		}
+		field = strings.ToLower(field)
+
+		switch field {
+		case "since", "until":
+			if negate {
+				return nil, fmt.Errorf("%s cannot be negated", field)
+			}
+			t, err := ParseTime(value, now)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", field, err)
+			}
+			if field == "since" {
+				query.Since = t
+			} else {
+				query.Until = t
+			}
+			continue
+		}
+
+		if _, ok := fieldColumns[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("missing value for %s", field)
+		}
+		query.Terms = append(query.Terms, Term{Field: field, Values: values, Negate: negate})
+	}
+
+	if !query.Since.IsZero() && !query.Until.IsZero() && !query.Since.Before(query.Until) {
+		return nil, fmt.Errorf("since must be before until")
+	}
+	return query, nil
+}
+
+// Page selects one page of search results. Cursor is the NextCursor of the
+// previous page.
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+// SearchResult is one page of matching entries, newest first
+type SearchResult struct {
+	Entries    []*Entry `json:"entries"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+// Search runs a parsed query. Pages are keyed on (created_at, id), so
+// entries recorded while paging do not shift later pages.
+func (s *Service) Search(ctx context.Context, query *Query, page Page) (*SearchResult, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("audit storage is not configured")
+	}
+
+	where, args := query.Compile()
+	if page.Cursor != "" {
+		createdAt, id, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, createdAt, id)
+		where += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	limit := page.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	// One extra row tells us whether there is another page
+	args = append(args, limit+1)
+
+	entries, err := s.queryEntries(ctx, fmt.Sprintf(`
+		SELECT id, COALESCE(actor_id, ''), action, resource_type, COALESCE(resource_id, ''),
+		       COALESCE(details, '{}'), COALESCE(host(ip_address), ''), COALESCE(user_agent, ''), created_at
+		FROM audit_logs
+		WHERE %s
+		ORDER BY created_at DESC, id DESC LIMIT $%d`, where, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Entries: entries}
+	if len(entries) > limit {
+		result.Entries = entries[:limit]
+		last := result.Entries[limit-1]
+		result.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	if result.Entries == nil {
+		result.Entries = []*Entry{}
+	}
+	return result, nil
+}
+
+func encodeCursor(createdAt time.Time, id int64) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(createdAt.Format(time.RFC3339Nano) + "|" + strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	ts, idText, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	return createdAt, id, nil
+}
+
+// CSVColumns is the header row written by CSVWriter
+var CSVColumns = []string{
+	"id", "created_at", "actor_id", "action", "resource_type", "resource_id",
+	"ip_address", "user_agent", "details",
+}
+
+// CSVWriter writes entries as CSV, emitting the header before the first row
+type CSVWriter struct {
+	w       *csv.Writer
+	started bool
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+func (c *CSVWriter) Write(entries []*Entry) error {
+	if !c.started {
+		if err := c.w.Write(CSVColumns); err != nil {
+			return err
+		}
+		c.started = true
+	}
+	for _, entry := range entries {
+		details := ""
+		if len(entry.Details) > 0 {
+			raw, err := json.Marshal(entry.Details)
+			if err != nil {
+				return fmt.Errorf("failed to encode details: %w", err)
+			}
+			details = string(raw)
+		}
+		if err := c.w.Write([]string{
+			strconv.FormatInt(entry.ID, 10),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			entry.ActorID,
+			entry.Action,
+			entry.ResourceType,
+			entry.ResourceID,
+			entry.IPAddress,
+			entry.UserAgent,
+			details,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered rows, including the header for an empty result
+func (c *CSVWriter) Flush() error {
+	if !c.started {
+		if err := c.Write(nil); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Compile renders the query as a SQL condition with positional arguments
+// starting at $1. An empty query compiles to "TRUE".
+func (q *Query) Compile() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	for _, term := range q.Terms {
+		var alternatives []string
+		for _, value := range term.Values {
+			alternatives = append(alternatives, compileValue(term.Field, value, arg))
+		}
+		condition := strings.Join(alternatives, " OR ")
+		if len(alternatives) > 1 {
+			condition = "(" + condition + ")"
+		}
+		if term.Negate {
+			// NOT on a NULL comparison is still NULL, so rows without the
+			// column would otherwise never match a negated term
+			if column := fieldColumns[term.Field]; nullableColumns[column] {
+				condition = fmt.Sprintf("(%s IS NULL OR NOT %s)", column, condition)
+			} else {
+				condition = "NOT " + condition
+			}
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if !q.Since.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(q.Since))
+	}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(q.Until))
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func compileValue(field, value string, arg func(interface{}) string) string {
+	switch field {
+	case "":
+		pattern := arg("%" + escapeLike(value) + "%")
+		return fmt.Sprintf("(COALESCE(resource_id, '') ILIKE %s OR COALESCE(details::text, '') ILIKE %s)", pattern, pattern)
+	case "ip":
+		return fmt.Sprintf("ip_address <<= %s::inet", arg(value))
+	}
+
+	column := fieldColumns[field]
+	if prefix, ok := strings.CutSuffix(value, "*"); ok && (column == "action" || column == "resource_type") {
+		return fmt.Sprintf("%s LIKE %s", column, arg(escapeLike(prefix)+"%"))
+	}
+	return fmt.Sprintf("%s = %s", column, arg(value))
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ParseTime accepts a relative duration (30m, 24h, 7d), a date or an
+// RFC 3339 timestamp
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("negative duration %q", value)
+		}
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected a duration, date or RFC 3339 timestamp, got %q", value)
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits on whitespace. Double quotes group a phrase, either on
+// their own or as a field value (user:"alice smith").
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	var current strings.Builder
+	inQuotes, quoted, started := false, false, false
+
+	flush := func() {
+		if started {
+			tokens = append(tokens, token{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+		quoted, started = false, false
+	}
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			if inQuotes && current.Len() == 0 {
+				quoted = true
+			}
+			started = true
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	flush()
+	return tokens, nil
+}
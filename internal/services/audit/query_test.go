@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	query, err := ParseQuery(`user:alice action:session.create since:24h`, now)
+	require.NoError(t, err)
+	assert.Equal(t, []Term{
+		{Field: "user", Values: []string{"alice"}},
+		{Field: "action", Values: []string{"session.create"}},
+	}, query.Terms)
+	assert.Equal(t, now.Add(-24*time.Hour), query.Since)
+
+	where, args := query.Compile()
+	assert.Equal(t, "actor_id = $1 AND action = $2 AND created_at >= $3", where)
+	assert.Equal(t, []interface{}{"alice", "session.create", now.Add(-24 * time.Hour)}, args)
+}
+
+func TestParseQueryOperators(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	_, err := ParseQuery(`until:2026-03-01 since:7d`, now)
+	require.Error(t, err, "since after until")
+
+	query, err := ParseQuery(`action:session.* -user:bob,carol ip:10.0.0.0/8 "rm -rf" user:"alice smith" since:7d`, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, -7), query.Since)
+
+	where, args := query.Compile()
+	assert.Equal(t, "action LIKE $1"+
+		" AND (actor_id IS NULL OR NOT (actor_id = $2 OR actor_id = $3))"+
+		" AND ip_address <<= $4::inet"+
+		" AND (COALESCE(resource_id, '') ILIKE $5 OR COALESCE(details::text, '') ILIKE $5)"+
+		" AND actor_id = $6"+
+		" AND created_at >= $7", where)
+	assert.Equal(t, []interface{}{"session.%", "bob", "carol", "10.0.0.0/8", "%rm -rf%", "alice smith", now.AddDate(0, 0, -7)}, args)
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	now := time.Now()
+	for _, input := range []string{
+		`owner:alice`,
+		`user:`,
+		`since:yesterday`,
+		`-since:1h`,
+		`"unterminated`,
+	} {
+		_, err := ParseQuery(input, now)
+		assert.Error(t, err, input)
+	}
+
+	query, err := ParseQuery("", now)
+	require.NoError(t, err)
+	where, args := query.Compile()
+	assert.Equal(t, "TRUE", where)
+	assert.Empty(t, args)
+}
+
+func TestEscapeLike(t *testing.T) {
+	query, err := ParseQuery(`100%_done`, time.Now())
+	require.NoError(t, err)
+	_, args := query.Compile()
+	assert.Equal(t, []interface{}{`%100\%\_done%`}, args)
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2026, 3, 10, 12, 0, 0, 123456000, time.UTC)
+	gotTime, gotID, err := decodeCursor(encodeCursor(createdAt, 42))
+	require.NoError(t, err)
+	assert.True(t, createdAt.Equal(gotTime))
+	assert.Equal(t, int64(42), gotID)
+
+	_, _, err = decodeCursor("not a cursor")
+	assert.Error(t, err)
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	out := NewCSVWriter(&buf)
+	require.NoError(t, out.Write([]*Entry{{
+		ID:           7,
+		ActorID:      "alice",
+		Action:       "session.create",
+		ResourceType: "session",
+		ResourceID:   "sess_1",
+		Details:      map[string]interface{}{"command": "echo a,b"},
+		CreatedAt:    time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC),
+	}}))
+	require.NoError(t, out.Flush())
+
+	assert.Equal(t, "id,created_at,actor_id,action,resource_type,resource_id,ip_address,user_agent,details\n"+
+		`7,2026-03-10T12:00:00Z,alice,session.create,session,sess_1,,,"{""command"":""echo a,b""}"`+"\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, NewCSVWriter(&buf).Flush())
+	assert.Equal(t, "id,created_at,actor_id,action,resource_type,resource_id,ip_address,user_agent,details\n", buf.String())
+}
@@ -0,0 +1,242 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"go.uber.org/zap"
+)
+
+const (
+	ActionClientAttest   = "client.attest"
+	ActionUploadRejected = "file.upload_rejected"
+	ActionBan            = "abuse.ban"
+	ActionUnban          = "abuse.unban"
+	ActionHostKeyPin     = "ssh.host_key_pin"
+	ActionHostKeyForget  = "ssh.host_key_forget"
+	ActionWorkshopCreate = "workshop.create"
+	ActionWorkshopEnd    = "workshop.end"
+	ActionElevationAsk   = "elevation.request"
+	ActionElevationGrant = "elevation.grant"
+	ActionElevationDeny  = "elevation.deny"
+	ActionElevationEnd   = "elevation.end"
+	ActionIdentityIssue  = "identity.issue"
+	ActionTemplateCreate = "template.create"
+	ActionTemplateUpdate = "template.update"
+	ActionTemplateDelete = "template.delete"
+	ActionExec           = "command.exec"
+	ActionScheduleRun    = "schedule.run"
+	ActionAccountCreate  = "service_account.create"
+	ActionAccountRotate  = "service_account.rotate_key"
+	ActionAccountDelete  = "service_account.delete"
+	ActionBannerAck      = "banner.acknowledge"
+	ActionInputAuditRead = "input_audit.read"
+)
+
+type Service struct {
+	db     *database.DB
+	logger *zap.Logger
+	chain  *ChainConfig // nil unless entries are hash-chained
+	client *http.Client
+}
+
+type Entry struct {
+	ID           int64                  `json:"id"`
+	ActorID      string                 `json:"actor_id"`
+	Action       string                 `json:"action"`
+	ResourceType string                 `json:"resource_type"`
+	ResourceID   string                 `json:"resource_id,omitempty"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+	IPAddress    string                 `json:"ip_address,omitempty"`
+	UserAgent    string                 `json:"user_agent,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
+
+type Filter struct {
+	ActorID      string
+	Action       string
+	ResourceType string
+	ResourceID   string
+	Since        time.Time
+	Until        time.Time
+	Limit        int
+}
+
+// FingerprintSummary aggregates the TLS clients seen for one actor
+type FingerprintSummary struct {
+	Fingerprint string    `json:"tls_fingerprint"`
+	UserAgent   string    `json:"user_agent"`
+	Count       int       `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// New creates the audit service. Without a database, entries are only
+// written to the log.
+func New(db *database.DB, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *Service) Record(ctx context.Context, entry *Entry) error {
+	s.logger.Info("Audit",
+		zap.String("actor_id", entry.ActorID),
+		zap.String("action", entry.Action),
+		zap.String("resource_type", entry.ResourceType),
+		zap.String("resource_id", entry.ResourceID),
+		zap.String("ip_address", entry.IPAddress),
+		zap.Any("details", entry.Details),
+	)
+
+	if s.db == nil {
+		return nil
+	}
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+	if s.chain != nil {
+		return s.recordChained(ctx, entry, details)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO audit_logs (actor_id, action, resource_type, resource_id, details, ip_address, user_agent)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, '')::inet, NULLIF($7, ''))`,
+		entry.ActorID,
+		entry.Action,
+		entry.ResourceType,
+		entry.ResourceID,
+		details,
+		entry.IPAddress,
+		entry.UserAgent,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) Query(ctx context.Context, filter Filter) ([]*Entry, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("audit storage is not configured")
+	}
+
+	var conditions []string
+	var args []interface{}
+	add := func(clause string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.ActorID != "" {
+		add("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.ResourceType != "" {
+		add("resource_type = $%d", filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		add("resource_id = $%d", filter.ResourceID)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+
+	query := `
+		SELECT id, COALESCE(actor_id, ''), action, resource_type, COALESCE(resource_id, ''),
+		       COALESCE(details, '{}'), COALESCE(host(ip_address), ''), COALESCE(user_agent, ''), created_at
+		FROM audit_logs`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	return s.queryEntries(ctx, query, args...)
+}
+
+// Fingerprints lists the distinct TLS clients an actor has connected with,
+// most recent first.
+func (s *Service) Fingerprints(ctx context.Context, actorID string) ([]*FingerprintSummary, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("audit storage is not configured")
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT details->>'tls_fingerprint', COALESCE(user_agent, ''), COUNT(*), MIN(created_at), MAX(created_at)
+		FROM audit_logs
+		WHERE actor_id = $1 AND action = $2 AND details->>'tls_fingerprint' IS NOT NULL
+		GROUP BY details->>'tls_fingerprint', user_agent
+		ORDER BY MAX(created_at) DESC`, actorID, ActionClientAttest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fingerprints: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*FingerprintSummary
+	for rows.Next() {
+		summary := &FingerprintSummary{}
+		if err := rows.Scan(
+			&summary.Fingerprint,
+			&summary.UserAgent,
+			&summary.Count,
+			&summary.FirstSeen,
+			&summary.LastSeen,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan fingerprint: %w", err)
+		}
+		result = append(result, summary)
+	}
+	return result, rows.Err()
+}
+
+func (s *Service) queryEntries(ctx context.Context, query string, args ...interface{}) ([]*Entry, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*Entry
+	for rows.Next() {
+		entry := &Entry{}
+		var details []byte
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.ActorID,
+			&entry.Action,
+			&entry.ResourceType,
+			&entry.ResourceID,
+			&details,
+			&entry.IPAddress,
+			&entry.UserAgent,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if err := json.Unmarshal(details, &entry.Details); err != nil {
+			return nil, fmt.Errorf("failed to decode audit details: %w", err)
+		}
+		result = append(result, entry)
+	}
+	return result, rows.Err()
+}
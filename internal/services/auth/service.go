@@ -1,19 +1,39 @@
 package auth
 
 import (
+	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/yourusername/webtunnel/internal/config"
 	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/pkg/config"
 	"go.uber.org/zap"
 )
 
 type Service struct {
-	config config.AuthConfig
-	db     *database.DB
-	logger *zap.Logger
+	config      config.AuthConfig
+	db          *database.DB
+	directories []Directory
+	keys        KeyVerifier
+	logger      *zap.Logger
+}
+
+// Directory is a source of users kept outside the users table, such as
+// workshop accounts. It answers ok for the logins and user IDs it owns,
+// which then never reach the database; err rejects them.
+type Directory interface {
+	Authenticate(login, password string) (user *User, ok bool, err error)
+	Lookup(userID string) (user *User, ok bool, err error)
+}
+
+// KeyVerifier authenticates API keys, for users without a password such as
+// service accounts. It answers ok for the keys it issued; err rejects them.
+type KeyVerifier interface {
+	VerifyKey(key string) (userID string, ok bool, err error)
+	// KeyOnly reports the users who may not use a login token at all
+	KeyOnly(userID string) bool
 }
 
 type Claims struct {
@@ -28,6 +48,7 @@ type User struct {
 	Email    string `json:"email"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	OrgID    string `json:"org_id,omitempty"`
 }
 
 func New(config config.AuthConfig, db *database.DB, logger *zap.Logger) *Service {
@@ -38,18 +59,34 @@ func New(config config.AuthConfig, db *database.DB, logger *zap.Logger) *Service
 	}
 }
 
+// SetDirectory adds a source of users consulted before the database, after
+// any added earlier
+func (s *Service) SetDirectory(directory Directory) {
+	s.directories = append(s.directories, directory)
+}
+
+// SetKeyVerifier accepts API keys wherever a login token is accepted
+func (s *Service) SetKeyVerifier(keys KeyVerifier) {
+	s.keys = keys
+}
+
 func (s *Service) GenerateToken(userID, email, role string) (string, error) {
 	expirationTime, err := time.ParseDuration(s.config.SessionExpiry)
 	if err != nil {
 		expirationTime = 24 * time.Hour // default
 	}
+	return s.GenerateTokenUntil(userID, email, role, time.Now().Add(expirationTime))
+}
 
+// GenerateTokenUntil issues a token that expires at the given time rather
+// than after the configured session expiry
+func (s *Service) GenerateTokenUntil(userID, email, role string, expires time.Time) (string, error) {
 	claims := &Claims{
 		UserID: userID,
 		Email:  email,
 		Role:   role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expirationTime)),
+			ExpiresAt: jwt.NewNumericDate(expires),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "webtunnel",
@@ -66,6 +103,15 @@ func (s *Service) GenerateToken(userID, email, role string) (string, error) {
 }
 
 func (s *Service) ValidateToken(tokenString string) (string, error) {
+	if s.keys != nil {
+		if userID, ok, err := s.keys.VerifyKey(tokenString); ok {
+			if err != nil {
+				return "", fmt.Errorf("invalid API key: %w", err)
+			}
+			return userID, nil
+		}
+	}
+
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
@@ -83,10 +129,30 @@ func (s *Service) ValidateToken(tokenString string) (string, error) {
 		return "", fmt.Errorf("invalid token")
 	}
 
+	if s.keys != nil && s.keys.KeyOnly(claims.UserID) {
+		return "", fmt.Errorf("invalid token: user authenticates with an API key only")
+	}
+
+	// Directory users stop working as soon as the directory drops them
+	for _, directory := range s.directories {
+		if _, ok, err := directory.Lookup(claims.UserID); ok {
+			if err != nil {
+				return "", fmt.Errorf("invalid token: %w", err)
+			}
+			break
+		}
+	}
+
 	return claims.UserID, nil
 }
 
 func (s *Service) AuthenticateUser(email, password string) (*User, error) {
+	for _, directory := range s.directories {
+		if user, ok, err := directory.Authenticate(email, password); ok {
+			return user, err
+		}
+	}
+
 	// For demo purposes, create a simple auth that accepts any password
 	// In production, this would check against database with hashed passwords
 	
@@ -96,19 +162,73 @@ func (s *Service) AuthenticateUser(email, password string) (*User, error) {
 		Username: email,
 		Role:     "user",
 	}
+	orgID, err := s.orgOf(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.OrgID = orgID
 
 	s.logger.Info("User authenticated", zap.String("email", email))
 	return user, nil
 }
 
 func (s *Service) GetUserByID(userID string) (*User, error) {
+	for _, directory := range s.directories {
+		if user, ok, err := directory.Lookup(userID); ok {
+			return user, err
+		}
+	}
+
 	// For demo purposes, return a mock user
 	// In production, this would query the database
 	
+	orgID, err := s.orgOf(userID)
+	if err != nil {
+		return nil, err
+	}
 	return &User{
 		ID:       userID,
 		Email:    "demo@example.com",
 		Username: "demo",
 		Role:     "user",
+		OrgID:    orgID,
 	}, nil
-}
\ No newline at end of file
+}
+
+// orgOf returns the org in users.org_id, if any. Users who log in by
+// email have IDs made from it, so their rows are found by email too.
+// Without a database no one has an org.
+func (s *Service) orgOf(userID string) (string, error) {
+	if s.db == nil {
+		return "", nil
+	}
+
+	var orgID string
+	err := s.db.QueryRow(`SELECT COALESCE(org_id, '') FROM users WHERE uuid = $1 OR email = $2 LIMIT 1`,
+		userID, loginEmail(userID)).Scan(&orgID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user org: %w", err)
+	}
+	return orgID, nil
+}
+
+// loginEmail returns the email a login user ID was made from
+func loginEmail(userID string) string {
+	email, ok := strings.CutPrefix(userID, "user_")
+	if !ok {
+		return ""
+	}
+	return email
+}
+
+// UserRole returns the user's role, for services that only need that
+func (s *Service) UserRole(userID string) (string, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+	return user.Role, nil
+}
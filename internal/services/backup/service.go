@@ -0,0 +1,275 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	archiveVersion = 1
+	archiveMagic   = "WTBK1"
+	saltSize       = 16
+)
+
+// backupTables lists the tables captured in an archive, in restore order so
+// foreign keys resolve. Live PTY state is never exported.
+var backupTables = []string{
+	"users",
+	"user_preferences",
+	"terminal_sessions",
+	"session_shares",
+	"share_revocations",
+	"feature_flags",
+	"snippets",
+	"session_templates",
+	"push_subscriptions",
+	"ssh_keys",
+	"ssh_known_hosts",
+	"workshops",
+	"workshop_users",
+	"service_accounts",
+	"banner_acks",
+}
+
+type Service struct {
+	db     *database.DB
+	logger *zap.Logger
+}
+
+// Archive is the decrypted contents of a backup. Rows are stored as the JSON
+// form Postgres produces for them.
+type Archive struct {
+	Version   int                          `json:"version"`
+	CreatedAt time.Time                    `json:"created_at"`
+	Tables    map[string][]json.RawMessage `json:"tables"`
+}
+
+// ImportResult reports how many rows were restored per table. Rows that
+// already exist are skipped.
+type ImportResult struct {
+	Tables map[string]int `json:"tables"`
+}
+
+func New(db *database.DB, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Export writes an encrypted archive of the instance state to w.
+func (s *Service) Export(ctx context.Context, w io.Writer, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("passphrase is required")
+	}
+
+	archive := Archive{
+		Version:   archiveVersion,
+		CreatedAt: time.Now().UTC(),
+		Tables:    make(map[string][]json.RawMessage),
+	}
+
+	for _, table := range backupTables {
+		rows, err := s.exportTable(ctx, table)
+		if err != nil {
+			return err
+		}
+		archive.Tables[table] = rows
+	}
+
+	plaintext, err := compress(archive)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := seal(plaintext, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	s.logger.Info("Exported server state", zap.Int("bytes", len(sealed)))
+	return nil
+}
+
+// Import decrypts an archive and restores its rows in a single transaction.
+func (s *Service) Import(ctx context.Context, r io.Reader, passphrase string) (*ImportResult, error) {
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	plaintext, err := open(sealed, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := decompress(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if archive.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version: %d", archive.Version)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{Tables: make(map[string]int)}
+	for _, table := range backupTables {
+		restored := 0
+		for _, row := range archive.Tables[table] {
+			// Table names come from backupTables, never from the archive
+			res, err := tx.ExecContext(ctx, fmt.Sprintf(
+				`INSERT INTO %[1]s SELECT * FROM json_populate_record(NULL::%[1]s, $1) ON CONFLICT DO NOTHING`,
+				table), string(row))
+			if err != nil {
+				return nil, fmt.Errorf("failed to restore %s: %w", table, err)
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				restored++
+			}
+		}
+		result.Tables[table] = restored
+
+		// Move serial sequences past restored IDs
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(
+			`SELECT setval(seq, GREATEST((SELECT COALESCE(MAX(id), 0) FROM %[1]s), 1))
+			 FROM pg_get_serial_sequence('%[1]s', 'id') AS seq WHERE seq IS NOT NULL`,
+			table)); err != nil {
+			return nil, fmt.Errorf("failed to reset sequence for %s: %w", table, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+
+	s.logger.Info("Imported server state",
+		zap.Time("archive_created_at", archive.CreatedAt),
+		zap.Any("tables", result.Tables))
+	return result, nil
+}
+
+func (s *Service) exportTable(ctx context.Context, table string) ([]json.RawMessage, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT row_to_json(t) FROM %s t`, table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to export %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	result := []json.RawMessage{}
+	for rows.Next() {
+		var row []byte
+		if err := rows.Scan(&row); err != nil {
+			return nil, fmt.Errorf("failed to scan %s row: %w", table, err)
+		}
+		result = append(result, json.RawMessage(row))
+	}
+	return result, rows.Err()
+}
+
+func compress(archive Archive) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(archive); err != nil {
+		return nil, fmt.Errorf("failed to encode archive: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte) (*Archive, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive: %w", err)
+	}
+	defer zr.Close()
+
+	var archive Archive
+	if err := json.NewDecoder(zr).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("failed to decode archive: %w", err)
+	}
+	return &archive, nil
+}
+
+// seal encrypts with AES-256-GCM under a scrypt-derived key. The layout is
+// magic | salt | nonce | ciphertext.
+func seal(plaintext []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	out := append([]byte(archiveMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, []byte(archiveMagic)), nil
+}
+
+func open(sealed []byte, passphrase string) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, []byte(archiveMagic)) {
+		return nil, fmt.Errorf("not a webtunnel archive")
+	}
+	sealed = sealed[len(archiveMagic):]
+	if len(sealed) < saltSize {
+		return nil, fmt.Errorf("archive is truncated")
+	}
+
+	salt, sealed := sealed[:saltSize], sealed[saltSize:]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("archive is truncated")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(archiveMagic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive: wrong passphrase or corrupted data")
+	}
+	return plaintext, nil
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,250 @@
+package banners
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+const (
+	ShowNotice = "notice"
+	ShowPrint  = "print"
+	ShowBoth   = "both"
+)
+
+// ErrStale is returned for acknowledging a banner that is not the user's
+// current one, e.g. one whose text has changed since it was shown
+var ErrStale = errors.New("banner is not the current one")
+
+// UserLookup resolves the org and role a user's banner depends on
+type UserLookup interface {
+	GetUserByID(userID string) (*auth.User, error)
+}
+
+// Ack records that a user acknowledged a banner
+type Ack struct {
+	UserID         string    `json:"user_id"`
+	BannerID       string    `json:"banner_id"`
+	AcknowledgedAt time.Time `json:"acknowledged_at"`
+}
+
+// Service picks the banner for each user's sessions and keeps their
+// acknowledgments, in memory without a database
+type Service struct {
+	db     *database.DB
+	users  UserLookup
+	logger *zap.Logger
+
+	banner *terminal.Banner            // nil for none
+	orgs   map[string]*terminal.Banner // a nil entry shows that org none
+	exempt map[string]bool
+
+	memory map[string]Ack // by user ID:banner ID
+	mu     sync.Mutex
+}
+
+func New(cfg config.BannerConfig, db *database.DB, users UserLookup, logger *zap.Logger) (*Service, error) {
+	show := cfg.Show
+	if show == "" {
+		show = ShowBoth
+	}
+	if show != ShowNotice && show != ShowPrint && show != ShowBoth {
+		return nil, fmt.Errorf("invalid banner show %q; want notice, print or both", cfg.Show)
+	}
+	s := &Service{
+		db:     db,
+		users:  users,
+		logger: logger,
+		banner: newBanner(cfg.Text, cfg.RequireAck, show),
+		orgs:   make(map[string]*terminal.Banner),
+		exempt: make(map[string]bool),
+		memory: make(map[string]Ack),
+	}
+	for _, org := range cfg.Orgs {
+		if org.Org == "" {
+			return nil, fmt.Errorf("banner orgs need an org")
+		}
+		if _, exists := s.orgs[org.Org]; exists {
+			return nil, fmt.Errorf("duplicate banner for org %q", org.Org)
+		}
+		s.orgs[org.Org] = newBanner(org.Text, org.RequireAck, show)
+	}
+	for _, role := range cfg.ExemptRoles {
+		s.exempt[role] = true
+	}
+	return s, nil
+}
+
+// newBanner names the banner by its text, so changing the text asks
+// everyone to acknowledge it again
+func newBanner(text string, requireAck bool, show string) *terminal.Banner {
+	if text == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(text))
+	return &terminal.Banner{
+		ID:         hex.EncodeToString(sum[:8]),
+		Text:       text,
+		RequireAck: requireAck,
+		Notice:     show != ShowPrint,
+		Print:      show != ShowNotice,
+	}
+}
+
+// Configured is a banner as configured, for admins
+type Configured struct {
+	Org string `json:"org,omitempty"` // empty for the default
+	terminal.Banner
+}
+
+// List returns the configured banners, the default first and then by org
+func (s *Service) List() []Configured {
+	result := []Configured{}
+	if s.banner != nil {
+		result = append(result, Configured{Banner: *s.banner})
+	}
+	orgs := make([]string, 0, len(s.orgs))
+	for org, banner := range s.orgs {
+		if banner != nil {
+			orgs = append(orgs, org)
+		}
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		result = append(result, Configured{Org: org, Banner: *s.orgs[org]})
+	}
+	return result
+}
+
+// Enabled reports whether any banner is configured
+func (s *Service) Enabled() bool {
+	if s.banner != nil {
+		return true
+	}
+	for _, banner := range s.orgs {
+		if banner != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Banner returns the user's banner: their org's if it has one, the default
+// otherwise. Users in an exempt role get none.
+func (s *Service) Banner(ctx context.Context, userID string) (*terminal.Banner, error) {
+	user, err := s.users.GetUserByID(userID)
+	if err != nil {
+		// Unknown users still see the default banner
+		return s.banner, nil
+	}
+	if s.exempt[user.Role] {
+		return nil, nil
+	}
+	if banner, ok := s.orgs[user.OrgID]; ok && user.OrgID != "" {
+		return banner, nil
+	}
+	return s.banner, nil
+}
+
+// Acknowledged reports whether the user has acknowledged the banner
+func (s *Service) Acknowledged(ctx context.Context, userID, bannerID string) (bool, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, ok := s.memory[userID+":"+bannerID]
+		return ok, nil
+	}
+
+	var acknowledged bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM banner_acks WHERE user_id = $1 AND banner_id = $2)`,
+		userID, bannerID,
+	).Scan(&acknowledged)
+	if err != nil {
+		return false, fmt.Errorf("failed to check banner acknowledgment: %w", err)
+	}
+	return acknowledged, nil
+}
+
+// Acknowledge records that the user has read their current banner.
+// Acknowledging it again keeps the first time.
+func (s *Service) Acknowledge(ctx context.Context, userID, bannerID string) (*Ack, error) {
+	banner, err := s.Banner(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if banner == nil || banner.ID != bannerID {
+		return nil, fmt.Errorf("%w: %s", ErrStale, bannerID)
+	}
+
+	ack := Ack{UserID: userID, BannerID: bannerID, AcknowledgedAt: time.Now()}
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		key := userID + ":" + bannerID
+		if existing, ok := s.memory[key]; ok {
+			return &existing, nil
+		}
+		s.memory[key] = ack
+		return &ack, nil
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO banner_acks (user_id, banner_id, acknowledged_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, banner_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING acknowledged_at`,
+		userID, bannerID, ack.AcknowledgedAt,
+	).Scan(&ack.AcknowledgedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save banner acknowledgment: %w", err)
+	}
+	return &ack, nil
+}
+
+// Acks lists who acknowledged a banner, oldest first
+func (s *Service) Acks(ctx context.Context, bannerID string) ([]Ack, error) {
+	result := []Ack{}
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, ack := range s.memory {
+			if ack.BannerID == bannerID {
+				result = append(result, ack)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].AcknowledgedAt.Before(result[j].AcknowledgedAt)
+		})
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, banner_id, acknowledged_at
+		FROM banner_acks
+		WHERE banner_id = $1
+		ORDER BY acknowledged_at`, bannerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query banner acknowledgments: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ack Ack
+		if err := rows.Scan(&ack.UserID, &ack.BannerID, &ack.AcknowledgedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan banner acknowledgment: %w", err)
+		}
+		result = append(result, ack)
+	}
+	return result, rows.Err()
+}
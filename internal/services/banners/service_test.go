@@ -0,0 +1,124 @@
+package banners
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+type fakeUsers map[string]*auth.User
+
+func (f fakeUsers) GetUserByID(userID string) (*auth.User, error) {
+	if user, ok := f[userID]; ok {
+		return user, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+var users = fakeUsers{
+	"alice": {ID: "alice", Role: "user"},
+	"bob":   {ID: "bob", Role: "user", OrgID: "acme"},
+	"carol": {ID: "carol", Role: "user", OrgID: "labs"},
+	"ci":    {ID: "ci", Role: "service"},
+}
+
+func TestBanner(t *testing.T) {
+	service, err := New(config.BannerConfig{
+		Text:        "Authorized use only.",
+		Show:        ShowNotice,
+		ExemptRoles: []string{"service"},
+		Orgs: []config.OrgBannerConfig{
+			{Org: "acme", Text: "ACME systems. Activity is monitored.", RequireAck: true},
+			{Org: "labs"},
+		},
+	}, nil, users, zap.NewNop())
+	require.NoError(t, err)
+	require.True(t, service.Enabled())
+	ctx := context.Background()
+
+	banner, err := service.Banner(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "Authorized use only.", banner.Text)
+	assert.False(t, banner.RequireAck)
+	assert.True(t, banner.Notice)
+	assert.False(t, banner.Print)
+
+	banner, err = service.Banner(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "ACME systems. Activity is monitored.", banner.Text)
+	assert.True(t, banner.RequireAck)
+
+	// An org can opt out, and so can roles
+	banner, err = service.Banner(ctx, "carol")
+	require.NoError(t, err)
+	assert.Nil(t, banner)
+	banner, err = service.Banner(ctx, "ci")
+	require.NoError(t, err)
+	assert.Nil(t, banner)
+
+	banner, err = service.Banner(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Equal(t, "Authorized use only.", banner.Text)
+
+	listed := service.List()
+	require.Len(t, listed, 2)
+	assert.Empty(t, listed[0].Org)
+	assert.Equal(t, "acme", listed[1].Org)
+}
+
+func TestAcknowledge(t *testing.T) {
+	cfg := config.BannerConfig{Text: "Authorized use only.", RequireAck: true}
+	service, err := New(cfg, nil, users, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	banner, err := service.Banner(ctx, "alice")
+	require.NoError(t, err)
+	acknowledged, err := service.Acknowledged(ctx, "alice", banner.ID)
+	require.NoError(t, err)
+	assert.False(t, acknowledged)
+
+	first, err := service.Acknowledge(ctx, "alice", banner.ID)
+	require.NoError(t, err)
+	acknowledged, err = service.Acknowledged(ctx, "alice", banner.ID)
+	require.NoError(t, err)
+	assert.True(t, acknowledged)
+
+	// Again keeps the first time
+	again, err := service.Acknowledge(ctx, "alice", banner.ID)
+	require.NoError(t, err)
+	assert.Equal(t, first.AcknowledgedAt, again.AcknowledgedAt)
+
+	_, err = service.Acknowledge(ctx, "alice", "0000000000000000")
+	assert.ErrorIs(t, err, ErrStale)
+
+	acks, err := service.Acks(ctx, banner.ID)
+	require.NoError(t, err)
+	require.Len(t, acks, 1)
+	assert.Equal(t, "alice", acks[0].UserID)
+
+	// A new text needs a new acknowledgment
+	cfg.Text = "Authorized use only. Sessions are recorded."
+	service, err = New(cfg, nil, users, zap.NewNop())
+	require.NoError(t, err)
+	changed, err := service.Banner(ctx, "alice")
+	require.NoError(t, err)
+	assert.NotEqual(t, banner.ID, changed.ID)
+}
+
+func TestConfig(t *testing.T) {
+	_, err := New(config.BannerConfig{Show: "popup"}, nil, users, zap.NewNop())
+	assert.Error(t, err)
+	_, err = New(config.BannerConfig{Orgs: []config.OrgBannerConfig{{Org: "a"}, {Org: "a"}}}, nil, users, zap.NewNop())
+	assert.Error(t, err)
+
+	service, err := New(config.BannerConfig{}, nil, users, zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, service.Enabled())
+}
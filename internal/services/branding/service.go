@@ -0,0 +1,176 @@
+package branding
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Fallbacks for fields the configuration leaves empty, matching the
+// stock web UI
+const (
+	DefaultProductName     = "WebTunnel"
+	DefaultPrimaryColor    = "#00ff88"
+	DefaultBackgroundColor = "#1e1e1e"
+	DefaultTextColor       = "#ffffff"
+)
+
+// color only admits hex colors, which are safe to put into a style
+var color = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// UserLookup resolves the org a user's branding depends on
+type UserLookup interface {
+	GetUserByID(userID string) (*auth.User, error)
+}
+
+// Branding is what the web UI and share pages show in place of WebTunnel's
+// own name and colors
+type Branding struct {
+	Org         string `json:"org,omitempty"` // empty for the default
+	ProductName string `json:"product_name"`
+	LogoURL     string `json:"logo_url,omitempty"`
+	SupportURL  string `json:"support_url,omitempty"`
+	Colors      Colors `json:"colors"`
+}
+
+type Colors struct {
+	Primary    string `json:"primary"`
+	Background string `json:"background"`
+	Text       string `json:"text"`
+}
+
+// Service picks each user's branding by their org
+type Service struct {
+	users  UserLookup
+	logger *zap.Logger
+
+	branding Branding
+	orgs     map[string]Branding
+}
+
+func New(cfg config.BrandingConfig, users UserLookup, logger *zap.Logger) (*Service, error) {
+	base := Branding{
+		ProductName: DefaultProductName,
+		Colors: Colors{
+			Primary:    DefaultPrimaryColor,
+			Background: DefaultBackgroundColor,
+			Text:       DefaultTextColor,
+		},
+	}
+	branding, err := merge(base, config.OrgBrandingConfig{
+		ProductName:     cfg.ProductName,
+		LogoURL:         cfg.LogoURL,
+		SupportURL:      cfg.SupportURL,
+		PrimaryColor:    cfg.PrimaryColor,
+		BackgroundColor: cfg.BackgroundColor,
+		TextColor:       cfg.TextColor,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{
+		users:    users,
+		logger:   logger,
+		branding: branding,
+		orgs:     make(map[string]Branding),
+	}
+	for _, org := range cfg.Orgs {
+		if org.Org == "" {
+			return nil, fmt.Errorf("branding orgs need an org")
+		}
+		if _, exists := s.orgs[org.Org]; exists {
+			return nil, fmt.Errorf("duplicate branding for org %q", org.Org)
+		}
+		b, err := merge(branding, org)
+		if err != nil {
+			return nil, fmt.Errorf("branding for org %q: %w", org.Org, err)
+		}
+		b.Org = org.Org
+		s.orgs[org.Org] = b
+	}
+	return s, nil
+}
+
+// merge overrides base with the fields cfg sets, checking each
+func merge(base Branding, cfg config.OrgBrandingConfig) (Branding, error) {
+	b := base
+	if cfg.ProductName != "" {
+		b.ProductName = cfg.ProductName
+	}
+	if cfg.LogoURL != "" {
+		if !validURL(cfg.LogoURL, false) {
+			return b, fmt.Errorf("invalid logo_url %q; want an http(s) URL or a path", cfg.LogoURL)
+		}
+		b.LogoURL = cfg.LogoURL
+	}
+	if cfg.SupportURL != "" {
+		if !validURL(cfg.SupportURL, true) {
+			return b, fmt.Errorf("invalid support_url %q; want an http(s) or mailto: URL", cfg.SupportURL)
+		}
+		b.SupportURL = cfg.SupportURL
+	}
+	for _, c := range []struct {
+		name, value string
+		field       *string
+	}{
+		{"primary_color", cfg.PrimaryColor, &b.Colors.Primary},
+		{"background_color", cfg.BackgroundColor, &b.Colors.Background},
+		{"text_color", cfg.TextColor, &b.Colors.Text},
+	} {
+		if c.value == "" {
+			continue
+		}
+		if !color.MatchString(c.value) {
+			return b, fmt.Errorf("invalid %s %q; want #rgb or #rrggbb", c.name, c.value)
+		}
+		*c.field = c.value
+	}
+	return b, nil
+}
+
+// validURL admits absolute http(s) URLs and paths on this server, and
+// mailto: links where mail is allowed
+func validURL(raw string, mail bool) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return mail && u.Opaque != ""
+	case "":
+		return !mail && strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//")
+	}
+	return false
+}
+
+// Default returns the branding for users outside any configured org
+func (s *Service) Default() Branding {
+	return s.branding
+}
+
+// ForOrg returns the org's branding, or the default for an org without its
+// own
+func (s *Service) ForOrg(org string) Branding {
+	if b, ok := s.orgs[org]; ok {
+		return b
+	}
+	return s.branding
+}
+
+// ForUser returns the branding of the user's org
+func (s *Service) ForUser(userID string) Branding {
+	user, err := s.users.GetUserByID(userID)
+	if err != nil {
+		return s.branding
+	}
+	return s.ForOrg(user.OrgID)
+}
@@ -0,0 +1,86 @@
+package branding
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+type fakeUsers map[string]*auth.User
+
+func (f fakeUsers) GetUserByID(userID string) (*auth.User, error) {
+	if user, ok := f[userID]; ok {
+		return user, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+var users = fakeUsers{
+	"alice": {ID: "alice", Role: "user"},
+	"bob":   {ID: "bob", Role: "user", OrgID: "acme"},
+	"carol": {ID: "carol", Role: "user", OrgID: "labs"},
+}
+
+func TestBranding(t *testing.T) {
+	service, err := New(config.BrandingConfig{
+		ProductName:  "DevBox",
+		SupportURL:   "https://help.example.com",
+		PrimaryColor: "#336699",
+		Orgs: []config.OrgBrandingConfig{
+			{Org: "acme", ProductName: "ACME Shell", LogoURL: "/static/acme.svg", TextColor: "#eee"},
+		},
+	}, users, zap.NewNop())
+	require.NoError(t, err)
+
+	alice := service.ForUser("alice")
+	assert.Equal(t, "DevBox", alice.ProductName)
+	assert.Equal(t, "https://help.example.com", alice.SupportURL)
+	assert.Equal(t, Colors{Primary: "#336699", Background: DefaultBackgroundColor, Text: DefaultTextColor}, alice.Colors)
+	assert.Empty(t, alice.Org)
+
+	// Orgs inherit what they leave out
+	bob := service.ForUser("bob")
+	assert.Equal(t, "acme", bob.Org)
+	assert.Equal(t, "ACME Shell", bob.ProductName)
+	assert.Equal(t, "/static/acme.svg", bob.LogoURL)
+	assert.Equal(t, "https://help.example.com", bob.SupportURL)
+	assert.Equal(t, Colors{Primary: "#336699", Background: DefaultBackgroundColor, Text: "#eee"}, bob.Colors)
+	assert.Equal(t, bob, service.ForOrg("acme"))
+
+	assert.Equal(t, service.Default(), service.ForUser("carol"), "an org without its own gets the default")
+	assert.Equal(t, service.Default(), service.ForUser("unknown"))
+}
+
+func TestDefaults(t *testing.T) {
+	service, err := New(config.BrandingConfig{}, users, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, Branding{
+		ProductName: DefaultProductName,
+		Colors:      Colors{Primary: DefaultPrimaryColor, Background: DefaultBackgroundColor, Text: DefaultTextColor},
+	}, service.Default())
+}
+
+func TestConfig(t *testing.T) {
+	for _, cfg := range []config.BrandingConfig{
+		{PrimaryColor: "red"},
+		{TextColor: "#fff; background:url(x)"},
+		{LogoURL: "javascript:alert(1)"},
+		{LogoURL: "//evil.example.com/logo.png"},
+		{SupportURL: "/help"},
+		{LogoURL: "mailto:help@example.com"},
+		{Orgs: []config.OrgBrandingConfig{{ProductName: "No org"}}},
+		{Orgs: []config.OrgBrandingConfig{{Org: "a"}, {Org: "a"}}},
+		{Orgs: []config.OrgBrandingConfig{{Org: "a", BackgroundColor: "#12345"}}},
+	} {
+		_, err := New(cfg, users, zap.NewNop())
+		assert.Error(t, err, "%+v", cfg)
+	}
+
+	_, err := New(config.BrandingConfig{SupportURL: "mailto:help@example.com", LogoURL: "https://cdn.example.com/logo.png"}, users, zap.NewNop())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,264 @@
+package browse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 1000
+	MaxLimit     = 10000
+
+	// batchSize is how many directory entries are read at a time
+	batchSize = 256
+)
+
+const (
+	SortName     = "name"
+	SortSize     = "size"
+	SortModified = "modified"
+	SortNone     = "none" // directory order, no buffering
+)
+
+type Entry struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size"`
+	Modified    string `json:"modified"`
+	Permissions string `json:"permissions"`
+}
+
+type Options struct {
+	Offset     int
+	Limit      int
+	Pattern    string   // glob matched against the name
+	Extensions []string // e.g. ".log"; empty allows any
+	Hidden     bool     // include dotfiles
+	Sort       string
+	Desc       bool
+}
+
+type Listing struct {
+	Path    string  `json:"path"`
+	Files   []Entry `json:"files"`
+	Total   int     `json:"total"` // entries matching the filters
+	Offset  int     `json:"offset"`
+	Limit   int     `json:"limit"`
+	HasMore bool    `json:"has_more"`
+}
+
+// Validate normalizes limits and rejects unknown sort keys and bad globs
+func (o *Options) Validate() error {
+	if o.Limit <= 0 {
+		o.Limit = DefaultLimit
+	}
+	if o.Limit > MaxLimit {
+		o.Limit = MaxLimit
+	}
+	if o.Offset < 0 {
+		o.Offset = 0
+	}
+	switch o.Sort {
+	case "":
+		o.Sort = SortName
+	case SortName, SortSize, SortModified, SortNone:
+	default:
+		return fmt.Errorf("invalid sort: %s", o.Sort)
+	}
+	if o.Pattern != "" {
+		if _, err := filepath.Match(o.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+	for i, ext := range o.Extensions {
+		if !strings.HasPrefix(ext, ".") {
+			o.Extensions[i] = "." + ext
+		}
+	}
+	return nil
+}
+
+// match applies the name filters, which need no stat call
+func (o *Options) match(name string) bool {
+	if !o.Hidden && strings.HasPrefix(name, ".") {
+		return false
+	}
+	if o.Pattern != "" {
+		if ok, _ := filepath.Match(o.Pattern, name); !ok {
+			return false
+		}
+	}
+	if len(o.Extensions) > 0 {
+		ext := filepath.Ext(name)
+		for _, allowed := range o.Extensions {
+			if strings.EqualFold(ext, allowed) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// List returns one page of a directory. Names are filtered and sorted
+// before anything is stat'ed, so sorting by name only stats the page.
+func List(ctx context.Context, path string, opts Options) (*Listing, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var matched []fs.DirEntry
+	err := walk(ctx, path, func(entry fs.DirEntry) error {
+		if opts.match(entry.Name()) {
+			matched = append(matched, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &Listing{
+		Path:   path,
+		Files:  []Entry{},
+		Total:  len(matched),
+		Offset: opts.Offset,
+		Limit:  opts.Limit,
+	}
+
+	switch opts.Sort {
+	case SortName:
+		sort.Slice(matched, func(i, j int) bool {
+			if opts.Desc {
+				i, j = j, i
+			}
+			return matched[i].Name() < matched[j].Name()
+		})
+	case SortSize, SortModified:
+		return listing, listByInfo(listing, matched, opts)
+	}
+
+	page := paginate(matched, opts.Offset, opts.Limit)
+	for _, entry := range page {
+		if info, err := entry.Info(); err == nil {
+			listing.Files = append(listing.Files, toEntry(entry, info))
+		}
+	}
+	listing.HasMore = opts.Offset+len(page) < len(matched)
+	return listing, nil
+}
+
+// listByInfo sorts on stat fields, which means stat'ing every match
+func listByInfo(listing *Listing, matched []fs.DirEntry, opts Options) error {
+	type statted struct {
+		entry fs.DirEntry
+		info  fs.FileInfo
+	}
+	all := make([]statted, 0, len(matched))
+	for _, entry := range matched {
+		if info, err := entry.Info(); err == nil {
+			all = append(all, statted{entry, info})
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if opts.Desc {
+			i, j = j, i
+		}
+		if opts.Sort == SortSize {
+			return all[i].info.Size() < all[j].info.Size()
+		}
+		return all[i].info.ModTime().Before(all[j].info.ModTime())
+	})
+
+	listing.Total = len(all)
+	page := paginate(all, opts.Offset, opts.Limit)
+	for _, s := range page {
+		listing.Files = append(listing.Files, toEntry(s.entry, s.info))
+	}
+	listing.HasMore = opts.Offset+len(page) < len(all)
+	return nil
+}
+
+// Stream calls fn for each matching entry in directory order without
+// holding the directory in memory, and returns how many matched.
+func Stream(ctx context.Context, path string, opts Options, fn func(Entry) error) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	err := walk(ctx, path, func(entry fs.DirEntry) error {
+		if !opts.match(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		total++
+		return fn(toEntry(entry, info))
+	})
+	return total, err
+}
+
+// walk reads a directory in batches, stopping between batches once ctx is
+// done
+func walk(ctx context.Context, path string, fn func(fs.DirEntry) error) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := dir.ReadDir(batchSize)
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+func toEntry(entry fs.DirEntry, info fs.FileInfo) Entry {
+	fileType := "file"
+	if entry.IsDir() {
+		fileType = "directory"
+	}
+	return Entry{
+		Name:        entry.Name(),
+		Type:        fileType,
+		Size:        info.Size(),
+		Modified:    info.ModTime().UTC().Format(time.RFC3339),
+		Permissions: info.Mode().String(),
+	}
+}
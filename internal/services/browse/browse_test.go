@@ -0,0 +1,219 @@
+package browse
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeDir(t *testing.T) string {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 600; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("app-%03d.log", i))
+		require.NoError(t, os.WriteFile(name, make([]byte, i), 0644))
+		require.NoError(t, os.Chtimes(name, base, base.Add(time.Duration(i)*time.Second)))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), nil, 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "archive"), 0755))
+	return dir
+}
+
+func names(listing *Listing) []string {
+	var result []string
+	for _, entry := range listing.Files {
+		result = append(result, entry.Name)
+	}
+	return result
+}
+
+func TestListPagination(t *testing.T) {
+	dir := makeDir(t)
+	ctx := context.Background()
+
+	listing, err := List(ctx, dir, Options{Limit: 2, Hidden: true})
+	require.NoError(t, err)
+	assert.Equal(t, 603, listing.Total)
+	assert.Equal(t, []string{".hidden", "app-000.log"}, names(listing))
+	assert.True(t, listing.HasMore)
+
+	listing, err = List(ctx, dir, Options{Offset: 601, Limit: 10, Hidden: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"archive", "notes.txt"}, names(listing))
+	assert.False(t, listing.HasMore)
+}
+
+func TestListFilters(t *testing.T) {
+	dir := makeDir(t)
+	ctx := context.Background()
+
+	listing, err := List(ctx, dir, Options{Extensions: []string{"txt"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"notes.txt"}, names(listing))
+
+	listing, err = List(ctx, dir, Options{Pattern: "app-00?.log"})
+	require.NoError(t, err)
+	assert.Equal(t, 10, listing.Total)
+
+	listing, err = List(ctx, dir, Options{Limit: 1000})
+	require.NoError(t, err)
+	assert.Equal(t, 602, listing.Total, "dotfiles hidden")
+
+	_, err = List(ctx, dir, Options{Pattern: "[", Limit: 1})
+	assert.Error(t, err)
+	_, err = List(ctx, dir, Options{Sort: "owner"})
+	assert.Error(t, err)
+}
+
+func TestListSort(t *testing.T) {
+	dir := makeDir(t)
+	ctx := context.Background()
+
+	listing, err := List(ctx, dir, Options{Extensions: []string{".log"}, Sort: SortSize, Desc: true, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app-599.log", "app-598.log"}, names(listing))
+
+	listing, err = List(ctx, dir, Options{Extensions: []string{".log"}, Sort: SortModified, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app-000.log"}, names(listing))
+
+	listing, err = List(ctx, dir, Options{Sort: SortName, Desc: true, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"notes.txt"}, names(listing))
+}
+
+func TestStream(t *testing.T) {
+	dir := makeDir(t)
+	ctx := context.Background()
+
+	seen := 0
+	total, err := Stream(ctx, dir, Options{Extensions: []string{".log"}}, func(Entry) error {
+		seen++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 600, total)
+	assert.Equal(t, 600, seen)
+}
+
+func TestStreamCancelled(t *testing.T) {
+	dir := makeDir(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	seen := 0
+	_, err := Stream(ctx, dir, Options{}, func(Entry) error {
+		if seen++; seen == 10 {
+			cancel()
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, seen, 600, "stops at the next batch")
+}
+
+func makeTree(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"README.md":                 "# app\n",
+		"deploy/app.yaml":           "replicas: 3\n",
+		"deploy/staging/app.yaml":   "replicas: 1\n",
+		"deploy/staging/notes.txt":  "scale replicas down at night\n",
+		"secret/keys.yaml":          "replicas: 9\n",
+		".git/config.yaml":          "replicas: 0\n",
+		"bin/app":                   "\x00ELF replicas",
+		"deploy/staging/Config.YML": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	require.NoError(t, os.Symlink("/etc", filepath.Join(dir, "deploy", "etc")))
+	return dir
+}
+
+func paths(result *SearchResult) []string {
+	var found []string
+	for _, match := range result.Matches {
+		found = append(found, match.Path)
+	}
+	return found
+}
+
+func TestSearch(t *testing.T) {
+	dir := makeTree(t)
+	ctx := context.Background()
+
+	result, err := Search(ctx, dir, SearchOptions{Query: "*.yaml"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy/app.yaml", "deploy/staging/app.yaml", "secret/keys.yaml"}, paths(result),
+		"dot directories are skipped")
+
+	result, err = Search(ctx, dir, SearchOptions{Query: "CONFIG", Hidden: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{".git/config.yaml", "deploy/staging/Config.YML"}, paths(result))
+
+	// Content searches skip binaries and report the first matching line
+	result, err = Search(ctx, dir, SearchOptions{Content: "replicas"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy/app.yaml", "deploy/staging/app.yaml", "deploy/staging/notes.txt", "secret/keys.yaml"}, paths(result))
+	assert.Equal(t, 1, result.Matches[0].Line)
+	assert.Equal(t, "replicas: 3", result.Matches[0].Text)
+
+	result, err = Search(ctx, dir, SearchOptions{Query: "app", MaxDepth: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bin/app", "deploy/app.yaml"}, paths(result), "nothing below deploy/staging is searched")
+
+	result, err = Search(ctx, dir, SearchOptions{Query: ".yaml", Allow: func(path string) bool {
+		return filepath.Base(path) != "secret"
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy/app.yaml", "deploy/staging/app.yaml"}, paths(result))
+
+	_, err = Search(ctx, dir, SearchOptions{})
+	assert.Error(t, err)
+	_, err = Search(ctx, dir, SearchOptions{Query: "[a-"})
+	assert.Error(t, err)
+	_, err = Search(ctx, filepath.Join(dir, "README.md"), SearchOptions{Query: "a"})
+	assert.Error(t, err)
+}
+
+func TestSearchPagination(t *testing.T) {
+	dir := makeDir(t)
+	ctx := context.Background()
+
+	result, err := Search(ctx, dir, SearchOptions{Query: "app-", Offset: 10, Limit: 5})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app-010.log", "app-011.log", "app-012.log", "app-013.log", "app-014.log"}, paths(result))
+	assert.True(t, result.HasMore)
+	assert.Equal(t, 17, result.Scanned, "stops once it knows there is more")
+
+	result, err = Search(ctx, dir, SearchOptions{Query: "app-", Offset: 598, Limit: 5})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app-598.log", "app-599.log"}, paths(result))
+	assert.False(t, result.HasMore)
+	assert.Empty(t, result.Truncated)
+}
+
+func TestSearchTimeout(t *testing.T) {
+	dir := makeDir(t)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	result, err := Search(ctx, dir, SearchOptions{Query: "app-"})
+	require.NoError(t, err)
+	assert.Equal(t, TruncatedTimeout, result.Truncated)
+	assert.Empty(t, result.Matches)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = Search(ctx, dir, SearchOptions{Query: "app-"})
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,266 @@
+package browse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+
+	// MaxScanned is how many entries one search looks at before giving up
+	MaxScanned = 100000
+	// MaxGrepSize skips larger files when searching contents
+	MaxGrepSize = 1 << 20
+
+	// maxLineLength cuts long matching lines in the results
+	maxLineLength = 200
+)
+
+// Reasons a search stopped before walking the whole tree
+const (
+	TruncatedTimeout = "timeout"
+	TruncatedScanned = "scan_limit"
+)
+
+type SearchOptions struct {
+	// Query matches names: a glob when it has any of *?[, otherwise a
+	// case-insensitive substring. Empty matches every name.
+	Query string
+	// Content keeps only text files containing it, case-sensitively
+	Content  string
+	Hidden   bool // look in dotfiles and dot directories
+	MaxDepth int  // directories below the root to descend; 0 for no limit
+	Offset   int
+	Limit    int
+
+	// Allow is asked about every directory before descending and every
+	// match before returning it; nil allows everything
+	Allow func(path string) bool
+}
+
+type SearchMatch struct {
+	Path     string `json:"path"` // relative to the root
+	Type     string `json:"type"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+
+	// Set for content searches: the first matching line
+	Line int    `json:"line,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+type SearchResult struct {
+	Path      string        `json:"path"`
+	Matches   []SearchMatch `json:"matches"`
+	Offset    int           `json:"offset"`
+	Limit     int           `json:"limit"`
+	HasMore   bool          `json:"has_more"`
+	Scanned   int           `json:"scanned"`             // entries looked at
+	Truncated string        `json:"truncated,omitempty"` // why the search stopped early
+}
+
+// Validate normalizes limits and rejects bad globs and empty searches
+func (o *SearchOptions) Validate() error {
+	if o.Query == "" && o.Content == "" {
+		return fmt.Errorf("a name query or content to search for is required")
+	}
+	if o.Limit <= 0 {
+		o.Limit = DefaultSearchLimit
+	}
+	if o.Limit > MaxSearchLimit {
+		o.Limit = MaxSearchLimit
+	}
+	if o.Offset < 0 {
+		o.Offset = 0
+	}
+	if o.MaxDepth < 0 {
+		o.MaxDepth = 0
+	}
+	if o.glob() {
+		if _, err := filepath.Match(o.Query, ""); err != nil {
+			return fmt.Errorf("invalid query: %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *SearchOptions) glob() bool {
+	return strings.ContainsAny(o.Query, "*?[")
+}
+
+func (o *SearchOptions) matchName(name string) bool {
+	if o.Query == "" {
+		return true
+	}
+	if o.glob() {
+		ok, _ := filepath.Match(o.Query, name)
+		return ok
+	}
+	return strings.Contains(strings.ToLower(name), strings.ToLower(o.Query))
+}
+
+// Search walks the tree under root in name order for one page of matches.
+// Symlinks are not followed. It stops early, with what it found so far,
+// after MaxScanned entries or once ctx's deadline passes; a cancelled ctx
+// is an error.
+func Search(ctx context.Context, root string, opts SearchOptions) (*SearchResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	root = filepath.Clean(root)
+	if info, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", root)
+	}
+
+	result := &SearchResult{
+		Path:    root,
+		Matches: []SearchMatch{},
+		Offset:  opts.Offset,
+		Limit:   opts.Limit,
+	}
+	found := 0
+	errStop := errors.New("stop")
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are skipped, not fatal
+			if entry != nil && entry.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if result.Scanned >= MaxScanned {
+			result.Truncated = TruncatedScanned
+			return errStop
+		}
+		result.Scanned++
+
+		rel, _ := filepath.Rel(root, path)
+		name := entry.Name()
+		if !opts.Hidden && strings.HasPrefix(name, ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		// next is what to do after this entry: a directory at the depth
+		// limit can still match, but nothing inside it is looked at
+		var next error
+		if entry.IsDir() {
+			if opts.Allow != nil && !opts.Allow(path) {
+				return fs.SkipDir
+			}
+			if depth := strings.Count(rel, string(filepath.Separator)) + 1; opts.MaxDepth > 0 && depth > opts.MaxDepth {
+				next = fs.SkipDir
+			}
+		}
+		if !opts.matchName(name) {
+			return next
+		}
+
+		if opts.Allow != nil && !entry.IsDir() && !opts.Allow(path) {
+			return next
+		}
+		match, ok := searchEntry(path, rel, entry, opts)
+		if !ok {
+			return next
+		}
+		if found++; found > opts.Offset+opts.Limit {
+			result.HasMore = true
+			return errStop
+		}
+		if found > opts.Offset {
+			result.Matches = append(result.Matches, match)
+		}
+		return next
+	})
+
+	switch {
+	case err == nil, errors.Is(err, errStop):
+	case errors.Is(err, context.DeadlineExceeded):
+		result.Truncated = TruncatedTimeout
+	default:
+		return nil, err
+	}
+	return result, nil
+}
+
+// searchEntry describes a name match, and for content searches checks the
+// file, which must be a regular text file no larger than MaxGrepSize
+func searchEntry(path, rel string, entry fs.DirEntry, opts SearchOptions) (SearchMatch, bool) {
+	info, err := entry.Info()
+	if err != nil {
+		return SearchMatch{}, false
+	}
+	match := SearchMatch{
+		Path:     filepath.ToSlash(rel),
+		Type:     "file",
+		Size:     info.Size(),
+		Modified: info.ModTime().UTC().Format(time.RFC3339),
+	}
+	if entry.IsDir() {
+		match.Type = "directory"
+	}
+	if opts.Content == "" {
+		return match, true
+	}
+	if !info.Mode().IsRegular() || info.Size() > MaxGrepSize {
+		return match, false
+	}
+	line, text, ok := grep(path, opts.Content)
+	match.Line, match.Text = line, text
+	return match, ok
+}
+
+// grep returns the first line of the file containing content, skipping
+// files that look binary
+func grep(path, content string) (int, string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", false
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(io.LimitReader(file, MaxGrepSize))
+	if head, _ := reader.Peek(8192); bytes.IndexByte(head, 0) >= 0 {
+		return 0, "", false
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), MaxGrepSize)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		if i := strings.Index(text, content); i >= 0 {
+			if len(text) > maxLineLength {
+				start := i - maxLineLength/4
+				if start < 0 {
+					start = 0
+				}
+				end := start + maxLineLength
+				if end > len(text) {
+					end = len(text)
+				}
+				text = strings.ToValidUTF8(text[start:end], "")
+			}
+			return n, text, true
+		}
+	}
+	return 0, "", false
+}
@@ -0,0 +1,184 @@
+package checksum
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"go.uber.org/zap"
+)
+
+// Service remembers the SHA-256 of uploaded files so downloads can send a
+// Digest header. A stored digest is only trusted while the file's size and
+// modification time are unchanged.
+type Service struct {
+	db     *database.DB
+	logger *zap.Logger
+
+	// memory is used when there is no database
+	memory map[string]*Record
+	mu     sync.Mutex
+}
+
+type Record struct {
+	Path    string    `json:"path"`
+	SHA256  string    `json:"sha256"` // hex
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func New(db *database.DB, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+		memory: make(map[string]*Record),
+	}
+}
+
+func (s *Service) Store(ctx context.Context, path string, sum []byte, info os.FileInfo) error {
+	record := &Record{
+		Path:    path,
+		SHA256:  hex.EncodeToString(sum),
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+	}
+
+	if s.db == nil {
+		s.mu.Lock()
+		s.memory[path] = record
+		s.mu.Unlock()
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO file_digests (path, sha256, size, mod_time)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (path) DO UPDATE SET
+			sha256 = EXCLUDED.sha256,
+			size = EXCLUDED.size,
+			mod_time = EXCLUDED.mod_time,
+			updated_at = CURRENT_TIMESTAMP`,
+		record.Path, record.SHA256, record.Size, record.ModTime)
+	if err != nil {
+		return fmt.Errorf("failed to store file digest: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns the stored digest for path, or nil when there is none or
+// the file changed since it was recorded.
+func (s *Service) Lookup(ctx context.Context, path string, info os.FileInfo) (*Record, error) {
+	var record *Record
+	if s.db == nil {
+		s.mu.Lock()
+		record = s.memory[path]
+		s.mu.Unlock()
+	} else {
+		record = &Record{Path: path}
+		err := s.db.QueryRowContext(ctx,
+			"SELECT sha256, size, mod_time FROM file_digests WHERE path = $1", path,
+		).Scan(&record.SHA256, &record.Size, &record.ModTime)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up file digest: %w", err)
+		}
+	}
+
+	if record == nil || record.Size != info.Size() || !record.ModTime.Equal(info.ModTime().UTC()) {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// DigestHeader formats a hex SHA-256 for the RFC 3230 Digest header
+func DigestHeader(hexSum string) string {
+	raw, err := hex.DecodeString(hexSum)
+	if err != nil {
+		return ""
+	}
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(raw)
+}
+
+// ETag returns a strong entity tag for the file: its stored SHA-256 when
+// there is a trusted record, otherwise its modification time and size.
+// Resumed downloads send it back in If-Range.
+func ETag(info os.FileInfo, record *Record) string {
+	if record != nil {
+		return `"sha256-` + record.SHA256 + `"`
+	}
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// Verifier hashes data as it is written and compares it with the digest
+// the client claimed.
+type Verifier struct {
+	hash.Hash
+	expected []byte // nil when the client sent no digest
+}
+
+// NewVerifier accepts the expected digest as hex or base64, optionally in
+// Digest header form ("sha-256=..."). An empty string disables checking.
+func NewVerifier(expected string) (*Verifier, error) {
+	v := &Verifier{Hash: sha256.New()}
+	if expected == "" {
+		return v, nil
+	}
+
+	sum, err := parseDigest(expected)
+	if err != nil {
+		return nil, err
+	}
+	v.expected = sum
+	return v, nil
+}
+
+// Verify reports a mismatch between the claimed and computed digests
+func (v *Verifier) Verify() error {
+	if v.expected == nil {
+		return nil
+	}
+	if sum := v.Sum(nil); !bytes.Equal(sum, v.expected) {
+		return fmt.Errorf("checksum mismatch: expected %x, computed %x", v.expected, sum)
+	}
+	return nil
+}
+
+// Hex returns the computed digest
+func (v *Verifier) Hex() string {
+	return hex.EncodeToString(v.Sum(nil))
+}
+
+func parseDigest(s string) ([]byte, error) {
+	// A Digest header may list several algorithms
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if prefix, value, found := strings.Cut(part, "="); found && strings.EqualFold(prefix, "sha-256") {
+			s = value
+			break
+		}
+	}
+	s = strings.TrimSpace(s)
+
+	if sum, err := hex.DecodeString(s); err == nil && len(sum) == sha256.Size {
+		return sum, nil
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if sum, err := enc.DecodeString(s); err == nil && len(sum) == sha256.Size {
+			return sum, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid SHA-256 digest")
+}
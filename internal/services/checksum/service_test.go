@@ -0,0 +1,82 @@
+package checksum
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestVerifier(t *testing.T) {
+	data := []byte("release artifact")
+	sum := sha256.Sum256(data)
+
+	accepted := []string{
+		hex.EncodeToString(sum[:]),
+		base64.StdEncoding.EncodeToString(sum[:]),
+		"SHA-256=" + base64.StdEncoding.EncodeToString(sum[:]),
+		"md5=abc, sha-256=" + base64.StdEncoding.EncodeToString(sum[:]),
+	}
+	for _, expected := range accepted {
+		v, err := NewVerifier(expected)
+		require.NoError(t, err, expected)
+		v.Write(data)
+		assert.NoError(t, v.Verify(), expected)
+		assert.Equal(t, hex.EncodeToString(sum[:]), v.Hex())
+	}
+
+	v, err := NewVerifier(hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	v.Write([]byte("corrupted artifact"))
+	assert.Error(t, v.Verify())
+
+	// No digest means nothing to check
+	v, err = NewVerifier("")
+	require.NoError(t, err)
+	v.Write(data)
+	assert.NoError(t, v.Verify())
+
+	_, err = NewVerifier("not-a-digest")
+	assert.Error(t, err)
+}
+
+func TestStoreLookup(t *testing.T) {
+	service := New(nil, zap.NewNop())
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "artifact.bin")
+	require.NoError(t, os.WriteFile(path, []byte("v1"), 0644))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("v1"))
+	require.NoError(t, service.Store(ctx, path, sum[:], info))
+
+	record, err := service.Lookup(ctx, path, info)
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.Equal(t, "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]), DigestHeader(record.SHA256))
+	assert.Equal(t, `"sha256-`+record.SHA256+`"`, ETag(info, record))
+
+	// A file changed outside of an upload has no trusted digest
+	require.NoError(t, os.WriteFile(path, []byte("v2!"), 0644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+
+	record, err = service.Lookup(ctx, path, info)
+	require.NoError(t, err)
+	assert.Nil(t, record)
+
+	// Without one the tag follows the modification time and size
+	assert.Equal(t, fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), 3), ETag(info, nil))
+}
@@ -0,0 +1,198 @@
+package deps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+var ErrNotReady = errors.New("dependencies not ready")
+
+// attemptTimeout bounds a single check so a host that drops packets does
+// not stall the retry loop
+const attemptTimeout = 5 * time.Second
+
+// Check reports whether a dependency is reachable
+type Check func(ctx context.Context) error
+
+type dependency struct {
+	name  string
+	check Check
+	ready bool
+}
+
+// Service waits for the database and Redis at startup, retrying with
+// exponential backoff. In degraded mode the server starts anyway and Run
+// keeps retrying in the background; routes that need a dependency are
+// gated on Ready until it connects.
+type Service struct {
+	wait       time.Duration
+	initial    time.Duration
+	maxBackoff time.Duration
+	degraded   bool
+	logger     *zap.Logger
+
+	deps []*dependency
+	mu   sync.RWMutex
+}
+
+func New(cfg config.StartupConfig, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		initial:    time.Second,
+		maxBackoff: 15 * time.Second,
+		degraded:   cfg.Degraded,
+		logger:     logger,
+	}
+	for _, setting := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"wait_for_deps", cfg.WaitForDeps, &s.wait},
+		{"retry_interval", cfg.RetryInterval, &s.initial},
+		{"max_retry_interval", cfg.MaxRetryInterval, &s.maxBackoff},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(setting.value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid startup %s %q", setting.name, setting.value)
+		}
+		*setting.dst = d
+	}
+	if s.initial <= 0 {
+		s.initial = time.Second
+	}
+	if s.maxBackoff < s.initial {
+		s.maxBackoff = s.initial
+	}
+	return s, nil
+}
+
+// Add registers a dependency to wait for
+func (s *Service) Add(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deps = append(s.deps, &dependency{name: name, check: check})
+}
+
+// Start waits up to the configured time for every dependency. It returns
+// ErrNotReady naming the missing ones when they do not come up, unless
+// degraded startup is allowed.
+func (s *Service) Start(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.wait)
+	defer cancel()
+
+	err := s.Wait(ctx)
+	if err == nil {
+		return nil
+	}
+	if !s.degraded {
+		return err
+	}
+	s.logger.Warn("Starting in degraded mode; login and admin routes are unavailable until dependencies connect",
+		zap.Strings("pending", s.Pending()))
+	return nil
+}
+
+// Wait retries pending dependencies until all are ready or ctx is done.
+// At least one attempt is made even if ctx has already expired.
+func (s *Service) Wait(ctx context.Context) error {
+	backoff := s.initial
+	for {
+		pending := s.attempt(ctx)
+		if len(pending) == 0 {
+			return nil
+		}
+
+		s.logger.Warn("Waiting for dependencies",
+			zap.Strings("pending", pending),
+			zap.Duration("retry_in", backoff))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s", ErrNotReady, strings.Join(pending, ", "))
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// Run keeps retrying dependencies that were not ready at startup
+func (s *Service) Run(ctx context.Context) {
+	if len(s.Pending()) == 0 {
+		return
+	}
+	if err := s.Wait(ctx); err == nil {
+		s.logger.Info("All dependencies connected, leaving degraded mode")
+	}
+}
+
+func (s *Service) attempt(ctx context.Context) []string {
+	s.mu.RLock()
+	deps := make([]*dependency, len(s.deps))
+	copy(deps, s.deps)
+	s.mu.RUnlock()
+
+	var pending []string
+	for _, dep := range deps {
+		s.mu.RLock()
+		ready := dep.ready
+		s.mu.RUnlock()
+		if ready {
+			continue
+		}
+
+		checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), attemptTimeout)
+		err := dep.check(checkCtx)
+		cancel()
+
+		s.mu.Lock()
+		dep.ready = err == nil
+		s.mu.Unlock()
+
+		if err != nil {
+			s.logger.Debug("Dependency not ready", zap.String("dependency", dep.name), zap.Error(err))
+			pending = append(pending, dep.name)
+			continue
+		}
+		s.logger.Info("Dependency ready", zap.String("dependency", dep.name))
+	}
+	return pending
+}
+
+// Ready reports whether every named dependency has connected
+func (s *Service) Ready(names ...string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, dep := range s.deps {
+		for _, name := range names {
+			if dep.name == name && !dep.ready {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Pending lists dependencies that have not connected yet
+func (s *Service) Pending() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var pending []string
+	for _, dep := range s.deps {
+		if !dep.ready {
+			pending = append(pending, dep.name)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,74 @@
+package deps
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+var errDown = errors.New("connection refused")
+
+// upAfter fails the first n checks
+func upAfter(n int32) Check {
+	var calls atomic.Int32
+	return func(ctx context.Context) error {
+		if calls.Add(1) <= n {
+			return errDown
+		}
+		return nil
+	}
+}
+
+func TestStartRetries(t *testing.T) {
+	service, err := New(config.StartupConfig{
+		WaitForDeps:      "5s",
+		RetryInterval:    "1ms",
+		MaxRetryInterval: "4ms",
+	}, zap.NewNop())
+	require.NoError(t, err)
+	service.Add("database", upAfter(3))
+	service.Add("redis", upAfter(0))
+
+	require.NoError(t, service.Start(context.Background()))
+	assert.True(t, service.Ready("database", "redis"))
+	assert.Empty(t, service.Pending())
+}
+
+func TestStartTimeout(t *testing.T) {
+	service, err := New(config.StartupConfig{WaitForDeps: "0", RetryInterval: "1ms"}, zap.NewNop())
+	require.NoError(t, err)
+	service.Add("database", upAfter(1))
+	service.Add("redis", upAfter(0))
+
+	err = service.Start(context.Background())
+	assert.ErrorIs(t, err, ErrNotReady)
+	assert.ErrorContains(t, err, "database")
+	assert.False(t, service.Ready("database"))
+	assert.True(t, service.Ready("redis"), "checked once despite the zero wait")
+}
+
+func TestDegradedStart(t *testing.T) {
+	service, err := New(config.StartupConfig{WaitForDeps: "0", RetryInterval: "1ms", Degraded: true}, zap.NewNop())
+	require.NoError(t, err)
+	service.Add("database", upAfter(2))
+
+	require.NoError(t, service.Start(context.Background()))
+	assert.Equal(t, []string{"database"}, service.Pending())
+
+	// Run picks up where startup left off
+	service.Run(context.Background())
+	assert.True(t, service.Ready("database"))
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, err := New(config.StartupConfig{WaitForDeps: "soon"}, zap.NewNop())
+	assert.Error(t, err)
+	_, err = New(config.StartupConfig{RetryInterval: "-1s"}, zap.NewNop())
+	assert.Error(t, err)
+}
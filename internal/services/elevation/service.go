@@ -0,0 +1,251 @@
+package elevation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+const (
+	maxJustification = 500
+	// pendingTTL drops requests no admin got to; the user can ask again
+	pendingTTL = time.Hour
+)
+
+var (
+	ErrInvalid  = errors.New("invalid elevation request")
+	ErrNotFound = errors.New("elevation request not found")
+)
+
+// Request is a user's request to elevate one session
+type Request struct {
+	ID            string     `json:"id"`
+	SessionID     string     `json:"session_id"`
+	UserID        string     `json:"user_id"`
+	Minutes       int        `json:"minutes"`
+	Justification string     `json:"justification"`
+	Status        string     `json:"status"`
+	RequestedAt   time.Time  `json:"requested_at"`
+	DecidedBy     string     `json:"decided_by,omitempty"`
+	Reason        string     `json:"reason,omitempty"` // why it was denied
+	Until         *time.Time `json:"until,omitempty"`  // when approved
+}
+
+// RoleLookup resolves a user's role
+type RoleLookup func(userID string) (string, error)
+
+// Elevator is the part of the terminal service that switches elevated mode
+type Elevator interface {
+	Elevate(sessionID, grantedBy string, d time.Duration) (time.Time, error)
+	EndElevation(sessionID string) error
+}
+
+// Recorder persists audit entries
+type Recorder interface {
+	Record(ctx context.Context, entry *audit.Entry) error
+}
+
+// Service decides elevation requests: self-approving roles are elevated at
+// once, other requests wait for an admin. Pending requests are kept in
+// memory only.
+type Service struct {
+	maxDuration time.Duration
+	selfApprove map[string]bool
+	roles       RoleLookup
+	sessions    Elevator
+	recorder    Recorder
+	logger      *zap.Logger
+
+	pending map[string]*Request
+	mu      sync.Mutex
+}
+
+func New(cfg config.ElevationConfig, roles RoleLookup, sessions Elevator, recorder Recorder, logger *zap.Logger) (*Service, error) {
+	maxDuration := time.Hour
+	if cfg.MaxDuration != "" {
+		var err error
+		if maxDuration, err = time.ParseDuration(cfg.MaxDuration); err != nil || maxDuration < time.Minute {
+			return nil, fmt.Errorf("invalid elevation max_duration %q", cfg.MaxDuration)
+		}
+	}
+	selfApprove := make(map[string]bool)
+	for _, role := range cfg.SelfApprove {
+		selfApprove[role] = true
+	}
+
+	return &Service{
+		maxDuration: maxDuration,
+		selfApprove: selfApprove,
+		roles:       roles,
+		sessions:    sessions,
+		recorder:    recorder,
+		logger:      logger,
+		pending:     make(map[string]*Request),
+	}, nil
+}
+
+// Request asks for elevated mode on a session for the given minutes. It is
+// approved at once when the user's role may approve its own, and otherwise
+// waits for an admin, replacing any request pending for the same session.
+func (s *Service) Request(ctx context.Context, sessionID, userID string, minutes int, justification string) (*Request, error) {
+	if justification == "" || len(justification) > maxJustification {
+		return nil, fmt.Errorf("%w: a justification of 1 to %d characters is required", ErrInvalid, maxJustification)
+	}
+	if minutes <= 0 || time.Duration(minutes)*time.Minute > s.maxDuration {
+		return nil, fmt.Errorf("%w: minutes must be 1 to %d", ErrInvalid, int(s.maxDuration/time.Minute))
+	}
+
+	req := &Request{
+		ID:            generateID(),
+		SessionID:     sessionID,
+		UserID:        userID,
+		Minutes:       minutes,
+		Justification: justification,
+		Status:        StatusPending,
+		RequestedAt:   time.Now(),
+	}
+
+	role, err := s.roles(userID)
+	if err != nil {
+		s.logger.Warn("Failed to look up role for elevation", zap.String("user_id", userID), zap.Error(err))
+	}
+	if err == nil && s.selfApprove[role] {
+		return req, s.grant(req, userID)
+	}
+
+	s.mu.Lock()
+	for id, other := range s.pending {
+		if other.SessionID == sessionID {
+			delete(s.pending, id)
+		}
+	}
+	s.pending[req.ID] = req
+	s.mu.Unlock()
+
+	s.logger.Info("Elevation requested",
+		zap.String("session_id", sessionID),
+		zap.String("user_id", userID),
+		zap.Int("minutes", minutes))
+	return req, nil
+}
+
+// Approve elevates the session of a pending request
+func (s *Service) Approve(ctx context.Context, requestID, adminID string) (*Request, error) {
+	req, err := s.take(requestID)
+	if err != nil {
+		return nil, err
+	}
+	return req, s.grant(req, adminID)
+}
+
+// Deny turns a pending request down
+func (s *Service) Deny(ctx context.Context, requestID, adminID, reason string) (*Request, error) {
+	req, err := s.take(requestID)
+	if err != nil {
+		return nil, err
+	}
+	req.Status = StatusDenied
+	req.DecidedBy = adminID
+	req.Reason = reason
+	return req, nil
+}
+
+// Pending lists the requests waiting for an admin, oldest first
+func (s *Service) Pending() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+
+	result := []*Request{}
+	for _, req := range s.pending {
+		copied := *req
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RequestedAt.Before(result[j].RequestedAt)
+	})
+	return result
+}
+
+// End drops a session back to the standard policy before its elevation
+// runs out
+func (s *Service) End(sessionID string) error {
+	return s.sessions.EndElevation(sessionID)
+}
+
+// HandleSessionEvent records elevations that ran out in the audit trail;
+// grants and early ends are recorded where they are made
+func (s *Service) HandleSessionEvent(event terminal.Event) {
+	if event.Type != terminal.EventElevationEnded || event.Detail["how"] != "expired" {
+		return
+	}
+	entry := &audit.Entry{
+		Action:       audit.ActionElevationEnd,
+		ResourceType: "session",
+		ResourceID:   event.SessionID,
+		Details: map[string]interface{}{
+			"owner_id":   event.UserID,
+			"granted_by": event.Detail["granted_by"],
+			"expired":    true,
+		},
+	}
+	if err := s.recorder.Record(context.Background(), entry); err != nil {
+		s.logger.Error("Failed to record elevation expiry", zap.Error(err))
+	}
+}
+
+func (s *Service) grant(req *Request, grantedBy string) error {
+	until, err := s.sessions.Elevate(req.SessionID, grantedBy, time.Duration(req.Minutes)*time.Minute)
+	if err != nil {
+		return err
+	}
+	req.Status = StatusApproved
+	req.DecidedBy = grantedBy
+	req.Until = &until
+	return nil
+}
+
+// take removes a pending request for a decision
+func (s *Service) take(requestID string) (*Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+
+	req, ok := s.pending[requestID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, requestID)
+	}
+	delete(s.pending, requestID)
+	return req, nil
+}
+
+// prune drops requests pending longer than pendingTTL. Callers hold mu.
+func (s *Service) prune(now time.Time) {
+	for id, req := range s.pending {
+		if now.Sub(req.RequestedAt) > pendingTTL {
+			delete(s.pending, id)
+		}
+	}
+}
+
+func generateID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
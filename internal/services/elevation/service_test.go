@@ -0,0 +1,165 @@
+package elevation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+type fakeElevator map[string]string // session ID to who elevated it
+
+func (f fakeElevator) Elevate(sessionID, grantedBy string, d time.Duration) (time.Time, error) {
+	if sessionID == "gone" {
+		return time.Time{}, terminal.ErrSessionNotFound
+	}
+	f[sessionID] = grantedBy
+	return time.Now().Add(d), nil
+}
+
+func (f fakeElevator) EndElevation(sessionID string) error {
+	if _, ok := f[sessionID]; !ok {
+		return terminal.ErrNotElevated
+	}
+	delete(f, sessionID)
+	return nil
+}
+
+type fakeRecorder []*audit.Entry
+
+func (f *fakeRecorder) Record(ctx context.Context, entry *audit.Entry) error {
+	*f = append(*f, entry)
+	return nil
+}
+
+func newTestService(t *testing.T) (*Service, fakeElevator, *fakeRecorder) {
+	t.Helper()
+	roles := map[string]string{"alice": "oncall", "bob": "user"}
+	lookup := func(userID string) (string, error) {
+		role, ok := roles[userID]
+		if !ok {
+			return "", errors.New("user not found")
+		}
+		return role, nil
+	}
+	elevator, recorder := fakeElevator{}, &fakeRecorder{}
+	service, err := New(config.ElevationConfig{
+		MaxDuration: "30m",
+		SelfApprove: []string{"oncall"},
+	}, lookup, elevator, recorder, zap.NewNop())
+	require.NoError(t, err)
+	return service, elevator, recorder
+}
+
+func TestSelfApprove(t *testing.T) {
+	service, elevator, _ := newTestService(t)
+	ctx := context.Background()
+
+	req, err := service.Request(ctx, "s1", "alice", 15, "restarting the stuck worker")
+	require.NoError(t, err)
+	assert.Equal(t, StatusApproved, req.Status)
+	assert.Equal(t, "alice", req.DecidedBy)
+	require.NotNil(t, req.Until)
+	assert.Equal(t, "alice", elevator["s1"])
+	assert.Empty(t, service.Pending())
+
+	require.NoError(t, service.End("s1"))
+	assert.ErrorIs(t, service.End("s1"), terminal.ErrNotElevated)
+}
+
+func TestAdminApproval(t *testing.T) {
+	service, elevator, _ := newTestService(t)
+	ctx := context.Background()
+
+	first, err := service.Request(ctx, "s1", "bob", 10, "first try")
+	require.NoError(t, err)
+	req, err := service.Request(ctx, "s1", "bob", 20, "inspect the docker daemon")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, req.Status)
+	assert.Empty(t, elevator)
+
+	// A new request replaces the session's pending one
+	pending := service.Pending()
+	require.Len(t, pending, 1)
+	assert.Equal(t, req.ID, pending[0].ID)
+	_, err = service.Approve(ctx, first.ID, "admin")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	approved, err := service.Approve(ctx, req.ID, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, StatusApproved, approved.Status)
+	assert.Equal(t, "admin", elevator["s1"])
+	assert.Empty(t, service.Pending())
+
+	req, err = service.Request(ctx, "s2", "bob", 5, "again")
+	require.NoError(t, err)
+	denied, err := service.Deny(ctx, req.ID, "admin", "use the runbook")
+	require.NoError(t, err)
+	assert.Equal(t, StatusDenied, denied.Status)
+	assert.Equal(t, "use the runbook", denied.Reason)
+	_, ok := elevator["s2"]
+	assert.False(t, ok)
+
+	// Requests pending too long are dropped
+	req, err = service.Request(ctx, "s3", "bob", 5, "late")
+	require.NoError(t, err)
+	service.mu.Lock()
+	service.pending[req.ID].RequestedAt = time.Now().Add(-2 * pendingTTL)
+	service.mu.Unlock()
+	_, err = service.Approve(ctx, req.ID, "admin")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// The session may be gone by the time an admin looks
+	req, err = service.Request(ctx, "gone", "bob", 5, "too slow")
+	require.NoError(t, err)
+	_, err = service.Approve(ctx, req.ID, "admin")
+	assert.ErrorIs(t, err, terminal.ErrSessionNotFound)
+}
+
+func TestRequestValidates(t *testing.T) {
+	service, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	_, err := service.Request(ctx, "s1", "alice", 15, "")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = service.Request(ctx, "s1", "alice", 0, "why")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = service.Request(ctx, "s1", "alice", 31, "why")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// An unknown role never approves itself
+	req, err := service.Request(ctx, "s1", "mallory", 5, "why")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, req.Status)
+}
+
+func TestExpiryAudited(t *testing.T) {
+	service, _, recorder := newTestService(t)
+
+	service.HandleSessionEvent(terminal.Event{
+		Type:      terminal.EventElevationEnded,
+		SessionID: "s1",
+		UserID:    "bob",
+		Detail:    map[string]string{"granted_by": "admin", "how": "ended"},
+	})
+	assert.Empty(t, *recorder, "early ends are recorded by whoever ended it")
+
+	service.HandleSessionEvent(terminal.Event{
+		Type:      terminal.EventElevationEnded,
+		SessionID: "s1",
+		UserID:    "bob",
+		Detail:    map[string]string{"granted_by": "admin", "how": "expired"},
+	})
+	require.Len(t, *recorder, 1)
+	entry := (*recorder)[0]
+	assert.Equal(t, audit.ActionElevationEnd, entry.Action)
+	assert.Equal(t, "s1", entry.ResourceID)
+	assert.Equal(t, true, entry.Details["expired"])
+}
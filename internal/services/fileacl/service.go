@@ -0,0 +1,228 @@
+package fileacl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/internal/services/policy"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Access levels a rule can grant. Write implies read.
+const (
+	AccessNone  = "none"
+	AccessRead  = "read"
+	AccessWrite = "write"
+)
+
+var rank = map[string]int{AccessNone: 0, AccessRead: 1, AccessWrite: 2}
+
+// How specifically a rule names the user; a more specific rule on the same
+// path wins
+const (
+	matchEveryone = iota
+	matchGroup
+	matchUser
+)
+
+// maxLinks bounds the symlinks followed resolving one path, as the kernel's
+// ELOOP limit does
+const maxLinks = 40
+
+// UserLookup resolves the role and org a user's group rules match against
+type UserLookup interface {
+	GetUserByID(userID string) (*auth.User, error)
+}
+
+// Rule is a validated config rule with its path cleaned
+type Rule struct {
+	Path   string   `json:"path"`
+	Users  []string `json:"users,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	Orgs   []string `json:"orgs,omitempty"`
+	Access string   `json:"access"`
+}
+
+// Service layers per-directory ACLs over another authorizer, normally the
+// policy engine. File actions must pass both; other actions only the next
+// authorizer.
+type Service struct {
+	next          terminal.Authorizer
+	users         UserLookup
+	rules         []Rule
+	defaultAccess string
+	logger        *zap.Logger
+}
+
+func New(cfg config.FileACLConfig, next terminal.Authorizer, users UserLookup, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		next:          next,
+		users:         users,
+		defaultAccess: cfg.Default,
+		logger:        logger,
+	}
+	if s.defaultAccess == "" {
+		s.defaultAccess = AccessWrite
+	}
+	if _, ok := rank[s.defaultAccess]; !ok {
+		return nil, fmt.Errorf("invalid file_acl default %q: must be read, write or none", cfg.Default)
+	}
+
+	for i, r := range cfg.Rules {
+		if !filepath.IsAbs(r.Path) {
+			return nil, fmt.Errorf("file_acl rule %d: path %q must be absolute", i, r.Path)
+		}
+		if _, ok := rank[r.Access]; !ok {
+			return nil, fmt.Errorf("file_acl rule %d: invalid access %q: must be read, write or none", i, r.Access)
+		}
+		// A rule on a symlinked directory covers the paths it leads to
+		s.rules = append(s.rules, Rule{
+			Path:   resolve(filepath.Clean(r.Path), 0),
+			Users:  r.Users,
+			Roles:  r.Roles,
+			Orgs:   r.Orgs,
+			Access: r.Access,
+		})
+	}
+	return s, nil
+}
+
+// Authorize checks the next authorizer first, then the ACLs for file reads
+// and writes
+func (s *Service) Authorize(ctx context.Context, userID, action string, resource map[string]interface{}) (bool, string) {
+	if allowed, reason := s.next.Authorize(ctx, userID, action, resource); !allowed {
+		return false, reason
+	}
+
+	var need string
+	switch action {
+	case policy.ActionFileRead:
+		need = AccessRead
+	case policy.ActionFileWrite:
+		need = AccessWrite
+	default:
+		return true, ""
+	}
+
+	path, _ := resource["path"].(string)
+	if access := s.Access(userID, path); rank[access] < rank[need] {
+		s.logger.Info("File ACL denied access",
+			zap.String("user_id", userID),
+			zap.String("action", action),
+			zap.String("path", path),
+			zap.String("access", access))
+		return false, fmt.Sprintf("%s access to %s is %s", need, path, access)
+	}
+	return true, ""
+}
+
+// Access returns the user's access to a path. The rule with the longest
+// matching path wins; among rules for the same path one naming the user
+// beats one matching their role or org, which beats one for everyone, and
+// between equally specific rules the most restrictive wins. Paths no rule
+// covers get the default. Rules match the path with symlinks resolved, so
+// a link cannot lead somewhere a rule denies, and users who cannot be
+// looked up get no access, since their group rules cannot be checked.
+func (s *Service) Access(userID, path string) string {
+	user, err := s.users.GetUserByID(userID)
+	if err != nil {
+		s.logger.Warn("File ACL user lookup failed", zap.String("user_id", userID), zap.Error(err))
+		return AccessNone
+	}
+	role, org := user.Role, user.OrgID
+
+	// Relative paths are opened against the working directory
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = resolve(path, 0)
+	access := s.defaultAccess
+	bestLen, bestMatch := -1, -1
+	for _, r := range s.rules {
+		if !within(path, r.Path) {
+			continue
+		}
+		match, ok := matches(r, userID, role, org)
+		if !ok {
+			continue
+		}
+		switch {
+		case len(r.Path) > bestLen, len(r.Path) == bestLen && match > bestMatch:
+			access = r.Access
+		case len(r.Path) == bestLen && match == bestMatch && rank[r.Access] < rank[access]:
+			access = r.Access
+		default:
+			continue
+		}
+		bestLen, bestMatch = len(r.Path), match
+	}
+	return access
+}
+
+// Rules returns the configured rules, for showing to admins
+func (s *Service) Rules() []Rule {
+	return append([]Rule{}, s.rules...)
+}
+
+// Default is the access to paths no rule covers
+func (s *Service) Default() string {
+	return s.defaultAccess
+}
+
+func matches(r Rule, userID, role, org string) (int, bool) {
+	if len(r.Users) == 0 && len(r.Roles) == 0 && len(r.Orgs) == 0 {
+		return matchEveryone, true
+	}
+	if contains(r.Users, userID) {
+		return matchUser, true
+	}
+	if (role != "" && contains(r.Roles, role)) || (org != "" && contains(r.Orgs, org)) {
+		return matchGroup, true
+	}
+	return 0, false
+}
+
+// resolve follows the symlinks in an absolute path as opening it would.
+// Parts that do not exist yet are kept below the nearest parent that does,
+// and a dangling link resolves to its target, which writing would create.
+func resolve(path string, links int) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	dir := filepath.Dir(path)
+	if dir == path {
+		return path
+	}
+	parent := resolve(dir, links)
+	path = filepath.Join(parent, filepath.Base(path))
+	if target, err := os.Readlink(path); err == nil && links < maxLinks {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(parent, target)
+		}
+		return resolve(target, links+1)
+	}
+	return path
+}
+
+// within reports whether path is dir or below it
+func within(path, dir string) bool {
+	if dir == string(filepath.Separator) {
+		return true
+	}
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
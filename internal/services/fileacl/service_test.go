@@ -0,0 +1,118 @@
+package fileacl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/internal/services/policy"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+type fakeUsers map[string]*auth.User
+
+func (f fakeUsers) GetUserByID(userID string) (*auth.User, error) {
+	if user, ok := f[userID]; ok {
+		return user, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+type allowAll struct{}
+
+func (allowAll) Authorize(ctx context.Context, userID, action string, resource map[string]interface{}) (bool, string) {
+	return true, ""
+}
+
+func TestAccess(t *testing.T) {
+	users := fakeUsers{
+		"alice": {Role: "admin"},
+		"bob":   {Role: "user", OrgID: "acme"},
+		"carol": {Role: "user"},
+	}
+	service, err := New(config.FileACLConfig{
+		Default: AccessNone,
+		Rules: []config.FileACLRule{
+			{Path: "/srv/projects", Access: AccessRead},
+			{Path: "/srv/projects", Roles: []string{"admin"}, Access: AccessWrite},
+			{Path: "/srv/projects/acme", Orgs: []string{"acme"}, Access: AccessWrite},
+			{Path: "/srv/projects/acme/secrets", Access: AccessNone},
+			{Path: "/srv/projects/acme/secrets", Users: []string{"bob"}, Access: AccessRead},
+			{Path: "/tmp", Access: AccessWrite},
+		},
+	}, allowAll{}, users, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.Equal(t, AccessRead, service.Access("carol", "/srv/projects/acme/main.go"))
+	assert.Equal(t, AccessWrite, service.Access("bob", "/srv/projects/acme/main.go"))
+	assert.Equal(t, AccessWrite, service.Access("alice", "/srv/projects/other"))
+	assert.Equal(t, AccessNone, service.Access("carol", "/srv/projects/acme/secrets/key"))
+	assert.Equal(t, AccessRead, service.Access("bob", "/srv/projects/acme/secrets/key"), "a user rule beats the rule for everyone")
+	assert.Equal(t, AccessNone, service.Access("carol", "/srv/projects-old"), "prefixes stop at path boundaries")
+	assert.Equal(t, AccessNone, service.Access("carol", "/srv/projects/../etc/passwd"))
+	assert.Equal(t, AccessWrite, service.Access("carol", "/tmp/x"))
+	assert.Equal(t, AccessNone, service.Access("mallory", "/tmp/x"), "users who cannot be looked up get nothing")
+
+	ctx := context.Background()
+	allowed, _ := service.Authorize(ctx, "carol", policy.ActionFileRead, map[string]interface{}{"path": "/srv/projects/readme"})
+	assert.True(t, allowed)
+	allowed, reason := service.Authorize(ctx, "carol", policy.ActionFileWrite, map[string]interface{}{"path": "/srv/projects/readme"})
+	assert.False(t, allowed)
+	assert.NotEmpty(t, reason)
+	allowed, _ = service.Authorize(ctx, "carol", policy.ActionCommandRun, map[string]interface{}{"command": "ls"})
+	assert.True(t, allowed, "non-file actions are left to the next authorizer")
+}
+
+func TestSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	secret, open := filepath.Join(dir, "secret"), filepath.Join(dir, "open")
+	require.NoError(t, os.Mkdir(secret, 0o755))
+	require.NoError(t, os.Mkdir(open, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(secret, "key"), []byte("key"), 0o600))
+	require.NoError(t, os.Symlink(secret, filepath.Join(open, "dir")))
+	require.NoError(t, os.Symlink("../secret/key", filepath.Join(open, "key")))
+	require.NoError(t, os.Symlink("../secret/new", filepath.Join(open, "dangling")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(open, "loop")))
+
+	service, err := New(config.FileACLConfig{Rules: []config.FileACLRule{
+		{Path: secret, Access: AccessNone},
+	}}, allowAll{}, fakeUsers{"carol": {Role: "user"}}, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.Equal(t, AccessWrite, service.Access("carol", filepath.Join(open, "notes")))
+	for _, path := range []string{
+		filepath.Join(open, "key"),
+		filepath.Join(open, "dir", "key"),
+		filepath.Join(open, "dir", "new", "file"),
+		filepath.Join(open, "dangling"),
+	} {
+		assert.Equal(t, AccessNone, service.Access("carol", path), path)
+	}
+	assert.Equal(t, AccessWrite, service.Access("carol", filepath.Join(open, "loop")), "loops end")
+}
+
+func TestSameLevelMostRestrictive(t *testing.T) {
+	service, err := New(config.FileACLConfig{Rules: []config.FileACLRule{
+		{Path: "/data", Roles: []string{"user"}, Access: AccessWrite},
+		{Path: "/data", Orgs: []string{"acme"}, Access: AccessRead},
+	}}, allowAll{}, fakeUsers{"bob": {Role: "user", OrgID: "acme"}}, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.Equal(t, AccessRead, service.Access("bob", "/data/file"))
+	assert.Equal(t, AccessWrite, service.Access("bob", "/elsewhere"), "write is the default")
+}
+
+func TestNewValidates(t *testing.T) {
+	_, err := New(config.FileACLConfig{Rules: []config.FileACLRule{{Path: "srv", Access: AccessRead}}}, allowAll{}, fakeUsers{}, zap.NewNop())
+	assert.Error(t, err)
+	_, err = New(config.FileACLConfig{Rules: []config.FileACLRule{{Path: "/srv", Access: "admin"}}}, allowAll{}, fakeUsers{}, zap.NewNop())
+	assert.Error(t, err)
+	_, err = New(config.FileACLConfig{Default: "all"}, allowAll{}, fakeUsers{}, zap.NewNop())
+	assert.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+type Service struct {
+	config   config.FlagsConfig
+	db       *database.DB
+	logger   *zap.Logger
+	cache    map[string]*Flag
+	loadedAt time.Time
+	mu       sync.RWMutex
+}
+
+// Flag gates a feature. A disabled flag is off for everyone; an enabled flag
+// is on for listed users and orgs plus a stable percentage of other users.
+type Flag struct {
+	Name              string    `json:"name"`
+	Description       string    `json:"description"`
+	Enabled           bool      `json:"enabled"`
+	RolloutPercentage int       `json:"rollout_percentage"`
+	UserIDs           []string  `json:"user_ids"`
+	OrgIDs            []string  `json:"org_ids"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+func New(cfg config.FlagsConfig, db *database.DB, logger *zap.Logger) *Service {
+	return &Service{
+		config: cfg,
+		db:     db,
+		logger: logger,
+		cache:  make(map[string]*Flag),
+	}
+}
+
+// IsEnabled reports whether the named flag is on for the given user.
+// Unknown flags and lookup failures are treated as off.
+func (s *Service) IsEnabled(ctx context.Context, name, userID, orgID string) bool {
+	flags, err := s.load(ctx)
+	if err != nil {
+		s.logger.Warn("Failed to load feature flags", zap.Error(err))
+		return false
+	}
+
+	flag, exists := flags[name]
+	if !exists {
+		return false
+	}
+	return flag.enabledFor(userID, orgID)
+}
+
+// Evaluate returns the state of every known flag for the given user.
+func (s *Service) Evaluate(ctx context.Context, userID, orgID string) (map[string]bool, error) {
+	flags, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(flags))
+	for name, flag := range flags {
+		result[name] = flag.enabledFor(userID, orgID)
+	}
+	return result, nil
+}
+
+func (s *Service) List(ctx context.Context) ([]*Flag, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT name, COALESCE(description, ''), enabled, rollout_percentage,
+		       user_ids, org_ids, created_at, updated_at
+		FROM feature_flags
+		ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feature flags: %w", err)
+	}
+	defer rows.Close()
+
+	var flags []*Flag
+	for rows.Next() {
+		flag := &Flag{}
+		if err := rows.Scan(
+			&flag.Name,
+			&flag.Description,
+			&flag.Enabled,
+			&flag.RolloutPercentage,
+			pq.Array(&flag.UserIDs),
+			pq.Array(&flag.OrgIDs),
+			&flag.CreatedAt,
+			&flag.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan feature flag: %w", err)
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+func (s *Service) Upsert(ctx context.Context, flag *Flag) error {
+	if flag.Name == "" {
+		return fmt.Errorf("flag name is required")
+	}
+	if flag.RolloutPercentage < 0 || flag.RolloutPercentage > 100 {
+		return fmt.Errorf("rollout percentage must be between 0 and 100")
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO feature_flags (name, description, enabled, rollout_percentage, user_ids, org_ids)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name) DO UPDATE SET
+			description = EXCLUDED.description,
+			enabled = EXCLUDED.enabled,
+			rollout_percentage = EXCLUDED.rollout_percentage,
+			user_ids = EXCLUDED.user_ids,
+			org_ids = EXCLUDED.org_ids,
+			updated_at = CURRENT_TIMESTAMP`,
+		flag.Name,
+		flag.Description,
+		flag.Enabled,
+		flag.RolloutPercentage,
+		pq.Array(flag.UserIDs),
+		pq.Array(flag.OrgIDs),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save feature flag: %w", err)
+	}
+
+	s.invalidate()
+	s.logger.Info("Feature flag updated",
+		zap.String("flag", flag.Name),
+		zap.Bool("enabled", flag.Enabled),
+		zap.Int("rollout_percentage", flag.RolloutPercentage),
+	)
+	return nil
+}
+
+func (s *Service) Delete(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM feature_flags WHERE name = $1`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete feature flag: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("feature flag not found: %s", name)
+	}
+
+	s.invalidate()
+	s.logger.Info("Feature flag deleted", zap.String("flag", name))
+	return nil
+}
+
+// load returns the cached flag set, refreshing it from the database once the
+// configured TTL has elapsed.
+func (s *Service) load(ctx context.Context) (map[string]*Flag, error) {
+	s.mu.RLock()
+	if !s.loadedAt.IsZero() && time.Since(s.loadedAt) < s.cacheTTL() {
+		cache := s.cache
+		s.mu.RUnlock()
+		return cache, nil
+	}
+	s.mu.RUnlock()
+
+	flags, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := make(map[string]*Flag, len(flags))
+	for _, flag := range flags {
+		cache[flag.Name] = flag
+	}
+
+	s.mu.Lock()
+	s.cache = cache
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+
+	return cache, nil
+}
+
+func (s *Service) invalidate() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *Service) cacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(s.config.CacheTTL)
+	if err != nil {
+		return 30 * time.Second // default
+	}
+	return ttl
+}
+
+func (f *Flag) enabledFor(userID, orgID string) bool {
+	if !f.Enabled {
+		return false
+	}
+	for _, id := range f.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	if orgID != "" {
+		for _, id := range f.OrgIDs {
+			if id == orgID {
+				return true
+			}
+		}
+	}
+	return rolloutBucket(f.Name, userID) < f.RolloutPercentage
+}
+
+// rolloutBucket maps a user to a stable bucket in [0, 100) per flag, so a
+// user stays in the rollout as the percentage grows.
+func rolloutBucket(flagName, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(flagName + ":" + userID))
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,59 @@
+package flags
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlagDisabled(t *testing.T) {
+	flag := &Flag{
+		Name:              "binary-protocol",
+		Enabled:           false,
+		RolloutPercentage: 100,
+		UserIDs:           []string{"user123"},
+	}
+
+	// Disabled flags are off even for listed users
+	assert.False(t, flag.enabledFor("user123", ""))
+	assert.False(t, flag.enabledFor("other", ""))
+}
+
+func TestFlagUserAndOrgTargeting(t *testing.T) {
+	flag := &Flag{
+		Name:    "collaborative-mode",
+		Enabled: true,
+		UserIDs: []string{"user123"},
+		OrgIDs:  []string{"acme"},
+	}
+
+	assert.True(t, flag.enabledFor("user123", ""))
+	assert.True(t, flag.enabledFor("someone", "acme"))
+	assert.False(t, flag.enabledFor("someone", "globex"))
+	assert.False(t, flag.enabledFor("someone", ""))
+}
+
+func TestFlagPercentageRollout(t *testing.T) {
+	flag := &Flag{
+		Name:              "new-backend",
+		Enabled:           true,
+		RolloutPercentage: 30,
+	}
+
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		if flag.enabledFor(fmt.Sprintf("user%d", i), "") {
+			enabled++
+		}
+	}
+
+	// Roughly 30% of users should be in the rollout
+	assert.InDelta(t, 300, enabled, 60)
+
+	// Buckets are stable for a given user
+	assert.Equal(t, rolloutBucket("new-backend", "user1"), rolloutBucket("new-backend", "user1"))
+
+	flag.RolloutPercentage = 100
+	assert.True(t, flag.enabledFor("anyone", ""))
+}
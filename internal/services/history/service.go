@@ -0,0 +1,206 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// historyLimit caps the sessions listed per user, and those kept per user
+// in memory
+const historyLimit = 200
+
+// Service records terminal sessions as they start and end. Running
+// sessions are recorded with the node that runs them, so each node only
+// restores its own after a restart. Without a database records live only
+// in memory, which keeps ended sessions listable but restores nothing.
+type Service struct {
+	db     *database.DB
+	node   string
+	logger *zap.Logger
+
+	memory map[string]terminal.SessionRecord
+	mu     sync.Mutex
+}
+
+func New(db *database.DB, logger *zap.Logger) *Service {
+	node, err := os.Hostname()
+	if err != nil {
+		logger.Warn("Failed to read host name for session history", zap.Error(err))
+	}
+	return &Service{
+		db:     db,
+		node:   node,
+		logger: logger,
+		memory: make(map[string]terminal.SessionRecord),
+	}
+}
+
+// SaveSession inserts or updates the session's record
+func (s *Service) SaveSession(ctx context.Context, record terminal.SessionRecord) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.memory[record.ID] = record
+		s.prune(record.UserID)
+		return nil
+	}
+
+	env, err := json.Marshal(record.Env)
+	if err != nil {
+		return fmt.Errorf("failed to encode session environment: %w", err)
+	}
+	tags, err := json.Marshal(record.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to encode session tags: %w", err)
+	}
+	limits, err := json.Marshal(record.Limits)
+	if err != nil {
+		return fmt.Errorf("failed to encode session limits: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO terminal_sessions (uuid, owner_id, command, working_directory, env, status, exit_code, exit_reason, node, created_at, ended_at, name, tags, template, shell, login_shell, limits, charset)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11, NULLIF($12, ''), $13, NULLIF($14, ''), NULLIF($15, ''), $16, $17, NULLIF($18, ''))
+		ON CONFLICT (uuid) DO UPDATE SET
+			command = EXCLUDED.command,
+			name = EXCLUDED.name,
+			tags = EXCLUDED.tags,
+			env = EXCLUDED.env,
+			status = EXCLUDED.status,
+			exit_code = EXCLUDED.exit_code,
+			exit_reason = EXCLUDED.exit_reason,
+			node = EXCLUDED.node,
+			ended_at = EXCLUDED.ended_at,
+			last_active = CURRENT_TIMESTAMP`,
+		record.ID, record.UserID, record.Command, record.WorkingDir, env,
+		string(record.Status), record.ExitCode, string(record.ExitReason), s.node, record.CreatedAt, record.EndedAt, record.Name, tags,
+		record.Template, record.Shell, record.Login, limits, record.Charset)
+	if err != nil {
+		return fmt.Errorf("failed to save session record: %w", err)
+	}
+	return nil
+}
+
+// SessionHistory lists the user's recorded sessions, newest first
+func (s *Service) SessionHistory(ctx context.Context, userID string) ([]terminal.SessionRecord, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.matching(func(r terminal.SessionRecord) bool { return r.UserID == userID }), nil
+	}
+
+	return s.query(ctx, `
+		SELECT uuid, owner_id, command, COALESCE(working_directory, ''), env, status, exit_code, COALESCE(exit_reason, ''), created_at, ended_at, COALESCE(name, ''), tags, COALESCE(template, ''), COALESCE(shell, ''), login_shell, limits, COALESCE(charset, '')
+		FROM terminal_sessions WHERE owner_id = $1
+		ORDER BY created_at DESC LIMIT $2`, userID, historyLimit)
+}
+
+// RunningSessions lists the sessions this node recorded as running
+func (s *Service) RunningSessions(ctx context.Context) ([]terminal.SessionRecord, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.matching(func(r terminal.SessionRecord) bool { return r.Status == terminal.StatusRunning }), nil
+	}
+
+	return s.query(ctx, `
+		SELECT uuid, owner_id, command, COALESCE(working_directory, ''), env, status, exit_code, COALESCE(exit_reason, ''), created_at, ended_at, COALESCE(name, ''), tags, COALESCE(template, ''), COALESCE(shell, ''), login_shell, limits, COALESCE(charset, '')
+		FROM terminal_sessions WHERE status = $1 AND node = $2 AND owner_id IS NOT NULL
+		ORDER BY created_at`, string(terminal.StatusRunning), s.node)
+}
+
+func (s *Service) query(ctx context.Context, query string, args ...interface{}) ([]terminal.SessionRecord, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session records: %w", err)
+	}
+	defer rows.Close()
+
+	result := []terminal.SessionRecord{}
+	for rows.Next() {
+		var record terminal.SessionRecord
+		var env, tags, limits []byte
+		var status, reason string
+		var exitCode sql.NullInt64
+		var endedAt sql.NullTime
+		if err := rows.Scan(&record.ID, &record.UserID, &record.Command, &record.WorkingDir, &env,
+			&status, &exitCode, &reason, &record.CreatedAt, &endedAt, &record.Name, &tags,
+			&record.Template, &record.Shell, &record.Login, &limits, &record.Charset); err != nil {
+			return nil, fmt.Errorf("failed to scan session record: %w", err)
+		}
+		if len(env) > 0 {
+			if err := json.Unmarshal(env, &record.Env); err != nil {
+				return nil, fmt.Errorf("failed to decode session environment: %w", err)
+			}
+		}
+		if len(tags) > 0 {
+			if err := json.Unmarshal(tags, &record.Tags); err != nil {
+				return nil, fmt.Errorf("failed to decode session tags: %w", err)
+			}
+		}
+		if len(limits) > 0 {
+			if err := json.Unmarshal(limits, &record.Limits); err != nil {
+				return nil, fmt.Errorf("failed to decode session limits: %w", err)
+			}
+		}
+		record.Status, record.ExitReason = terminal.Status(status), terminal.ExitReason(reason)
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			record.ExitCode = &code
+		}
+		if endedAt.Valid {
+			record.EndedAt = &endedAt.Time
+		}
+		result = append(result, record)
+	}
+	return result, rows.Err()
+}
+
+// matching returns the matching in-memory records, newest first. Callers
+// hold mu.
+func (s *Service) matching(match func(terminal.SessionRecord) bool) []terminal.SessionRecord {
+	result := []terminal.SessionRecord{}
+	for _, record := range s.memory {
+		if match(record) {
+			result = append(result, record)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	if len(result) > historyLimit {
+		result = result[:historyLimit]
+	}
+	return result
+}
+
+// prune drops the user's oldest ended sessions from memory beyond the
+// history limit. Callers hold mu.
+func (s *Service) prune(userID string) {
+	count := 0
+	var ended []terminal.SessionRecord
+	for _, record := range s.memory {
+		if record.UserID != userID {
+			continue
+		}
+		count++
+		if record.Status != terminal.StatusRunning {
+			ended = append(ended, record)
+		}
+	}
+	sort.Slice(ended, func(i, j int) bool {
+		return ended[i].CreatedAt.Before(ended[j].CreatedAt)
+	})
+	for i := 0; count > historyLimit && i < len(ended); i++ {
+		delete(s.memory, ended[i].ID)
+		count--
+	}
+}
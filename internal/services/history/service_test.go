@@ -0,0 +1,73 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+func TestHistory(t *testing.T) {
+	service := New(nil, zap.NewNop())
+	ctx := context.Background()
+	start := time.Now()
+
+	code := 3
+	require.NoError(t, service.SaveSession(ctx, terminal.SessionRecord{
+		ID: "a", UserID: "alice", Command: "make", Status: terminal.StatusRunning, CreatedAt: start,
+	}))
+	require.NoError(t, service.SaveSession(ctx, terminal.SessionRecord{
+		ID: "b", UserID: "alice", Command: "bash", Status: terminal.StatusRunning, CreatedAt: start.Add(time.Second),
+	}))
+	require.NoError(t, service.SaveSession(ctx, terminal.SessionRecord{
+		ID: "c", UserID: "bob", Command: "bash", Status: terminal.StatusRunning, CreatedAt: start,
+	}))
+	// Ending a session replaces its record
+	require.NoError(t, service.SaveSession(ctx, terminal.SessionRecord{
+		ID: "a", UserID: "alice", Command: "make", Status: terminal.StatusStopped, ExitCode: &code, CreatedAt: start,
+	}))
+
+	records, err := service.SessionHistory(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "b", records[0].ID, "newest first")
+	assert.Equal(t, terminal.StatusStopped, records[1].Status)
+	assert.Equal(t, 3, *records[1].ExitCode)
+
+	running, err := service.RunningSessions(ctx)
+	require.NoError(t, err)
+	assert.Len(t, running, 2)
+}
+
+func TestHistoryPrunesEnded(t *testing.T) {
+	service := New(nil, zap.NewNop())
+	ctx := context.Background()
+	start := time.Now()
+
+	require.NoError(t, service.SaveSession(ctx, terminal.SessionRecord{
+		ID: "running", UserID: "alice", Status: terminal.StatusRunning, CreatedAt: start,
+	}))
+	for i := 0; i < historyLimit+10; i++ {
+		require.NoError(t, service.SaveSession(ctx, terminal.SessionRecord{
+			ID:        fmt.Sprintf("ended-%d", i),
+			UserID:    "alice",
+			Status:    terminal.StatusStopped,
+			CreatedAt: start.Add(time.Duration(i+1) * time.Second),
+		}))
+	}
+
+	records, err := service.SessionHistory(ctx, "alice")
+	require.NoError(t, err)
+	assert.Len(t, records, historyLimit)
+	assert.Equal(t, fmt.Sprintf("ended-%d", historyLimit+9), records[0].ID)
+
+	// The oldest ended sessions go first; running ones are kept
+	running, err := service.RunningSessions(ctx)
+	require.NoError(t, err)
+	assert.Len(t, running, 1)
+}
@@ -0,0 +1,214 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// maxTTL caps assertion lifetimes; they are meant to be fetched per call
+const maxTTL = time.Hour
+
+var (
+	ErrDisabled = errors.New("identity assertions are not configured")
+	ErrAudience = errors.New("audience is not allowed")
+)
+
+// JWK is a public key in JSON Web Key form, as served in the JWKS
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// Signer signs assertions and publishes the keys that verify them. The
+// built-in signer holds an Ed25519 key; embedders can plug in one backed
+// by a KMS or HSM. PublicKeys may list retired keys still in use.
+type Signer interface {
+	Sign(claims jwt.MapClaims) (string, error)
+	PublicKeys() []JWK
+}
+
+// Users looks up the claims about a user
+type Users interface {
+	GetUserByID(userID string) (*auth.User, error)
+}
+
+// Assertion is a signed statement of who drives a session, for one
+// downstream audience
+type Assertion struct {
+	Token     string    `json:"token"`
+	Audience  string    `json:"audience"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Service issues short-lived JWTs saying which user is driving which
+// session, so tools run in a terminal can prove it to internal services
+// without handing them the user's webtunnel token
+type Service struct {
+	issuer    string
+	ttl       time.Duration
+	audiences map[string]bool
+	signer    Signer
+	users     Users
+	logger    *zap.Logger
+}
+
+// New creates the service. Without audiences it is disabled; without a
+// signing key an ephemeral one is generated, so assertions stop verifying
+// after a restart.
+func New(cfg config.IdentityConfig, users Users, logger *zap.Logger) (*Service, error) {
+	ttl := 5 * time.Minute
+	if cfg.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(cfg.TTL); err != nil || ttl <= 0 || ttl > maxTTL {
+			return nil, fmt.Errorf("invalid identity ttl %q: use up to %s", cfg.TTL, maxTTL)
+		}
+	}
+	audiences := make(map[string]bool)
+	for _, audience := range cfg.Audiences {
+		audiences[audience] = true
+	}
+
+	s := &Service{
+		issuer:    cfg.Issuer,
+		ttl:       ttl,
+		audiences: audiences,
+		users:     users,
+		logger:    logger,
+	}
+	if len(audiences) == 0 {
+		return s, nil
+	}
+
+	var key ed25519.PrivateKey
+	if cfg.SigningKey == "" {
+		_, generated, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate identity signing key: %w", err)
+		}
+		key = generated
+		logger.Warn("No identity signing key configured, generated an ephemeral one")
+	} else {
+		seed, err := base64.StdEncoding.DecodeString(cfg.SigningKey)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("identity signing_key must be a 32 byte base64 encoded Ed25519 seed")
+		}
+		key = ed25519.NewKeyFromSeed(seed)
+	}
+	s.signer = NewEd25519Signer(key)
+	return s, nil
+}
+
+// SetSigner replaces the built-in signer
+func (s *Service) SetSigner(signer Signer) {
+	s.signer = signer
+}
+
+// Enabled reports whether any audience may be asked for
+func (s *Service) Enabled() bool {
+	return len(s.audiences) > 0 && s.signer != nil
+}
+
+// Issue signs an assertion that userID drives the session, owned by
+// ownerID, for audience. Callers check the user may write to the session.
+func (s *Service) Issue(userID, sessionID, ownerID, audience string) (*Assertion, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if !s.audiences[audience] {
+		return nil, fmt.Errorf("%w: %q", ErrAudience, audience)
+	}
+	user, err := s.users.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	expires := now.Add(s.ttl)
+	claims := jwt.MapClaims{
+		"iss":           s.issuer,
+		"sub":           user.ID,
+		"aud":           audience,
+		"iat":           now.Unix(),
+		"nbf":           now.Unix(),
+		"exp":           expires.Unix(),
+		"jti":           generateID(),
+		"email":         user.Email,
+		"role":          user.Role,
+		"session_id":    sessionID,
+		"session_owner": ownerID,
+	}
+	if user.OrgID != "" {
+		claims["org_id"] = user.OrgID
+	}
+	token, err := s.signer.Sign(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign identity assertion: %w", err)
+	}
+
+	s.logger.Info("Identity assertion issued",
+		zap.String("user_id", userID),
+		zap.String("session_id", sessionID),
+		zap.String("audience", audience))
+	return &Assertion{Token: token, Audience: audience, ExpiresAt: expires}, nil
+}
+
+// JWKS returns the key set downstream services verify assertions with
+func (s *Service) JWKS() map[string][]JWK {
+	keys := []JWK{}
+	if s.signer != nil {
+		keys = append(keys, s.signer.PublicKeys()...)
+	}
+	return map[string][]JWK{"keys": keys}
+}
+
+// ed25519Signer signs with EdDSA. Its key ID is derived from the public
+// key, so verifiers can tell keys apart across rotations.
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+	kid string
+}
+
+func NewEd25519Signer(key ed25519.PrivateKey) Signer {
+	sum := sha256.Sum256(key.Public().(ed25519.PublicKey))
+	return &ed25519Signer{key: key, kid: base64.RawURLEncoding.EncodeToString(sum[:8])}
+}
+
+func (e *ed25519Signer) Sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = e.kid
+	return token.SignedString(e.key)
+}
+
+func (e *ed25519Signer) PublicKeys() []JWK {
+	return []JWK{{
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(e.key.Public().(ed25519.PublicKey)),
+		Kid: e.kid,
+		Alg: "EdDSA",
+		Use: "sig",
+	}}
+}
+
+func generateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,100 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+type fakeUsers map[string]*auth.User
+
+func (f fakeUsers) GetUserByID(userID string) (*auth.User, error) {
+	return f[userID], nil
+}
+
+// verify checks a token against the service's published keys, as a
+// downstream service would
+func verify(t *testing.T, service *Service, token, audience string) jwt.MapClaims {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		for _, key := range service.JWKS()["keys"] {
+			if key.Kid == token.Header["kid"] {
+				x, err := base64.RawURLEncoding.DecodeString(key.X)
+				return ed25519.PublicKey(x), err
+			}
+		}
+		return nil, assert.AnError
+	}, jwt.WithValidMethods([]string{"EdDSA"}), jwt.WithAudience(audience), jwt.WithIssuer("webtunnel"))
+	require.NoError(t, err)
+	return claims
+}
+
+func TestIssue(t *testing.T) {
+	users := fakeUsers{"alice": {ID: "alice", Email: "alice@example.com", Role: "user"}}
+	service, err := New(config.IdentityConfig{
+		Issuer:    "webtunnel",
+		TTL:       "2m",
+		Audiences: []string{"deploy-api"},
+	}, users, zap.NewNop())
+	require.NoError(t, err)
+	require.True(t, service.Enabled())
+
+	assertion, err := service.Issue("alice", "sess_1", "bob", "deploy-api")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), assertion.ExpiresAt, 2*time.Second)
+
+	claims := verify(t, service, assertion.Token, "deploy-api")
+	assert.Equal(t, "alice", claims["sub"])
+	assert.Equal(t, "alice@example.com", claims["email"])
+	assert.Equal(t, "sess_1", claims["session_id"])
+	assert.Equal(t, "bob", claims["session_owner"])
+	assert.NotEmpty(t, claims["jti"])
+
+	_, err = service.Issue("alice", "sess_1", "alice", "billing-api")
+	assert.ErrorIs(t, err, ErrAudience)
+}
+
+func TestSigningKey(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	cfg := config.IdentityConfig{
+		Issuer:     "webtunnel",
+		Audiences:  []string{"deploy-api"},
+		SigningKey: base64.StdEncoding.EncodeToString(seed),
+	}
+	users := fakeUsers{"alice": {ID: "alice"}}
+
+	// The same key signs across restarts
+	first, err := New(cfg, users, zap.NewNop())
+	require.NoError(t, err)
+	second, err := New(cfg, users, zap.NewNop())
+	require.NoError(t, err)
+	assertion, err := first.Issue("alice", "sess_1", "alice", "deploy-api")
+	require.NoError(t, err)
+	verify(t, second, assertion.Token, "deploy-api")
+
+	cfg.SigningKey = "c2hvcnQ="
+	_, err = New(cfg, users, zap.NewNop())
+	assert.Error(t, err)
+	cfg.SigningKey, cfg.TTL = "", "2h"
+	_, err = New(cfg, users, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestDisabled(t *testing.T) {
+	service, err := New(config.IdentityConfig{}, fakeUsers{}, zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, service.Enabled())
+	assert.Empty(t, service.JWKS()["keys"])
+
+	_, err = service.Issue("alice", "sess_1", "alice", "deploy-api")
+	assert.ErrorIs(t, err, ErrDisabled)
+}
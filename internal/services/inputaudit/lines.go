@@ -0,0 +1,115 @@
+package inputaudit
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// lineBuffer reconstructs the command lines a user typed from their raw
+// input, applying the line editing a shell's readline would: backspace,
+// ^U, ^W and ^C. What the shell decides itself, such as history recall
+// with the arrow keys or tab completion, cannot be replayed, so lines that
+// used it are marked approximate.
+type lineBuffer struct {
+	line        []rune
+	approximate bool
+
+	// pending holds an incomplete UTF-8 sequence or escape sequence split
+	// across inputs
+	pending []byte
+}
+
+// line is one reconstructed command line
+type line struct {
+	text        string
+	approximate bool
+}
+
+// feed adds input and returns the lines it finished
+func (b *lineBuffer) feed(input []byte) []line {
+	data := append(b.pending, input...)
+	b.pending = nil
+
+	var done []line
+	for len(data) > 0 {
+		c := data[0]
+		switch {
+		case c == 0x1b:
+			n, complete := escapeLength(data)
+			if !complete {
+				b.pending = append([]byte{}, data...)
+				return done
+			}
+			if !isPasteMarker(data[:n]) {
+				b.approximate = true
+			}
+			data = data[n:]
+			continue
+		case c == '\r' || c == '\n':
+			if len(b.line) > 0 || b.approximate {
+				done = append(done, line{text: string(b.line), approximate: b.approximate})
+			}
+			b.reset()
+		case c == 0x7f || c == 0x08:
+			if len(b.line) > 0 {
+				b.line = b.line[:len(b.line)-1]
+			}
+		case c == 0x15: // ^U
+			b.line = b.line[:0]
+		case c == 0x17: // ^W
+			b.line = []rune(deleteWord(string(b.line)))
+		case c == 0x03: // ^C
+			b.reset()
+		case c == '\t':
+			b.approximate = true
+		case c < 0x20:
+		default:
+			r, size := utf8.DecodeRune(data)
+			if r == utf8.RuneError && size == 1 && !utf8.FullRune(data) {
+				b.pending = append([]byte{}, data...)
+				return done
+			}
+			b.line = append(b.line, r)
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return done
+}
+
+func (b *lineBuffer) reset() {
+	b.line = b.line[:0]
+	b.approximate = false
+}
+
+// escapeLength returns the length of the escape sequence data starts with,
+// or false if it is cut off
+func escapeLength(data []byte) (int, bool) {
+	if len(data) < 2 {
+		return 0, false
+	}
+	if data[1] != '[' && data[1] != 'O' {
+		return 2, true
+	}
+	for i := 2; i < len(data); i++ {
+		if data[i] >= 0x40 && data[i] <= 0x7e {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// isPasteMarker reports whether seq starts or ends a bracketed paste,
+// which leaves the pasted text as typed
+func isPasteMarker(seq []byte) bool {
+	return string(seq) == "\x1b[200~" || string(seq) == "\x1b[201~"
+}
+
+func deleteWord(s string) string {
+	s = strings.TrimRight(s, " ")
+	if i := strings.LastIndex(s, " "); i >= 0 {
+		return s[:i+1]
+	}
+	return ""
+}
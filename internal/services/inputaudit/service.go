@@ -0,0 +1,304 @@
+// Package inputaudit records everything typed into sessions, and the
+// command lines reconstructed from it, for compliance.
+package inputaudit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// Kinds of record
+const (
+	KindInput   = "input"   // raw input, as written to the PTY
+	KindCommand = "command" // a command line reconstructed from the input
+)
+
+const (
+	// flushInterval is how often pending records are written out
+	flushInterval = time.Second
+	// maxPending caps records waiting for the database, so an outage does
+	// not grow memory without bound
+	maxPending = 100000
+	// memoryLimit caps the records kept without a database
+	memoryLimit = 100000
+
+	DefaultQueryLimit = 1000
+	MaxQueryLimit     = 10000
+)
+
+// Record is one input or command line typed into a session
+type Record struct {
+	ID          int64     `json:"id,omitempty"`
+	SessionID   string    `json:"session_id"`
+	UserID      string    `json:"user_id"`
+	Kind        string    `json:"kind"`
+	Data        string    `json:"data"`
+	Approximate bool      `json:"approximate,omitempty"` // the line used history or completion
+	At          time.Time `json:"at"`
+}
+
+// Filter narrows a session's records
+type Filter struct {
+	Kind  string // empty for both
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// Service keeps input records in the database, written in batches off
+// the input path, or in memory without one
+type Service struct {
+	db        *database.DB
+	enabled   bool
+	retention time.Duration
+	logger    *zap.Logger
+	now       func() time.Time
+
+	lines   map[string]*lineBuffer // by session ID:user ID
+	pending []Record
+	memory  []Record
+	nextID  int64
+	dropped int64
+	mu      sync.Mutex
+}
+
+func New(cfg config.InputAuditConfig, db *database.DB, logger *zap.Logger) (*Service, error) {
+	retention := 90 * 24 * time.Hour
+	if cfg.Retention != "" {
+		d, err := time.ParseDuration(cfg.Retention)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid input_audit retention %q", cfg.Retention)
+		}
+		retention = d
+	}
+	return &Service{
+		db:        db,
+		enabled:   cfg.Enabled,
+		retention: retention,
+		logger:    logger,
+		now:       time.Now,
+		lines:     make(map[string]*lineBuffer),
+	}, nil
+}
+
+// Enabled reports whether input is audited
+func (s *Service) Enabled() bool {
+	return s.enabled
+}
+
+// RecordInput keeps input written to a session's PTY, and any command
+// lines it finished. It never waits for the database.
+func (s *Service) RecordInput(sessionID, userID string, input []byte, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionID + ":" + userID
+	buffer, ok := s.lines[key]
+	if !ok {
+		buffer = &lineBuffer{}
+		s.lines[key] = buffer
+	}
+	s.add(Record{SessionID: sessionID, UserID: userID, Kind: KindInput, Data: text(input), At: at})
+	for _, line := range buffer.feed(input) {
+		s.add(Record{SessionID: sessionID, UserID: userID, Kind: KindCommand, Data: line.text, Approximate: line.approximate, At: at})
+	}
+}
+
+// text makes input storable as Postgres text, which takes neither NUL
+// bytes nor invalid UTF-8
+func text(input []byte) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(string(input), "\uFFFD"), "\x00", "\uFFFD")
+}
+
+// add queues a record for the database, or keeps it in memory without one
+func (s *Service) add(record Record) {
+	if s.db == nil {
+		s.nextID++
+		record.ID = s.nextID
+		s.memory = append(s.memory, record)
+		if len(s.memory) > memoryLimit {
+			s.memory = s.memory[len(s.memory)-memoryLimit:]
+		}
+		return
+	}
+	if len(s.pending) >= maxPending {
+		s.dropped++
+		return
+	}
+	s.pending = append(s.pending, record)
+}
+
+// HandleSessionEvent forgets the half-typed lines of a session that ended
+func (s *Service) HandleSessionEvent(event terminal.Event) {
+	if event.Type != terminal.EventSessionExited {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.lines {
+		if strings.HasPrefix(key, event.SessionID+":") {
+			delete(s.lines, key)
+		}
+	}
+}
+
+// Query returns a session's records, oldest first
+func (s *Service) Query(ctx context.Context, sessionID string, filter Filter) ([]Record, error) {
+	limit := filter.Limit
+	switch {
+	case limit == 0:
+		limit = DefaultQueryLimit
+	case limit < 0 || limit > MaxQueryLimit:
+		return nil, fmt.Errorf("limit must be 1 to %d", MaxQueryLimit)
+	}
+	if filter.Kind != "" && filter.Kind != KindInput && filter.Kind != KindCommand {
+		return nil, fmt.Errorf("kind must be input or command")
+	}
+
+	result := []Record{}
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, record := range s.memory {
+			if record.SessionID != sessionID ||
+				(filter.Kind != "" && record.Kind != filter.Kind) ||
+				(!filter.Since.IsZero() && record.At.Before(filter.Since)) ||
+				(!filter.Until.IsZero() && !record.At.Before(filter.Until)) {
+				continue
+			}
+			result = append(result, record)
+			if len(result) == limit {
+				break
+			}
+		}
+		return result, nil
+	}
+
+	// Records still waiting to be written would be missing
+	s.flush(ctx)
+
+	query := `
+		SELECT id, session_id, user_id, kind, data, approximate, created_at
+		FROM input_audit
+		WHERE session_id = $1`
+	args := []interface{}{sessionID}
+	if filter.Kind != "" {
+		args = append(args, filter.Kind)
+		query += fmt.Sprintf(" AND kind = $%d", len(args))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since)
+		query += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until)
+		query += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at, id LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query input audit: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record Record
+		if err := rows.Scan(&record.ID, &record.SessionID, &record.UserID, &record.Kind, &record.Data, &record.Approximate, &record.At); err != nil {
+			return nil, fmt.Errorf("failed to scan input audit record: %w", err)
+		}
+		result = append(result, record)
+	}
+	return result, rows.Err()
+}
+
+// Run writes pending records every second and prunes records past the
+// retention every hour, until ctx is done
+func (s *Service) Run(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// What was typed last still gets written
+			done, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			s.flush(done)
+			cancel()
+			return
+		case <-flush.C:
+			s.flush(ctx)
+		case <-prune.C:
+			s.prune(ctx)
+		}
+	}
+}
+
+// flush writes the pending records in one transaction. They are kept for
+// the next flush if the write fails.
+func (s *Service) flush(ctx context.Context) {
+	s.mu.Lock()
+	records, dropped := s.pending, s.dropped
+	s.pending, s.dropped = nil, 0
+	s.mu.Unlock()
+
+	if dropped > 0 {
+		s.logger.Error("Dropped input audit records while the database was behind", zap.Int64("dropped", dropped))
+	}
+	if len(records) == 0 {
+		return
+	}
+	if err := s.write(ctx, records); err != nil {
+		s.logger.Error("Failed to write input audit records", zap.Int("records", len(records)), zap.Error(err))
+		s.mu.Lock()
+		s.pending = append(records, s.pending...)
+		s.mu.Unlock()
+	}
+}
+
+func (s *Service) write(ctx context.Context, records []Record) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO input_audit (session_id, user_id, kind, data, approximate, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, record := range records {
+		if _, err := stmt.ExecContext(ctx, record.SessionID, record.UserID, record.Kind, record.Data, record.Approximate, record.At); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Service) prune(ctx context.Context) {
+	cutoff := s.now().Add(-s.retention)
+	result, err := s.db.ExecContext(ctx, `DELETE FROM input_audit WHERE created_at < $1`, cutoff)
+	if err != nil {
+		s.logger.Error("Failed to prune input audit", zap.Error(err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		s.logger.Info("Pruned input audit records", zap.Int64("records", n))
+	}
+}
@@ -0,0 +1,95 @@
+package inputaudit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		want  []line
+	}{
+		{"typed a key at a time", []string{"l", "s", " ", "-", "l", "\r"}, []line{{text: "ls -l"}}},
+		{"backspace", []string{"lss\x7f -a\r"}, []line{{text: "ls -a"}}},
+		{"kill line and word", []string{"rm -rf /\x15echo hi there\x17you\r"}, []line{{text: "echo hi you"}}},
+		{"interrupted", []string{"make deploy\x03make test\n"}, []line{{text: "make test"}}},
+		{"several at once", []string{"cd /srv\rls\r\r"}, []line{{text: "cd /srv"}, {text: "ls"}}},
+		{"history recall", []string{"\x1b[A\r"}, []line{{text: "", approximate: true}}},
+		{"tab completion", []string{"cat READ\t\r"}, []line{{text: "cat READ", approximate: true}}},
+		{"escape split across inputs", []string{"git st\x1b[", "D\x1b", "[Catus\r"}, []line{{text: "git status", approximate: true}}},
+		{"bracketed paste", []string{"\x1b[200~kubectl get pods\x1b[201~\r"}, []line{{text: "kubectl get pods"}}},
+		{"utf-8 split across inputs", []string{"echo h\xc3", "\xa9llo\r"}, []line{{text: "echo héllo"}}},
+		{"unfinished", []string{"shutdown -h now"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b lineBuffer
+			var got []line
+			for _, input := range tt.input {
+				got = append(got, b.feed([]byte(input))...)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRecordInput(t *testing.T) {
+	service, err := New(config.InputAuditConfig{Enabled: true}, nil, zap.NewNop())
+	require.NoError(t, err)
+	require.True(t, service.Enabled())
+	ctx := context.Background()
+	start := time.Now()
+
+	service.RecordInput("s1", "alice", []byte("whoami"), start)
+	service.RecordInput("s1", "bob", []byte("uptime\r"), start.Add(time.Second))
+	service.RecordInput("s1", "alice", []byte("\r"), start.Add(2*time.Second))
+	service.RecordInput("s2", "alice", []byte("date\r\x00"), start.Add(3*time.Second))
+
+	commands, err := service.Query(ctx, "s1", Filter{Kind: KindCommand})
+	require.NoError(t, err)
+	require.Len(t, commands, 2)
+	assert.Equal(t, "bob", commands[0].UserID)
+	assert.Equal(t, "uptime", commands[0].Data)
+	assert.Equal(t, "alice", commands[1].UserID, "each user's line is reconstructed apart")
+	assert.Equal(t, "whoami", commands[1].Data)
+
+	all, err := service.Query(ctx, "s1", Filter{})
+	require.NoError(t, err)
+	assert.Len(t, all, 5)
+	inputs, err := service.Query(ctx, "s1", Filter{Kind: KindInput, Since: start.Add(time.Second), Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, inputs, 1)
+	assert.Equal(t, "uptime\r", inputs[0].Data)
+
+	inputs, err = service.Query(ctx, "s2", Filter{Kind: KindInput})
+	require.NoError(t, err)
+	assert.Equal(t, "date\r�", inputs[0].Data, "storable as Postgres text")
+
+	_, err = service.Query(ctx, "s1", Filter{Kind: "keys"})
+	assert.Error(t, err)
+	_, err = service.Query(ctx, "s1", Filter{Limit: MaxQueryLimit + 1})
+	assert.Error(t, err)
+
+	service.RecordInput("s1", "alice", []byte("half"), start)
+	service.HandleSessionEvent(terminal.Event{Type: terminal.EventSessionExited, SessionID: "s1"})
+	assert.NotContains(t, service.lines, "s1:alice")
+	assert.Contains(t, service.lines, "s2:alice")
+}
+
+func TestConfig(t *testing.T) {
+	_, err := New(config.InputAuditConfig{Enabled: true, Retention: "a while"}, nil, zap.NewNop())
+	assert.Error(t, err)
+
+	service, err := New(config.InputAuditConfig{}, nil, zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, service.Enabled())
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package janitor
+
+import (
+	"io/fs"
+	"time"
+)
+
+// accessTime falls back to the modification time where atime is not exposed
+func accessTime(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,433 @@
+package janitor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+const (
+	ActionDelete  = "delete"
+	ActionArchive = "archive"
+)
+
+// SessionLookup tells the janitor which session directories are still in
+// use, and ends the sessions whose directories outgrow the quota
+type SessionLookup interface {
+	SessionExists(sessionID string) bool
+	EndSession(sessionID string, reason terminal.ExitReason) error
+}
+
+// Service removes session directories left behind by sessions that no
+// longer exist, e.g. after a crash, once nothing in them has been read or
+// written for the retention period, or with on_end as soon as their
+// sessions end. With quota_mb it also ends live sessions whose directories
+// grow past the quota.
+type Service struct {
+	root          string // WorkingDirectory/sessions
+	interval      time.Duration
+	retention     time.Duration
+	action        string
+	archiveDir    string
+	quota         int64 // bytes; 0 is unlimited
+	quotaInterval time.Duration
+	sessions      SessionLookup
+	logger        *zap.Logger
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Stats accumulates over the life of the process
+type Stats struct {
+	Sweeps         int64     `json:"sweeps"`
+	Deleted        int64     `json:"deleted"`
+	Archived       int64     `json:"archived"`
+	Failed         int64     `json:"failed"`
+	BytesReclaimed int64     `json:"bytes_reclaimed"`
+	OverQuota      int64     `json:"over_quota"` // sessions ended for outgrowing the quota
+	LastSweep      time.Time `json:"last_sweep"`
+}
+
+// Report describes one sweep
+type Report struct {
+	Scanned        int      `json:"scanned"`
+	Removed        []string `json:"removed"`
+	BytesReclaimed int64    `json:"bytes_reclaimed"`
+}
+
+func New(cfg config.JanitorConfig, workingDir string, sessions SessionLookup, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		root:       filepath.Join(workingDir, "sessions"),
+		action:     cfg.Action,
+		archiveDir: cfg.ArchiveDir,
+		quota:      int64(cfg.QuotaMB) << 20,
+		sessions:   sessions,
+		logger:     logger,
+	}
+
+	var err error
+	if cfg.Interval != "" {
+		if s.interval, err = time.ParseDuration(cfg.Interval); err != nil {
+			return nil, fmt.Errorf("invalid janitor interval: %w", err)
+		}
+	}
+	if s.retention, err = time.ParseDuration(cfg.Retention); err != nil {
+		return nil, fmt.Errorf("invalid janitor retention: %w", err)
+	}
+	if s.quota > 0 {
+		if s.quotaInterval, err = time.ParseDuration(cfg.QuotaInterval); err != nil || s.quotaInterval <= 0 {
+			return nil, fmt.Errorf("invalid janitor quota_interval: %q", cfg.QuotaInterval)
+		}
+	}
+
+	switch s.action {
+	case "":
+		s.action = ActionDelete
+	case ActionDelete:
+	case ActionArchive:
+		if s.archiveDir == "" {
+			return nil, fmt.Errorf("janitor archive_dir is required for the archive action")
+		}
+	default:
+		return nil, fmt.Errorf("invalid janitor action: %s", s.action)
+	}
+
+	return s, nil
+}
+
+// Run sweeps on the configured interval, and measures live session
+// directories on the quota interval, until ctx is cancelled. A zero
+// interval disables sweeps, and a zero quota the measuring.
+func (s *Service) Run(ctx context.Context) {
+	var sweeps, checks <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		sweeps = ticker.C
+	}
+	if s.quota > 0 {
+		ticker := time.NewTicker(s.quotaInterval)
+		defer ticker.Stop()
+		checks = ticker.C
+	}
+	if sweeps == nil && checks == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sweeps:
+			if _, err := s.Sweep(time.Now()); err != nil {
+				s.logger.Error("Session directory sweep failed", zap.Error(err))
+			}
+		case <-checks:
+			if _, err := s.EnforceQuota(); err != nil {
+				s.logger.Error("Session directory quota check failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *Service) Sweep(now time.Time) (*Report, error) {
+	report := &Report{Removed: []string{}}
+
+	entries, err := os.ReadDir(s.root)
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session directories: %w", err)
+	}
+
+	var failed int64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		report.Scanned++
+
+		sessionID := entry.Name()
+		if s.sessions.SessionExists(sessionID) {
+			continue
+		}
+
+		dir := filepath.Join(s.root, sessionID)
+		lastUsed, size, err := usage(dir)
+		if err != nil {
+			s.logger.Warn("Failed to inspect session directory", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		if now.Sub(lastUsed) < s.retention {
+			continue
+		}
+
+		if err := s.reclaim(dir, sessionID, now); err != nil {
+			failed++
+			s.logger.Error("Failed to reclaim session directory", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+
+		report.Removed = append(report.Removed, sessionID)
+		report.BytesReclaimed += size
+		s.logger.Info("Reclaimed orphaned session directory",
+			zap.String("session_id", sessionID),
+			zap.String("action", s.action),
+			zap.Int64("bytes", size),
+			zap.Time("last_used", lastUsed))
+	}
+
+	s.mu.Lock()
+	s.stats.Sweeps++
+	s.stats.LastSweep = now
+	s.mu.Unlock()
+	s.count(int64(len(report.Removed)), failed, report.BytesReclaimed)
+
+	return report, nil
+}
+
+// count adds reclaimed and failed directories to the stats
+func (s *Service) count(reclaimed, failed, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Failed += failed
+	s.stats.BytesReclaimed += bytes
+	if s.action == ActionArchive {
+		s.stats.Archived += reclaimed
+	} else {
+		s.stats.Deleted += reclaimed
+	}
+}
+
+// Reclaim applies the action to the directory of a session that has just
+// ended, without waiting for the retention period. The terminal service
+// calls it under on_end. Directories outside the working directory's
+// sessions are not the janitor's and are left alone.
+func (s *Service) Reclaim(sessionID, dir string) {
+	if filepath.Clean(dir) != filepath.Join(s.root, sessionID) {
+		return
+	}
+	_, size, err := usage(dir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		s.logger.Warn("Failed to inspect session directory", zap.String("dir", dir), zap.Error(err))
+	}
+
+	if err := s.reclaim(dir, sessionID, time.Now()); err != nil {
+		s.count(0, 1, 0)
+		s.logger.Error("Failed to reclaim session directory", zap.String("dir", dir), zap.Error(err))
+		return
+	}
+	s.count(1, 0, size)
+	s.logger.Info("Reclaimed ended session directory",
+		zap.String("session_id", sessionID),
+		zap.String("action", s.action),
+		zap.Int64("bytes", size))
+}
+
+// EnforceQuota measures the directory of every live session and ends the
+// sessions whose directories hold more than the quota, returning their IDs
+func (s *Service) EnforceQuota() ([]string, error) {
+	ended := []string{}
+	if s.quota <= 0 {
+		return ended, nil
+	}
+
+	entries, err := os.ReadDir(s.root)
+	if os.IsNotExist(err) {
+		return ended, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session directories: %w", err)
+	}
+
+	for _, entry := range entries {
+		sessionID := entry.Name()
+		if !entry.IsDir() || !s.sessions.SessionExists(sessionID) {
+			continue
+		}
+
+		dir := filepath.Join(s.root, sessionID)
+		_, size, err := usage(dir)
+		if err != nil {
+			s.logger.Warn("Failed to inspect session directory", zap.String("dir", dir), zap.Error(err))
+			continue
+		}
+		if size <= s.quota {
+			continue
+		}
+
+		// Pooled processes have directories but no session to end yet
+		if err := s.sessions.EndSession(sessionID, terminal.ExitDiskQuota); err != nil {
+			continue
+		}
+		ended = append(ended, sessionID)
+		s.logger.Warn("Ended session over its directory quota",
+			zap.String("session_id", sessionID),
+			zap.Int64("bytes", size),
+			zap.Int64("quota", s.quota))
+	}
+
+	s.mu.Lock()
+	s.stats.OverQuota += int64(len(ended))
+	s.mu.Unlock()
+
+	return ended, nil
+}
+
+// usage returns the most recent access or modification anywhere in the
+// tree, and its total size. Directory atimes alone miss reads of files.
+func usage(dir string) (time.Time, int64, error) {
+	var lastUsed time.Time
+	var size int64
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		for _, t := range []time.Time{accessTime(info), info.ModTime()} {
+			if t.After(lastUsed) {
+				lastUsed = t
+			}
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return lastUsed, size, err
+}
+
+func (s *Service) reclaim(dir, sessionID string, now time.Time) error {
+	if s.action == ActionArchive {
+		name := fmt.Sprintf("%s-%s.tar.gz", sessionID, now.UTC().Format("20060102T150405Z"))
+		if err := archive(dir, filepath.Join(s.archiveDir, name)); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// archive writes dir as a gzipped tarball, replacing dst only on success
+func archive(dir, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".archive-*")
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+
+	base := filepath.Base(dir)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		// Symlinks are stored as links, never followed
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(base, rel))
+		if d.IsDir() && !strings.HasSuffix(header.Name, "/") {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (s *Service) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// WriteMetrics renders the janitor counters in the Prometheus text format
+func (s *Service) WriteMetrics(w io.Writer) error {
+	stats := s.Stats()
+	_, err := fmt.Fprintf(w, `# HELP webtunnel_janitor_sweeps_total Orphaned session directory sweeps.
+# TYPE webtunnel_janitor_sweeps_total counter
+webtunnel_janitor_sweeps_total %d
+# HELP webtunnel_janitor_directories_total Session directories reclaimed, by action.
+# TYPE webtunnel_janitor_directories_total counter
+webtunnel_janitor_directories_total{action="delete"} %d
+webtunnel_janitor_directories_total{action="archive"} %d
+# HELP webtunnel_janitor_failures_total Session directories that could not be reclaimed.
+# TYPE webtunnel_janitor_failures_total counter
+webtunnel_janitor_failures_total %d
+# HELP webtunnel_janitor_reclaimed_bytes_total Bytes freed from the session working directory.
+# TYPE webtunnel_janitor_reclaimed_bytes_total counter
+webtunnel_janitor_reclaimed_bytes_total %d
+# HELP webtunnel_janitor_over_quota_total Sessions ended because their directory outgrew the quota.
+# TYPE webtunnel_janitor_over_quota_total counter
+webtunnel_janitor_over_quota_total %d
+`, stats.Sweeps, stats.Deleted, stats.Archived, stats.Failed, stats.BytesReclaimed, stats.OverQuota)
+	return err
+}
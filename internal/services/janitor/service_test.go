@@ -0,0 +1,174 @@
+package janitor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+type liveSessions map[string]bool
+
+func (l liveSessions) SessionExists(sessionID string) bool {
+	return l[sessionID]
+}
+
+func (l liveSessions) EndSession(sessionID string, reason terminal.ExitReason) error {
+	if !l[sessionID] {
+		return errors.New("session not found")
+	}
+	delete(l, sessionID)
+	return nil
+}
+
+// makeSessionDir creates a session directory with one file, last used at
+// the given time
+func makeSessionDir(t *testing.T, root, sessionID string, lastUsed time.Time) {
+	dir := filepath.Join(root, "sessions", sessionID)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	file := filepath.Join(dir, "output.txt")
+	require.NoError(t, os.WriteFile(file, []byte("hello"), 0644))
+	require.NoError(t, os.Chtimes(file, lastUsed, lastUsed))
+	require.NoError(t, os.Chtimes(dir, lastUsed, lastUsed))
+}
+
+func TestSweepDelete(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	makeSessionDir(t, root, "sess_live", now.Add(-100*time.Hour))
+	makeSessionDir(t, root, "sess_orphan", now.Add(-100*time.Hour))
+	makeSessionDir(t, root, "sess_recent", now.Add(-time.Hour))
+
+	cfg := config.JanitorConfig{Retention: "72h", Action: ActionDelete}
+	service, err := New(cfg, root, liveSessions{"sess_live": true}, zap.NewNop())
+	require.NoError(t, err)
+
+	report, err := service.Sweep(now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Scanned)
+	assert.Equal(t, []string{"sess_orphan"}, report.Removed)
+	assert.Equal(t, int64(5), report.BytesReclaimed)
+
+	assert.NoDirExists(t, filepath.Join(root, "sessions", "sess_orphan"))
+	assert.DirExists(t, filepath.Join(root, "sessions", "sess_live"))
+	assert.DirExists(t, filepath.Join(root, "sessions", "sess_recent"))
+
+	var metrics bytes.Buffer
+	require.NoError(t, service.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), `webtunnel_janitor_directories_total{action="delete"} 1`)
+	assert.Contains(t, metrics.String(), "webtunnel_janitor_reclaimed_bytes_total 5")
+}
+
+func TestSweepArchive(t *testing.T) {
+	root := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	now := time.Now()
+	makeSessionDir(t, root, "sess_orphan", now.Add(-100*time.Hour))
+
+	cfg := config.JanitorConfig{Retention: "72h", Action: ActionArchive, ArchiveDir: archiveDir}
+	service, err := New(cfg, root, liveSessions{}, zap.NewNop())
+	require.NoError(t, err)
+
+	report, err := service.Sweep(now)
+	require.NoError(t, err)
+	require.Len(t, report.Removed, 1)
+
+	archives, err := filepath.Glob(filepath.Join(archiveDir, "sess_orphan-*.tar.gz"))
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+
+	f, err := os.Open(archives[0])
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	assert.Equal(t, []string{"sess_orphan/", "sess_orphan/output.txt"}, names)
+	assert.Equal(t, int64(1), service.Stats().Archived)
+}
+
+func TestNewValidation(t *testing.T) {
+	_, err := New(config.JanitorConfig{Retention: "72h", Action: ActionArchive}, "/tmp", liveSessions{}, zap.NewNop())
+	assert.Error(t, err, "archive without archive_dir")
+
+	_, err = New(config.JanitorConfig{Retention: "72h", Action: "shred"}, "/tmp", liveSessions{}, zap.NewNop())
+	assert.Error(t, err)
+
+	_, err = New(config.JanitorConfig{Retention: "72h", QuotaMB: 10}, "/tmp", liveSessions{}, zap.NewNop())
+	assert.Error(t, err, "quota without quota_interval")
+}
+
+func TestReclaim(t *testing.T) {
+	root := t.TempDir()
+	archiveDir := filepath.Join(t.TempDir(), "archive")
+	now := time.Now()
+	makeSessionDir(t, root, "sess_ended", now)
+
+	cfg := config.JanitorConfig{Retention: "72h", Action: ActionArchive, ArchiveDir: archiveDir, OnEnd: true}
+	service, err := New(cfg, root, liveSessions{}, zap.NewNop())
+	require.NoError(t, err)
+
+	// Recently used, but its session has ended
+	service.Reclaim("sess_ended", filepath.Join(root, "sessions", "sess_ended"))
+	assert.NoDirExists(t, filepath.Join(root, "sessions", "sess_ended"))
+	archives, err := filepath.Glob(filepath.Join(archiveDir, "sess_ended-*.tar.gz"))
+	require.NoError(t, err)
+	assert.Len(t, archives, 1)
+	assert.Equal(t, int64(1), service.Stats().Archived)
+	assert.Equal(t, int64(5), service.Stats().BytesReclaimed)
+
+	// Directories elsewhere are not the janitor's
+	elsewhere := t.TempDir()
+	service.Reclaim("sess_other", elsewhere)
+	assert.DirExists(t, elsewhere)
+}
+
+func TestEnforceQuota(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	makeSessionDir(t, root, "sess_small", now)
+	makeSessionDir(t, root, "sess_large", now)
+	makeSessionDir(t, root, "sess_orphan", now)
+	large := make([]byte, 2<<20)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sessions", "sess_large", "big.bin"), large, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sessions", "sess_orphan", "big.bin"), large, 0644))
+
+	sessions := liveSessions{"sess_small": true, "sess_large": true}
+	cfg := config.JanitorConfig{Retention: "72h", QuotaMB: 1, QuotaInterval: "1m"}
+	service, err := New(cfg, root, sessions, zap.NewNop())
+	require.NoError(t, err)
+
+	ended, err := service.EnforceQuota()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sess_large"}, ended)
+	assert.True(t, sessions["sess_small"])
+	assert.False(t, sessions["sess_large"])
+
+	// The directory itself is left for the cleanup policy
+	assert.DirExists(t, filepath.Join(root, "sessions", "sess_large"))
+
+	var metrics bytes.Buffer
+	require.NoError(t, service.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), "webtunnel_janitor_over_quota_total 1")
+}
@@ -0,0 +1,401 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+var ErrMaintenance = errors.New("maintenance in progress")
+
+// ClosedError is returned by Admit while a window is open
+type ClosedError struct {
+	Window  string
+	Message string
+	Until   time.Time
+}
+
+func (e *ClosedError) Error() string {
+	msg := fmt.Sprintf("New sessions are paused for maintenance until %s", e.Until.UTC().Format("15:04 MST"))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func (e *ClosedError) Unwrap() error {
+	return ErrMaintenance
+}
+
+// UserLookup lets admins bypass an open window
+type UserLookup interface {
+	GetUserByID(userID string) (*auth.User, error)
+}
+
+// Sessions is the part of the terminal service used to warn and end
+// running sessions
+type Sessions interface {
+	Notice(text string)
+	ListAllSessions() []*terminal.Session
+	KillSession(sessionID string) error
+}
+
+type Service struct {
+	windows  []*window
+	warnAt   []time.Duration // longest first
+	users    UserLookup
+	sessions Sessions
+	logger   *zap.Logger
+
+	// override suspends enforcement until the given time
+	override   time.Time
+	overrideBy string
+	// sent records warnings and kills already done per window occurrence
+	sent map[string]time.Time
+	mu   sync.Mutex
+}
+
+type window struct {
+	name         string
+	message      string
+	killSessions bool
+
+	// one-off windows
+	start, end time.Time
+
+	// recurring windows, as offsets from local midnight
+	recurring bool
+	from, to  time.Duration
+	weekdays  map[time.Weekday]bool
+	location  *time.Location
+}
+
+// Occurrence is one concrete opening of a window
+type Occurrence struct {
+	Name    string    `json:"name"`
+	Message string    `json:"message,omitempty"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+type Status struct {
+	Active        *Occurrence `json:"active,omitempty"`
+	Next          *Occurrence `json:"next,omitempty"`
+	OverrideUntil *time.Time  `json:"override_until,omitempty"`
+	OverrideBy    string      `json:"override_by,omitempty"`
+}
+
+func New(cfg config.MaintenanceConfig, users UserLookup, sessions Sessions, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		users:    users,
+		sessions: sessions,
+		logger:   logger,
+		sent:     make(map[string]time.Time),
+	}
+
+	for i, wc := range cfg.Windows {
+		w, err := parseWindow(wc)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %d: %w", i, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+
+	for _, d := range cfg.WarnAt {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance warn_at %q: %w", d, err)
+		}
+		s.warnAt = append(s.warnAt, duration)
+	}
+	sort.Slice(s.warnAt, func(i, j int) bool { return s.warnAt[i] > s.warnAt[j] })
+
+	return s, nil
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseWindow(wc config.MaintenanceWindow) (*window, error) {
+	w := &window{
+		name:         wc.Name,
+		message:      wc.Message,
+		killSessions: wc.KillSessions,
+		location:     time.UTC,
+	}
+	if w.name == "" {
+		w.name = "maintenance"
+	}
+
+	if wc.Timezone != "" {
+		loc, err := time.LoadLocation(wc.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+		w.location = loc
+	}
+
+	// One-off windows use full timestamps
+	if start, err := time.Parse(time.RFC3339, wc.Start); err == nil {
+		end, err := time.Parse(time.RFC3339, wc.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end: %w", err)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("end must be after start")
+		}
+		w.start, w.end = start, end
+		return w, nil
+	}
+
+	from, err := parseClock(wc.Start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start: %w", err)
+	}
+	to, err := parseClock(wc.End)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end: %w", err)
+	}
+	if from == to {
+		return nil, fmt.Errorf("start and end must differ")
+	}
+	w.recurring, w.from, w.to = true, from, to
+
+	w.weekdays = make(map[time.Weekday]bool)
+	for _, day := range wc.Weekdays {
+		weekday, ok := weekdayNames[strings.ToLower(day)[:min(3, len(day))]]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", day)
+		}
+		w.weekdays[weekday] = true
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// occurrence returns the opening that is active at now or the next one to
+// start, or false if the window never opens again.
+func (w *window) occurrence(now time.Time) (Occurrence, bool) {
+	occ := Occurrence{Name: w.name, Message: w.message}
+	if !w.recurring {
+		if !now.Before(w.end) {
+			return occ, false
+		}
+		occ.Start, occ.End = w.start, w.end
+		return occ, true
+	}
+
+	local := now.In(w.location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, w.location)
+
+	// Start from yesterday so a window that crossed midnight is found
+	for d := -1; d <= 7; d++ {
+		day := midnight.AddDate(0, 0, d)
+		if len(w.weekdays) > 0 && !w.weekdays[day.Weekday()] {
+			continue
+		}
+		start := day.Add(w.from)
+		end := day.Add(w.to)
+		if w.to < w.from {
+			end = day.AddDate(0, 0, 1).Add(w.to)
+		}
+		if now.Before(end) {
+			occ.Start, occ.End = start, end
+			return occ, true
+		}
+	}
+	return occ, false
+}
+
+func (s *Service) Status(now time.Time) Status {
+	var status Status
+	for _, w := range s.windows {
+		occ, ok := w.occurrence(now)
+		if !ok {
+			continue
+		}
+		if !now.Before(occ.Start) {
+			if status.Active == nil || occ.End.After(status.Active.End) {
+				status.Active = &occ
+			}
+		} else if status.Next == nil || occ.Start.Before(status.Next.Start) {
+			status.Next = &occ
+		}
+	}
+
+	s.mu.Lock()
+	if now.Before(s.override) {
+		until := s.override
+		status.OverrideUntil = &until
+		status.OverrideBy = s.overrideBy
+	}
+	s.mu.Unlock()
+
+	return status
+}
+
+// Admit refuses new sessions while a window is open. Admins are always let
+// in, as is everyone while an override is in place.
+func (s *Service) Admit(userID string) error {
+	now := time.Now()
+	status := s.Status(now)
+	if status.Active == nil || status.OverrideUntil != nil {
+		return nil
+	}
+
+	if s.users != nil {
+		if user, err := s.users.GetUserByID(userID); err == nil && user.Role == "admin" {
+			return nil
+		}
+	}
+
+	return &ClosedError{
+		Window:  status.Active.Name,
+		Message: status.Active.Message,
+		Until:   status.Active.End,
+	}
+}
+
+// Override suspends enforcement of all windows until the given time.
+func (s *Service) Override(until time.Time, by string) {
+	s.mu.Lock()
+	s.override = until
+	s.overrideBy = by
+	s.mu.Unlock()
+
+	s.logger.Info("Maintenance windows overridden",
+		zap.Time("until", until),
+		zap.String("by", by))
+}
+
+func (s *Service) ClearOverride() {
+	s.mu.Lock()
+	s.override = time.Time{}
+	s.overrideBy = ""
+	s.mu.Unlock()
+}
+
+// Run sends countdown warnings and ends sessions when windows open, until
+// ctx is cancelled.
+func (s *Service) Run(ctx context.Context) {
+	if len(s.windows) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		s.tick(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) tick(now time.Time) {
+	s.mu.Lock()
+	overridden := now.Before(s.override)
+	s.mu.Unlock()
+	if overridden {
+		return
+	}
+
+	for _, w := range s.windows {
+		occ, ok := w.occurrence(now)
+		if !ok {
+			continue
+		}
+		key := occ.Name + "@" + occ.Start.Format(time.RFC3339)
+
+		if now.Before(occ.Start) {
+			s.warn(key, occ, occ.Start.Sub(now))
+			continue
+		}
+
+		if w.killSessions && s.once(key+"/kill", occ.Start) {
+			s.sessions.Notice(fmt.Sprintf("Maintenance %q has started, closing sessions. %s", occ.Name, occ.Message))
+			for _, session := range s.sessions.ListAllSessions() {
+				if err := s.sessions.KillSession(session.ID); err != nil {
+					s.logger.Error("Failed to end session for maintenance",
+						zap.Error(err),
+						zap.String("session_id", session.ID))
+				}
+			}
+			s.logger.Info("Maintenance window opened, sessions ended", zap.String("window", occ.Name))
+		}
+	}
+
+	s.forget(now.Add(-24 * time.Hour))
+}
+
+// warn sends the most urgent countdown warning not yet sent for the
+// occurrence, so a late start does not replay every earlier threshold.
+func (s *Service) warn(key string, occ Occurrence, remaining time.Duration) {
+	due := -1
+	for i, threshold := range s.warnAt {
+		if remaining <= threshold {
+			due = i
+		}
+	}
+	if due < 0 {
+		return
+	}
+
+	sentAny := false
+	for i := 0; i <= due; i++ {
+		if s.once(fmt.Sprintf("%s/warn/%s", key, s.warnAt[i]), occ.Start) {
+			sentAny = true
+		}
+	}
+	if !sentAny {
+		return
+	}
+
+	text := fmt.Sprintf("Maintenance %q begins in %s (until %s).",
+		occ.Name, remaining.Round(time.Second), occ.End.UTC().Format("15:04 MST"))
+	if occ.Message != "" {
+		text += " " + occ.Message
+	}
+	s.sessions.Notice(text)
+}
+
+func (s *Service) once(key string, start time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, done := s.sent[key]; done {
+		return false
+	}
+	s.sent[key] = start
+	return true
+}
+
+func (s *Service) forget(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, start := range s.sent {
+		if start.Before(before) {
+			delete(s.sent, key)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package maintenance
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+type fakeUsers map[string]string
+
+func (f fakeUsers) GetUserByID(userID string) (*auth.User, error) {
+	role, exists := f[userID]
+	if !exists {
+		return nil, errors.New("user not found")
+	}
+	return &auth.User{ID: userID, Role: role}, nil
+}
+
+type fakeSessions struct {
+	mu      sync.Mutex
+	notices []string
+	killed  []string
+	live    []*terminal.Session
+}
+
+func (f *fakeSessions) Notice(text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.notices = append(f.notices, text)
+}
+
+func (f *fakeSessions) ListAllSessions() []*terminal.Session {
+	return f.live
+}
+
+func (f *fakeSessions) KillSession(sessionID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.killed = append(f.killed, sessionID)
+	return nil
+}
+
+func TestRecurringOccurrence(t *testing.T) {
+	w, err := parseWindow(config.MaintenanceWindow{
+		Name:     "nightly",
+		Start:    "23:00",
+		End:      "01:00",
+		Weekdays: []string{"sat"},
+	})
+	require.NoError(t, err)
+
+	// Friday noon: next opening is Saturday 23:00
+	friday := time.Date(2024, 6, 7, 12, 0, 0, 0, time.UTC)
+	occ, ok := w.occurrence(friday)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2024, 6, 8, 23, 0, 0, 0, time.UTC), occ.Start)
+	assert.Equal(t, time.Date(2024, 6, 9, 1, 0, 0, 0, time.UTC), occ.End)
+
+	// Sunday 00:30 is still inside Saturday's window
+	occ, ok = w.occurrence(time.Date(2024, 6, 9, 0, 30, 0, 0, time.UTC))
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2024, 6, 8, 23, 0, 0, 0, time.UTC), occ.Start)
+
+	_, err = parseWindow(config.MaintenanceWindow{Start: "02:00", End: "04:00", Weekdays: []string{"someday"}})
+	assert.Error(t, err)
+}
+
+func TestAdmit(t *testing.T) {
+	now := time.Now()
+	cfg := config.MaintenanceConfig{
+		Windows: []config.MaintenanceWindow{{
+			Name:    "upgrade",
+			Start:   now.Add(-time.Minute).Format(time.RFC3339),
+			End:     now.Add(time.Hour).Format(time.RFC3339),
+			Message: "database upgrade",
+		}},
+	}
+	service, err := New(cfg, fakeUsers{"alice": "user", "root": "admin"}, &fakeSessions{}, zap.NewNop())
+	require.NoError(t, err)
+
+	err = service.Admit("alice")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrMaintenance))
+	var closed *ClosedError
+	require.True(t, errors.As(err, &closed))
+	assert.Equal(t, "upgrade", closed.Window)
+	assert.Contains(t, err.Error(), "database upgrade")
+
+	assert.NoError(t, service.Admit("root"))
+
+	service.Override(now.Add(10*time.Minute), "root")
+	assert.NoError(t, service.Admit("alice"))
+	service.ClearOverride()
+	assert.Error(t, service.Admit("alice"))
+}
+
+func TestCountdown(t *testing.T) {
+	start := time.Date(2024, 6, 8, 2, 0, 0, 0, time.UTC)
+	cfg := config.MaintenanceConfig{
+		Windows: []config.MaintenanceWindow{{
+			Name:         "patching",
+			Start:        start.Format(time.RFC3339),
+			End:          start.Add(time.Hour).Format(time.RFC3339),
+			KillSessions: true,
+		}},
+		WarnAt: []string{"15m", "5m", "1m"},
+	}
+	sessions := &fakeSessions{live: []*terminal.Session{{ID: "sess_1"}}}
+	service, err := New(cfg, nil, sessions, zap.NewNop())
+	require.NoError(t, err)
+
+	service.tick(start.Add(-30 * time.Minute))
+	assert.Empty(t, sessions.notices)
+
+	// Starting late inside the 5m threshold sends one warning, not two
+	service.tick(start.Add(-4 * time.Minute))
+	service.tick(start.Add(-3 * time.Minute))
+	assert.Len(t, sessions.notices, 1)
+
+	service.tick(start.Add(-30 * time.Second))
+	assert.Len(t, sessions.notices, 2)
+
+	service.tick(start.Add(time.Second))
+	service.tick(start.Add(time.Minute))
+	assert.Equal(t, []string{"sess_1"}, sessions.killed)
+}
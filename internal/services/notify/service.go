@@ -0,0 +1,355 @@
+package notify
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+const (
+	EventLongRunningSession = "session.long_running"
+	EventSessionEnded       = "session.ended"
+	EventPolicyViolation    = "policy.violation"
+	EventNewLoginIP         = "auth.new_ip"
+	EventServerCapacity     = "server.capacity"
+	EventLoadShedding       = "server.load_shedding"
+)
+
+const (
+	TargetSlack = "slack"
+	TargetTeams = "teams"
+)
+
+// Bounds on what the service remembers, so neither grows for the life of
+// the process. Past them the least recent entries are dropped.
+const (
+	maxSent       = 10000 // NotifyOnce keys
+	maxLoginUsers = 10000 // users whose addresses are tracked
+	maxLoginIPs   = 20    // addresses per user
+	// loginIPTTL is how long an address stays known after its last login;
+	// a login from it after that counts as new again
+	loginIPTTL = 90 * 24 * time.Hour
+)
+
+type Service struct {
+	config config.NotifyConfig
+	client *http.Client
+	logger *zap.Logger
+
+	// sent remembers keys passed to NotifyOnce; logins tracks the users
+	// who have logged in and, in each entry's ips, the addresses they
+	// logged in from, with when they last did.
+	sent   *recency
+	logins *recency
+	mu     sync.Mutex
+
+	maxSent, maxLoginUsers, maxLoginIPs int
+	loginIPTTL                          time.Duration
+}
+
+type Event struct {
+	Type   string            `json:"type"`
+	Title  string            `json:"title"`
+	Text   string            `json:"text"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+func New(cfg config.NotifyConfig, logger *zap.Logger) *Service {
+	return &Service{
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		sent:   newRecency(),
+		logins: newRecency(),
+
+		maxSent:       maxSent,
+		maxLoginUsers: maxLoginUsers,
+		maxLoginIPs:   maxLoginIPs,
+		loginIPTTL:    loginIPTTL,
+	}
+}
+
+// Notify delivers the event to every target subscribed to its type. Delivery
+// happens in the background so callers on request paths are never blocked.
+func (s *Service) Notify(event Event) {
+	for _, target := range s.config.Targets {
+		if !subscribed(target, event.Type) {
+			continue
+		}
+		go func(target config.NotifyTarget) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := s.deliver(ctx, target, event); err != nil {
+				s.logger.Error("Failed to deliver notification",
+					zap.Error(err),
+					zap.String("event", event.Type),
+					zap.String("target", target.Type))
+			}
+		}(target)
+	}
+}
+
+// NotifyOnce sends the event only the first time the key is seen, so
+// periodic checks do not repeat the same alert.
+func (s *Service) NotifyOnce(key string, event Event) {
+	s.mu.Lock()
+	if s.sent.has(key) {
+		s.mu.Unlock()
+		return
+	}
+	if s.sent.len() >= s.maxSent {
+		s.sent.dropOldest()
+	}
+	s.sent.touch(key, time.Now())
+	s.mu.Unlock()
+
+	s.Notify(event)
+}
+
+// Forget drops keys recorded by NotifyOnce that are older than maxAge.
+func (s *Service) Forget(maxAge time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent.expire(time.Now().Add(-maxAge))
+}
+
+// LoginFrom records a successful login and raises EventNewLoginIP when a
+// user who has logged in before shows up from an address not seen within
+// loginIPTTL.
+func (s *Service) LoginFrom(userID, email, ip string) {
+	s.mu.Lock()
+	now := time.Now()
+	seen := s.logins.has(userID)
+	if !seen && s.logins.len() >= s.maxLoginUsers {
+		// The user whose latest login is the oldest is forgotten; their
+		// next login is then their first again
+		s.logins.dropOldest()
+	}
+	user := s.logins.touch(userID, now)
+	if user.ips == nil {
+		user.ips = newRecency()
+	}
+	known := user.ips
+	known.expire(now.Add(-s.loginIPTTL))
+	wasKnown := known.has(ip)
+	isNew := seen && !wasKnown
+	if !wasKnown && known.len() >= s.maxLoginIPs {
+		known.dropOldest()
+	}
+	known.touch(ip, now)
+	s.mu.Unlock()
+
+	if !isNew {
+		return
+	}
+
+	s.Notify(Event{
+		Type:  EventNewLoginIP,
+		Title: "Login from new IP address",
+		Text:  fmt.Sprintf("%s logged in from %s for the first time", email, ip),
+		Fields: map[string]string{
+			"user_id": userID,
+			"ip":      ip,
+		},
+	})
+}
+
+// recency is a set of keys ordered by when each was last touched, so the
+// least recent can be dropped without a scan
+type recency struct {
+	order *list.List // of *recent, least recent first
+	index map[string]*list.Element
+}
+
+type recent struct {
+	key string
+	at  time.Time
+	ips *recency // a user's addresses, for logins
+}
+
+func newRecency() *recency {
+	return &recency{order: list.New(), index: make(map[string]*list.Element)}
+}
+
+func (r *recency) len() int {
+	return r.order.Len()
+}
+
+func (r *recency) has(key string) bool {
+	_, ok := r.index[key]
+	return ok
+}
+
+// touch records key as seen at, which must not be before earlier touches,
+// and returns its entry
+func (r *recency) touch(key string, at time.Time) *recent {
+	if elem, ok := r.index[key]; ok {
+		entry := elem.Value.(*recent)
+		entry.at = at
+		r.order.MoveToBack(elem)
+		return entry
+	}
+	entry := &recent{key: key, at: at}
+	r.index[key] = r.order.PushBack(entry)
+	return entry
+}
+
+func (r *recency) dropOldest() {
+	if front := r.order.Front(); front != nil {
+		r.remove(front)
+	}
+}
+
+// expire drops the keys last touched before cutoff
+func (r *recency) expire(cutoff time.Time) {
+	for front := r.order.Front(); front != nil && front.Value.(*recent).at.Before(cutoff); front = r.order.Front() {
+		r.remove(front)
+	}
+}
+
+func (r *recency) remove(elem *list.Element) {
+	delete(r.index, elem.Value.(*recent).key)
+	r.order.Remove(elem)
+}
+
+// HandleSessionEvent raises EventSessionEnded when a session's command
+// exits, if session_ended is on. It is registered with
+// terminal.Service.OnEvent.
+func (s *Service) HandleSessionEvent(event terminal.Event) {
+	if !s.config.SessionEnded || event.Type != terminal.EventSessionExited {
+		return
+	}
+
+	fields := map[string]string{
+		"session_id": event.SessionID,
+		"user_id":    event.UserID,
+		"exit_code":  event.Detail["exit_code"],
+		"duration":   event.Detail["duration"],
+	}
+	if signal := event.Detail["signal"]; signal != "" {
+		fields["signal"] = signal
+	}
+	s.Notify(Event{
+		Type:   EventSessionEnded,
+		Title:  "Session ended",
+		Text:   fmt.Sprintf("%s exited with code %s after %s", event.Command, event.Detail["exit_code"], event.Detail["duration"]),
+		Fields: fields,
+	})
+}
+
+// LongRunningThreshold returns how long a session may run before
+// EventLongRunningSession fires, or zero when the check is disabled.
+func (s *Service) LongRunningThreshold() time.Duration {
+	if s.config.LongRunningThreshold == "" {
+		return 0
+	}
+	threshold, err := time.ParseDuration(s.config.LongRunningThreshold)
+	if err != nil {
+		return 0
+	}
+	return threshold
+}
+
+func (s *Service) deliver(ctx context.Context, target config.NotifyTarget, event Event) error {
+	var payload interface{}
+	switch target.Type {
+	case TargetSlack:
+		payload = slackPayload(event)
+	case TargetTeams:
+		payload = teamsPayload(event)
+	default:
+		return fmt.Errorf("unknown notification target type: %s", target.Type)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func subscribed(target config.NotifyTarget, eventType string) bool {
+	if len(target.Events) == 0 {
+		return true
+	}
+	for _, e := range target.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// slackPayload renders an event in Slack incoming webhook format.
+func slackPayload(event Event) map[string]interface{} {
+	var fields []map[string]interface{}
+	for name, value := range event.Fields {
+		fields = append(fields, map[string]interface{}{
+			"title": name,
+			"value": value,
+			"short": true,
+		})
+	}
+
+	return map[string]interface{}{
+		"text": fmt.Sprintf("*%s*\n%s", event.Title, event.Text),
+		"attachments": []map[string]interface{}{
+			{
+				"fallback": event.Text,
+				"footer":   "webtunnel " + event.Type,
+				"fields":   fields,
+			},
+		},
+	}
+}
+
+// teamsPayload renders an event as a Microsoft Teams MessageCard.
+func teamsPayload(event Event) map[string]interface{} {
+	var facts []map[string]string
+	for name, value := range event.Fields {
+		facts = append(facts, map[string]string{
+			"name":  name,
+			"value": value,
+		})
+	}
+
+	return map[string]interface{}{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  event.Title,
+		"title":    event.Title,
+		"text":     event.Text,
+		"sections": []map[string]interface{}{
+			{
+				"activitySubtitle": event.Type,
+				"facts":            facts,
+			},
+		},
+	}
+}
@@ -0,0 +1,214 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// receiver is a webhook that hands each payload it gets to the test
+func receiver(t *testing.T) (*httptest.Server, chan map[string]interface{}) {
+	payloads := make(chan map[string]interface{}, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var payload map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &payload))
+		payloads <- payload
+	}))
+	t.Cleanup(server.Close)
+	return server, payloads
+}
+
+func next(t *testing.T, payloads chan map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	select {
+	case payload := <-payloads:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification delivered")
+		return nil
+	}
+}
+
+func none(t *testing.T, payloads chan map[string]interface{}) {
+	t.Helper()
+	select {
+	case payload := <-payloads:
+		t.Fatalf("unexpected notification: %v", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	server, payloads := receiver(t)
+	service := New(config.NotifyConfig{Targets: []config.NotifyTarget{{Type: TargetSlack, URL: server.URL}}}, zap.NewNop())
+
+	service.Notify(Event{
+		Type:   EventPolicyViolation,
+		Title:  "Policy violation",
+		Text:   "alice ran rm",
+		Fields: map[string]string{"user_id": "alice"},
+	})
+	payload := next(t, payloads)
+	assert.Equal(t, "*Policy violation*\nalice ran rm", payload["text"])
+
+	attachments := payload["attachments"].([]interface{})
+	require.Len(t, attachments, 1)
+	attachment := attachments[0].(map[string]interface{})
+	assert.Equal(t, "alice ran rm", attachment["fallback"])
+	assert.Equal(t, "webtunnel policy.violation", attachment["footer"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"title": "user_id", "value": "alice", "short": true},
+	}, attachment["fields"])
+}
+
+func TestTeamsPayload(t *testing.T) {
+	server, payloads := receiver(t)
+	service := New(config.NotifyConfig{Targets: []config.NotifyTarget{{Type: TargetTeams, URL: server.URL}}}, zap.NewNop())
+
+	service.Notify(Event{
+		Type:   EventServerCapacity,
+		Title:  "Server at capacity",
+		Text:   "50 of 50 sessions",
+		Fields: map[string]string{"sessions": "50"},
+	})
+	payload := next(t, payloads)
+	assert.Equal(t, "MessageCard", payload["@type"])
+	assert.Equal(t, "https://schema.org/extensions", payload["@context"])
+	assert.Equal(t, "Server at capacity", payload["summary"])
+	assert.Equal(t, "Server at capacity", payload["title"])
+	assert.Equal(t, "50 of 50 sessions", payload["text"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"activitySubtitle": "server.capacity",
+			"facts":            []interface{}{map[string]interface{}{"name": "sessions", "value": "50"}},
+		},
+	}, payload["sections"])
+}
+
+func TestSubscribedEvents(t *testing.T) {
+	server, payloads := receiver(t)
+	service := New(config.NotifyConfig{Targets: []config.NotifyTarget{
+		{Type: TargetSlack, URL: server.URL, Events: []string{EventNewLoginIP}},
+	}}, zap.NewNop())
+
+	service.Notify(Event{Type: EventPolicyViolation, Title: "ignored"})
+	none(t, payloads)
+	service.Notify(Event{Type: EventNewLoginIP, Title: "sent"})
+	assert.Equal(t, "*sent*\n", next(t, payloads)["text"])
+}
+
+func TestLoginFrom(t *testing.T) {
+	server, payloads := receiver(t)
+	service := New(config.NotifyConfig{Targets: []config.NotifyTarget{{Type: TargetTeams, URL: server.URL}}}, zap.NewNop())
+
+	// A first login is not news, and neither is a known address
+	service.LoginFrom("alice", "alice@example.com", "203.0.113.7")
+	service.LoginFrom("alice", "alice@example.com", "203.0.113.7")
+	none(t, payloads)
+
+	service.LoginFrom("alice", "alice@example.com", "198.51.100.1")
+	payload := next(t, payloads)
+	assert.Equal(t, "Login from new IP address", payload["title"])
+	assert.Equal(t, "alice@example.com logged in from 198.51.100.1 for the first time", payload["text"])
+	service.LoginFrom("alice", "alice@example.com", "198.51.100.1")
+	none(t, payloads)
+
+	// Addresses unseen for loginIPTTL are new again
+	service.mu.Lock()
+	service.logins.index["alice"].Value.(*recent).ips.index["203.0.113.7"].Value.(*recent).at = time.Now().Add(-loginIPTTL - time.Hour)
+	service.mu.Unlock()
+	service.LoginFrom("alice", "alice@example.com", "203.0.113.7")
+	next(t, payloads)
+}
+
+func TestNotifyOnce(t *testing.T) {
+	server, payloads := receiver(t)
+	service := New(config.NotifyConfig{Targets: []config.NotifyTarget{{Type: TargetSlack, URL: server.URL}}}, zap.NewNop())
+	event := Event{Type: EventLongRunningSession, Title: "Long-running terminal session"}
+
+	service.NotifyOnce("long_running:s1", event)
+	next(t, payloads)
+	service.NotifyOnce("long_running:s1", event)
+	none(t, payloads)
+	service.NotifyOnce("long_running:s2", event)
+	next(t, payloads)
+
+	// Forgotten keys alert again
+	service.Forget(0)
+	service.NotifyOnce("long_running:s1", event)
+	next(t, payloads)
+}
+
+func TestBounded(t *testing.T) {
+	service := New(config.NotifyConfig{}, zap.NewNop())
+	service.maxSent, service.maxLoginUsers, service.maxLoginIPs = 3, 2, 2
+
+	for i := 0; i < 5; i++ {
+		service.NotifyOnce(fmt.Sprintf("key%d", i), Event{})
+	}
+	assert.Equal(t, []string{"key2", "key3", "key4"}, service.sent.keys())
+	assert.Len(t, service.sent.index, 3)
+
+	for i := 0; i < 4; i++ {
+		service.LoginFrom("alice", "", fmt.Sprintf("10.0.0.%d", i))
+	}
+	// A login from a known address makes it the most recent
+	service.LoginFrom("alice", "", "10.0.0.2")
+	service.LoginFrom("alice", "", "10.0.0.4")
+	alice := service.logins.index["alice"].Value.(*recent)
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.4"}, alice.ips.keys())
+
+	// The user who logged in longest ago is forgotten first
+	service.LoginFrom("bob", "", "10.0.1.1")
+	service.LoginFrom("alice", "", "10.0.0.4")
+	service.LoginFrom("carol", "", "10.0.2.1")
+	assert.Equal(t, []string{"alice", "carol"}, service.logins.keys())
+	assert.Len(t, service.logins.index, 2)
+
+	// Forget drops keys from the oldest on
+	service.Forget(time.Hour)
+	assert.Len(t, service.sent.keys(), 3)
+	service.Forget(0)
+	assert.Empty(t, service.sent.keys())
+}
+
+// keys lists the keys from least to most recent
+func (r *recency) keys() []string {
+	var keys []string
+	for elem := r.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*recent).key)
+	}
+	return keys
+}
+
+func TestHandleSessionEvent(t *testing.T) {
+	server, payloads := receiver(t)
+	targets := []config.NotifyTarget{{Type: TargetTeams, URL: server.URL}}
+	event := terminal.Event{
+		Type:      terminal.EventSessionExited,
+		SessionID: "s1",
+		UserID:    "alice",
+		Command:   "make",
+		Detail:    map[string]string{"exit_code": "2", "duration": "1m30s"},
+	}
+
+	New(config.NotifyConfig{Targets: targets}, zap.NewNop()).HandleSessionEvent(event)
+	none(t, payloads)
+
+	New(config.NotifyConfig{Targets: targets, SessionEnded: true}, zap.NewNop()).HandleSessionEvent(event)
+	payload := next(t, payloads)
+	assert.Equal(t, "make exited with code 2 after 1m30s", payload["text"])
+}
@@ -0,0 +1,67 @@
+package permissions
+
+import (
+	"fmt"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+const (
+	SessionsRead  = "sessions:read"
+	SessionsWrite = "sessions:write"
+)
+
+// known lists the permissions a role may be granted
+var known = map[string]bool{
+	SessionsRead:  true,
+	SessionsWrite: true,
+}
+
+// RoleLookup resolves a user's role
+type RoleLookup func(userID string) (string, error)
+
+// Service grants permissions by role. It decides access to sessions a user
+// does not own; owners always have full access to their own.
+type Service struct {
+	grants map[string]map[string]bool
+	roles  RoleLookup
+	logger *zap.Logger
+}
+
+func New(cfg config.PermissionsConfig, roles RoleLookup, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		grants: make(map[string]map[string]bool),
+		roles:  roles,
+		logger: logger,
+	}
+	for role, perms := range cfg.Roles {
+		s.grants[role] = make(map[string]bool)
+		for _, perm := range perms {
+			if !known[perm] {
+				return nil, fmt.Errorf("unknown permission %q for role %s", perm, role)
+			}
+			s.grants[role][perm] = true
+		}
+	}
+	return s, nil
+}
+
+// SessionGrant is the access the user's role grants to every session.
+// sessions:write implies sessions:read; a failed role lookup grants nothing.
+func (s *Service) SessionGrant(userID string) terminal.Access {
+	role, err := s.roles(userID)
+	if err != nil {
+		s.logger.Warn("Failed to look up role for permissions", zap.String("user_id", userID), zap.Error(err))
+		return terminal.AccessNone
+	}
+	switch grants := s.grants[role]; {
+	case grants[SessionsWrite]:
+		return terminal.AccessWrite
+	case grants[SessionsRead]:
+		return terminal.AccessRead
+	default:
+		return terminal.AccessNone
+	}
+}
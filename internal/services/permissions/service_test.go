@@ -0,0 +1,48 @@
+package permissions
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+func TestSessionGrant(t *testing.T) {
+	roles := map[string]string{
+		"alice": "admin",
+		"bob":   "auditor",
+		"carol": "operator",
+		"dave":  "user",
+	}
+	lookup := func(userID string) (string, error) {
+		role, ok := roles[userID]
+		if !ok {
+			return "", errors.New("user not found")
+		}
+		return role, nil
+	}
+
+	service, err := New(config.PermissionsConfig{Roles: map[string][]string{
+		"admin":    {SessionsRead, SessionsWrite},
+		"auditor":  {SessionsRead},
+		"operator": {SessionsWrite},
+	}}, lookup, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.Equal(t, terminal.AccessWrite, service.SessionGrant("alice"))
+	assert.Equal(t, terminal.AccessRead, service.SessionGrant("bob"))
+	assert.Equal(t, terminal.AccessWrite, service.SessionGrant("carol"), "write implies read")
+	assert.Equal(t, terminal.AccessNone, service.SessionGrant("dave"))
+	assert.Equal(t, terminal.AccessNone, service.SessionGrant("mallory"))
+}
+
+func TestNewValidates(t *testing.T) {
+	_, err := New(config.PermissionsConfig{Roles: map[string][]string{
+		"auditor": {"sessions:watch"},
+	}}, nil, zap.NewNop())
+	assert.Error(t, err)
+}
@@ -0,0 +1,240 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	ActionSessionCreate = "session.create"
+	ActionCommandRun    = "command.run"
+	ActionFileRead      = "file.read"
+	ActionFileWrite     = "file.write"
+)
+
+const (
+	SourceOPA      = "opa"
+	SourceLocal    = "local"
+	SourceFallback = "fallback"
+)
+
+// UserLookup resolves role and org for policy input
+type UserLookup interface {
+	GetUserByID(userID string) (*auth.User, error)
+}
+
+type Service struct {
+	config config.PolicyConfig
+	users  UserLookup
+	client *http.Client
+	logger *zap.Logger
+}
+
+type Decision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+	Source string `json:"source"`
+}
+
+// Input is the document sent to OPA as "input" and matched by local rules
+type Input struct {
+	Action   string                 `json:"action"`
+	User     InputUser              `json:"user"`
+	Resource map[string]interface{} `json:"resource"`
+}
+
+type InputUser struct {
+	ID   string `json:"id"`
+	Role string `json:"role"`
+	Org  string `json:"org"`
+}
+
+func New(cfg config.PolicyConfig, users UserLookup, logger *zap.Logger) *Service {
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		timeout = 2 * time.Second // default
+	}
+
+	return &Service{
+		config: cfg,
+		users:  users,
+		client: &http.Client{Timeout: timeout},
+		logger: logger,
+	}
+}
+
+// Decide evaluates an action for a user. OPA is consulted first when
+// configured; if it is unset or unreachable, local rules decide, and if no
+// local rule matches the configured fallback applies.
+func (s *Service) Decide(ctx context.Context, userID, action string, resource map[string]interface{}) Decision {
+	input := Input{
+		Action:   action,
+		User:     InputUser{ID: userID},
+		Resource: resource,
+	}
+	if user, err := s.users.GetUserByID(userID); err == nil {
+		input.User.Role = user.Role
+		input.User.Org = user.OrgID
+	}
+
+	if s.config.OPAURL != "" {
+		decision, err := s.queryOPA(ctx, input)
+		if err == nil {
+			s.logDecision(input, decision)
+			return decision
+		}
+		s.logger.Warn("OPA query failed, using local policy", zap.Error(err), zap.String("action", action))
+	}
+
+	decision := s.evaluateLocal(input)
+	s.logDecision(input, decision)
+	return decision
+}
+
+// Authorize adapts Decide for callers that only need allow/deny
+func (s *Service) Authorize(ctx context.Context, userID, action string, resource map[string]interface{}) (bool, string) {
+	decision := s.Decide(ctx, userID, action, resource)
+	return decision.Allow, decision.Reason
+}
+
+func (s *Service) queryOPA(ctx context.Context, input Input) (Decision, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to marshal policy input: %w", err)
+	}
+
+	url := strings.TrimRight(s.config.OPAURL, "/") + "/v1/data/" + strings.Trim(s.config.PolicyPath, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to create OPA request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to query OPA: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf("OPA returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Decision{}, fmt.Errorf("failed to decode OPA response: %w", err)
+	}
+	if len(result.Result) == 0 {
+		return Decision{}, fmt.Errorf("policy %s is undefined", s.config.PolicyPath)
+	}
+
+	// The rule may be a plain boolean or an object with allow and reason
+	var allow bool
+	if err := json.Unmarshal(result.Result, &allow); err == nil {
+		return Decision{Allow: allow, Source: SourceOPA}, nil
+	}
+	var object struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(result.Result, &object); err != nil {
+		return Decision{}, fmt.Errorf("unexpected OPA result: %s", result.Result)
+	}
+	return Decision{Allow: object.Allow, Reason: object.Reason, Source: SourceOPA}, nil
+}
+
+func (s *Service) evaluateLocal(input Input) Decision {
+	for _, rule := range s.config.Rules {
+		if !ruleMatches(rule, input) {
+			continue
+		}
+		decision := Decision{
+			Allow:  rule.Effect == "allow",
+			Reason: rule.Reason,
+			Source: SourceLocal,
+		}
+		if !decision.Allow && decision.Reason == "" {
+			decision.Reason = fmt.Sprintf("%s denied by local policy", input.Action)
+		}
+		return decision
+	}
+
+	decision := Decision{Allow: s.config.FallbackAllow, Source: SourceFallback}
+	if !decision.Allow {
+		decision.Reason = fmt.Sprintf("%s denied: no policy allows it", input.Action)
+	}
+	return decision
+}
+
+func ruleMatches(rule config.PolicyRule, input Input) bool {
+	if rule.Action != "" && rule.Action != "*" && rule.Action != input.Action {
+		return false
+	}
+	if len(rule.Roles) > 0 && !contains(rule.Roles, input.User.Role) {
+		return false
+	}
+	if len(rule.Orgs) > 0 && !contains(rule.Orgs, input.User.Org) {
+		return false
+	}
+	if len(rule.PathPrefixes) > 0 {
+		path, _ := input.Resource["path"].(string)
+		matched := false
+		for _, prefix := range rule.PathPrefixes {
+			if strings.HasPrefix(path, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if rule.Elevated {
+		if elevated, _ := input.Resource["elevated"].(bool); !elevated {
+			return false
+		}
+	}
+	if len(rule.Commands) > 0 {
+		command, _ := input.Resource["command"].(string)
+		program := command
+		if fields := strings.Fields(command); len(fields) > 0 {
+			program = fields[0]
+		}
+		if !contains(rule.Commands, command) && !contains(rule.Commands, program) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Service) logDecision(input Input, decision Decision) {
+	if decision.Allow {
+		return
+	}
+	s.logger.Info("Policy denied action",
+		zap.String("action", input.Action),
+		zap.String("user_id", input.User.ID),
+		zap.String("source", decision.Source),
+		zap.String("reason", decision.Reason),
+		zap.Any("resource", input.Resource))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+type fakeUsers map[string]*auth.User
+
+func (f fakeUsers) GetUserByID(userID string) (*auth.User, error) {
+	if user, ok := f[userID]; ok {
+		return user, nil
+	}
+	return &auth.User{ID: userID, Role: "user"}, nil
+}
+
+var users = fakeUsers{
+	"alice": {ID: "alice", Role: "admin", OrgID: "acme"},
+	"bob":   {ID: "bob", Role: "user", OrgID: "acme"},
+}
+
+func TestLocalRules(t *testing.T) {
+	cfg := config.PolicyConfig{
+		FallbackAllow: false,
+		Rules: []config.PolicyRule{
+			{Action: ActionFileRead, Effect: "deny", PathPrefixes: []string{"/etc"}, Reason: "system files"},
+			{Action: "*", Effect: "allow", Roles: []string{"admin"}},
+			{Action: ActionSessionCreate, Effect: "allow", Orgs: []string{"acme"}, Commands: []string{"bash"}},
+		},
+	}
+	service := New(cfg, users, zap.NewNop())
+	ctx := context.Background()
+
+	decision := service.Decide(ctx, "alice", ActionFileRead, map[string]interface{}{"path": "/etc/shadow"})
+	assert.False(t, decision.Allow)
+	assert.Equal(t, "system files", decision.Reason)
+	assert.Equal(t, SourceLocal, decision.Source)
+
+	decision = service.Decide(ctx, "alice", ActionFileWrite, map[string]interface{}{"path": "/tmp/x"})
+	assert.True(t, decision.Allow)
+
+	decision = service.Decide(ctx, "bob", ActionSessionCreate, map[string]interface{}{"command": "bash -l"})
+	assert.True(t, decision.Allow)
+
+	// Nothing matches, so the fallback denies
+	decision = service.Decide(ctx, "bob", ActionSessionCreate, map[string]interface{}{"command": "python"})
+	assert.False(t, decision.Allow)
+	assert.Equal(t, SourceFallback, decision.Source)
+}
+
+func TestElevatedRules(t *testing.T) {
+	cfg := config.PolicyConfig{
+		FallbackAllow: true,
+		Rules: []config.PolicyRule{
+			{Action: ActionCommandRun, Effect: "allow", Commands: []string{"docker"}, Elevated: true},
+			{Action: ActionCommandRun, Effect: "deny", Commands: []string{"docker"}, Reason: "docker needs elevated mode"},
+		},
+	}
+	service := New(cfg, users, zap.NewNop())
+	ctx := context.Background()
+
+	decision := service.Decide(ctx, "bob", ActionCommandRun, map[string]interface{}{"command": "docker ps"})
+	assert.False(t, decision.Allow)
+	assert.Equal(t, "docker needs elevated mode", decision.Reason)
+
+	decision = service.Decide(ctx, "bob", ActionCommandRun, map[string]interface{}{"command": "docker ps", "elevated": true})
+	assert.True(t, decision.Allow)
+	assert.Equal(t, SourceLocal, decision.Source)
+}
+
+func TestOPAQuery(t *testing.T) {
+	var received Input
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/data/webtunnel/authz/decision", r.URL.Path)
+
+		var body struct {
+			Input Input `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received = body.Input
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": map[string]interface{}{"allow": false, "reason": "outside change window"},
+		})
+	}))
+	defer opa.Close()
+
+	cfg := config.PolicyConfig{
+		OPAURL:        opa.URL,
+		PolicyPath:    "webtunnel/authz/decision",
+		FallbackAllow: true,
+	}
+	service := New(cfg, users, zap.NewNop())
+
+	decision := service.Decide(context.Background(), "bob", ActionSessionCreate, map[string]interface{}{"command": "bash"})
+	assert.False(t, decision.Allow)
+	assert.Equal(t, "outside change window", decision.Reason)
+	assert.Equal(t, SourceOPA, decision.Source)
+	assert.Equal(t, "acme", received.User.Org)
+	assert.Equal(t, "bash", received.Resource["command"])
+
+	// An unreachable OPA falls back to local evaluation
+	opa.Close()
+	decision = service.Decide(context.Background(), "bob", ActionSessionCreate, nil)
+	assert.True(t, decision.Allow)
+	assert.Equal(t, SourceFallback, decision.Source)
+}
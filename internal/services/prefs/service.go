@@ -0,0 +1,221 @@
+package prefs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	// Time zone names must resolve in minimal containers without tzdata
+	_ "time/tzdata"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/kv"
+	"go.uber.org/zap"
+)
+
+var ErrInvalidTimezone = errors.New("unknown time zone")
+
+// Service stores per-user display preferences. Preferences are read on
+// most API requests, so they are cached; without a database they live in
+// the embedded store if there is one, and otherwise only in memory.
+type Service struct {
+	db     *database.DB
+	store  kv.Store
+	logger *zap.Logger
+
+	cache  map[string]*time.Location
+	shells map[string]ShellPrefs
+	mu     sync.RWMutex
+}
+
+// ShellPrefs are the shell the user's sessions start in and whether as a
+// login shell. An empty Shell and a nil Login use the server's defaults.
+type ShellPrefs struct {
+	Shell string `json:"shell,omitempty"`
+	Login *bool  `json:"login_shell,omitempty"`
+}
+
+func New(db *database.DB, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+		cache:  make(map[string]*time.Location),
+		shells: make(map[string]ShellPrefs),
+	}
+}
+
+// storeBucket holds time zone names by user ID in the embedded store,
+// and shellBucket ShellPrefs as JSON
+const (
+	storeBucket = "prefs.timezone"
+	shellBucket = "prefs.shell"
+)
+
+// SetStore keeps preferences in the embedded store when there is no
+// database
+func (s *Service) SetStore(store kv.Store) {
+	s.store = store
+}
+
+// LoadTimezone resolves an IANA zone name such as "Europe/Berlin"
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTimezone, name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTimezone, name)
+	}
+	return loc, nil
+}
+
+// Timezone returns the user's time zone, UTC if none is set
+func (s *Service) Timezone(ctx context.Context, userID string) (*time.Location, error) {
+	s.mu.RLock()
+	loc, cached := s.cache[userID]
+	s.mu.RUnlock()
+	if cached {
+		return loc, nil
+	}
+	if s.db == nil && s.store == nil {
+		return time.UTC, nil
+	}
+
+	var name string
+	var err error
+	if s.db != nil {
+		err = s.db.QueryRowContext(ctx,
+			"SELECT timezone FROM user_preferences WHERE user_id = $1", userID,
+		).Scan(&name)
+	} else {
+		var value []byte
+		value, err = s.store.Get(storeBucket, userID)
+		name = string(value)
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, kv.ErrNotFound):
+		loc = time.UTC
+	case err != nil:
+		return nil, fmt.Errorf("failed to look up time zone: %w", err)
+	default:
+		if loc, err = LoadTimezone(name); err != nil {
+			// A zone dropped from tzdata should not break the user's requests
+			s.logger.Warn("Stored time zone no longer resolves", zap.String("user_id", userID), zap.String("timezone", name))
+			loc = time.UTC
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[userID] = loc
+	s.mu.Unlock()
+	return loc, nil
+}
+
+// SetTimezone validates and stores the user's time zone
+func (s *Service) SetTimezone(ctx context.Context, userID, name string) (*time.Location, error) {
+	loc, err := LoadTimezone(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case s.db != nil:
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO user_preferences (user_id, timezone)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET
+				timezone = EXCLUDED.timezone,
+				updated_at = CURRENT_TIMESTAMP`,
+			userID, loc.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to store time zone: %w", err)
+		}
+	case s.store != nil:
+		if err := s.store.Put(storeBucket, userID, []byte(loc.String())); err != nil {
+			return nil, fmt.Errorf("failed to store time zone: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[userID] = loc
+	s.mu.Unlock()
+	return loc, nil
+}
+
+// Shell returns the user's shell preferences; the shell is checked against
+// the allowed shells when a session starts
+func (s *Service) Shell(ctx context.Context, userID string) (ShellPrefs, error) {
+	s.mu.RLock()
+	prefs, cached := s.shells[userID]
+	s.mu.RUnlock()
+	if cached || (s.db == nil && s.store == nil) {
+		return prefs, nil
+	}
+
+	var err error
+	if s.db != nil {
+		var shell sql.NullString
+		var login sql.NullBool
+		err = s.db.QueryRowContext(ctx,
+			"SELECT shell, login_shell FROM user_preferences WHERE user_id = $1", userID,
+		).Scan(&shell, &login)
+		prefs.Shell = shell.String
+		if login.Valid {
+			prefs.Login = &login.Bool
+		}
+	} else {
+		var value []byte
+		if value, err = s.store.Get(shellBucket, userID); err == nil {
+			err = json.Unmarshal(value, &prefs)
+		}
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, kv.ErrNotFound):
+		prefs = ShellPrefs{}
+	case err != nil:
+		return ShellPrefs{}, fmt.Errorf("failed to look up shell preferences: %w", err)
+	}
+
+	s.mu.Lock()
+	s.shells[userID] = prefs
+	s.mu.Unlock()
+	return prefs, nil
+}
+
+// SetShell stores the user's shell preferences, replacing any before
+func (s *Service) SetShell(ctx context.Context, userID string, prefs ShellPrefs) error {
+	switch {
+	case s.db != nil:
+		login := sql.NullBool{}
+		if prefs.Login != nil {
+			login = sql.NullBool{Bool: *prefs.Login, Valid: true}
+		}
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO user_preferences (user_id, shell, login_shell)
+			VALUES ($1, NULLIF($2, ''), $3)
+			ON CONFLICT (user_id) DO UPDATE SET
+				shell = EXCLUDED.shell,
+				login_shell = EXCLUDED.login_shell,
+				updated_at = CURRENT_TIMESTAMP`,
+			userID, prefs.Shell, login)
+		if err != nil {
+			return fmt.Errorf("failed to store shell preferences: %w", err)
+		}
+	case s.store != nil:
+		value, err := json.Marshal(prefs)
+		if err != nil {
+			return fmt.Errorf("failed to encode shell preferences: %w", err)
+		}
+		if err := s.store.Put(shellBucket, userID, value); err != nil {
+			return fmt.Errorf("failed to store shell preferences: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.shells[userID] = prefs
+	s.mu.Unlock()
+	return nil
+}
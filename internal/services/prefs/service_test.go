@@ -0,0 +1,102 @@
+package prefs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/kv"
+	"go.uber.org/zap"
+)
+
+func TestTimezone(t *testing.T) {
+	service := New(nil, zap.NewNop())
+	ctx := context.Background()
+
+	loc, err := service.Timezone(ctx, "user123")
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, loc)
+
+	loc, err = service.SetTimezone(ctx, "user123", "Europe/Berlin")
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Berlin", loc.String())
+
+	loc, err = service.Timezone(ctx, "user123")
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/Berlin", loc.String())
+
+	// Other users are unaffected
+	loc, err = service.Timezone(ctx, "user456")
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, loc)
+}
+
+func TestTimezoneStored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local.kv")
+	store, err := kv.Open(path)
+	require.NoError(t, err)
+	service := New(nil, zap.NewNop())
+	service.SetStore(store)
+	ctx := context.Background()
+
+	_, err = service.SetTimezone(ctx, "user123", "Asia/Tokyo")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// A restart keeps it
+	store, err = kv.Open(path)
+	require.NoError(t, err)
+	defer store.Close()
+	service = New(nil, zap.NewNop())
+	service.SetStore(store)
+	loc, err := service.Timezone(ctx, "user123")
+	require.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", loc.String())
+	loc, err = service.Timezone(ctx, "user456")
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, loc)
+}
+
+func TestSetTimezoneValidates(t *testing.T) {
+	service := New(nil, zap.NewNop())
+
+	for _, name := range []string{"", "Local", "Mars/Olympus_Mons", "../../etc/passwd"} {
+		_, err := service.SetTimezone(context.Background(), "user123", name)
+		assert.ErrorIs(t, err, ErrInvalidTimezone, name)
+	}
+}
+
+func TestShell(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local.kv")
+	store, err := kv.Open(path)
+	require.NoError(t, err)
+	service := New(nil, zap.NewNop())
+	service.SetStore(store)
+	ctx := context.Background()
+
+	shell, err := service.Shell(ctx, "user123")
+	require.NoError(t, err)
+	assert.Equal(t, ShellPrefs{}, shell)
+
+	login := true
+	require.NoError(t, service.SetShell(ctx, "user123", ShellPrefs{Shell: "zsh", Login: &login}))
+	require.NoError(t, store.Close())
+
+	// A restart keeps it
+	store, err = kv.Open(path)
+	require.NoError(t, err)
+	defer store.Close()
+	service = New(nil, zap.NewNop())
+	service.SetStore(store)
+	shell, err = service.Shell(ctx, "user123")
+	require.NoError(t, err)
+	assert.Equal(t, "zsh", shell.Shell)
+	require.NotNil(t, shell.Login)
+	assert.True(t, *shell.Login)
+	shell, err = service.Shell(ctx, "user456")
+	require.NoError(t, err)
+	assert.Equal(t, ShellPrefs{}, shell)
+}
@@ -0,0 +1,183 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+// recordSize is the aes128gcm record size advertised in the header. Push
+// payloads are small enough to always fit in a single record.
+const recordSize = 4096
+
+// maxPayload is the largest plaintext push services are required to accept.
+const maxPayload = 3993
+
+var b64 = base64.RawURLEncoding
+
+// vapidKeys is the application server key pair from RFC 8292.
+type vapidKeys struct {
+	private   *ecdsa.PrivateKey
+	publicKey string // base64url uncompressed point, handed to browsers
+}
+
+// loadVAPIDKeys decodes a configured key pair, or generates a new one when
+// no private key is set.
+func loadVAPIDKeys(privateKey string) (*vapidKeys, error) {
+	var key *ecdh.PrivateKey
+	var err error
+	if privateKey == "" {
+		key, err = ecdh.P256().GenerateKey(rand.Reader)
+	} else {
+		var raw []byte
+		raw, err = decodeKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+		}
+		key, err = ecdh.P256().NewPrivateKey(raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load VAPID key: %w", err)
+	}
+
+	// Round trip through PKCS#8 to get the ecdsa form used for signing
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode VAPID key: %w", err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode VAPID key: %w", err)
+	}
+	signer, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected VAPID key type %T", parsed)
+	}
+
+	return &vapidKeys{
+		private:   signer,
+		publicKey: b64.EncodeToString(key.PublicKey().Bytes()),
+	}, nil
+}
+
+// authorization builds the VAPID Authorization header for an endpoint.
+func (k *vapidKeys) authorization(endpoint, subject string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": subject,
+	})
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign VAPID token: %w", err)
+	}
+
+	return fmt.Sprintf("vapid t=%s, k=%s", signed, k.publicKey), nil
+}
+
+// encrypt seals a payload for one subscription using the aes128gcm content
+// coding from RFC 8188 with the key derivation from RFC 8291.
+func encrypt(payload []byte, p256dh, authSecret string) ([]byte, error) {
+	if len(payload) > maxPayload {
+		return nil, fmt.Errorf("payload too large: %d bytes", len(payload))
+	}
+
+	uaPublicRaw, err := decodeKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	auth, err := decodeKey(authSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth secret: %w", err)
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	sharedSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive shared secret: %w", err)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicRaw...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := derive(sharedSecret, auth, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := derive(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := derive(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	// Header: salt, record size, key id length, key id (our public key)
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	// A single record ends with the last-record delimiter
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+func derive(secret, salt, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return out, nil
+}
+
+// decodeKey accepts base64url with or without padding, which is what
+// browsers hand out from PushSubscription.toJSON().
+func decodeKey(s string) ([]byte, error) {
+	if raw, err := b64.DecodeString(s); err == nil {
+		return raw, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
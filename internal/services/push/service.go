@@ -0,0 +1,285 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+type Service struct {
+	config config.PushConfig
+	db     *database.DB
+	keys   *vapidKeys
+	client *http.Client
+	logger *zap.Logger
+
+	// memory holds subscriptions by user when there is no database
+	memory map[string]map[string]*Subscription
+	mu     sync.Mutex
+}
+
+// Subscription is a browser PushSubscription as returned by toJSON()
+type Subscription struct {
+	Endpoint  string    `json:"endpoint"`
+	Keys      Keys      `json:"keys"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+type Keys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// Message is the JSON payload the service worker receives
+type Message struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Body      string `json:"body"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// New creates the push service. Without configured VAPID keys an ephemeral
+// pair is generated, which invalidates existing subscriptions on restart.
+func New(cfg config.PushConfig, db *database.DB, logger *zap.Logger) (*Service, error) {
+	keys, err := loadVAPIDKeys(cfg.VAPIDPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.VAPIDPrivateKey == "" {
+		logger.Warn("No VAPID key configured, generated an ephemeral key pair",
+			zap.String("vapid_public_key", keys.publicKey))
+	} else if cfg.VAPIDPublicKey != "" && cfg.VAPIDPublicKey != keys.publicKey {
+		return nil, fmt.Errorf("VAPID public key does not match private key")
+	}
+
+	return &Service{
+		config: cfg,
+		db:     db,
+		keys:   keys,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		memory: make(map[string]map[string]*Subscription),
+	}, nil
+}
+
+// PublicKey returns the application server key browsers subscribe with.
+func (s *Service) PublicKey() string {
+	return s.keys.publicKey
+}
+
+func (s *Service) Subscribe(ctx context.Context, userID string, sub *Subscription) error {
+	if err := validate(sub); err != nil {
+		return err
+	}
+
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// An endpoint belongs to one browser, so it moves between users
+		for _, subs := range s.memory {
+			delete(subs, sub.Endpoint)
+		}
+		if s.memory[userID] == nil {
+			s.memory[userID] = make(map[string]*Subscription)
+		}
+		sub.CreatedAt = time.Now()
+		s.memory[userID][sub.Endpoint] = sub
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (endpoint) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			p256dh = EXCLUDED.p256dh,
+			auth = EXCLUDED.auth`,
+		userID, sub.Endpoint, sub.Keys.P256dh, sub.Keys.Auth)
+	if err != nil {
+		return fmt.Errorf("failed to save push subscription: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) Unsubscribe(ctx context.Context, userID, endpoint string) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.memory[userID], endpoint)
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM push_subscriptions WHERE user_id = $1 AND endpoint = $2",
+		userID, endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to delete push subscription: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) subscriptions(ctx context.Context, userID string) ([]*Subscription, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var result []*Subscription
+		for _, sub := range s.memory[userID] {
+			result = append(result, sub)
+		}
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT endpoint, p256dh, auth, created_at
+		FROM push_subscriptions WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query push subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*Subscription
+	for rows.Next() {
+		sub := &Subscription{}
+		if err := rows.Scan(&sub.Endpoint, &sub.Keys.P256dh, &sub.Keys.Auth, &sub.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan push subscription: %w", err)
+		}
+		result = append(result, sub)
+	}
+	return result, rows.Err()
+}
+
+// Send delivers a message to every browser the user subscribed from.
+// Subscriptions the push service reports as gone are removed.
+func (s *Service) Send(ctx context.Context, userID string, msg Message) error {
+	subs, err := s.subscriptions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal push message: %w", err)
+	}
+
+	for _, sub := range subs {
+		status, err := s.deliver(ctx, sub, payload)
+		if status == http.StatusNotFound || status == http.StatusGone {
+			s.logger.Info("Removing expired push subscription",
+				zap.String("user_id", userID),
+				zap.Int("status", status))
+			if err := s.Unsubscribe(ctx, userID, sub.Endpoint); err != nil {
+				s.logger.Error("Failed to remove push subscription", zap.Error(err))
+			}
+			continue
+		}
+		if err != nil {
+			s.logger.Error("Failed to deliver push notification",
+				zap.Error(err),
+				zap.String("user_id", userID))
+		}
+	}
+	return nil
+}
+
+func (s *Service) deliver(ctx context.Context, sub *Subscription, payload []byte) (int, error) {
+	body, err := encrypt(payload, sub.Keys.P256dh, sub.Keys.Auth)
+	if err != nil {
+		return 0, err
+	}
+	authorization, err := s.keys.authorization(sub.Endpoint, s.config.Subject)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(s.config.TTL))
+	req.Header.Set("Urgency", "normal")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post push message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("push service returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// HandleSessionEvent turns terminal events into push notifications. It is
+// registered with terminal.Service.OnEvent.
+func (s *Service) HandleSessionEvent(event terminal.Event) {
+	msg, ok := messageFor(event)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.Send(ctx, event.UserID, msg); err != nil {
+		s.logger.Error("Failed to send session push notification",
+			zap.Error(err),
+			zap.String("event", event.Type),
+			zap.String("session_id", event.SessionID))
+	}
+}
+
+func messageFor(event terminal.Event) (Message, bool) {
+	msg := Message{Type: event.Type, SessionID: event.SessionID}
+
+	switch event.Type {
+	case terminal.EventSessionExited:
+		// Attached users already see the exit in their terminal
+		if event.Detail["detached"] != "true" {
+			return msg, false
+		}
+		msg.Title = "Session finished"
+		msg.Body = fmt.Sprintf("%s has exited", event.Command)
+	case terminal.EventOutputAlert:
+		msg.Title = "Error in session output"
+		msg.Body = fmt.Sprintf("%s: %s", event.Command, event.Detail["match"])
+	case terminal.EventIdleWarning:
+		msg.Title = "Session about to be closed"
+		msg.Body = fmt.Sprintf("%s has been idle for %s and will be closed in %s",
+			event.Command, event.Detail["idle"], event.Detail["terminates_in"])
+	default:
+		return msg, false
+	}
+	return msg, true
+}
+
+func validate(sub *Subscription) error {
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("endpoint must be an https URL")
+	}
+	if sub.Keys.P256dh == "" || sub.Keys.Auth == "" {
+		return fmt.Errorf("subscription keys are required")
+	}
+	if raw, err := decodeKey(sub.Keys.P256dh); err != nil || len(raw) != 65 {
+		return fmt.Errorf("invalid p256dh key")
+	}
+	if raw, err := decodeKey(sub.Keys.Auth); err != nil || len(raw) != 16 {
+		return fmt.Errorf("invalid auth secret")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+)
+
+// decrypt is the user agent side of RFC 8291, used to check encrypt.
+func decrypt(t *testing.T, body []byte, uaPrivate *ecdh.PrivateKey, auth []byte) []byte {
+	salt := body[:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	idLen := int(body[20])
+	asPublicRaw := body[21 : 21+idLen]
+	assert.Equal(t, uint32(recordSize), rs)
+
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicRaw)
+	require.NoError(t, err)
+	shared, err := uaPrivate.ECDH(asPublic)
+	require.NoError(t, err)
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPrivate.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublicRaw...)
+	ikm, err := derive(shared, auth, keyInfo, 32)
+	require.NoError(t, err)
+	cek, err := derive(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	require.NoError(t, err)
+	nonce, err := derive(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(cek)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	plaintext, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	require.NoError(t, err)
+
+	require.Equal(t, byte(0x02), plaintext[len(plaintext)-1])
+	return plaintext[:len(plaintext)-1]
+}
+
+func TestEncrypt(t *testing.T) {
+	uaPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	auth := make([]byte, 16)
+	_, err = rand.Read(auth)
+	require.NoError(t, err)
+
+	payload := []byte(`{"title":"Session finished"}`)
+	body, err := encrypt(payload, b64.EncodeToString(uaPrivate.PublicKey().Bytes()), b64.EncodeToString(auth))
+	require.NoError(t, err)
+
+	assert.Equal(t, payload, decrypt(t, body, uaPrivate, auth))
+
+	_, err = encrypt(make([]byte, maxPayload+1), b64.EncodeToString(uaPrivate.PublicKey().Bytes()), b64.EncodeToString(auth))
+	assert.Error(t, err)
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	keys, err := loadVAPIDKeys("")
+	require.NoError(t, err)
+
+	header, err := keys.authorization("https://push.example.com/send/abc", "mailto:ops@example.com")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(header, "vapid t="))
+
+	parts := strings.SplitN(strings.TrimPrefix(header, "vapid t="), ", k=", 2)
+	require.Len(t, parts, 2)
+	assert.Equal(t, keys.publicKey, parts[1])
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(parts[0], claims, func(*jwt.Token) (interface{}, error) {
+		return &keys.private.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"ES256"}))
+	require.NoError(t, err)
+	assert.Equal(t, "https://push.example.com", claims["aud"])
+	assert.Equal(t, "mailto:ops@example.com", claims["sub"])
+
+	// A configured key loads back to the same public key
+	reloaded, err := loadVAPIDKeys(b64.EncodeToString(keys.private.D.FillBytes(make([]byte, 32))))
+	require.NoError(t, err)
+	assert.Equal(t, keys.publicKey, reloaded.publicKey)
+}
+
+func TestMessageFor(t *testing.T) {
+	_, ok := messageFor(terminal.Event{
+		Type:   terminal.EventSessionExited,
+		Detail: map[string]string{"detached": "false"},
+	})
+	assert.False(t, ok)
+
+	msg, ok := messageFor(terminal.Event{
+		Type:      terminal.EventSessionExited,
+		SessionID: "sess_1",
+		Command:   "make",
+		Detail:    map[string]string{"detached": "true"},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "sess_1", msg.SessionID)
+	assert.Contains(t, msg.Body, "make")
+
+	_, ok = messageFor(terminal.Event{
+		Type:   terminal.EventOutputAlert,
+		Detail: map[string]string{"match": "panic:"},
+	})
+	assert.True(t, ok)
+}
@@ -0,0 +1,98 @@
+package rtc
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+var ErrNotConfigured = errors.New("WebRTC is not configured")
+
+// ICEServer is one entry of RTCConfiguration.iceServers
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// Configuration is passed straight to new RTCPeerConnection(). Clients
+// should fetch a fresh one before ExpiresAt.
+type Configuration struct {
+	ICEServers []ICEServer `json:"iceServers"`
+	ExpiresAt  *time.Time  `json:"expires_at,omitempty"`
+}
+
+// Service mints short-lived TURN credentials so the data channel can be
+// relayed for browsers behind symmetric NAT. The TURN server checks them
+// with the same shared secret; nothing is stored.
+type Service struct {
+	stun   []string
+	turn   []string
+	secret []byte
+	ttl    time.Duration
+	logger *zap.Logger
+}
+
+func New(cfg config.RTCConfig, logger *zap.Logger) (*Service, error) {
+	if len(cfg.TURNURLs) > 0 && cfg.TURNSecret == "" {
+		return nil, fmt.Errorf("rtc turn_urls require turn_secret")
+	}
+
+	ttl := time.Hour
+	if cfg.CredentialTTL != "" {
+		parsed, err := time.ParseDuration(cfg.CredentialTTL)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid rtc credential_ttl %q", cfg.CredentialTTL)
+		}
+		ttl = parsed
+	}
+
+	return &Service{
+		stun:   cfg.STUNURLs,
+		turn:   cfg.TURNURLs,
+		secret: []byte(cfg.TURNSecret),
+		ttl:    ttl,
+		logger: logger,
+	}, nil
+}
+
+// Configuration returns the ICE servers for userID, with TURN credentials
+// valid until now plus the credential TTL
+func (s *Service) Configuration(userID string, now time.Time) (*Configuration, error) {
+	if len(s.stun) == 0 && len(s.turn) == 0 {
+		return nil, ErrNotConfigured
+	}
+
+	conf := &Configuration{ICEServers: []ICEServer{}}
+	if len(s.stun) > 0 {
+		conf.ICEServers = append(conf.ICEServers, ICEServer{URLs: s.stun})
+	}
+	if len(s.turn) > 0 {
+		expires := now.Add(s.ttl).Truncate(time.Second)
+		username, credential := s.credential(userID, expires)
+		conf.ICEServers = append(conf.ICEServers, ICEServer{
+			URLs:       s.turn,
+			Username:   username,
+			Credential: credential,
+		})
+		conf.ExpiresAt = &expires
+	}
+	return conf, nil
+}
+
+// credential follows the TURN REST API draft: the username is the expiry
+// as a unix timestamp and the user ID, and the password is the base64
+// HMAC-SHA1 of the username
+func (s *Service) credential(userID string, expires time.Time) (string, string) {
+	username := strconv.FormatInt(expires.Unix(), 10) + ":" + userID
+	mac := hmac.New(sha1.New, s.secret)
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,60 @@
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+func TestConfiguration(t *testing.T) {
+	service, err := New(config.RTCConfig{
+		STUNURLs:      []string{"stun:turn.example.com:3478"},
+		TURNURLs:      []string{"turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:5349"},
+		TURNSecret:    "north",
+		CredentialTTL: "10m",
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 500)
+	conf, err := service.Configuration("alice", now)
+	require.NoError(t, err)
+	require.Len(t, conf.ICEServers, 2)
+
+	assert.Equal(t, ICEServer{URLs: []string{"stun:turn.example.com:3478"}}, conf.ICEServers[0])
+
+	turn := conf.ICEServers[1]
+	assert.Len(t, turn.URLs, 2)
+	assert.Equal(t, "1700000600:alice", turn.Username)
+	// echo -n 1700000600:alice | openssl dgst -sha1 -hmac north -binary | base64
+	assert.Equal(t, "gthUwOpcRoLI0MMCziCEBOWYnpo=", turn.Credential)
+	assert.Equal(t, time.Unix(1700000600, 0), *conf.ExpiresAt)
+
+	// Credentials differ per user
+	other, err := service.Configuration("bob", now)
+	require.NoError(t, err)
+	assert.NotEqual(t, turn.Credential, other.ICEServers[1].Credential)
+}
+
+func TestConfigurationValidation(t *testing.T) {
+	_, err := New(config.RTCConfig{TURNURLs: []string{"turn:turn.example.com"}}, zap.NewNop())
+	assert.Error(t, err, "turn without secret")
+
+	_, err = New(config.RTCConfig{CredentialTTL: "soon"}, zap.NewNop())
+	assert.Error(t, err)
+
+	service, err := New(config.RTCConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	_, err = service.Configuration("alice", time.Now())
+	assert.ErrorIs(t, err, ErrNotConfigured)
+
+	stunOnly, err := New(config.RTCConfig{STUNURLs: []string{"stun:stun.example.com"}}, zap.NewNop())
+	require.NoError(t, err)
+	conf, err := stunOnly.Configuration("alice", time.Now())
+	require.NoError(t, err)
+	assert.Len(t, conf.ICEServers, 1)
+	assert.Nil(t, conf.ExpiresAt)
+}
@@ -0,0 +1,185 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// syscalls are the system calls a profile may deny, by name. Calls only
+// one architecture has are in the architecture's file.
+var syscalls = map[string]uint32{
+	"acct":              unix.SYS_ACCT,
+	"add_key":           unix.SYS_ADD_KEY,
+	"adjtimex":          unix.SYS_ADJTIMEX,
+	"bpf":               unix.SYS_BPF,
+	"chdir":             unix.SYS_CHDIR,
+	"chroot":            unix.SYS_CHROOT,
+	"clock_adjtime":     unix.SYS_CLOCK_ADJTIME,
+	"clock_settime":     unix.SYS_CLOCK_SETTIME,
+	"clone":             unix.SYS_CLONE,
+	"clone3":            unix.SYS_CLONE3,
+	"connect":           unix.SYS_CONNECT,
+	"delete_module":     unix.SYS_DELETE_MODULE,
+	"finit_module":      unix.SYS_FINIT_MODULE,
+	"fsconfig":          unix.SYS_FSCONFIG,
+	"fsmount":           unix.SYS_FSMOUNT,
+	"fsopen":            unix.SYS_FSOPEN,
+	"fspick":            unix.SYS_FSPICK,
+	"init_module":       unix.SYS_INIT_MODULE,
+	"io_uring_setup":    unix.SYS_IO_URING_SETUP,
+	"kcmp":              unix.SYS_KCMP,
+	"kexec_file_load":   unix.SYS_KEXEC_FILE_LOAD,
+	"kexec_load":        unix.SYS_KEXEC_LOAD,
+	"keyctl":            unix.SYS_KEYCTL,
+	"mkdirat":           unix.SYS_MKDIRAT,
+	"mount":             unix.SYS_MOUNT,
+	"mount_setattr":     unix.SYS_MOUNT_SETATTR,
+	"move_mount":        unix.SYS_MOVE_MOUNT,
+	"name_to_handle_at": unix.SYS_NAME_TO_HANDLE_AT,
+	"open_by_handle_at": unix.SYS_OPEN_BY_HANDLE_AT,
+	"open_tree":         unix.SYS_OPEN_TREE,
+	"perf_event_open":   unix.SYS_PERF_EVENT_OPEN,
+	"personality":       unix.SYS_PERSONALITY,
+	"pidfd_getfd":       unix.SYS_PIDFD_GETFD,
+	"pivot_root":        unix.SYS_PIVOT_ROOT,
+	"process_vm_readv":  unix.SYS_PROCESS_VM_READV,
+	"process_vm_writev": unix.SYS_PROCESS_VM_WRITEV,
+	"ptrace":            unix.SYS_PTRACE,
+	"quotactl":          unix.SYS_QUOTACTL,
+	"reboot":            unix.SYS_REBOOT,
+	"request_key":       unix.SYS_REQUEST_KEY,
+	"setdomainname":     unix.SYS_SETDOMAINNAME,
+	"sethostname":       unix.SYS_SETHOSTNAME,
+	"setns":             unix.SYS_SETNS,
+	"settimeofday":      unix.SYS_SETTIMEOFDAY,
+	"socket":            unix.SYS_SOCKET,
+	"swapoff":           unix.SYS_SWAPOFF,
+	"swapon":            unix.SYS_SWAPON,
+	"syslog":            unix.SYS_SYSLOG,
+	"umount2":           unix.SYS_UMOUNT2,
+	"unshare":           unix.SYS_UNSHARE,
+	"userfaultfd":       unix.SYS_USERFAULTFD,
+	"vhangup":           unix.SYS_VHANGUP,
+}
+
+func init() {
+	for name, nr := range archSyscalls {
+		syscalls[name] = nr
+	}
+}
+
+func knownSyscall(name string) bool {
+	_, ok := syscalls[name]
+	return ok
+}
+
+// Exec confines the current process as spec says and replaces it with
+// argv. The security module label applies from the exec on; the seccomp
+// filter is inherited by everything the command starts. Exec only returns
+// on failure.
+func Exec(spec Spec, argv []string) error {
+	if len(argv) == 0 {
+		return errors.New("no command to run")
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	// Security attributes and the seccomp mode are per thread, and the
+	// thread that sets them must be the one that execs
+	runtime.LockOSThread()
+
+	if spec.AppArmor != "" {
+		if err := writeAttr("apparmor/exec", "exec "+spec.AppArmor); errors.Is(err, os.ErrNotExist) {
+			err = writeAttr("exec", "exec "+spec.AppArmor)
+		} else if err != nil {
+			return fmt.Errorf("failed to select apparmor profile %s: %w", spec.AppArmor, err)
+		}
+	}
+	if spec.SELinux != "" {
+		if err := writeAttr("exec", spec.SELinux); err != nil {
+			return fmt.Errorf("failed to select selinux context %s: %w", spec.SELinux, err)
+		}
+	}
+	if len(spec.DenySyscalls) > 0 {
+		filter, err := seccompFilter(spec.DenySyscalls)
+		if err != nil {
+			return err
+		}
+		if err := installFilter(filter); err != nil {
+			return err
+		}
+	}
+
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+func writeAttr(name, value string) error {
+	return os.WriteFile("/proc/thread-self/attr/"+name, []byte(value), 0)
+}
+
+// seccompFilter builds a BPF program that fails the denied calls with
+// EPERM and allows the rest. A call made through another architecture's
+// ABI kills the process, so it cannot slip past the numbers checked.
+func seccompFilter(deny []string) ([]unix.SockFilter, error) {
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+	const (
+		load  = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		equal = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		ret   = unix.BPF_RET | unix.BPF_K
+		eperm = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	)
+
+	filter := []unix.SockFilter{
+		stmt(load, 4), // seccomp_data.arch
+		jump(equal, auditArch, 1, 0),
+		stmt(ret, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(load, 0), // seccomp_data.nr
+	}
+	if x32SyscallBit != 0 {
+		filter = append(filter,
+			jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+			stmt(ret, eperm))
+	}
+	for _, name := range deny {
+		nr, ok := syscalls[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown system call %q", name)
+		}
+		filter = append(filter, jump(equal, nr, 0, 1), stmt(ret, eperm))
+	}
+	return append(filter, stmt(ret, unix.SECCOMP_RET_ALLOW)), nil
+}
+
+// installFilter sets no_new_privs, which an unprivileged process needs to
+// install a filter, then applies the filter to every thread
+func installFilter(filter []unix.SockFilter) error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	r, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+	if r != 0 {
+		return fmt.Errorf("failed to install seccomp filter: thread %d cannot be synchronized", r)
+	}
+	return nil
+}
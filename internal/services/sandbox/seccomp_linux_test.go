@@ -0,0 +1,51 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The test binary re-runs itself as the process to confine, since Exec
+// replaces the process that calls it
+func TestMain(m *testing.M) {
+	if os.Getenv("SANDBOX_TEST_EXEC") == "1" {
+		err := Exec(Spec{DenySyscalls: []string{"chdir"}}, []string{"sh", "-c", "cd / && echo moved"})
+		os.Stderr.WriteString(err.Error())
+		os.Exit(2)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExecDeniesSyscalls(t *testing.T) {
+	// Unconfined, the shell can change directory
+	out, err := exec.Command("sh", "-c", "cd / && echo moved").CombinedOutput()
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "moved")
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "SANDBOX_TEST_EXEC=1")
+	out, err = cmd.CombinedOutput()
+	require.Error(t, err)
+	assert.NotContains(t, string(out), "moved")
+	assert.NotContains(t, string(out), "seccomp", "the filter was installed")
+}
+
+func TestSeccompFilter(t *testing.T) {
+	filter, err := seccompFilter([]string{"mount", "ptrace"})
+	require.NoError(t, err)
+	// Architecture check, a compare and return per denied call, allow
+	want := 4 + 2*2 + 1
+	if x32SyscallBit != 0 {
+		want += 2
+	}
+	assert.Len(t, filter, want)
+
+	_, err = seccompFilter([]string{"frobnicate"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,14 @@
+//go:build !linux || !(amd64 || arm64)
+
+package sandbox
+
+import "errors"
+
+func knownSyscall(string) bool {
+	return false
+}
+
+// Exec is only supported on Linux on amd64 and arm64
+func Exec(Spec, []string) error {
+	return errors.New("sandboxing is not supported on this platform")
+}
@@ -0,0 +1,117 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Command is the hidden subcommand of the server binary that applies a
+// profile and execs the session's command
+const Command = "sandbox-exec"
+
+// Spec is what a profile applies to a process
+type Spec struct {
+	DenySyscalls []string
+	AppArmor     string
+	SELinux      string
+}
+
+// RoleLookup resolves a user's role
+type RoleLookup func(userID string) (string, error)
+
+// Service picks each user's sandbox profile by role and wraps session
+// commands so they start confined by it
+type Service struct {
+	profiles   map[string]Spec
+	roles      map[string]string
+	def        string
+	lookup     RoleLookup
+	executable string
+	logger     *zap.Logger
+}
+
+func New(cfg config.SandboxConfig, lookup RoleLookup, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		profiles: make(map[string]Spec),
+		roles:    cfg.Roles,
+		def:      cfg.Default,
+		lookup:   lookup,
+		logger:   logger,
+	}
+	for name, profile := range cfg.Profiles {
+		for _, call := range profile.DenySyscalls {
+			if !knownSyscall(call) {
+				return nil, fmt.Errorf("sandbox profile %s: unknown system call %q", name, call)
+			}
+		}
+		for _, label := range []string{profile.AppArmor, profile.SELinux} {
+			if strings.ContainsAny(label, " \t\n\x00") {
+				return nil, fmt.Errorf("sandbox profile %s: invalid label %q", name, label)
+			}
+		}
+		s.profiles[name] = Spec{
+			DenySyscalls: profile.DenySyscalls,
+			AppArmor:     profile.AppArmor,
+			SELinux:      profile.SELinux,
+		}
+	}
+	for role, name := range cfg.Roles {
+		if _, ok := s.profiles[name]; name != "" && !ok {
+			return nil, fmt.Errorf("sandbox role %s: unknown profile %q", role, name)
+		}
+	}
+	if _, ok := s.profiles[cfg.Default]; cfg.Default != "" && !ok {
+		return nil, fmt.Errorf("sandbox default: unknown profile %q", cfg.Default)
+	}
+
+	if len(s.profiles) > 0 {
+		executable, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate the server binary for sandboxing: %w", err)
+		}
+		s.executable = executable
+	}
+	return s, nil
+}
+
+// Profile names the profile the user's sessions run under, or returns
+// empty when they run unconfined. A failed role lookup is an error rather
+// than a fallback, so a confined user is never let out by it.
+func (s *Service) Profile(userID string) (string, error) {
+	if len(s.profiles) == 0 {
+		return "", nil
+	}
+	role, err := s.lookup(userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up role: %w", err)
+	}
+	if name, ok := s.roles[role]; ok {
+		return name, nil
+	}
+	return s.def, nil
+}
+
+// Wrap returns the command line that runs argv under the named profile:
+// the server binary's sandbox-exec subcommand, which applies it and execs
+// argv
+func (s *Service) Wrap(profile string, argv []string) ([]string, error) {
+	spec, ok := s.profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown sandbox profile %q", profile)
+	}
+	wrapped := []string{s.executable, Command}
+	if len(spec.DenySyscalls) > 0 {
+		wrapped = append(wrapped, "--deny-syscalls="+strings.Join(spec.DenySyscalls, ","))
+	}
+	if spec.AppArmor != "" {
+		wrapped = append(wrapped, "--apparmor="+spec.AppArmor)
+	}
+	if spec.SELinux != "" {
+		wrapped = append(wrapped, "--selinux="+spec.SELinux)
+	}
+	return append(append(wrapped, "--"), argv...), nil
+}
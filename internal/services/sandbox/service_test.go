@@ -0,0 +1,65 @@
+package sandbox
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+func TestProfile(t *testing.T) {
+	roles := map[string]string{"alice": "admin", "bob": "user", "carol": "contractor"}
+	lookup := func(userID string) (string, error) {
+		role, ok := roles[userID]
+		if !ok {
+			return "", errors.New("user not found")
+		}
+		return role, nil
+	}
+
+	service, err := New(config.SandboxConfig{
+		Profiles: map[string]config.SandboxProfile{
+			"strict":   {DenySyscalls: []string{"mount", "ptrace"}, AppArmor: "webtunnel-strict"},
+			"standard": {DenySyscalls: []string{"mount"}},
+		},
+		Roles:   map[string]string{"admin": "", "contractor": "strict"},
+		Default: "standard",
+	}, lookup, zap.NewNop())
+	require.NoError(t, err)
+
+	for user, want := range map[string]string{"alice": "", "bob": "standard", "carol": "strict"} {
+		profile, err := service.Profile(user)
+		require.NoError(t, err)
+		assert.Equal(t, want, profile, user)
+	}
+	_, err = service.Profile("mallory")
+	assert.Error(t, err, "a failed lookup does not fall back to running unconfined")
+
+	argv, err := service.Wrap("strict", []string{"/bin/bash", "-c", "make"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{Command, "--deny-syscalls=mount,ptrace", "--apparmor=webtunnel-strict", "--", "/bin/bash", "-c", "make"}, argv[1:])
+	_, err = service.Wrap("missing", []string{"/bin/bash"})
+	assert.Error(t, err)
+
+	// Without profiles everyone runs unconfined
+	service, err = New(config.SandboxConfig{}, lookup, zap.NewNop())
+	require.NoError(t, err)
+	profile, err := service.Profile("mallory")
+	require.NoError(t, err)
+	assert.Empty(t, profile)
+}
+
+func TestNewValidates(t *testing.T) {
+	for name, cfg := range map[string]config.SandboxConfig{
+		"unknown syscall": {Profiles: map[string]config.SandboxProfile{"p": {DenySyscalls: []string{"frobnicate"}}}},
+		"bad label":       {Profiles: map[string]config.SandboxProfile{"p": {AppArmor: "a b"}}},
+		"unknown role":    {Profiles: map[string]config.SandboxProfile{"p": {}}, Roles: map[string]string{"user": "q"}},
+		"unknown default": {Profiles: map[string]config.SandboxProfile{"p": {}}, Default: "q"},
+	} {
+		_, err := New(cfg, nil, zap.NewNop())
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,22 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// x32SyscallBit marks calls made through the x32 ABI, which reach the
+// same kernel functions under different numbers
+const x32SyscallBit = 0x40000000
+
+var archSyscalls = map[string]uint32{
+	"ioperm":        unix.SYS_IOPERM,
+	"iopl":          unix.SYS_IOPL,
+	"mkdir":         unix.SYS_MKDIR,
+	"modify_ldt":    unix.SYS_MODIFY_LDT,
+	"uselib":        unix.SYS_USELIB,
+	"ustat":         unix.SYS_USTAT,
+	"sysfs":         unix.SYS_SYSFS,
+	"_sysctl":       unix.SYS__SYSCTL,
+	"create_module": unix.SYS_CREATE_MODULE,
+	"query_module":  unix.SYS_QUERY_MODULE,
+}
@@ -0,0 +1,10 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_AARCH64
+
+// arm64 has a single system call ABI
+const x32SyscallBit = 0
+
+var archSyscalls = map[string]uint32{}
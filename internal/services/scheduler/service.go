@@ -0,0 +1,433 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/services/audit"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"    // non-zero exit or a signal
+	StatusTimedOut  = "timed_out" // killed at the schedule's timeout
+	StatusError     = "error"     // could not be started, e.g. refused by policy
+)
+
+const (
+	defaultTimeout = 10 * time.Minute
+
+	// keepRuns is how many runs are kept per schedule
+	keepRuns = 100
+)
+
+var ErrNotFound = errors.New("schedule not found")
+
+var scheduleName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Runner runs one-shot commands; the terminal service does
+type Runner interface {
+	Exec(ctx context.Context, userID string, opts terminal.ExecOptions) (*terminal.ExecResult, error)
+}
+
+// Recorder persists audit entries
+type Recorder interface {
+	Record(ctx context.Context, entry *audit.Entry) error
+}
+
+// Schedule is a configured command and when it runs next
+type Schedule struct {
+	ID         string     `json:"id"` // the configured name
+	Cron       string     `json:"cron"`
+	Command    string     `json:"command"`
+	WorkingDir string     `json:"working_dir,omitempty"`
+	UserID     string     `json:"user_id"`
+	Timeout    string     `json:"timeout"`
+	Artifacts  []string   `json:"artifacts,omitempty"`
+	NextRun    *time.Time `json:"next_run,omitempty"`
+	Running    bool       `json:"running"`
+	LastRun    *Run       `json:"last_run,omitempty"`
+}
+
+// Run is one run of a schedule and what it wrote
+type Run struct {
+	ID         string    `json:"id"`
+	ScheduleID string    `json:"schedule_id"`
+	Status     string    `json:"status"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Error      string    `json:"error,omitempty"` // why it did not start
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMS int64     `json:"duration_ms"`
+
+	Artifacts []terminal.Artifact `json:"artifacts,omitempty"`
+}
+
+type schedule struct {
+	cfg     config.ScheduleConfig
+	cron    *Cron
+	timeout time.Duration
+	next    time.Time
+	running bool
+	last    *Run
+}
+
+// Service runs the configured schedules and keeps their run history. Runs
+// are one-shot execs, so each gets a fresh working directory that is
+// removed afterwards. A schedule that is still running when it comes due
+// again skips that run. Without a database the history lives only in
+// memory.
+type Service struct {
+	db       *database.DB
+	runner   Runner
+	recorder Recorder
+	logger   *zap.Logger
+
+	schedules map[string]*schedule
+	memory    map[string][]*Run // newest first
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+
+	now func() time.Time
+}
+
+func New(cfgs []config.ScheduleConfig, db *database.DB, runner Runner, recorder Recorder, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		db:        db,
+		runner:    runner,
+		recorder:  recorder,
+		logger:    logger,
+		schedules: make(map[string]*schedule),
+		memory:    make(map[string][]*Run),
+		now:       time.Now,
+	}
+	for _, cfg := range cfgs {
+		if !scheduleName.MatchString(cfg.Name) {
+			return nil, fmt.Errorf("invalid schedule name %q", cfg.Name)
+		}
+		if _, exists := s.schedules[cfg.Name]; exists {
+			return nil, fmt.Errorf("duplicate schedule %q", cfg.Name)
+		}
+		if cfg.Command == "" || cfg.User == "" {
+			return nil, fmt.Errorf("schedule %q needs a command and a user", cfg.Name)
+		}
+		cron, err := ParseCron(cfg.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", cfg.Name, err)
+		}
+		timeout := defaultTimeout
+		if cfg.Timeout != "" {
+			if timeout, err = time.ParseDuration(cfg.Timeout); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("schedule %q: invalid timeout %q", cfg.Name, cfg.Timeout)
+			}
+		}
+		s.schedules[cfg.Name] = &schedule{cfg: cfg, cron: cron, timeout: timeout}
+	}
+	return s, nil
+}
+
+// Run starts schedules as they come due until ctx is done, then waits for
+// the runs in progress, which ctx cancels
+func (s *Service) Run(ctx context.Context) {
+	if len(s.schedules) == 0 {
+		return
+	}
+	defer s.wg.Wait()
+
+	s.mu.Lock()
+	now := s.now()
+	for _, sch := range s.schedules {
+		sch.next = sch.cron.Next(now)
+	}
+	s.mu.Unlock()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		timer.Reset(s.tick(ctx, s.now()))
+	}
+}
+
+// tick starts every schedule due by now and returns how long to wait for
+// the next one
+func (s *Service) tick(ctx context.Context, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wait := time.Hour
+	for _, sch := range s.schedules {
+		if sch.next.IsZero() {
+			continue
+		}
+		if !now.Before(sch.next) {
+			if sch.running {
+				s.logger.Warn("Skipping scheduled run; the previous one is still running",
+					zap.String("schedule", sch.cfg.Name))
+			} else {
+				sch.running = true
+				s.wg.Add(1)
+				go s.run(ctx, sch, now)
+			}
+			sch.next = sch.cron.Next(now)
+			if sch.next.IsZero() {
+				continue
+			}
+		}
+		if d := sch.next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+func (s *Service) run(ctx context.Context, sch *schedule, started time.Time) {
+	defer s.wg.Done()
+	logger := s.logger.With(zap.String("schedule", sch.cfg.Name), zap.String("user_id", sch.cfg.User))
+
+	run := &Run{ID: generateID(), ScheduleID: sch.cfg.Name, StartedAt: started}
+	result, err := s.runner.Exec(ctx, sch.cfg.User, terminal.ExecOptions{
+		Command:    sch.cfg.Command,
+		WorkingDir: sch.cfg.WorkingDir,
+		Timeout:    sch.timeout,
+		Artifacts:  sch.cfg.Artifacts,
+	})
+	run.FinishedAt = s.now()
+	run.DurationMS = run.FinishedAt.Sub(started).Milliseconds()
+	switch {
+	case err != nil:
+		run.Status, run.Error = StatusError, err.Error()
+	case result.TimedOut:
+		run.Status = StatusTimedOut
+	case result.ExitCode != 0:
+		run.Status = StatusFailed
+	default:
+		run.Status = StatusSucceeded
+	}
+	if result != nil {
+		code := result.ExitCode
+		run.ExitCode = &code
+		run.Stdout, run.Stderr, run.Truncated = result.Stdout, result.Stderr, result.Truncated
+		run.Artifacts = result.Artifacts
+	}
+
+	// Stored even when the server is stopping, so the run is not lost
+	storeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.store(storeCtx, run); err != nil {
+		logger.Error("Failed to store scheduled run", zap.Error(err))
+	}
+	if s.recorder != nil {
+		entry := &audit.Entry{
+			ActorID:      sch.cfg.User,
+			Action:       audit.ActionScheduleRun,
+			ResourceType: "schedule",
+			ResourceID:   sch.cfg.Name,
+			Details: map[string]interface{}{
+				"run_id":  run.ID,
+				"command": sch.cfg.Command,
+				"status":  run.Status,
+			},
+		}
+		if err := s.recorder.Record(storeCtx, entry); err != nil {
+			logger.Error("Failed to record scheduled run", zap.Error(err))
+		}
+	}
+
+	s.mu.Lock()
+	sch.running = false
+	sch.last = run
+	s.mu.Unlock()
+
+	logger.Info("Scheduled run finished",
+		zap.String("status", run.Status),
+		zap.Int64("duration_ms", run.DurationMS))
+}
+
+// List returns the schedules by name. With userID set, only the ones that
+// run as that user.
+func (s *Service) List(ctx context.Context, userID string) []*Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []*Schedule{}
+	for _, sch := range s.schedules {
+		if userID != "" && sch.cfg.User != userID {
+			continue
+		}
+		result = append(result, s.describe(sch))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Get returns one schedule
+func (s *Service) Get(ctx context.Context, id string) (*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sch, ok := s.schedules[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return s.describe(sch), nil
+}
+
+// describe copies a schedule out. Callers hold mu.
+func (s *Service) describe(sch *schedule) *Schedule {
+	result := &Schedule{
+		ID:         sch.cfg.Name,
+		Cron:       sch.cfg.Cron,
+		Command:    sch.cfg.Command,
+		WorkingDir: sch.cfg.WorkingDir,
+		UserID:     sch.cfg.User,
+		Timeout:    sch.timeout.String(),
+		Artifacts:  sch.cfg.Artifacts,
+		Running:    sch.running,
+	}
+	next := sch.next
+	if next.IsZero() {
+		next = sch.cron.Next(s.now())
+	}
+	if !next.IsZero() {
+		result.NextRun = &next
+	}
+	if sch.last != nil {
+		last := *sch.last
+		result.LastRun = &last
+	}
+	return result
+}
+
+// Runs returns a schedule's runs, newest first
+func (s *Service) Runs(ctx context.Context, id string, limit int) ([]*Run, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > keepRuns {
+		limit = keepRuns
+	}
+
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result := []*Run{}
+		for _, run := range s.memory[id] {
+			if len(result) == limit {
+				break
+			}
+			copied := *run
+			result = append(result, &copied)
+		}
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT uuid, schedule_id, status, exit_code, stdout, stderr, truncated, error, started_at, finished_at, artifacts
+		FROM schedule_runs
+		WHERE schedule_id = $1
+		ORDER BY started_at DESC
+		LIMIT $2`, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled runs: %w", err)
+	}
+	defer rows.Close()
+
+	result := []*Run{}
+	for rows.Next() {
+		run := &Run{}
+		var exitCode sql.NullInt64
+		var artifacts []byte
+		if err := rows.Scan(&run.ID, &run.ScheduleID, &run.Status, &exitCode, &run.Stdout, &run.Stderr,
+			&run.Truncated, &run.Error, &run.StartedAt, &run.FinishedAt, &artifacts); err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled run: %w", err)
+		}
+		if len(artifacts) > 0 {
+			if err := json.Unmarshal(artifacts, &run.Artifacts); err != nil {
+				return nil, fmt.Errorf("failed to decode scheduled run artifacts: %w", err)
+			}
+		}
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			run.ExitCode = &code
+		}
+		run.DurationMS = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+		result = append(result, run)
+	}
+	return result, rows.Err()
+}
+
+// store saves a run and drops the schedule's runs past keepRuns
+func (s *Service) store(ctx context.Context, run *Run) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		runs := append([]*Run{run}, s.memory[run.ScheduleID]...)
+		if len(runs) > keepRuns {
+			runs = runs[:keepRuns]
+		}
+		s.memory[run.ScheduleID] = runs
+		return nil
+	}
+
+	var exitCode, artifacts interface{}
+	if run.ExitCode != nil {
+		exitCode = *run.ExitCode
+	}
+	if len(run.Artifacts) > 0 {
+		encoded, err := json.Marshal(run.Artifacts)
+		if err != nil {
+			return fmt.Errorf("failed to encode scheduled run artifacts: %w", err)
+		}
+		artifacts = encoded
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO schedule_runs (uuid, schedule_id, status, exit_code, stdout, stderr, truncated, error, started_at, finished_at, artifacts)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		run.ID, run.ScheduleID, run.Status, exitCode, run.Stdout, run.Stderr, run.Truncated, run.Error,
+		run.StartedAt, run.FinishedAt, artifacts)
+	if err != nil {
+		return fmt.Errorf("failed to save scheduled run: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		DELETE FROM schedule_runs
+		WHERE schedule_id = $1 AND id NOT IN (
+			SELECT id FROM schedule_runs WHERE schedule_id = $1 ORDER BY started_at DESC LIMIT $2
+		)`, run.ScheduleID, keepRuns)
+	if err != nil {
+		return fmt.Errorf("failed to prune scheduled runs: %w", err)
+	}
+	return nil
+}
+
+func generateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s",
+		hex.EncodeToString(b[0:4]),
+		hex.EncodeToString(b[4:6]),
+		hex.EncodeToString(b[6:8]),
+		hex.EncodeToString(b[8:10]),
+		hex.EncodeToString(b[10:16]))
+}
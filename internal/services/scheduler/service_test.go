@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+type fakeRunner struct {
+	mu    sync.Mutex
+	calls []string
+	block chan struct{}
+}
+
+func (r *fakeRunner) Exec(ctx context.Context, userID string, opts terminal.ExecOptions) (*terminal.ExecResult, error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, userID+": "+opts.Command)
+	r.mu.Unlock()
+	if r.block != nil {
+		<-r.block
+	}
+	switch opts.Command {
+	case "refused":
+		return nil, errors.New("command not allowed")
+	case "false":
+		return &terminal.ExecResult{ExitCode: 1, Stderr: "failed"}, nil
+	}
+	result := &terminal.ExecResult{Stdout: "ok\n"}
+	for _, glob := range opts.Artifacts {
+		result.Artifacts = append(result.Artifacts, terminal.Artifact{Name: glob})
+	}
+	return result, nil
+}
+
+func TestScheduler(t *testing.T) {
+	runner := &fakeRunner{}
+	s, err := New([]config.ScheduleConfig{
+		{Name: "backup", Cron: "0 3 * * *", Command: "backup.sh", User: "alice", Artifacts: []string{"backup.tar.gz"}},
+		{Name: "check", Cron: "*/5 * * * *", Command: "false", User: "bob", Timeout: "1m"},
+		{Name: "nope", Cron: "*/5 * * * *", Command: "refused", User: "bob"},
+	}, nil, runner, nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	now := time.Date(2026, 3, 10, 2, 59, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	for _, sch := range s.schedules {
+		sch.next = sch.cron.Next(now)
+	}
+
+	// Waits for the first one due
+	assert.Equal(t, time.Minute, s.tick(ctx, now))
+	assert.Empty(t, runner.calls)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 5*time.Minute, s.tick(ctx, now))
+	s.wg.Wait()
+	assert.ElementsMatch(t, []string{"alice: backup.sh", "bob: false", "bob: refused"}, runner.calls)
+
+	runs, err := s.Runs(ctx, "backup", 0)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, StatusSucceeded, runs[0].Status)
+	assert.Equal(t, "ok\n", runs[0].Stdout)
+	assert.Equal(t, []terminal.Artifact{{Name: "backup.tar.gz"}}, runs[0].Artifacts)
+
+	runs, err = s.Runs(ctx, "check", 0)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, StatusFailed, runs[0].Status)
+	require.NotNil(t, runs[0].ExitCode)
+	assert.Equal(t, 1, *runs[0].ExitCode)
+
+	runs, err = s.Runs(ctx, "nope", 0)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, StatusError, runs[0].Status)
+	assert.Nil(t, runs[0].ExitCode)
+	assert.Contains(t, runs[0].Error, "not allowed")
+
+	// Users see their own schedules
+	mine := s.List(ctx, "alice")
+	require.Len(t, mine, 1)
+	assert.Equal(t, "backup", mine[0].ID)
+	assert.Equal(t, StatusSucceeded, mine[0].LastRun.Status)
+	assert.Equal(t, time.Date(2026, 3, 11, 3, 0, 0, 0, time.UTC), *mine[0].NextRun)
+	assert.Len(t, s.List(ctx, ""), 3)
+
+	_, err = s.Runs(ctx, "missing", 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestSchedulerSkipsOverlappingRuns(t *testing.T) {
+	runner := &fakeRunner{block: make(chan struct{})}
+	s, err := New([]config.ScheduleConfig{
+		{Name: "slow", Cron: "* * * * *", Command: "sleep 120", User: "alice"},
+	}, nil, runner, nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	s.schedules["slow"].next = now
+	s.tick(ctx, now)
+	s.tick(ctx, now.Add(time.Minute))
+	close(runner.block)
+	s.wg.Wait()
+
+	assert.Len(t, runner.calls, 1)
+}
+
+func TestSchedulerConfig(t *testing.T) {
+	for _, cfg := range []config.ScheduleConfig{
+		{Name: "Bad Name", Cron: "* * * * *", Command: "true", User: "alice"},
+		{Name: "nocron", Cron: "soon", Command: "true", User: "alice"},
+		{Name: "nouser", Cron: "* * * * *", Command: "true"},
+		{Name: "timeout", Cron: "* * * * *", Command: "true", User: "alice", Timeout: "-1s"},
+	} {
+		_, err := New([]config.ScheduleConfig{cfg}, nil, &fakeRunner{}, nil, zap.NewNop())
+		assert.Error(t, err, cfg.Name)
+	}
+
+	_, err := New([]config.ScheduleConfig{
+		{Name: "twice", Cron: "* * * * *", Command: "true", User: "alice"},
+		{Name: "twice", Cron: "* * * * *", Command: "true", User: "alice"},
+	}, nil, &fakeRunner{}, nil, zap.NewNop())
+	assert.Error(t, err)
+}
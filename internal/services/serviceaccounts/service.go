@@ -0,0 +1,574 @@
+package serviceaccounts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// userPrefix marks service account user IDs, so lookups for other users
+	// never touch service account storage
+	userPrefix = "svc_"
+	// keyPrefix marks API keys, so they are never parsed as login tokens
+	keyPrefix = "wtsa_"
+
+	// Role is the role every service account has; it is never admin
+	Role = "service"
+)
+
+// Scopes a service account can be given. Everything else, including the
+// admin API, files and sharing, is out of reach.
+const (
+	ScopeExec          = "exec"           // POST /exec
+	ScopeSessionsRead  = "sessions:read"  // list, get and read output of its own sessions
+	ScopeSessionsWrite = "sessions:write" // create, attach to, type into and kill them
+)
+
+var knownScopes = map[string]bool{
+	ScopeExec:          true,
+	ScopeSessionsRead:  true,
+	ScopeSessionsWrite: true,
+}
+
+// routeScopes is the scope each route a service account may call needs
+var routeScopes = map[string]string{
+	"POST /api/v1/exec":               ScopeExec,
+	"GET /api/v1/artifacts/:id":       ScopeExec,
+	"GET /api/v1/artifacts/:id/*name": ScopeExec,
+
+	"GET /api/v1/sessions":                ScopeSessionsRead,
+	"GET /api/v1/sessions/summary":        ScopeSessionsRead,
+	"GET /api/v1/sessions/:id":            ScopeSessionsRead,
+	"GET /api/v1/sessions/:id/transcript": ScopeSessionsRead,
+	"GET /api/v1/sessions/:id/bookmarks":  ScopeSessionsRead,
+	"GET /api/v1/sessions/:id/presence":   ScopeSessionsRead,
+
+	"POST /api/v1/sessions":                    ScopeSessionsWrite,
+	"DELETE /api/v1/sessions/:id":              ScopeSessionsWrite,
+	"POST /api/v1/sessions/:id/input":          ScopeSessionsWrite,
+	"POST /api/v1/sessions/:id/expect":         ScopeSessionsWrite,
+	"POST /api/v1/sessions/:id/bookmarks":      ScopeSessionsWrite,
+	"GET /api/v1/sessions/:id/stream":          ScopeSessionsWrite,
+	"POST /api/v1/sessions/:id/poll":           ScopeSessionsWrite,
+	"GET /api/v1/sessions/:id/poll/:client":    ScopeSessionsWrite,
+	"POST /api/v1/sessions/:id/poll/:client":   ScopeSessionsWrite,
+	"DELETE /api/v1/sessions/:id/poll/:client": ScopeSessionsWrite,
+	"POST /api/v1/sessions/:id/control":        ScopeSessionsWrite,
+	"POST /api/v1/sessions/:id/control/grant":  ScopeSessionsWrite,
+	"DELETE /api/v1/sessions/:id/control":      ScopeSessionsWrite,
+}
+
+var (
+	ErrNotFound    = errors.New("service account not found")
+	ErrInvalid     = errors.New("invalid service account")
+	ErrNameTaken   = errors.New("service account name already taken")
+	ErrBadKey      = errors.New("unknown API key")
+	ErrNoLogin     = errors.New("service accounts cannot log in interactively")
+	ErrScope       = errors.New("outside the service account's scopes")
+	ErrRateLimited = errors.New("service account request quota exceeded")
+)
+
+// RateLimitError is returned by Permit for an account over its request
+// quota, with how long until its next request may pass; it wraps
+// ErrRateLimited
+type RateLimitError struct {
+	RequestsPerMinute int
+	RetryAfter        time.Duration
+}
+
+func (e *RateLimitError) Error() string { return ErrRateLimited.Error() }
+func (e *RateLimitError) Unwrap() error { return ErrRateLimited }
+
+var name = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// Account is a non-human user for CI jobs. It authenticates with an API key
+// only, can do what its scopes allow within its quotas, and is owned by the
+// admin answerable for it. APIKey is only set on the account Create and
+// RotateKey return; afterwards only its hash is kept.
+type Account struct {
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	OwnerID           string    `json:"owner_id"`
+	Scopes            []string  `json:"scopes"`
+	MaxSessions       int       `json:"max_sessions"`
+	RequestsPerMinute int       `json:"requests_per_minute"`
+	CreatedBy         string    `json:"created_by"`
+	CreatedAt         time.Time `json:"created_at"`
+	APIKey            string    `json:"api_key,omitempty"`
+
+	keyHash []byte
+}
+
+// Request describes an account to create. OwnerID defaults to the admin
+// creating it; zero quotas get the configured ones.
+type Request struct {
+	Name              string
+	OwnerID           string
+	Scopes            []string
+	MaxSessions       int
+	RequestsPerMinute int
+}
+
+// RoleLookup resolves a user's role
+type RoleLookup func(userID string) (string, error)
+
+// Sessions is the part of the terminal service used to end a deleted
+// account's sessions
+type Sessions interface {
+	ListSessions(userID string) []*terminal.Session
+	KillSession(sessionID string) error
+}
+
+// Service stores service accounts and is the auth directory and API key
+// verifier for them. Without a database accounts live only in memory, so a
+// restart deletes them.
+type Service struct {
+	db       *database.DB
+	limits   config.ServiceAccountsConfig
+	roles    RoleLookup
+	sessions Sessions
+	logger   *zap.Logger
+
+	memory   map[string]*Account
+	limiters map[string]*rate.Limiter
+	mu       sync.Mutex
+}
+
+func New(cfg config.ServiceAccountsConfig, db *database.DB, roles RoleLookup, sessions Sessions, logger *zap.Logger) *Service {
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = 5
+	}
+	if cfg.RequestsPerMinute <= 0 {
+		cfg.RequestsPerMinute = 120
+	}
+	return &Service{
+		db:       db,
+		limits:   cfg,
+		roles:    roles,
+		sessions: sessions,
+		logger:   logger,
+		memory:   make(map[string]*Account),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Create adds an account and returns it with its API key, which is shown
+// this once. The owner must be an admin.
+func (s *Service) Create(ctx context.Context, createdBy string, req Request) (*Account, error) {
+	if !name.MatchString(req.Name) {
+		return nil, fmt.Errorf("%w: name must be 1 to 64 lowercase letters, digits, dots, dashes or underscores", ErrInvalid)
+	}
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if req.MaxSessions < 0 || req.MaxSessions > s.limits.MaxSessions {
+		return nil, fmt.Errorf("%w: max_sessions must be 0 to %d", ErrInvalid, s.limits.MaxSessions)
+	}
+	if req.RequestsPerMinute < 0 || req.RequestsPerMinute > s.limits.RequestsPerMinute {
+		return nil, fmt.Errorf("%w: requests_per_minute must be 0 to %d", ErrInvalid, s.limits.RequestsPerMinute)
+	}
+
+	owner := req.OwnerID
+	if owner == "" {
+		owner = createdBy
+	}
+	if strings.HasPrefix(owner, userPrefix) {
+		return nil, fmt.Errorf("%w: the owner must be a person", ErrInvalid)
+	}
+	role, err := s.roles(owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up owner: %w", err)
+	}
+	if role != "admin" {
+		return nil, fmt.Errorf("%w: the owner must be an admin", ErrInvalid)
+	}
+
+	a := &Account{
+		ID:                userPrefix + generateID(),
+		Name:              req.Name,
+		OwnerID:           owner,
+		Scopes:            scopes,
+		MaxSessions:       req.MaxSessions,
+		RequestsPerMinute: req.RequestsPerMinute,
+		CreatedBy:         createdBy,
+		CreatedAt:         time.Now().Truncate(time.Second),
+	}
+	if a.MaxSessions == 0 {
+		a.MaxSessions = s.limits.MaxSessions
+	}
+	if a.RequestsPerMinute == 0 {
+		a.RequestsPerMinute = s.limits.RequestsPerMinute
+	}
+	if a.APIKey, err = generateKey(); err != nil {
+		return nil, err
+	}
+	a.keyHash = hashKey(a.APIKey)
+
+	if err := s.save(ctx, a); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Service account created",
+		zap.String("account_id", a.ID),
+		zap.String("name", a.Name),
+		zap.String("owner_id", a.OwnerID),
+		zap.Strings("scopes", a.Scopes),
+		zap.String("created_by", createdBy))
+	return a, nil
+}
+
+// List returns every account, by name, without keys
+func (s *Service) List(ctx context.Context) ([]*Account, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result := []*Account{}
+		for _, a := range s.memory {
+			result = append(result, a.public())
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT uuid, name, owner_id, scopes, max_sessions, requests_per_minute, created_by, created_at
+		FROM service_accounts ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service accounts: %w", err)
+	}
+	defer rows.Close()
+
+	result := []*Account{}
+	for rows.Next() {
+		a := &Account{}
+		if err := rows.Scan(&a.ID, &a.Name, &a.OwnerID, pq.Array(&a.Scopes), &a.MaxSessions,
+			&a.RequestsPerMinute, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan service account: %w", err)
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+// Get returns one account, without its key
+func (s *Service) Get(ctx context.Context, id string) (*Account, error) {
+	a, err := s.find(ctx, "uuid", id)
+	if err != nil {
+		return nil, err
+	}
+	return a.public(), nil
+}
+
+// RotateKey replaces the account's API key; the old one stops working at
+// once. The new key is shown this once.
+func (s *Service) RotateKey(ctx context.Context, id string) (*Account, error) {
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	hash := hashKey(key)
+
+	if s.db == nil {
+		s.mu.Lock()
+		a, ok := s.memory[id]
+		if ok {
+			a.keyHash = hash
+		}
+		s.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+	} else {
+		result, err := s.db.ExecContext(ctx, "UPDATE service_accounts SET key_hash = $1 WHERE uuid = $2", hash, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rotate API key: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+	}
+
+	a, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	a.APIKey = key
+	s.logger.Info("Service account key rotated", zap.String("account_id", id))
+	return a, nil
+}
+
+// Delete removes the account, which revokes its key, and kills its
+// sessions
+func (s *Service) Delete(ctx context.Context, id string) error {
+	if s.db == nil {
+		s.mu.Lock()
+		_, ok := s.memory[id]
+		delete(s.memory, id)
+		s.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+	} else {
+		result, err := s.db.ExecContext(ctx, "DELETE FROM service_accounts WHERE uuid = $1", id)
+		if err != nil {
+			return fmt.Errorf("failed to delete service account: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+	}
+	s.mu.Lock()
+	delete(s.limiters, id)
+	s.mu.Unlock()
+
+	killed := 0
+	for _, session := range s.sessions.ListSessions(id) {
+		if err := s.sessions.KillSession(session.ID); err == nil {
+			killed++
+		}
+	}
+	s.logger.Info("Service account deleted",
+		zap.String("account_id", id),
+		zap.Int("sessions_killed", killed))
+	return nil
+}
+
+// VerifyKey resolves an API key to its account. Strings that are not API
+// keys are left to the other authenticators.
+func (s *Service) VerifyKey(key string) (string, bool, error) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return "", false, nil
+	}
+	a, err := s.find(context.Background(), "key_hash", hashKey(key))
+	if errors.Is(err, ErrNotFound) {
+		return "", true, ErrBadKey
+	}
+	if err != nil {
+		return "", true, err
+	}
+	return a.ID, true, nil
+}
+
+// KeyOnly reports service accounts, which never hold login tokens
+func (s *Service) KeyOnly(userID string) bool {
+	return strings.HasPrefix(userID, userPrefix)
+}
+
+// Authenticate refuses password logins with a service account's name.
+// Other logins are left to the other authenticators.
+func (s *Service) Authenticate(login, password string) (*auth.User, bool, error) {
+	if _, err := s.find(context.Background(), "name", login); err != nil {
+		return nil, false, nil
+	}
+	return nil, true, ErrNoLogin
+}
+
+// Lookup resolves service account user IDs. A deleted account is an error,
+// which revokes its key.
+func (s *Service) Lookup(userID string) (*auth.User, bool, error) {
+	if !s.KeyOnly(userID) {
+		return nil, false, nil
+	}
+	a, err := s.find(context.Background(), "uuid", userID)
+	if err != nil {
+		return nil, true, err
+	}
+	return &auth.User{ID: a.ID, Username: a.Name, Role: Role}, true, nil
+}
+
+// SessionLimit is the account's max_sessions, in place of the per-user
+// session limit
+func (s *Service) SessionLimit(userID string) (int, bool) {
+	if !s.KeyOnly(userID) {
+		return 0, false
+	}
+	a, err := s.find(context.Background(), "uuid", userID)
+	if err != nil {
+		// A deleted account cannot start anything
+		return 0, true
+	}
+	return a.MaxSessions, true
+}
+
+// Permit checks a service account's request against its scopes and request
+// quota; route is the matched route pattern. Other users are not limited.
+func (s *Service) Permit(userID, method, route string) error {
+	if !s.KeyOnly(userID) {
+		return nil
+	}
+	a, err := s.find(context.Background(), "uuid", userID)
+	if err != nil {
+		return err
+	}
+	scope, ok := routeScopes[method+" "+route]
+	if !ok || !a.hasScope(scope) {
+		return fmt.Errorf("%w: %s %s", ErrScope, method, route)
+	}
+
+	s.mu.Lock()
+	limiter, ok := s.limiters[a.ID]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(a.RequestsPerMinute)), a.RequestsPerMinute)
+		s.limiters[a.ID] = limiter
+	}
+	s.mu.Unlock()
+	r := limiter.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return &RateLimitError{RequestsPerMinute: a.RequestsPerMinute, RetryAfter: delay}
+	}
+	return nil
+}
+
+// find returns the account whose column equals value
+func (s *Service) find(ctx context.Context, column string, value interface{}) (*Account, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, a := range s.memory {
+			var match bool
+			switch column {
+			case "uuid":
+				match = a.ID == value
+			case "name":
+				match = a.Name == value
+			case "key_hash":
+				match = string(a.keyHash) == string(value.([]byte))
+			}
+			if match {
+				return a.public(), nil
+			}
+		}
+		return nil, ErrNotFound
+	}
+
+	a := &Account{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT uuid, name, owner_id, scopes, max_sessions, requests_per_minute, created_by, created_at
+		FROM service_accounts WHERE `+column+` = $1`, value,
+	).Scan(&a.ID, &a.Name, &a.OwnerID, pq.Array(&a.Scopes), &a.MaxSessions,
+		&a.RequestsPerMinute, &a.CreatedBy, &a.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up service account: %w", err)
+	}
+	return a, nil
+}
+
+func (s *Service) save(ctx context.Context, a *Account) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, other := range s.memory {
+			if other.Name == a.Name {
+				return fmt.Errorf("%w: %s", ErrNameTaken, a.Name)
+			}
+		}
+		stored := a.public()
+		stored.keyHash = a.keyHash
+		s.memory[a.ID] = stored
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO service_accounts (uuid, name, owner_id, scopes, max_sessions, requests_per_minute, key_hash, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		a.ID, a.Name, a.OwnerID, pq.Array(a.Scopes), a.MaxSessions, a.RequestsPerMinute, a.keyHash, a.CreatedBy, a.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return fmt.Errorf("%w: %s", ErrNameTaken, a.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save service account: %w", err)
+	}
+	return nil
+}
+
+// public copies the account without its key
+func (a *Account) public() *Account {
+	copied := *a
+	copied.Scopes = append([]string(nil), a.Scopes...)
+	copied.APIKey = ""
+	copied.keyHash = nil
+	return &copied
+}
+
+func (a *Account) hasScope(scope string) bool {
+	for _, s := range a.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeScopes checks, dedupes and sorts scopes; an account needs at
+// least one
+func normalizeScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalid, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalid)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func generateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashKey is a plain SHA-256: keys are random and long enough that a slow
+// hash adds nothing
+func hashKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+func generateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s",
+		hex.EncodeToString(b[0:4]),
+		hex.EncodeToString(b[4:6]),
+		hex.EncodeToString(b[6:8]),
+		hex.EncodeToString(b[8:10]),
+		hex.EncodeToString(b[10:16]))
+}
@@ -0,0 +1,192 @@
+package serviceaccounts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+type fakeSessions struct {
+	killed []string
+}
+
+func (f *fakeSessions) ListSessions(userID string) []*terminal.Session {
+	return []*terminal.Session{{ID: "sess-" + userID, UserID: userID}}
+}
+
+func (f *fakeSessions) KillSession(sessionID string) error {
+	f.killed = append(f.killed, sessionID)
+	return nil
+}
+
+func roles(userID string) (string, error) {
+	switch userID {
+	case "admin", "other-admin":
+		return "admin", nil
+	case "ghost":
+		return "", errors.New("no such user")
+	}
+	return "user", nil
+}
+
+func newService(t *testing.T) (*Service, *fakeSessions) {
+	sessions := &fakeSessions{}
+	return New(config.ServiceAccountsConfig{MaxSessions: 3, RequestsPerMinute: 2}, nil, roles, sessions, zap.NewNop()), sessions
+}
+
+func TestCreate(t *testing.T) {
+	service, _ := newService(t)
+	ctx := context.Background()
+
+	account, err := service.Create(ctx, "admin", Request{
+		Name:   "ci-deploy",
+		Scopes: []string{ScopeSessionsWrite, ScopeExec, ScopeExec},
+	})
+	require.NoError(t, err)
+	assert.Regexp(t, `^svc_`, account.ID)
+	assert.Regexp(t, `^wtsa_`, account.APIKey)
+	assert.Equal(t, "admin", account.OwnerID)
+	assert.Equal(t, []string{ScopeExec, ScopeSessionsWrite}, account.Scopes)
+	assert.Equal(t, 3, account.MaxSessions)
+	assert.Equal(t, 2, account.RequestsPerMinute)
+
+	// The key is never shown again
+	listed, err := service.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Empty(t, listed[0].APIKey)
+
+	for _, tc := range []struct {
+		name string
+		req  Request
+	}{
+		{"bad name", Request{Name: "CI Deploy", Scopes: []string{ScopeExec}}},
+		{"no scopes", Request{Name: "ci-a"}},
+		{"unknown scope", Request{Name: "ci-b", Scopes: []string{"admin"}}},
+		{"over quota", Request{Name: "ci-c", Scopes: []string{ScopeExec}, MaxSessions: 4}},
+		{"owner not admin", Request{Name: "ci-d", Scopes: []string{ScopeExec}, OwnerID: "bob"}},
+		{"owned by an account", Request{Name: "ci-e", Scopes: []string{ScopeExec}, OwnerID: account.ID}},
+	} {
+		_, err := service.Create(ctx, "admin", tc.req)
+		assert.ErrorIs(t, err, ErrInvalid, tc.name)
+	}
+
+	_, err = service.Create(ctx, "admin", Request{Name: "ci-deploy", Scopes: []string{ScopeExec}})
+	assert.ErrorIs(t, err, ErrNameTaken)
+	_, err = service.Create(ctx, "admin", Request{Name: "ci-f", Scopes: []string{ScopeExec}, OwnerID: "ghost"})
+	assert.Error(t, err)
+
+	other, err := service.Create(ctx, "admin", Request{Name: "ci-g", Scopes: []string{ScopeExec}, OwnerID: "other-admin"})
+	require.NoError(t, err)
+	assert.Equal(t, "other-admin", other.OwnerID)
+	assert.Equal(t, "admin", other.CreatedBy)
+}
+
+func TestKeys(t *testing.T) {
+	service, sessions := newService(t)
+	ctx := context.Background()
+
+	account, err := service.Create(ctx, "admin", Request{Name: "ci", Scopes: []string{ScopeExec}})
+	require.NoError(t, err)
+
+	userID, ok, err := service.VerifyKey(account.APIKey)
+	require.True(t, ok)
+	require.NoError(t, err)
+	assert.Equal(t, account.ID, userID)
+
+	// Login tokens are someone else's business
+	_, ok, _ = service.VerifyKey("eyJhbGciOiJIUzI1NiJ9.e30.sig")
+	assert.False(t, ok)
+	_, ok, err = service.VerifyKey("wtsa_forged")
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrBadKey)
+
+	assert.True(t, service.KeyOnly(account.ID))
+	assert.False(t, service.KeyOnly("user_alice@example.com"))
+
+	// No password logins, whatever the password
+	_, ok, err = service.Authenticate("ci", "anything")
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrNoLogin)
+	_, ok, _ = service.Authenticate("alice@example.com", "secret")
+	assert.False(t, ok)
+
+	user, ok, err := service.Lookup(account.ID)
+	require.True(t, ok)
+	require.NoError(t, err)
+	assert.Equal(t, Role, user.Role)
+	assert.Equal(t, "ci", user.Username)
+
+	// Rotating revokes the old key
+	rotated, err := service.RotateKey(ctx, account.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, account.APIKey, rotated.APIKey)
+	_, _, err = service.VerifyKey(account.APIKey)
+	assert.ErrorIs(t, err, ErrBadKey)
+	userID, _, err = service.VerifyKey(rotated.APIKey)
+	require.NoError(t, err)
+	assert.Equal(t, account.ID, userID)
+
+	// Deleting revokes everything and ends its sessions
+	require.NoError(t, service.Delete(ctx, account.ID))
+	assert.Equal(t, []string{"sess-" + account.ID}, sessions.killed)
+	_, _, err = service.VerifyKey(rotated.APIKey)
+	assert.ErrorIs(t, err, ErrBadKey)
+	_, ok, err = service.Lookup(account.ID)
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, service.Delete(ctx, account.ID), ErrNotFound)
+	_, err = service.RotateKey(ctx, account.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestPermit(t *testing.T) {
+	service, _ := newService(t)
+	ctx := context.Background()
+
+	account, err := service.Create(ctx, "admin", Request{
+		Name:        "ci",
+		Scopes:      []string{ScopeSessionsRead},
+		MaxSessions: 1,
+	})
+	require.NoError(t, err)
+
+	// People are not limited here
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, service.Permit("alice", "GET", "/api/v1/admin/audit"))
+	}
+	_, ok := service.SessionLimit("alice")
+	assert.False(t, ok)
+
+	limit, ok := service.SessionLimit(account.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 1, limit)
+
+	assert.ErrorIs(t, service.Permit(account.ID, "POST", "/api/v1/exec"), ErrScope)
+	assert.ErrorIs(t, service.Permit(account.ID, "GET", "/api/v1/admin/audit"), ErrScope)
+	assert.ErrorIs(t, service.Permit(account.ID, "GET", "/api/v1/files/download"), ErrScope)
+
+	// Two requests a minute
+	service, _ = newService(t)
+	account, err = service.Create(ctx, "admin", Request{Name: "ci", Scopes: []string{ScopeSessionsRead}})
+	require.NoError(t, err)
+	assert.NoError(t, service.Permit(account.ID, "GET", "/api/v1/sessions"))
+	assert.NoError(t, service.Permit(account.ID, "GET", "/api/v1/sessions/:id"))
+	err = service.Permit(account.ID, "GET", "/api/v1/sessions")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	var limited *RateLimitError
+	require.ErrorAs(t, err, &limited)
+	assert.Equal(t, 2, limited.RequestsPerMinute)
+	assert.InDelta(t, 30*time.Second, limited.RetryAfter, float64(time.Second))
+	// A refused request does not count against the next one
+	assert.ErrorIs(t, service.Permit(account.ID, "GET", "/api/v1/sessions"), ErrRateLimited)
+
+	assert.ErrorIs(t, service.Permit("svc_deleted", "GET", "/api/v1/sessions"), ErrNotFound)
+}
@@ -0,0 +1,156 @@
+package session
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yourusername/webtunnel/pkg/config"
+)
+
+// newClient connects to a single node, a Sentinel-managed master or a
+// cluster depending on which of sentinel and cluster_addrs is configured.
+// Credentials, db and TLS come from the URL unless overridden.
+func newClient(cfg config.RedisConfig) (redis.UniversalClient, string, error) {
+	opts, err := redisOptions(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case opts.MasterName != "":
+		return redis.NewFailoverClient(opts.Failover()), "sentinel", nil
+	case len(cfg.ClusterAddrs) > 0:
+		return redis.NewClusterClient(opts.Cluster()), "cluster", nil
+	default:
+		return redis.NewClient(opts.Simple()), "single", nil
+	}
+}
+
+func redisOptions(cfg config.RedisConfig) (*redis.UniversalOptions, error) {
+	if cfg.Sentinel.MasterName != "" && len(cfg.ClusterAddrs) > 0 {
+		return nil, fmt.Errorf("redis sentinel and cluster_addrs are mutually exclusive")
+	}
+
+	opts := &redis.UniversalOptions{}
+	if cfg.URL != "" {
+		parsed, err := redis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis url: %w", err)
+		}
+		opts.Addrs = []string{parsed.Addr}
+		opts.Username = parsed.Username
+		opts.Password = parsed.Password
+		opts.DB = parsed.DB
+		opts.TLSConfig = parsed.TLSConfig
+		opts.PoolSize = parsed.PoolSize
+		opts.MinIdleConns = parsed.MinIdleConns
+		opts.MaxRetries = parsed.MaxRetries
+		opts.DialTimeout = parsed.DialTimeout
+		opts.ReadTimeout = parsed.ReadTimeout
+		opts.WriteTimeout = parsed.WriteTimeout
+		opts.PoolTimeout = parsed.PoolTimeout
+	}
+
+	if cfg.Password != "" {
+		opts.Password = cfg.Password
+	}
+	if cfg.DB != 0 {
+		opts.DB = cfg.DB
+	}
+
+	switch {
+	case cfg.Sentinel.MasterName != "":
+		if len(cfg.Sentinel.Addrs) == 0 {
+			return nil, fmt.Errorf("redis sentinel requires addrs")
+		}
+		opts.MasterName = cfg.Sentinel.MasterName
+		opts.Addrs = cfg.Sentinel.Addrs
+		opts.SentinelPassword = cfg.Sentinel.Password
+	case len(cfg.ClusterAddrs) > 0:
+		if opts.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports db 0")
+		}
+		opts.Addrs = cfg.ClusterAddrs
+	case len(opts.Addrs) == 0:
+		return nil, fmt.Errorf("redis url is required")
+	}
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := redisTLS(cfg.TLS, opts.Addrs[0])
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	if cfg.PoolSize > 0 {
+		opts.PoolSize = cfg.PoolSize
+	}
+	if cfg.MinIdleConns > 0 {
+		opts.MinIdleConns = cfg.MinIdleConns
+	}
+	if cfg.MaxRetries != 0 {
+		opts.MaxRetries = cfg.MaxRetries
+	}
+	for _, timeout := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"dial_timeout", cfg.DialTimeout, &opts.DialTimeout},
+		{"read_timeout", cfg.ReadTimeout, &opts.ReadTimeout},
+		{"write_timeout", cfg.WriteTimeout, &opts.WriteTimeout},
+		{"pool_timeout", cfg.PoolTimeout, &opts.PoolTimeout},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(timeout.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis %s: %w", timeout.name, err)
+		}
+		*timeout.dst = d
+	}
+
+	return opts, nil
+}
+
+func redisTLS(cfg config.RedisTLSConfig, addr string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if tlsConfig.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			tlsConfig.ServerName = host
+		}
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis ca_file contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
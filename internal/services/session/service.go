@@ -3,47 +3,97 @@ package session
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
-	"github.com/yourusername/webtunnel/internal/config"
+	"github.com/yourusername/webtunnel/pkg/config"
 	"go.uber.org/zap"
 )
 
 type Service struct {
-	redis  *redis.Client
+	redis  redis.UniversalClient
 	logger *zap.Logger
+
+	// healthy is cleared while the health check fails
+	interval time.Duration
+	healthy  atomic.Bool
 }
 
+var ErrSessionNotFound = errors.New("session not found")
+
 type SessionData struct {
 	UserID    string            `json:"user_id"`
 	SessionID string            `json:"session_id"`
 	Data      map[string]string `json:"data"`
 	CreatedAt time.Time         `json:"created_at"`
+	LastSeen  time.Time         `json:"last_seen"`
 	ExpiresAt time.Time         `json:"expires_at"`
+	TTL       time.Duration     `json:"ttl"` // idle time before expiry; each read extends it
 }
 
-func New(cfg config.RedisConfig, logger *zap.Logger) *Service {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     cfg.URL[8:], // Remove redis:// prefix
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
+func New(cfg config.RedisConfig, logger *zap.Logger) (*Service, error) {
+	var interval time.Duration
+	if cfg.HealthCheckInterval != "" {
+		parsed, err := time.ParseDuration(cfg.HealthCheckInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis health_check_interval: %w", err)
+		}
+		interval = parsed
+	}
 
-	return &Service{
-		redis:  rdb,
-		logger: logger,
+	rdb, mode, err := newClient(cfg)
+	if err != nil {
+		return nil, err
 	}
+	logger.Info("Configured Redis client", zap.String("mode", mode))
+
+	s := &Service{
+		redis:    rdb,
+		logger:   logger,
+		interval: interval,
+	}
+	s.healthy.Store(true)
+	return s, nil
 }
 
+// Sessions are stored under session:<id>, and each user's session IDs in
+// the set user_sessions:<user>. The set is only a hint: it expires with the
+// user's longest-lived session and members whose session has expired are
+// pruned when it is read. No command spans both keys, so this works the
+// same on a single node and on a cluster.
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func userKey(userID string) string {
+	return "user_sessions:" + userID
+}
+
+// extendIndex moves the index expiry out to ttl unless it already lasts
+// longer, so the set outlives every session in it. PTTL is -1 for a set
+// that was just created and has no expiry yet.
+var extendIndex = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -1 or (ttl >= 0 and ttl < tonumber(ARGV[1])) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return ttl
+`)
+
 func (s *Service) StoreSession(ctx context.Context, userID, sessionID string, data map[string]string, ttl time.Duration) error {
+	now := time.Now()
 	sessionData := SessionData{
 		UserID:    userID,
 		SessionID: sessionID,
 		Data:      data,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(ttl),
+		TTL:       ttl,
 	}
 
 	bytes, err := json.Marshal(sessionData)
@@ -51,16 +101,65 @@ func (s *Service) StoreSession(ctx context.Context, userID, sessionID string, da
 		return fmt.Errorf("failed to marshal session data: %w", err)
 	}
 
-	key := fmt.Sprintf("session:%s", sessionID)
-	return s.redis.Set(ctx, key, bytes, ttl).Err()
+	if _, err := s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionKey(sessionID), bytes, ttl)
+		pipe.SAdd(ctx, userKey(userID), sessionID)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return s.indexExpiry(ctx, userID, ttl)
+}
+
+func (s *Service) indexExpiry(ctx context.Context, userID string, ttl time.Duration) error {
+	// A session without a TTL keeps the index forever
+	if ttl <= 0 {
+		return s.redis.Persist(ctx, userKey(userID)).Err()
+	}
+	if err := extendIndex.Run(ctx, s.redis, []string{userKey(userID)}, ttl.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("failed to update session index: %w", err)
+	}
+	return nil
 }
 
+// GetSession returns a session and restarts its TTL, so sessions expire
+// after a period of inactivity rather than a fixed time after login
 func (s *Service) GetSession(ctx context.Context, sessionID string) (*SessionData, error) {
-	key := fmt.Sprintf("session:%s", sessionID)
-	bytes, err := s.redis.Get(ctx, key).Bytes()
+	sessionData, err := s.readSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if sessionData.TTL <= 0 {
+		return sessionData, nil
+	}
+
+	now := time.Now()
+	sessionData.LastSeen = now
+	sessionData.ExpiresAt = now.Add(sessionData.TTL)
+	bytes, err := json.Marshal(sessionData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal session data: %w", err)
+	}
+
+	// XX so a session deleted since the read is not brought back
+	updated, err := s.redis.SetXX(ctx, sessionKey(sessionID), bytes, sessionData.TTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh session: %w", err)
+	}
+	if !updated {
+		return nil, ErrSessionNotFound
+	}
+	if err := s.indexExpiry(ctx, sessionData.UserID, sessionData.TTL); err != nil {
+		return nil, err
+	}
+	return sessionData, nil
+}
+
+func (s *Service) readSession(ctx context.Context, sessionID string) (*SessionData, error) {
+	bytes, err := s.redis.Get(ctx, sessionKey(sessionID)).Bytes()
 	if err != nil {
 		if err == redis.Nil {
-			return nil, fmt.Errorf("session not found")
+			return nil, ErrSessionNotFound
 		}
 		return nil, fmt.Errorf("failed to get session: %w", err)
 	}
@@ -74,8 +173,106 @@ func (s *Service) GetSession(ctx context.Context, sessionID string) (*SessionDat
 }
 
 func (s *Service) DeleteSession(ctx context.Context, sessionID string) error {
-	key := fmt.Sprintf("session:%s", sessionID)
-	return s.redis.Del(ctx, key).Err()
+	sessionData, err := s.readSession(ctx, sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionKey(sessionID))
+		pipe.SRem(ctx, userKey(sessionData.UserID), sessionID)
+		return nil
+	})
+	return err
+}
+
+// ListSessionsByUser returns a user's live sessions, oldest first, without
+// extending them. Expired IDs are dropped from the index on the way.
+func (s *Service) ListSessionsByUser(ctx context.Context, userID string) ([]*SessionData, error) {
+	ids, err := s.redis.SMembers(ctx, userKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	if len(ids) == 0 {
+		return []*SessionData{}, nil
+	}
+
+	// Pipelined GETs rather than MGET, which a cluster rejects when the keys
+	// live on different slots
+	gets := make([]*redis.StringCmd, len(ids))
+	if _, err := s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			gets[i] = pipe.Get(ctx, sessionKey(id))
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := make([]*SessionData, 0, len(ids))
+	var expired []interface{}
+	for i, get := range gets {
+		bytes, err := get.Bytes()
+		if err == redis.Nil {
+			expired = append(expired, ids[i])
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get session: %w", err)
+		}
+		var sessionData SessionData
+		if err := json.Unmarshal(bytes, &sessionData); err != nil {
+			s.logger.Warn("Skipping unreadable session", zap.String("session_id", ids[i]), zap.Error(err))
+			continue
+		}
+		sessions = append(sessions, &sessionData)
+	}
+
+	if len(expired) > 0 {
+		if err := s.redis.SRem(ctx, userKey(userID), expired...).Err(); err != nil {
+			s.logger.Warn("Failed to prune session index", zap.String("user_id", userID), zap.Error(err))
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+// RevokeUserSessions deletes every session of a user except keep, which may
+// be empty, and returns how many were deleted. It is the store side of
+// logout everywhere only: login does not store sessions yet and JWT
+// authentication does not check them, so issued tokens stay valid.
+func (s *Service) RevokeUserSessions(ctx context.Context, userID, keep string) (int, error) {
+	ids, err := s.redis.SMembers(ctx, userKey(userID)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	var dels []*redis.IntCmd
+	if _, err := s.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			if id == keep {
+				continue
+			}
+			dels = append(dels, pipe.Del(ctx, sessionKey(id)))
+			pipe.SRem(ctx, userKey(userID), id)
+		}
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	revoked := 0
+	for _, del := range dels {
+		revoked += int(del.Val())
+	}
+	if revoked > 0 {
+		s.logger.Info("Revoked user sessions", zap.String("user_id", userID), zap.Int("count", revoked))
+	}
+	return revoked, nil
 }
 
 func (s *Service) PublishMessage(ctx context.Context, channel string, message interface{}) error {
@@ -89,4 +286,76 @@ func (s *Service) PublishMessage(ctx context.Context, channel string, message in
 
 func (s *Service) Subscribe(ctx context.Context, channel string) *redis.PubSub {
 	return s.redis.Subscribe(ctx, channel)
-}
\ No newline at end of file
+}
+
+// Client returns the Redis client, for services that share the store
+func (s *Service) Client() redis.UniversalClient {
+	return s.redis
+}
+
+// Ping checks that Redis is reachable
+func (s *Service) Ping(ctx context.Context) error {
+	return s.redis.Ping(ctx).Err()
+}
+
+// Healthy reports the result of the last health check
+func (s *Service) Healthy() bool {
+	return s.healthy.Load()
+}
+
+// Run pings Redis every health check interval. After a failure it retries
+// with exponential backoff, capped at the interval, until Redis answers;
+// each ping makes the client redial, so this also reconnects the pool.
+func (s *Service) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+
+	const minBackoff = 500 * time.Millisecond
+	wait := s.interval
+	backoff := minBackoff
+	failures := 0
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := s.Ping(pingCtx)
+		cancel()
+
+		switch {
+		case err == nil && failures > 0:
+			s.logger.Info("Redis connection restored", zap.Int("failed_checks", failures))
+			fallthrough
+		case err == nil:
+			s.healthy.Store(true)
+			failures = 0
+			backoff = minBackoff
+			wait = s.interval
+		default:
+			if failures == 0 {
+				s.logger.Error("Redis health check failed", zap.Error(err))
+			} else {
+				s.logger.Debug("Redis still unreachable", zap.Int("failed_checks", failures+1), zap.Error(err))
+			}
+			s.healthy.Store(false)
+			failures++
+			wait = backoff
+			if backoff *= 2; backoff > s.interval {
+				backoff = s.interval
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// Close releases the connection pool
+func (s *Service) Close() error {
+	return s.redis.Close()
+}
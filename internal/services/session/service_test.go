@@ -0,0 +1,90 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// newTestService connects to the Redis in WEBTUNNEL_TEST_REDIS_URL; keys
+// are namespaced per test run through the user and session IDs
+func newTestService(t *testing.T) (*Service, string) {
+	url := os.Getenv("WEBTUNNEL_TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("WEBTUNNEL_TEST_REDIS_URL not set")
+	}
+	service, err := New(config.RedisConfig{URL: url}, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { service.Close() })
+	require.NoError(t, service.Ping(context.Background()))
+	return service, fmt.Sprintf("test-%d", time.Now().UnixNano())
+}
+
+func TestSlidingTTL(t *testing.T) {
+	service, prefix := newTestService(t)
+	ctx := context.Background()
+	user, id := prefix+"-alice", prefix+"-s1"
+
+	require.NoError(t, service.StoreSession(ctx, user, id, map[string]string{"ip": "10.0.0.1"}, 400*time.Millisecond))
+
+	// Reading keeps the session alive past its original expiry
+	for i := 0; i < 3; i++ {
+		time.Sleep(250 * time.Millisecond)
+		sessionData, err := service.GetSession(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", sessionData.Data["ip"])
+	}
+
+	time.Sleep(600 * time.Millisecond)
+	_, err := service.GetSession(ctx, id)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestUserIndex(t *testing.T) {
+	service, prefix := newTestService(t)
+	ctx := context.Background()
+	alice, bob := prefix+"-alice", prefix+"-bob"
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, service.StoreSession(ctx, alice, fmt.Sprintf("%s-a%d", prefix, i), nil, time.Minute))
+	}
+	require.NoError(t, service.StoreSession(ctx, alice, prefix+"-short", nil, 50*time.Millisecond))
+	require.NoError(t, service.StoreSession(ctx, bob, prefix+"-b1", nil, time.Minute))
+
+	time.Sleep(100 * time.Millisecond)
+	sessions, err := service.ListSessionsByUser(ctx, alice)
+	require.NoError(t, err)
+	require.Len(t, sessions, 3, "expired sessions are pruned")
+	assert.Equal(t, prefix+"-a1", sessions[0].SessionID)
+
+	require.NoError(t, service.DeleteSession(ctx, prefix+"-a1"))
+	sessions, err = service.ListSessionsByUser(ctx, alice)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	// Logout everywhere else
+	revoked, err := service.RevokeUserSessions(ctx, alice, prefix+"-a3")
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	sessions, err = service.ListSessionsByUser(ctx, alice)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, prefix+"-a3", sessions[0].SessionID)
+
+	revoked, err = service.RevokeUserSessions(ctx, alice, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+
+	sessions, err = service.ListSessionsByUser(ctx, bob)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 1, "other users are untouched")
+	_, err = service.RevokeUserSessions(ctx, bob, "")
+	require.NoError(t, err)
+}
@@ -0,0 +1,350 @@
+package shares
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/kv"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalid = errors.New("invalid share link")
+	ErrExpired = errors.New("share link has expired")
+	ErrRevoked = errors.New("share link has been revoked")
+)
+
+// What a link lets its holder do
+const (
+	// ModeSnapshot links render the current screen as a static page
+	ModeSnapshot = "snapshot"
+	// ModeReadOnly links also stream the live session, without input
+	ModeReadOnly = "read-only"
+	// ModeReadWrite links let the guest type and resize as well
+	ModeReadWrite = "read-write"
+)
+
+// Share is what a link grants: a view of one session, or a seat at it.
+// ID names the link for revocation.
+type Share struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id"`
+	Mode      string    `json:"mode"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Service signs and checks share tokens. A token is the session ID, expiry,
+// mode, issue time and a nonce, base64url encoded, followed by an HMAC-SHA256 over
+// them. Only revocations are stored; without a database they are kept in
+// the embedded store if there is one, and otherwise only in memory.
+type Service struct {
+	secret []byte
+	ttl    time.Duration
+	db     *database.DB
+	store  kv.Store
+	logger *zap.Logger
+
+	// revoked maps session ID:share ID to when the revocation can be
+	// forgotten;
+	// revokedAll maps session IDs to when all their links were revoked
+	revoked    map[string]time.Time
+	revokedAll map[string]time.Time
+	mu         sync.Mutex
+}
+
+// New takes the JWT secret as the fallback signing key. Tokens are signed
+// with a distinct prefix so they can never pass for anything else signed
+// with the same key.
+func New(cfg config.SharesConfig, jwtSecret string, db *database.DB, logger *zap.Logger) (*Service, error) {
+	ttl := 24 * time.Hour
+	if cfg.TTL != "" {
+		parsed, err := time.ParseDuration(cfg.TTL)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid shares ttl %q", cfg.TTL)
+		}
+		ttl = parsed
+	}
+
+	secret := cfg.Secret
+	if secret == "" {
+		secret = jwtSecret
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("shares require a secret or auth jwt_secret")
+	}
+
+	return &Service{
+		secret:     []byte(secret),
+		ttl:        ttl,
+		db:         db,
+		logger:     logger,
+		revoked:    make(map[string]time.Time),
+		revokedAll: make(map[string]time.Time),
+	}, nil
+}
+
+// Buckets of revocations in the embedded store, keyed like the maps, with
+// the times in RFC 3339
+const (
+	revokedBucket    = "shares.revoked"
+	revokedAllBucket = "shares.revoked_all"
+)
+
+// SetStore keeps revocations in the embedded store when there is no
+// database, loading those made before a restart
+func (s *Service) SetStore(store kv.Store) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for bucket, into := range map[string]map[string]time.Time{
+		revokedBucket:    s.revoked,
+		revokedAllBucket: s.revokedAll,
+	} {
+		entries, err := store.List(bucket)
+		if err != nil {
+			return fmt.Errorf("failed to load share revocations: %w", err)
+		}
+		for key, value := range entries {
+			at, err := time.Parse(time.RFC3339Nano, string(value))
+			if err != nil {
+				s.logger.Warn("Skipping unreadable share revocation", zap.String("key", key), zap.Error(err))
+				continue
+			}
+			into[key] = at
+		}
+	}
+	s.store = store
+	s.prune(time.Now())
+	return nil
+}
+
+// Issue returns a token for a snapshot of the session
+func (s *Service) Issue(sessionID string) (string, Share) {
+	token, share, _ := s.IssueMode(sessionID, ModeSnapshot)
+	return token, share
+}
+
+// IssueMode returns a token granting mode on the session
+func (s *Service) IssueMode(sessionID, mode string) (string, Share, error) {
+	switch mode {
+	case ModeSnapshot, ModeReadOnly, ModeReadWrite:
+	default:
+		return "", Share{}, fmt.Errorf("%w: unknown mode %q", ErrInvalid, mode)
+	}
+	now := time.Now()
+	share := Share{
+		SessionID: sessionID,
+		Mode:      mode,
+		IssuedAt:  now.Truncate(time.Millisecond),
+		ExpiresAt: now.Add(s.ttl).Truncate(time.Second),
+	}
+	// The nonce keeps links issued together apart, for revocation
+	nonce := make([]byte, 6)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", Share{}, fmt.Errorf("failed to issue share link: %w", err)
+	}
+	payload := strings.Join([]string{
+		sessionID,
+		strconv.FormatInt(share.ExpiresAt.Unix(), 10),
+		mode,
+		strconv.FormatInt(share.IssuedAt.UnixMilli(), 10),
+		base64.RawURLEncoding.EncodeToString(nonce),
+	}, ":")
+	sig := s.sign(payload)
+	share.ID = shareID(sig)
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(sig)
+	return token, share, nil
+}
+
+// Verify checks a token's signature, expiry and revocation
+func (s *Service) Verify(token string) (*Share, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.sign(string(raw))) {
+		return nil, ErrInvalid
+	}
+
+	fields := strings.Split(string(raw), ":")
+	if len(fields) != 5 || fields[0] == "" {
+		return nil, ErrInvalid
+	}
+	switch fields[2] {
+	case ModeSnapshot, ModeReadOnly, ModeReadWrite:
+	default:
+		return nil, ErrInvalid
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	issued, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	share := &Share{
+		ID:        shareID(mac),
+		SessionID: fields[0],
+		Mode:      fields[2],
+		IssuedAt:  time.UnixMilli(issued),
+		ExpiresAt: time.Unix(expires, 0),
+	}
+
+	if time.Now().After(share.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	revoked, err := s.isRevoked(context.Background(), share)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrRevoked
+	}
+	return share, nil
+}
+
+// Revoke stops one link to the session from working
+func (s *Service) Revoke(ctx context.Context, sessionID, shareID string) error {
+	// The link may have been issued with a longer ttl before a restart;
+	// this only decides when the revocation is forgotten
+	forget := time.Now().Add(s.ttl)
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.prune(time.Now())
+		key := sessionID + ":" + shareID
+		if err := s.persist(revokedBucket, key, forget); err != nil {
+			return fmt.Errorf("failed to revoke share link: %w", err)
+		}
+		s.revoked[key] = forget
+		return nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO share_revocations (session_id, share_id, forget_at)
+		VALUES ($1, $2, $3)`, sessionID, shareID, forget); err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+	s.pruneDB(ctx)
+	return nil
+}
+
+// RevokeSession stops every link to the session issued so far; links
+// issued afterwards work
+func (s *Service) RevokeSession(ctx context.Context, sessionID string) error {
+	now := time.Now()
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.prune(now)
+		if err := s.persist(revokedAllBucket, sessionID, now); err != nil {
+			return fmt.Errorf("failed to revoke share links: %w", err)
+		}
+		s.revokedAll[sessionID] = now
+		return nil
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO share_revocations (session_id, revoked_at, forget_at)
+		VALUES ($1, $2, $3)`, sessionID, now, now.Add(s.ttl)); err != nil {
+		return fmt.Errorf("failed to revoke share links: %w", err)
+	}
+	s.pruneDB(ctx)
+	return nil
+}
+
+func (s *Service) isRevoked(ctx context.Context, share *Share) (bool, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.revoked[share.SessionID+":"+share.ID]; ok {
+			return true, nil
+		}
+		at, ok := s.revokedAll[share.SessionID]
+		return ok && !share.IssuedAt.After(at), nil
+	}
+
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM share_revocations
+		WHERE session_id = $1 AND (share_id = $2 OR revoked_at >= $3))`,
+		share.SessionID, share.ID, share.IssuedAt,
+	).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check share revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+// prune forgets revocations of links that have expired anyway. s.mu must be
+// held.
+func (s *Service) prune(now time.Time) {
+	for id, forget := range s.revoked {
+		if now.After(forget) {
+			delete(s.revoked, id)
+			s.forget(revokedBucket, id)
+		}
+	}
+	for sessionID, at := range s.revokedAll {
+		if now.After(at.Add(s.ttl)) {
+			delete(s.revokedAll, sessionID)
+			s.forget(revokedAllBucket, sessionID)
+		}
+	}
+}
+
+// persist writes a revocation to the embedded store, if any. s.mu must be
+// held.
+func (s *Service) persist(bucket, key string, at time.Time) error {
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Put(bucket, key, []byte(at.Format(time.RFC3339Nano)))
+}
+
+// forget removes a pruned revocation from the embedded store, if any.
+// s.mu must be held.
+func (s *Service) forget(bucket, key string) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Delete(bucket, key); err != nil {
+		s.logger.Warn("Failed to prune share revocation", zap.String("key", key), zap.Error(err))
+	}
+}
+
+func (s *Service) pruneDB(ctx context.Context) {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM share_revocations WHERE forget_at < $1", time.Now()); err != nil {
+		s.logger.Warn("Failed to prune share revocations", zap.Error(err))
+	}
+}
+
+func (s *Service) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("webtunnel-share:" + payload))
+	return mac.Sum(nil)
+}
+
+// shareID names a link by its signature, so links need not carry an ID
+func shareID(sig []byte) string {
+	return hex.EncodeToString(sig[:8])
+}
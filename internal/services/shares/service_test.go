@@ -0,0 +1,173 @@
+package shares
+
+import (
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/kv"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+func TestIssueAndVerify(t *testing.T) {
+	service, err := New(config.SharesConfig{TTL: "1h"}, "jwt-secret", nil, zap.NewNop())
+	require.NoError(t, err)
+
+	token, share := service.Issue("session-1")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), share.ExpiresAt, 2*time.Second)
+
+	verified, err := service.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "session-1", verified.SessionID)
+	assert.Equal(t, ModeSnapshot, verified.Mode)
+	assert.Equal(t, share.ExpiresAt.Unix(), verified.ExpiresAt.Unix())
+
+	// Tampering with either half breaks the signature
+	payload, sig, _ := strings.Cut(token, ".")
+	other, _ := service.Issue("session-2")
+	otherPayload, _, _ := strings.Cut(other, ".")
+	for _, bad := range []string{otherPayload + "." + sig, payload + ".AAAA", payload, "", "..."} {
+		_, err := service.Verify(bad)
+		assert.ErrorIs(t, err, ErrInvalid, bad)
+	}
+
+	// A different key does not accept the token
+	rotated, err := New(config.SharesConfig{Secret: "share-secret"}, "jwt-secret", nil, zap.NewNop())
+	require.NoError(t, err)
+	_, err = rotated.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestIssueMode(t *testing.T) {
+	service, err := New(config.SharesConfig{TTL: "1h"}, "jwt-secret", nil, zap.NewNop())
+	require.NoError(t, err)
+
+	token, share, err := service.IssueMode("session-1", ModeReadWrite)
+	require.NoError(t, err)
+	assert.Equal(t, ModeReadWrite, share.Mode)
+	assert.Len(t, share.ID, 16)
+
+	verified, err := service.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, share, *verified)
+
+	_, _, err = service.IssueMode("session-1", "admin")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// A snapshot token cannot be turned into a live one
+	snapshot, _ := service.Issue("session-1")
+	payload, sig, _ := strings.Cut(snapshot, ".")
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	require.NoError(t, err)
+	forged := strings.Replace(string(raw), ":snapshot:", ":read-write:", 1)
+	_, err = service.Verify(base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + sig)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// Signed payloads of any other shape are refused
+	expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	issued := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	for _, payload := range []string{
+		"session-1:" + expires,
+		"session-1:" + expires + ":read-only",
+		"session-1:" + expires + ":admin:" + issued + ":nonce",
+		":" + expires + ":read-only:" + issued + ":nonce",
+		"session-1:" + expires + ":read-only:soon:nonce",
+		"session-1:" + expires + ":read-only:" + issued + ":nonce:extra",
+	} {
+		token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+			base64.RawURLEncoding.EncodeToString(service.sign(payload))
+		_, err := service.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalid, payload)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	service, err := New(config.SharesConfig{TTL: "1h"}, "jwt-secret", nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	first, share, err := service.IssueMode("session-1", ModeReadOnly)
+	require.NoError(t, err)
+	second, _, err := service.IssueMode("session-1", ModeReadOnly)
+	require.NoError(t, err)
+	other, _ := service.Issue("session-2")
+
+	// One link
+	require.NoError(t, service.Revoke(ctx, "session-1", share.ID))
+	_, err = service.Verify(first)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = service.Verify(second)
+	assert.NoError(t, err)
+
+	// Every link issued so far, but not later ones or other sessions'
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, service.RevokeSession(ctx, "session-1"))
+	_, err = service.Verify(second)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = service.Verify(other)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Millisecond)
+	later, _, err := service.IssueMode("session-1", ModeReadOnly)
+	require.NoError(t, err)
+	_, err = service.Verify(later)
+	assert.NoError(t, err)
+}
+
+func TestRevokeStored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "local.kv")
+	store, err := kv.Open(path)
+	require.NoError(t, err)
+	service, err := New(config.SharesConfig{TTL: "1h"}, "jwt-secret", nil, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, service.SetStore(store))
+	ctx := context.Background()
+
+	first, share, err := service.IssueMode("session-1", ModeReadOnly)
+	require.NoError(t, err)
+	other, _ := service.Issue("session-2")
+	require.NoError(t, service.Revoke(ctx, "session-1", share.ID))
+	require.NoError(t, service.RevokeSession(ctx, "session-2"))
+	require.NoError(t, store.Close())
+
+	// Revocations outlive a restart
+	store, err = kv.Open(path)
+	require.NoError(t, err)
+	defer store.Close()
+	restarted, err := New(config.SharesConfig{TTL: "1h"}, "jwt-secret", nil, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, restarted.SetStore(store))
+	_, err = restarted.Verify(first)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = restarted.Verify(other)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	time.Sleep(2 * time.Millisecond)
+	later, _ := restarted.Issue("session-2")
+	_, err = restarted.Verify(later)
+	assert.NoError(t, err)
+}
+
+func TestExpired(t *testing.T) {
+	service, err := New(config.SharesConfig{TTL: "1ns"}, "jwt-secret", nil, zap.NewNop())
+	require.NoError(t, err)
+
+	token, _ := service.Issue("session-1")
+	time.Sleep(1100 * time.Millisecond)
+	_, err = service.Verify(token)
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+func TestNewValidates(t *testing.T) {
+	_, err := New(config.SharesConfig{TTL: "soon"}, "jwt-secret", nil, zap.NewNop())
+	assert.Error(t, err)
+	_, err = New(config.SharesConfig{}, "", nil, zap.NewNop())
+	assert.Error(t, err)
+}
@@ -0,0 +1,313 @@
+package shed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/services/notify"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	ActionCreate = "session.create"
+	ActionAttach = "session.attach"
+)
+
+// Level is how much work is being refused
+type Level int
+
+const (
+	LevelNormal   Level = iota
+	LevelSessions       // new sessions refused
+	LevelAttach         // new sessions and attaches refused
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelSessions:
+		return "sessions"
+	case LevelAttach:
+		return "attach"
+	default:
+		return "normal"
+	}
+}
+
+// recovery is how far pressure must fall below a threshold before the
+// level drops, so the server does not flap around the limit
+const recovery = 0.05
+
+// Sample is one reading of the process
+type Sample struct {
+	RSS        int64
+	Goroutines int
+}
+
+// Stats is the current state, exposed to admins and metrics
+type Stats struct {
+	Level      string           `json:"level"`
+	Pressure   float64          `json:"pressure"`
+	RSSBytes   int64            `json:"rss_bytes"`
+	Goroutines int              `json:"goroutines"`
+	Since      time.Time        `json:"since"`
+	Rejected   map[string]int64 `json:"rejected"`
+}
+
+// Service watches memory and goroutine counts and sheds load in two steps
+// before the process is OOM-killed along with every session. New sessions
+// cost the most and go first; attaches to existing sessions are refused
+// only at the hard limit. Nothing already running is disconnected.
+type Service struct {
+	maxRSS        int64
+	maxGoroutines int
+	softRatio     float64
+	interval      time.Duration
+	retryAfter    time.Duration
+	notifier      *notify.Service
+	logger        *zap.Logger
+
+	// sample reads the process; replaced in tests
+	sample func() Sample
+
+	mu       sync.RWMutex
+	level    Level
+	since    time.Time
+	last     Sample
+	pressure float64
+	rejected map[string]int64
+}
+
+func New(cfg config.LoadShedConfig, notifier *notify.Service, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		maxRSS:        int64(cfg.MaxRSSMB) << 20,
+		maxGoroutines: cfg.MaxGoroutines,
+		softRatio:     cfg.SoftRatio,
+		interval:      5 * time.Second,
+		retryAfter:    30 * time.Second,
+		notifier:      notifier,
+		logger:        logger,
+		sample:        readSample,
+		since:         time.Now(),
+		rejected:      map[string]int64{ActionCreate: 0, ActionAttach: 0},
+	}
+	if s.softRatio <= 0 || s.softRatio > 1 {
+		s.softRatio = 0.85
+	}
+
+	var err error
+	if cfg.CheckInterval != "" {
+		if s.interval, err = time.ParseDuration(cfg.CheckInterval); err != nil || s.interval <= 0 {
+			return nil, fmt.Errorf("invalid load_shed check_interval %q", cfg.CheckInterval)
+		}
+	}
+	if cfg.RetryAfter != "" {
+		if s.retryAfter, err = time.ParseDuration(cfg.RetryAfter); err != nil || s.retryAfter <= 0 {
+			return nil, fmt.Errorf("invalid load_shed retry_after %q", cfg.RetryAfter)
+		}
+	}
+	return s, nil
+}
+
+// Enabled reports whether any limit is configured
+func (s *Service) Enabled() bool {
+	return s.maxRSS > 0 || s.maxGoroutines > 0
+}
+
+// Run samples the process on the check interval until ctx is cancelled
+func (s *Service) Run(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Check()
+		}
+	}
+}
+
+// Check takes a sample and moves between levels
+func (s *Service) Check() Level {
+	sample := s.sample()
+	pressure := s.pressureOf(sample)
+
+	s.mu.Lock()
+	prev := s.level
+	next := s.levelFor(prev, pressure)
+	s.last = sample
+	s.pressure = pressure
+	if next != prev {
+		s.level = next
+		s.since = time.Now()
+	}
+	s.mu.Unlock()
+
+	if next != prev {
+		s.changed(prev, next, sample, pressure)
+	}
+	return next
+}
+
+// pressureOf is the larger of the two usage ratios
+func (s *Service) pressureOf(sample Sample) float64 {
+	var pressure float64
+	if s.maxRSS > 0 {
+		pressure = float64(sample.RSS) / float64(s.maxRSS)
+	}
+	if s.maxGoroutines > 0 {
+		if g := float64(sample.Goroutines) / float64(s.maxGoroutines); g > pressure {
+			pressure = g
+		}
+	}
+	return pressure
+}
+
+// levelFor raises the level as soon as a threshold is crossed and lowers it
+// only once pressure is clearly back under
+func (s *Service) levelFor(current Level, pressure float64) Level {
+	switch {
+	case pressure >= 1:
+		return LevelAttach
+	case current == LevelAttach && pressure >= 1-recovery:
+		return LevelAttach
+	case pressure >= s.softRatio:
+		return LevelSessions
+	case current >= LevelSessions && pressure >= s.softRatio-recovery:
+		return LevelSessions
+	default:
+		return LevelNormal
+	}
+}
+
+func (s *Service) changed(prev, next Level, sample Sample, pressure float64) {
+	fields := []zap.Field{
+		zap.String("from", prev.String()),
+		zap.String("to", next.String()),
+		zap.Float64("pressure", pressure),
+		zap.Int64("rss_bytes", sample.RSS),
+		zap.Int("goroutines", sample.Goroutines),
+	}
+	if next < prev {
+		s.logger.Info("Load shedding eased", fields...)
+	} else {
+		s.logger.Warn("Shedding load under memory pressure", fields...)
+	}
+
+	title := "Server recovered from memory pressure"
+	text := "New sessions and attaches are accepted again"
+	switch next {
+	case LevelSessions:
+		title = "Server under memory pressure"
+		text = "New sessions are refused until usage drops"
+	case LevelAttach:
+		title = "Server at its memory limit"
+		text = "New sessions and attaches are refused until usage drops"
+	}
+	s.notifier.Notify(notify.Event{
+		Type:  notify.EventLoadShedding,
+		Title: title,
+		Text:  text,
+		Fields: map[string]string{
+			"level":      next.String(),
+			"pressure":   strconv.FormatFloat(pressure, 'f', 2, 64),
+			"rss_mb":     strconv.FormatInt(sample.RSS>>20, 10),
+			"goroutines": strconv.Itoa(sample.Goroutines),
+		},
+	})
+}
+
+// Admit reports whether an action may go ahead and, if not, when to retry
+func (s *Service) Admit(action string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	refused := (action == ActionCreate && s.level >= LevelSessions) ||
+		(action == ActionAttach && s.level >= LevelAttach)
+	if !refused {
+		return 0, true
+	}
+	s.rejected[action]++
+	return s.retryAfter, false
+}
+
+// Level returns the current shedding level
+func (s *Service) Level() Level {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.level
+}
+
+func (s *Service) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rejected := make(map[string]int64, len(s.rejected))
+	for action, n := range s.rejected {
+		rejected[action] = n
+	}
+	return Stats{
+		Level:      s.level.String(),
+		Pressure:   s.pressure,
+		RSSBytes:   s.last.RSS,
+		Goroutines: s.last.Goroutines,
+		Since:      s.since,
+		Rejected:   rejected,
+	}
+}
+
+// WriteMetrics renders the shedding state in the Prometheus text format
+func (s *Service) WriteMetrics(w io.Writer) error {
+	stats := s.Stats()
+	_, err := fmt.Fprintf(w, `# HELP webtunnel_load_shed_level Load shedding level: 0 normal, 1 new sessions refused, 2 attaches refused.
+# TYPE webtunnel_load_shed_level gauge
+webtunnel_load_shed_level %d
+# HELP webtunnel_load_shed_pressure Largest ratio of usage to its configured limit.
+# TYPE webtunnel_load_shed_pressure gauge
+webtunnel_load_shed_pressure %g
+# HELP webtunnel_process_rss_bytes Resident set size at the last check.
+# TYPE webtunnel_process_rss_bytes gauge
+webtunnel_process_rss_bytes %d
+# HELP webtunnel_process_goroutines Goroutines at the last check.
+# TYPE webtunnel_process_goroutines gauge
+webtunnel_process_goroutines %d
+# HELP webtunnel_load_shed_rejected_total Requests refused while shedding load, by action.
+# TYPE webtunnel_load_shed_rejected_total counter
+webtunnel_load_shed_rejected_total{action="session.create"} %d
+webtunnel_load_shed_rejected_total{action="session.attach"} %d
+`, s.Level(), stats.Pressure, stats.RSSBytes, stats.Goroutines,
+		stats.Rejected[ActionCreate], stats.Rejected[ActionAttach])
+	return err
+}
+
+// readSample uses /proc where available and falls back to the memory the
+// Go runtime has obtained from the OS, which overstates RSS but tracks it
+func readSample() Sample {
+	sample := Sample{Goroutines: runtime.NumGoroutine()}
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := bytes.Fields(data); len(fields) > 1 {
+			if pages, err := strconv.ParseInt(string(fields[1]), 10, 64); err == nil {
+				sample.RSS = pages * int64(os.Getpagesize())
+				return sample
+			}
+		}
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	sample.RSS = int64(mem.Sys - mem.HeapReleased)
+	return sample
+}
@@ -0,0 +1,84 @@
+package shed
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/notify"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+func newService(t *testing.T, sample *Sample) *Service {
+	service, err := New(config.LoadShedConfig{
+		MaxRSSMB:      100,
+		MaxGoroutines: 1000,
+		SoftRatio:     0.8,
+		RetryAfter:    "10s",
+	}, notify.New(config.NotifyConfig{}, zap.NewNop()), zap.NewNop())
+	require.NoError(t, err)
+	service.sample = func() Sample { return *sample }
+	return service
+}
+
+func TestLevels(t *testing.T) {
+	sample := &Sample{RSS: 50 << 20, Goroutines: 100}
+	service := newService(t, sample)
+
+	assert.Equal(t, LevelNormal, service.Check())
+	_, ok := service.Admit(ActionCreate)
+	assert.True(t, ok)
+
+	// Goroutines alone can raise the level
+	sample.Goroutines = 850
+	assert.Equal(t, LevelSessions, service.Check())
+	retryAfter, ok := service.Admit(ActionCreate)
+	assert.False(t, ok)
+	assert.Equal(t, "10s", retryAfter.String())
+	_, ok = service.Admit(ActionAttach)
+	assert.True(t, ok, "attaches still allowed")
+
+	sample.RSS = 101 << 20
+	assert.Equal(t, LevelAttach, service.Check())
+	_, ok = service.Admit(ActionAttach)
+	assert.False(t, ok)
+
+	// Hysteresis: just under the limit stays put
+	sample.RSS = 97 << 20
+	assert.Equal(t, LevelAttach, service.Check())
+	sample.RSS = 90 << 20
+	assert.Equal(t, LevelSessions, service.Check())
+	sample.RSS, sample.Goroutines = 77<<20, 100
+	assert.Equal(t, LevelSessions, service.Check())
+	sample.RSS = 60 << 20
+	assert.Equal(t, LevelNormal, service.Check())
+
+	stats := service.Stats()
+	assert.Equal(t, "normal", stats.Level)
+	assert.Equal(t, int64(1), stats.Rejected[ActionCreate])
+	assert.Equal(t, int64(1), stats.Rejected[ActionAttach])
+
+	var buf bytes.Buffer
+	require.NoError(t, service.WriteMetrics(&buf))
+	assert.Contains(t, buf.String(), `webtunnel_load_shed_rejected_total{action="session.attach"} 1`)
+	assert.Contains(t, buf.String(), "webtunnel_load_shed_level 0")
+}
+
+func TestDisabled(t *testing.T) {
+	service, err := New(config.LoadShedConfig{}, notify.New(config.NotifyConfig{}, zap.NewNop()), zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, service.Enabled())
+	_, ok := service.Admit(ActionCreate)
+	assert.True(t, ok)
+
+	_, err = New(config.LoadShedConfig{CheckInterval: "often"}, nil, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestReadSample(t *testing.T) {
+	sample := readSample()
+	assert.Greater(t, sample.RSS, int64(0))
+	assert.Greater(t, sample.Goroutines, 0)
+}
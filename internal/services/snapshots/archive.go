@@ -0,0 +1,196 @@
+package snapshots
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errTooLarge = errors.New("snapshot unpacks to more than the size limit")
+
+// extract unpacks a gzipped tarball into dest, which must exist. Entries
+// that would land outside dest, including through symlinks, are rejected
+// rather than skipped so a bad archive is never half trusted.
+func extract(r io.Reader, dest string, limit int64) error {
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("not a gzipped tarball: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	var written int64
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tarball: %w", err)
+		}
+
+		name, err := entryPath(header.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		target := filepath.Join(root, name)
+		mode := fs.FileMode(header.Mode).Perm()
+
+		// Lexical checks miss symlink chains such as a/b -> .. followed by
+		// c -> a/b/.., so also resolve what is on disk so far
+		if err := resolveInside(root, filepath.Dir(target)); err != nil {
+			return fmt.Errorf("entry %s is outside the snapshot", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if written += header.Size; written > limit {
+				return errTooLarge
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, mode|0600); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if !insideRoot(name, header.Linkname) {
+				return fmt.Errorf("symlink %s points outside the snapshot", header.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			linkName, err := entryPath(header.Linkname)
+			if err != nil || linkName == "" || resolveInside(root, filepath.Join(root, linkName)) != nil {
+				return fmt.Errorf("hard link %s points outside the snapshot", header.Name)
+			}
+			if err := os.Link(filepath.Join(root, linkName), target); err != nil {
+				return err
+			}
+
+		default:
+			// Devices, FIFOs and the like have no place in a workspace
+		}
+	}
+}
+
+// entryPath cleans an archive path, rejecting absolute paths and any that
+// climb out of the archive root
+func entryPath(name string) (string, error) {
+	clean := filepath.Clean(strings.TrimPrefix(name, "./"))
+	if clean == "." {
+		return "", nil
+	}
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("entry %s is outside the snapshot", name)
+	}
+	return clean, nil
+}
+
+// insideRoot reports whether a symlink at name pointing to target resolves
+// within the archive root
+func insideRoot(name, target string) bool {
+	if filepath.IsAbs(target) {
+		return false
+	}
+	resolved := filepath.Clean(filepath.Join(filepath.Dir(name), target))
+	return resolved != ".." && !strings.HasPrefix(resolved, "../")
+}
+
+// resolveInside fails if path, or the longest part of it that exists,
+// resolves outside root
+func resolveInside(root, path string) error {
+	for p := path; ; p = filepath.Dir(p) {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+				return fmt.Errorf("%s resolves outside %s", path, root)
+			}
+			return nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) || p == root || p == filepath.Dir(p) {
+			return err
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, mode fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyTree copies an unpacked snapshot into a session directory, keeping
+// permissions and symlinks
+func copyTree(ctx context.Context, src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			in, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			return writeFile(target, in, info.Mode().Perm())
+		}
+		return nil
+	})
+}
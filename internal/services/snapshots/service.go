@@ -0,0 +1,381 @@
+package snapshots
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrDisabled    = errors.New("snapshots are not enabled")
+	ErrNotFound    = errors.New("snapshot not found")
+	ErrInvalidName = errors.New("snapshot names are lowercase letters, digits, '.', '_' and '-'")
+	ErrTooLarge    = errors.New("snapshot archive exceeds the size limit")
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// Snapshot is one version of an environment. Versions are immutable; a new
+// upload under the same name becomes the next version.
+type Snapshot struct {
+	Name        string    `json:"name"`
+	Version     int       `json:"version"`
+	Description string    `json:"description,omitempty"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	CreatedBy   string    `json:"created_by"`
+}
+
+// Ref is how sessions ask for the snapshot: name for the latest version,
+// or name@version to pin one
+func (s *Snapshot) Ref() string {
+	return s.Name + "@" + strconv.Itoa(s.Version)
+}
+
+// Service keeps environment snapshots on disk. Each version is stored as
+// the uploaded tarball plus an unpacked copy that sessions are provisioned
+// from, so creating a session costs a file copy rather than a decompress.
+//
+//	<dir>/<name>/<version>.tar.gz
+//	<dir>/<name>/<version>.json
+//	<dir>/<name>/<version>/
+type Service struct {
+	dir         string
+	maxSize     int64
+	maxUnpacked int64
+	logger      *zap.Logger
+
+	// mu serializes version assignment, deletion and cache rebuilds
+	mu sync.Mutex
+}
+
+func New(cfg config.SnapshotsConfig, logger *zap.Logger) (*Service, error) {
+	s := &Service{
+		dir:         cfg.Dir,
+		maxSize:     int64(cfg.MaxSizeMB) << 20,
+		maxUnpacked: int64(cfg.MaxUnpackedMB) << 20,
+		logger:      logger,
+	}
+	if s.dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return s, nil
+}
+
+// Enabled reports whether a snapshot directory is configured
+func (s *Service) Enabled() bool {
+	return s.dir != ""
+}
+
+// Create stores a gzipped tarball as the next version of name. The archive
+// is unpacked before it is accepted, which both validates it and warms the
+// cache for the first session.
+func (s *Service) Create(ctx context.Context, name, description, by string, r io.Reader) (*Snapshot, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if !validName.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+
+	nameDir := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(nameDir, 0755); err != nil {
+		return nil, err
+	}
+
+	archive, err := os.CreateTemp(nameDir, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(archive.Name())
+
+	hash := sha256.New()
+	limited := &io.LimitedReader{R: r, N: s.maxSize + 1}
+	size, err := io.Copy(io.MultiWriter(archive, hash), contextReader{ctx, limited})
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size > s.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	unpacked, err := os.MkdirTemp(nameDir, ".unpack-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(unpacked)
+	if err := s.unpack(archive.Name(), unpacked); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, err := s.versions(name)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{
+		Name:        name,
+		Version:     1,
+		Description: description,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt:   time.Now().UTC(),
+		CreatedBy:   by,
+	}
+	if len(versions) > 0 {
+		snapshot.Version = versions[len(versions)-1].Version + 1
+	}
+
+	base := filepath.Join(nameDir, strconv.Itoa(snapshot.Version))
+	if err := os.Rename(archive.Name(), base+".tar.gz"); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(unpacked, base); err != nil {
+		os.Remove(base + ".tar.gz")
+		return nil, err
+	}
+	// The metadata file is written last; until it exists the version is
+	// invisible
+	data, _ := json.MarshalIndent(snapshot, "", "  ")
+	if err := os.WriteFile(base+".json", data, 0644); err != nil {
+		os.Remove(base + ".tar.gz")
+		os.RemoveAll(base)
+		return nil, err
+	}
+
+	s.logger.Info("Snapshot created",
+		zap.String("snapshot", snapshot.Ref()),
+		zap.Int64("size", size),
+		zap.String("by", by))
+	return snapshot, nil
+}
+
+func (s *Service) unpack(archive, dest string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := extract(f, dest, s.maxUnpacked); err != nil {
+		if errors.Is(err, errTooLarge) {
+			return ErrTooLarge
+		}
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return nil
+}
+
+// List returns the latest version of every snapshot, by name
+func (s *Service) List() ([]*Snapshot, error) {
+	if !s.Enabled() {
+		return []*Snapshot{}, nil
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	latest := []*Snapshot{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !validName.MatchString(entry.Name()) {
+			continue
+		}
+		versions, err := s.versions(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) > 0 {
+			latest = append(latest, versions[len(versions)-1])
+		}
+	}
+	return latest, nil
+}
+
+// Versions returns every version of name, oldest first
+func (s *Service) Versions(name string) ([]*Snapshot, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if !validName.MatchString(name) {
+		return nil, ErrNotFound
+	}
+	versions, err := s.versions(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, nil
+}
+
+func (s *Service) versions(name string) ([]*Snapshot, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, name, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]*Snapshot, 0, len(matches))
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			s.logger.Warn("Skipping unreadable snapshot metadata", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		versions = append(versions, &snapshot)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// Resolve looks up "name" (latest) or "name@version"
+func (s *Service) Resolve(ref string) (*Snapshot, error) {
+	name, version, pinned := strings.Cut(ref, "@")
+	versions, err := s.Versions(name)
+	if err != nil {
+		return nil, err
+	}
+	if !pinned {
+		return versions[len(versions)-1], nil
+	}
+	n, err := strconv.Atoi(version)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	for _, snapshot := range versions {
+		if snapshot.Version == n {
+			return snapshot, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Delete removes one version. Sessions provisioned from it keep their copy.
+func (s *Service) Delete(name string, version int) error {
+	if !s.Enabled() {
+		return ErrDisabled
+	}
+	if !validName.MatchString(name) {
+		return ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base := filepath.Join(s.dir, name, strconv.Itoa(version))
+	if err := os.Remove(base + ".json"); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	os.Remove(base + ".tar.gz")
+	os.RemoveAll(base)
+	os.Remove(filepath.Join(s.dir, name)) // only succeeds once empty
+
+	s.logger.Info("Snapshot deleted", zap.String("snapshot", name+"@"+strconv.Itoa(version)))
+	return nil
+}
+
+// Provision copies a snapshot into a new session directory and returns
+// environment for the session: the snapshot ref, and PATH with the
+// snapshot's bin directory first when it has one.
+func (s *Service) Provision(ctx context.Context, userID, ref, dir string) ([]string, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	snapshot, err := s.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := s.cached(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if err := copyTree(ctx, cache, dir); err != nil {
+		return nil, fmt.Errorf("failed to copy snapshot: %w", err)
+	}
+
+	s.logger.Info("Provisioned session from snapshot",
+		zap.String("snapshot", snapshot.Ref()),
+		zap.String("user_id", userID),
+		zap.Duration("took", time.Since(start)))
+
+	env := []string{"WEBTUNNEL_SNAPSHOT=" + snapshot.Ref()}
+	if info, err := os.Stat(filepath.Join(dir, "bin")); err == nil && info.IsDir() {
+		env = append(env, "PATH="+filepath.Join(dir, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+	return env, nil
+}
+
+// cached returns the unpacked copy of a snapshot, unpacking the stored
+// tarball again if the copy has been removed
+func (s *Service) cached(snapshot *Snapshot) (string, error) {
+	base := filepath.Join(s.dir, snapshot.Name, strconv.Itoa(snapshot.Version))
+	if info, err := os.Stat(base); err == nil && info.IsDir() {
+		return base, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info, err := os.Stat(base); err == nil && info.IsDir() {
+		return base, nil
+	}
+
+	unpacked, err := os.MkdirTemp(filepath.Dir(base), ".unpack-*")
+	if err != nil {
+		return "", err
+	}
+	if err := s.unpack(base+".tar.gz", unpacked); err != nil {
+		os.RemoveAll(unpacked)
+		return "", err
+	}
+	if err := os.Rename(unpacked, base); err != nil {
+		os.RemoveAll(unpacked)
+		return "", err
+	}
+	s.logger.Info("Rebuilt snapshot cache", zap.String("snapshot", snapshot.Ref()))
+	return base, nil
+}
+
+// contextReader stops an upload once the request is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
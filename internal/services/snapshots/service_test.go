@@ -0,0 +1,204 @@
+package snapshots
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+type entry struct {
+	name     string
+	typ      byte
+	body     string
+	linkname string
+}
+
+func archive(t *testing.T, entries ...entry) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: 0644, Linkname: e.linkname}
+		switch e.typ {
+		case tar.TypeDir:
+			header.Mode = 0755
+		case tar.TypeReg:
+			header.Size = int64(len(e.body))
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		if e.typ == tar.TypeReg {
+			_, err := tw.Write([]byte(e.body))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return &buf
+}
+
+func newService(t *testing.T) *Service {
+	service, err := New(config.SnapshotsConfig{
+		Dir:           t.TempDir(),
+		MaxSizeMB:     1,
+		MaxUnpackedMB: 1,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	return service
+}
+
+func TestCreateAndProvision(t *testing.T) {
+	service := newService(t)
+	ctx := context.Background()
+
+	first, err := service.Create(ctx, "python", "", "admin", archive(t,
+		entry{name: "README", typ: tar.TypeReg, body: "v1"},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, 1, first.Version)
+
+	second, err := service.Create(ctx, "python", "with tools", "admin", archive(t,
+		entry{name: "bin/", typ: tar.TypeDir},
+		entry{name: "bin/tool", typ: tar.TypeReg, body: "#!/bin/sh"},
+		entry{name: "README", typ: tar.TypeReg, body: "v2"},
+		entry{name: "docs", typ: tar.TypeSymlink, linkname: "README"},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, 2, second.Version)
+	assert.Len(t, second.SHA256, 64)
+
+	list, err := service.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "python@2", list[0].Ref())
+
+	dir := t.TempDir()
+	env, err := service.Provision(ctx, "alice", "python", dir)
+	require.NoError(t, err)
+	assert.Contains(t, env, "WEBTUNNEL_SNAPSHOT=python@2")
+	assert.Len(t, env, 2, "PATH includes the snapshot bin")
+	data, err := os.ReadFile(filepath.Join(dir, "docs"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(data))
+
+	// A pinned version still provisions after its cache is dropped
+	require.NoError(t, os.RemoveAll(filepath.Join(service.dir, "python", "1")))
+	dir = t.TempDir()
+	env, err = service.Provision(ctx, "alice", "python@1", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"WEBTUNNEL_SNAPSHOT=python@1"}, env)
+	data, err = os.ReadFile(filepath.Join(dir, "README"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+
+	require.NoError(t, service.Delete("python", 2))
+	latest, err := service.Resolve("python")
+	require.NoError(t, err)
+	assert.Equal(t, 1, latest.Version)
+
+	_, err = service.Resolve("python@2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, service.Delete("python", 2), ErrNotFound)
+}
+
+func TestCreateRejectsEscapes(t *testing.T) {
+	service := newService(t)
+	ctx := context.Background()
+
+	cases := map[string]*bytes.Buffer{
+		"parent":   archive(t, entry{name: "../evil", typ: tar.TypeReg, body: "x"}),
+		"absolute": archive(t, entry{name: "/tmp/evil", typ: tar.TypeReg, body: "x"}),
+		"symlink":  archive(t, entry{name: "out", typ: tar.TypeSymlink, linkname: "../../"}),
+		"through symlink": archive(t,
+			entry{name: "a/", typ: tar.TypeDir},
+			entry{name: "a/up", typ: tar.TypeSymlink, linkname: ".."},
+			entry{name: "b", typ: tar.TypeSymlink, linkname: "a/up/.."},
+			entry{name: "b/evil", typ: tar.TypeReg, body: "x"},
+		),
+		"hard link": archive(t, entry{name: "passwd", typ: tar.TypeLink, linkname: "../../etc/passwd"}),
+		"not gzip":  bytes.NewBufferString("plain text"),
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := service.Create(ctx, "bad", "", "admin", body)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := service.Resolve("bad")
+	assert.ErrorIs(t, err, ErrNotFound, "no version was stored")
+
+	_, err = service.Create(ctx, "../bad", "", "admin", archive(t))
+	assert.ErrorIs(t, err, ErrInvalidName)
+}
+
+func TestCreateSizeLimits(t *testing.T) {
+	service := newService(t)
+	ctx := context.Background()
+
+	_, err := service.Create(ctx, "big", "", "admin", bytes.NewReader(make([]byte, 2<<20)))
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// Compresses well under the archive limit but unpacks past it
+	_, err = service.Create(ctx, "bomb", "", "admin", archive(t,
+		entry{name: "zeros", typ: tar.TypeReg, body: string(make([]byte, 2<<20))},
+	))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestDisabled(t *testing.T) {
+	service, err := New(config.SnapshotsConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, service.Enabled())
+
+	list, err := service.List()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	_, err = service.Provision(context.Background(), "alice", "python", t.TempDir())
+	assert.ErrorIs(t, err, ErrDisabled)
+}
+
+// FuzzEntryPath checks that no archive entry or symlink the extractor
+// accepts lands outside the snapshot root
+func FuzzEntryPath(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"bin/tool", "../lib/tool"},
+		{"./a/b", "c"},
+		{"../escape", "x"},
+		{"a/../../b", "../../etc/passwd"},
+		{"/etc/passwd", "/etc/shadow"},
+		{"a/./b/../c", "./../.."},
+		{"..", ""},
+		{"a\\..\\..", "b/../../c"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+
+	const root = "/srv/snapshot"
+	inside := func(path string) bool {
+		return path == root || strings.HasPrefix(path, root+"/")
+	}
+	f.Fuzz(func(t *testing.T, name, target string) {
+		clean, err := entryPath(name)
+		if err != nil {
+			return
+		}
+		assert.False(t, filepath.IsAbs(clean), "%q", name)
+		assert.True(t, inside(filepath.Join(root, clean)), "%q extracts to %q", name, clean)
+
+		if clean != "" && insideRoot(clean, target) {
+			link := filepath.Join(root, filepath.Dir(clean), target)
+			assert.True(t, inside(link), "%q -> %q resolves to %q", clean, target, link)
+		}
+	})
+}
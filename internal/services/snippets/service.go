@@ -0,0 +1,184 @@
+package snippets
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"go.uber.org/zap"
+)
+
+const (
+	OwnerUser = "user"
+	OwnerOrg  = "org"
+)
+
+// UserLookup resolves a user's org so org-level snippets can be found
+type UserLookup interface {
+	GetUserByID(userID string) (*auth.User, error)
+}
+
+type Service struct {
+	db     *database.DB
+	users  UserLookup
+	logger *zap.Logger
+}
+
+type Snippet struct {
+	ID        string    `json:"id"`
+	OwnerType string    `json:"owner_type"`
+	OwnerID   string    `json:"owner_id"`
+	Name      string    `json:"name"`
+	Content   string    `json:"content"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func New(db *database.DB, users UserLookup, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		users:  users,
+		logger: logger,
+	}
+}
+
+// List returns the user's own snippets followed by their org's library.
+func (s *Service) List(ctx context.Context, userID string) ([]*Snippet, error) {
+	orgID := s.orgOf(userID)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT uuid, owner_type, owner_id, name, content, created_by, created_at, updated_at
+		FROM snippets
+		WHERE (owner_type = 'user' AND owner_id = $1)
+		   OR (owner_type = 'org' AND owner_id = $2 AND $2 <> '')
+		ORDER BY owner_type DESC, name`, userID, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snippets: %w", err)
+	}
+	defer rows.Close()
+
+	var result []*Snippet
+	for rows.Next() {
+		snippet := &Snippet{}
+		if err := rows.Scan(
+			&snippet.ID,
+			&snippet.OwnerType,
+			&snippet.OwnerID,
+			&snippet.Name,
+			&snippet.Content,
+			&snippet.CreatedBy,
+			&snippet.CreatedAt,
+			&snippet.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan snippet: %w", err)
+		}
+		result = append(result, snippet)
+	}
+	return result, rows.Err()
+}
+
+// Save creates or replaces a snippet by name. Shared snippets go into the
+// user's org library.
+func (s *Service) Save(ctx context.Context, userID, name, content string, shared bool) (*Snippet, error) {
+	if name == "" || content == "" {
+		return nil, fmt.Errorf("snippet name and content are required")
+	}
+
+	snippet := &Snippet{
+		ID:        generateID(),
+		OwnerType: OwnerUser,
+		OwnerID:   userID,
+		Name:      name,
+		Content:   content,
+		CreatedBy: userID,
+	}
+	if shared {
+		orgID := s.orgOf(userID)
+		if orgID == "" {
+			return nil, fmt.Errorf("user does not belong to an org")
+		}
+		snippet.OwnerType = OwnerOrg
+		snippet.OwnerID = orgID
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO snippets (uuid, owner_type, owner_id, name, content, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (owner_type, owner_id, name) DO UPDATE SET
+			content = EXCLUDED.content,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING uuid, created_by, created_at, updated_at`,
+		snippet.ID, snippet.OwnerType, snippet.OwnerID, snippet.Name, snippet.Content, snippet.CreatedBy,
+	).Scan(&snippet.ID, &snippet.CreatedBy, &snippet.CreatedAt, &snippet.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save snippet: %w", err)
+	}
+
+	s.logger.Info("Snippet saved",
+		zap.String("snippet", name),
+		zap.String("owner_type", snippet.OwnerType),
+		zap.String("user_id", userID))
+	return snippet, nil
+}
+
+// Delete removes a snippet the user owns or one shared with their org.
+func (s *Service) Delete(ctx context.Context, userID, snippetID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM snippets
+		WHERE uuid = $1
+		  AND ((owner_type = 'user' AND owner_id = $2)
+		    OR (owner_type = 'org' AND owner_id = $3 AND $3 <> ''))`,
+		snippetID, userID, s.orgOf(userID))
+	if err != nil {
+		return fmt.Errorf("failed to delete snippet: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("snippet not found: %s", snippetID)
+	}
+	return nil
+}
+
+// ResolveSnippet returns the content of the named snippet visible to the
+// user, preferring their own over the org library.
+func (s *Service) ResolveSnippet(ctx context.Context, userID, name string) (string, error) {
+	var content string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT content FROM snippets
+		WHERE name = $1
+		  AND ((owner_type = 'user' AND owner_id = $2)
+		    OR (owner_type = 'org' AND owner_id = $3 AND $3 <> ''))
+		ORDER BY owner_type DESC
+		LIMIT 1`, name, userID, s.orgOf(userID)).Scan(&content)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("snippet not found: %s", name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load snippet: %w", err)
+	}
+	return content, nil
+}
+
+func (s *Service) orgOf(userID string) string {
+	user, err := s.users.GetUserByID(userID)
+	if err != nil {
+		return ""
+	}
+	return user.OrgID
+}
+
+func generateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s",
+		hex.EncodeToString(b[0:4]),
+		hex.EncodeToString(b[4:6]),
+		hex.EncodeToString(b[6:8]),
+		hex.EncodeToString(b[8:10]),
+		hex.EncodeToString(b[10:16]))
+}
@@ -0,0 +1,340 @@
+package sshkeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	ErrInvalidTarget   = errors.New("invalid ssh target")
+	ErrHostKeyMismatch = errors.New("host key does not match the known key for this host")
+	ErrUnknownHost     = errors.New("host key is not pinned and trust on first use is disabled")
+)
+
+var (
+	hostPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.:-]*$`)
+	userPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+)
+
+// errHostKeySeen ends a handshake once the host key has been read
+var errHostKeySeen = errors.New("host key seen")
+
+// HostKey is the key a host must present. Keys seen on first connect are
+// trusted until an admin removes them; pinned keys are set by an admin.
+type HostKey struct {
+	Host        string    `json:"host"` // known_hosts form: host, or [host]:port
+	Type        string    `json:"type"`
+	PublicKey   string    `json:"public_key"`
+	Fingerprint string    `json:"fingerprint"`
+	Pinned      bool      `json:"pinned"`
+	PinnedBy    string    `json:"pinned_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// HostAddress normalizes a host and port to the known_hosts form
+func HostAddress(host string, port int) string {
+	if port == 0 {
+		port = 22
+	}
+	return knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// KnownHosts lists every known host key, pinned or trusted on first use
+func (s *Service) KnownHosts(ctx context.Context) ([]*HostKey, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result := []*HostKey{}
+		for _, host := range s.hosts {
+			result = append(result, host)
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Host < result[j].Host })
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT host, key_type, public_key, fingerprint, pinned, COALESCE(pinned_by, ''), created_at
+		FROM ssh_known_hosts ORDER BY host`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query known hosts: %w", err)
+	}
+	defer rows.Close()
+
+	result := []*HostKey{}
+	for rows.Next() {
+		host := &HostKey{}
+		if err := rows.Scan(&host.Host, &host.Type, &host.PublicKey, &host.Fingerprint, &host.Pinned, &host.PinnedBy, &host.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan known host: %w", err)
+		}
+		result = append(result, host)
+	}
+	return result, rows.Err()
+}
+
+// Pin sets the key a host must present, replacing any key trusted on first
+// use. host is a host name or address with an optional :port.
+func (s *Service) Pin(ctx context.Context, adminID, host, publicKey string) (*HostKey, error) {
+	name, port, err := splitHost(host)
+	if err != nil {
+		return nil, err
+	}
+	public, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	entry := newHostKey(HostAddress(name, port), public)
+	entry.Pinned, entry.PinnedBy = true, adminID
+	if err := s.putHost(ctx, entry); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Pinned SSH host key",
+		zap.String("host", entry.Host),
+		zap.String("fingerprint", entry.Fingerprint),
+		zap.String("admin_id", adminID))
+	return entry, nil
+}
+
+// Forget removes a host's key, so the next connection trusts whatever key
+// the host presents (if trust on first use is enabled)
+func (s *Service) Forget(ctx context.Context, host string) error {
+	name, port, err := splitHost(host)
+	if err != nil {
+		return err
+	}
+	addr := HostAddress(name, port)
+
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.hosts[addr]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, addr)
+		}
+		delete(s.hosts, addr)
+		return nil
+	}
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM ssh_known_hosts WHERE host = $1", addr)
+	if err != nil {
+		return fmt.Errorf("failed to delete known host: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, addr)
+	}
+	return nil
+}
+
+// ConnectSSH verifies the target's host key, trusting it on first use,
+// and writes the selected private key and a one-line known_hosts into the
+// session directory. It returns the ssh command line the session runs,
+// which refuses any other host key.
+func (s *Service) ConnectSSH(ctx context.Context, userID string, target terminal.SSHTarget, dir string) (string, error) {
+	if !hostPattern.MatchString(target.Host) || !userPattern.MatchString(target.User) || target.Port < 0 || target.Port > 65535 {
+		return "", ErrInvalidTarget
+	}
+	port := target.Port
+	if port == 0 {
+		port = 22
+	}
+
+	key, err := s.Get(ctx, userID, target.Key)
+	if err != nil {
+		return "", err
+	}
+	if !key.HasPrivate {
+		return "", fmt.Errorf("%w: %s", ErrNoPrivateKey, key.Name)
+	}
+	private, err := s.open(key.sealed)
+	if err != nil {
+		return "", err
+	}
+
+	hostKey, err := s.verifyHost(ctx, target.Host, port)
+	if err != nil {
+		return "", err
+	}
+
+	sshDir := filepath.Join(dir, ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create ssh directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(sshDir, "id_webtunnel"), private, 0600); err != nil {
+		return "", fmt.Errorf("failed to write ssh key: %w", err)
+	}
+	line := knownhosts.Line([]string{hostKey.Host}, hostKey.public())
+	if err := os.WriteFile(filepath.Join(sshDir, "known_hosts"), []byte(line+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write known_hosts: %w", err)
+	}
+
+	// Paths are relative to the session directory the command runs in
+	return fmt.Sprintf("ssh -i .ssh/id_webtunnel -o IdentitiesOnly=yes"+
+		" -o UserKnownHostsFile=.ssh/known_hosts -o GlobalKnownHostsFile=/dev/null"+
+		" -o StrictHostKeyChecking=yes -p %d %s@%s", port, target.User, target.Host), nil
+}
+
+// verifyHost reads the key the host presents and checks it against the
+// known key, recording it when the host is new
+func (s *Service) verifyHost(ctx context.Context, host string, port int) (*HostKey, error) {
+	addr := HostAddress(host, port)
+	known, err := s.host(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if known == nil && !s.tofu {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHost, addr)
+	}
+
+	var algorithms []string
+	if known != nil {
+		algorithms = hostKeyAlgorithms(known.Type)
+	}
+	presented, err := s.scanHostKey(ctx, net.JoinHostPort(host, strconv.Itoa(port)), algorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	if known != nil {
+		if known.PublicKey != newHostKey(addr, presented).PublicKey {
+			s.logger.Warn("SSH host key mismatch",
+				zap.String("host", addr),
+				zap.String("known", known.Fingerprint),
+				zap.String("presented", ssh.FingerprintSHA256(presented)))
+			return nil, fmt.Errorf("%w: %s presented %s", ErrHostKeyMismatch, addr, ssh.FingerprintSHA256(presented))
+		}
+		return known, nil
+	}
+
+	entry := newHostKey(addr, presented)
+	if err := s.putHost(ctx, entry); err != nil {
+		return nil, err
+	}
+	s.logger.Info("Trusted SSH host key on first use",
+		zap.String("host", addr),
+		zap.String("fingerprint", entry.Fingerprint))
+	return entry, nil
+}
+
+// scanHostKey runs the key exchange far enough to see the host key. Only
+// the algorithms given are offered, so a host with several keys presents
+// the one on record.
+func (s *Service) scanHostKey(ctx context.Context, addr string, algorithms []string) (ssh.PublicKey, error) {
+	dialer := net.Dialer{Timeout: s.dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.dialTimeout))
+
+	var presented ssh.PublicKey
+	_, _, _, err = ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:              "webtunnel",
+		HostKeyAlgorithms: algorithms,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			presented = key
+			return errHostKeySeen
+		},
+	})
+	if presented == nil {
+		return nil, fmt.Errorf("failed to read host key from %s: %w", addr, err)
+	}
+	return presented, nil
+}
+
+func (s *Service) host(ctx context.Context, addr string) (*HostKey, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.hosts[addr], nil
+	}
+
+	host := &HostKey{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT host, key_type, public_key, fingerprint, pinned, COALESCE(pinned_by, ''), created_at
+		FROM ssh_known_hosts WHERE host = $1`, addr,
+	).Scan(&host.Host, &host.Type, &host.PublicKey, &host.Fingerprint, &host.Pinned, &host.PinnedBy, &host.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known host: %w", err)
+	}
+	return host, nil
+}
+
+func (s *Service) putHost(ctx context.Context, entry *HostKey) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.hosts[entry.Host] = entry
+		return nil
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO ssh_known_hosts (host, key_type, public_key, fingerprint, pinned, pinned_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		ON CONFLICT (host) DO UPDATE SET
+			key_type = EXCLUDED.key_type,
+			public_key = EXCLUDED.public_key,
+			fingerprint = EXCLUDED.fingerprint,
+			pinned = EXCLUDED.pinned,
+			pinned_by = EXCLUDED.pinned_by,
+			created_at = CURRENT_TIMESTAMP`,
+		entry.Host, entry.Type, entry.PublicKey, entry.Fingerprint, entry.Pinned, entry.PinnedBy)
+	if err != nil {
+		return fmt.Errorf("failed to save known host: %w", err)
+	}
+	return nil
+}
+
+func newHostKey(addr string, key ssh.PublicKey) *HostKey {
+	return &HostKey{
+		Host:        addr,
+		Type:        key.Type(),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		CreatedAt:   time.Now(),
+	}
+}
+
+func (h *HostKey) public() ssh.PublicKey {
+	key, _, _, _, _ := ssh.ParseAuthorizedKey([]byte(h.PublicKey))
+	return key
+}
+
+// hostKeyAlgorithms are the signature algorithms for a stored key type;
+// RSA keys sign with SHA-2 on current servers
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// splitHost parses host or host:port as given to the admin API
+func splitHost(host string) (string, int, error) {
+	name, portText, err := net.SplitHostPort(host)
+	if err != nil {
+		name, portText = strings.Trim(host, "[]"), "22"
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port <= 0 || port > 65535 || !hostPattern.MatchString(name) {
+		return "", 0, fmt.Errorf("%w: %q", ErrInvalidTarget, host)
+	}
+	return name, port, nil
+}
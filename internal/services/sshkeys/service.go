@@ -0,0 +1,338 @@
+package sshkeys
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// Key types that can be generated
+const (
+	TypeEd25519 = "ed25519"
+	TypeRSA     = "rsa"
+)
+
+const maxKeyName = 64
+
+var (
+	ErrNotFound     = errors.New("ssh key not found")
+	ErrInvalidKey   = errors.New("invalid ssh key")
+	ErrKeyExists    = errors.New("an ssh key with that name already exists")
+	ErrNoPrivateKey = errors.New("ssh key has no private key on the server")
+)
+
+// Key is a user's SSH key. Generated and uploaded private keys are stored
+// sealed and never returned; keys uploaded without one can be listed but
+// not used for sessions.
+type Key struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	PublicKey   string    `json:"public_key"` // authorized_keys format
+	Fingerprint string    `json:"fingerprint"`
+	HasPrivate  bool      `json:"has_private_key"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	sealed []byte
+}
+
+// Service stores users' SSH keys and known host keys, and prepares SSH
+// sessions with them. Without a database both live only in memory.
+type Service struct {
+	db          *database.DB
+	aead        cipher.AEAD
+	tofu        bool
+	dialTimeout time.Duration
+	logger      *zap.Logger
+
+	// memory holds keys by user and host keys by host when there is no
+	// database
+	memory map[string]map[string]*Key
+	hosts  map[string]*HostKey
+	mu     sync.Mutex
+}
+
+// New creates the service. Without a configured encryption key an
+// ephemeral one is generated, so stored private keys cannot be opened
+// after a restart.
+func New(cfg config.SSHConfig, db *database.DB, logger *zap.Logger) (*Service, error) {
+	var key []byte
+	if cfg.EncryptionKey == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate ssh encryption key: %w", err)
+		}
+		logger.Warn("No SSH key encryption key configured, generated an ephemeral one")
+	} else {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(cfg.EncryptionKey); err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("ssh encryption_key must be 32 base64 encoded bytes")
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssh key cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssh key cipher: %w", err)
+	}
+
+	dialTimeout := 10 * time.Second
+	if cfg.DialTimeout != "" {
+		if dialTimeout, err = time.ParseDuration(cfg.DialTimeout); err != nil || dialTimeout <= 0 {
+			return nil, fmt.Errorf("invalid ssh dial_timeout %q", cfg.DialTimeout)
+		}
+	}
+
+	return &Service{
+		db:          db,
+		aead:        aead,
+		tofu:        cfg.TrustOnFirstUse,
+		dialTimeout: dialTimeout,
+		logger:      logger,
+		memory:      make(map[string]map[string]*Key),
+		hosts:       make(map[string]*HostKey),
+	}, nil
+}
+
+// Generate creates a key pair on the server and stores it. The private key
+// never leaves the server; the user installs the public key on their hosts.
+func (s *Service) Generate(ctx context.Context, userID, name, keyType string) (*Key, error) {
+	var private interface{}
+	switch keyType {
+	case "", TypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		private = priv
+	case TypeRSA:
+		priv, err := rsa.GenerateKey(rand.Reader, 3072)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		private = priv
+	default:
+		return nil, fmt.Errorf("%w: unsupported key type %q", ErrInvalidKey, keyType)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	return s.save(ctx, userID, name, signer.PublicKey(), pem.EncodeToMemory(block))
+}
+
+// Import stores an uploaded key: a public key in authorized_keys format,
+// or an unencrypted private key, whose public half is derived from it
+func (s *Service) Import(ctx context.Context, userID, name, publicKey, privateKey string) (*Key, error) {
+	if privateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return s.save(ctx, userID, name, signer.PublicKey(), []byte(privateKey))
+	}
+
+	public, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return s.save(ctx, userID, name, public, nil)
+}
+
+func (s *Service) save(ctx context.Context, userID, name string, public ssh.PublicKey, private []byte) (*Key, error) {
+	if name == "" || len(name) > maxKeyName {
+		return nil, fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidKey, maxKeyName)
+	}
+
+	key := &Key{
+		ID:          generateID(),
+		Name:        name,
+		Type:        public.Type(),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(public))),
+		Fingerprint: ssh.FingerprintSHA256(public),
+		HasPrivate:  private != nil,
+		CreatedAt:   time.Now(),
+	}
+	if private != nil {
+		var err error
+		if key.sealed, err = s.seal(private); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, existing := range s.memory[userID] {
+			if existing.Name == name {
+				return nil, ErrKeyExists
+			}
+		}
+		if s.memory[userID] == nil {
+			s.memory[userID] = make(map[string]*Key)
+		}
+		s.memory[userID][key.ID] = key
+	} else {
+		result, err := s.db.ExecContext(ctx, `
+			INSERT INTO ssh_keys (uuid, user_id, name, key_type, public_key, fingerprint, private_key)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (user_id, name) DO NOTHING`,
+			key.ID, userID, key.Name, key.Type, key.PublicKey, key.Fingerprint, key.sealed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save ssh key: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, ErrKeyExists
+		}
+	}
+
+	s.logger.Info("SSH key saved",
+		zap.String("user_id", userID),
+		zap.String("key", name),
+		zap.String("fingerprint", key.Fingerprint),
+		zap.Bool("private", key.HasPrivate))
+	return key, nil
+}
+
+// List returns the user's keys, oldest first
+func (s *Service) List(ctx context.Context, userID string) ([]*Key, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result := []*Key{}
+		for _, key := range s.memory[userID] {
+			result = append(result, key)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		})
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT uuid, name, key_type, public_key, fingerprint, private_key IS NOT NULL, created_at
+		FROM ssh_keys WHERE user_id = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ssh keys: %w", err)
+	}
+	defer rows.Close()
+
+	result := []*Key{}
+	for rows.Next() {
+		key := &Key{}
+		if err := rows.Scan(&key.ID, &key.Name, &key.Type, &key.PublicKey, &key.Fingerprint, &key.HasPrivate, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan ssh key: %w", err)
+		}
+		result = append(result, key)
+	}
+	return result, rows.Err()
+}
+
+// Get returns one of the user's keys, by ID or name
+func (s *Service) Get(ctx context.Context, userID, ref string) (*Key, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, key := range s.memory[userID] {
+			if key.ID == ref || key.Name == ref {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+
+	key := &Key{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT uuid, name, key_type, public_key, fingerprint, private_key, created_at
+		FROM ssh_keys WHERE user_id = $1 AND (uuid = $2 OR name = $2)
+		LIMIT 1`, userID, ref,
+	).Scan(&key.ID, &key.Name, &key.Type, &key.PublicKey, &key.Fingerprint, &key.sealed, &key.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ssh key: %w", err)
+	}
+	key.HasPrivate = key.sealed != nil
+	return key, nil
+}
+
+// Delete removes one of the user's keys
+func (s *Service) Delete(ctx context.Context, userID, keyID string) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.memory[userID][keyID]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, keyID)
+		}
+		delete(s.memory[userID], keyID)
+		return nil
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		"DELETE FROM ssh_keys WHERE user_id = $1 AND uuid = $2", userID, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete ssh key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, keyID)
+	}
+	return nil
+}
+
+// seal encrypts a private key with AES-256-GCM; the nonce is prepended
+func (s *Service) seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *Service) open(sealed []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed ssh key is truncated")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt ssh key, was the encryption key changed? %w", err)
+	}
+	return plaintext, nil
+}
+
+func generateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s",
+		hex.EncodeToString(b[0:4]),
+		hex.EncodeToString(b[4:6]),
+		hex.EncodeToString(b[6:8]),
+		hex.EncodeToString(b[8:10]),
+		hex.EncodeToString(b[10:16]))
+}
@@ -0,0 +1,176 @@
+package sshkeys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// startHost runs an SSH server that only gets as far as the key exchange
+func startHost(t *testing.T, hostKey ssh.Signer) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(hostKey)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				ssh.NewServerConn(conn, cfg)
+			}()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(private)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestKeys(t *testing.T) {
+	service, err := New(config.SSHConfig{TrustOnFirstUse: true}, nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	generated, err := service.Generate(ctx, "alice", "deploy", TypeEd25519)
+	require.NoError(t, err)
+	assert.Equal(t, ssh.KeyAlgoED25519, generated.Type)
+	assert.True(t, generated.HasPrivate)
+	assert.True(t, strings.HasPrefix(generated.Fingerprint, "SHA256:"))
+
+	_, err = service.Generate(ctx, "alice", "deploy", TypeEd25519)
+	assert.ErrorIs(t, err, ErrKeyExists)
+	_, err = service.Generate(ctx, "alice", "other", "dsa")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	uploaded, err := service.Import(ctx, "alice", "laptop", string(ssh.MarshalAuthorizedKey(newSigner(t).PublicKey())), "")
+	require.NoError(t, err)
+	assert.False(t, uploaded.HasPrivate)
+	_, err = service.Import(ctx, "alice", "junk", "not a key", "")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	keys, err := service.List(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "deploy", keys[0].Name)
+	keys, err = service.List(ctx, "bob")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// Private keys are sealed at rest
+	assert.NotContains(t, string(generated.sealed), "OPENSSH PRIVATE KEY")
+	private, err := service.open(generated.sealed)
+	require.NoError(t, err)
+	_, err = ssh.ParsePrivateKey(private)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, service.Delete(ctx, "bob", generated.ID), ErrNotFound)
+	require.NoError(t, service.Delete(ctx, "alice", generated.ID))
+	_, err = service.Get(ctx, "alice", "deploy")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestConnectSSH(t *testing.T) {
+	service, err := New(config.SSHConfig{TrustOnFirstUse: true, DialTimeout: "5s"}, nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	hostKey := newSigner(t)
+	port := startHost(t, hostKey)
+	_, err = service.Generate(ctx, "alice", "deploy", TypeEd25519)
+	require.NoError(t, err)
+
+	target := terminal.SSHTarget{Host: "127.0.0.1", Port: port, User: "deploy", Key: "deploy"}
+	dir := t.TempDir()
+	command, err := service.ConnectSSH(ctx, "alice", target, dir)
+	require.NoError(t, err)
+	assert.Contains(t, command, "-p "+strconv.Itoa(port)+" deploy@127.0.0.1")
+	assert.Contains(t, command, "StrictHostKeyChecking=yes")
+
+	info, err := os.Stat(filepath.Join(dir, ".ssh", "id_webtunnel"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	knownHosts, err := os.ReadFile(filepath.Join(dir, ".ssh", "known_hosts"))
+	require.NoError(t, err)
+	assert.Contains(t, string(knownHosts), "[127.0.0.1]:"+strconv.Itoa(port))
+
+	// Trusted on first use
+	hosts, err := service.KnownHosts(ctx)
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.False(t, hosts[0].Pinned)
+	assert.Equal(t, ssh.FingerprintSHA256(hostKey.PublicKey()), hosts[0].Fingerprint)
+
+	// A pinned key the host does not present is refused
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	_, err = service.Pin(ctx, "admin", addr, string(ssh.MarshalAuthorizedKey(newSigner(t).PublicKey())))
+	require.NoError(t, err)
+	_, err = service.ConnectSSH(ctx, "alice", target, t.TempDir())
+	assert.ErrorIs(t, err, ErrHostKeyMismatch)
+
+	// Forgetting the host trusts the next key seen
+	require.NoError(t, service.Forget(ctx, addr))
+	_, err = service.ConnectSSH(ctx, "alice", target, t.TempDir())
+	assert.NoError(t, err)
+
+	for _, bad := range []terminal.SSHTarget{
+		{Host: "127.0.0.1; rm -rf /", User: "deploy", Key: "deploy"},
+		{Host: "127.0.0.1", User: "$(id)", Key: "deploy"},
+		{Host: "127.0.0.1", Port: 70000, User: "deploy", Key: "deploy"},
+	} {
+		_, err = service.ConnectSSH(ctx, "alice", bad, t.TempDir())
+		assert.ErrorIs(t, err, ErrInvalidTarget)
+	}
+	_, err = service.ConnectSSH(ctx, "bob", target, t.TempDir())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestUnknownHostWithoutTOFU(t *testing.T) {
+	service, err := New(config.SSHConfig{}, nil, zap.NewNop())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	hostKey := newSigner(t)
+	port := startHost(t, hostKey)
+	_, err = service.Generate(ctx, "alice", "deploy", TypeEd25519)
+	require.NoError(t, err)
+	target := terminal.SSHTarget{Host: "127.0.0.1", Port: port, User: "deploy", Key: "deploy"}
+
+	_, err = service.ConnectSSH(ctx, "alice", target, t.TempDir())
+	assert.ErrorIs(t, err, ErrUnknownHost)
+
+	_, err = service.Pin(ctx, "admin", "127.0.0.1:"+strconv.Itoa(port), string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())))
+	require.NoError(t, err)
+	_, err = service.ConnectSSH(ctx, "alice", target, t.TempDir())
+	assert.NoError(t, err)
+}
+
+func TestNewValidates(t *testing.T) {
+	_, err := New(config.SSHConfig{EncryptionKey: "c2hvcnQ="}, nil, zap.NewNop())
+	assert.Error(t, err)
+	_, err = New(config.SSHConfig{DialTimeout: "soon"}, nil, zap.NewNop())
+	assert.Error(t, err)
+}
@@ -0,0 +1,284 @@
+package status
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Overall and component states, from best to worst
+const (
+	StateOperational = "operational"
+	StateMaintenance = "maintenance"
+	StateDegraded    = "degraded"
+	StateOutage      = "outage"
+)
+
+// Incident severities set by admins
+const (
+	SeverityMinor = "minor"
+	SeverityMajor = "major"
+)
+
+// checkTimeout bounds each component check so a hung dependency shows up
+// as an outage instead of stalling the page
+const checkTimeout = 2 * time.Second
+
+var ErrInvalidSeverity = errors.New("severity must be minor or major")
+
+// StateError lets a check report something other than an outage. Any
+// other error marks the component as down.
+type StateError struct {
+	State  string
+	Detail string
+}
+
+func (e *StateError) Error() string {
+	return e.Detail
+}
+
+// Degraded reports a component that works with reduced capacity
+func Degraded(format string, args ...interface{}) error {
+	return &StateError{State: StateDegraded, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Maintenance reports a component that is down on purpose
+func Maintenance(format string, args ...interface{}) error {
+	return &StateError{State: StateMaintenance, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Check probes one component. A nil error means operational.
+type Check func(ctx context.Context) error
+
+type Component struct {
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type Incident struct {
+	Message  string    `json:"message"`
+	Severity string    `json:"severity"`
+	Since    time.Time `json:"since"`
+	SetBy    string    `json:"-"`
+}
+
+// Summary is what the public status page shows. Component details are
+// kept short and never include raw errors.
+type Summary struct {
+	Title      string      `json:"title"`
+	State      string      `json:"state"`
+	Components []Component `json:"components"`
+	Incident   *Incident   `json:"incident,omitempty"`
+	CheckedAt  time.Time   `json:"checked_at"`
+	ETag       string      `json:"-"`
+}
+
+type Service struct {
+	title  string
+	ttl    time.Duration
+	logger *zap.Logger
+
+	checks []namedCheck
+
+	mu       sync.Mutex
+	incident *Incident
+	cached   *Summary
+	// refreshing serializes check runs so a burst of page loads after the
+	// cache expires probes each dependency once
+	refreshing sync.Mutex
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+func New(cfg config.StatusConfig, logger *zap.Logger) (*Service, error) {
+	ttl := 30 * time.Second
+	if cfg.CacheTTL != "" {
+		parsed, err := time.ParseDuration(cfg.CacheTTL)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid status cache_ttl %q", cfg.CacheTTL)
+		}
+		ttl = parsed
+	}
+	title := cfg.Title
+	if title == "" {
+		title = "WebTunnel Status"
+	}
+
+	return &Service{
+		title:  title,
+		ttl:    ttl,
+		logger: logger,
+	}, nil
+}
+
+// AddCheck registers a component. Components are shown in the order they
+// were added.
+func (s *Service) AddCheck(name string, check Check) {
+	s.checks = append(s.checks, namedCheck{name: name, check: check})
+}
+
+// CacheTTL is how long a summary is reused
+func (s *Service) CacheTTL() time.Duration {
+	return s.ttl
+}
+
+// Summary returns the cached summary, running the checks again once it is
+// older than the cache TTL
+func (s *Service) Summary(ctx context.Context) *Summary {
+	if summary := s.fresh(); summary != nil {
+		return summary
+	}
+
+	s.refreshing.Lock()
+	defer s.refreshing.Unlock()
+	if summary := s.fresh(); summary != nil {
+		return summary
+	}
+
+	components := s.runChecks(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := s.build(components, time.Now())
+	s.cached = summary
+	return summary
+}
+
+func (s *Service) fresh() *Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != nil && time.Since(s.cached.CheckedAt) < s.ttl {
+		return s.cached
+	}
+	return nil
+}
+
+func (s *Service) runChecks(ctx context.Context) []Component {
+	components := make([]Component, len(s.checks))
+	var wg sync.WaitGroup
+	for i, c := range s.checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			components[i] = Component{Name: c.name, State: StateOperational}
+			err := c.check(checkCtx)
+			if err == nil {
+				return
+			}
+			var stateErr *StateError
+			if errors.As(err, &stateErr) {
+				components[i].State = stateErr.State
+				components[i].Detail = stateErr.Detail
+				return
+			}
+			s.logger.Warn("Status check failed", zap.String("component", c.name), zap.Error(err))
+			components[i].State = StateOutage
+			components[i].Detail = "Unavailable"
+		}(i, c)
+	}
+	wg.Wait()
+	return components
+}
+
+// build must be called with s.mu held
+func (s *Service) build(components []Component, now time.Time) *Summary {
+	summary := &Summary{
+		Title:      s.title,
+		State:      StateOperational,
+		Components: components,
+		CheckedAt:  now,
+	}
+	for _, c := range components {
+		summary.State = worse(summary.State, c.State)
+	}
+	if s.incident != nil {
+		incident := *s.incident
+		summary.Incident = &incident
+		if incident.Severity == SeverityMajor {
+			summary.State = worse(summary.State, StateOutage)
+		} else {
+			summary.State = worse(summary.State, StateDegraded)
+		}
+	}
+
+	raw, _ := json.Marshal(summary)
+	sum := sha256.Sum256(raw)
+	summary.ETag = `"` + hex.EncodeToString(sum[:8]) + `"`
+	return summary
+}
+
+var stateRank = map[string]int{
+	StateOperational: 0,
+	StateMaintenance: 1,
+	StateDegraded:    2,
+	StateOutage:      3,
+}
+
+func worse(a, b string) string {
+	if stateRank[b] > stateRank[a] {
+		return b
+	}
+	return a
+}
+
+// SetIncident publishes an incident message on the status page. It shows
+// up immediately rather than after the cache expires.
+func (s *Service) SetIncident(message, severity, by string) (*Incident, error) {
+	if message == "" {
+		return nil, fmt.Errorf("incident message is required")
+	}
+	if severity == "" {
+		severity = SeverityMinor
+	}
+	if severity != SeverityMinor && severity != SeverityMajor {
+		return nil, ErrInvalidSeverity
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := time.Now()
+	if s.incident != nil {
+		since = s.incident.Since
+	}
+	s.incident = &Incident{Message: message, Severity: severity, Since: since, SetBy: by}
+	s.rebuild()
+
+	s.logger.Info("Status incident set", zap.String("severity", severity), zap.String("by", by))
+	incident := *s.incident
+	return &incident, nil
+}
+
+// ClearIncident removes the incident message
+func (s *Service) ClearIncident(by string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.incident == nil {
+		return
+	}
+	s.incident = nil
+	s.rebuild()
+	s.logger.Info("Status incident cleared", zap.String("by", by))
+}
+
+// rebuild refreshes the cached summary with the current incident, keeping
+// the component results. Must be called with s.mu held.
+func (s *Service) rebuild() {
+	if s.cached != nil {
+		s.cached = s.build(s.cached.Components, s.cached.CheckedAt)
+	}
+}
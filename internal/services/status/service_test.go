@@ -0,0 +1,91 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+func TestSummaryStates(t *testing.T) {
+	service, err := New(config.StatusConfig{CacheTTL: "0s"}, zap.NewNop())
+	require.NoError(t, err)
+
+	var dbErr error
+	service.AddCheck("Terminal sessions", func(ctx context.Context) error { return nil })
+	service.AddCheck("Database", func(ctx context.Context) error { return dbErr })
+
+	summary := service.Summary(context.Background())
+	assert.Equal(t, StateOperational, summary.State)
+	assert.Equal(t, []Component{
+		{Name: "Terminal sessions", State: StateOperational},
+		{Name: "Database", State: StateOperational},
+	}, summary.Components)
+
+	dbErr = Degraded("Slow queries")
+	summary = service.Summary(context.Background())
+	assert.Equal(t, StateDegraded, summary.State)
+	assert.Equal(t, "Slow queries", summary.Components[1].Detail)
+
+	// Raw errors are logged, not published
+	dbErr = errors.New("dial tcp 10.0.0.5:5432: connection refused")
+	summary = service.Summary(context.Background())
+	assert.Equal(t, StateOutage, summary.State)
+	assert.Equal(t, Component{Name: "Database", State: StateOutage, Detail: "Unavailable"}, summary.Components[1])
+}
+
+func TestSummaryIsCached(t *testing.T) {
+	service, err := New(config.StatusConfig{CacheTTL: "1h"}, zap.NewNop())
+	require.NoError(t, err)
+
+	var calls int32
+	service.AddCheck("Database", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	first := service.Summary(context.Background())
+	second := service.Summary(context.Background())
+	assert.Same(t, first, second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestIncident(t *testing.T) {
+	service, err := New(config.StatusConfig{CacheTTL: "1h"}, zap.NewNop())
+	require.NoError(t, err)
+	service.AddCheck("Database", func(ctx context.Context) error { return nil })
+
+	before := service.Summary(context.Background())
+	assert.Equal(t, StateOperational, before.State)
+
+	_, err = service.SetIncident("", "", "admin")
+	assert.Error(t, err)
+	_, err = service.SetIncident("Investigating", "critical", "admin")
+	assert.ErrorIs(t, err, ErrInvalidSeverity)
+
+	// Incidents show up without waiting for the cache to expire
+	incident, err := service.SetIncident("Investigating slow logins", "", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, SeverityMinor, incident.Severity)
+	summary := service.Summary(context.Background())
+	assert.Equal(t, StateDegraded, summary.State)
+	assert.Equal(t, "Investigating slow logins", summary.Incident.Message)
+	assert.NotEqual(t, before.ETag, summary.ETag)
+
+	// Escalating keeps the original start time
+	escalated, err := service.SetIncident("Logins failing", SeverityMajor, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, incident.Since, escalated.Since)
+	assert.Equal(t, StateOutage, service.Summary(context.Background()).State)
+
+	service.ClearIncident("admin")
+	summary = service.Summary(context.Background())
+	assert.Equal(t, StateOperational, summary.State)
+	assert.Nil(t, summary.Incident)
+	assert.Equal(t, before.ETag, summary.ETag)
+}
@@ -0,0 +1,329 @@
+package templates
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+const (
+	maxName        = 64
+	maxDescription = 500
+)
+
+var (
+	ErrNotFound = errors.New("session template not found")
+	ErrInvalid  = errors.New("invalid session template")
+	ErrExists   = errors.New("a session template with that name already exists")
+)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Template is a session profile an admin defined, which users launch by ID
+// or name instead of typing a command
+type Template struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Command     string            `json:"command"` // empty for an interactive shell
+	WorkingDir  string            `json:"working_dir,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Shell       string            `json:"shell,omitempty"`
+	Limits      terminal.Limits   `json:"limits"`
+	CreatedBy   string            `json:"created_by"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// CreateOptions returns the options that start a session from the template
+func (t *Template) CreateOptions() terminal.CreateOptions {
+	env := make([]string, 0, len(t.Env))
+	for key, value := range t.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return terminal.CreateOptions{
+		Command:    t.Command,
+		WorkingDir: t.WorkingDir,
+		Template:   t.ID,
+		Shell:      t.Shell,
+		Env:        env,
+		Limits:     t.Limits,
+	}
+}
+
+func (t *Template) validate() error {
+	switch {
+	case t.Name == "" || len(t.Name) > maxName:
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalid, maxName)
+	case len(t.Description) > maxDescription:
+		return fmt.Errorf("%w: description is longer than %d characters", ErrInvalid, maxDescription)
+	case t.WorkingDir != "" && !filepath.IsAbs(t.WorkingDir):
+		return fmt.Errorf("%w: working_dir must be an absolute path", ErrInvalid)
+	case t.Shell != "" && !filepath.IsAbs(t.Shell):
+		return fmt.Errorf("%w: shell must be an absolute path", ErrInvalid)
+	}
+	for key := range t.Env {
+		if !envName.MatchString(key) {
+			return fmt.Errorf("%w: invalid environment variable name %q", ErrInvalid, key)
+		}
+	}
+	if err := t.Limits.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+// Service stores session templates. Without a database they live only in
+// memory.
+type Service struct {
+	db     *database.DB
+	logger *zap.Logger
+
+	memory map[string]*Template
+	mu     sync.Mutex
+}
+
+func New(db *database.DB, logger *zap.Logger) *Service {
+	return &Service{
+		db:     db,
+		logger: logger,
+		memory: make(map[string]*Template),
+	}
+}
+
+// List returns every template, by name
+func (s *Service) List(ctx context.Context) ([]*Template, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result := []*Template{}
+		for _, t := range s.memory {
+			copied := *t
+			result = append(result, &copied)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT uuid, name, description, command, working_directory, env, shell, limits, created_by, created_at, updated_at
+		FROM session_templates ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session templates: %w", err)
+	}
+	defer rows.Close()
+
+	result := []*Template{}
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+// Get returns a template by ID or name
+func (s *Service) Get(ctx context.Context, ref string) (*Template, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, t := range s.memory {
+			if t.ID == ref || t.Name == ref {
+				copied := *t
+				return &copied, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+
+	t, err := scan(s.db.QueryRowContext(ctx, `
+		SELECT uuid, name, description, command, working_directory, env, shell, limits, created_by, created_at, updated_at
+		FROM session_templates WHERE uuid = $1 OR name = $1
+		LIMIT 1`, ref))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	return t, err
+}
+
+// Create stores a new template
+func (s *Service) Create(ctx context.Context, adminID string, t Template) (*Template, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	t.ID, t.CreatedBy, t.CreatedAt, t.UpdatedAt = generateID(), adminID, now, now
+
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, other := range s.memory {
+			if other.Name == t.Name {
+				return nil, ErrExists
+			}
+		}
+		stored := t
+		s.memory[t.ID] = &stored
+	} else {
+		env, limits, err := encode(&t)
+		if err != nil {
+			return nil, err
+		}
+		result, err := s.db.ExecContext(ctx, `
+			INSERT INTO session_templates (uuid, name, description, command, working_directory, env, shell, limits, created_by, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+			ON CONFLICT (name) DO NOTHING`,
+			t.ID, t.Name, t.Description, t.Command, t.WorkingDir, env, t.Shell, limits, adminID, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save session template: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, ErrExists
+		}
+	}
+
+	s.logger.Info("Session template created",
+		zap.String("template", t.Name),
+		zap.String("user_id", adminID))
+	return &t, nil
+}
+
+// Update replaces a template's fields, keeping its ID and creator.
+// Sessions already started from it are unaffected.
+func (s *Service) Update(ctx context.Context, id string, t Template) (*Template, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	t.ID, t.UpdatedAt = id, time.Now()
+
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		existing, ok := s.memory[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		for _, other := range s.memory {
+			if other.ID != id && other.Name == t.Name {
+				return nil, ErrExists
+			}
+		}
+		t.CreatedBy, t.CreatedAt = existing.CreatedBy, existing.CreatedAt
+		stored := t
+		s.memory[id] = &stored
+		return &t, nil
+	}
+
+	env, limits, err := encode(&t)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE session_templates SET
+			name = $2, description = $3, command = $4, working_directory = $5,
+			env = $6, shell = $7, limits = $8, updated_at = $9
+		WHERE uuid = $1
+		RETURNING created_by, created_at`,
+		id, t.Name, t.Description, t.Command, t.WorkingDir, env, t.Shell, limits, t.UpdatedAt,
+	).Scan(&t.CreatedBy, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation on name
+		return nil, ErrExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session template: %w", err)
+	}
+	return &t, nil
+}
+
+// Delete removes a template. Sessions started from it keep running.
+func (s *Service) Delete(ctx context.Context, id string) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.memory[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		delete(s.memory, id)
+		return nil
+	}
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM session_templates WHERE uuid = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete session template: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*Template, error) {
+	t := &Template{}
+	var env, limits []byte
+	if err := row.Scan(&t.ID, &t.Name, &t.Description, &t.Command, &t.WorkingDir, &env, &t.Shell, &limits,
+		&t.CreatedBy, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan session template: %w", err)
+	}
+	if len(env) > 0 {
+		if err := json.Unmarshal(env, &t.Env); err != nil {
+			return nil, fmt.Errorf("failed to decode template environment: %w", err)
+		}
+	}
+	if len(limits) > 0 {
+		if err := json.Unmarshal(limits, &t.Limits); err != nil {
+			return nil, fmt.Errorf("failed to decode template limits: %w", err)
+		}
+	}
+	return t, nil
+}
+
+func encode(t *Template) (env, limits []byte, err error) {
+	if env, err = json.Marshal(t.Env); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode template environment: %w", err)
+	}
+	if limits, err = json.Marshal(t.Limits); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode template limits: %w", err)
+	}
+	return env, limits, nil
+}
+
+func generateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s",
+		hex.EncodeToString(b[0:4]),
+		hex.EncodeToString(b[4:6]),
+		hex.EncodeToString(b[6:8]),
+		hex.EncodeToString(b[8:10]),
+		hex.EncodeToString(b[10:16]))
+}
@@ -0,0 +1,70 @@
+package templates
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+func TestTemplates(t *testing.T) {
+	service := New(nil, zap.NewNop())
+	ctx := context.Background()
+
+	python, err := service.Create(ctx, "admin", Template{
+		Name:       "python",
+		Command:    "python3",
+		WorkingDir: "/srv/python",
+		Env:        map[string]string{"PYTHONUNBUFFERED": "1", "LANG": "C.UTF-8"},
+		Limits:     terminal.Limits{MemoryMB: 512},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "admin", python.CreatedBy)
+
+	_, err = service.Create(ctx, "admin", Template{Name: "python"})
+	assert.ErrorIs(t, err, ErrExists)
+	for _, invalid := range []Template{
+		{},
+		{Name: "x", WorkingDir: "relative"},
+		{Name: "x", Shell: "zsh"},
+		{Name: "x", Env: map[string]string{"NOT VALID": "1"}},
+		{Name: "x", Limits: terminal.Limits{OpenFiles: -1}},
+	} {
+		_, err = service.Create(ctx, "admin", invalid)
+		assert.ErrorIs(t, err, ErrInvalid, "%+v", invalid)
+	}
+
+	// By ID or name
+	got, err := service.Get(ctx, "python")
+	require.NoError(t, err)
+	assert.Equal(t, python.ID, got.ID)
+	opts := got.CreateOptions()
+	assert.Equal(t, python.ID, opts.Template)
+	assert.Equal(t, "python3", opts.Command)
+	assert.Equal(t, []string{"LANG=C.UTF-8", "PYTHONUNBUFFERED=1"}, opts.Env)
+	assert.Equal(t, terminal.Limits{MemoryMB: 512}, opts.Limits)
+
+	shell, err := service.Create(ctx, "admin", Template{Name: "shell", Shell: "/bin/zsh"})
+	require.NoError(t, err)
+	_, err = service.Update(ctx, shell.ID, Template{Name: "python"})
+	assert.ErrorIs(t, err, ErrExists)
+	updated, err := service.Update(ctx, shell.ID, Template{Name: "zsh", Shell: "/bin/zsh"})
+	require.NoError(t, err)
+	assert.Equal(t, "admin", updated.CreatedBy)
+
+	list, err := service.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "python", list[0].Name)
+	assert.Equal(t, "zsh", list[1].Name)
+
+	require.NoError(t, service.Delete(ctx, python.ID))
+	_, err = service.Get(ctx, python.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, service.Delete(ctx, python.ID), ErrNotFound)
+	_, err = service.Update(ctx, python.ID, Template{Name: "python"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,242 @@
+package uploads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// SniffLen is how much of a file Check needs to see
+const SniffLen = 512
+
+// RoleLookup resolves a user's role to pick their upload rule
+type RoleLookup func(userID string) (string, error)
+
+// RejectedError explains why an upload was refused
+type RejectedError struct {
+	Reason string
+	MIME   string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Reason
+}
+
+// Verdict is the outcome of an accepted check
+type Verdict struct {
+	MIME  string // sniffed from the content
+	Limit int64  // maximum size in bytes, 0 for unlimited
+}
+
+// Service decides whether a user may upload a file, judging by its name and
+// its first bytes. The client's Content-Type is never trusted.
+type Service struct {
+	rules          map[string]config.UploadRule
+	blockDisguised bool
+	roles          RoleLookup
+	logger         *zap.Logger
+}
+
+func New(cfg config.UploadsConfig, roles RoleLookup, logger *zap.Logger) *Service {
+	rules := make(map[string]config.UploadRule, len(cfg.Rules))
+	for role, rule := range cfg.Rules {
+		rules[strings.ToLower(role)] = rule
+	}
+	return &Service{
+		rules:          rules,
+		blockDisguised: cfg.BlockDisguised,
+		roles:          roles,
+		logger:         logger,
+	}
+}
+
+// Enabled reports whether uploads are checked at all
+func (s *Service) Enabled() bool {
+	return len(s.rules) > 0 || s.blockDisguised
+}
+
+// Check returns the size limit for the upload or a *RejectedError. head is
+// the start of the file, up to SniffLen bytes.
+func (s *Service) Check(userID, filename string, head []byte) (Verdict, error) {
+	contentType := Sniff(head)
+	verdict := Verdict{MIME: contentType}
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	if s.blockDisguised && disguised(filename, ext, head) {
+		return verdict, &RejectedError{
+			Reason: fmt.Sprintf("file content is %s, which does not match its %q extension", contentType, ext),
+			MIME:   contentType,
+		}
+	}
+
+	rule, ok := s.ruleFor(userID)
+	if !ok {
+		return verdict, nil
+	}
+
+	if containsExt(rule.DenyExtensions, ext) {
+		return verdict, &RejectedError{Reason: fmt.Sprintf("files with extension %q are not allowed", ext), MIME: contentType}
+	}
+	if len(rule.AllowExtensions) > 0 && !containsExt(rule.AllowExtensions, ext) {
+		return verdict, &RejectedError{Reason: fmt.Sprintf("files with extension %q are not allowed", ext), MIME: contentType}
+	}
+	if _, denied := matchMIME(rule.DenyMIME, contentType); denied {
+		return verdict, &RejectedError{Reason: fmt.Sprintf("files of type %s are not allowed", contentType), MIME: contentType}
+	}
+	if _, allowed := matchMIME(rule.AllowMIME, contentType); len(rule.AllowMIME) > 0 && !allowed {
+		return verdict, &RejectedError{Reason: fmt.Sprintf("files of type %s are not allowed", contentType), MIME: contentType}
+	}
+
+	limitMB := rule.MaxSizeMB
+	patterns := make([]string, 0, len(rule.MaxSizeByMIME))
+	for pattern := range rule.MaxSizeByMIME {
+		patterns = append(patterns, pattern)
+	}
+	if pattern, ok := matchMIME(patterns, contentType); ok {
+		limitMB = rule.MaxSizeByMIME[pattern]
+	}
+	verdict.Limit = int64(limitMB) << 20
+	return verdict, nil
+}
+
+// ruleFor picks the user's role rule, falling back to "default"
+func (s *Service) ruleFor(userID string) (config.UploadRule, bool) {
+	if len(s.rules) == 0 {
+		return config.UploadRule{}, false
+	}
+	if s.roles != nil {
+		role, err := s.roles(userID)
+		if err != nil {
+			s.logger.Warn("Failed to look up role for upload rule", zap.String("user_id", userID), zap.Error(err))
+		} else if rule, exists := s.rules[strings.ToLower(role)]; exists {
+			return rule, true
+		}
+	}
+	rule, exists := s.rules["default"]
+	return rule, exists
+}
+
+func containsExt(list []string, ext string) bool {
+	for _, item := range list {
+		item = strings.ToLower(item)
+		if !strings.HasPrefix(item, ".") {
+			item = "." + item
+		}
+		if item == ext || (item == "." && ext == "") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMIME returns the most specific pattern matching contentType. An exact
+// type beats family/* which beats *.
+func matchMIME(patterns []string, contentType string) (string, bool) {
+	family := contentType
+	if i := strings.IndexByte(contentType, '/'); i >= 0 {
+		family = contentType[:i]
+	}
+
+	best, bestScore := "", 0
+	for _, pattern := range patterns {
+		score := 0
+		switch p := strings.ToLower(strings.TrimSpace(pattern)); {
+		case p == contentType:
+			score = 3
+		case p == family+"/*":
+			score = 2
+		case p == "*" || p == "*/*":
+			score = 1
+		}
+		if score > bestScore {
+			best, bestScore = pattern, score
+		}
+	}
+	return best, bestScore > 0
+}
+
+// Executable formats, checked before http.DetectContentType, which reports
+// them all as application/octet-stream
+const (
+	MIMEELF    = "application/x-executable"
+	MIMEPE     = "application/vnd.microsoft.portable-executable"
+	MIMEMachO  = "application/x-mach-binary"
+	MIMEScript = "text/x-shellscript"
+)
+
+var magic = []struct {
+	prefix []byte
+	mime   string
+}{
+	{[]byte("\x7fELF"), MIMEELF},
+	{[]byte{0xfe, 0xed, 0xfa, 0xce}, MIMEMachO},
+	{[]byte{0xfe, 0xed, 0xfa, 0xcf}, MIMEMachO},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, MIMEMachO},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, MIMEMachO},
+	{[]byte("#!"), MIMEScript},
+}
+
+// Sniff returns the MIME type of content without parameters
+func Sniff(head []byte) string {
+	if isPE(head) {
+		return MIMEPE
+	}
+	for _, m := range magic {
+		if bytes.HasPrefix(head, m.prefix) {
+			return m.mime
+		}
+	}
+	contentType := http.DetectContentType(head)
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType
+}
+
+// isPE looks past the MZ stub for the PE header, so text that happens to
+// start with "MZ" is not taken for a Windows binary. The header offset is
+// trusted if it lies beyond head but within the first 4 KiB.
+func isPE(head []byte) bool {
+	if len(head) < 64 || !bytes.HasPrefix(head, []byte("MZ")) {
+		return false
+	}
+	offset := int(binary.LittleEndian.Uint32(head[0x3c:0x40]))
+	if offset+4 <= len(head) {
+		return bytes.Equal(head[offset:offset+4], []byte("PE\x00\x00"))
+	}
+	return offset < 4096
+}
+
+// binaryExtensions are names under which native executables and libraries
+// are expected
+var binaryExtensions = map[string]bool{
+	"": true, ".exe": true, ".dll": true, ".sys": true, ".com": true, ".scr": true,
+	".so": true, ".dylib": true, ".bin": true, ".out": true, ".elf": true,
+	".o": true, ".ko": true, ".run": true, ".appimage": true,
+}
+
+// disguised reports an executable hiding behind the name of something
+// harmless. Native binaries must carry a binary extension; scripts may have
+// any name except one of a media or document type.
+func disguised(filename, ext string, head []byte) bool {
+	switch Sniff(head) {
+	case MIMEELF, MIMEPE, MIMEMachO:
+		// Versioned shared libraries, e.g. libfoo.so.1
+		return !binaryExtensions[ext] && !strings.Contains(strings.ToLower(filepath.Base(filename)), ".so.")
+	case MIMEScript:
+		expected := mime.TypeByExtension(ext)
+		for _, family := range []string{"image/", "audio/", "video/", "font/", "application/pdf"} {
+			if strings.HasPrefix(expected, family) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package uploads
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+var (
+	elf    = []byte("\x7fELF\x02\x01\x01\x00")
+	png    = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	script = []byte("#!/bin/sh\nrm -rf ~\n")
+	text   = []byte("hello world\n")
+)
+
+func newService(cfg config.UploadsConfig) *Service {
+	roles := map[string]string{"alice": "admin", "bob": "user", "carol": "guest"}
+	return New(cfg, func(userID string) (string, error) {
+		role, ok := roles[userID]
+		if !ok {
+			return "", errors.New("no such user")
+		}
+		return role, nil
+	}, zap.NewNop())
+}
+
+func pe() []byte {
+	head := make([]byte, 256)
+	copy(head, "MZ")
+	binary.LittleEndian.PutUint32(head[0x3c:], 0x80)
+	copy(head[0x80:], "PE\x00\x00")
+	return head
+}
+
+func TestSniff(t *testing.T) {
+	assert.Equal(t, MIMEELF, Sniff(elf))
+	assert.Equal(t, MIMEPE, Sniff(pe()))
+	assert.Equal(t, MIMEMachO, Sniff([]byte{0xcf, 0xfa, 0xed, 0xfe, 0x07}))
+	assert.Equal(t, MIMEScript, Sniff(script))
+	assert.Equal(t, "image/png", Sniff(png))
+	assert.Equal(t, "text/plain", Sniff(text))
+
+	// Text that merely starts with MZ is not a Windows binary
+	prose := []byte("MZ is how this sentence starts, and it goes on for more than sixty-four bytes.")
+	assert.Equal(t, "text/plain", Sniff(prose))
+}
+
+func TestDisguisedExecutables(t *testing.T) {
+	service := newService(config.UploadsConfig{BlockDisguised: true})
+
+	for name, head := range map[string][]byte{
+		"cat.png":    elf,
+		"report.pdf": pe(),
+		"notes.txt":  elf,
+		"photo.jpg":  script,
+	} {
+		_, err := service.Check("bob", name, head)
+		var rejected *RejectedError
+		assert.True(t, errors.As(err, &rejected), name)
+	}
+
+	for name, head := range map[string][]byte{
+		"tool":        elf,
+		"setup.exe":   pe(),
+		"libfoo.so.1": elf,
+		"install.sh":  script,
+		"deploy.py":   script,
+		"cat.png":     png,
+		"readme.md":   text,
+	} {
+		_, err := service.Check("bob", name, head)
+		assert.NoError(t, err, name)
+	}
+
+	// Off unless configured
+	_, err := newService(config.UploadsConfig{}).Check("bob", "cat.png", elf)
+	assert.NoError(t, err)
+}
+
+func TestRoleRules(t *testing.T) {
+	service := newService(config.UploadsConfig{
+		Rules: map[string]config.UploadRule{
+			"default": {
+				AllowExtensions: []string{"txt", ".png", ".csv"},
+				DenyMIME:        []string{"application/x-executable"},
+				MaxSizeMB:       10,
+				MaxSizeByMIME:   map[string]int{"image/*": 2, "image/png": 1},
+			},
+			"admin": {DenyExtensions: []string{".exe"}},
+		},
+	})
+
+	verdict, err := service.Check("bob", "/tmp/notes.TXT", text)
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", verdict.MIME)
+	assert.Equal(t, int64(10<<20), verdict.Limit)
+
+	// The most specific size limit wins
+	verdict, err = service.Check("bob", "cat.png", png)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<20), verdict.Limit)
+
+	var rejected *RejectedError
+	_, err = service.Check("bob", "setup.exe", pe())
+	assert.True(t, errors.As(err, &rejected))
+	assert.Contains(t, rejected.Reason, "extension")
+
+	// Allowed name, denied content
+	_, err = service.Check("bob", "data.csv", elf)
+	assert.True(t, errors.As(err, &rejected))
+	assert.Equal(t, MIMEELF, rejected.MIME)
+
+	// Roles without a rule, and unknown users, get the default
+	_, err = service.Check("carol", "setup.exe", pe())
+	assert.Error(t, err)
+	_, err = service.Check("mallory", "setup.exe", pe())
+	assert.Error(t, err)
+
+	// Admins have their own rule
+	verdict, err = service.Check("alice", "tool.bin", elf)
+	require.NoError(t, err)
+	assert.Zero(t, verdict.Limit)
+	_, err = service.Check("alice", "setup.exe", pe())
+	assert.Error(t, err)
+}
+
+func TestAllowMIME(t *testing.T) {
+	service := newService(config.UploadsConfig{
+		Rules: map[string]config.UploadRule{
+			"default": {AllowMIME: []string{"text/*"}},
+		},
+	})
+
+	_, err := service.Check("bob", "notes", text)
+	assert.NoError(t, err)
+	_, err = service.Check("bob", "cat.png", png)
+	assert.Error(t, err)
+}
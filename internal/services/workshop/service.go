@@ -0,0 +1,631 @@
+package workshop
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/internal/database"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// userPrefix marks the user IDs workshops issue, so lookups for other
+// users never touch workshop storage
+const userPrefix = "workshop_"
+
+const (
+	maxName        = 100
+	passwordLength = 16
+	// passwordAlphabet leaves out characters that are easy to misread on a
+	// projector or a printed handout
+	passwordAlphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	ErrNotFound      = errors.New("workshop not found")
+	ErrInvalid       = errors.New("invalid workshop")
+	ErrLoginTaken    = errors.New("login already used by another workshop")
+	ErrUserEnded     = errors.New("workshop has ended")
+	ErrBadPassword   = errors.New("invalid credentials")
+	ErrUnknownUser   = errors.New("workshop user not found")
+	ErrUnknownPreset = errors.New("unknown workshop preset")
+)
+
+var username = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,99}$`)
+
+// Workshop is a batch of temporary users for a class. When it ends its
+// users are deleted and their sessions killed.
+type Workshop struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Preset    string    `json:"preset,omitempty"`
+	Role      string    `json:"role"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Users     []*User   `json:"users"`
+}
+
+// User is a workshop account. Password, Token and SessionID are only set on
+// the workshop Provision returns; afterwards the password is kept hashed.
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email,omitempty"`
+	Password  string `json:"password,omitempty"`
+	Token     string `json:"token,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+
+	hash []byte
+}
+
+// Request describes the users to provision: Count generated ones, or one
+// per row of CSV, each row a username and an optional email
+type Request struct {
+	Name   string
+	Count  int
+	CSV    string
+	Preset string
+	EndsAt time.Time
+}
+
+// TokenIssuer signs login tokens that expire with the workshop
+type TokenIssuer interface {
+	GenerateTokenUntil(userID, email, role string, expires time.Time) (string, error)
+}
+
+// Sessions is the part of the terminal service used to start the preset
+// sessions and end them at teardown
+type Sessions interface {
+	CreateSessionWithOptions(ctx context.Context, userID string, opts terminal.CreateOptions) (*terminal.Session, error)
+	ListSessions(userID string) []*terminal.Session
+	KillSession(sessionID string) error
+}
+
+// Service provisions workshops and is the auth directory for their users.
+// Without a database workshops live only in memory, so a restart ends them.
+type Service struct {
+	db          *database.DB
+	maxUsers    int
+	maxDuration time.Duration
+	presets     map[string]config.WorkshopPreset
+	tokens      TokenIssuer
+	sessions    Sessions
+	logger      *zap.Logger
+
+	memory map[string]*Workshop
+	mu     sync.Mutex
+}
+
+func New(cfg config.WorkshopConfig, db *database.DB, tokens TokenIssuer, sessions Sessions, logger *zap.Logger) (*Service, error) {
+	maxDuration := 72 * time.Hour
+	if cfg.MaxDuration != "" {
+		var err error
+		if maxDuration, err = time.ParseDuration(cfg.MaxDuration); err != nil || maxDuration <= 0 {
+			return nil, fmt.Errorf("invalid workshop max_duration %q", cfg.MaxDuration)
+		}
+	}
+	maxUsers := cfg.MaxUsers
+	if maxUsers <= 0 {
+		maxUsers = 100
+	}
+	for name, preset := range cfg.Presets {
+		if preset.Role == "admin" {
+			return nil, fmt.Errorf("workshop preset %s may not grant the admin role", name)
+		}
+	}
+
+	return &Service{
+		db:          db,
+		maxUsers:    maxUsers,
+		maxDuration: maxDuration,
+		presets:     cfg.Presets,
+		tokens:      tokens,
+		sessions:    sessions,
+		logger:      logger,
+		memory:      make(map[string]*Workshop),
+	}, nil
+}
+
+// Provision creates the workshop's users, with a login token each, and
+// starts the preset's session for every one of them. It is all or nothing:
+// on failure whatever was created is torn down again.
+func (s *Service) Provision(ctx context.Context, createdBy string, req Request) (*Workshop, error) {
+	if req.Name == "" || len(req.Name) > maxName {
+		return nil, fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalid, maxName)
+	}
+	now := time.Now()
+	if !req.EndsAt.After(now) {
+		return nil, fmt.Errorf("%w: ends_at must be in the future", ErrInvalid)
+	}
+	if req.EndsAt.Sub(now) > s.maxDuration {
+		return nil, fmt.Errorf("%w: workshops may last at most %s", ErrInvalid, s.maxDuration)
+	}
+
+	w := &Workshop{
+		ID:        generateID(),
+		Name:      req.Name,
+		Preset:    req.Preset,
+		Role:      "user",
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		EndsAt:    req.EndsAt.Truncate(time.Second),
+	}
+	var preset config.WorkshopPreset
+	if req.Preset != "" {
+		var ok bool
+		if preset, ok = s.presets[req.Preset]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPreset, req.Preset)
+		}
+		if preset.Role != "" {
+			w.Role = preset.Role
+		}
+	}
+
+	var err error
+	switch {
+	case req.CSV != "" && req.Count != 0:
+		return nil, fmt.Errorf("%w: give a count or a CSV, not both", ErrInvalid)
+	case req.CSV != "":
+		w.Users, err = parseCSV(req.CSV)
+	default:
+		w.Users, err = generateUsers(w.ID, req.Count)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(w.Users) > s.maxUsers {
+		return nil, fmt.Errorf("%w: at most %d users per workshop", ErrInvalid, s.maxUsers)
+	}
+
+	for _, u := range w.Users {
+		u.ID = userPrefix + generateID()
+		if u.Password, err = generatePassword(); err != nil {
+			return nil, err
+		}
+		u.hash = hashPassword(u.Password)
+		if u.Token, err = s.tokens.GenerateTokenUntil(u.ID, u.login(), w.Role, w.EndsAt); err != nil {
+			return nil, fmt.Errorf("failed to issue workshop token: %w", err)
+		}
+	}
+
+	if err := s.save(ctx, w); err != nil {
+		return nil, err
+	}
+
+	if req.Preset != "" {
+		for _, u := range w.Users {
+			session, err := s.sessions.CreateSessionWithOptions(ctx, u.ID, terminal.CreateOptions{
+				Command:    preset.Command,
+				WorkingDir: preset.WorkingDir,
+			})
+			if err != nil {
+				s.teardown(context.Background(), w)
+				return nil, fmt.Errorf("failed to start session for %s: %w", u.Username, err)
+			}
+			u.SessionID = session.ID
+		}
+	}
+
+	s.logger.Info("Workshop provisioned",
+		zap.String("workshop_id", w.ID),
+		zap.String("name", w.Name),
+		zap.Int("users", len(w.Users)),
+		zap.String("preset", w.Preset),
+		zap.Time("ends_at", w.EndsAt),
+		zap.String("created_by", createdBy))
+	return w, nil
+}
+
+// List returns the workshops that have not been torn down, soonest ending
+// first
+func (s *Service) List(ctx context.Context) ([]*Workshop, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result := []*Workshop{}
+		for _, w := range s.memory {
+			copied := w.public()
+			for _, u := range copied.Users {
+				u.hash = nil
+			}
+			result = append(result, copied)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].EndsAt.Before(result[j].EndsAt)
+		})
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT w.uuid, w.name, w.preset, w.role, w.created_by, w.created_at, w.ends_at,
+			u.uuid, u.username, u.email
+		FROM workshops w LEFT JOIN workshop_users u ON u.workshop_id = w.uuid
+		ORDER BY w.ends_at, w.uuid, u.id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workshops: %w", err)
+	}
+	defer rows.Close()
+
+	result := []*Workshop{}
+	var last *Workshop
+	for rows.Next() {
+		w := &Workshop{}
+		var userID, name, email sql.NullString
+		if err := rows.Scan(&w.ID, &w.Name, &w.Preset, &w.Role, &w.CreatedBy, &w.CreatedAt, &w.EndsAt,
+			&userID, &name, &email); err != nil {
+			return nil, fmt.Errorf("failed to scan workshop: %w", err)
+		}
+		if last == nil || last.ID != w.ID {
+			w.Users = []*User{}
+			result = append(result, w)
+			last = w
+		}
+		if userID.Valid {
+			last.Users = append(last.Users, &User{ID: userID.String, Username: name.String, Email: email.String})
+		}
+	}
+	return result, rows.Err()
+}
+
+// Get returns one workshop and its users, without their secrets
+func (s *Service) Get(ctx context.Context, id string) (*Workshop, error) {
+	workshops, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range workshops {
+		if w.ID == id {
+			return w, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// End tears a workshop down now rather than at its end time
+func (s *Service) End(ctx context.Context, id string) error {
+	w, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.teardown(ctx, w)
+}
+
+// Run tears workshops down as they end
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) tick(ctx context.Context, now time.Time) {
+	workshops, err := s.List(ctx)
+	if err != nil {
+		s.logger.Warn("Failed to list workshops", zap.Error(err))
+		return
+	}
+	for _, w := range workshops {
+		if now.Before(w.EndsAt) {
+			break
+		}
+		if err := s.teardown(ctx, w); err != nil {
+			s.logger.Warn("Failed to tear down workshop", zap.String("workshop_id", w.ID), zap.Error(err))
+		}
+	}
+}
+
+// teardown deletes the workshop's users, which revokes their tokens, then
+// kills every session they own
+func (s *Service) teardown(ctx context.Context, w *Workshop) error {
+	if err := s.remove(ctx, w.ID); err != nil {
+		return err
+	}
+
+	killed := 0
+	for _, u := range w.Users {
+		for _, session := range s.sessions.ListSessions(u.ID) {
+			if err := s.sessions.KillSession(session.ID); err == nil {
+				killed++
+			}
+		}
+	}
+
+	s.logger.Info("Workshop torn down",
+		zap.String("workshop_id", w.ID),
+		zap.String("name", w.Name),
+		zap.Int("users", len(w.Users)),
+		zap.Int("sessions_killed", killed))
+	return nil
+}
+
+// Authenticate checks a workshop user's username or email and password.
+// Logins no workshop uses are left to the other authenticators.
+func (s *Service) Authenticate(login, password string) (*auth.User, bool, error) {
+	w, u, err := s.find(context.Background(), func(w *Workshop, u *User) bool {
+		return u.Username == login || (u.Email != "" && u.Email == login)
+	}, `u.username = $1 OR u.email = $1`, login)
+	if errors.Is(err, ErrUnknownUser) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if subtle.ConstantTimeCompare(u.hash, hashPassword(password)) != 1 {
+		return nil, true, ErrBadPassword
+	}
+	if !time.Now().Before(w.EndsAt) {
+		return nil, true, ErrUserEnded
+	}
+	return u.user(w), true, nil
+}
+
+// Lookup resolves the user IDs workshops issued. A user whose workshop has
+// ended or been torn down is an error, which revokes their tokens.
+func (s *Service) Lookup(userID string) (*auth.User, bool, error) {
+	if !strings.HasPrefix(userID, userPrefix) {
+		return nil, false, nil
+	}
+	w, u, err := s.find(context.Background(), func(w *Workshop, u *User) bool {
+		return u.ID == userID
+	}, `u.uuid = $1`, userID)
+	if err != nil {
+		return nil, true, err
+	}
+	if !time.Now().Before(w.EndsAt) {
+		return nil, true, ErrUserEnded
+	}
+	return u.user(w), true, nil
+}
+
+// find returns the first workshop user matching, by match in memory or the
+// where clause in the database
+func (s *Service) find(ctx context.Context, match func(*Workshop, *User) bool, where string, arg string) (*Workshop, *User, error) {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, w := range s.memory {
+			for _, u := range w.Users {
+				if match(w, u) {
+					return w, u, nil
+				}
+			}
+		}
+		return nil, nil, ErrUnknownUser
+	}
+
+	w, u := &Workshop{}, &User{}
+	var email sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT w.uuid, w.name, w.role, w.ends_at, u.uuid, u.username, u.email, u.password_hash
+		FROM workshop_users u JOIN workshops w ON w.uuid = u.workshop_id
+		WHERE `+where+` LIMIT 1`, arg,
+	).Scan(&w.ID, &w.Name, &w.Role, &w.EndsAt, &u.ID, &u.Username, &email, &u.hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up workshop user: %w", err)
+	}
+	u.Email = email.String
+	return w, u, nil
+}
+
+func (s *Service) save(ctx context.Context, w *Workshop) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		taken := make(map[string]bool)
+		for _, other := range s.memory {
+			for _, u := range other.Users {
+				taken[u.Username] = true
+				if u.Email != "" {
+					taken[u.Email] = true
+				}
+			}
+		}
+		for _, u := range w.Users {
+			if taken[u.Username] || (u.Email != "" && taken[u.Email]) {
+				return fmt.Errorf("%w: %s", ErrLoginTaken, u.login())
+			}
+		}
+		s.memory[w.ID] = w.public()
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save workshop: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO workshops (uuid, name, preset, role, created_by, created_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		w.ID, w.Name, w.Preset, w.Role, w.CreatedBy, w.CreatedAt, w.EndsAt); err != nil {
+		return fmt.Errorf("failed to save workshop: %w", err)
+	}
+	for _, u := range w.Users {
+		var taken bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM workshop_users
+			WHERE username = $1 OR email = $1 OR username = $2 OR email = $2)`,
+			u.Username, u.login()).Scan(&taken); err != nil {
+			return fmt.Errorf("failed to save workshop user: %w", err)
+		}
+		if taken {
+			return fmt.Errorf("%w: %s", ErrLoginTaken, u.login())
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO workshop_users (uuid, workshop_id, username, email, password_hash)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5)`,
+			u.ID, w.ID, u.Username, u.Email, u.hash); err != nil {
+			return fmt.Errorf("failed to save workshop user: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Service) remove(ctx context.Context, id string) error {
+	if s.db == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.memory[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		delete(s.memory, id)
+		return nil
+	}
+
+	// Users go with the workshop, ON DELETE CASCADE
+	result, err := s.db.ExecContext(ctx, "DELETE FROM workshops WHERE uuid = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete workshop: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// public copies the workshop without the secrets only Provision returns
+func (w *Workshop) public() *Workshop {
+	copied := *w
+	copied.Users = make([]*User, len(w.Users))
+	for i, u := range w.Users {
+		copied.Users[i] = &User{ID: u.ID, Username: u.Username, Email: u.Email, hash: u.hash}
+	}
+	return &copied
+}
+
+// login is what the user signs in with: their email, or their username
+// when they have none
+func (u *User) login() string {
+	if u.Email != "" {
+		return u.Email
+	}
+	return u.Username
+}
+
+func (u *User) user(w *Workshop) *auth.User {
+	return &auth.User{
+		ID:       u.ID,
+		Email:    u.login(),
+		Username: u.Username,
+		Role:     w.Role,
+	}
+}
+
+// parseCSV reads one user per row: a username and an optional email. A
+// first row starting with "username" is taken as a header.
+func parseCSV(data string) ([]*User, error) {
+	r := csv.NewReader(strings.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var users []*User
+	seen := make(map[string]bool)
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "username") {
+			continue
+		}
+		if len(record) > 2 {
+			return nil, fmt.Errorf("%w: line %d: expected username and email", ErrInvalid, line)
+		}
+		u := &User{Username: strings.TrimSpace(record[0])}
+		if len(record) == 2 {
+			u.Email = strings.TrimSpace(record[1])
+		}
+		if !username.MatchString(u.Username) {
+			return nil, fmt.Errorf("%w: line %d: invalid username %q", ErrInvalid, line, u.Username)
+		}
+		if u.Email != "" && !strings.Contains(u.Email, "@") {
+			return nil, fmt.Errorf("%w: line %d: invalid email %q", ErrInvalid, line, u.Email)
+		}
+		if seen[u.Username] || (u.Email != "" && seen[u.Email]) {
+			return nil, fmt.Errorf("%w: line %d: duplicate user %s", ErrInvalid, line, u.login())
+		}
+		seen[u.Username] = true
+		if u.Email != "" {
+			seen[u.Email] = true
+		}
+		users = append(users, u)
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%w: the CSV has no users", ErrInvalid)
+	}
+	return users, nil
+}
+
+// generateUsers names users after the workshop ID, e.g. 3f9a1c2e-07
+func generateUsers(workshopID string, count int) ([]*User, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("%w: count must be positive", ErrInvalid)
+	}
+	users := make([]*User, count)
+	for i := range users {
+		users[i] = &User{Username: fmt.Sprintf("%s-%02d", workshopID[:8], i+1)}
+	}
+	return users, nil
+}
+
+func generatePassword() (string, error) {
+	b := make([]byte, passwordLength)
+	limit := big.NewInt(int64(len(passwordAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate password: %w", err)
+		}
+		b[i] = passwordAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// hashPassword is a plain SHA-256: the passwords are random and long
+// enough that a slow hash adds nothing
+func hashPassword(password string) []byte {
+	sum := sha256.Sum256([]byte(password))
+	return sum[:]
+}
+
+func generateID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s",
+		hex.EncodeToString(b[0:4]),
+		hex.EncodeToString(b[4:6]),
+		hex.EncodeToString(b[6:8]),
+		hex.EncodeToString(b[8:10]),
+		hex.EncodeToString(b[10:16]))
+}
@@ -0,0 +1,158 @@
+package workshop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/internal/services/auth"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T) (*Service, *auth.Service, *terminal.Service) {
+	t.Helper()
+	authService := auth.New(config.AuthConfig{JWTSecret: "secret", SessionExpiry: "1h"}, nil, zap.NewNop())
+	termService := terminal.New(config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+	}, zap.NewNop())
+	t.Cleanup(termService.Shutdown)
+
+	service, err := New(config.WorkshopConfig{
+		MaxUsers: 5,
+		Presets: map[string]config.WorkshopPreset{
+			"intro": {Command: "cat", Role: "student"},
+		},
+	}, nil, authService, termService, zap.NewNop())
+	require.NoError(t, err)
+	authService.SetDirectory(service)
+	return service, authService, termService
+}
+
+func TestProvision(t *testing.T) {
+	service, authService, termService := newTestService(t)
+	ctx := context.Background()
+
+	w, err := service.Provision(ctx, "admin", Request{
+		Name:   "Intro to Linux",
+		Count:  3,
+		Preset: "intro",
+		EndsAt: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.Len(t, w.Users, 3)
+	assert.Equal(t, "student", w.Role)
+	assert.Equal(t, w.ID[:8]+"-01", w.Users[0].Username)
+
+	u := w.Users[0]
+	assert.Len(t, u.Password, passwordLength)
+	session, ok := termService.GetSession(u.SessionID)
+	require.True(t, ok, "preset session started")
+	assert.Equal(t, u.ID, session.UserID)
+
+	// Workshop users sign in with their generated password only
+	user, err := authService.AuthenticateUser(u.Username, u.Password)
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, user.ID)
+	assert.Equal(t, "student", user.Role)
+	_, err = authService.AuthenticateUser(u.Username, "guess")
+	assert.ErrorIs(t, err, ErrBadPassword)
+
+	userID, err := authService.ValidateToken(u.Token)
+	require.NoError(t, err)
+	assert.Equal(t, u.ID, userID)
+	role, err := authService.UserRole(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "student", role)
+
+	// Secrets are only returned once
+	listed, err := service.Get(ctx, w.ID)
+	require.NoError(t, err)
+	assert.Empty(t, listed.Users[0].Password)
+	assert.Empty(t, listed.Users[0].Token)
+	assert.Nil(t, listed.Users[0].hash)
+
+	require.NoError(t, service.End(ctx, w.ID))
+	<-session.Done()
+	_, err = authService.ValidateToken(u.Token)
+	assert.Error(t, err, "tokens are revoked at teardown")
+	_, err = authService.GetUserByID(u.ID)
+	assert.ErrorIs(t, err, ErrUnknownUser)
+	_, err = service.Get(ctx, w.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestTeardownAtEnd(t *testing.T) {
+	service, _, termService := newTestService(t)
+	ctx := context.Background()
+
+	soon, err := service.Provision(ctx, "admin", Request{
+		Name:   "soon",
+		CSV:    "username,email\nada,ada@example.com\ngrace\n",
+		Preset: "intro",
+		EndsAt: time.Now().Add(time.Minute),
+	})
+	require.NoError(t, err)
+	require.Len(t, soon.Users, 2)
+	assert.Equal(t, "ada@example.com", soon.Users[0].Email)
+	later, err := service.Provision(ctx, "admin", Request{
+		Name:   "later",
+		Count:  1,
+		EndsAt: time.Now().Add(2 * time.Hour),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, later.Users[0].SessionID, "no preset, no session")
+
+	// The user's own sessions go too, not only the preset one
+	extra, err := termService.CreateSession(ctx, soon.Users[1].ID, "cat", "")
+	require.NoError(t, err)
+
+	service.tick(ctx, time.Now().Add(time.Hour))
+	<-extra.Done()
+	assert.Empty(t, termService.ListSessions(soon.Users[0].ID))
+
+	workshops, err := service.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, workshops, 1)
+	assert.Equal(t, later.ID, workshops[0].ID)
+}
+
+func TestProvisionValidates(t *testing.T) {
+	service, _, _ := newTestService(t)
+	ctx := context.Background()
+	hour := time.Now().Add(time.Hour)
+
+	for name, req := range map[string]Request{
+		"no name":        {Count: 1, EndsAt: hour},
+		"ended":          {Name: "w", Count: 1, EndsAt: time.Now().Add(-time.Minute)},
+		"too long":       {Name: "w", Count: 1, EndsAt: time.Now().Add(100 * time.Hour)},
+		"no users":       {Name: "w", EndsAt: hour},
+		"too many users": {Name: "w", Count: 6, EndsAt: hour},
+		"count and csv":  {Name: "w", Count: 1, CSV: "ada", EndsAt: hour},
+		"duplicate row":  {Name: "w", CSV: "ada\nada", EndsAt: hour},
+		"bad username":   {Name: "w", CSV: "-ada", EndsAt: hour},
+		"bad email":      {Name: "w", CSV: "ada,nowhere", EndsAt: hour},
+		"extra column":   {Name: "w", CSV: "ada,ada@example.com,admin", EndsAt: hour},
+	} {
+		_, err := service.Provision(ctx, "admin", req)
+		assert.ErrorIs(t, err, ErrInvalid, name)
+	}
+
+	_, err := service.Provision(ctx, "admin", Request{Name: "w", Count: 1, Preset: "advanced", EndsAt: hour})
+	assert.ErrorIs(t, err, ErrUnknownPreset)
+
+	_, err = service.Provision(ctx, "admin", Request{Name: "w", CSV: "ada", EndsAt: hour})
+	require.NoError(t, err)
+	_, err = service.Provision(ctx, "admin", Request{Name: "w2", CSV: "grace\nada", EndsAt: hour})
+	assert.ErrorIs(t, err, ErrLoginTaken)
+
+	_, err = New(config.WorkshopConfig{Presets: map[string]config.WorkshopPreset{
+		"root": {Role: "admin"},
+	}}, nil, nil, nil, zap.NewNop())
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+package tlsinfo
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fingerprint describes the TLS client behind a connection.
+//
+// Hash follows the JA3 recipe (version, ciphers, extensions, curves, point
+// formats) except that the extension list is left empty, because the
+// standard library does not expose it on Go 1.23. Fingerprints are stable
+// per client stack but are not comparable with published JA3 databases.
+type Fingerprint struct {
+	Hash       string `json:"hash"`
+	Raw        string `json:"raw"`
+	TLSVersion string `json:"tls_version"`
+	ServerName string `json:"server_name,omitempty"`
+	ALPN       string `json:"alpn,omitempty"`
+}
+
+// Registry remembers the fingerprint of each live TLS connection, keyed by
+// remote address, so HTTP handlers can look it up.
+type Registry struct {
+	conns sync.Map
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// GetConfigForClient is installed on tls.Config to capture ClientHellos. It
+// returns nil so the server's own configuration is used unchanged.
+func (r *Registry) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if hello.Conn != nil {
+		r.conns.Store(hello.Conn.RemoteAddr().String(), fromClientHello(hello))
+	}
+	return nil, nil
+}
+
+// ConnState is installed on http.Server to forget closed connections.
+func (r *Registry) ConnState(conn net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		// Hijacked connections (WebSockets) have already been looked up
+		r.conns.Delete(conn.RemoteAddr().String())
+	}
+}
+
+func (r *Registry) Lookup(remoteAddr string) (*Fingerprint, bool) {
+	value, ok := r.conns.Load(remoteAddr)
+	if !ok {
+		return nil, false
+	}
+	return value.(*Fingerprint), true
+}
+
+func fromClientHello(hello *tls.ClientHelloInfo) *Fingerprint {
+	version := uint16(0)
+	for _, v := range hello.SupportedVersions {
+		// Skip GREASE values (0x?a?a)
+		if v&0x0f0f == 0x0a0a {
+			continue
+		}
+		if v > version {
+			version = v
+		}
+	}
+
+	raw := strings.Join([]string{
+		strconv.Itoa(int(version)),
+		joinUint16(hello.CipherSuites),
+		"",
+		joinCurves(hello.SupportedCurves),
+		joinPoints(hello.SupportedPoints),
+	}, ",")
+	sum := md5.Sum([]byte(raw))
+
+	return &Fingerprint{
+		Hash:       hex.EncodeToString(sum[:]),
+		Raw:        raw,
+		TLSVersion: tls.VersionName(version),
+		ServerName: hello.ServerName,
+		ALPN:       strings.Join(hello.SupportedProtos, ","),
+	}
+}
+
+func joinUint16(values []uint16) string {
+	var parts []string
+	for _, v := range values {
+		if v&0x0f0f == 0x0a0a {
+			continue
+		}
+		parts = append(parts, strconv.Itoa(int(v)))
+	}
+	return strings.Join(parts, "-")
+}
+
+func joinCurves(curves []tls.CurveID) string {
+	values := make([]uint16, len(curves))
+	for i, c := range curves {
+		values[i] = uint16(c)
+	}
+	return joinUint16(values)
+}
+
+func joinPoints(points []uint8) string {
+	var parts []string
+	for _, p := range points {
+		parts = append(parts, fmt.Sprint(p))
+	}
+	return strings.Join(parts, "-")
+}
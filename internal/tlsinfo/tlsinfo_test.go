@@ -0,0 +1,27 @@
+package tlsinfo
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprintIgnoresGrease(t *testing.T) {
+	hello := &tls.ClientHelloInfo{
+		CipherSuites:      []uint16{0x0a0a, tls.TLS_AES_128_GCM_SHA256, tls.TLS_CHACHA20_POLY1305_SHA256},
+		SupportedCurves:   []tls.CurveID{0x1a1a, tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+		SupportedVersions: []uint16{0x2a2a, tls.VersionTLS13, tls.VersionTLS12},
+		ServerName:        "webtunnel.example.com",
+	}
+
+	fp := fromClientHello(hello)
+	assert.Equal(t, "772,4865-4867,,29-23,0", fp.Raw)
+	assert.Equal(t, "TLS 1.3", fp.TLSVersion)
+	assert.Len(t, fp.Hash, 32)
+
+	// Different GREASE values yield the same fingerprint
+	hello.CipherSuites[0] = 0x3a3a
+	assert.Equal(t, fp.Hash, fromClientHello(hello).Hash)
+}
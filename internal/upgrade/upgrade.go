@@ -0,0 +1,288 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Binary upgrades hand the listening sockets to a freshly started copy of
+// the executable. The new process inherits the sockets as extra files,
+// signals readiness over a pipe, and from then on accepts every new
+// connection, while the old process stops accepting and drains the
+// terminals it already has.
+
+const (
+	// envListeners lists the inherited listener addresses in file order,
+	// starting at fd 3
+	envListeners = "WEBTUNNEL_LISTENERS"
+	// envReadyFD is the pipe the new process writes to once it serves
+	envReadyFD = "WEBTUNNEL_READY_FD"
+)
+
+var (
+	ErrUpgradeInProgress = errors.New("upgrade already in progress")
+	ErrUpgraded          = errors.New("process has already been upgraded")
+)
+
+// Upgrader owns the process's listeners so they can be passed on
+type Upgrader struct {
+	logger *zap.Logger
+	// ReadyTimeout bounds how long the new process may take to start
+	// serving before the upgrade is abandoned
+	ReadyTimeout time.Duration
+	// PIDFile, when set, is rewritten by whichever process is serving, so
+	// a supervisor such as systemd can follow the upgrade
+	PIDFile string
+
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	listeners []bound
+	ready     *os.File
+	upgrading bool
+
+	exit     chan struct{}
+	exitOnce sync.Once
+}
+
+// New picks up any listeners handed over by a parent process. The handoff
+// variables are removed from the environment so terminal sessions do not
+// inherit them.
+func New(logger *zap.Logger) (*Upgrader, error) {
+	u := &Upgrader{
+		logger:       logger,
+		ReadyTimeout: time.Minute,
+		inherited:    make(map[string]*os.File),
+		exit:         make(chan struct{}),
+	}
+
+	if addrs := os.Getenv(envListeners); addrs != "" {
+		for i, addr := range strings.Split(addrs, ",") {
+			u.inherited[addr] = os.NewFile(uintptr(3+i), addr)
+		}
+	}
+	if fd := os.Getenv(envReadyFD); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", envReadyFD, err)
+		}
+		u.ready = os.NewFile(uintptr(n), "upgrade-ready")
+	}
+	os.Unsetenv(envListeners)
+	os.Unsetenv(envReadyFD)
+
+	return u, nil
+}
+
+// Inherited reports whether this process was started by an upgrade
+func (u *Upgrader) Inherited() bool {
+	return u.ready != nil
+}
+
+// Listen returns the inherited listener for addr, or opens a new one
+func (u *Upgrader) Listen(addr string) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var l net.Listener
+	if f, ok := u.inherited[addr]; ok {
+		delete(u.inherited, addr)
+		var err error
+		l, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use inherited listener for %s: %w", addr, err)
+		}
+		u.logger.Info("Using inherited listener", zap.String("addr", addr))
+	} else {
+		var err error
+		if l, err = net.Listen("tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+
+	tcp, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+		return nil, fmt.Errorf("unexpected listener type %T", l)
+	}
+	u.listeners = append(u.listeners, bound{TCPListener: tcp, addr: addr})
+	return tcp, nil
+}
+
+// bound pairs a listener with its configured address, which is what the
+// next process asks for, rather than the resolved one
+type bound struct {
+	*net.TCPListener
+	addr string
+}
+
+// Ready tells the parent process, if any, that this one is serving, and
+// closes inherited listeners nobody asked for
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for addr, f := range u.inherited {
+		u.logger.Warn("Closing unused inherited listener", zap.String("addr", addr))
+		f.Close()
+		delete(u.inherited, addr)
+	}
+
+	if u.PIDFile != "" {
+		if err := writePIDFile(u.PIDFile); err != nil {
+			return err
+		}
+	}
+
+	if u.ready == nil {
+		return nil
+	}
+	defer func() {
+		u.ready.Close()
+		u.ready = nil
+	}()
+	if _, err := u.ready.Write([]byte{1}); err != nil {
+		return fmt.Errorf("failed to signal readiness: %w", err)
+	}
+	return nil
+}
+
+// Upgrade starts a new copy of the executable with the same arguments and
+// hands it the listeners. It returns once the new process is serving, after
+// which Exit is closed; on error the current process carries on as before.
+func (u *Upgrader) Upgrade() error {
+	u.mu.Lock()
+	select {
+	case <-u.exit:
+		u.mu.Unlock()
+		return ErrUpgraded
+	default:
+	}
+	if u.upgrading {
+		u.mu.Unlock()
+		return ErrUpgradeInProgress
+	}
+	u.upgrading = true
+
+	var files []*os.File
+	var addrs []string
+	for _, l := range u.listeners {
+		f, err := l.File()
+		if err != nil {
+			closeAll(files)
+			u.upgrading = false
+			u.mu.Unlock()
+			return fmt.Errorf("failed to duplicate listener: %w", err)
+		}
+		files = append(files, f)
+		addrs = append(addrs, l.addr)
+	}
+	u.mu.Unlock()
+
+	defer func() {
+		u.mu.Lock()
+		u.upgrading = false
+		u.mu.Unlock()
+	}()
+	defer closeAll(files)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	readR, readW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer readR.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readW)
+	cmd.Env = append(os.Environ(),
+		envListeners+"="+strings.Join(addrs, ","),
+		envReadyFD+"="+strconv.Itoa(3+len(files)),
+	)
+	if err := cmd.Start(); err != nil {
+		readW.Close()
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+	readW.Close()
+	u.logger.Info("Started new process for upgrade", zap.Int("pid", cmd.Process.Pid))
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := io.ReadFull(readR, buf)
+		ready <- err
+	}()
+
+	timer := time.NewTimer(u.ReadyTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Process.Kill()
+			return fmt.Errorf("new process failed before becoming ready: %w", err)
+		}
+	case err := <-exited:
+		return fmt.Errorf("new process exited before becoming ready: %v", err)
+	case <-timer.C:
+		cmd.Process.Kill()
+		return fmt.Errorf("new process not ready after %s", u.ReadyTimeout)
+	}
+
+	u.exitOnce.Do(func() { close(u.exit) })
+	return nil
+}
+
+// Exit is closed once a new process has taken over the listeners
+func (u *Upgrader) Exit() <-chan struct{} {
+	return u.exit
+}
+
+// writePIDFile replaces the file atomically so readers never see it empty
+func writePIDFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pid-*")
+	if err != nil {
+		return fmt.Errorf("failed to write pid file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = fmt.Fprintf(tmp, "%d\n", os.Getpid())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write pid file: %w", err)
+	}
+	return nil
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,122 @@
+package upgrade
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const childModeEnv = "WEBTUNNEL_UPGRADE_TEST_CHILD"
+
+// TestMain doubles as the upgraded process: Upgrade re-executes the test
+// binary, which takes over the listener and answers one connection.
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(childModeEnv); mode != "" && os.Getenv(envListeners) != "" {
+		os.Exit(runChild(mode))
+	}
+	os.Exit(m.Run())
+}
+
+func runChild(mode string) int {
+	if mode == "fail" {
+		return 1
+	}
+
+	u, err := New(zap.NewNop())
+	if err != nil {
+		return 2
+	}
+	ln, err := u.Listen("127.0.0.1:0")
+	if err != nil {
+		return 3
+	}
+	if err := u.Ready(); err != nil {
+		return 4
+	}
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		return 5
+	}
+	conn.Write([]byte("pid " + strconv.Itoa(os.Getpid())))
+	conn.Close()
+	return 0
+}
+
+func TestUpgradeHandsOverListener(t *testing.T) {
+	t.Setenv(childModeEnv, "serve")
+
+	u, err := New(zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, u.Inherited())
+
+	ln, err := u.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+
+	require.NoError(t, u.Upgrade())
+	select {
+	case <-u.Exit():
+	default:
+		t.Fatal("Exit not closed after a successful upgrade")
+	}
+	assert.ErrorIs(t, u.Upgrade(), ErrUpgraded)
+
+	// The old process stops accepting; the same socket keeps working
+	ln.Close()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reply, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(reply), "pid "), string(reply))
+	assert.NotEqual(t, "pid "+strconv.Itoa(os.Getpid()), string(reply))
+}
+
+func TestUpgradeFailureKeepsServing(t *testing.T) {
+	t.Setenv(childModeEnv, "fail")
+
+	u, err := New(zap.NewNop())
+	require.NoError(t, err)
+	ln, err := u.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	assert.Error(t, u.Upgrade())
+	select {
+	case <-u.Exit():
+		t.Fatal("Exit closed after a failed upgrade")
+	default:
+	}
+
+	// The listener is untouched and a retry is allowed
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+	require.NoError(t, err)
+	conn.Close()
+	assert.Error(t, u.Upgrade())
+}
+
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webtunnel.pid")
+	require.NoError(t, writePIDFile(path))
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(raw))
+}
@@ -0,0 +1,12 @@
+-- Feature flags for gradual rollout of risky features
+
+CREATE TABLE IF NOT EXISTS feature_flags (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT,
+    enabled BOOLEAN DEFAULT FALSE,
+    rollout_percentage INTEGER DEFAULT 0 CHECK (rollout_percentage BETWEEN 0 AND 100),
+    user_ids TEXT[] DEFAULT '{}',
+    org_ids TEXT[] DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,16 @@
+-- Stored command snippets, owned by a user or shared across an org
+
+CREATE TABLE IF NOT EXISTS snippets (
+    id SERIAL PRIMARY KEY,
+    uuid VARCHAR(36) UNIQUE NOT NULL,
+    owner_type VARCHAR(10) NOT NULL CHECK (owner_type IN ('user', 'org')),
+    owner_id VARCHAR(255) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (owner_type, owner_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_snippets_owner ON snippets(owner_type, owner_id);
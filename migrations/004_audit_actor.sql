@@ -0,0 +1,8 @@
+-- Audit entries are attributed by application user ID, which is not always
+-- a row in users (service accounts, local mode)
+
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS actor_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_id ON audit_logs(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs(action);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_tls_fingerprint ON audit_logs((details->>'tls_fingerprint'));
@@ -0,0 +1,26 @@
+-- SSH keys for remote sessions and the host keys those hosts must present
+
+CREATE TABLE IF NOT EXISTS ssh_keys (
+    id SERIAL PRIMARY KEY,
+    uuid VARCHAR(36) UNIQUE NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    key_type VARCHAR(64) NOT NULL,
+    public_key TEXT NOT NULL,
+    fingerprint VARCHAR(128) NOT NULL,
+    private_key BYTEA, -- AES-GCM sealed; NULL for public-only uploads
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_keys_user_id ON ssh_keys(user_id);
+
+CREATE TABLE IF NOT EXISTS ssh_known_hosts (
+    host VARCHAR(255) PRIMARY KEY, -- known_hosts form: host or [host]:port
+    key_type VARCHAR(64) NOT NULL,
+    public_key TEXT NOT NULL,
+    fingerprint VARCHAR(128) NOT NULL,
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned_by VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,11 @@
+-- Terminal sessions are recorded as they start and end, so ended sessions
+-- stay listable and sessions cut off by a restart can be restored. Owners
+-- are application user IDs, which are not always rows in users.
+
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS owner_id VARCHAR(255);
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS env JSONB;
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS exit_code INTEGER;
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS node VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_terminal_sessions_owner_id ON terminal_sessions(owner_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_terminal_sessions_running ON terminal_sessions(node) WHERE status = 'running';
@@ -0,0 +1,4 @@
+-- Why a recorded session ended: exited, signal, oom, timeout, killed,
+-- shutdown or error
+
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS exit_reason VARCHAR(32);
@@ -0,0 +1,23 @@
+-- Workshops: temporary users for a class, deleted when it ends
+
+CREATE TABLE IF NOT EXISTS workshops (
+    id SERIAL PRIMARY KEY,
+    uuid VARCHAR(36) UNIQUE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    preset VARCHAR(100) NOT NULL DEFAULT '',
+    role VARCHAR(50) NOT NULL,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ends_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_workshops_ends_at ON workshops(ends_at);
+
+CREATE TABLE IF NOT EXISTS workshop_users (
+    id SERIAL PRIMARY KEY,
+    uuid VARCHAR(50) UNIQUE NOT NULL, -- workshop_<uuid>
+    workshop_id VARCHAR(36) NOT NULL REFERENCES workshops(uuid) ON DELETE CASCADE,
+    username VARCHAR(100) UNIQUE NOT NULL,
+    email VARCHAR(255) UNIQUE,
+    password_hash BYTEA NOT NULL -- SHA-256 of a random password
+);
@@ -0,0 +1,3 @@
+-- Names people give their sessions, set on create or by rename
+
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS name VARCHAR(64);
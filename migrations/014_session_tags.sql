@@ -0,0 +1,3 @@
+-- Tags for organizing sessions, a JSON array of strings
+
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS tags JSONB;
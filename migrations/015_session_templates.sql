@@ -0,0 +1,21 @@
+-- Session templates: admin-defined profiles users launch sessions from,
+-- and what a session took from one, so it can be relaunched the same way
+
+CREATE TABLE IF NOT EXISTS session_templates (
+    id SERIAL PRIMARY KEY,
+    uuid VARCHAR(36) UNIQUE NOT NULL,
+    name VARCHAR(64) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    command TEXT NOT NULL DEFAULT '',
+    working_directory TEXT NOT NULL DEFAULT '',
+    env JSONB,
+    shell TEXT NOT NULL DEFAULT '',
+    limits JSONB,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS template VARCHAR(36);
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS shell TEXT;
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS limits JSONB;
@@ -0,0 +1,49 @@
+-- Hash-chained audit entries: with audit.chain on, each entry stores the
+-- hash of the chained entry before it and its own, and chained entries
+-- can no longer be changed or deleted. Anchors record the chain head as
+-- sent to an outside service, so truncating the chain shows up too.
+
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS hash VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_chain ON audit_logs(id) WHERE hash IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION audit_logs_chained_immutable() RETURNS trigger AS $$
+BEGIN
+    IF OLD.hash IS NULL THEN
+        IF TG_OP = 'DELETE' THEN
+            RETURN OLD;
+        END IF;
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RAISE EXCEPTION 'chained audit entry % cannot be deleted', OLD.id;
+    END IF;
+    -- user_id is not hashed; it is cleared when the user is deleted
+    IF (NEW.actor_id, NEW.action, NEW.resource_type, NEW.resource_id, NEW.details,
+        NEW.ip_address, NEW.user_agent, NEW.created_at, NEW.prev_hash, NEW.hash)
+       IS DISTINCT FROM
+       (OLD.actor_id, OLD.action, OLD.resource_type, OLD.resource_id, OLD.details,
+        OLD.ip_address, OLD.user_agent, OLD.created_at, OLD.prev_hash, OLD.hash) THEN
+        RAISE EXCEPTION 'chained audit entry % cannot be changed', OLD.id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_logs_chained_immutable ON audit_logs;
+CREATE TRIGGER audit_logs_chained_immutable
+    BEFORE UPDATE OR DELETE ON audit_logs
+    FOR EACH ROW EXECUTE FUNCTION audit_logs_chained_immutable();
+
+CREATE TABLE IF NOT EXISTS audit_anchors (
+    id SERIAL PRIMARY KEY,
+    entry_id INTEGER NOT NULL,
+    entry_created_at TIMESTAMP NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    target TEXT NOT NULL,
+    receipt TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_anchors_entry_created_at ON audit_anchors(entry_created_at);
@@ -0,0 +1,14 @@
+-- Service accounts: API-key-only users for CI jobs, owned by an admin
+
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id SERIAL PRIMARY KEY,
+    uuid VARCHAR(50) UNIQUE NOT NULL, -- svc_<uuid>
+    name VARCHAR(64) UNIQUE NOT NULL,
+    owner_id VARCHAR(255) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    max_sessions INTEGER NOT NULL,
+    requests_per_minute INTEGER NOT NULL,
+    key_hash BYTEA UNIQUE NOT NULL, -- SHA-256 of the API key
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,15 @@
+-- Revoked share links. Links are signed, not stored, so a revocation names
+-- one link by share_id or every link issued up to revoked_at. Rows are
+-- deleted once the links they cover have expired anyway.
+
+CREATE TABLE IF NOT EXISTS share_revocations (
+    id SERIAL PRIMARY KEY,
+    session_id VARCHAR(100) NOT NULL,
+    share_id VARCHAR(16),
+    revoked_at TIMESTAMP,
+    forget_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_revocations_session ON share_revocations(session_id);
+CREATE INDEX IF NOT EXISTS idx_share_revocations_forget ON share_revocations(forget_at);
@@ -0,0 +1,4 @@
+-- Files kept from a scheduled run's directory, as listed in the run's
+-- result; the files themselves live in the artifact directory
+
+ALTER TABLE schedule_runs ADD COLUMN IF NOT EXISTS artifacts JSONB;
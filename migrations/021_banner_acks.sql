@@ -0,0 +1,11 @@
+-- Acknowledgments of the session banner. Banner IDs change with the text,
+-- so a new text is acknowledged afresh.
+
+CREATE TABLE IF NOT EXISTS banner_acks (
+    user_id VARCHAR(255) NOT NULL,
+    banner_id VARCHAR(16) NOT NULL,
+    acknowledged_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, banner_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_banner_acks_banner ON banner_acks(banner_id, acknowledged_at);
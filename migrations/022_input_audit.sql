@@ -0,0 +1,15 @@
+-- Everything typed into sessions, when input auditing is on: raw input as
+-- written to the PTY and the command lines reconstructed from it.
+
+CREATE TABLE IF NOT EXISTS input_audit (
+    id BIGSERIAL PRIMARY KEY,
+    session_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    data TEXT NOT NULL,
+    approximate BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_input_audit_session ON input_audit(session_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_input_audit_created ON input_audit(created_at);
@@ -0,0 +1,8 @@
+-- The shell each user's sessions start in and whether as a login shell;
+-- NULL uses the server's defaults. Sessions record theirs to relaunch
+-- with it.
+
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS shell TEXT;
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS login_shell BOOLEAN;
+
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS login_shell BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+-- The legacy charset a session's programs speak, such as ISO-8859-1 or
+-- Shift_JIS; NULL for UTF-8. Sessions record theirs to relaunch with it.
+
+ALTER TABLE terminal_sessions ADD COLUMN IF NOT EXISTS charset TEXT;
@@ -0,0 +1,6 @@
+-- The org each user belongs to, which feature flags, snippets, banners,
+-- branding, policy and file ACLs can target; NULL for none
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS org_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_users_org_id ON users(org_id) WHERE org_id IS NOT NULL;
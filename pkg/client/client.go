@@ -0,0 +1,567 @@
+// Package client is a Go client for a webtunnel server. It manages
+// sessions over the REST API and attaches to them over WebSocket.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+// Client calls one webtunnel server with a bearer token
+type Client struct {
+	baseURL    string
+	token      string
+	HTTPClient *http.Client
+	Dialer     *websocket.Dialer
+}
+
+// New returns a client for the server at baseURL, e.g.
+// "https://tunnel.example.com"
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		HTTPClient: http.DefaultClient,
+		Dialer:     websocket.DefaultDialer,
+	}
+}
+
+// APIError is a non-2xx response from the server. Code is set for
+// refused attaches, session access errors and 429s; see the protocol Code
+// constants. A 429 also has the Limit that refused the request.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Code       string
+	Limit      *protocol.Limit
+
+	// RetryAfter is the response's Retry-After, if it had one in seconds
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("webtunnel: %d %s", e.StatusCode, e.Message)
+}
+
+// AttachError is returned by Receive when the server refused to attach the
+// connection, e.g. because the session exited before the upgrade finished
+type AttachError struct {
+	Code    string
+	Message string
+}
+
+func (e *AttachError) Error() string {
+	return fmt.Sprintf("webtunnel: attach failed: %s (%s)", e.Message, e.Code)
+}
+
+// Session is a terminal session as reported by the server
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Name       string    `json:"name,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Command    string    `json:"command"`
+	WorkingDir string    `json:"working_dir"`
+	Template   string    `json:"template,omitempty"` // ID of the template it was started from
+	Charset    string    `json:"charset,omitempty"`  // transcoded to and from UTF-8; empty for UTF-8
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastActive time.Time `json:"last_active"`
+	Presenting bool      `json:"presenting"`
+
+	// Driver is the user with control of a shared session; empty lets
+	// anyone with write access type
+	Driver       string                 `json:"driver,omitempty"`
+	Participants []protocol.Participant `json:"participants,omitempty"`
+
+	// Attached connections and output averaged over the last 10 seconds
+	Viewers           int        `json:"viewers"`
+	OutputBytesPerSec float64    `json:"output_bytes_per_sec"`
+	LastOutputAt      *time.Time `json:"last_output_at,omitempty"`
+	LastInputAt       *time.Time `json:"last_input_at,omitempty"`
+
+	// The title its programs set, and the command line running in the
+	// foreground, e.g. "vim main.go"; see protocol.Title
+	Title          string `json:"title,omitempty"`
+	CurrentCommand string `json:"current_command,omitempty"`
+
+	// Bytes written to and read from the session's PTY since it started
+	InputBytes  int64 `json:"input_bytes"`
+	OutputBytes int64 `json:"output_bytes"`
+
+	// Set once the session has ended; see protocol.Exit
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	ExitReason string     `json:"exit_reason,omitempty"`
+	ExitSignal string     `json:"exit_signal,omitempty"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+}
+
+// CreateRequest describes a new session. Cols and Rows size the PTY
+// before the command starts. TemplateID, a template's ID or name, starts
+// the session from an admin-defined template instead of Command.
+// ResizePolicy is smallest, driver or fixed; empty uses the server's.
+type CreateRequest struct {
+	Command      string            `json:"command"`
+	Name         string            `json:"name,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	WorkingDir   string            `json:"working_dir,omitempty"`
+	TemplateID   string            `json:"template_id,omitempty"`
+	Cols         uint16            `json:"cols,omitempty"`
+	Rows         uint16            `json:"rows,omitempty"`
+	ResizePolicy string            `json:"resize_policy,omitempty"`
+	Shell        string            `json:"shell,omitempty"`       // one of the server's allowed shells, e.g. "zsh"
+	LoginShell   *bool             `json:"login_shell,omitempty"` // start the shell with -l
+	Env          map[string]string `json:"env,omitempty"`         // variables for the session, within the server's allowed_env_vars
+	Charset      string            `json:"charset,omitempty"`     // what the session's programs speak, e.g. "Shift_JIS"; UTF-8 if empty
+}
+
+// CreateSession starts a command in a new session
+func (c *Client) CreateSession(ctx context.Context, req CreateRequest) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, http.MethodPost, "/sessions", req, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListSessions returns the caller's sessions
+func (c *Client) ListSessions(ctx context.Context) ([]Session, error) {
+	return c.listSessions(ctx, url.Values{})
+}
+
+// FindSessions returns the caller's sessions in the given status ("" for
+// any) that carry every one of tags
+func (c *Client) FindSessions(ctx context.Context, status string, tags ...string) ([]Session, error) {
+	query := url.Values{"tag": tags}
+	if status != "" {
+		query.Set("status", status)
+	}
+	return c.listSessions(ctx, query)
+}
+
+// listSessions follows the list's cursors to collect every page
+func (c *Client) listSessions(ctx context.Context, query url.Values) ([]Session, error) {
+	query.Set("limit", "500")
+	sessions := []Session{}
+	for {
+		var resp struct {
+			Sessions   []Session `json:"sessions"`
+			NextCursor string    `json:"next_cursor"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/sessions?"+query.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, resp.Sessions...)
+		if resp.NextCursor == "" {
+			return sessions, nil
+		}
+		query.Set("cursor", resp.NextCursor)
+	}
+}
+
+// GetSession returns one session
+func (c *Client) GetSession(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, http.MethodGet, "/sessions/"+url.PathEscape(id), nil, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RenameSession gives a session a new name; an empty name clears it
+func (c *Client) RenameSession(ctx context.Context, id, name string) (*Session, error) {
+	var session Session
+	body := map[string]string{"name": name}
+	if err := c.do(ctx, http.MethodPatch, "/sessions/"+url.PathEscape(id), body, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// TagSession replaces a session's tags; no tags removes them all
+func (c *Client) TagSession(ctx context.Context, id string, tags ...string) (*Session, error) {
+	var session Session
+	body := map[string][]string{"tags": append([]string{}, tags...)}
+	if err := c.do(ctx, http.MethodPatch, "/sessions/"+url.PathEscape(id), body, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// KillSession terminates a session
+func (c *Client) KillSession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(id), nil, nil)
+}
+
+// Presence returns who is attached to a session and who may type
+func (c *Client) Presence(ctx context.Context, id string) (*protocol.Presence, error) {
+	return c.control(ctx, http.MethodGet, id, "/presence", nil)
+}
+
+// RequestControl asks for a session's control. It is granted at once when
+// nobody holds it; otherwise Requests lists the caller until the driver or
+// the owner grants it.
+func (c *Client) RequestControl(ctx context.Context, id string) (*protocol.Presence, error) {
+	return c.control(ctx, http.MethodPost, id, "/control", nil)
+}
+
+// GrantControl hands a session's control to another user
+func (c *Client) GrantControl(ctx context.Context, id, userID string) (*protocol.Presence, error) {
+	return c.control(ctx, http.MethodPost, id, "/control/grant", map[string]string{"user_id": userID})
+}
+
+// ReleaseControl gives up a session's control
+func (c *Client) ReleaseControl(ctx context.Context, id string) (*protocol.Presence, error) {
+	return c.control(ctx, http.MethodDelete, id, "/control", nil)
+}
+
+func (c *Client) control(ctx context.Context, method, id, path string, body interface{}) (*protocol.Presence, error) {
+	var presence protocol.Presence
+	if err := c.do(ctx, method, "/sessions/"+url.PathEscape(id)+path, body, &presence); err != nil {
+		return nil, err
+	}
+	return &presence, nil
+}
+
+// SizeInfo is a session's terminal size, the policy that picks it and its
+// recent changes, oldest first
+type SizeInfo struct {
+	Cols    uint16       `json:"cols"`
+	Rows    uint16       `json:"rows"`
+	Policy  string       `json:"policy"`
+	History []SizeChange `json:"history"`
+}
+
+// SizeChange is one change of a session's size. Reason is started,
+// reported, control, left or policy.
+type SizeChange struct {
+	At     time.Time `json:"at"`
+	Cols   uint16    `json:"cols"`
+	Rows   uint16    `json:"rows"`
+	Policy string    `json:"policy"`
+	Reason string    `json:"reason"`
+	UserID string    `json:"user_id,omitempty"`
+}
+
+// Size returns a session's terminal size and how it got there
+func (c *Client) Size(ctx context.Context, id string) (*SizeInfo, error) {
+	var size SizeInfo
+	if err := c.do(ctx, http.MethodGet, "/sessions/"+url.PathEscape(id)+"/size", nil, &size); err != nil {
+		return nil, err
+	}
+	return &size, nil
+}
+
+// SetResizePolicy changes how a session's size is picked when several
+// clients attach; empty goes back to the server's policy. With the fixed
+// policy, non-zero cols and rows set the size to keep.
+func (c *Client) SetResizePolicy(ctx context.Context, id, policy string, cols, rows uint16) (*SizeInfo, error) {
+	var size SizeInfo
+	body := map[string]interface{}{"policy": policy, "cols": cols, "rows": rows}
+	if err := c.do(ctx, http.MethodPut, "/sessions/"+url.PathEscape(id)+"/size", body, &size); err != nil {
+		return nil, err
+	}
+	return &size, nil
+}
+
+// Action is something the caller may do, as listed for a command palette:
+// a request of Method to Path with Body, or a Frame to send on an attached
+// session, once the Params are filled in. Kind is new-session,
+// start-template, connect-host or run-snippet.
+type Action struct {
+	ID          string                 `json:"id"`
+	Kind        string                 `json:"kind"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	Method      string                 `json:"method,omitempty"`
+	Path        string                 `json:"path,omitempty"`
+	Body        map[string]interface{} `json:"body,omitempty"`
+	Frame       map[string]string      `json:"frame,omitempty"`
+	Params      []ActionParam          `json:"params,omitempty"`
+}
+
+// ActionParam is a value an Action needs. In is body, naming a field of
+// the body with dots for nested ones, or session, for the session a Frame
+// is sent on.
+type ActionParam struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Actions lists what the caller may do, optionally of one kind and
+// matching query
+func (c *Client) Actions(ctx context.Context, kind, query string) ([]Action, error) {
+	values := url.Values{}
+	if kind != "" {
+		values.Set("kind", kind)
+	}
+	if query != "" {
+		values.Set("q", query)
+	}
+	var resp struct {
+		Actions []Action `json:"actions"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/actions?"+values.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Actions, nil
+}
+
+// ExecRequest describes a one-shot command. Timeout is a duration such as
+// "10s"; empty uses the server's default.
+type ExecRequest struct {
+	Command    string `json:"command"`
+	WorkingDir string `json:"working_dir,omitempty"`
+	Stdin      string `json:"stdin,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+}
+
+// ExecResult is how a one-shot command ended and what it wrote
+type ExecResult struct {
+	ID         string `json:"id"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exit_code"`
+	Signal     string `json:"signal,omitempty"`
+	TimedOut   bool   `json:"timed_out"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Exec runs a command without a session and waits for it. A command that
+// fails is not an error; check ExitCode and TimedOut.
+func (c *Client) Exec(ctx context.Context, req ExecRequest) (*ExecResult, error) {
+	var result ExecResult
+	if err := c.do(ctx, http.MethodPost, "/exec", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api/v1"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("webtunnel: invalid response: %w", err)
+	}
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string          `json:"error"`
+		Code  string          `json:"code"`
+		Limit *protocol.Limit `json:"limit"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body)
+	if body.Error == "" {
+		body.Error = http.StatusText(resp.StatusCode)
+	}
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: body.Error, Code: body.Code, Limit: body.Limit}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// Conn is an attached terminal. Receive must be called in a loop to see
+// output; it answers latency probes itself.
+type Conn struct {
+	ws        *websocket.Conn
+	sessionID string
+	writeMu   sync.Mutex
+}
+
+// AttachOptions shape what the server sends on a new Conn
+type AttachOptions struct {
+	// Since replays output from a bookmark name or byte offset rather than
+	// the whole buffer
+	Since string
+
+	// MaxFrame caps the bytes of output in one frame (256 to 1 MiB); zero
+	// leaves it to the server
+	MaxFrame int
+
+	// FlushInterval has the server coalesce output and send it at most
+	// this often (up to a second); zero sends it as it arrives
+	FlushInterval time.Duration
+
+	// ReadOnly watches without being able to send input or resize, even
+	// when the user could
+	ReadOnly bool
+}
+
+// Attach opens a WebSocket to a session. The server replays recent output
+// first, so a new Conn sees the current screen.
+func (c *Client) Attach(ctx context.Context, sessionID string) (*Conn, error) {
+	return c.AttachWith(ctx, sessionID, AttachOptions{})
+}
+
+// AttachWith opens a WebSocket to a session with the given replay anchor,
+// output framing and mode
+func (c *Client) AttachWith(ctx context.Context, sessionID string, opts AttachOptions) (*Conn, error) {
+	u, err := url.Parse(c.baseURL + "/api/v1/sessions/" + url.PathEscape(sessionID) + "/stream")
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	if opts.Since != "" {
+		query.Set("since", opts.Since)
+	}
+	if opts.MaxFrame > 0 {
+		query.Set("max_frame", strconv.Itoa(opts.MaxFrame))
+	}
+	if opts.FlushInterval > 0 {
+		query.Set("flush_interval", opts.FlushInterval.String())
+	}
+	if opts.ReadOnly {
+		query.Set("mode", "read-only")
+	}
+	u.RawQuery = query.Encode()
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+c.token)
+	header.Set("Sec-WebSocket-Protocol", protocol.Subprotocol(protocol.LatestVersion))
+	ws, resp, err := c.Dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				return nil, responseError(resp)
+			}
+		}
+		return nil, err
+	}
+	return &Conn{ws: ws, sessionID: sessionID}, nil
+}
+
+// Version is the protocol version the server picked for the connection;
+// protocol.Version0 from servers that do not negotiate
+func (c *Conn) Version() int {
+	version, _ := protocol.ParseSubprotocol(c.ws.Subprotocol())
+	return version
+}
+
+// Receive returns the next frame from the server, or an *AttachError if
+// the server refused the connection
+func (c *Conn) Receive() (protocol.Message, error) {
+	for {
+		var msg protocol.Message
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			return protocol.Message{}, err
+		}
+		if msg.Type == protocol.TypeAttachError {
+			var attachErr protocol.AttachError
+			if err := msg.Decode(&attachErr); err != nil {
+				return protocol.Message{}, fmt.Errorf("webtunnel: invalid attach error: %w", err)
+			}
+			return protocol.Message{}, &AttachError{Code: attachErr.Code, Message: attachErr.Message}
+		}
+		if msg.Type == protocol.TypeProbe {
+			if err := c.Send(protocol.Message{Type: protocol.TypeProbe, Data: msg.Data}); err != nil {
+				return protocol.Message{}, err
+			}
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// Send writes a raw frame. It is safe to call from several goroutines.
+func (c *Conn) Send(msg protocol.Message) error {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	msg.SessionID = c.sessionID
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteJSON(msg)
+}
+
+// Input types data into the terminal
+func (c *Conn) Input(data string) error {
+	return c.Send(protocol.Message{Type: protocol.TypeInput, Data: data})
+}
+
+// Resize changes the terminal size
+func (c *Conn) Resize(cols, rows int) error {
+	msg, err := protocol.NewMessage(protocol.TypeResize, protocol.Resize{Cols: cols, Rows: rows})
+	if err != nil {
+		return err
+	}
+	return c.Send(msg)
+}
+
+// Search asks for matches in the scrollback; they arrive as a
+// TypeSearchResults message from Receive
+func (c *Conn) Search(search protocol.Search) error {
+	msg, err := protocol.NewMessage(protocol.TypeSearch, search)
+	if err != nil {
+		return err
+	}
+	return c.Send(msg)
+}
+
+// Mark drops a bookmark at the current output offset; every attached
+// client receives it as a TypeBookmark message
+func (c *Conn) Mark(name string) error {
+	return c.Send(protocol.Message{Type: protocol.TypeMark, Data: name})
+}
+
+// Close detaches from the session, leaving it running
+func (c *Conn) Close() error {
+	c.writeMu.Lock()
+	c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.writeMu.Unlock()
+	return c.ws.Close()
+}
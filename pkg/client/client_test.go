@@ -0,0 +1,419 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"github.com/yourusername/webtunnel/pkg/terminal"
+	"go.uber.org/zap"
+)
+
+// newTestServer serves the session routes the client uses, backed by a
+// real terminal service
+func newTestServer(t *testing.T) (*httptest.Server, *terminal.Service) {
+	t.Helper()
+
+	service := terminal.New(config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}, zap.NewNop())
+	t.Cleanup(service.Shutdown)
+
+	authed := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Invalid token"})
+				return
+			}
+			next(w, r)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/sessions", authed(func(w http.ResponseWriter, r *http.Request) {
+		var req CreateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		session, err := service.CreateSessionWithOptions(r.Context(), "user123", terminal.CreateOptions{
+			Command:    req.Command,
+			Name:       req.Name,
+			Tags:       req.Tags,
+			WorkingDir: req.WorkingDir,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(session)
+	}))
+	mux.HandleFunc("GET /api/v1/sessions", authed(func(w http.ResponseWriter, r *http.Request) {
+		filter := terminal.SessionFilter{
+			Tags:   r.URL.Query()["tag"],
+			Status: terminal.Status(r.URL.Query().Get("status")),
+		}
+		// Small pages, so the client has to follow cursors
+		list, err := terminal.SessionPage{Limit: 1, Cursor: r.URL.Query().Get("cursor")}.Page(service.FindSessions("user123", filter))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+	mux.HandleFunc("GET /api/v1/sessions/{id}", authed(func(w http.ResponseWriter, r *http.Request) {
+		session, exists := service.GetSession(r.PathValue("id"))
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Session not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(session)
+	}))
+	mux.HandleFunc("PATCH /api/v1/sessions/{id}", authed(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name *string   `json:"name"`
+			Tags *[]string `json:"tags"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		session, err := service.Update(r.PathValue("id"), terminal.SessionUpdate{Name: req.Name, Tags: req.Tags})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(session)
+	}))
+	mux.HandleFunc("DELETE /api/v1/sessions/{id}", authed(func(w http.ResponseWriter, r *http.Request) {
+		if err := service.KillSession(r.PathValue("id")); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Session deleted"})
+	}))
+	mux.HandleFunc("POST /api/v1/exec", authed(func(w http.ResponseWriter, r *http.Request) {
+		var req ExecRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		timeout, _ := time.ParseDuration(req.Timeout)
+		result, err := service.Exec(r.Context(), "user123", terminal.ExecOptions{
+			Command: req.Command,
+			Stdin:   req.Stdin,
+			Timeout: timeout,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	mux.HandleFunc("GET /api/v1/sessions/{id}/stream", authed(func(w http.ResponseWriter, r *http.Request) {
+		negotiation, err := service.Negotiate(websocket.Subprotocols(r), terminal.NegotiationInfo{Transport: "websocket"})
+		if err != nil {
+			w.WriteHeader(http.StatusUpgradeRequired)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, http.Header{"Sec-WebSocket-Protocol": {negotiation.Subprotocol()}})
+		if err != nil {
+			return
+		}
+		if err := service.AttachWebSocketAs(r.PathValue("id"), "user123", conn); err != nil {
+			conn.Close()
+		}
+	}))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, service
+}
+
+func TestClient(t *testing.T) {
+	server, _ := newTestServer(t)
+	ctx := context.Background()
+
+	t.Run("rejects bad token", func(t *testing.T) {
+		_, err := New(server.URL, "wrong").ListSessions(ctx)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+		assert.Equal(t, "Invalid token", apiErr.Message)
+	})
+
+	t.Run("session lifecycle", func(t *testing.T) {
+		c := New(server.URL+"/", "secret")
+
+		session, err := c.CreateSession(ctx, CreateRequest{Command: "/bin/cat", Name: "logs"})
+		require.NoError(t, err)
+		assert.Equal(t, "/bin/cat", session.Command)
+		assert.Equal(t, "logs", session.Name)
+		assert.Equal(t, "running", session.Status)
+
+		renamed, err := c.RenameSession(ctx, session.ID, "api logs")
+		require.NoError(t, err)
+		assert.Equal(t, "api logs", renamed.Name)
+
+		tagged, err := c.TagSession(ctx, session.ID, "team-api", "logs")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"logs", "team-api"}, tagged.Tags)
+		assert.Equal(t, "api logs", tagged.Name)
+		found, err := c.FindSessions(ctx, "running", "logs")
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		found, err = c.FindSessions(ctx, "", "build")
+		require.NoError(t, err)
+		assert.Empty(t, found)
+
+		sessions, err := c.ListSessions(ctx)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, session.ID, sessions[0].ID)
+
+		// Every page is collected
+		second, err := c.CreateSession(ctx, CreateRequest{Command: "/bin/cat"})
+		require.NoError(t, err)
+		sessions, err = c.ListSessions(ctx)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		require.NoError(t, c.KillSession(ctx, second.ID))
+
+		require.NoError(t, c.KillSession(ctx, session.ID))
+		sessions, err = c.ListSessions(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+
+	t.Run("exec", func(t *testing.T) {
+		c := New(server.URL, "secret")
+		result, err := c.Exec(ctx, ExecRequest{Command: "tr a-z A-Z; exit 2", Stdin: "shout", Timeout: "5s"})
+		require.NoError(t, err)
+		assert.Equal(t, "SHOUT", result.Stdout)
+		assert.Equal(t, 2, result.ExitCode)
+		assert.False(t, result.TimedOut)
+
+		_, err = c.Exec(ctx, ExecRequest{})
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	})
+
+	t.Run("attach", func(t *testing.T) {
+		c := New(server.URL, "secret")
+		session, err := c.CreateSession(ctx, CreateRequest{Command: "/bin/cat"})
+		require.NoError(t, err)
+		defer c.KillSession(ctx, session.ID)
+
+		conn, err := c.Attach(ctx, session.ID)
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Equal(t, protocol.LatestVersion, conn.Version())
+
+		require.NoError(t, conn.Resize(120, 40))
+		require.NoError(t, conn.Input("hello client\n"))
+
+		var output strings.Builder
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(output.String(), "hello client") && time.Now().Before(deadline) {
+			msg, err := conn.Receive()
+			require.NoError(t, err)
+			if msg.Type == protocol.TypeOutput {
+				output.WriteString(msg.Data)
+			}
+		}
+		assert.Contains(t, output.String(), "hello client")
+	})
+
+	t.Run("exit", func(t *testing.T) {
+		c := New(server.URL, "secret")
+		session, err := c.CreateSession(ctx, CreateRequest{Command: "read line; echo bye; exit 4"})
+		require.NoError(t, err)
+		defer c.KillSession(ctx, session.ID)
+
+		conn, err := c.Attach(ctx, session.ID)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.Input("go\n"))
+
+		// The exit frame follows the last output
+		var output strings.Builder
+		var exit protocol.Exit
+		for {
+			msg, err := conn.Receive()
+			require.NoError(t, err)
+			if msg.Type == protocol.TypeOutput {
+				output.WriteString(msg.Data)
+			}
+			if msg.Type == protocol.TypeExit {
+				require.NoError(t, msg.Decode(&exit))
+				break
+			}
+		}
+		assert.Contains(t, output.String(), "bye")
+		assert.Equal(t, "exited", exit.Reason)
+		require.NotNil(t, exit.Code)
+		assert.Equal(t, 4, *exit.Code)
+
+		ended, err := c.GetSession(ctx, session.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "stopped", ended.Status)
+		assert.Equal(t, "exited", ended.ExitReason)
+		require.NotNil(t, ended.ExitCode)
+		assert.Equal(t, 4, *ended.ExitCode)
+	})
+
+	t.Run("attach to killed session", func(t *testing.T) {
+		c := New(server.URL, "secret")
+		session, err := c.CreateSession(ctx, CreateRequest{Command: "/bin/cat"})
+		require.NoError(t, err)
+		require.NoError(t, c.KillSession(ctx, session.ID))
+
+		conn, err := c.Attach(ctx, session.ID)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Receive()
+		var attachErr *AttachError
+		require.ErrorAs(t, err, &attachErr)
+		assert.Equal(t, protocol.CodeSessionNotFound, attachErr.Code)
+
+		// The socket is closed with the matching close code
+		_, _, err = conn.ws.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, 4404), "got %v", err)
+	})
+
+	t.Run("attach with bad token", func(t *testing.T) {
+		_, err := New(server.URL, "wrong").Attach(ctx, "missing")
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	})
+}
+
+func TestLimitError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "user has reached maximum session limit (2)",
+			"code":  protocol.CodeQuotaExceeded,
+			"limit": protocol.Limit{Type: protocol.LimitUserSessions, Limit: 2, Used: 2, RetryAfter: 30, Increase: "https://help.example.com/quota"},
+		})
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL, "secret").CreateSession(context.Background(), CreateRequest{Command: "bash"})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	assert.Equal(t, protocol.CodeQuotaExceeded, apiErr.Code)
+	assert.Equal(t, 30*time.Second, apiErr.RetryAfter)
+	require.NotNil(t, apiErr.Limit)
+	assert.Equal(t, protocol.LimitUserSessions, apiErr.Limit.Type)
+	assert.Equal(t, "https://help.example.com/quota", apiErr.Limit.Increase)
+}
+
+func TestTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"sessions": []Session{}})
+	}))
+	server.StartTLS()
+	defer server.Close()
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	list := func(opts TLSOptions) error {
+		c := New(server.URL, "secret")
+		if err := c.SetTLS(opts); err != nil {
+			return err
+		}
+		_, err := c.ListSessions(ctx)
+		return err
+	}
+
+	t.Run("self-signed needs its CA", func(t *testing.T) {
+		err := list(TLSOptions{})
+		var unknown x509.UnknownAuthorityError
+		assert.ErrorAs(t, err, &unknown)
+		assert.NoError(t, list(TLSOptions{CAFile: caFile}))
+	})
+
+	t.Run("pins", func(t *testing.T) {
+		pin := Pin(server.Certificate())
+		assert.NoError(t, list(TLSOptions{CAFile: caFile, Pins: []string{pin}}))
+		// Pinning alone is enough for a self-signed certificate
+		assert.NoError(t, list(TLSOptions{InsecureSkipVerify: true, Pins: []string{pin}}))
+
+		other := "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, 32))
+		assert.ErrorIs(t, list(TLSOptions{CAFile: caFile, Pins: []string{other}}), ErrPinMismatch)
+		assert.ErrorIs(t, list(TLSOptions{InsecureSkipVerify: true, Pins: []string{other}}), ErrPinMismatch)
+		assert.Error(t, list(TLSOptions{Pins: []string{"md5/abc"}}))
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "webtunnel-client"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+		require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+		clientCert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		mtls := httptest.NewUnstartedServer(server.Config.Handler)
+		mtls.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+		mtls.TLS.ClientCAs.AddCert(clientCert)
+		mtls.StartTLS()
+		defer mtls.Close()
+		mtlsCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mtls.Certificate().Raw})
+
+		c := New(mtls.URL, "secret")
+		require.NoError(t, c.SetTLS(TLSOptions{CAPEM: mtlsCA}))
+		_, err = c.ListSessions(ctx)
+		assert.Error(t, err)
+
+		require.NoError(t, c.SetTLS(TLSOptions{CAPEM: mtlsCA, CertFile: certFile, KeyFile: keyFile}))
+		_, err = c.ListSessions(ctx)
+		assert.NoError(t, err)
+
+		assert.Error(t, c.SetTLS(TLSOptions{CertFile: certFile}))
+	})
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrPinMismatch is returned when none of the server's certificates match a
+// pin
+var ErrPinMismatch = errors.New("webtunnel: server certificate does not match any pin")
+
+// TLSOptions decide how the client trusts the server and, for mTLS, how it
+// identifies itself. The zero value verifies against the system roots.
+type TLSOptions struct {
+	// CAFile and CAPEM are PEM bundles of the CAs to trust instead of the
+	// system roots, e.g. an internal deployment's own CA
+	CAFile string
+	CAPEM  []byte
+
+	// Pins are SHA-256 hashes of the public keys the server may present,
+	// as "sha256/<base64>" (see Pin). A connection succeeds only if some
+	// certificate in the server's chain matches one, in addition to the
+	// usual verification.
+	Pins []string
+
+	// CertFile and KeyFile are the PEM client certificate and key to send
+	// when the server asks for one
+	CertFile string
+	KeyFile  string
+
+	// InsecureSkipVerify accepts any certificate chain. Pins are still
+	// checked, which makes pinning a self-signed certificate without its
+	// CA possible; without pins the connection is open to interception.
+	InsecureSkipVerify bool
+}
+
+// Pin returns the pin for a certificate: the SHA-256 hash of its public
+// key, as "sha256/<base64>". It is the same as
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Config builds the tls.Config the options describe
+func (o TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" || len(o.CAPEM) > 0 {
+		pool := x509.NewCertPool()
+		if o.CAFile != "" {
+			data, err := os.ReadFile(o.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("webtunnel: failed to read CA bundle: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("webtunnel: no certificates in CA bundle %s", o.CAFile)
+			}
+		}
+		if len(o.CAPEM) > 0 && !pool.AppendCertsFromPEM(o.CAPEM) {
+			return nil, fmt.Errorf("webtunnel: no certificates in CA PEM")
+		}
+		config.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, fmt.Errorf("webtunnel: a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("webtunnel: failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.Pins) > 0 {
+		pins := make(map[string]bool, len(o.Pins))
+		for _, pin := range o.Pins {
+			if !strings.HasPrefix(pin, "sha256/") {
+				return nil, fmt.Errorf("webtunnel: invalid pin %q; want sha256/<base64>", pin)
+			}
+			if sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/")); err != nil || len(sum) != sha256.Size {
+				return nil, fmt.Errorf("webtunnel: invalid pin %q; want sha256/<base64>", pin)
+			}
+			pins[pin] = true
+		}
+		// VerifyConnection runs after chain verification, and on its own
+		// when that is skipped
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, cert := range state.PeerCertificates {
+				if pins[Pin(cert)] {
+					return nil
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+	return config, nil
+}
+
+// SetTLS replaces the client's HTTP client and WebSocket dialer with ones
+// using the options, for the REST API and attaches alike
+func (c *Client) SetTLS(opts TLSOptions) error {
+	config, err := opts.Config()
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	c.HTTPClient = &http.Client{Transport: transport}
+	c.Dialer = &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+		TLSClientConfig:  config,
+	}
+	return nil
+}
@@ -0,0 +1,823 @@
+// Package config holds the webtunnel configuration types and loads them
+// from a YAML file and WEBTUNNEL_ environment variables.
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	Server      ServerConfig      `mapstructure:"server"`
+	Database    DatabaseConfig    `mapstructure:"database"`
+	Redis       RedisConfig       `mapstructure:"redis"`
+	Auth        AuthConfig        `mapstructure:"auth"`
+	Session     SessionConfig     `mapstructure:"session"`
+	Flags       FlagsConfig       `mapstructure:"flags"`
+	Notify      NotifyConfig      `mapstructure:"notify"`
+	Policy      PolicyConfig      `mapstructure:"policy"`
+	Push        PushConfig        `mapstructure:"push"`
+	Bandwidth   BandwidthConfig   `mapstructure:"bandwidth"`
+	Maintenance MaintenanceConfig `mapstructure:"maintenance"`
+	Janitor     JanitorConfig     `mapstructure:"janitor"`
+	Status      StatusConfig      `mapstructure:"status"`
+	Timeouts    TimeoutsConfig    `mapstructure:"timeouts"`
+	RTC         RTCConfig         `mapstructure:"rtc"`
+	Snapshots   SnapshotsConfig   `mapstructure:"snapshots"`
+	Startup     StartupConfig     `mapstructure:"startup"`
+	LoadShed    LoadShedConfig    `mapstructure:"load_shed"`
+	Uploads     UploadsConfig     `mapstructure:"uploads"`
+	Shares      SharesConfig      `mapstructure:"shares"`
+	Abuse       AbuseConfig       `mapstructure:"abuse"`
+	Permissions PermissionsConfig `mapstructure:"permissions"`
+	SSH         SSHConfig         `mapstructure:"ssh"`
+	FileACL     FileACLConfig     `mapstructure:"file_acl"`
+	Sandbox     SandboxConfig     `mapstructure:"sandbox"`
+	Workshop    WorkshopConfig    `mapstructure:"workshop"`
+	Elevation   ElevationConfig   `mapstructure:"elevation"`
+	Identity    IdentityConfig    `mapstructure:"identity"`
+	Audit       AuditConfig       `mapstructure:"audit"`
+	Schedules   []ScheduleConfig  `mapstructure:"schedules"`
+
+	ServiceAccounts ServiceAccountsConfig `mapstructure:"service_accounts"`
+	Artifacts       ArtifactsConfig       `mapstructure:"artifacts"`
+	Banner          BannerConfig          `mapstructure:"banner"`
+	InputAudit      InputAuditConfig      `mapstructure:"input_audit"`
+	FileSearch      FileSearchConfig      `mapstructure:"file_search"`
+	Branding        BrandingConfig        `mapstructure:"branding"`
+}
+
+// Deployment environments for server.environment. Only development runs
+// gin in debug mode and turns on pprof and verbose request logs by default.
+const (
+	EnvDevelopment = "development"
+	EnvStaging     = "staging"
+	EnvProduction  = "production"
+)
+
+type ServerConfig struct {
+	Environment  string `mapstructure:"environment"` // development, staging or production
+	Host         string `mapstructure:"host"`
+	Port         int    `mapstructure:"port"`
+	TLS          bool   `mapstructure:"tls"`
+	CertFile     string `mapstructure:"cert_file"`
+	KeyFile      string `mapstructure:"key_file"`
+	StaticDir    string `mapstructure:"static_dir"`
+	AllowOrigins []string `mapstructure:"allow_origins"`
+	EnablePprof  bool     `mapstructure:"enable_pprof"` // served under /api/v1/admin/debug/pprof
+	VerboseLogs  bool     `mapstructure:"verbose_logs"` // log request queries and user agents
+	PIDFile      string   `mapstructure:"pid_file"`
+	DrainTimeout string   `mapstructure:"drain_timeout"` // how long an upgraded process keeps its terminals
+	QuotaHelp    string   `mapstructure:"quota_help"`    // how to ask for a higher limit, e.g. a URL; sent with every 429
+
+	AccessLog AccessLogConfig `mapstructure:"access_log"`
+}
+
+// AccessLogConfig writes access logs for tools that only take the Common
+// or Combined Log Format, next to the structured request logs
+type AccessLogConfig struct {
+	Format string `mapstructure:"format"` // common or combined; empty disables
+	Path   string `mapstructure:"path"`   // file to append to; empty or "-" for stdout
+}
+
+type DatabaseConfig struct {
+	URL             string `mapstructure:"url"`
+	MaxOpenConns    int    `mapstructure:"max_open_conns"`
+	MaxIdleConns    int    `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime string `mapstructure:"conn_max_lifetime"`
+}
+
+type RedisConfig struct {
+	URL      string `mapstructure:"url"`      // redis:// or rediss://, may carry user, password and db
+	Password string `mapstructure:"password"` // overrides the password in the URL
+	DB       int    `mapstructure:"db"`       // overrides the db in the URL when non-zero
+
+	TLS          RedisTLSConfig      `mapstructure:"tls"`
+	Sentinel     RedisSentinelConfig `mapstructure:"sentinel"`
+	ClusterAddrs []string            `mapstructure:"cluster_addrs"` // set to use Redis Cluster
+
+	PoolSize            int    `mapstructure:"pool_size"` // 0 uses the client default
+	MinIdleConns        int    `mapstructure:"min_idle_conns"`
+	MaxRetries          int    `mapstructure:"max_retries"`
+	DialTimeout         string `mapstructure:"dial_timeout"`
+	ReadTimeout         string `mapstructure:"read_timeout"`
+	WriteTimeout        string `mapstructure:"write_timeout"`
+	PoolTimeout         string `mapstructure:"pool_timeout"`
+	HealthCheckInterval string `mapstructure:"health_check_interval"` // empty disables health checks
+}
+
+// RedisTLSConfig enables TLS beyond what a rediss:// URL implies, e.g. a
+// private CA or client certificates
+type RedisTLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// RedisSentinelConfig connects through Sentinel to the current master of
+// MasterName
+type RedisSentinelConfig struct {
+	MasterName string   `mapstructure:"master_name"`
+	Addrs      []string `mapstructure:"addrs"`
+	Password   string   `mapstructure:"password"`
+}
+
+type AuthConfig struct {
+	JWTSecret     string `mapstructure:"jwt_secret"`
+	SessionExpiry string `mapstructure:"session_expiry"`
+	RateLimit     int    `mapstructure:"rate_limit"`
+}
+
+type SessionConfig struct {
+	MaxSessions        int    `mapstructure:"max_sessions"`
+	MaxTotalSessions   int    `mapstructure:"max_total_sessions"`
+	MaxMemoryMB        int    `mapstructure:"max_memory_mb"`
+	MaxCPUPercent      int    `mapstructure:"max_cpu_percent"`
+	SessionTimeout     string `mapstructure:"session_timeout"`
+	IdleWarning        string `mapstructure:"idle_warning"`
+	IdlePolicy         string `mapstructure:"idle_policy"` // activity (default), output, input or detached: what keeps a session from timing out
+	CleanupInterval    string `mapstructure:"cleanup_interval"`
+	WorkingDirectory   string `mapstructure:"working_directory"`
+	DefaultCols        int    `mapstructure:"default_cols"`
+	DefaultRows        int    `mapstructure:"default_rows"`
+	Locale             string `mapstructure:"locale"`
+	FanoutWorkers      int    `mapstructure:"fanout_workers"`
+	FanoutQuantumKB    int    `mapstructure:"fanout_quantum_kb"`
+	FanoutQueueKB      int    `mapstructure:"fanout_queue_kb"`
+	AllowedCommands    []string `mapstructure:"allowed_commands"`
+	BlockedCommands    []string `mapstructure:"blocked_commands"`
+	ElevatedCommands   []string `mapstructure:"elevated_commands"` // pass both lists in elevated mode
+	EnvironmentVars    map[string]string `mapstructure:"environment_vars"`
+	AlertPatterns      []string `mapstructure:"alert_patterns"`
+	LatencyProbeInterval string `mapstructure:"latency_probe_interval"` // empty disables probes
+	LogLines           int    `mapstructure:"log_lines"` // server log lines kept per session; 0 disables capture
+	Prewarm            []PrewarmPool `mapstructure:"prewarm"`
+	RecordSessions     bool   `mapstructure:"record_sessions"` // write each session's output as an asciicast v2 file
+	RecordingDir       string `mapstructure:"recording_dir"`   // empty uses <working_directory>/recordings
+	RelaunchOnRestart  bool   `mapstructure:"relaunch_on_restart"` // restart sessions cut off by a server restart; otherwise they are marked interrupted
+	ResizePolicy       string `mapstructure:"resize_policy"`       // smallest (default), driver or fixed: whose window sizes a shared session; sessions may pick their own
+	MinProtocolVersion int    `mapstructure:"min_protocol_version"` // oldest protocol version clients may attach with; 0 allows clients that do not negotiate
+	StopSignal         string `mapstructure:"stop_signal"`          // sent to a session's processes before SIGKILL; SIGTERM by default
+	StopGrace          string `mapstructure:"stop_grace"`           // how long they get to exit before SIGKILL; 0 kills at once
+	ScrollbackSize     int    `mapstructure:"scrollback_size"`      // output kept in memory per session, in bytes
+	MaxScrollbackSize  int    `mapstructure:"max_scrollback_size"`  // the most a session may ask for; 0 for no limit
+	ScrollbackSpill    bool   `mapstructure:"scrollback_spill"`     // also keep each session's whole output on disk
+	SpillDir           string `mapstructure:"spill_dir"`            // empty uses <working_directory>/scrollback
+	EphemeralUsers     bool   `mapstructure:"ephemeral_users"`      // run each local session as a throwaway UNIX user made with useradd; needs root
+	EphemeralUserPrefix string `mapstructure:"ephemeral_user_prefix"` // their names start with this; "wt-" by default
+	AllowedShells      []string `mapstructure:"allowed_shells"`       // shells sessions may ask for, by name on PATH or absolute path; the default shell is always allowed
+	LoginShell         bool   `mapstructure:"login_shell"`          // start shells with -l unless the session or user says otherwise
+	InputThresholds    []int64 `mapstructure:"input_thresholds"`    // bytes of input at which a session.traffic_threshold event fires
+	OutputThresholds   []int64 `mapstructure:"output_thresholds"`   // likewise for output
+	AllowedEnvVars     []string `mapstructure:"allowed_env_vars"`    // names or patterns like LC_* sessions may set when created; empty allows any not blocked
+	BlockedEnvVars     []string `mapstructure:"blocked_env_vars"`    // names or patterns they may not, even if allowed
+	EndedGrace         string `mapstructure:"ended_grace"`          // how long a session whose command exited stays attachable with its output; empty keeps it until session_timeout
+}
+
+// PrewarmPool keeps processes for a command started ahead of time, so a
+// session for it skips the shell's startup. Pooled processes are started
+// before any user claims them and so lack WEBTUNNEL_USER_ID.
+type PrewarmPool struct {
+	Command string `mapstructure:"command"` // bash, sh or empty for the interactive shell
+	Size    int    `mapstructure:"size"`
+}
+
+type FlagsConfig struct {
+	CacheTTL string `mapstructure:"cache_ttl"`
+}
+
+type NotifyConfig struct {
+	Targets              []NotifyTarget `mapstructure:"targets"`
+	LongRunningThreshold string         `mapstructure:"long_running_threshold"`
+	SessionEnded         bool           `mapstructure:"session_ended"` // notify when a session's command exits; off since it fires for every session
+}
+
+type NotifyTarget struct {
+	Type   string   `mapstructure:"type"` // slack or teams
+	URL    string   `mapstructure:"url"`
+	Events []string `mapstructure:"events"` // empty means all events
+}
+
+type PolicyConfig struct {
+	OPAURL        string       `mapstructure:"opa_url"`
+	PolicyPath    string       `mapstructure:"policy_path"`
+	Timeout       string       `mapstructure:"timeout"`
+	FallbackAllow bool         `mapstructure:"fallback_allow"`
+	Rules         []PolicyRule `mapstructure:"rules"`
+}
+
+// PolicyRule is a local rule used when OPA is not configured or fails. The
+// first rule whose non-empty fields all match decides.
+type PolicyRule struct {
+	Action       string   `mapstructure:"action"` // e.g. session.create, or * for any
+	Effect       string   `mapstructure:"effect"` // allow or deny
+	Roles        []string `mapstructure:"roles"`
+	Orgs         []string `mapstructure:"orgs"`
+	PathPrefixes []string `mapstructure:"path_prefixes"`
+	Commands     []string `mapstructure:"commands"`
+	Elevated     bool     `mapstructure:"elevated"` // only matches in elevated mode
+	Reason       string   `mapstructure:"reason"`
+}
+
+// PushConfig holds the VAPID key pair used to sign Web Push requests. Keys
+// are base64url encoded: the raw 32-byte private scalar and the uncompressed
+// public point.
+type PushConfig struct {
+	VAPIDPublicKey  string `mapstructure:"vapid_public_key"`
+	VAPIDPrivateKey string `mapstructure:"vapid_private_key"`
+	Subject         string `mapstructure:"subject"` // mailto: or https: contact
+	TTL             int    `mapstructure:"ttl"`     // seconds
+}
+
+// BandwidthConfig caps each WebSocket or file transfer, in KB/s per
+// direction. Zero means unlimited.
+type BandwidthConfig struct {
+	DefaultCapKBps int            `mapstructure:"default_cap_kbps"`
+	RoleCapsKBps   map[string]int `mapstructure:"role_caps_kbps"`
+}
+
+type MaintenanceConfig struct {
+	Windows []MaintenanceWindow `mapstructure:"windows"`
+	WarnAt  []string            `mapstructure:"warn_at"` // countdown warnings before a window, e.g. 15m
+}
+
+// MaintenanceWindow is either a one-off window with RFC 3339 start and end,
+// or a recurring one with HH:MM times on the listed weekdays (every day when
+// empty). Recurring windows may cross midnight.
+type MaintenanceWindow struct {
+	Name         string   `mapstructure:"name"`
+	Start        string   `mapstructure:"start"`
+	End          string   `mapstructure:"end"`
+	Weekdays     []string `mapstructure:"weekdays"` // mon, tue, ...
+	Timezone     string   `mapstructure:"timezone"` // IANA name, default UTC
+	Message      string   `mapstructure:"message"`
+	KillSessions bool     `mapstructure:"kill_sessions"` // end running sessions when the window opens
+}
+
+// JanitorConfig controls cleanup of session directories whose sessions are
+// gone, and how large those of live sessions may grow. Retention is
+// measured from the last access or modification of anything inside the
+// directory.
+type JanitorConfig struct {
+	Interval      string `mapstructure:"interval"` // empty disables the janitor
+	Retention     string `mapstructure:"retention"`
+	Action        string `mapstructure:"action"` // delete or archive
+	ArchiveDir    string `mapstructure:"archive_dir"`
+	OnEnd         bool   `mapstructure:"on_end"`         // apply the action as soon as a session ends instead of after retention
+	QuotaMB       int    `mapstructure:"quota_mb"`       // per live session directory; 0 is unlimited
+	QuotaInterval string `mapstructure:"quota_interval"` // how often live directories are measured
+}
+
+// StatusConfig controls the public status page
+type StatusConfig struct {
+	Title    string `mapstructure:"title"`
+	CacheTTL string `mapstructure:"cache_ttl"` // how long health checks are reused
+}
+
+// TimeoutsConfig bounds individual API operations. The request is
+// cancelled when its timeout passes or the client disconnects, and the
+// connection deadlines follow the timeout, so transfers may outlast the
+// server-wide 15s. Empty disables a timeout.
+type TimeoutsConfig struct {
+	CreateSession string `mapstructure:"create_session"`
+	Input         string `mapstructure:"input"`
+	FileList      string `mapstructure:"file_list"`
+	FileSearch    string `mapstructure:"file_search"` // returns what was found so far once it passes
+	FileTransfer  string `mapstructure:"file_transfer"`
+	Exec          string `mapstructure:"exec"` // longest a one-shot command may run
+}
+
+// RTCConfig lists the ICE servers handed to browsers. TURN credentials are
+// minted per user with the shared secret, in the format coturn expects
+// with use-auth-secret.
+type RTCConfig struct {
+	STUNURLs      []string `mapstructure:"stun_urls"`
+	TURNURLs      []string `mapstructure:"turn_urls"`
+	TURNSecret    string   `mapstructure:"turn_secret"`
+	CredentialTTL string   `mapstructure:"credential_ttl"`
+}
+
+// SnapshotsConfig stores admin-defined environments that are unpacked into
+// new session directories
+type SnapshotsConfig struct {
+	Dir           string `mapstructure:"dir"` // empty disables snapshots
+	MaxSizeMB     int    `mapstructure:"max_size_mb"`
+	MaxUnpackedMB int    `mapstructure:"max_unpacked_mb"`
+}
+
+// StartupConfig controls how long the server waits for Postgres and Redis
+// before giving up, or before starting without them when Degraded is set
+type StartupConfig struct {
+	WaitForDeps      string `mapstructure:"wait_for_deps"`  // 0 tries once
+	RetryInterval    string `mapstructure:"retry_interval"` // doubles after each failed attempt
+	MaxRetryInterval string `mapstructure:"max_retry_interval"`
+	Degraded         bool   `mapstructure:"degraded"` // serve sessions while login and admin wait for the database
+}
+
+// LoadShedConfig refuses new work before the process runs out of memory.
+// At soft_ratio of either limit new sessions are refused; at the limit new
+// attaches are refused as well. Existing sessions are never touched.
+type LoadShedConfig struct {
+	MaxRSSMB      int     `mapstructure:"max_rss_mb"`     // 0 disables the RSS limit
+	MaxGoroutines int     `mapstructure:"max_goroutines"` // 0 disables the goroutine limit
+	SoftRatio     float64 `mapstructure:"soft_ratio"`
+	CheckInterval string  `mapstructure:"check_interval"`
+	RetryAfter    string  `mapstructure:"retry_after"`
+}
+
+// UploadsConfig restricts what users may upload. Rules are keyed by role;
+// the "default" rule applies to roles without their own.
+type UploadsConfig struct {
+	Rules map[string]UploadRule `mapstructure:"rules"`
+	// BlockDisguised rejects files whose content is an executable or script
+	// but whose extension claims otherwise, e.g. an ELF binary named .png
+	BlockDisguised bool `mapstructure:"block_disguised"`
+}
+
+// UploadRule lists are checked against the file extension and the MIME
+// type sniffed from the content, never the type the client claims. MIME
+// patterns may end in /* to match a whole family.
+type UploadRule struct {
+	AllowExtensions []string       `mapstructure:"allow_extensions"` // empty allows any not denied
+	DenyExtensions  []string       `mapstructure:"deny_extensions"`
+	AllowMIME       []string       `mapstructure:"allow_mime"`
+	DenyMIME        []string       `mapstructure:"deny_mime"`
+	MaxSizeMB       int            `mapstructure:"max_size_mb"` // 0 is unlimited
+	MaxSizeByMIME   map[string]int `mapstructure:"max_size_by_mime"`
+}
+
+// SharesConfig controls read-only share links. Links are signed, so they
+// need no storage and stop working when they expire or the session ends.
+type SharesConfig struct {
+	TTL    string `mapstructure:"ttl"`
+	Secret string `mapstructure:"secret"` // defaults to the JWT secret
+}
+
+// AbuseConfig bans clients that reconnect in a loop, stuff auth tokens or
+// flood input. Each repeat ban of the same IP or user doubles, from
+// ban_duration up to max_ban_duration, while earlier bans are remembered.
+type AbuseConfig struct {
+	Enabled        bool      `mapstructure:"enabled"`
+	Reconnect      AbuseRule `mapstructure:"reconnect"`    // attaches per IP and per user
+	AuthFailure    AbuseRule `mapstructure:"auth_failure"` // 401 responses per IP
+	Input          AbuseRule `mapstructure:"input"`        // input messages per user
+	BanDuration    string    `mapstructure:"ban_duration"`
+	MaxBanDuration string    `mapstructure:"max_ban_duration"`
+	StrikeTTL      string    `mapstructure:"strike_ttl"` // how long a ban counts toward escalation
+}
+
+// AbuseRule bans once more than Limit events happen within Window
+type AbuseRule struct {
+	Limit  int    `mapstructure:"limit"` // 0 disables the rule
+	Window string `mapstructure:"window"`
+}
+
+// PermissionsConfig grants roles access to sessions they do not own;
+// owners always have full access to their own. sessions:read covers
+// listing, getting and streaming output; sessions:write adds input, resize
+// and kill.
+type PermissionsConfig struct {
+	Roles map[string][]string `mapstructure:"roles"`
+}
+
+// SSHConfig controls sessions on remote hosts. EncryptionKey is the base64
+// encoded 32-byte AES key that seals stored private keys; without it an
+// ephemeral key is used and stored keys are unreadable after a restart.
+// Without TrustOnFirstUse only hosts with a pinned key can be reached.
+type SSHConfig struct {
+	EncryptionKey   string `mapstructure:"encryption_key"`
+	TrustOnFirstUse bool   `mapstructure:"trust_on_first_use"`
+	DialTimeout     string `mapstructure:"dial_timeout"` // for reading host keys
+}
+
+// FileACLConfig layers per-directory access over policy for the file API.
+// The rule with the longest matching path decides; on the same path a rule
+// naming the user beats one for their role or org, which beats one with no
+// users, roles or orgs, which applies to everyone. Paths no rule covers get
+// Default.
+type FileACLConfig struct {
+	Default string        `mapstructure:"default"` // read, write or none
+	Rules   []FileACLRule `mapstructure:"rules"`
+}
+
+type FileACLRule struct {
+	Path   string   `mapstructure:"path"` // absolute; covers everything below it
+	Users  []string `mapstructure:"users"`
+	Roles  []string `mapstructure:"roles"`
+	Orgs   []string `mapstructure:"orgs"`
+	Access string   `mapstructure:"access"` // read, write or none
+}
+
+// FileSearchConfig limits GET /api/v1/files/search, which walks whole
+// directory trees; timeouts.file_search bounds each search
+type FileSearchConfig struct {
+	RateLimit int `mapstructure:"rate_limit"` // searches per user per minute; 0 for no limit
+}
+
+// SandboxConfig confines host session processes, short of a container: a
+// seccomp filter denies system calls such as mount and ptrace, and an
+// AppArmor profile or SELinux context is applied at exec. Each role gets
+// the profile Roles names for it, or Default; empty runs unconfined.
+type SandboxConfig struct {
+	Profiles map[string]SandboxProfile `mapstructure:"profiles"`
+	Roles    map[string]string         `mapstructure:"roles"`
+	Default  string                    `mapstructure:"default"`
+}
+
+type SandboxProfile struct {
+	DenySyscalls []string `mapstructure:"deny_syscalls"` // fail with EPERM
+	AppArmor     string   `mapstructure:"apparmor"`      // profile name, must be loaded
+	SELinux      string   `mapstructure:"selinux"`       // full context, e.g. user_u:user_r:user_t:s0
+}
+
+// WorkshopConfig limits workshops: batches of temporary users provisioned
+// for a class and torn down, with their sessions, when it ends
+type WorkshopConfig struct {
+	MaxUsers    int                       `mapstructure:"max_users"`    // per workshop
+	MaxDuration string                    `mapstructure:"max_duration"` // furthest end time from now
+	Presets     map[string]WorkshopPreset `mapstructure:"presets"`
+}
+
+// WorkshopPreset is the session every user of a workshop starts with, and
+// the role they get, e.g. one the sandbox confines
+type WorkshopPreset struct {
+	Command    string `mapstructure:"command"`
+	WorkingDir string `mapstructure:"working_dir"`
+	Role       string `mapstructure:"role"` // defaults to user
+}
+
+// ElevationConfig controls elevated mode, which lets one session run the
+// session.elevated_commands and match elevated policy rules for a while.
+// Roles in self_approve grant it to themselves with a justification;
+// everyone else waits for an admin.
+type ElevationConfig struct {
+	MaxDuration string   `mapstructure:"max_duration"`
+	SelfApprove []string `mapstructure:"self_approve"` // roles
+}
+
+// IdentityConfig controls identity assertions: short-lived JWTs that tell
+// downstream services which user drives a session. Only the listed
+// audiences can be asked for; with none the feature is off.
+type IdentityConfig struct {
+	Issuer     string   `mapstructure:"issuer"`
+	TTL        string   `mapstructure:"ttl"`         // at most 1h
+	Audiences  []string `mapstructure:"audiences"`
+	SigningKey string   `mapstructure:"signing_key"` // base64 Ed25519 seed; ephemeral if empty
+}
+
+// AuditConfig controls audit storage. With chain on, each entry carries a
+// hash of the one before it and can no longer be changed, which
+// "webtunnel audit verify" checks; anchor_url also gets the chain head
+// every anchor_interval, so a truncated chain shows up as well.
+type AuditConfig struct {
+	Chain          bool   `mapstructure:"chain"`
+	AnchorURL      string `mapstructure:"anchor_url"`
+	AnchorInterval string `mapstructure:"anchor_interval"`
+}
+
+// ScheduleConfig is a command run on a cron schedule as a one-shot exec,
+// with the user's policy, command lists and sandbox applying as usual
+type ScheduleConfig struct {
+	Name       string `mapstructure:"name"` // identifies it in the API
+	Cron       string `mapstructure:"cron"` // five fields, server local time
+	Command    string `mapstructure:"command"`
+	WorkingDir string `mapstructure:"working_dir"`
+	User       string `mapstructure:"user"`    // user ID it runs as
+	Timeout    string `mapstructure:"timeout"` // defaults to 10m
+
+	// Artifacts are globs of files to keep from the run's directory
+	Artifacts []string `mapstructure:"artifacts"`
+}
+
+// ServiceAccountsConfig caps the quotas an admin may give a service
+// account, a user for CI jobs that authenticates with an API key only.
+// Accounts created without quotas get these.
+type ServiceAccountsConfig struct {
+	MaxSessions       int `mapstructure:"max_sessions"`        // running at once
+	RequestsPerMinute int `mapstructure:"requests_per_minute"` // API calls
+}
+
+// ArtifactsConfig keeps the files one-shot commands and schedules declare
+// as artifacts, for download after their directory is removed
+type ArtifactsConfig struct {
+	Dir        string `mapstructure:"dir"` // empty disables artifacts
+	MaxFileMB  int    `mapstructure:"max_file_mb"`
+	MaxTotalMB int    `mapstructure:"max_total_mb"` // per command
+	MaxFiles   int    `mapstructure:"max_files"`    // per command
+	Retention  string `mapstructure:"retention"`
+}
+
+// BannerConfig is a notice, e.g. a legal warning, shown when a session
+// starts. An org entry replaces the default banner for users in that org.
+// With RequireAck, sessions are refused until the user has acknowledged
+// the current text.
+type BannerConfig struct {
+	Text        string            `mapstructure:"text"` // empty shows none
+	RequireAck  bool              `mapstructure:"require_ack"`
+	Show        string            `mapstructure:"show"`         // notice, print or both
+	ExemptRoles []string          `mapstructure:"exempt_roles"` // e.g. service
+	Orgs        []OrgBannerConfig `mapstructure:"orgs"`
+}
+
+// OrgBannerConfig is one org's banner; an empty text shows that org none
+type OrgBannerConfig struct {
+	Org        string `mapstructure:"org"`
+	Text       string `mapstructure:"text"`
+	RequireAck bool   `mapstructure:"require_ack"`
+}
+
+// BrandingConfig white-labels the web UI and share pages, and is served
+// from /api/v1/branding. An org entry overrides the fields it sets for
+// users in that org. Colors are #rgb or #rrggbb.
+type BrandingConfig struct {
+	ProductName     string              `mapstructure:"product_name"`
+	LogoURL         string              `mapstructure:"logo_url"`    // http(s) or a path on this server
+	SupportURL      string              `mapstructure:"support_url"` // http(s) or mailto:
+	PrimaryColor    string              `mapstructure:"primary_color"`
+	BackgroundColor string              `mapstructure:"background_color"`
+	TextColor       string              `mapstructure:"text_color"`
+	Orgs            []OrgBrandingConfig `mapstructure:"orgs"`
+}
+
+type OrgBrandingConfig struct {
+	Org             string `mapstructure:"org"`
+	ProductName     string `mapstructure:"product_name"`
+	LogoURL         string `mapstructure:"logo_url"`
+	SupportURL      string `mapstructure:"support_url"`
+	PrimaryColor    string `mapstructure:"primary_color"`
+	BackgroundColor string `mapstructure:"background_color"`
+	TextColor       string `mapstructure:"text_color"`
+}
+
+// InputAuditConfig records everything typed into sessions with who typed
+// it and when, plus the command lines reconstructed from it, for
+// compliance. Records are kept for Retention.
+type InputAuditConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Retention string `mapstructure:"retention"` // defaults to 2160h (90 days)
+}
+
+func Load(configFile string) (*Config, error) {
+	v := viper.New()
+	
+	// Set defaults
+	setDefaults(v)
+	
+	// Set config file
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+	} else {
+		v.SetConfigName(".webtunnel")
+		v.SetConfigType("yaml")
+		v.AddConfigPath("$HOME")
+		v.AddConfigPath(".")
+	}
+
+	// Environment variables
+	v.SetEnvPrefix("WEBTUNNEL")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	// Bind command line flags
+	v.BindPFlag("server.host", nil)
+	v.BindPFlag("server.port", nil)
+	v.BindPFlag("server.tls", nil)
+	v.BindPFlag("database.url", nil)
+	v.BindPFlag("redis.url", nil)
+
+	// Read config file if it exists
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+	}
+
+	switch env := v.GetString("server.environment"); env {
+	case EnvDevelopment, EnvStaging, EnvProduction:
+		// Debug features default to on only in development; setting them
+		// explicitly wins in any environment
+		for _, key := range []string{"server.enable_pprof", "server.verbose_logs"} {
+			if !v.IsSet(key) {
+				v.Set(key, env == EnvDevelopment)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown server.environment %q: use development, staging or production", env)
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+func setDefaults(v *viper.Viper) {
+	// Server defaults
+	v.SetDefault("server.environment", EnvProduction)
+	v.SetDefault("server.host", "0.0.0.0")
+	v.SetDefault("server.port", 8443)
+	v.SetDefault("server.tls", true)
+	v.SetDefault("server.static_dir", "./web/dist")
+	v.SetDefault("server.allow_origins", []string{"*"})
+	v.SetDefault("server.drain_timeout", "1h")
+	v.SetDefault("server.quota_help", "")
+	v.SetDefault("server.access_log.format", "")
+	v.SetDefault("server.access_log.path", "")
+
+	// Database defaults
+	v.SetDefault("database.url", "postgres://localhost/webtunnel?sslmode=disable")
+	v.SetDefault("database.max_open_conns", 25)
+	v.SetDefault("database.max_idle_conns", 5)
+	v.SetDefault("database.conn_max_lifetime", "15m")
+
+	// Redis defaults
+	v.SetDefault("redis.url", "redis://localhost:6379")
+	v.SetDefault("redis.db", 0)
+	v.SetDefault("redis.max_retries", 3)
+	v.SetDefault("redis.dial_timeout", "5s")
+	v.SetDefault("redis.read_timeout", "3s")
+	v.SetDefault("redis.write_timeout", "3s")
+	v.SetDefault("redis.health_check_interval", "15s")
+
+	// Auth defaults
+	v.SetDefault("auth.jwt_secret", "your-secret-key-change-in-production")
+	v.SetDefault("auth.session_expiry", "24h")
+	v.SetDefault("auth.rate_limit", 100)
+
+	// Session defaults
+	v.SetDefault("session.max_sessions", 50)
+	v.SetDefault("session.max_total_sessions", 0)
+	v.SetDefault("session.max_memory_mb", 512)
+	v.SetDefault("session.max_cpu_percent", 80)
+	v.SetDefault("session.session_timeout", "1h")
+	v.SetDefault("session.idle_warning", "10m")
+	v.SetDefault("session.idle_policy", "activity")
+	v.SetDefault("session.cleanup_interval", "5m")
+	v.SetDefault("session.working_directory", "/tmp/webtunnel")
+	v.SetDefault("session.default_cols", 80)
+	v.SetDefault("session.default_rows", 24)
+	v.SetDefault("session.resize_policy", "smallest")
+	v.SetDefault("session.min_protocol_version", 0)
+	v.SetDefault("session.stop_signal", "SIGTERM")
+	v.SetDefault("session.stop_grace", "5s")
+	v.SetDefault("session.scrollback_size", 1024*1024)
+	v.SetDefault("session.max_scrollback_size", 16*1024*1024)
+	v.SetDefault("session.scrollback_spill", false)
+	v.SetDefault("session.ephemeral_users", false)
+	v.SetDefault("session.ephemeral_user_prefix", "wt-")
+	v.SetDefault("session.allowed_shells", []string{"bash", "zsh", "fish"})
+	v.SetDefault("session.login_shell", false)
+	v.SetDefault("session.input_thresholds", []int64{})
+	v.SetDefault("session.output_thresholds", []int64{1 << 30})
+	v.SetDefault("session.allowed_env_vars", []string{})
+	// Variables that change what the shell runs or how programs load
+	v.SetDefault("session.blocked_env_vars", []string{
+		"PATH", "HOME", "SHELL", "USER", "LOGNAME", "LD_*", "DYLD_*",
+		"BASH_ENV", "ENV", "SHELLOPTS", "BASHOPTS", "PROMPT_COMMAND", "PS4", "IFS", "ZDOTDIR",
+	})
+	v.SetDefault("session.locale", "C.UTF-8")
+	v.SetDefault("session.fanout_workers", 8)
+	v.SetDefault("session.fanout_quantum_kb", 32)
+	v.SetDefault("session.fanout_queue_kb", 256)
+	v.SetDefault("session.log_lines", 200)
+	v.SetDefault("session.allowed_commands", []string{})
+	v.SetDefault("session.alert_patterns", []string{`(?i)\bpanic:`, `(?i)segmentation fault`, `(?i)out of memory`})
+	v.SetDefault("session.blocked_commands", []string{"rm", "rmdir", "dd", "mkfs", "fdisk"})
+	v.SetDefault("session.environment_vars", map[string]string{
+		"TERM": "xterm-256color",
+		"SHELL": "/bin/bash",
+	})
+
+	// Feature flag defaults
+	v.SetDefault("flags.cache_ttl", "30s")
+
+	// Notification defaults
+	v.SetDefault("notify.long_running_threshold", "8h")
+
+	// Policy defaults
+	v.SetDefault("policy.policy_path", "webtunnel/authz/decision")
+	v.SetDefault("policy.timeout", "2s")
+	v.SetDefault("policy.fallback_allow", true)
+
+	// Push defaults
+	v.SetDefault("push.subject", "mailto:admin@localhost")
+	v.SetDefault("push.ttl", 3600)
+
+	// Bandwidth defaults
+	v.SetDefault("bandwidth.default_cap_kbps", 0)
+
+	// Maintenance defaults
+	v.SetDefault("maintenance.warn_at", []string{"15m", "5m", "1m"})
+
+	// Janitor defaults
+	v.SetDefault("janitor.interval", "1h")
+	v.SetDefault("janitor.retention", "72h")
+	v.SetDefault("janitor.action", "delete")
+	v.SetDefault("janitor.quota_interval", "1m")
+
+	// Status page defaults
+	v.SetDefault("status.title", "WebTunnel Status")
+	v.SetDefault("status.cache_ttl", "30s")
+
+	// Timeout defaults
+	v.SetDefault("timeouts.create_session", "10s")
+	v.SetDefault("timeouts.input", "5s")
+	v.SetDefault("timeouts.file_list", "30s")
+	v.SetDefault("timeouts.file_search", "10s")
+	v.SetDefault("timeouts.file_transfer", "10m")
+	v.SetDefault("timeouts.exec", "5m")
+
+	// WebRTC defaults
+	v.SetDefault("rtc.credential_ttl", "1h")
+
+	// Snapshot defaults
+	v.SetDefault("snapshots.max_size_mb", 1024)
+	v.SetDefault("snapshots.max_unpacked_mb", 4096)
+
+	// Startup defaults
+	v.SetDefault("startup.wait_for_deps", "30s")
+	v.SetDefault("startup.retry_interval", "1s")
+	v.SetDefault("startup.max_retry_interval", "15s")
+
+	// Load shedding defaults
+	v.SetDefault("load_shed.soft_ratio", 0.85)
+	v.SetDefault("load_shed.check_interval", "5s")
+	v.SetDefault("load_shed.retry_after", "30s")
+
+	// Upload defaults
+	v.SetDefault("uploads.block_disguised", true)
+
+	// Share link defaults
+	v.SetDefault("shares.ttl", "24h")
+
+	// Abuse detection defaults
+	v.SetDefault("abuse.enabled", true)
+	v.SetDefault("abuse.reconnect.limit", 30)
+	v.SetDefault("abuse.reconnect.window", "1m")
+	v.SetDefault("abuse.auth_failure.limit", 10)
+	v.SetDefault("abuse.auth_failure.window", "5m")
+	v.SetDefault("abuse.input.limit", 2000)
+	v.SetDefault("abuse.input.window", "10s")
+	v.SetDefault("abuse.ban_duration", "5m")
+	v.SetDefault("abuse.max_ban_duration", "24h")
+	v.SetDefault("abuse.strike_ttl", "24h")
+
+	// Permission defaults
+	v.SetDefault("permissions.roles", map[string][]string{
+		"admin":   {"sessions:read", "sessions:write"},
+		"auditor": {"sessions:read"},
+	})
+
+	// SSH defaults
+	v.SetDefault("ssh.trust_on_first_use", true)
+	v.SetDefault("ssh.dial_timeout", "10s")
+
+	// File ACL defaults
+	v.SetDefault("file_acl.default", "write")
+
+	// File search defaults
+	v.SetDefault("file_search.rate_limit", 10)
+
+	// Workshop defaults
+	v.SetDefault("workshop.max_users", 100)
+	v.SetDefault("workshop.max_duration", "72h")
+
+	// Elevation defaults
+	v.SetDefault("elevation.max_duration", "1h")
+
+	// Service account defaults
+	v.SetDefault("service_accounts.max_sessions", 5)
+	v.SetDefault("service_accounts.requests_per_minute", 120)
+
+	// Artifact defaults
+	v.SetDefault("artifacts.max_file_mb", 100)
+	v.SetDefault("artifacts.max_total_mb", 500)
+	v.SetDefault("artifacts.max_files", 100)
+	v.SetDefault("artifacts.retention", "168h")
+
+	// Banner defaults
+	v.SetDefault("banner.show", "both")
+
+	// Input audit defaults
+	v.SetDefault("input_audit.retention", "2160h")
+
+	// Branding defaults
+	v.SetDefault("branding.product_name", "WebTunnel")
+	v.SetDefault("branding.primary_color", "#00ff88")
+	v.SetDefault("branding.background_color", "#1e1e1e")
+	v.SetDefault("branding.text_color", "#ffffff")
+
+	// Identity defaults
+	v.SetDefault("identity.issuer", "webtunnel")
+	v.SetDefault("identity.ttl", "5m")
+
+	// Audit defaults
+	v.SetDefault("audit.anchor_interval", "1h")
+}
\ No newline at end of file
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "webtunnel.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(yaml), 0600))
+	return file
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		yaml        string
+		environment string
+		pprof       bool
+		verbose     bool
+	}{
+		{"unset", "server: {}\n", EnvProduction, false, false},
+		{"development", "server: {environment: development}\n", EnvDevelopment, true, true},
+		{"staging", "server: {environment: staging}\n", EnvStaging, false, false},
+		{"production", "server: {environment: production}\n", EnvProduction, false, false},
+		{"development overridden", "server: {environment: development, enable_pprof: false}\n", EnvDevelopment, false, true},
+		{"production overridden", "server: {environment: production, verbose_logs: true}\n", EnvProduction, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := Load(writeConfig(t, tc.yaml))
+			require.NoError(t, err)
+			assert.Equal(t, tc.environment, cfg.Server.Environment)
+			assert.Equal(t, tc.pprof, cfg.Server.EnablePprof)
+			assert.Equal(t, tc.verbose, cfg.Server.VerboseLogs)
+		})
+	}
+}
+
+func TestLoadEnvironmentVariable(t *testing.T) {
+	t.Setenv("WEBTUNNEL_SERVER_ENVIRONMENT", EnvDevelopment)
+	cfg, err := Load(writeConfig(t, "server: {environment: production}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, EnvDevelopment, cfg.Server.Environment)
+	assert.True(t, cfg.Server.EnablePprof)
+}
+
+func TestLoadInvalidEnvironment(t *testing.T) {
+	for _, env := range []string{"prod", "Production", "dev", " "} {
+		_, err := Load(writeConfig(t, "server:\n  environment: \""+env+"\"\n"))
+		assert.ErrorContains(t, err, "unknown server.environment", env)
+	}
+}
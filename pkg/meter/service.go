@@ -0,0 +1,336 @@
+// Package meter counts client traffic per user and session and applies
+// per-role bandwidth caps.
+package meter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	KindWebSocket = "websocket"
+	KindLongPoll  = "longpoll"
+	KindFile      = "file"
+)
+
+// RoleLookup resolves a user's role to pick their bandwidth cap
+type RoleLookup func(userID string) (string, error)
+
+// Service counts bytes moved over WebSockets and file transfers, per user
+// and per session, and throttles connections of capped roles.
+type Service struct {
+	config config.BandwidthConfig
+	roles  RoleLookup
+	logger *zap.Logger
+
+	users    map[string]*counters
+	sessions map[string]*counters
+	mu       sync.Mutex
+}
+
+type counters struct {
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+	connections atomic.Int64
+	lastActive  atomic.Int64 // unix nanoseconds
+}
+
+// Usage is a snapshot of one user's or session's traffic
+type Usage struct {
+	ID          string    `json:"id"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	Connections int64     `json:"connections"`
+	LastActive  time.Time `json:"last_active"`
+}
+
+func New(cfg config.BandwidthConfig, roles RoleLookup, logger *zap.Logger) *Service {
+	return &Service{
+		config:   cfg,
+		roles:    roles,
+		logger:   logger,
+		users:    make(map[string]*counters),
+		sessions: make(map[string]*counters),
+	}
+}
+
+// Conn meters one connection or transfer. In and Out block as needed to
+// keep the connection under its role's cap.
+type Conn struct {
+	user    *counters
+	session *counters
+	in      *rate.Limiter
+	out     *rate.Limiter
+	closed  atomic.Bool
+}
+
+// Connection starts metering a connection. sessionID may be empty for
+// transfers that are not tied to a session. Close must be called when the
+// connection ends.
+func (s *Service) Connection(userID, sessionID, kind string) *Conn {
+	s.mu.Lock()
+	conn := &Conn{user: counterFor(s.users, userID)}
+	if sessionID != "" {
+		conn.session = counterFor(s.sessions, sessionID)
+	}
+	s.mu.Unlock()
+
+	if capKBps := s.capFor(userID); capKBps > 0 {
+		conn.in = newLimiter(capKBps)
+		conn.out = newLimiter(capKBps)
+		s.logger.Debug("Bandwidth cap applied",
+			zap.String("user_id", userID),
+			zap.String("kind", kind),
+			zap.Int("cap_kbps", capKBps))
+	}
+
+	conn.user.connections.Add(1)
+	if conn.session != nil {
+		conn.session.connections.Add(1)
+	}
+	return conn
+}
+
+func counterFor(m map[string]*counters, id string) *counters {
+	c, exists := m[id]
+	if !exists {
+		c = &counters{}
+		c.lastActive.Store(time.Now().UnixNano())
+		m[id] = c
+	}
+	return c
+}
+
+// capFor returns the per-connection cap in KB/s for the user's role, or
+// zero for unlimited.
+func (s *Service) capFor(userID string) int {
+	if len(s.config.RoleCapsKBps) == 0 || s.roles == nil {
+		return s.config.DefaultCapKBps
+	}
+
+	role, err := s.roles(userID)
+	if err != nil {
+		return s.config.DefaultCapKBps
+	}
+	if capKBps, exists := s.config.RoleCapsKBps[role]; exists {
+		return capKBps
+	}
+	return s.config.DefaultCapKBps
+}
+
+// newLimiter allows one second of burst at the capped rate
+func newLimiter(capKBps int) *rate.Limiter {
+	bytesPerSecond := capKBps * 1024
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)
+}
+
+// In records n bytes received from the client
+func (c *Conn) In(ctx context.Context, n int) error {
+	c.add(n, func(ct *counters) *atomic.Int64 { return &ct.bytesIn })
+	return wait(ctx, c.in, n)
+}
+
+// Out records n bytes sent to the client
+func (c *Conn) Out(ctx context.Context, n int) error {
+	c.add(n, func(ct *counters) *atomic.Int64 { return &ct.bytesOut })
+	return wait(ctx, c.out, n)
+}
+
+func (c *Conn) add(n int, field func(*counters) *atomic.Int64) {
+	now := time.Now().UnixNano()
+	field(c.user).Add(int64(n))
+	c.user.lastActive.Store(now)
+	if c.session != nil {
+		field(c.session).Add(int64(n))
+		c.session.lastActive.Store(now)
+	}
+}
+
+// wait takes n tokens from the limiter, in burst-sized steps since a single
+// WebSocket frame can be larger than one second of allowance.
+func wait(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		step := n
+		if step > limiter.Burst() {
+			step = limiter.Burst()
+		}
+		if err := limiter.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+func (c *Conn) Close() {
+	if !c.closed.CompareAndSwap(false, true) {
+		return
+	}
+	c.user.connections.Add(-1)
+	if c.session != nil {
+		c.session.connections.Add(-1)
+	}
+}
+
+// Reader meters everything read through r as inbound traffic. Reads fail
+// once ctx is done, so a cancelled transfer stops at the next chunk.
+func (c *Conn) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, conn: c, r: r}
+}
+
+type reader struct {
+	ctx  context.Context
+	conn *Conn
+	r    io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.conn.In(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// Writer meters everything written through w as outbound traffic, failing
+// once ctx is done
+func (c *Conn) Writer(ctx context.Context, w io.Writer) io.Writer {
+	return &writer{ctx: ctx, conn: c, w: w}
+}
+
+type writer struct {
+	ctx  context.Context
+	conn *Conn
+	w    io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := w.conn.Out(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+func (s *Service) UserUsage(userID string) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists := s.users[userID]
+	if !exists {
+		return Usage{ID: userID}
+	}
+	return c.snapshot(userID)
+}
+
+func (s *Service) SessionUsage(sessionID string) Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists := s.sessions[sessionID]
+	if !exists {
+		return Usage{ID: sessionID}
+	}
+	return c.snapshot(sessionID)
+}
+
+// Users returns usage for every metered user, sorted by ID
+func (s *Service) Users() []Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return snapshotAll(s.users)
+}
+
+// Sessions returns usage for every metered session, sorted by ID
+func (s *Service) Sessions() []Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return snapshotAll(s.sessions)
+}
+
+func snapshotAll(m map[string]*counters) []Usage {
+	result := make([]Usage, 0, len(m))
+	for id, c := range m {
+		result = append(result, c.snapshot(id))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func (c *counters) snapshot(id string) Usage {
+	return Usage{
+		ID:          id,
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+		Connections: c.connections.Load(),
+		LastActive:  time.Unix(0, c.lastActive.Load()),
+	}
+}
+
+// Prune drops session counters with no open connections and no traffic for
+// maxAge. Per-user totals are kept for the life of the process.
+func (s *Service) Prune(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge).UnixNano()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, c := range s.sessions {
+		if c.connections.Load() == 0 && c.lastActive.Load() < cutoff {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// WriteMetrics renders the counters in the Prometheus text format
+func (s *Service) WriteMetrics(w io.Writer) error {
+	users := s.Users()
+	sessions := s.Sessions()
+
+	lines := []string{
+		"# HELP webtunnel_user_bytes_total Bytes transferred per user over WebSockets and file transfers.",
+		"# TYPE webtunnel_user_bytes_total counter",
+	}
+	for _, u := range users {
+		lines = append(lines,
+			fmt.Sprintf("webtunnel_user_bytes_total{user=%q,direction=\"in\"} %d", u.ID, u.BytesIn),
+			fmt.Sprintf("webtunnel_user_bytes_total{user=%q,direction=\"out\"} %d", u.ID, u.BytesOut))
+	}
+	lines = append(lines,
+		"# HELP webtunnel_session_bytes_total Bytes transferred per terminal session.",
+		"# TYPE webtunnel_session_bytes_total counter")
+	for _, u := range sessions {
+		lines = append(lines,
+			fmt.Sprintf("webtunnel_session_bytes_total{session=%q,direction=\"in\"} %d", u.ID, u.BytesIn),
+			fmt.Sprintf("webtunnel_session_bytes_total{session=%q,direction=\"out\"} %d", u.ID, u.BytesOut))
+	}
+	lines = append(lines,
+		"# HELP webtunnel_user_connections Open metered connections per user.",
+		"# TYPE webtunnel_user_connections gauge")
+	for _, u := range users {
+		lines = append(lines, fmt.Sprintf("webtunnel_user_connections{user=%q} %d", u.ID, u.Connections))
+	}
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
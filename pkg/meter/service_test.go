@@ -0,0 +1,107 @@
+package meter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+type fakeUsers map[string]string
+
+func (f fakeUsers) role(userID string) (string, error) {
+	role, exists := f[userID]
+	if !exists {
+		return "", errors.New("user not found")
+	}
+	return role, nil
+}
+
+func TestCounters(t *testing.T) {
+	service := New(config.BandwidthConfig{}, nil, zap.NewNop())
+	ctx := context.Background()
+
+	ws := service.Connection("alice", "sess_1", KindWebSocket)
+	require.NoError(t, ws.In(ctx, 10))
+	require.NoError(t, ws.Out(ctx, 100))
+
+	file := service.Connection("alice", "", KindFile)
+	_, err := io.Copy(io.Discard, file.Reader(ctx, strings.NewReader("hello")))
+	require.NoError(t, err)
+	_, err = file.Writer(ctx, io.Discard).Write([]byte("world!"))
+	require.NoError(t, err)
+	file.Close()
+	file.Close()
+
+	user := service.UserUsage("alice")
+	assert.Equal(t, int64(15), user.BytesIn)
+	assert.Equal(t, int64(106), user.BytesOut)
+	assert.Equal(t, int64(1), user.Connections)
+
+	session := service.SessionUsage("sess_1")
+	assert.Equal(t, int64(10), session.BytesIn)
+	assert.Equal(t, int64(100), session.BytesOut)
+
+	var buf bytes.Buffer
+	require.NoError(t, service.WriteMetrics(&buf))
+	assert.Contains(t, buf.String(), `webtunnel_user_bytes_total{user="alice",direction="out"} 106`)
+	assert.Contains(t, buf.String(), `webtunnel_session_bytes_total{session="sess_1",direction="in"} 10`)
+
+	// Sessions with open connections survive pruning
+	service.Prune(0)
+	assert.Len(t, service.Sessions(), 1)
+	ws.Close()
+	service.Prune(0)
+	assert.Empty(t, service.Sessions())
+	assert.Len(t, service.Users(), 1)
+}
+
+func TestRoleCaps(t *testing.T) {
+	cfg := config.BandwidthConfig{
+		DefaultCapKBps: 0,
+		RoleCapsKBps:   map[string]int{"guest": 1},
+	}
+	service := New(cfg, fakeUsers{"bob": "guest", "carol": "admin"}.role, zap.NewNop())
+	ctx := context.Background()
+
+	assert.Equal(t, 1, service.capFor("bob"))
+	assert.Equal(t, 0, service.capFor("carol"))
+	assert.Equal(t, 0, service.capFor("unknown"))
+
+	// The first second of allowance is a burst, the rest is paced
+	conn := service.Connection("bob", "", KindFile)
+	defer conn.Close()
+
+	start := time.Now()
+	require.NoError(t, conn.Out(ctx, 1024))
+	require.NoError(t, conn.Out(ctx, 512))
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// Throttled transfers stop when the request goes away
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Error(t, conn.In(cancelled, 4096))
+}
+
+func TestCancelledTransfer(t *testing.T) {
+	service := New(config.BandwidthConfig{}, nil, zap.NewNop())
+	conn := service.Connection("alice", "", KindFile)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := io.Copy(io.Discard, conn.Reader(ctx, strings.NewReader("hello")))
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = conn.Writer(ctx, io.Discard).Write([]byte("world"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, service.UserUsage("alice").BytesIn+service.UserUsage("alice").BytesOut)
+}
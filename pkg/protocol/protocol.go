@@ -0,0 +1,320 @@
+// Package protocol defines the JSON frames exchanged between webtunnel
+// servers and terminal clients over WebSocket and long polling.
+//
+// Every frame is a Message. Structured payloads such as Resize and
+// Highlight are JSON encoded into Message.Data.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frames sent by clients
+const (
+	// TypeInput carries keystrokes for the PTY in Data
+	TypeInput = "input"
+	// TypeResize carries a Resize in Data
+	TypeResize = "resize"
+	// TypeRunSnippet types the stored snippet named in Data
+	TypeRunSnippet = "run-snippet"
+	// TypePing asks the server for a TypePong
+	TypePing = "ping"
+	// TypeHighlight carries a Highlight in Data; presenters only
+	TypeHighlight = "highlight"
+	// TypeClearHighlight removes the presenter's highlight
+	TypeClearHighlight = "clear-highlight"
+	// TypeSearch carries a Search in Data
+	TypeSearch = "search"
+	// TypeMark drops a bookmark named in Data at the current output offset
+	TypeMark = "mark"
+)
+
+// Frames sent by the server. TypeProbe is echoed back unchanged by the
+// client, and highlight frames are relayed from the presenter to viewers.
+const (
+	// TypeOutput carries PTY output in Data
+	TypeOutput = "output"
+	// TypeError carries a human readable error in Data
+	TypeError = "error"
+	// TypePong answers a TypePing
+	TypePong = "pong"
+	// TypeNotice carries a server-wide announcement in Data
+	TypeNotice = "notice"
+	// TypePresentation reports presentation mode as "on" or "off"
+	TypePresentation = "presentation"
+	// TypeProbe carries a latency probe ID the client must echo
+	TypeProbe = "probe"
+	// TypeQuality carries the connection's latency summary in Data
+	TypeQuality = "quality"
+	// TypeSearchResults carries SearchResults in Data
+	TypeSearchResults = "search-results"
+	// TypeBookmark carries a Bookmark in Data when one is dropped
+	TypeBookmark = "bookmark"
+	// TypeAttachError carries an AttachError in Data when a connection could
+	// not be attached; the server closes it right after
+	TypeAttachError = "attach-error"
+	// TypeExit carries an Exit in Data when the session ends, after the
+	// last of its output
+	TypeExit = "exit"
+	// TypePresence carries a Presence in Data whenever someone attaches or
+	// detaches, asks for control or control changes hands
+	TypePresence = "presence"
+	// TypeSize carries a Size in Data on attach and whenever the PTY's
+	// size changes; clients should render at it whatever their window
+	TypeSize = "size"
+	// TypeTitle carries a Title in Data on attach and whenever the title
+	// or the foreground command changes
+	TypeTitle = "title"
+	// TypeSessionEnded carries a SessionEnded in Data right after
+	// TypeExit
+	TypeSessionEnded = "session_ended"
+)
+
+// What a Presence frame reports
+const (
+	PresenceJoined    = "joined"
+	PresenceLeft      = "left"
+	PresenceRequested = "requested" // UserID asked for control
+	PresenceGranted   = "granted"   // UserID now has control
+	PresenceReleased  = "released"  // nobody has control
+)
+
+// Roles of the users attached to a session
+const (
+	// RoleDriver may type. While nobody holds control, everyone with write
+	// access is a driver.
+	RoleDriver = "driver"
+	// RoleObserver watches; read-only users are always observers
+	RoleObserver = "observer"
+)
+
+// Codes in an AttachError, also returned as "code" by the HTTP API when
+// an attach is refused before upgrading
+const (
+	CodeSessionNotFound = "session_not_found"
+	CodeSessionExited   = "session_exited"
+	CodeForbidden       = "forbidden"
+	CodeInvalidAnchor   = "invalid_anchor"
+	CodeProtocolTooOld  = "protocol_too_old"
+	CodeInternal        = "internal_error"
+)
+
+// Codes returned as "code" by the HTTP API with a 429, along with a Limit
+// as "limit"
+const (
+	// CodeRateLimited refuses a request over a request rate
+	CodeRateLimited = "rate_limited"
+	// CodeQuotaExceeded refuses a session over a limit on sessions at once
+	CodeQuotaExceeded = "quota_exceeded"
+)
+
+// Types of Limit
+const (
+	LimitServerRequests = "server_requests"  // API requests to the server from everyone
+	LimitUserRequests   = "user_requests"    // requests from one user to a route
+	LimitAPIKeyRequests = "api_key_requests" // requests with a service account's API key
+	LimitUserSessions   = "user_sessions"    // one user's running sessions
+	LimitServerSessions = "server_sessions"  // everyone's running sessions
+)
+
+// Limit details the rate limit or quota that refused a request, so a
+// client can say which one it hit and when to try again
+type Limit struct {
+	Type  string `json:"type"`
+	Limit int    `json:"limit"` // requests per Window, or sessions at once
+	Used  int    `json:"used"`
+
+	// Window is the period a request rate is counted over, e.g. "1m";
+	// empty for quotas on what runs at once
+	Window string `json:"window,omitempty"`
+
+	// ResetAt is when a request can succeed again, if that is known.
+	// RetryAfter is the same in seconds from now, as in the Retry-After
+	// header; for quotas it is only a suggestion of when to check again.
+	ResetAt    *time.Time `json:"reset_at,omitempty"`
+	RetryAfter int        `json:"retry_after"`
+
+	// Increase says how to ask for a higher limit, e.g. a URL
+	Increase string `json:"increase,omitempty"`
+}
+
+// Protocol versions. Clients offer the versions they speak as WebSocket
+// subprotocols, or as ?protocol= when long polling, and the server picks
+// the newest it also speaks. A client that offers none gets Version0.
+const (
+	// Version0 is JSON frames from a client that did not negotiate
+	Version0 = 0
+	// Version1 is JSON frames, negotiated
+	Version1 = 1
+
+	// LatestVersion is the newest version this package speaks
+	LatestVersion = Version1
+)
+
+const subprotocolPrefix = "webtunnel.v"
+
+// Subprotocol names a protocol version, e.g. "webtunnel.v1"
+func Subprotocol(version int) string {
+	return fmt.Sprintf("%s%d", subprotocolPrefix, version)
+}
+
+// ParseSubprotocol returns the version a subprotocol names, or false for
+// names that are not webtunnel versions. Only the names Subprotocol
+// returns count, so "webtunnel.v01" and "webtunnel.v+1" do not.
+func ParseSubprotocol(name string) (int, bool) {
+	digits, ok := strings.CutPrefix(name, subprotocolPrefix)
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(digits)
+	if err != nil || version < Version1 || strconv.Itoa(version) != digits {
+		return 0, false
+	}
+	return version, true
+}
+
+// Message is a single protocol frame
+type Message struct {
+	Type      string    `json:"type"`
+	Data      string    `json:"data,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	SessionID string    `json:"session_id,omitempty"`
+}
+
+// Resize is the payload of a TypeResize frame
+type Resize struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+// Size is the PTY's authoritative size, the policy that picked it and,
+// for a change, why it changed
+type Size struct {
+	Cols   int    `json:"cols"`
+	Rows   int    `json:"rows"`
+	Policy string `json:"policy"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Title is what a session shows: the title its programs set with OSC 0 or
+// OSC 2, if any, and the command line running in the foreground
+type Title struct {
+	Title          string `json:"title"`
+	CurrentCommand string `json:"current_command"`
+}
+
+// Highlight marks a region of the presenter's screen for viewers. Rows and
+// columns are zero-based; an empty column range covers the whole row.
+type Highlight struct {
+	Row      int    `json:"row"`
+	RowCount int    `json:"row_count,omitempty"`
+	ColStart int    `json:"col_start,omitempty"`
+	ColEnd   int    `json:"col_end,omitempty"`
+	Color    string `json:"color,omitempty"`
+	Label    string `json:"label,omitempty"`
+}
+
+// Search looks for a regular expression in the session's scrollback and
+// screen. ID is echoed in the results so replies can be matched to
+// requests.
+type Search struct {
+	ID         string `json:"id,omitempty"`
+	Query      string `json:"query"`
+	Literal    bool   `json:"literal,omitempty"`     // match Query as plain text
+	IgnoreCase bool   `json:"ignore_case,omitempty"` // same as a (?i) prefix
+	Limit      int    `json:"limit,omitempty"`       // zero uses the server default
+	Since      string `json:"since,omitempty"`       // bookmark or byte offset to start at
+	Until      string `json:"until,omitempty"`       // bookmark or byte offset to stop at
+}
+
+// SearchResults lists matches oldest first. Lines count from the oldest
+// scrollback line; the visible screen starts at line Scrollback.
+// Columns are in characters. A match in a line that wrapped continues on
+// the following line.
+type SearchResults struct {
+	ID         string        `json:"id,omitempty"`
+	Query      string        `json:"query"`
+	Lines      int           `json:"lines"`
+	Scrollback int           `json:"scrollback"`
+	Matches    []SearchMatch `json:"matches"`
+	Truncated  bool          `json:"truncated,omitempty"`
+}
+
+// SearchMatch is one match
+type SearchMatch struct {
+	Line   int    `json:"line"`
+	Col    int    `json:"col"`
+	Length int    `json:"length"`
+	Text   string `json:"text"`
+}
+
+// Bookmark names an offset in the session's output. Offsets count bytes
+// of output since the session started.
+type Bookmark struct {
+	Name      string `json:"name"`
+	Offset    int64  `json:"offset"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// AttachError says why a connection could not be attached. Code is one of
+// the Code constants; Message is for people.
+type AttachError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Exit says how a session ended. Reason is exited, signal, oom, timeout,
+// killed, shutdown, error or disk_quota; Code is set when the process
+// exited, and Signal names the signal that ended it.
+type Exit struct {
+	Reason string `json:"reason"`
+	Code   *int   `json:"code,omitempty"`
+	Signal string `json:"signal,omitempty"`
+}
+
+// SessionEnded is how a session ended, as in Exit, with how long it ran
+// and, when ended sessions are removed after a grace period, until when
+// its output can still be viewed
+type SessionEnded struct {
+	Exit
+	Duration      float64    `json:"duration"` // seconds
+	EndedAt       time.Time  `json:"ended_at"`
+	ViewableUntil *time.Time `json:"viewable_until,omitempty"`
+}
+
+// Presence is who is attached to a session and who may type. Event and
+// UserID say what changed; Driver is empty while nobody holds control.
+type Presence struct {
+	Event        string        `json:"event,omitempty"`
+	UserID       string        `json:"user_id,omitempty"`
+	Driver       string        `json:"driver,omitempty"`
+	Participants []Participant `json:"participants"`
+	Requests     []string      `json:"requests,omitempty"` // waiting for control, oldest first
+}
+
+// Participant is one user attached to a session, with how many
+// connections they have open
+type Participant struct {
+	UserID      string `json:"user_id"`
+	Role        string `json:"role"`
+	ReadOnly    bool   `json:"read_only,omitempty"`
+	Connections int    `json:"connections"`
+}
+
+// NewMessage builds a frame of the given type with a JSON encoded payload
+func NewMessage(typ string, payload interface{}) (Message, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Type: typ, Data: string(data), Timestamp: time.Now()}, nil
+}
+
+// Decode unmarshals the frame's JSON payload into v
+func (m Message) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(m.Data), v)
+}
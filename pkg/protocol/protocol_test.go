@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagePayload(t *testing.T) {
+	msg, err := NewMessage(TypeResize, Resize{Cols: 120, Rows: 40})
+	require.NoError(t, err)
+	assert.Equal(t, TypeResize, msg.Type)
+	assert.False(t, msg.Timestamp.IsZero())
+
+	// Payloads travel as a JSON string inside the frame, as the web client
+	// sends them
+	raw, err := json.Marshal(msg)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `"data":"{\"cols\":120,\"rows\":40}"`)
+
+	var decoded Message
+	require.NoError(t, json.Unmarshal(raw, &decoded))
+	var resize Resize
+	require.NoError(t, decoded.Decode(&resize))
+	assert.Equal(t, Resize{Cols: 120, Rows: 40}, resize)
+
+	assert.Error(t, Message{Type: TypeHighlight, Data: "not json"}.Decode(&Highlight{}))
+}
+
+func TestSubprotocol(t *testing.T) {
+	assert.Equal(t, "webtunnel.v1", Subprotocol(Version1))
+	version, ok := ParseSubprotocol("webtunnel.v12")
+	assert.True(t, ok)
+	assert.Equal(t, 12, version)
+
+	for _, name := range []string{"", "chat", "webtunnel.v", "webtunnel.v0", "webtunnel.vx", "webtunnel.v01", "webtunnel.v+1"} {
+		_, ok := ParseSubprotocol(name)
+		assert.False(t, ok, name)
+	}
+}
+
+// FuzzParseSubprotocol checks that only the names Subprotocol returns are
+// accepted, so each version has exactly one name
+func FuzzParseSubprotocol(f *testing.F) {
+	for _, seed := range []string{"webtunnel.v1", "webtunnel.v12", "webtunnel.v01", "webtunnel.v+1", "webtunnel.v-1", "webtunnel.v", "chat"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		version, ok := ParseSubprotocol(name)
+		if !ok {
+			return
+		}
+		assert.GreaterOrEqual(t, version, Version1)
+		assert.Equal(t, name, Subprotocol(version))
+	})
+}
+
+// FuzzMessage feeds arbitrary frames through the decoding the server does
+// for every client frame: a decoded Message survives being encoded and
+// decoded again, and decoding its payload never panics
+func FuzzMessage(f *testing.F) {
+	for _, seed := range []string{
+		`{"type":"input","data":"ls -la\r"}`,
+		`{"type":"resize","data":"{\"cols\":120,\"rows\":40}"}`,
+		`{"type":"search","data":"{\"query\":\"err\",\"limit\":-1}","session_id":"sess_1"}`,
+		`{"type":"highlight","data":"{\"row\":1e999}"}`,
+		`{"type":"mark","data":"\ud800","timestamp":"2024-01-01T00:00:00Z"}`,
+		`{"type":1}`,
+		`[]`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		var msg Message
+		if json.Unmarshal(frame, &msg) != nil {
+			return
+		}
+		raw, err := json.Marshal(msg)
+		require.NoError(t, err)
+		var again Message
+		require.NoError(t, json.Unmarshal(raw, &again))
+		assert.Equal(t, msg.Type, again.Type)
+		assert.Equal(t, msg.Data, again.Data)
+		assert.Equal(t, msg.SessionID, again.SessionID)
+		assert.True(t, msg.Timestamp.Equal(again.Timestamp))
+
+		for _, payload := range []interface{}{&Resize{}, &Search{}, &Highlight{}, &Bookmark{}} {
+			msg.Decode(payload)
+		}
+	})
+}
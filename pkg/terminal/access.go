@@ -0,0 +1,82 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+// Access is what a user may do with a session
+type Access int
+
+const (
+	AccessNone  Access = iota
+	AccessRead         // list, get, stream output and search
+	AccessWrite        // input, resize and kill as well
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrForbidden       = errors.New("not permitted")
+	ErrSessionExited   = errors.New("session is not running")
+)
+
+// Permissions grants users access to sessions they do not own, e.g.
+// watch-only access to every session for auditors
+type Permissions interface {
+	SessionGrant(userID string) Access
+}
+
+// SetPermissions limits access to other users' sessions. Without it any
+// user may read and write any session they know the ID of.
+func (s *Service) SetPermissions(perms Permissions) {
+	s.perms = perms
+}
+
+// Access returns what userID may do with session. Owners may do anything;
+// others get what their role grants. Presenting grants nothing: its viewers
+// are those who could already read the session, or guests of a share link.
+func (s *Service) Access(session *Session, userID string) Access {
+	if s.perms == nil || session.UserID == userID {
+		return AccessWrite
+	}
+	return s.perms.SessionGrant(userID)
+}
+
+// CheckAccess looks up a session and checks that userID has at least the
+// needed access to it
+func (s *Service) CheckAccess(sessionID, userID string, need Access) (*Session, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	if s.Access(session, userID) < need {
+		return nil, fmt.Errorf("%w: %s", ErrForbidden, sessionID)
+	}
+	return session, nil
+}
+
+// ListReadableSessions returns every session when the user may read them
+// all, and otherwise the user's own
+func (s *Service) ListReadableSessions(userID string) []*Session {
+	if s.perms == nil || s.perms.SessionGrant(userID) < AccessRead {
+		return s.ListSessions(userID)
+	}
+	return s.ListAllSessions()
+}
+
+// requireWrite tells a read-only client that what it tried is refused
+func (s *Service) requireWrite(session *Session, cl *client, what string) bool {
+	if cl.access >= AccessWrite {
+		return true
+	}
+	cl.writeJSON(protocol.Message{
+		Type:      protocol.TypeError,
+		Data:      fmt.Sprintf("Read-only access; %s is disabled", what),
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	})
+	return false
+}
@@ -0,0 +1,131 @@
+package terminal
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// activityWindow is how many seconds of output the byte rate averages
+const activityWindow = 10
+
+// Activity is how busy a session is right now, for dashboards
+type Activity struct {
+	Viewers           int        `json:"viewers"` // attached connections
+	OutputBytesPerSec float64    `json:"output_bytes_per_sec"`
+	LastOutputAt      *time.Time `json:"last_output_at,omitempty"`
+	LastInputAt       *time.Time `json:"last_input_at,omitempty"`
+}
+
+// activity counts a session's output per second over the last
+// activityWindow seconds, and when it last saw input and a client leave
+type activity struct {
+	buckets   [activityWindow]int64
+	seconds   [activityWindow]int64 // the unix second each bucket counts
+	last      time.Time
+	lastInput time.Time
+	lastLeft  time.Time
+	mu        sync.Mutex
+}
+
+func (a *activity) output(n int, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	second := now.Unix()
+	i := second % activityWindow
+	if a.seconds[i] != second {
+		a.seconds[i], a.buckets[i] = second, 0
+	}
+	a.buckets[i] += int64(n)
+	a.last = now
+}
+
+func (a *activity) input(now time.Time) {
+	a.mu.Lock()
+	a.lastInput = now
+	a.mu.Unlock()
+}
+
+func (a *activity) left(now time.Time) {
+	a.mu.Lock()
+	a.lastLeft = now
+	a.mu.Unlock()
+}
+
+// rate is the average output in bytes per second over the window ending
+// at now
+func (a *activity) rate(now time.Time) (float64, time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var total int64
+	for i, second := range a.seconds {
+		if age := now.Unix() - second; age >= 0 && age < activityWindow {
+			total += a.buckets[i]
+		}
+	}
+	return float64(total) / activityWindow, a.last
+}
+
+// Activity returns the session's attached connections and recent output
+func (s *Session) Activity() Activity {
+	s.connMu.RLock()
+	viewers := len(s.connections)
+	s.connMu.RUnlock()
+
+	rate, last := s.activity.rate(time.Now())
+	result := Activity{Viewers: viewers, OutputBytesPerSec: rate}
+	if !last.IsZero() {
+		result.LastOutputAt = &last
+	}
+	s.activity.mu.Lock()
+	lastInput := s.activity.lastInput
+	s.activity.mu.Unlock()
+	if !lastInput.IsZero() {
+		result.LastInputAt = &lastInput
+	}
+	return result
+}
+
+// SessionSummary is the little a dashboard needs to show which sessions
+// are busy
+type SessionSummary struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name,omitempty"`
+	Status Status `json:"status"`
+	Activity
+}
+
+// Summary returns the session's summary
+func (s *Session) Summary() SessionSummary {
+	return SessionSummary{
+		ID:       s.ID,
+		UserID:   s.UserID,
+		Name:     s.Name(),
+		Status:   s.Status(),
+		Activity: s.Activity(),
+	}
+}
+
+// Summaries summarizes sessions, the busiest first: by output rate, then
+// by latest output
+func Summaries(sessions []*Session) []SessionSummary {
+	result := make([]SessionSummary, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, session.Summary())
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.OutputBytesPerSec != b.OutputBytesPerSec {
+			return a.OutputBytesPerSec > b.OutputBytesPerSec
+		}
+		if a.LastOutputAt == nil || b.LastOutputAt == nil {
+			return a.LastOutputAt != nil && b.LastOutputAt == nil
+		}
+		if !a.LastOutputAt.Equal(*b.LastOutputAt) {
+			return a.LastOutputAt.After(*b.LastOutputAt)
+		}
+		return a.ID < b.ID
+	})
+	return result
+}
@@ -0,0 +1,60 @@
+package terminal
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+// Close codes sent with an attach error, in the range RFC 6455 leaves to
+// applications. They mirror the HTTP status the API uses for the same
+// failure, so clients that only see the close frame can still tell.
+var attachCloseCodes = map[string]int{
+	protocol.CodeSessionNotFound: 4404,
+	protocol.CodeSessionExited:   4410,
+	protocol.CodeForbidden:       4403,
+	protocol.CodeInvalidAnchor:   4400,
+	protocol.CodeProtocolTooOld:  4426,
+	protocol.CodeInternal:        websocket.CloseInternalServerErr,
+}
+
+// AttachErrorCode maps an attach error to its protocol code
+func AttachErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		return protocol.CodeSessionNotFound
+	case errors.Is(err, ErrSessionExited):
+		return protocol.CodeSessionExited
+	case errors.Is(err, ErrForbidden):
+		return protocol.CodeForbidden
+	case errors.Is(err, ErrInvalidAnchor):
+		return protocol.CodeInvalidAnchor
+	case errors.Is(err, ErrProtocolTooOld):
+		return protocol.CodeProtocolTooOld
+	default:
+		return protocol.CodeInternal
+	}
+}
+
+// rejectWebSocket tells a client why its connection was not attached, then
+// closes it with the matching close code. The caller still closes conn.
+func rejectWebSocket(conn *websocket.Conn, sessionID string, err error) {
+	code := AttachErrorCode(err)
+	msg, merr := protocol.NewMessage(protocol.TypeAttachError, protocol.AttachError{
+		Code:    code,
+		Message: err.Error(),
+	})
+	if merr != nil {
+		return
+	}
+	msg.SessionID = sessionID
+
+	deadline := time.Now().Add(5 * time.Second)
+	conn.SetWriteDeadline(deadline)
+	if conn.WriteJSON(msg) != nil {
+		return
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(attachCloseCodes[code], code), deadline)
+}
@@ -0,0 +1,94 @@
+package terminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+var ErrBannerNotAcknowledged = errors.New("the session banner must be acknowledged first")
+
+// Banner is a notice shown when a session starts, e.g. a legal warning.
+// Its ID changes with its text, so a new text needs a new acknowledgment.
+type Banner struct {
+	ID         string `json:"id"`
+	Text       string `json:"text"`
+	RequireAck bool   `json:"require_ack,omitempty"`
+
+	// Notice sends it to each client as it attaches; Print writes it into
+	// the terminal ahead of the process's output, so it is also in the
+	// scrollback and the recording
+	Notice bool `json:"-"`
+	Print  bool `json:"-"`
+}
+
+// BannerError refuses a session whose banner the user has not yet
+// acknowledged, and carries the banner to show them
+type BannerError struct {
+	Banner *Banner
+}
+
+func (e *BannerError) Error() string {
+	return ErrBannerNotAcknowledged.Error()
+}
+
+func (e *BannerError) Unwrap() error {
+	return ErrBannerNotAcknowledged
+}
+
+// Banners decides the banner for a user's sessions and whether they have
+// acknowledged it
+type Banners interface {
+	Banner(ctx context.Context, userID string) (*Banner, error) // nil for none
+	Acknowledged(ctx context.Context, userID, bannerID string) (bool, error)
+}
+
+// SetBanners shows banners at session start
+func (s *Service) SetBanners(banners Banners) {
+	s.banners = banners
+}
+
+// banner returns the banner for a new session of the user's, refusing it
+// with a BannerError if the banner needs an acknowledgment they have not
+// given
+func (s *Service) banner(ctx context.Context, userID string) (*Banner, error) {
+	if s.banners == nil {
+		return nil, nil
+	}
+	banner, err := s.banners.Banner(ctx, userID)
+	if err != nil || banner == nil {
+		return nil, err
+	}
+	if banner.RequireAck {
+		acknowledged, err := s.banners.Acknowledged(ctx, userID, banner.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check banner acknowledgment: %w", err)
+		}
+		if !acknowledged {
+			return nil, &BannerError{Banner: banner}
+		}
+	}
+	return banner, nil
+}
+
+// printBanner writes the banner into a new session's output before its
+// process has written anything
+func (s *Service) printBanner(session *Session) {
+	text := strings.ReplaceAll(strings.TrimRight(session.banner.Text, "\n"), "\n", "\r\n") + "\r\n\r\n"
+	s.feedWaiters(session, []byte(text))
+	session.recorder.output([]byte(text))
+}
+
+// sendBanner sends the banner to a client that has just attached
+func (s *Service) sendBanner(session *Session, cl *client) error {
+	return cl.writeJSON(protocol.Message{
+		Type:      protocol.TypeNotice,
+		Data:      session.banner.Text,
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	})
+}
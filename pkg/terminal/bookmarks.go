@@ -0,0 +1,193 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// maxBookmarks caps the bookmarks kept per session
+const maxBookmarks = 100
+
+var (
+	ErrInvalidBookmark  = errors.New("bookmark names must be 1 to 64 letters, digits, '.', '_' or '-', starting with a letter")
+	ErrBookmarkExists   = errors.New("bookmark already exists")
+	ErrTooManyBookmarks = errors.New("session has too many bookmarks")
+	ErrInvalidAnchor    = errors.New("invalid output anchor")
+)
+
+var bookmarkName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]{0,63}$`)
+
+// Bookmark names an offset in the session's output, so transcripts,
+// searches and replays can start or stop there
+type Bookmark struct {
+	Name      string    `json:"name"`
+	Offset    int64     `json:"offset"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Transcript is the session output between two offsets. Output older than
+// the retained buffer is gone, in which case Since is moved up to the
+// oldest byte kept and Truncated is set.
+type Transcript struct {
+	Since     int64  `json:"since"`
+	Until     int64  `json:"until"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Output    string `json:"output"`
+}
+
+// Mark drops a bookmark at the current output offset and tells every
+// client attached to the session about it
+func (s *Service) Mark(sessionID, userID, name string) (*Bookmark, error) {
+	if !bookmarkName.MatchString(name) {
+		return nil, ErrInvalidBookmark
+	}
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	session.waitMu.Lock()
+	for _, b := range session.bookmarks {
+		if b.Name == name {
+			session.waitMu.Unlock()
+			return nil, fmt.Errorf("%w: %s", ErrBookmarkExists, name)
+		}
+	}
+	if len(session.bookmarks) >= maxBookmarks {
+		session.waitMu.Unlock()
+		return nil, ErrTooManyBookmarks
+	}
+	bookmark := Bookmark{
+		Name:      name,
+		Offset:    session.outputOffset,
+		CreatedBy: userID,
+		CreatedAt: time.Now(),
+	}
+	session.bookmarks = append(session.bookmarks, bookmark)
+	session.waitMu.Unlock()
+
+	session.logger.Info("Bookmark added",
+		zap.String("bookmark", name),
+		zap.Int64("offset", bookmark.Offset),
+		zap.String("user_id", userID))
+
+	msg, err := protocol.NewMessage(protocol.TypeBookmark, protocol.Bookmark{
+		Name:      bookmark.Name,
+		Offset:    bookmark.Offset,
+		CreatedBy: bookmark.CreatedBy,
+	})
+	if err == nil {
+		msg.SessionID = session.ID
+		s.broadcast(session, msg, nil)
+	}
+	return &bookmark, nil
+}
+
+// Bookmarks lists the session's bookmarks in the order they were dropped
+func (s *Service) Bookmarks(sessionID string) ([]Bookmark, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	session.waitMu.Lock()
+	defer session.waitMu.Unlock()
+	return append([]Bookmark{}, session.bookmarks...), nil
+}
+
+// Transcript returns the output between two anchors, each a bookmark name
+// or a byte offset. An empty since starts at the oldest retained output and
+// an empty until ends at the newest.
+func (s *Service) Transcript(sessionID, since, until string) (*Transcript, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	return s.outputRange(session, since, until)
+}
+
+// ResolveAnchor returns the output offset a bookmark name or byte offset
+// refers to, so callers can reject a bad anchor before attaching
+func (s *Service) ResolveAnchor(sessionID, anchor string) (int64, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	session.waitMu.Lock()
+	defer session.waitMu.Unlock()
+	return resolveAnchor(session, anchor, session.outputOffset)
+}
+
+// outputRange resolves the anchors against the session and cuts the
+// retained output between them
+func (s *Service) outputRange(session *Session, since, until string) (*Transcript, error) {
+	session.waitMu.Lock()
+	defer session.waitMu.Unlock()
+
+	buf := session.outputBuf.Read()
+	end := session.outputOffset
+	start := end - int64(len(buf))
+
+	from, err := resolveAnchor(session, since, start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolveAnchor(session, until, end)
+	if err != nil {
+		return nil, err
+	}
+	if to > end {
+		to = end
+	}
+	if from > to {
+		return nil, fmt.Errorf("%w: %q comes after %q", ErrInvalidAnchor, since, until)
+	}
+
+	t := &Transcript{Since: from, Until: to}
+	if from < start {
+		t.Since, t.Truncated = min(start, to), true
+	}
+	if to > t.Since {
+		t.Output = string(buf[t.Since-start : to-start])
+	}
+	return t, nil
+}
+
+// resolveAnchor resolves a bookmark name or byte offset; an empty anchor is def.
+// Callers hold waitMu.
+func resolveAnchor(session *Session, anchor string, def int64) (int64, error) {
+	if anchor == "" {
+		return def, nil
+	}
+	if offset, err := strconv.ParseInt(anchor, 10, 64); err == nil {
+		if offset < 0 {
+			return 0, fmt.Errorf("%w: negative offset %d", ErrInvalidAnchor, offset)
+		}
+		return offset, nil
+	}
+	for _, b := range session.bookmarks {
+		if b.Name == anchor {
+			return b.Offset, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no bookmark named %q", ErrInvalidAnchor, anchor)
+}
+
+// handleMark drops the bookmark named in a mark frame. Marking does not
+// change the session, so read-only clients may do it too.
+func (s *Service) handleMark(session *Session, cl *client, msg protocol.Message) {
+	if _, err := s.Mark(session.ID, cl.userID, msg.Data); err != nil {
+		cl.writeJSON(protocol.Message{
+			Type:      protocol.TypeError,
+			Data:      fmt.Sprintf("Failed to add bookmark: %v", err),
+			Timestamp: time.Now(),
+			SessionID: session.ID,
+		})
+	}
+}
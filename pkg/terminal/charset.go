@@ -0,0 +1,97 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var ErrInvalidCharset = errors.New("invalid charset")
+
+// maxPending caps the bytes of a character split across reads that are
+// held for the next one; no supported charset needs more
+const maxPending = 16
+
+// transcoder converts a session's output from its charset to the UTF-8
+// clients render, and their input back. Output is decoded by the session's
+// one reader and input encoded under inputSem, so neither side locks.
+type transcoder struct {
+	decoder *encoding.Decoder
+	encoder *encoding.Encoder
+	pending []byte // the start of a character the last read cut off
+}
+
+// lookupCharset resolves an IANA charset name or alias, such as
+// ISO-8859-1, latin1 or Shift_JIS, to its canonical name and a transcoder.
+// Empty and UTF-8 need no transcoding and return a nil transcoder.
+func lookupCharset(name string) (string, *transcoder, error) {
+	if name == "" {
+		return "", nil, nil
+	}
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return "", nil, fmt.Errorf("%w: %q is not supported", ErrInvalidCharset, name)
+	}
+	if enc == unicode.UTF8 {
+		return "", nil, nil
+	}
+	// The MIME names are the familiar ones, ISO-8859-1 rather than
+	// ISO_8859-1:1987
+	canonical, err := ianaindex.MIME.Name(enc)
+	if err != nil {
+		if canonical, err = ianaindex.IANA.Name(enc); err != nil {
+			canonical = name
+		}
+	}
+	return canonical, &transcoder{
+		decoder: enc.NewDecoder(),
+		// Characters the charset lacks are sent as its substitute
+		encoder: encoding.ReplaceUnsupported(enc.NewEncoder()),
+	}, nil
+}
+
+// decode converts a read of output to UTF-8. A multibyte character cut off
+// at the end is held back and decoded with the next read.
+func (t *transcoder) decode(output []byte) []byte {
+	src := output
+	if len(t.pending) > 0 {
+		src = append(t.pending, output...)
+		t.pending = nil
+	}
+	decoded := make([]byte, 0, len(src)*2)
+	dst := make([]byte, len(src)*3+utf8.UTFMax)
+	for {
+		nDst, nSrc, err := t.decoder.Transform(dst, src, false)
+		decoded = append(decoded, dst[:nDst]...)
+		src = src[nSrc:]
+		switch {
+		case err == transform.ErrShortDst:
+			if nDst == 0 && nSrc == 0 {
+				dst = make([]byte, len(dst)*2)
+			}
+			continue
+		case err == transform.ErrShortSrc && len(src) <= maxPending:
+			t.pending = append([]byte(nil), src...)
+		case err != nil || len(src) > 0:
+			// Decoders replace what they cannot decode, so this is a
+			// charset that gave up; pass the rest through as it is
+			decoded = append(decoded, src...)
+			t.decoder.Reset()
+		}
+		return decoded
+	}
+}
+
+// encode converts input typed in UTF-8 to the session's charset
+func (t *transcoder) encode(input []byte) []byte {
+	encoded, err := t.encoder.Bytes(input)
+	if err != nil {
+		return input
+	}
+	return encoded
+}
@@ -0,0 +1,144 @@
+package terminal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/pkg/meter"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+// maxClientMessage is the largest message a client may send
+const maxClientMessage = 512
+
+// transport carries JSON frames between a session and one client. It is
+// the subset of *websocket.Conn the session needs, so WebSockets attach
+// as-is and other transports (long polling) mimic it.
+type transport interface {
+	WriteMessage(messageType int, data []byte) error
+	NextReader() (messageType int, r io.Reader, err error)
+	Close() error
+}
+
+// client is one connection attached to a session. gorilla/websocket allows
+// only one concurrent writer per connection, so all writes go through
+// writeMu.
+type client struct {
+	conn    transport
+	userID  string
+	access  Access
+	traffic *meter.Conn // nil when metering is off
+	latency *latency
+	writeMu sync.Mutex
+
+	// viewport shapes output frames; nil when the client asked for none
+	viewport *viewport
+
+	// cols and rows are the window size the client last reported, zero
+	// until it does; guarded by the session's connMu
+	cols, rows uint16
+
+	// done is closed when the client disconnects
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(conn transport, userID string) *client {
+	return &client{
+		conn:    conn,
+		userID:  userID,
+		latency: newLatency(),
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *client) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(websocket.TextMessage, data)
+}
+
+// writeMessage may block first when the connection is over its bandwidth cap
+func (c *client) writeMessage(messageType int, data []byte) error {
+	if c.traffic != nil {
+		if err := c.traffic.Out(context.Background(), len(data)); err != nil {
+			return err
+		}
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// readJSON is conn.ReadJSON with the frame counted as inbound traffic
+func (c *client) readJSON(v interface{}) error {
+	_, r, err := c.conn.NextReader()
+	if err != nil {
+		return err
+	}
+	if c.traffic != nil {
+		r = c.traffic.Reader(context.Background(), r)
+	}
+
+	err = json.NewDecoder(r).Decode(v)
+	if err == io.EOF {
+		// One value is expected in the message.
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.viewport != nil {
+			c.viewport.stop()
+		}
+		if c.traffic != nil {
+			c.traffic.Close()
+		}
+	})
+	c.conn.Close()
+}
+
+// isOwner reports whether the client belongs to the session's owner
+func (c *client) isOwner(session *Session) bool {
+	return c.userID == session.UserID
+}
+
+// Disconnect closes the session's connections whose user match picks, e.g.
+// guests whose share link was revoked, telling each why first. It returns
+// how many it closed.
+func (s *Service) Disconnect(sessionID, reason string, match func(userID string) bool) int {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return 0
+	}
+
+	session.connMu.RLock()
+	var picked []*client
+	for _, cl := range session.connections {
+		if match(cl.userID) {
+			picked = append(picked, cl)
+		}
+	}
+	session.connMu.RUnlock()
+
+	for _, cl := range picked {
+		cl.writeJSON(protocol.Message{
+			Type:      protocol.TypeError,
+			Data:      reason,
+			Timestamp: time.Now(),
+			SessionID: sessionID,
+		})
+		cl.close()
+	}
+	return len(picked)
+}
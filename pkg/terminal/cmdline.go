@@ -0,0 +1,162 @@
+package terminal
+
+import (
+	"slices"
+	"strings"
+)
+
+// commandPrefixes are the keywords and builtins a simple command may start
+// with before the program it runs
+var commandPrefixes = map[string]bool{
+	"!": true, "{": true, "}": true,
+	"if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"while": true, "until": true, "do": true, "done": true,
+	"time": true, "exec": true, "command": true, "builtin": true,
+}
+
+// commandPrograms returns the program each simple command in a command
+// line runs, in order: the commands between ;, &, |, newlines and
+// parentheses, and those in command substitutions. Quotes and backslashes
+// are removed, and leading keywords, variable assignments and
+// redirections skipped, so "FOO=1 \rm" and "if x; then 'rm'; fi" both run
+// rm. complete is false when the line could not be split with certainty,
+// such as with an unclosed quote or a substitution inside double quotes.
+//
+// Programs that run others, such as env, sudo, xargs or sh -c, are
+// reported as themselves, so blocked_commands cannot see past them; only
+// allowed_commands fails closed.
+func commandPrograms(command string) (programs []string, complete bool) {
+	complete = true
+	var words []string // the current simple command
+	var word strings.Builder
+	inWord := false
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if program := simpleProgram(words); program != "" {
+			programs = append(programs, program)
+		}
+		words = words[:0]
+	}
+
+	runes := []rune(command)
+	var quote rune
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			// A backslash before a newline continues the line
+			if next != 0 {
+				i++
+				if next != '\n' {
+					word.WriteRune(next)
+					inWord = true
+				}
+			}
+		case r == '`' || (r == '$' && next == '('):
+			// A command substitution runs commands of its own. Inside
+			// double quotes where it ends is not tracked.
+			if quote == '"' {
+				quote, complete = 0, false
+			}
+			if r == '$' {
+				i++
+			}
+			endCommand()
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == '<' || r == '>' || (r == '&' && next == '>'):
+			// A redirection, with any file descriptor number before it
+			// and & after it, is followed by its target
+			if inWord && strings.Trim(word.String(), "0123456789") == "" {
+				word.Reset()
+				inWord = false
+			}
+			endWord()
+			words = append(words, ">")
+			for i+1 < len(runes) && (runes[i+1] == '<' || runes[i+1] == '>') {
+				i++
+			}
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				i++
+			}
+		case strings.ContainsRune(";&|()\n", r):
+			endCommand()
+		case r == ' ' || r == '\t':
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		complete = false
+	}
+	endCommand()
+	return programs, complete
+}
+
+// simpleProgram returns the program a simple command's words run, if any
+func simpleProgram(words []string) string {
+	target := false
+	for _, w := range words {
+		switch {
+		case w == ">":
+			target = true
+		case target:
+			target = false
+		case commandPrefixes[w]:
+		case isAssignment(w):
+		default:
+			return w
+		}
+	}
+	return ""
+}
+
+// isAssignment reports whether a word sets a variable, as in FOO=1 cmd
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	return ok && envName.MatchString(name)
+}
+
+// allowedBy reports whether a list such as allowed_commands lets command
+// run: an entry must be the whole command line or, if every simple command
+// in it was found, each program it runs
+func allowedBy(list []string, command string, programs []string, complete bool) bool {
+	if slices.Contains(list, command) {
+		return true
+	}
+	if !complete || len(programs) == 0 {
+		return false
+	}
+	for _, program := range programs {
+		if !slices.Contains(list, program) {
+			return false
+		}
+	}
+	return true
+}
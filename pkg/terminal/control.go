@@ -0,0 +1,259 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNotDriver   = errors.New("another user has control of the session")
+	ErrCannotDrive = errors.New("user cannot type in this session")
+)
+
+// Presence returns who is attached to the session and who may type
+func (s *Service) Presence(sessionID string) (*protocol.Presence, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	presence := session.presence("", "")
+	return &presence, nil
+}
+
+// RequestControl asks for the session's control on behalf of userID, who
+// gets it at once when nobody holds it. Otherwise the request waits for
+// the driver or the owner to grant it.
+func (s *Service) RequestControl(sessionID, userID string) (*protocol.Presence, error) {
+	session, err := s.CheckAccess(sessionID, userID, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	event := protocol.PresenceRequested
+	session.controlMu.Lock()
+	switch {
+	case session.driver == "" || session.driver == userID:
+		session.driver = userID
+		session.requests = without(session.requests, userID)
+		event = protocol.PresenceGranted
+	case !contains(session.requests, userID):
+		session.requests = append(session.requests, userID)
+	}
+	session.controlMu.Unlock()
+
+	session.logger.Info("Control requested", zap.String("client_user_id", userID), zap.String("result", event))
+	if event == protocol.PresenceGranted {
+		s.arbitrateSize(session, SizeControl, userID)
+	}
+	return s.announce(session, event, userID), nil
+}
+
+// GrantControl hands the session's control to toUserID. Only the driver
+// and the owner may hand it over, and anyone with write access may while
+// nobody holds it.
+func (s *Service) GrantControl(sessionID, byUserID, toUserID string) (*protocol.Presence, error) {
+	session, err := s.CheckAccess(sessionID, byUserID, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+	if !s.canDrive(session, toUserID) {
+		return nil, fmt.Errorf("%w: %s", ErrCannotDrive, toUserID)
+	}
+
+	session.controlMu.Lock()
+	if session.driver != "" && session.driver != byUserID && session.UserID != byUserID {
+		session.controlMu.Unlock()
+		return nil, fmt.Errorf("%w: only the driver or the owner can hand over control", ErrForbidden)
+	}
+	session.driver = toUserID
+	session.requests = without(session.requests, toUserID)
+	session.controlMu.Unlock()
+
+	session.logger.Info("Control granted",
+		zap.String("client_user_id", toUserID),
+		zap.String("granted_by", byUserID))
+	s.arbitrateSize(session, SizeControl, toUserID)
+	return s.announce(session, protocol.PresenceGranted, toUserID), nil
+}
+
+// ReleaseControl gives up the session's control, after which anyone with
+// write access may type again. The driver and the owner may release it.
+func (s *Service) ReleaseControl(sessionID, userID string) (*protocol.Presence, error) {
+	session, err := s.CheckAccess(sessionID, userID, AccessWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	session.controlMu.Lock()
+	driver := session.driver
+	if driver != "" && driver != userID && session.UserID != userID {
+		session.controlMu.Unlock()
+		return nil, fmt.Errorf("%w: only the driver or the owner can release control", ErrForbidden)
+	}
+	session.driver = ""
+	session.controlMu.Unlock()
+
+	if driver == "" {
+		presence := session.presence("", "")
+		return &presence, nil
+	}
+	session.logger.Info("Control released", zap.String("client_user_id", driver), zap.String("released_by", userID))
+	s.arbitrateSize(session, SizeControl, userID)
+	return s.announce(session, protocol.PresenceReleased, driver), nil
+}
+
+// CheckControl refuses input from anyone but the driver while someone
+// holds the session's control
+func (s *Service) CheckControl(session *Session, userID string) error {
+	session.controlMu.Lock()
+	driver := session.driver
+	session.controlMu.Unlock()
+	if driver != "" && driver != userID {
+		return fmt.Errorf("%w: %s", ErrNotDriver, driver)
+	}
+	return nil
+}
+
+// requireControl tells a client that is not the driver that what it tried
+// is refused
+func (s *Service) requireControl(session *Session, cl *client, what string) bool {
+	err := s.CheckControl(session, cl.userID)
+	if err == nil {
+		return true
+	}
+	cl.writeJSON(protocol.Message{
+		Type:      protocol.TypeError,
+		Data:      fmt.Sprintf("%v; %s is disabled until you are given control", err, what),
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	})
+	return false
+}
+
+// canDrive reports whether userID could type in the session: they have
+// write access, or are attached with it, as guests of a share link are
+func (s *Service) canDrive(session *Session, userID string) bool {
+	if s.Access(session, userID) >= AccessWrite {
+		return true
+	}
+	session.connMu.RLock()
+	defer session.connMu.RUnlock()
+	for _, cl := range session.connections {
+		if cl.userID == userID && cl.access >= AccessWrite {
+			return true
+		}
+	}
+	return false
+}
+
+// left forgets a user whose last connection to the session has closed,
+// releasing control if they held it, refits the PTY to who remains and
+// tells the others
+func (s *Service) left(session *Session, userID string) {
+	if !session.attached(userID) {
+		session.controlMu.Lock()
+		released := session.driver == userID
+		if released {
+			session.driver = ""
+		}
+		session.requests = without(session.requests, userID)
+		session.controlMu.Unlock()
+
+		if released {
+			session.logger.Info("Control released by leaving", zap.String("client_user_id", userID))
+		}
+	}
+	s.arbitrateSize(session, SizeLeft, userID)
+	s.announce(session, protocol.PresenceLeft, userID)
+}
+
+// attached reports whether userID has a connection to the session
+func (s *Session) attached(userID string) bool {
+	s.connMu.RLock()
+	defer s.connMu.RUnlock()
+	for _, cl := range s.connections {
+		if cl.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// announce sends the session's presence to everyone attached and returns it
+func (s *Service) announce(session *Session, event, userID string) *protocol.Presence {
+	presence := session.presence(event, userID)
+	msg, err := protocol.NewMessage(protocol.TypePresence, presence)
+	if err != nil {
+		s.logger.Error("Failed to encode presence", zap.Error(err))
+		return &presence
+	}
+	msg.SessionID = session.ID
+	s.broadcast(session, msg, nil)
+	return &presence
+}
+
+// presence lists the attached users by ID with their roles
+func (s *Session) presence(event, userID string) protocol.Presence {
+	s.controlMu.Lock()
+	driver := s.driver
+	requests := append([]string(nil), s.requests...)
+	s.controlMu.Unlock()
+
+	byUser := make(map[string]*protocol.Participant)
+	s.connMu.RLock()
+	for _, cl := range s.connections {
+		p, ok := byUser[cl.userID]
+		if !ok {
+			p = &protocol.Participant{UserID: cl.userID, ReadOnly: true}
+			byUser[cl.userID] = p
+		}
+		p.Connections++
+		if cl.access >= AccessWrite {
+			p.ReadOnly = false
+		}
+	}
+	s.connMu.RUnlock()
+
+	participants := make([]protocol.Participant, 0, len(byUser))
+	for _, p := range byUser {
+		p.Role = protocol.RoleObserver
+		if !p.ReadOnly && (driver == "" || driver == p.UserID) {
+			p.Role = protocol.RoleDriver
+		}
+		participants = append(participants, *p)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].UserID < participants[j].UserID
+	})
+	return protocol.Presence{
+		Event:        event,
+		UserID:       userID,
+		Driver:       driver,
+		Participants: participants,
+		Requests:     requests,
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func without(list []string, value string) []string {
+	result := list[:0]
+	for _, v := range list {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+const (
+	EventElevated       = "session.elevated"
+	EventElevationEnded = "session.elevation_ended"
+)
+
+var ErrNotElevated = errors.New("session is not elevated")
+
+// elevation is a session's time-limited elevated mode. While it lasts the
+// commands in elevated_commands pass the command lists, and policy input
+// carries "elevated" so rules can tell.
+type elevation struct {
+	until     time.Time
+	grantedBy string
+	timer     *time.Timer
+}
+
+// Elevate puts the session in elevated mode for d, replacing any elevation
+// it already has. The stricter policy resumes by itself once d is up.
+func (s *Service) Elevate(sessionID, grantedBy string, d time.Duration) (time.Time, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	until := time.Now().Add(d)
+	e := &elevation{until: until, grantedBy: grantedBy}
+	e.timer = time.AfterFunc(d, func() {
+		s.endElevation(session, e, "expired")
+	})
+
+	session.waitMu.Lock()
+	if session.elevated != nil {
+		session.elevated.timer.Stop()
+	}
+	session.elevated = e
+	session.waitMu.Unlock()
+
+	session.logger.Info("Session elevated",
+		zap.String("granted_by", grantedBy),
+		zap.Time("until", until))
+	s.sessionNotice(session, fmt.Sprintf("Elevated mode is on until %s", until.UTC().Format("15:04:05 MST")))
+	s.emit(session, EventElevated, map[string]string{
+		"granted_by": grantedBy,
+		"until":      until.UTC().Format(time.RFC3339),
+	})
+	return until, nil
+}
+
+// EndElevation drops the session back to the standard policy early
+func (s *Service) EndElevation(sessionID string) error {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	session.waitMu.Lock()
+	e := session.elevated
+	session.waitMu.Unlock()
+	if e == nil || !s.endElevation(session, e, "ended") {
+		return ErrNotElevated
+	}
+	return nil
+}
+
+// endElevation ends e if it is still the session's elevation
+func (s *Service) endElevation(session *Session, e *elevation, how string) bool {
+	session.waitMu.Lock()
+	if session.elevated != e {
+		session.waitMu.Unlock()
+		return false
+	}
+	session.elevated = nil
+	session.waitMu.Unlock()
+	e.timer.Stop()
+
+	session.logger.Info("Session elevation ended", zap.String("how", how))
+	s.sessionNotice(session, "Elevated mode has ended, the standard policy applies again")
+	s.emit(session, EventElevationEnded, map[string]string{
+		"granted_by": e.grantedBy,
+		"how":        how,
+	})
+	return true
+}
+
+// ElevatedUntil reports when the session's elevated mode ends, if it is on
+func (s *Session) ElevatedUntil() (time.Time, bool) {
+	s.waitMu.Lock()
+	defer s.waitMu.Unlock()
+	if s.elevated == nil {
+		return time.Time{}, false
+	}
+	return s.elevated.until, true
+}
+
+func (s *Service) sessionNotice(session *Session, text string) {
+	s.broadcast(session, protocol.Message{
+		Type:      protocol.TypeNotice,
+		Data:      text,
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	}, nil)
+}
@@ -0,0 +1,58 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrEnvNotAllowed = errors.New("environment variable not allowed")
+
+// reservedEnv are the variables the server sets for each session, which no
+// one may ask to change
+var reservedEnv = []string{"WEBTUNNEL_*"}
+
+// envName is a portable variable name
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkEnv validates variables a user asked for: names must match
+// allowed_env_vars, if any are listed, and none of blocked_env_vars, which
+// take patterns such as LD_*. It returns them as KEY=value, by name.
+func (s *Service) checkEnv(vars map[string]string) ([]string, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]string, 0, len(vars))
+	for _, name := range names {
+		if !envName.MatchString(name) {
+			return nil, fmt.Errorf("%w: invalid name %q", ErrEnvNotAllowed, name)
+		}
+		if len(s.config.AllowedEnvVars) > 0 && !matchesEnv(s.config.AllowedEnvVars, name) {
+			return nil, fmt.Errorf("%w: %s", ErrEnvNotAllowed, name)
+		}
+		if matchesEnv(s.config.BlockedEnvVars, name) || matchesEnv(reservedEnv, name) {
+			return nil, fmt.Errorf("%w: %s is blocked", ErrEnvNotAllowed, name)
+		}
+		if strings.IndexByte(vars[name], 0) >= 0 {
+			return nil, fmt.Errorf("%w: %s contains a NUL byte", ErrEnvNotAllowed, name)
+		}
+		env = append(env, name+"="+vars[name])
+	}
+	return env, nil
+}
+
+// matchesEnv reports whether name matches one of the patterns
+func matchesEnv(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
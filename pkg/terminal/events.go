@@ -0,0 +1,95 @@
+package terminal
+
+import (
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	EventSessionExited = "session.exited"
+	EventOutputAlert   = "session.output_alert"
+	EventIdleWarning   = "session.idle_warning"
+	// EventTrafficThreshold fires once a session's input or output passes
+	// one of input_thresholds or output_thresholds, for listeners that
+	// start recording it, throttle it or tell someone
+	EventTrafficThreshold = "session.traffic_threshold"
+)
+
+// alertCooldown limits output alerts to one per session per interval.
+const alertCooldown = time.Minute
+
+// Event describes something that happened to a session, for listeners that
+// notify users outside the terminal itself.
+type Event struct {
+	Type      string            `json:"type"`
+	SessionID string            `json:"session_id"`
+	UserID    string            `json:"user_id"`
+	Command   string            `json:"command"`
+	Detail    map[string]string `json:"detail,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+type EventHandler func(Event)
+
+// OnEvent registers a listener for session events. Listeners run on their
+// own goroutine and must not assume any ordering between events.
+func (s *Service) OnEvent(handler EventHandler) {
+	s.handlersMu.Lock()
+	s.handlers = append(s.handlers, handler)
+	s.handlersMu.Unlock()
+}
+
+func (s *Service) emit(session *Session, eventType string, detail map[string]string) {
+	event := Event{
+		Type:      eventType,
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		Command:   session.Command,
+		Detail:    detail,
+		Timestamp: time.Now(),
+	}
+
+	s.handlersMu.RLock()
+	handlers := s.handlers
+	s.handlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		go handler(event)
+	}
+}
+
+// compileAlertPatterns prepares the configured output alert patterns,
+// skipping any that do not compile.
+func compileAlertPatterns(patterns []string, logger *zap.Logger) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warn("Ignoring invalid alert pattern", zap.String("pattern", pattern), zap.Error(err))
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// checkAlerts emits EventOutputAlert when output matches an alert pattern.
+// Matches are only found within a single chunk.
+func (s *Service) checkAlerts(session *Session, output []byte) {
+	if len(s.alertPatterns) == 0 || time.Since(session.lastAlert) < alertCooldown {
+		return
+	}
+
+	for _, re := range s.alertPatterns {
+		if match := re.Find(output); match != nil {
+			session.lastAlert = time.Now()
+			s.emit(session, EventOutputAlert, map[string]string{
+				"pattern": re.String(),
+				"match":   string(match),
+			})
+			return
+		}
+	}
+}
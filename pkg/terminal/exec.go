@@ -0,0 +1,250 @@
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultExecTimeout bounds a one-shot command that names no timeout
+	DefaultExecTimeout = 30 * time.Second
+
+	// maxExecOutput caps what is kept of each of stdout and stderr; the
+	// command can write more, but the rest is dropped
+	maxExecOutput = 1 << 20
+
+	// execWaitDelay is how long a command that ran out of time gets to
+	// close its output once killed, in case a child kept it open
+	execWaitDelay = 2 * time.Second
+)
+
+var ErrInvalidExec = errors.New("invalid exec request")
+
+// ExecOptions describes a one-shot command
+type ExecOptions struct {
+	Command string
+
+	// WorkingDir is the base directory, as for sessions; the command runs
+	// in a fresh directory under it that is removed afterwards
+	WorkingDir string
+
+	// Stdin is written to the command's standard input, which is empty
+	// otherwise
+	Stdin string
+
+	// Timeout kills the command, and everything it started, once it has
+	// run this long; zero uses DefaultExecTimeout
+	Timeout time.Duration
+
+	// Artifacts are globs relative to the command's directory, e.g.
+	// "dist/*.tar.gz"; matching files are kept once it finishes. They need
+	// an artifact store.
+	Artifacts []string
+}
+
+// Artifact is a file kept from a one-shot command's directory. Skipped
+// says why a match was not kept, e.g. it was over the size limit.
+type Artifact struct {
+	Name    string `json:"name"` // path relative to the command's directory
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// ExecResult is how a one-shot command ended and what it wrote
+type ExecResult struct {
+	ID         string `json:"id"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exit_code"`        // -1 when a signal ended it
+	Signal     string `json:"signal,omitempty"` // e.g. SIGKILL
+	TimedOut   bool   `json:"timed_out"`
+	Truncated  bool   `json:"truncated,omitempty"` // stdout or stderr went past the cap
+	DurationMS int64  `json:"duration_ms"`
+
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// Exec runs a command without a PTY and waits for it, capturing stdout and
+// stderr separately. It passes the same admission, command list, policy
+// and sandbox checks as a new session, and holds one of the user's session
+// slots while it runs. A command that runs out of time is killed and
+// reported with TimedOut; if ctx is canceled it is killed and Exec returns
+// ctx's error.
+func (s *Service) Exec(ctx context.Context, userID string, opts ExecOptions) (*ExecResult, error) {
+	if strings.TrimSpace(opts.Command) == "" {
+		return nil, fmt.Errorf("%w: command is required", ErrInvalidExec)
+	}
+	if opts.Timeout < 0 {
+		return nil, fmt.Errorf("%w: timeout cannot be negative", ErrInvalidExec)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultExecTimeout
+	}
+	if err := s.checkArtifacts(opts.Artifacts); err != nil {
+		return nil, err
+	}
+
+	if s.admit != nil {
+		if err := s.admit.Admit(userID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkCommand(opts.Command, false); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, userID, "session.exec", map[string]interface{}{
+		"command":     opts.Command,
+		"working_dir": opts.WorkingDir,
+	}); err != nil {
+		return nil, err
+	}
+	if err := s.reserveSlot(userID); err != nil {
+		s.failures.add(FailureQuota, "", "")
+		return nil, err
+	}
+	defer s.releaseSlot(userID)
+
+	var profile string
+	if s.sandbox != nil {
+		var err error
+		if profile, err = s.sandbox.Profile(userID); err != nil {
+			return nil, fmt.Errorf("failed to select sandbox profile: %w", err)
+		}
+	}
+
+	workingDir := opts.WorkingDir
+	if workingDir == "" {
+		workingDir = s.config.WorkingDirectory
+	}
+	id := generateSessionID()
+	dir := filepath.Join(workingDir, "sessions", id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create exec directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	runCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	// An interactive shell would wait for a terminal; always run -c
+	command := opts.Command
+	if isShell(command) {
+		command = "exec " + command
+	}
+	cmd, _, err := s.command(runCtx, id, userID, command, dir, profile, "", false, nil)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := &cappedBuffer{max: maxExecOutput}, &cappedBuffer{max: maxExecOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if opts.Stdin != "" {
+		cmd.Stdin = strings.NewReader(opts.Stdin)
+	}
+	// Its own process group, so a timeout kills what it started too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = execWaitDelay
+
+	logger := s.logger.With(zap.String("exec_id", id), zap.String("user_id", userID))
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		s.failures.add(FailurePTYStart, id, "")
+		return nil, fmt.Errorf("failed to start process: %w", err)
+	}
+	waitErr := cmd.Wait()
+	if errors.Is(ctx.Err(), context.Canceled) {
+		logger.Info("Exec canceled", zap.String("command", opts.Command))
+		return nil, ctx.Err()
+	}
+
+	result := &ExecResult{
+		ID:         id,
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		ExitCode:   exitCode(waitErr),
+		TimedOut:   runCtx.Err() != nil,
+		Truncated:  stdout.truncated || stderr.truncated,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			result.Signal = unix.SignalName(status.Signal())
+		}
+	}
+
+	// Collected whatever the exit code; a failed build's logs are often
+	// the artifact wanted
+	if len(opts.Artifacts) > 0 {
+		artifacts, err := s.artifacts.Collect(id, userID, dir, opts.Artifacts)
+		if err != nil {
+			logger.Error("Failed to collect artifacts", zap.Error(err))
+		}
+		result.Artifacts = artifacts
+	}
+
+	logger.Info("Exec finished",
+		zap.String("command", opts.Command),
+		zap.Int("exit_code", result.ExitCode),
+		zap.Bool("timed_out", result.TimedOut),
+		zap.Int("artifacts", len(result.Artifacts)),
+		zap.Int64("duration_ms", result.DurationMS))
+	return result, nil
+}
+
+// checkArtifacts refuses artifact globs that could reach outside the
+// command's directory
+func (s *Service) checkArtifacts(globs []string) error {
+	if len(globs) == 0 {
+		return nil
+	}
+	if s.artifacts == nil {
+		return fmt.Errorf("%w: artifacts are not enabled", ErrInvalidExec)
+	}
+	for _, glob := range globs {
+		if glob == "" || filepath.IsAbs(glob) || !filepath.IsLocal(glob) {
+			return fmt.Errorf("%w: artifact %q must be relative to the working directory", ErrInvalidExec, glob)
+		}
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return fmt.Errorf("%w: artifact %q: %v", ErrInvalidExec, glob, err)
+		}
+	}
+	return nil
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the
+// rest, so a chatty command is not cut off by a broken pipe. It does not
+// embed bytes.Buffer, whose ReadFrom would let io.Copy skip the cap.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
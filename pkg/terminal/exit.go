@@ -0,0 +1,176 @@
+package terminal
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// exitReason classifies how a process that exited on its own ended. A
+// SIGKILL counts as an OOM kill when the kernel OOM-killed something in
+// the server's cgroup since the process started; shells running a command
+// report its death by SIGKILL as exit code 137.
+func (s *Service) exitReason(session *Session, err error) (ExitReason, string) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ExitNormal, ""
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ExitNormal, ""
+	}
+
+	killed := status.Signaled() && status.Signal() == syscall.SIGKILL ||
+		status.Exited() && status.ExitStatus() == 128+int(syscall.SIGKILL)
+	if killed {
+		if kills, ok := oomKillCount(); ok && kills > session.oomKills {
+			return ExitOOM, ""
+		}
+	}
+	if status.Signaled() {
+		return ExitSignal, unix.SignalName(status.Signal())
+	}
+	return ExitNormal, ""
+}
+
+// sendExit tells attached clients how the session ended, in an exit frame
+// and a session_ended frame with how long it ran and, for a session that
+// lingers after its command exited, until when. With the fair scheduler
+// the frames are queued behind the session's pending output.
+func (s *Service) sendExit(session *Session, lingers bool) {
+	reason, signal := session.ExitReason()
+	exit := protocol.Exit{Reason: string(reason), Signal: signal}
+	if code, ok := session.ExitCode(); ok {
+		exit.Code = &code
+	}
+	msg, err := protocol.NewMessage(protocol.TypeExit, exit)
+	if err != nil {
+		return
+	}
+	msg.SessionID = session.ID
+
+	session.stateMu.Lock()
+	endedAt := session.endedAt
+	session.stateMu.Unlock()
+	ended := protocol.SessionEnded{
+		Exit:     exit,
+		Duration: session.Duration().Seconds(),
+		EndedAt:  endedAt.UTC(),
+	}
+	if grace := s.endedGrace(); grace > 0 && lingers {
+		until := endedAt.Add(grace).UTC()
+		ended.ViewableUntil = &until
+	}
+	endedMsg, err := protocol.NewMessage(protocol.TypeSessionEnded, ended)
+	if err != nil {
+		return
+	}
+	endedMsg.SessionID = session.ID
+
+	send := func() {
+		s.broadcast(session, msg, nil)
+		s.broadcast(session, endedMsg, nil)
+	}
+	if s.fanout != nil {
+		s.fanout.then(session, send)
+		return
+	}
+	send()
+}
+
+// Duration is how long the session has run, or ran until it ended
+func (s *Session) Duration() time.Duration {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if !s.endedAt.IsZero() {
+		return s.endedAt.Sub(s.CreatedAt)
+	}
+	return time.Since(s.CreatedAt)
+}
+
+// endedGrace is how long a session whose command exited stays in the
+// list, attachable with its output, before it is removed; 0 leaves it to
+// the idle timeout
+func (s *Service) endedGrace() time.Duration {
+	if s.config.EndedGrace == "" {
+		return 0
+	}
+	grace, err := time.ParseDuration(s.config.EndedGrace)
+	if err != nil || grace < 0 {
+		return 0
+	}
+	return grace
+}
+
+// expireEnded removes a session whose command exited once the grace
+// period is over, unless it has been removed already
+func (s *Service) expireEnded(session *Session) {
+	grace := s.endedGrace()
+	if grace == 0 {
+		return
+	}
+	time.AfterFunc(grace, func() {
+		if current, exists := s.GetSession(session.ID); !exists || current != session {
+			return
+		}
+		session.logger.Info("Removing ended session after its grace period")
+		s.EndSession(session.ID, ExitNormal)
+	})
+}
+
+// oomKillCount reads how many processes the kernel has OOM-killed in the
+// server's cgroup, which sessions inherit. It reports false when the
+// count is unavailable, e.g. outside Linux or without a memory controller.
+func oomKillCount() (int64, bool) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		var file string
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			file = filepath.Join("/sys/fs/cgroup", parts[2], "memory.events")
+		case strings.Contains(","+parts[1]+",", ",memory,"):
+			file = filepath.Join("/sys/fs/cgroup/memory", parts[2], "memory.oom_control")
+		default:
+			continue
+		}
+		if count, ok := readCounter(file, "oom_kill"); ok {
+			return count, true
+		}
+	}
+	return 0, false
+}
+
+// readCounter reads a "name value" line from a cgroup file
+func readCounter(file, name string) (int64, bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == name {
+			count, err := strconv.ParseInt(fields[1], 10, 64)
+			return count, err == nil
+		}
+	}
+	return 0, false
+}
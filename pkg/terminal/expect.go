@@ -0,0 +1,125 @@
+package terminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// expectWindow bounds how much unmatched output a waiter keeps around.
+const expectWindow = 64 * 1024
+
+var ErrExpectTimeout = errors.New("pattern not found before timeout")
+
+type ExpectResult struct {
+	Match  string   `json:"match"`
+	Offset int64    `json:"offset"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// expectWaiter accumulates output from base onwards until its pattern
+// matches.
+type expectWaiter struct {
+	re   *regexp.Regexp
+	buf  []byte
+	base int64
+	done chan *ExpectResult
+}
+
+// Expect blocks until pattern matches session output, the context ends or
+// the session stops. Offsets count bytes since the session started. With
+// fromStart, the retained scrollback is searched as well as new output.
+func (s *Service) Expect(ctx context.Context, sessionID, pattern string, fromStart bool) (*ExpectResult, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	waiter := &expectWaiter{
+		re:   re,
+		done: make(chan *ExpectResult, 1),
+	}
+
+	session.waitMu.Lock()
+	waiter.base = session.outputOffset
+	if fromStart {
+		scrollback := session.outputBuf.Read()
+		waiter.buf = append(waiter.buf, scrollback...)
+		waiter.base -= int64(len(scrollback))
+	}
+	if result := waiter.match(); result != nil {
+		session.waitMu.Unlock()
+		return result, nil
+	}
+	session.waiters[waiter] = struct{}{}
+	session.waitMu.Unlock()
+
+	defer func() {
+		session.waitMu.Lock()
+		delete(session.waiters, waiter)
+		session.waitMu.Unlock()
+	}()
+
+	select {
+	case result := <-waiter.done:
+		return result, nil
+	case <-ctx.Done():
+		return nil, ErrExpectTimeout
+	case <-session.ctx.Done():
+		return nil, fmt.Errorf("session stopped while waiting for output")
+	}
+}
+
+// feedWaiters buffers the new chunk, advances the session output offset,
+// updates the session screen and hands the chunk to every pending Expect
+// call. The buffer is written
+// under the same lock so its contents always end at outputOffset.
+func (s *Service) feedWaiters(session *Session, output []byte) {
+	session.waitMu.Lock()
+	defer session.waitMu.Unlock()
+
+	session.outputBuf.Write(output)
+	session.spill.write(output)
+	session.outputOffset += int64(len(output))
+	if session.screen != nil {
+		session.screen.Write(output)
+	}
+	for waiter := range session.waiters {
+		waiter.buf = append(waiter.buf, output...)
+		if result := waiter.match(); result != nil {
+			waiter.done <- result
+			delete(session.waiters, waiter)
+			continue
+		}
+		if excess := len(waiter.buf) - expectWindow; excess > 0 {
+			waiter.buf = append(waiter.buf[:0], waiter.buf[excess:]...)
+			waiter.base += int64(excess)
+		}
+	}
+}
+
+func (w *expectWaiter) match() *ExpectResult {
+	loc := w.re.FindSubmatchIndex(w.buf)
+	if loc == nil {
+		return nil
+	}
+
+	result := &ExpectResult{
+		Match:  string(w.buf[loc[0]:loc[1]]),
+		Offset: w.base + int64(loc[0]),
+	}
+	for i := 2; i < len(loc); i += 2 {
+		if loc[i] < 0 {
+			result.Groups = append(result.Groups, "")
+			continue
+		}
+		result.Groups = append(result.Groups, string(w.buf[loc[i]:loc[i+1]]))
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failure reasons counted in webtunnel_session_failures_total
+const (
+	FailurePTYStart = "pty_start_failed"
+	FailureWSWrite  = "ws_write_failed"
+	FailureQuota    = "quota_rejected"
+)
+
+var failureReasons = []string{FailurePTYStart, FailureWSWrite, FailureQuota}
+
+// exemplar points a counter at the latest session it counted, so a spike
+// on a dashboard leads to that session's logs and, when its creator sent a
+// traceparent, to the trace. Quota rejections have no session.
+type exemplar struct {
+	sessionID string
+	traceID   string
+	at        time.Time
+}
+
+// failureCounter counts session failures by reason
+type failureCounter struct {
+	counts    map[string]int64
+	exemplars map[string]exemplar
+	mu        sync.Mutex
+}
+
+func newFailureCounter() *failureCounter {
+	return &failureCounter{
+		counts:    make(map[string]int64),
+		exemplars: make(map[string]exemplar),
+	}
+}
+
+func (f *failureCounter) add(reason, sessionID, traceID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[reason]++
+	f.exemplars[reason] = exemplar{sessionID: sessionID, traceID: traceID, at: time.Now()}
+}
+
+// Failures returns the failure counts by reason
+func (s *Service) Failures() map[string]int64 {
+	s.failures.mu.Lock()
+	defer s.failures.mu.Unlock()
+	result := make(map[string]int64, len(failureReasons))
+	for _, reason := range failureReasons {
+		result[reason] = s.failures.counts[reason]
+	}
+	return result
+}
+
+// failureLines renders the failure counters. Exemplars are only valid in
+// the OpenMetrics format, and their labels are kept well under its 128
+// character limit.
+func (s *Service) failureLines(exemplars bool) []string {
+	s.failures.mu.Lock()
+	defer s.failures.mu.Unlock()
+
+	// OpenMetrics names the counter family without the _total suffix
+	family := "webtunnel_session_failures"
+	if !exemplars {
+		family += "_total"
+	}
+	lines := []string{
+		"# HELP " + family + " Sessions that failed to start, were refused by a quota, or lost a client to a failed write.",
+		"# TYPE " + family + " counter",
+	}
+	for _, reason := range failureReasons {
+		line := fmt.Sprintf("webtunnel_session_failures_total{reason=%q} %d", reason, s.failures.counts[reason])
+		if e, ok := s.failures.exemplars[reason]; ok && exemplars {
+			var labels []string
+			if e.sessionID != "" {
+				labels = append(labels, fmt.Sprintf("session_id=%q", e.sessionID))
+			}
+			if e.traceID != "" {
+				labels = append(labels, fmt.Sprintf("trace_id=%q", e.traceID))
+			}
+			line += fmt.Sprintf(" # {%s} 1 %.3f", strings.Join(labels, ","), float64(e.at.UnixMilli())/1000)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,202 @@
+package terminal
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// fanout delivers PTY output to WebSockets from a fixed worker pool using
+// deficit round robin across users. Each user gets up to quantum bytes per
+// turn, so a few chatty sessions cannot monopolise the writers. Queues are
+// bounded per user; when one fills, the PTY readers of that user block,
+// which pushes back on the chatty processes instead of everyone else.
+type fanout struct {
+	quantum  int
+	maxQueue int
+	deliver  func(session *Session, output []byte)
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	users   map[string]*userQueue
+	ready   []*userQueue // users with pending output, in service order
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+type userQueue struct {
+	userID  string
+	jobs    []fanoutJob
+	queued  int
+	deficit int
+	inReady bool // waiting in ready or checked out by a worker
+	stats   FanoutUserStats
+}
+
+type fanoutJob struct {
+	session  *Session
+	output   []byte
+	then     func() // run in place of delivering output
+	queuedAt time.Time
+}
+
+// FanoutUserStats describes how much output bandwidth a user has received
+type FanoutUserStats struct {
+	UserID      string  `json:"user_id"`
+	BytesSent   uint64  `json:"bytes_sent"`
+	ChunksSent  uint64  `json:"chunks_sent"`
+	QueuedBytes int     `json:"queued_bytes"`
+	Share       float64 `json:"share"`
+	MaxWaitMs   int64   `json:"max_wait_ms"`
+}
+
+func newFanout(workers, quantum, maxQueue int, deliver func(*Session, []byte)) *fanout {
+	f := &fanout{
+		quantum:  quantum,
+		maxQueue: maxQueue,
+		deliver:  deliver,
+		users:    make(map[string]*userQueue),
+	}
+	f.cond = sync.NewCond(&f.mu)
+
+	for i := 0; i < workers; i++ {
+		f.wg.Add(1)
+		go f.work()
+	}
+	return f
+}
+
+// enqueue copies output into the owner's queue, blocking while that queue
+// is over its limit.
+func (f *fanout) enqueue(session *Session, output []byte) {
+	data := make([]byte, len(output))
+	copy(data, output)
+	f.push(fanoutJob{session: session, output: data, queuedAt: time.Now()})
+}
+
+// then runs fn once the session's output queued so far has been
+// delivered, so a frame sent from fn follows it
+func (f *fanout) then(session *Session, fn func()) {
+	f.push(fanoutJob{session: session, then: fn, queuedAt: time.Now()})
+}
+
+func (f *fanout) push(job fanoutJob) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	userID := job.session.UserID
+	q, exists := f.users[userID]
+	if !exists {
+		q = &userQueue{userID: userID}
+		f.users[userID] = q
+	}
+
+	for q.queued >= f.maxQueue && !f.stopped {
+		f.cond.Wait()
+	}
+	if f.stopped {
+		return
+	}
+
+	q.jobs = append(q.jobs, job)
+	q.queued += len(job.output)
+	if !q.inReady {
+		q.inReady = true
+		f.ready = append(f.ready, q)
+	}
+	f.cond.Broadcast()
+}
+
+func (f *fanout) work() {
+	defer f.wg.Done()
+
+	for {
+		f.mu.Lock()
+		for len(f.ready) == 0 && !f.stopped {
+			f.cond.Wait()
+		}
+		if f.stopped {
+			f.mu.Unlock()
+			return
+		}
+
+		// Check out the next user; nobody else serves them until we put
+		// them back, which keeps each session's output in order.
+		q := f.ready[0]
+		f.ready = f.ready[1:]
+		q.deficit += f.quantum
+
+		var batch []fanoutJob
+		for len(q.jobs) > 0 && len(q.jobs[0].output) <= q.deficit {
+			job := q.jobs[0]
+			q.jobs = q.jobs[1:]
+			q.deficit -= len(job.output)
+			batch = append(batch, job)
+		}
+		f.mu.Unlock()
+
+		sent, chunks := 0, 0
+		var maxWait time.Duration
+		for _, job := range batch {
+			if wait := time.Since(job.queuedAt); wait > maxWait {
+				maxWait = wait
+			}
+			if job.then != nil {
+				job.then()
+				continue
+			}
+			f.deliver(job.session, job.output)
+			sent += len(job.output)
+			chunks++
+		}
+
+		f.mu.Lock()
+		q.queued -= sent
+		q.stats.BytesSent += uint64(sent)
+		q.stats.ChunksSent += uint64(chunks)
+		if ms := maxWait.Milliseconds(); ms > q.stats.MaxWaitMs {
+			q.stats.MaxWaitMs = ms
+		}
+		if len(q.jobs) > 0 {
+			f.ready = append(f.ready, q)
+		} else {
+			q.inReady = false
+			q.deficit = 0
+		}
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	}
+}
+
+func (f *fanout) stats() []FanoutUserStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var total uint64
+	for _, q := range f.users {
+		total += q.stats.BytesSent
+	}
+
+	result := make([]FanoutUserStats, 0, len(f.users))
+	for _, q := range f.users {
+		stats := q.stats
+		stats.UserID = q.userID
+		stats.QueuedBytes = q.queued
+		if total > 0 {
+			stats.Share = float64(stats.BytesSent) / float64(total)
+		}
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].BytesSent > result[j].BytesSent
+	})
+	return result
+}
+
+func (f *fanout) stop() {
+	f.mu.Lock()
+	f.stopped = true
+	f.cond.Broadcast()
+	f.mu.Unlock()
+	f.wg.Wait()
+}
@@ -0,0 +1,233 @@
+package terminal
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// hostUserComment marks the accounts ephemeral_users creates, so ones a
+// crash left behind can be told apart from everyone else's
+const hostUserComment = "webtunnel session"
+
+// Killing an account's processes takes up to maxKillPasses passes over
+// /proc, killPassInterval apart
+const (
+	maxKillPasses    = 50
+	killPassInterval = 20 * time.Millisecond
+)
+
+// hostUser is the throwaway UNIX account a session runs as under
+// ephemeral_users. Its home is the session directory, which it owns; no
+// other session's processes or files are its own. A nil hostUser runs as
+// the server's user.
+type hostUser struct {
+	name     string
+	uid, gid uint32
+	logger   *zap.Logger
+}
+
+// hostUserPrefix starts the name of every ephemeral account
+func (s *Service) hostUserPrefix() string {
+	if s.config.EphemeralUserPrefix != "" {
+		return s.config.EphemeralUserPrefix
+	}
+	return "wt-"
+}
+
+// newHostUser creates the session's account with useradd when
+// ephemeral_users is on, and hands it the session directory
+func (s *Service) newHostUser(session *Session) (*hostUser, error) {
+	if !s.config.EphemeralUsers {
+		return nil, nil
+	}
+
+	// Session IDs end in 12 random hex digits; names stay well within the
+	// 32 characters useradd allows
+	id := session.ID
+	if i := strings.LastIndexByte(id, '_'); i >= 0 {
+		id = id[i+1:]
+	}
+	name := s.hostUserPrefix() + id
+	out, err := exec.Command("useradd",
+		"--no-create-home",
+		"--home-dir", session.WorkingDir,
+		"--user-group",
+		"--no-log-init",
+		"--comment", hostUserComment+" "+session.ID,
+		name).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+
+	account := &hostUser{name: name, logger: session.logger}
+	u, err := user.Lookup(name)
+	if err == nil {
+		err = account.parseIDs(u)
+	}
+	if err == nil {
+		err = account.own(session.WorkingDir)
+	}
+	if err != nil {
+		account.remove()
+		return nil, err
+	}
+	session.logger.Info("Created session user",
+		zap.String("host_user", name),
+		zap.Uint32("uid", account.uid))
+	return account, nil
+}
+
+func (u *hostUser) parseIDs(account *user.User) error {
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid uid for %s: %w", u.name, err)
+	}
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid gid for %s: %w", u.name, err)
+	}
+	u.uid, u.gid = uint32(uid), uint32(gid)
+	return nil
+}
+
+// own gives the user dir and everything already in it, such as an
+// unpacked snapshot, and closes it to everyone else
+func (u *hostUser) own(dir string) error {
+	err := filepath.WalkDir(dir, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, int(u.uid), int(u.gid))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to give %s its session directory: %w", u.name, err)
+	}
+	return os.Chmod(dir, 0700)
+}
+
+// apply has cmd run as the user, with the user's identity in its
+// environment
+func (u *hostUser) apply(cmd *exec.Cmd) {
+	if u == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: u.uid, Gid: u.gid, NoSetGroups: true}
+	// Later entries win over the server's own
+	cmd.Env = append(cmd.Env, "HOME="+cmd.Dir, "USER="+u.name, "LOGNAME="+u.name)
+}
+
+// remove kills whatever the user still has running, which may have left
+// the session's PTY, and deletes the account; its home goes with the
+// session directory
+func (u *hostUser) remove() {
+	if u == nil {
+		return
+	}
+	if u.uid != 0 {
+		u.killProcesses()
+	}
+	if out, err := exec.Command("userdel", u.name).CombinedOutput(); err != nil {
+		u.logger.Warn("Failed to delete session user",
+			zap.String("host_user", u.name),
+			zap.String("output", strings.TrimSpace(string(out))),
+			zap.Error(err))
+		return
+	}
+	u.logger.Info("Deleted session user", zap.String("host_user", u.name))
+}
+
+// killProcesses kills everything the account runs. A process can fork
+// while /proc is read, so passes repeat until one finds nothing, up to
+// maxKillPasses.
+func (u *hostUser) killProcesses() {
+	for pass := 0; ; pass++ {
+		pids := userProcesses(u.uid)
+		if len(pids) == 0 {
+			return
+		}
+		if pass == maxKillPasses {
+			u.logger.Warn("Session user still has processes",
+				zap.String("host_user", u.name),
+				zap.Ints("pids", pids))
+			return
+		}
+		for _, pid := range pids {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+		time.Sleep(killPassInterval)
+	}
+}
+
+// userProcesses lists the live processes whose real UID is uid
+func userProcesses(uid uint32) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	want := strconv.FormatUint(uint64(uid), 10)
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/status")
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			// Uid: real effective saved filesystem
+			if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "Uid:" {
+				if fields[1] == want {
+					pids = append(pids, pid)
+				}
+				break
+			}
+		}
+	}
+	return pids
+}
+
+// sweepHostUsers deletes the accounts a previous run created and never
+// got to delete, because it crashed or was killed. Sessions relaunched
+// after a restart get new ones.
+func (s *Service) sweepHostUsers() {
+	file, err := os.Open("/etc/passwd")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	prefix := s.hostUserPrefix()
+	var stale []*hostUser
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name:password:uid:gid:comment:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || !strings.HasPrefix(fields[0], prefix) || !strings.HasPrefix(fields[4], hostUserComment+" ") {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		stale = append(stale, &hostUser{name: fields[0], uid: uint32(uid), logger: s.logger})
+	}
+	for _, u := range stale {
+		u.remove()
+	}
+}
@@ -0,0 +1,90 @@
+package terminal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+// Idle policies decide what keeps a session from being reaped as idle
+const (
+	// IdleActivity counts a session idle once it has had neither input nor
+	// output, so a build printing progress keeps running untouched
+	IdleActivity = "activity"
+	// IdleOutput counts it idle once the process stops writing, whatever
+	// is typed
+	IdleOutput = "output"
+	// IdleInput counts it idle once nobody types, whatever it prints
+	IdleInput = "input"
+	// IdleDetached counts it idle once nobody is attached, however busy
+	// the process is
+	IdleDetached = "detached"
+)
+
+// idleSince returns when the session last did what the idle policy counts
+// as activity, or its start if it never has. Under IdleDetached it is busy
+// for as long as a client is attached.
+func (s *Service) idleSince(session *Session, now time.Time) time.Time {
+	session.activity.mu.Lock()
+	lastOutput, lastInput, lastLeft := session.activity.last, session.activity.lastInput, session.activity.lastLeft
+	session.activity.mu.Unlock()
+
+	since := session.LastActive
+	switch s.idlePolicy() {
+	case IdleOutput:
+		since = lastOutput
+	case IdleInput:
+		since = lastInput
+	case IdleDetached:
+		session.connMu.RLock()
+		attached := len(session.connections) > 0
+		session.connMu.RUnlock()
+		if attached {
+			return now
+		}
+		since = lastLeft
+	}
+	if since.IsZero() {
+		return session.CreatedAt
+	}
+	return since
+}
+
+// warnIdle tells the attached clients the session is about to be
+// terminated, along with the EventIdleWarning subscribers
+func (s *Service) warnIdle(session *Session, idle, remaining time.Duration) {
+	s.emit(session, EventIdleWarning, map[string]string{
+		"idle":          idle.Round(time.Second).String(),
+		"terminates_in": remaining.Round(time.Second).String(),
+		"policy":        s.idlePolicy(),
+	})
+	s.broadcast(session, protocol.Message{
+		Type: protocol.TypeNotice,
+		Data: fmt.Sprintf("This session has been idle (%s) for %s and will be terminated in %s",
+			idleReason(s.idlePolicy()), idle.Round(time.Second), remaining.Round(time.Second)),
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	}, nil)
+}
+
+// idlePolicy is the configured idle policy, IdleActivity by default
+func (s *Service) idlePolicy() string {
+	switch s.config.IdlePolicy {
+	case IdleOutput, IdleInput, IdleDetached:
+		return s.config.IdlePolicy
+	}
+	return IdleActivity
+}
+
+func idleReason(policy string) string {
+	switch policy {
+	case IdleOutput:
+		return "no output"
+	case IdleInput:
+		return "no input"
+	case IdleDetached:
+		return "nobody attached"
+	}
+	return "no input or output"
+}
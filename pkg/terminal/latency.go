@@ -0,0 +1,287 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// latencySamples is how many recent samples each connection keeps
+const latencySamples = 32
+
+// maxOutstandingProbes bounds probes a client has not answered
+const maxOutstandingProbes = 8
+
+// latency tracks one connection's network round trip, measured with probe
+// frames the client echoes back, and PTY echo time, measured from an input
+// message to the next output the shell produces.
+type latency struct {
+	mu      sync.Mutex
+	seq     uint64
+	probes  map[uint64]time.Time
+	inputAt time.Time
+	rtt     samples
+	echo    samples
+}
+
+type samples struct {
+	values [latencySamples]time.Duration
+	n      int
+	next   int
+}
+
+func (s *samples) add(d time.Duration) {
+	s.values[s.next] = d
+	s.next = (s.next + 1) % latencySamples
+	if s.n < latencySamples {
+		s.n++
+	}
+}
+
+func (s *samples) mean() time.Duration {
+	if s.n == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range s.values[:s.n] {
+		total += d
+	}
+	return total / time.Duration(s.n)
+}
+
+func (s *samples) percentile(p float64) time.Duration {
+	if s.n == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, s.n)
+	copy(sorted, s.values[:s.n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(s.n-1))]
+}
+
+func newLatency() *latency {
+	return &latency{probes: make(map[uint64]time.Time)}
+}
+
+// probe allocates the next probe ID
+func (l *latency) probe(now time.Time) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	l.probes[l.seq] = now
+	// Clients that never answer should not grow the map
+	delete(l.probes, l.seq-maxOutstandingProbes)
+	return strconv.FormatUint(l.seq, 10)
+}
+
+// ack records the round trip for an answered probe
+func (l *latency) ack(id string, now time.Time) bool {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sent, exists := l.probes[seq]
+	if !exists {
+		return false
+	}
+	delete(l.probes, seq)
+	l.rtt.add(now.Sub(sent))
+	return true
+}
+
+// input notes when the client typed, unless earlier input is still waiting
+// for its echo
+func (l *latency) input(now time.Time) {
+	l.mu.Lock()
+	if l.inputAt.IsZero() {
+		l.inputAt = now
+	}
+	l.mu.Unlock()
+}
+
+// output closes the pending input, if any, as echoed
+func (l *latency) output(now time.Time) {
+	l.mu.Lock()
+	if !l.inputAt.IsZero() {
+		l.echo.add(now.Sub(l.inputAt))
+		l.inputAt = time.Time{}
+	}
+	l.mu.Unlock()
+}
+
+// ConnectionQuality summarizes a connection's recent latency. End-to-end
+// input latency is the network round trip plus the PTY echo time.
+type ConnectionQuality struct {
+	SessionID string  `json:"session_id,omitempty"`
+	UserID    string  `json:"user_id,omitempty"`
+	RTTMs     float64 `json:"rtt_ms"`
+	RTTP95Ms  float64 `json:"rtt_p95_ms"`
+	EchoMs    float64 `json:"echo_ms"`
+	EchoP95Ms float64 `json:"echo_p95_ms"`
+	InputMs   float64 `json:"input_ms"`
+	Samples   int     `json:"samples"`
+	Rating    string  `json:"rating"` // good, fair or poor
+}
+
+func (l *latency) quality() ConnectionQuality {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	q := ConnectionQuality{
+		RTTMs:     ms(l.rtt.mean()),
+		RTTP95Ms:  ms(l.rtt.percentile(0.95)),
+		EchoMs:    ms(l.echo.mean()),
+		EchoP95Ms: ms(l.echo.percentile(0.95)),
+		Samples:   l.rtt.n,
+	}
+	q.InputMs = q.RTTMs + q.EchoMs
+
+	switch {
+	case q.InputMs < 100:
+		q.Rating = "good"
+	case q.InputMs < 250:
+		q.Rating = "fair"
+	default:
+		q.Rating = "poor"
+	}
+	return q
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// probeInterval is how often attached clients are probed, or zero when
+// probing is disabled
+func (s *Service) probeInterval() time.Duration {
+	if s.config.LatencyProbeInterval == "" {
+		return 0
+	}
+	interval, err := time.ParseDuration(s.config.LatencyProbeInterval)
+	if err != nil || interval <= 0 {
+		return 0
+	}
+	return interval
+}
+
+// probeLoop sends probe frames to one client until it disconnects
+func (s *Service) probeLoop(session *Session, cl *client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cl.done:
+			return
+		case now := <-ticker.C:
+			msg := protocol.Message{
+				Type:      protocol.TypeProbe,
+				Data:      cl.latency.probe(now),
+				Timestamp: now,
+				SessionID: session.ID,
+			}
+			if err := cl.writeJSON(msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleProbe records an echoed probe and tells the client how its
+// connection is doing
+func (s *Service) handleProbe(session *Session, cl *client, id string) {
+	if !cl.latency.ack(id, time.Now()) {
+		return
+	}
+
+	data, err := json.Marshal(cl.latency.quality())
+	if err != nil {
+		return
+	}
+	if err := cl.writeJSON(protocol.Message{
+		Type:      protocol.TypeQuality,
+		Data:      string(data),
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	}); err != nil {
+		session.logger.Debug("Failed to send connection quality", zap.Error(err))
+	}
+}
+
+// observeEcho attributes PTY output to any input still waiting for echo
+func (s *Service) observeEcho(session *Session, now time.Time) {
+	session.connMu.RLock()
+	for _, cl := range session.connections {
+		cl.latency.output(now)
+	}
+	session.connMu.RUnlock()
+}
+
+// ConnectionQualities reports latency for every attached connection
+func (s *Service) ConnectionQualities() []ConnectionQuality {
+	var result []ConnectionQuality
+	for _, session := range s.sessions.snapshot() {
+		session.connMu.RLock()
+		for _, cl := range session.connections {
+			q := cl.latency.quality()
+			q.SessionID = session.ID
+			q.UserID = cl.userID
+			result = append(result, q)
+		}
+		session.connMu.RUnlock()
+	}
+	return result
+}
+
+// WriteMetrics renders per-connection latency, the pre-warmed pool, the
+// session failure counters, protocol negotiation and each session's PTY
+// traffic in the Prometheus text format
+func (s *Service) WriteMetrics(w io.Writer) error {
+	return s.writeMetrics(w, false)
+}
+
+// WriteOpenMetrics renders the same metrics in the OpenMetrics text
+// format, with an exemplar on each failure counter
+func (s *Service) WriteOpenMetrics(w io.Writer) error {
+	return s.writeMetrics(w, true)
+}
+
+func (s *Service) writeMetrics(w io.Writer, exemplars bool) error {
+	qualities := s.ConnectionQualities()
+
+	lines := []string{
+		"# HELP webtunnel_connection_rtt_milliseconds Mean WebSocket round trip per attached connection.",
+		"# TYPE webtunnel_connection_rtt_milliseconds gauge",
+	}
+	for _, q := range qualities {
+		lines = append(lines, fmt.Sprintf("webtunnel_connection_rtt_milliseconds{session=%q,user=%q} %g", q.SessionID, q.UserID, q.RTTMs))
+	}
+	lines = append(lines,
+		"# HELP webtunnel_connection_echo_milliseconds Mean time from input to PTY echo per attached connection.",
+		"# TYPE webtunnel_connection_echo_milliseconds gauge")
+	for _, q := range qualities {
+		lines = append(lines, fmt.Sprintf("webtunnel_connection_echo_milliseconds{session=%q,user=%q} %g", q.SessionID, q.UserID, q.EchoMs))
+	}
+	lines = append(lines, s.prewarm.metricLines()...)
+	lines = append(lines, s.failureLines(exemplars)...)
+	lines = append(lines, s.negotiationLines(exemplars)...)
+	lines = append(lines, s.trafficLines(exemplars)...)
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidLimits = errors.New("invalid resource limits")
+
+// Limits caps the resources of a session's process and everything it
+// starts. Zero fields are left at the server's own limits.
+type Limits struct {
+	MemoryMB   int `json:"memory_mb,omitempty"`   // address space
+	OpenFiles  int `json:"open_files,omitempty"`  // file descriptors
+	CPUSeconds int `json:"cpu_seconds,omitempty"` // CPU time, after which the process is killed
+}
+
+// IsZero reports whether no limit is set
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Validate rejects negative limits
+func (l Limits) Validate() error {
+	if l.MemoryMB < 0 || l.OpenFiles < 0 || l.CPUSeconds < 0 {
+		return fmt.Errorf("%w: limits cannot be negative", ErrInvalidLimits)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package terminal
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// applyLimits sets the limits on a process just after it started, so an
+// interactive shell has them before it reads any input; a command run
+// with -c may get a moment's head start. Its children inherit them.
+func applyLimits(pid int, limits Limits) error {
+	set := func(resource int, value uint64) error {
+		if value == 0 {
+			return nil
+		}
+		return unix.Prlimit(pid, resource, &unix.Rlimit{Cur: value, Max: value}, nil)
+	}
+	if err := set(unix.RLIMIT_AS, uint64(limits.MemoryMB)<<20); err != nil {
+		return fmt.Errorf("failed to limit memory: %w", err)
+	}
+	if err := set(unix.RLIMIT_NOFILE, uint64(limits.OpenFiles)); err != nil {
+		return fmt.Errorf("failed to limit open files: %w", err)
+	}
+	if err := set(unix.RLIMIT_CPU, uint64(limits.CPUSeconds)); err != nil {
+		return fmt.Errorf("failed to limit CPU time: %w", err)
+	}
+	return nil
+}
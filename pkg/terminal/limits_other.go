@@ -0,0 +1,13 @@
+//go:build !linux
+
+package terminal
+
+import "errors"
+
+// applyLimits is only supported on Linux
+func applyLimits(pid int, limits Limits) error {
+	if limits.IsZero() {
+		return nil
+	}
+	return errors.New("resource limits are not supported on this platform")
+}
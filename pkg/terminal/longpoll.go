@@ -0,0 +1,297 @@
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/pkg/meter"
+	"go.uber.org/zap"
+)
+
+// Long polling carries the same JSON messages as the WebSocket for networks
+// that block upgrades. The client fetches output with a cursor, which
+// acknowledges everything up to it and waits for more, and posts input in
+// batches. Unacknowledged output is resent, so a lost response costs a
+// retry rather than data.
+
+const (
+	// pollIdleTimeout detaches a client that stops polling
+	pollIdleTimeout = 60 * time.Second
+	// maxPollWait caps how long one poll is held open
+	maxPollWait = 30 * time.Second
+	// maxPollBacklog bounds unacknowledged output per client. A client that
+	// falls further behind is dropped like a stalled WebSocket.
+	maxPollBacklog = 4 << 20
+	// maxPollBatch caps the messages returned by one poll
+	maxPollBatch = 256
+)
+
+var (
+	ErrPollClientNotFound = errors.New("long-poll client not found")
+	ErrPollBacklog        = errors.New("long-poll client fell too far behind")
+	ErrMessageTooLarge    = errors.New("message too large")
+)
+
+// PollResult is one batch of messages for a long-poll client. Cursor is
+// passed to the next poll; Closed means no more messages will follow.
+type PollResult struct {
+	Messages []json.RawMessage `json:"messages"`
+	Cursor   uint64            `json:"cursor"`
+	Closed   bool              `json:"closed,omitempty"`
+}
+
+type pollFrame struct {
+	seq  uint64
+	data []byte
+}
+
+// pollTransport queues outbound frames until polled and hands posted
+// messages to the session's reader
+type pollTransport struct {
+	id        string
+	sessionID string
+	userID    string
+
+	mu       sync.Mutex
+	frames   []pollFrame
+	size     int
+	seq      uint64        // last frame queued
+	wake     chan struct{} // closed and replaced when frames arrive
+	lastPoll time.Time
+	closed   bool
+
+	inbound   chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPollTransport(id, sessionID, userID string) *pollTransport {
+	return &pollTransport{
+		id:        id,
+		sessionID: sessionID,
+		userID:    userID,
+		wake:      make(chan struct{}),
+		lastPoll:  time.Now(),
+		inbound:   make(chan []byte, 64),
+		done:      make(chan struct{}),
+	}
+}
+
+func (t *pollTransport) WriteMessage(_ int, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return io.ErrClosedPipe
+	}
+	if t.size+len(data) > maxPollBacklog {
+		return ErrPollBacklog
+	}
+
+	// Broadcast frames come from a pooled buffer, so keep a copy
+	frame := bytes.TrimSpace(append([]byte(nil), data...))
+	t.seq++
+	t.frames = append(t.frames, pollFrame{seq: t.seq, data: frame})
+	t.size += len(frame)
+	close(t.wake)
+	t.wake = make(chan struct{})
+	return nil
+}
+
+// NextReader returns the next posted message. It fails once the client
+// has not polled for pollIdleTimeout, which detaches it.
+func (t *pollTransport) NextReader() (int, io.Reader, error) {
+	ticker := time.NewTicker(pollIdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-t.inbound:
+			return websocket.TextMessage, bytes.NewReader(data), nil
+		case <-t.done:
+			return 0, nil, io.EOF
+		case now := <-ticker.C:
+			t.mu.Lock()
+			idle := now.Sub(t.lastPoll)
+			t.mu.Unlock()
+			if idle > pollIdleTimeout {
+				return 0, nil, fmt.Errorf("long-poll client idle for %s", idle.Round(time.Second))
+			}
+		}
+	}
+}
+
+func (t *pollTransport) Close() error {
+	t.closeOnce.Do(func() {
+		t.mu.Lock()
+		t.closed = true
+		close(t.wake)
+		t.wake = make(chan struct{})
+		t.mu.Unlock()
+		close(t.done)
+	})
+	return nil
+}
+
+// poll acknowledges frames up to cursor and returns the rest, waiting up
+// to wait for new ones
+func (t *pollTransport) poll(ctx context.Context, cursor uint64, wait time.Duration) *PollResult {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		t.mu.Lock()
+		t.lastPoll = time.Now()
+		t.ack(cursor)
+		if len(t.frames) > 0 || t.closed {
+			result := t.batch(cursor)
+			t.mu.Unlock()
+			return result
+		}
+		wake := t.wake
+		t.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			return &PollResult{Messages: []json.RawMessage{}, Cursor: cursor}
+		case <-ctx.Done():
+			return &PollResult{Messages: []json.RawMessage{}, Cursor: cursor}
+		}
+	}
+}
+
+// ack drops frames the client has seen. Must be called with t.mu held.
+func (t *pollTransport) ack(cursor uint64) {
+	n := 0
+	for n < len(t.frames) && t.frames[n].seq <= cursor {
+		t.size -= len(t.frames[n].data)
+		n++
+	}
+	t.frames = t.frames[n:]
+}
+
+// batch must be called with t.mu held
+func (t *pollTransport) batch(cursor uint64) *PollResult {
+	result := &PollResult{Messages: []json.RawMessage{}, Cursor: cursor}
+	for i, frame := range t.frames {
+		if i == maxPollBatch {
+			return result
+		}
+		result.Messages = append(result.Messages, frame.data)
+		result.Cursor = frame.seq
+	}
+	result.Closed = t.closed
+	return result
+}
+
+// deliver queues posted messages for the session's reader
+func (t *pollTransport) deliver(ctx context.Context, messages []json.RawMessage) error {
+	for _, msg := range messages {
+		if len(msg) > maxClientMessage {
+			return ErrMessageTooLarge
+		}
+		select {
+		case t.inbound <- msg:
+		case <-t.done:
+			return ErrPollClientNotFound
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// AttachLongPoll attaches a long-poll client for the given user and
+// returns its ID, which the client presents on every poll
+func (s *Service) AttachLongPoll(sessionID, userID string) (string, error) {
+	return s.AttachLongPollSince(sessionID, userID, "")
+}
+
+// AttachLongPollSince attaches a long-poll client that replays output from
+// the since anchor, a bookmark or byte offset
+func (s *Service) AttachLongPollSince(sessionID, userID, since string) (string, error) {
+	return s.AttachLongPollWith(sessionID, userID, AttachOptions{Since: since})
+}
+
+// AttachLongPollWith attaches a long-poll client with the replay anchor and
+// output framing it asked for
+func (s *Service) AttachLongPollWith(sessionID, userID string, opts AttachOptions) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate client ID: %w", err)
+	}
+	t := newPollTransport(hex.EncodeToString(raw), sessionID, userID)
+
+	s.pollMu.Lock()
+	s.pollers[t.id] = t
+	s.pollMu.Unlock()
+
+	if _, err := s.attach(sessionID, userID, opts, t, meter.KindLongPoll); err != nil {
+		s.pollMu.Lock()
+		delete(s.pollers, t.id)
+		s.pollMu.Unlock()
+		return "", err
+	}
+
+	go func() {
+		<-t.done
+		s.pollMu.Lock()
+		delete(s.pollers, t.id)
+		s.pollMu.Unlock()
+		s.logger.Debug("Long-poll client detached", zap.String("session_id", sessionID))
+	}()
+
+	return t.id, nil
+}
+
+// Poll returns messages after cursor, holding the request open for up to
+// wait when there are none
+func (s *Service) Poll(ctx context.Context, sessionID, userID, clientID string, cursor uint64, wait time.Duration) (*PollResult, error) {
+	t, err := s.pollClient(sessionID, userID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if wait <= 0 || wait > maxPollWait {
+		wait = maxPollWait
+	}
+	return t.poll(ctx, cursor, wait), nil
+}
+
+// PostMessages hands client messages (input, resize, probe echoes, ...) to
+// the session as if they had arrived on a WebSocket
+func (s *Service) PostMessages(ctx context.Context, sessionID, userID, clientID string, messages []json.RawMessage) error {
+	t, err := s.pollClient(sessionID, userID, clientID)
+	if err != nil {
+		return err
+	}
+	return t.deliver(ctx, messages)
+}
+
+// DetachLongPoll disconnects a long-poll client
+func (s *Service) DetachLongPoll(sessionID, userID, clientID string) error {
+	t, err := s.pollClient(sessionID, userID, clientID)
+	if err != nil {
+		return err
+	}
+	return t.Close()
+}
+
+func (s *Service) pollClient(sessionID, userID, clientID string) (*pollTransport, error) {
+	s.pollMu.Lock()
+	t, ok := s.pollers[clientID]
+	s.pollMu.Unlock()
+	if !ok || t.sessionID != sessionID || t.userID != userID {
+		return nil, ErrPollClientNotFound
+	}
+	return t, nil
+}
@@ -0,0 +1,41 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxSessionName = 64
+
+var ErrInvalidName = errors.New("invalid session name")
+
+// normalizeName trims a session name and checks it is short and printable.
+// An empty name is allowed and leaves the session unnamed.
+func normalizeName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxSessionName {
+		return "", fmt.Errorf("%w: at most %d characters", ErrInvalidName, maxSessionName)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return "", fmt.Errorf("%w: only printable characters are allowed", ErrInvalidName)
+		}
+	}
+	return name, nil
+}
+
+// Name returns the name given to the session, if any
+func (s *Session) Name() string {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.name
+}
+
+// Rename gives a session a new name; an empty name clears it. Names are
+// labels for people and need not be unique.
+func (s *Service) Rename(sessionID, name string) (*Session, error) {
+	return s.Update(sessionID, SessionUpdate{Name: &name})
+}
@@ -0,0 +1,239 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+var ErrProtocolTooOld = errors.New("client protocol version is below the server's minimum")
+
+// Why a client got an older protocol version than the newest it offered
+const (
+	// DowngradeNoOffer is a client that offered no version at all
+	DowngradeNoOffer = "no_offer"
+	// DowngradeUnsupported is a client whose newest version this server
+	// does not speak yet
+	DowngradeUnsupported = "unsupported"
+)
+
+// recentDowngrades is how many downgrades the protocol report keeps
+const recentDowngrades = 100
+
+// Negotiation is the protocol version picked for one connection
+type Negotiation struct {
+	Version int
+	Offered []int  // versions the client offered, newest first
+	Reason  string // why it was downgraded; empty when it was not
+}
+
+// Subprotocol is the WebSocket subprotocol to answer with, or empty for
+// a client that did not negotiate
+func (n Negotiation) Subprotocol() string {
+	if n.Version == protocol.Version0 {
+		return ""
+	}
+	return protocol.Subprotocol(n.Version)
+}
+
+// NegotiationInfo is who is negotiating, for the protocol report
+type NegotiationInfo struct {
+	UserID    string
+	SessionID string
+	Transport string // "websocket" or "long-poll"
+	UserAgent string
+}
+
+// Downgrade is one client that did not get the newest version it offered,
+// or that was refused for being below the minimum
+type Downgrade struct {
+	At        time.Time `json:"at"`
+	UserID    string    `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	Transport string    `json:"transport"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Offered   []int     `json:"offered"`
+	Version   int       `json:"version"`
+	Reason    string    `json:"reason"`
+	Refused   bool      `json:"refused,omitempty"`
+}
+
+// ProtocolReport is how clients have been negotiating since the server
+// started, for retiring old versions safely
+type ProtocolReport struct {
+	MinVersion    int              `json:"min_version"`
+	LatestVersion int              `json:"latest_version"`
+	Negotiated    map[string]int64 `json:"negotiated"` // by "v<version>"
+	Downgrades    map[string]int64 `json:"downgrades"` // by reason
+	Refused       int64            `json:"refused"`
+	Recent        []Downgrade      `json:"recent"` // newest first
+}
+
+// negotiations counts the versions connections were given
+type negotiations struct {
+	versions   map[string]map[int]int64 // by transport
+	downgrades map[string]int64
+	refused    int64
+	recent     []Downgrade
+	mu         sync.Mutex
+}
+
+// Negotiate picks the protocol version for a connection from the
+// subprotocols it offered: the newest this server speaks, or Version0 when
+// it offered none. Connections below the configured minimum are refused
+// with ErrProtocolTooOld. Every outcome is counted for the protocol report.
+func (s *Service) Negotiate(offers []string, info NegotiationInfo) (Negotiation, error) {
+	var n Negotiation
+	for _, offer := range offers {
+		if version, ok := protocol.ParseSubprotocol(offer); ok {
+			n.Offered = append(n.Offered, version)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(n.Offered)))
+
+	for _, version := range n.Offered {
+		if version <= protocol.LatestVersion {
+			n.Version = version
+			break
+		}
+	}
+	switch {
+	case len(n.Offered) == 0:
+		n.Reason = DowngradeNoOffer
+	case n.Offered[0] > n.Version:
+		n.Reason = DowngradeUnsupported
+	}
+
+	var err error
+	if n.Version < s.MinProtocolVersion() {
+		err = fmt.Errorf("%w: got version %d, need %d or newer", ErrProtocolTooOld, n.Version, s.MinProtocolVersion())
+	}
+	s.negotiations.add(n, info, err != nil)
+	return n, err
+}
+
+// MinProtocolVersion is the oldest version clients may attach with. It
+// never exceeds the newest version the server speaks.
+func (s *Service) MinProtocolVersion() int {
+	min := s.config.MinProtocolVersion
+	if min > protocol.LatestVersion {
+		return protocol.LatestVersion
+	}
+	if min < protocol.Version0 {
+		return protocol.Version0
+	}
+	return min
+}
+
+func (c *negotiations) add(n Negotiation, info NegotiationInfo, refused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.versions == nil {
+		c.versions = make(map[string]map[int]int64)
+		c.downgrades = make(map[string]int64)
+	}
+	if refused {
+		c.refused++
+	} else {
+		if c.versions[info.Transport] == nil {
+			c.versions[info.Transport] = make(map[int]int64)
+		}
+		c.versions[info.Transport][n.Version]++
+	}
+	if n.Reason == "" && !refused {
+		return
+	}
+	if n.Reason != "" {
+		c.downgrades[n.Reason]++
+	}
+	c.recent = append(c.recent, Downgrade{
+		At:        time.Now(),
+		UserID:    info.UserID,
+		SessionID: info.SessionID,
+		Transport: info.Transport,
+		UserAgent: info.UserAgent,
+		Offered:   append([]int{}, n.Offered...),
+		Version:   n.Version,
+		Reason:    n.Reason,
+		Refused:   refused,
+	})
+	if len(c.recent) > recentDowngrades {
+		c.recent = c.recent[len(c.recent)-recentDowngrades:]
+	}
+}
+
+// ProtocolReport returns the negotiation counts and the latest downgrades
+func (s *Service) ProtocolReport() ProtocolReport {
+	c := &s.negotiations
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := ProtocolReport{
+		MinVersion:    s.MinProtocolVersion(),
+		LatestVersion: protocol.LatestVersion,
+		Negotiated:    make(map[string]int64),
+		Downgrades:    make(map[string]int64),
+		Refused:       c.refused,
+		Recent:        make([]Downgrade, 0, len(c.recent)),
+	}
+	for _, versions := range c.versions {
+		for version, count := range versions {
+			report.Negotiated[fmt.Sprintf("v%d", version)] += count
+		}
+	}
+	for reason, count := range c.downgrades {
+		report.Downgrades[reason] = count
+	}
+	for i := len(c.recent) - 1; i >= 0; i-- {
+		report.Recent = append(report.Recent, c.recent[i])
+	}
+	return report
+}
+
+// negotiationLines renders the negotiation counters
+func (s *Service) negotiationLines(exemplars bool) []string {
+	c := &s.negotiations
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// OpenMetrics names counter families without the _total suffix
+	suffix := "_total"
+	if exemplars {
+		suffix = ""
+	}
+	lines := []string{
+		"# HELP webtunnel_protocol_negotiations" + suffix + " Attached connections by transport and the protocol version they got.",
+		"# TYPE webtunnel_protocol_negotiations" + suffix + " counter",
+	}
+	transports := make([]string, 0, len(c.versions))
+	for transport := range c.versions {
+		transports = append(transports, transport)
+	}
+	sort.Strings(transports)
+	for _, transport := range transports {
+		versions := make([]int, 0, len(c.versions[transport]))
+		for version := range c.versions[transport] {
+			versions = append(versions, version)
+		}
+		sort.Ints(versions)
+		for _, version := range versions {
+			lines = append(lines, fmt.Sprintf("webtunnel_protocol_negotiations_total{transport=%q,version=\"%d\"} %d",
+				transport, version, c.versions[transport][version]))
+		}
+	}
+	lines = append(lines,
+		"# HELP webtunnel_protocol_downgrades"+suffix+" Connections that got an older protocol version than the newest they offered, by reason.",
+		"# TYPE webtunnel_protocol_downgrades"+suffix+" counter")
+	for _, reason := range []string{DowngradeNoOffer, DowngradeUnsupported} {
+		lines = append(lines, fmt.Sprintf("webtunnel_protocol_downgrades_total{reason=%q} %d", reason, c.downgrades[reason]))
+	}
+	lines = append(lines,
+		"# HELP webtunnel_protocol_refused"+suffix+" Connections refused for a protocol version below the minimum.",
+		"# TYPE webtunnel_protocol_refused"+suffix+" counter",
+		fmt.Sprintf("webtunnel_protocol_refused_total %d", c.refused))
+	return lines
+}
@@ -0,0 +1,139 @@
+package terminal
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Session list sort keys; a leading "-" sorts newest first
+const (
+	SortCreatedAt  = "created_at"
+	SortLastActive = "last_active"
+
+	DefaultPageLimit = 100
+	MaxPageLimit     = 500
+)
+
+var ErrInvalidPage = errors.New("invalid page")
+
+// SessionPage selects one page of a session list. Cursor is the NextCursor
+// of the previous page and cannot be combined with Offset.
+type SessionPage struct {
+	Limit  int
+	Offset int
+	Cursor string
+	Sort   string
+}
+
+// SessionList is one page of sessions. Total counts every session that
+// matched, across all pages.
+type SessionList struct {
+	Sessions   []*Session `json:"sessions"`
+	Total      int        `json:"total"`
+	Limit      int        `json:"limit"`
+	Offset     int        `json:"offset"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// Page sorts sessions and cuts one page from them. Ties on the sort key
+// are broken by ID, so pages are stable while sessions come and go; a
+// cursor resumes after the last session of its page even if earlier ones
+// ended since.
+func (p SessionPage) Page(sessions []*Session) (*SessionList, error) {
+	limit := p.Limit
+	switch {
+	case limit == 0:
+		limit = DefaultPageLimit
+	case limit < 0 || limit > MaxPageLimit:
+		return nil, fmt.Errorf("%w: limit must be 1 to %d", ErrInvalidPage, MaxPageLimit)
+	}
+	if p.Offset < 0 {
+		return nil, fmt.Errorf("%w: offset must not be negative", ErrInvalidPage)
+	}
+	if p.Offset > 0 && p.Cursor != "" {
+		return nil, fmt.Errorf("%w: use offset or cursor, not both", ErrInvalidPage)
+	}
+
+	field, desc := strings.CutPrefix(p.Sort, "-")
+	var value func(*Session) time.Time
+	switch field {
+	case "", SortCreatedAt:
+		value = func(s *Session) time.Time { return s.CreatedAt }
+	case SortLastActive:
+		value = func(s *Session) time.Time { return s.LastActive }
+	default:
+		return nil, fmt.Errorf("%w: sort must be created_at or last_active, optionally with a leading -", ErrInvalidPage)
+	}
+
+	// Keys are read once, since sessions stay active while they are sorted,
+	// and without monotonic readings, to compare like decoded cursors
+	keys := make(map[*Session]time.Time, len(sessions))
+	for _, s := range sessions {
+		keys[s] = value(s).Round(0)
+	}
+	before := func(at time.Time, id string, other *Session) bool {
+		if k := keys[other]; !at.Equal(k) {
+			return at.Before(k) != desc
+		}
+		return id < other.ID
+	}
+	sorted := append([]*Session(nil), sessions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return before(keys[sorted[i]], sorted[i].ID, sorted[j])
+	})
+
+	start := p.Offset
+	if p.Cursor != "" {
+		sortKey, at, id, err := decodeSessionCursor(p.Cursor)
+		if err != nil || sortKey != p.Sort {
+			return nil, fmt.Errorf("%w: cursor is invalid or for another sort", ErrInvalidPage)
+		}
+		start = sort.Search(len(sorted), func(i int) bool {
+			return before(at, id, sorted[i])
+		})
+	}
+	if start > len(sorted) {
+		start = len(sorted)
+	}
+	end := start + limit
+	if end > len(sorted) {
+		end = len(sorted)
+	}
+
+	list := &SessionList{
+		Sessions: sorted[start:end],
+		Total:    len(sorted),
+		Limit:    limit,
+		Offset:   start,
+	}
+	if end < len(sorted) {
+		last := sorted[end-1]
+		list.NextCursor = encodeSessionCursor(p.Sort, keys[last], last.ID)
+	}
+	return list, nil
+}
+
+func encodeSessionCursor(sortKey string, at time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(sortKey + "|" + at.Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeSessionCursor(cursor string) (string, time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 3)
+	if len(parts) != 3 {
+		return "", time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return "", time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	return parts[0], at, parts[2], nil
+}
@@ -0,0 +1,141 @@
+package terminal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// persistTimeout bounds each write to the session store
+const persistTimeout = 5 * time.Second
+
+var ErrNoSessionStore = errors.New("session history is not available")
+
+// SessionRecord is what a SessionStore keeps about a session: enough to
+// start it again after a restart, and its final status once it ended
+type SessionRecord struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	Command    string     `json:"command"`
+	WorkingDir string     `json:"working_dir"`
+	Env        []string   `json:"-"` // added by the provisioner or template
+	Template   string     `json:"template,omitempty"`
+	Shell      string     `json:"-"`
+	Login      bool       `json:"-"` // login shell
+	Charset    string     `json:"charset,omitempty"`
+	Limits     Limits     `json:"-"`
+	Status     Status     `json:"status"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	ExitReason ExitReason `json:"exit_reason,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	EndedAt    *time.Time `json:"ended_at,omitempty"`
+}
+
+// SessionStore persists session metadata across server restarts.
+// SaveSession inserts or replaces the record with the same ID.
+type SessionStore interface {
+	SaveSession(ctx context.Context, record SessionRecord) error
+	SessionHistory(ctx context.Context, userID string) ([]SessionRecord, error)
+	RunningSessions(ctx context.Context) ([]SessionRecord, error)
+}
+
+// SetSessionStore records every session as it starts and ends. Sessions
+// still running at shutdown stay recorded as running, for Restore.
+func (s *Service) SetSessionStore(store SessionStore) {
+	s.store = store
+}
+
+// History lists the user's recorded sessions, running and ended, newest
+// first
+func (s *Service) History(ctx context.Context, userID string) ([]SessionRecord, error) {
+	if s.store == nil {
+		return nil, ErrNoSessionStore
+	}
+	return s.store.SessionHistory(ctx, userID)
+}
+
+// Restore handles the sessions the store still lists as running, which
+// were cut off when the server last stopped. With relaunch_on_restart each
+// is started again under its old ID, in its old directory and with its
+// old command and environment; otherwise, or if that fails, it is marked
+// interrupted.
+func (s *Service) Restore(ctx context.Context) {
+	if s.store == nil {
+		return
+	}
+	records, err := s.store.RunningSessions(ctx)
+	if err != nil {
+		s.logger.Warn("Failed to load sessions to restore", zap.Error(err))
+		return
+	}
+
+	for _, record := range records {
+		if _, exists := s.GetSession(record.ID); exists {
+			continue
+		}
+		if s.config.RelaunchOnRestart {
+			relaunch := record
+			_, err := s.CreateSessionWithOptions(ctx, record.UserID, CreateOptions{
+				Command:  record.Command,
+				relaunch: &relaunch,
+			})
+			if err == nil {
+				s.logger.Info("Relaunched session",
+					zap.String("session_id", record.ID),
+					zap.String("user_id", record.UserID))
+				continue
+			}
+			s.logger.Warn("Failed to relaunch session",
+				zap.String("session_id", record.ID),
+				zap.Error(err))
+		}
+
+		now := time.Now()
+		record.Status, record.ExitReason, record.EndedAt = StatusInterrupted, ExitShutdown, &now
+		if err := s.store.SaveSession(ctx, record); err != nil {
+			s.logger.Warn("Failed to mark session interrupted",
+				zap.String("session_id", record.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// persist saves the session's current status. A failed write is logged
+// and otherwise ignored; the session itself is unaffected.
+func (s *Service) persist(session *Session) {
+	if s.store == nil {
+		return
+	}
+
+	record := SessionRecord{
+		ID:         session.ID,
+		UserID:     session.UserID,
+		Command:    session.Command,
+		WorkingDir: session.WorkingDir,
+		Env:        session.env,
+		Template:   session.Template,
+		Shell:      session.shell,
+		Login:      session.login,
+		Charset:    session.Charset,
+		Limits:     session.limits,
+		CreatedAt:  session.CreatedAt,
+	}
+	session.stateMu.Lock()
+	record.Status, record.ExitCode, record.ExitReason = session.status, session.exitCode, session.exitReason
+	record.Name, record.Tags = session.name, session.tags
+	session.stateMu.Unlock()
+	if record.Status != StatusRunning {
+		now := time.Now()
+		record.EndedAt = &now
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	if err := s.store.SaveSession(ctx, record); err != nil {
+		session.logger.Warn("Failed to persist session", zap.Error(err))
+	}
+}
@@ -0,0 +1,90 @@
+package terminal
+
+// States of plainText between bytes
+const (
+	plainGround   = iota
+	plainEscape   // after ESC
+	plainEscInter // in an ESC sequence's intermediate bytes
+	plainCSI      // in a control sequence
+	plainString   // in an OSC, DCS, SOS, PM or APC string
+	plainStringST // after ESC in a string, which \ ends
+)
+
+// plainText strips escape sequences and control characters from output,
+// leaving the text a log or search wants. Carriage returns become newlines,
+// so each redraw of a progress line is kept on a line of its own, and a
+// CRLF is one newline. It keeps its state between calls, so output can be
+// fed to it in pieces, as it arrives.
+type plainText struct {
+	state int
+	cr    bool // the last byte was a carriage return
+}
+
+// strip calls keep with each byte of output that is text and its index; a
+// carriage return is kept as '\n'
+func (p *plainText) strip(output []byte, keep func(i int, b byte)) {
+	for i, b := range output {
+		cr := p.cr
+		p.cr = false
+		switch p.state {
+		case plainGround:
+			switch {
+			case b == 0x1b:
+				p.state = plainEscape
+			case b == '\r':
+				p.cr = true
+				keep(i, '\n')
+			case b == '\n':
+				if !cr {
+					keep(i, b)
+				}
+			case b == '\t' || (b >= 0x20 && b != 0x7f):
+				keep(i, b)
+			}
+		case plainEscape:
+			switch {
+			case b == '[':
+				p.state = plainCSI
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				p.state = plainString
+			case b >= 0x20 && b <= 0x2f:
+				p.state = plainEscInter
+			case b == 0x1b:
+			default:
+				p.state = plainGround
+			}
+		case plainEscInter:
+			if b < 0x20 || b > 0x2f {
+				p.state = plainGround
+			}
+		case plainCSI:
+			switch {
+			case b == 0x1b:
+				p.state = plainEscape
+			case b >= 0x40 && b <= 0x7e:
+				p.state = plainGround
+			}
+		case plainString:
+			switch b {
+			case 0x07:
+				p.state = plainGround
+			case 0x1b:
+				p.state = plainStringST
+			}
+		case plainStringST:
+			if b == '\\' {
+				p.state = plainGround
+			} else {
+				p.state = plainString
+			}
+		}
+	}
+}
+
+// append appends the text of output to dst
+func (p *plainText) append(dst, output []byte) []byte {
+	p.strip(output, func(_ int, b byte) {
+		dst = append(dst, b)
+	})
+	return dst
+}
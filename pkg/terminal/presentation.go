@@ -0,0 +1,85 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// SetPresentation turns presentation mode on or off. While presenting,
+// only the owner may type and viewers receive the owner's highlights.
+func (s *Service) SetPresentation(sessionID, userID string, enabled bool) error {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+	if session.UserID != userID {
+		return fmt.Errorf("only the session owner can change presentation mode")
+	}
+
+	session.presenting.Store(enabled)
+
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	s.broadcast(session, protocol.Message{
+		Type:      protocol.TypePresentation,
+		Data:      state,
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	}, nil)
+
+	session.logger.Info("Presentation mode changed", zap.Bool("enabled", enabled))
+	return nil
+}
+
+// Presenting reports whether the owner is presenting the session
+func (s *Session) Presenting() bool {
+	return s.presenting.Load()
+}
+
+// handleHighlight relays the presenter's highlight events to viewers.
+func (s *Service) handleHighlight(session *Session, cl *client, msg protocol.Message) {
+	if !session.Presenting() || !cl.isOwner(session) {
+		cl.writeJSON(protocol.Message{
+			Type:      protocol.TypeError,
+			Data:      "Highlights are only available to the presenter",
+			Timestamp: time.Now(),
+			SessionID: session.ID,
+		})
+		return
+	}
+
+	out := protocol.Message{
+		Type:      msg.Type,
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	}
+
+	if msg.Type == protocol.TypeHighlight {
+		var highlight protocol.Highlight
+		if err := msg.Decode(&highlight); err != nil || highlight.Row < 0 ||
+			highlight.RowCount < 0 || highlight.ColStart < 0 || highlight.ColEnd < highlight.ColStart {
+			cl.writeJSON(protocol.Message{
+				Type:      protocol.TypeError,
+				Data:      "Invalid highlight",
+				Timestamp: time.Now(),
+				SessionID: session.ID,
+			})
+			return
+		}
+		if highlight.RowCount == 0 {
+			highlight.RowCount = 1
+		}
+
+		// Re-encode so viewers only ever see validated fields
+		data, _ := json.Marshal(highlight)
+		out.Data = string(data)
+	}
+
+	s.broadcast(session, out, cl)
+}
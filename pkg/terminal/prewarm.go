@@ -0,0 +1,310 @@
+package terminal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// prewarmCheckInterval is how often pools are topped up besides after each
+// claim, replacing pooled processes that exited on their own
+const prewarmCheckInterval = 30 * time.Second
+
+// warmProcess is a process started ahead of time in its own session
+// directory; a session claiming it takes over the ID as well
+type warmProcess struct {
+	id     string
+	dir    string
+	ctx    context.Context
+	cancel context.CancelFunc
+	proc   *process
+}
+
+func (w *warmProcess) exited() bool {
+	return len(w.proc.exited) > 0
+}
+
+// discard stops a pooled process that will not be claimed
+func (w *warmProcess) discard() {
+	w.cancel()
+	w.proc.pty.Close()
+	os.RemoveAll(w.dir)
+}
+
+// prewarmPool holds idle processes keyed by prewarmKey
+type prewarmPool struct {
+	sizes  map[string]int
+	idle   map[string][]*warmProcess
+	hits   map[string]int64
+	misses map[string]int64
+	closed bool
+	mu     sync.Mutex
+
+	// wake asks the fill loop to replace a claimed process
+	wake chan struct{}
+}
+
+// newPrewarmPool returns nil when no pool has a size, so callers can skip
+// the pool entirely
+func newPrewarmPool(pools []config.PrewarmPool) *prewarmPool {
+	p := &prewarmPool{
+		sizes:  make(map[string]int),
+		idle:   make(map[string][]*warmProcess),
+		hits:   make(map[string]int64),
+		misses: make(map[string]int64),
+		wake:   make(chan struct{}, 1),
+	}
+	for _, pool := range pools {
+		if pool.Size > 0 {
+			p.sizes[prewarmKey(pool.Command)] += pool.Size
+		}
+	}
+	if len(p.sizes) == 0 {
+		return nil
+	}
+	return p
+}
+
+// prewarmKey maps commands that start the same process to one pool
+func prewarmKey(command string) string {
+	if isShell(command) {
+		return "bash"
+	}
+	return command
+}
+
+// take pops the oldest live process for key. ok is false when key has no
+// pool, so only sessions that could have been served count as misses.
+func (p *prewarmPool) take(key string) (warm *warmProcess, ok bool) {
+	var dead []*warmProcess
+	defer func() {
+		for _, w := range dead {
+			w.discard()
+		}
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, pooled := p.sizes[key]; !pooled || p.closed {
+		return nil, false
+	}
+
+	idle := p.idle[key]
+	for len(idle) > 0 && warm == nil {
+		if idle[0].exited() {
+			dead = append(dead, idle[0])
+		} else {
+			warm = idle[0]
+		}
+		idle = idle[1:]
+	}
+	p.idle[key] = idle
+
+	if warm != nil {
+		p.hits[key]++
+	} else {
+		p.misses[key]++
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return warm, true
+}
+
+// put adds a process to its pool, reporting false once the pool is closed
+func (p *prewarmPool) put(key string, warm *warmProcess) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.idle[key] = append(p.idle[key], warm)
+	return true
+}
+
+// missing drops exited processes for key and returns how many are needed
+// to fill its pool again
+func (p *prewarmPool) missing(key string) int {
+	p.mu.Lock()
+	var live, dead []*warmProcess
+	for _, w := range p.idle[key] {
+		if w.exited() {
+			dead = append(dead, w)
+		} else {
+			live = append(live, w)
+		}
+	}
+	p.idle[key] = live
+	n := p.sizes[key] - len(live)
+	p.mu.Unlock()
+
+	for _, w := range dead {
+		w.discard()
+	}
+	return n
+}
+
+// holds reports whether sessionID belongs to an idle pooled process
+func (p *prewarmPool) holds(sessionID string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, idle := range p.idle {
+		for _, w := range idle {
+			if w.id == sessionID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// drain stops every idle process and refuses new ones
+func (p *prewarmPool) drain() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = make(map[string][]*warmProcess)
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, pool := range idle {
+		for _, w := range pool {
+			w.discard()
+		}
+	}
+}
+
+func (p *prewarmPool) metricLines() []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := make([]string, 0, len(p.sizes))
+	for key := range p.sizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{
+		"# HELP webtunnel_prewarm_claims_total Sessions that could use a pre-warmed process, by whether one was idle.",
+		"# TYPE webtunnel_prewarm_claims_total counter",
+	}
+	for _, key := range keys {
+		lines = append(lines,
+			fmt.Sprintf("webtunnel_prewarm_claims_total{command=%q,result=\"hit\"} %d", key, p.hits[key]),
+			fmt.Sprintf("webtunnel_prewarm_claims_total{command=%q,result=\"miss\"} %d", key, p.misses[key]))
+	}
+	lines = append(lines,
+		"# HELP webtunnel_prewarm_idle Idle pre-warmed processes per pool.",
+		"# TYPE webtunnel_prewarm_idle gauge")
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("webtunnel_prewarm_idle{command=%q} %d", key, len(p.idle[key])))
+	}
+	return lines
+}
+
+// Prewarm keeps the configured pools full until ctx is done, then stops
+// the idle processes. It returns at once when no pool is configured.
+func (s *Service) Prewarm(ctx context.Context) {
+	if s.prewarm == nil {
+		return
+	}
+
+	ticker := time.NewTicker(prewarmCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.fillPools(ctx)
+
+		select {
+		case <-ctx.Done():
+			s.prewarm.drain()
+			return
+		case <-s.prewarm.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) fillPools(ctx context.Context) {
+	for key := range s.prewarm.sizes {
+		for n := s.prewarm.missing(key); n > 0; n-- {
+			if ctx.Err() != nil {
+				return
+			}
+			warm, err := s.startWarm(key)
+			if err != nil {
+				// Try again on the next tick rather than spinning
+				s.logger.Warn("Failed to pre-warm process", zap.String("command", key), zap.Error(err))
+				break
+			}
+			if !s.prewarm.put(key, warm) {
+				warm.discard()
+				return
+			}
+		}
+	}
+}
+
+func (s *Service) startWarm(command string) (*warmProcess, error) {
+	id := generateSessionID()
+	dir := filepath.Join(s.config.WorkingDirectory, "sessions", id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	proc, err := s.spawn(ctx, id, "", command, dir, "", "", s.config.LoginShell, nil, s.initialSize(CreateOptions{}), nil)
+	if err != nil {
+		cancel()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &warmProcess{id: id, dir: dir, ctx: ctx, cancel: cancel, proc: proc}, nil
+}
+
+// claimWarm takes a pooled process for a new session. It returns nil when
+// the session needs a fresh process: a snapshot, shell, login mode,
+// environment, limits or working directory of its own, a command without a
+// pool, or an empty pool.
+func (s *Service) claimWarm(command, workingDir string, opts CreateOptions) *warmProcess {
+	if s.prewarm == nil || opts.Snapshot != "" || opts.SSH != nil || workingDir != s.config.WorkingDirectory {
+		return nil
+	}
+	if opts.Shell != "" || s.loginShell(opts) != s.config.LoginShell || len(opts.Env) > 0 || !opts.Limits.IsZero() {
+		return nil
+	}
+	warm, _ := s.prewarm.take(prewarmKey(command))
+	return warm
+}
+
+// adoptWarm hands a claimed process over to its session, resizing it to
+// the size the client asked for
+func (s *Service) adoptWarm(session *Session, warm *warmProcess, size *pty.Winsize) {
+	session.cmd, session.pty = warm.proc.cmd, warm.proc.pty
+	session.oomKills, _ = oomKillCount()
+	if err := pty.Setsize(session.pty, size); err != nil {
+		session.logger.Warn("Failed to resize pre-warmed PTY", zap.Error(err))
+	}
+
+	session.logger.Info("Claimed pre-warmed PTY session",
+		zap.String("command", session.Command),
+		zap.Uint16("cols", size.Cols),
+		zap.Uint16("rows", size.Rows),
+		zap.Int("pid", session.cmd.Process.Pid))
+
+	s.watchProcess(session, warm.proc.exited)
+}
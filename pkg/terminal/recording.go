@@ -0,0 +1,194 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+	"go.uber.org/zap"
+)
+
+var ErrNoRecording = errors.New("session is not being recorded")
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes a session's output to an asciicast v2 file: a header
+// line, then one [seconds, code, data] line per event. Input is not
+// recorded. A nil recorder records nothing.
+type recorder struct {
+	path    string
+	file    *os.File
+	start   time.Time
+	pending []byte // an incomplete UTF-8 sequence held for the next chunk
+	logger  *zap.Logger
+	mu      sync.Mutex
+}
+
+// recordingsDir is where casts are written: recording_dir, or a
+// recordings directory beside the per-session ones
+func (s *Service) recordingsDir() string {
+	if s.config.RecordingDir != "" {
+		return s.config.RecordingDir
+	}
+	dir := s.config.WorkingDirectory
+	if dir == "" {
+		dir = "/tmp/webtunnel"
+	}
+	return filepath.Join(dir, "recordings")
+}
+
+// startRecording opens the session's cast when recording is enabled. A
+// session that cannot be recorded still runs; the failure is logged.
+func (s *Service) startRecording(session *Session, size *pty.Winsize) *recorder {
+	if !s.config.RecordSessions {
+		return nil
+	}
+
+	dir := s.recordingsDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		session.logger.Error("Failed to create recordings directory", zap.Error(err))
+		return nil
+	}
+	path := filepath.Join(dir, session.ID+".cast")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		session.logger.Error("Failed to create session recording", zap.Error(err))
+		return nil
+	}
+
+	r := &recorder{path: path, file: file, start: time.Now(), logger: session.logger}
+	header := castHeader{
+		Version:   2,
+		Width:     size.Cols,
+		Height:    size.Rows,
+		Timestamp: r.start.Unix(),
+		Command:   session.Command,
+		Title:     session.ID,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	}
+	if err := r.writeLine(header); err != nil {
+		file.Close()
+		os.Remove(path)
+		session.logger.Error("Failed to write session recording header", zap.Error(err))
+		return nil
+	}
+	session.logger.Info("Recording session", zap.String("path", path))
+	return r
+}
+
+// output records a chunk of PTY output. Chunks can end inside a multi-byte
+// character, which JSON would mangle, so the partial sequence is held back
+// until the rest arrives.
+func (r *recorder) output(data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data = append(r.pending, data...)
+	r.pending = nil
+	if cut := incompleteTail(data); cut > 0 {
+		r.pending = append([]byte{}, data[len(data)-cut:]...)
+		data = data[:len(data)-cut]
+	}
+	if len(data) > 0 {
+		r.event("o", string(data))
+	}
+}
+
+// resize records a terminal size change
+func (r *recorder) resize(cols, rows uint16) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// close flushes any held bytes and closes the file; the cast stays on disk
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	if err := r.file.Close(); err != nil {
+		r.logger.Warn("Failed to close session recording", zap.Error(err))
+	}
+	r.file = nil
+}
+
+// event writes one event line. Callers hold mu.
+func (r *recorder) event(code, data string) {
+	if r.file == nil {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	if err := r.writeLine([]interface{}{elapsed, code, data}); err != nil {
+		r.logger.Warn("Failed to write session recording", zap.Error(err))
+	}
+}
+
+func (r *recorder) writeLine(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+// incompleteTail returns how many bytes at the end of data start a UTF-8
+// sequence that is not finished yet
+func incompleteTail(data []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if b < utf8.RuneSelf {
+			return 0
+		}
+		if utf8.RuneStart(b) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// Recording returns the path of the session's cast. The file grows while
+// the session runs and is complete once it has stopped.
+func (s *Service) Recording(sessionID string) (string, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return "", fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	if session.recorder == nil {
+		return "", fmt.Errorf("%w: %s", ErrNoRecording, sessionID)
+	}
+	return session.recorder.path, nil
+}
@@ -0,0 +1,795 @@
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Color is a cell color: ColorDefault, a palette index 0-255, or an RGB
+// value with colorRGB set
+type Color int32
+
+const (
+	ColorDefault Color = -1
+	colorRGB     Color = 1 << 24
+)
+
+// Style is the look of one cell
+type Style struct {
+	FG        Color
+	BG        Color
+	Bold      bool
+	Faint     bool
+	Italic    bool
+	Underline bool
+	Inverse   bool
+	Strike    bool
+}
+
+var defaultStyle = Style{FG: ColorDefault, BG: ColorDefault}
+
+// Default colors used when rendering, matching the web client's theme
+const (
+	DefaultForeground = "#d4d4d4"
+	DefaultBackground = "#1e1e1e"
+)
+
+type cell struct {
+	r     rune
+	style Style
+}
+
+// row is one screen line. wrapped is set when text ran past the last
+// column into the next row, so both belong to one logical line.
+type row struct {
+	cells   []cell
+	wrapped bool
+}
+
+// scrolledLine keeps only the text of a row that left the screen
+type scrolledLine struct {
+	text    []rune
+	wrapped bool
+}
+
+// Run is a stretch of text in one style
+type Run struct {
+	Text  string
+	Style Style
+}
+
+// Screen is a minimal VT100/xterm model: enough cursor movement, erasing,
+// scrolling and SGR handling to reconstruct what a full-screen program
+// shows. Input it does not understand is skipped.
+type Screen struct {
+	cols, rows int
+	grid       []*row
+	primary    []*row // saved while the alternate screen is active
+	x, y       int
+
+	// Text scrolled off the top of the primary screen, oldest first. It
+	// grows to twice the limit before the oldest half is dropped.
+	scrollback    []scrolledLine
+	maxScrollback int
+
+	style      Style
+	wrapNext   bool
+	top, bot   int // scroll region, inclusive
+	savedX     int
+	savedY     int
+	savedStyle Style
+
+	// Parser state, kept across Writes so sequences may be split
+	state  int
+	params []byte
+	utf8   []byte
+}
+
+const (
+	stateGround = iota
+	stateEscape
+	stateCSI
+	stateOSC
+	stateOSCEscape
+	stateCharset
+)
+
+func NewScreen(cols, rows int) *Screen {
+	if cols <= 0 {
+		cols = 80
+	}
+	if rows <= 0 {
+		rows = 24
+	}
+	s := &Screen{cols: cols, rows: rows}
+	s.reset()
+	return s
+}
+
+// SetScrollback keeps up to n lines that scroll off the top of the screen
+func (s *Screen) SetScrollback(n int) {
+	s.maxScrollback = n
+}
+
+func (s *Screen) reset() {
+	s.grid = s.blankGrid()
+	s.scrollback = nil
+	s.primary = nil
+	s.x, s.y = 0, 0
+	s.style = defaultStyle
+	s.wrapNext = false
+	s.top, s.bot = 0, s.rows-1
+	s.savedX, s.savedY, s.savedStyle = 0, 0, defaultStyle
+}
+
+func (s *Screen) blankGrid() []*row {
+	grid := make([]*row, s.rows)
+	for i := range grid {
+		grid[i] = s.blankRow()
+	}
+	return grid
+}
+
+func (s *Screen) blankRow() *row {
+	cells := make([]cell, s.cols)
+	for i := range cells {
+		cells[i] = cell{r: ' ', style: defaultStyle}
+	}
+	return &row{cells: cells}
+}
+
+// Size returns the screen dimensions
+func (s *Screen) Size() (cols, rows int) {
+	return s.cols, s.rows
+}
+
+// Resize changes the screen size as xterm does, without reflowing text.
+// Rows that no longer fit above the cursor scroll into the scrollback,
+// rows are cut or padded to the new width, and the scroll region resets.
+func (s *Screen) Resize(cols, rows int) {
+	if cols <= 0 || rows <= 0 || (cols == s.cols && rows == s.rows) {
+		return
+	}
+	s.cols = cols
+	if s.primary != nil {
+		s.primary = s.resizeGrid(s.primary, rows, &s.savedY, true)
+		s.grid = s.resizeGrid(s.grid, rows, &s.y, false)
+	} else {
+		s.grid = s.resizeGrid(s.grid, rows, &s.y, true)
+	}
+	s.rows = rows
+	s.x = clamp(s.x, 0, cols-1)
+	s.savedX = clamp(s.savedX, 0, cols-1)
+	s.top, s.bot = 0, rows-1
+	s.wrapNext = false
+}
+
+// resizeGrid fits grid to s.cols by rows, keeping the row at cursor
+// visible. Rows dropped off the top of the primary screen are kept as
+// scrollback.
+func (s *Screen) resizeGrid(grid []*row, rows int, cursor *int, primary bool) []*row {
+	for _, r := range grid {
+		if len(r.cells) > s.cols {
+			r.cells = r.cells[:s.cols]
+			r.wrapped = false
+		}
+		for len(r.cells) < s.cols {
+			r.cells = append(r.cells, cell{r: ' ', style: defaultStyle})
+		}
+	}
+
+	if drop := *cursor + 1 - rows; drop > 0 {
+		if primary && s.maxScrollback > 0 {
+			for _, r := range grid[:drop] {
+				s.keep(r)
+			}
+		}
+		grid = grid[drop:]
+		*cursor -= drop
+	}
+	if len(grid) > rows {
+		grid = grid[:rows]
+	}
+	for len(grid) < rows {
+		grid = append(grid, s.blankRow())
+	}
+	return grid
+}
+
+// Write feeds terminal output through the model
+func (s *Screen) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.feed(b)
+	}
+	return len(p), nil
+}
+
+func (s *Screen) feed(b byte) {
+	switch s.state {
+	case stateEscape:
+		s.escape(b)
+		return
+	case stateCSI:
+		if b >= 0x40 && b <= 0x7e {
+			s.state = stateGround
+			s.csi(b)
+		} else if len(s.params) < 64 {
+			s.params = append(s.params, b)
+		}
+		return
+	case stateOSC:
+		// Titles and hyperlinks end with BEL or ST and are not rendered
+		switch b {
+		case 0x07:
+			s.state = stateGround
+		case 0x1b:
+			s.state = stateOSCEscape
+		}
+		return
+	case stateOSCEscape:
+		s.state = stateOSC
+		if b == '\\' {
+			s.state = stateGround
+		}
+		return
+	case stateCharset:
+		s.state = stateGround
+		return
+	}
+
+	if len(s.utf8) > 0 || b >= 0x80 {
+		s.utf8 = append(s.utf8, b)
+		if utf8.FullRune(s.utf8) {
+			r, _ := utf8.DecodeRune(s.utf8)
+			s.utf8 = s.utf8[:0]
+			s.print(r)
+		}
+		return
+	}
+
+	switch b {
+	case 0x1b:
+		s.state = stateEscape
+	case '\r':
+		s.x = 0
+		s.wrapNext = false
+	case '\n', 0x0b, 0x0c:
+		s.lineFeed()
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapNext = false
+	case '\t':
+		s.x = (s.x/8 + 1) * 8
+		if s.x >= s.cols {
+			s.x = s.cols - 1
+		}
+	default:
+		if b >= 0x20 && b < 0x7f {
+			s.print(rune(b))
+		}
+	}
+}
+
+func (s *Screen) escape(b byte) {
+	s.state = stateGround
+	switch b {
+	case '[':
+		s.state = stateCSI
+		s.params = s.params[:0]
+	case ']', 'P', '_', '^':
+		// OSC, DCS, APC and PM strings are all skipped the same way
+		s.state = stateOSC
+	case '(', ')', '*', '+', '#':
+		s.state = stateCharset
+	case '7':
+		s.saveCursor()
+	case '8':
+		s.restoreCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.x = 0
+		s.lineFeed()
+	case 'M':
+		if s.y == s.top {
+			s.scrollDown(1)
+		} else if s.y > 0 {
+			s.y--
+		}
+		s.wrapNext = false
+	case 'c':
+		s.reset()
+	}
+}
+
+func (s *Screen) print(r rune) {
+	if s.wrapNext {
+		s.grid[s.y].wrapped = true
+		s.x = 0
+		s.lineFeed()
+	}
+	s.grid[s.y].cells[s.x] = cell{r: r, style: s.style}
+	if s.x == s.cols-1 {
+		s.wrapNext = true
+	} else {
+		s.x++
+	}
+}
+
+func (s *Screen) lineFeed() {
+	s.wrapNext = false
+	if s.y == s.bot {
+		s.scrollUp(1)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+// scrollUp moves the region up, reusing the row that falls off the top as
+// the new bottom row
+func (s *Screen) scrollUp(n int) {
+	for ; n > 0; n-- {
+		gone := s.grid[s.top]
+		if s.top == 0 && s.primary == nil && s.maxScrollback > 0 {
+			s.keep(gone)
+		}
+		copy(s.grid[s.top:s.bot], s.grid[s.top+1:s.bot+1])
+		s.eraseRow(gone)
+		s.grid[s.bot] = gone
+	}
+}
+
+func (s *Screen) scrollDown(n int) {
+	for ; n > 0; n-- {
+		gone := s.grid[s.bot]
+		copy(s.grid[s.top+1:s.bot+1], s.grid[s.top:s.bot])
+		s.eraseRow(gone)
+		s.grid[s.top] = gone
+	}
+}
+
+func (s *Screen) keep(r *row) {
+	if len(s.scrollback) >= 2*s.maxScrollback {
+		s.scrollback = append([]scrolledLine(nil), s.scrollback[len(s.scrollback)-s.maxScrollback+1:]...)
+	}
+	s.scrollback = append(s.scrollback, scrolledLine{text: r.text(), wrapped: r.wrapped})
+}
+
+// text returns the row's characters; trailing blanks are dropped unless the
+// line continues on the next row
+func (r *row) text() []rune {
+	end := len(r.cells)
+	if !r.wrapped {
+		for end > 0 && r.cells[end-1].r == ' ' {
+			end--
+		}
+	}
+	text := make([]rune, end)
+	for i := range text {
+		text[i] = r.cells[i].r
+	}
+	return text
+}
+
+// history returns the kept scrollback, oldest first, then the screen rows
+func (s *Screen) history() []scrolledLine {
+	kept := s.scrollback
+	if len(kept) > s.maxScrollback {
+		kept = kept[len(kept)-s.maxScrollback:]
+	}
+	lines := make([]scrolledLine, 0, len(kept)+len(s.grid))
+	lines = append(lines, kept...)
+	for _, r := range s.grid {
+		lines = append(lines, scrolledLine{text: r.text(), wrapped: r.wrapped})
+	}
+	return lines
+}
+
+func (s *Screen) saveCursor() {
+	s.savedX, s.savedY, s.savedStyle = s.x, s.y, s.style
+}
+
+func (s *Screen) restoreCursor() {
+	s.x, s.y, s.style = s.savedX, s.savedY, s.savedStyle
+	s.wrapNext = false
+}
+
+// csi runs a control sequence; s.params holds everything between ESC [ and
+// the final byte
+func (s *Screen) csi(final byte) {
+	private := len(s.params) > 0 && s.params[0] >= '<' && s.params[0] <= '?'
+	raw := string(s.params)
+	if private {
+		raw = raw[1:]
+	}
+	var args []int
+	if raw != "" {
+		for _, field := range strings.FieldsFunc(raw, func(r rune) bool { return r == ';' || r == ':' }) {
+			n, _ := strconv.Atoi(field)
+			args = append(args, n)
+		}
+	}
+	arg := func(i, fallback int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return fallback
+	}
+
+	if private {
+		if s.params[0] == '?' && (final == 'h' || final == 'l') {
+			for _, mode := range args {
+				if mode == 47 || mode == 1047 || mode == 1049 {
+					s.alternate(final == 'h', mode == 1049)
+				}
+			}
+		}
+		return
+	}
+
+	s.wrapNext = false
+	switch final {
+	case 'A':
+		s.y = clamp(s.y-arg(0, 1), 0, s.rows-1)
+	case 'B', 'e':
+		s.y = clamp(s.y+arg(0, 1), 0, s.rows-1)
+	case 'C', 'a':
+		s.x = clamp(s.x+arg(0, 1), 0, s.cols-1)
+	case 'D':
+		s.x = clamp(s.x-arg(0, 1), 0, s.cols-1)
+	case 'E':
+		s.x = 0
+		s.y = clamp(s.y+arg(0, 1), 0, s.rows-1)
+	case 'F':
+		s.x = 0
+		s.y = clamp(s.y-arg(0, 1), 0, s.rows-1)
+	case 'G', '`':
+		s.x = clamp(arg(0, 1)-1, 0, s.cols-1)
+	case 'd':
+		s.y = clamp(arg(0, 1)-1, 0, s.rows-1)
+	case 'H', 'f':
+		s.y = clamp(arg(0, 1)-1, 0, s.rows-1)
+		s.x = clamp(arg(1, 1)-1, 0, s.cols-1)
+	case 'J':
+		s.eraseDisplay(arg(0, 0))
+	case 'K':
+		s.eraseLine(arg(0, 0))
+	case 'L':
+		if s.y >= s.top && s.y <= s.bot {
+			top := s.top
+			s.top = s.y
+			s.scrollDown(arg(0, 1))
+			s.top = top
+		}
+	case 'M':
+		if s.y >= s.top && s.y <= s.bot {
+			top := s.top
+			s.top = s.y
+			s.scrollUp(arg(0, 1))
+			s.top = top
+		}
+	case 'P':
+		n := clamp(arg(0, 1), 0, s.cols-s.x)
+		row := s.grid[s.y].cells
+		copy(row[s.x:], row[s.x+n:])
+		s.blank(row[s.cols-n:])
+	case '@':
+		n := clamp(arg(0, 1), 0, s.cols-s.x)
+		row := s.grid[s.y].cells
+		copy(row[s.x+n:], row[s.x:s.cols-n])
+		s.blank(row[s.x : s.x+n])
+	case 'X':
+		n := clamp(arg(0, 1), 0, s.cols-s.x)
+		s.blank(s.grid[s.y].cells[s.x : s.x+n])
+	case 'S':
+		s.scrollUp(arg(0, 1))
+	case 'T':
+		s.scrollDown(arg(0, 1))
+	case 'r':
+		top, bot := arg(0, 1)-1, arg(1, s.rows)-1
+		if top < bot && bot < s.rows {
+			s.top, s.bot = top, bot
+			s.x, s.y = 0, 0
+		}
+	case 's':
+		s.saveCursor()
+	case 'u':
+		s.restoreCursor()
+	case 'm':
+		s.sgr(args)
+	}
+}
+
+// blank erases cells with the current background, as xterm does
+func (s *Screen) blank(cells []cell) {
+	for i := range cells {
+		cells[i] = cell{r: ' ', style: Style{FG: ColorDefault, BG: s.style.BG}}
+	}
+}
+
+func (s *Screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.eraseLine(0)
+		for _, row := range s.grid[s.y+1:] {
+			s.eraseRow(row)
+		}
+	case 1:
+		s.eraseLine(1)
+		for _, row := range s.grid[:s.y] {
+			s.eraseRow(row)
+		}
+	case 2:
+		for _, row := range s.grid {
+			s.eraseRow(row)
+		}
+	case 3:
+		for _, row := range s.grid {
+			s.eraseRow(row)
+		}
+		s.scrollback = nil
+	}
+}
+
+func (s *Screen) eraseLine(mode int) {
+	row := s.grid[s.y]
+	switch mode {
+	case 0:
+		s.blank(row.cells[s.x:])
+		row.wrapped = false
+	case 1:
+		s.blank(row.cells[:s.x+1])
+	case 2:
+		s.eraseRow(row)
+	}
+}
+
+func (s *Screen) eraseRow(row *row) {
+	s.blank(row.cells)
+	row.wrapped = false
+}
+
+// alternate switches to or from the alternate screen used by editors and
+// pagers. Mode 1049 also saves and restores the cursor.
+func (s *Screen) alternate(on, withCursor bool) {
+	if on == (s.primary != nil) {
+		return
+	}
+	if on {
+		if withCursor {
+			s.saveCursor()
+		}
+		s.primary = s.grid
+		s.grid = s.blankGrid()
+		return
+	}
+	s.grid = s.primary
+	s.primary = nil
+	if withCursor {
+		s.restoreCursor()
+	}
+}
+
+func (s *Screen) sgr(args []int) {
+	if len(args) == 0 {
+		args = []int{0}
+	}
+	for i := 0; i < len(args); i++ {
+		switch n := args[i]; {
+		case n == 0:
+			s.style = defaultStyle
+		case n == 1:
+			s.style.Bold = true
+		case n == 2:
+			s.style.Faint = true
+		case n == 3:
+			s.style.Italic = true
+		case n == 4:
+			s.style.Underline = true
+		case n == 7:
+			s.style.Inverse = true
+		case n == 9:
+			s.style.Strike = true
+		case n == 22:
+			s.style.Bold, s.style.Faint = false, false
+		case n == 23:
+			s.style.Italic = false
+		case n == 24:
+			s.style.Underline = false
+		case n == 27:
+			s.style.Inverse = false
+		case n == 29:
+			s.style.Strike = false
+		case n >= 30 && n <= 37:
+			s.style.FG = Color(n - 30)
+		case n == 39:
+			s.style.FG = ColorDefault
+		case n >= 40 && n <= 47:
+			s.style.BG = Color(n - 40)
+		case n == 49:
+			s.style.BG = ColorDefault
+		case n >= 90 && n <= 97:
+			s.style.FG = Color(n - 90 + 8)
+		case n >= 100 && n <= 107:
+			s.style.BG = Color(n - 100 + 8)
+		case n == 38 || n == 48:
+			var color Color
+			color, i = extendedColor(args, i)
+			if color == ColorDefault {
+				continue
+			}
+			if n == 38 {
+				s.style.FG = color
+			} else {
+				s.style.BG = color
+			}
+		}
+	}
+}
+
+// extendedColor parses 38;5;n and 38;2;r;g;b, returning the index of the
+// last argument consumed
+func extendedColor(args []int, i int) (Color, int) {
+	if i+1 >= len(args) {
+		return ColorDefault, i
+	}
+	switch args[i+1] {
+	case 5:
+		if i+2 < len(args) {
+			return Color(clamp(args[i+2], 0, 255)), i + 2
+		}
+	case 2:
+		if i+4 < len(args) {
+			r, g, b := clamp(args[i+2], 0, 255), clamp(args[i+3], 0, 255), clamp(args[i+4], 0, 255)
+			return colorRGB | Color(r<<16|g<<8|b), i + 4
+		}
+	}
+	return ColorDefault, len(args)
+}
+
+func clamp(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
+
+// Lines returns the visible screen as runs of equally styled text, with
+// trailing unstyled blanks removed from each line
+func (s *Screen) Lines() [][]Run {
+	lines := make([][]Run, len(s.grid))
+	for y, r := range s.grid {
+		lines[y] = runs(r.cells)
+	}
+	return lines
+}
+
+// Text returns the visible screen as plain lines
+func (s *Screen) Text() []string {
+	lines := s.Lines()
+	text := make([]string, len(lines))
+	for i, runs := range lines {
+		var b strings.Builder
+		for _, run := range runs {
+			b.WriteString(run.Text)
+		}
+		text[i] = b.String()
+	}
+	return text
+}
+
+// CSS returns inline declarations for the style, suitable for HTML that
+// must render without a stylesheet, such as email
+func (st Style) CSS() string {
+	fg, bg := st.FG, st.BG
+	fgCSS, bgCSS := fg.CSS(), bg.CSS()
+	if st.Inverse {
+		if fgCSS == "" {
+			fgCSS = DefaultForeground
+		}
+		if bgCSS == "" {
+			bgCSS = DefaultBackground
+		}
+		fgCSS, bgCSS = bgCSS, fgCSS
+	}
+
+	var decls []string
+	if fgCSS != "" {
+		decls = append(decls, "color:"+fgCSS)
+	}
+	if bgCSS != "" {
+		decls = append(decls, "background-color:"+bgCSS)
+	}
+	if st.Bold {
+		decls = append(decls, "font-weight:bold")
+	}
+	if st.Faint {
+		decls = append(decls, "opacity:0.6")
+	}
+	if st.Italic {
+		decls = append(decls, "font-style:italic")
+	}
+	switch {
+	case st.Underline && st.Strike:
+		decls = append(decls, "text-decoration:underline line-through")
+	case st.Underline:
+		decls = append(decls, "text-decoration:underline")
+	case st.Strike:
+		decls = append(decls, "text-decoration:line-through")
+	}
+	return strings.Join(decls, ";")
+}
+
+// ansiColors is the 16-color palette of the web client's theme
+var ansiColors = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+// CSS returns the color as #rrggbb, or "" for the default
+func (c Color) CSS() string {
+	switch {
+	case c == ColorDefault:
+		return ""
+	case c&colorRGB != 0:
+		return fmt.Sprintf("#%06x", int32(c&^colorRGB))
+	case c < 16:
+		return ansiColors[c]
+	case c < 232:
+		// 6x6x6 color cube
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		n := int(c) - 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+	default:
+		gray := 8 + (int(c)-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
+
+// Screen replays a session's buffered output through a screen model of the
+// PTY's current size, reconstructing what an attached client would show
+func (s *Service) Screen(sessionID string) (*Screen, error) {
+	return s.replay(sessionID, 0, "", "")
+}
+
+// replay builds the screen model, keeping up to scrollback lines that
+// scrolled off the top. Non-empty anchors replay only the output between
+// them.
+func (s *Service) replay(sessionID string, scrollback int, since, until string) (*Screen, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	var output []byte
+	if since != "" || until != "" {
+		r, err := s.outputRange(session, since, until)
+		if err != nil {
+			return nil, err
+		}
+		output = []byte(r.Output)
+	} else {
+		output = session.outputBuf.Read()
+	}
+
+	// The recorded size, not the PTY's, which may be closing under us
+	session.sizing.mu.Lock()
+	cols, rows := session.sizing.cols, session.sizing.rows
+	session.sizing.mu.Unlock()
+	if cols == 0 || rows == 0 {
+		size := s.initialSize(CreateOptions{})
+		cols, rows = size.Cols, size.Rows
+	}
+
+	screen := NewScreen(int(cols), int(rows))
+	screen.SetScrollback(scrollback)
+	screen.Write(output)
+	return screen, nil
+}
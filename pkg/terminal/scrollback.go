@@ -0,0 +1,288 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultScrollbackSize is the output kept in memory per session when
+	// the configuration sets no size
+	DefaultScrollbackSize = 1024 * 1024
+
+	// DefaultScrollbackChunk and MaxScrollbackChunk bound one read of a
+	// session's older output
+	DefaultScrollbackChunk = 64 * 1024
+	MaxScrollbackChunk     = 1024 * 1024
+)
+
+var ErrInvalidScrollback = errors.New("invalid scrollback size")
+
+// ScrollbackChunk is a piece of a session's output. Offsets count bytes
+// since the session started; Start is the oldest still retained, in
+// memory or spilled to disk, and End the newest.
+type ScrollbackChunk struct {
+	Offset    int64  `json:"offset"`
+	Next      int64  `json:"next"` // where the following chunk starts
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	Truncated bool   `json:"truncated,omitempty"` // the asked-for offset is no longer retained
+	Spilled   bool   `json:"spilled,omitempty"`   // some of it was read from disk
+	Output    string `json:"output"`
+}
+
+// scrollbackSize is the in-memory scrollback a session gets: its own size
+// if it asks for one, up to max_scrollback_size, or scrollback_size
+func (s *Service) scrollbackSize(opts CreateOptions) (int, error) {
+	if opts.Scrollback < 0 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrInvalidScrollback, opts.Scrollback)
+	}
+	if opts.Scrollback > 0 {
+		if limit := s.config.MaxScrollbackSize; limit > 0 && opts.Scrollback > limit {
+			return 0, fmt.Errorf("%w: %d bytes is over the limit of %d", ErrInvalidScrollback, opts.Scrollback, limit)
+		}
+		return opts.Scrollback, nil
+	}
+	if s.config.ScrollbackSize > 0 {
+		return s.config.ScrollbackSize, nil
+	}
+	return DefaultScrollbackSize, nil
+}
+
+// spillDir is where spilled scrollback is written: spill_dir, or a
+// scrollback directory beside the per-session ones
+func (s *Service) spillDir() string {
+	if s.config.SpillDir != "" {
+		return s.config.SpillDir
+	}
+	dir := s.config.WorkingDirectory
+	if dir == "" {
+		dir = "/tmp/webtunnel"
+	}
+	return filepath.Join(dir, "scrollback")
+}
+
+// spill keeps a session's whole output on disk, so what has left the
+// in-memory scrollback can still be read. It holds offsets [0, size). A
+// write that fails stops the spill; what was written stays readable. A
+// nil spill keeps nothing.
+type spill struct {
+	path   string
+	file   *os.File
+	size   int64
+	failed bool
+	logger *zap.Logger
+	mu     sync.Mutex
+}
+
+// startSpill opens the session's spill file when spilling is enabled. A
+// session that cannot spill still runs with its in-memory scrollback; the
+// failure is logged.
+func (s *Service) startSpill(session *Session) *spill {
+	if !s.config.ScrollbackSpill {
+		return nil
+	}
+
+	dir := s.spillDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		session.logger.Error("Failed to create scrollback directory", zap.Error(err))
+		return nil
+	}
+	path := filepath.Join(dir, session.ID+".out")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		session.logger.Error("Failed to create scrollback spill", zap.Error(err))
+		return nil
+	}
+	return &spill{path: path, file: file, logger: session.logger}
+}
+
+func (sp *spill) write(p []byte) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.file == nil || sp.failed {
+		return
+	}
+	n, err := sp.file.WriteAt(p, sp.size)
+	sp.size += int64(n)
+	if err != nil {
+		sp.failed = true
+		sp.logger.Error("Failed to spill scrollback; older output is no longer kept",
+			zap.Int64("spilled_bytes", sp.size),
+			zap.Error(err))
+	}
+}
+
+// spilled returns how much output the spill holds
+func (sp *spill) spilled() int64 {
+	if sp == nil {
+		return 0
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.file == nil {
+		return 0
+	}
+	return sp.size
+}
+
+// read returns the spilled output in [from, to)
+func (sp *spill) read(from, to int64) ([]byte, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.file == nil {
+		return nil, fmt.Errorf("scrollback spill is closed")
+	}
+	buf := make([]byte, to-from)
+	n, err := sp.file.ReadAt(buf, from)
+	if n == len(buf) {
+		return buf, nil
+	}
+	return buf[:n], err
+}
+
+// remove closes the spill and deletes its file
+func (sp *spill) remove() {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.file == nil {
+		return
+	}
+	sp.file.Close()
+	sp.file = nil
+	if err := os.Remove(sp.path); err != nil && !os.IsNotExist(err) {
+		sp.logger.Warn("Failed to remove scrollback spill", zap.Error(err))
+	}
+}
+
+// retained returns the in-memory scrollback with the offset it starts at,
+// and the oldest offset that can still be read, which is 0 while the spill
+// reaches back to the in-memory part. Callers hold waitMu.
+func (session *Session) retained() (buf []byte, bufStart, start int64) {
+	buf = session.outputBuf.Read()
+	bufStart = session.outputOffset - int64(len(buf))
+	start = bufStart
+	if bufStart > 0 && session.spill.spilled() >= bufStart {
+		start = 0
+	}
+	return buf, bufStart, start
+}
+
+// readOutput returns the output in [from, to), which must lie between the
+// start and end retained, reading what has left memory from the spill.
+// Callers hold waitMu.
+func (session *Session) readOutput(buf []byte, bufStart, from, to int64) ([]byte, error) {
+	if from >= bufStart {
+		return buf[from-bufStart : to-bufStart], nil
+	}
+	output, err := session.spill.read(from, min(to, bufStart))
+	if err != nil {
+		return nil, err
+	}
+	if to > bufStart {
+		output = append(output, buf[:to-bufStart]...)
+	}
+	return output, nil
+}
+
+// Scrollback returns up to limit bytes of the session's output from offset,
+// spilled output included. An offset older than what is retained starts at
+// the oldest retained instead. The chunk ends on a whole UTF-8 character
+// where it can, so chunks read one after another join up.
+func (s *Service) Scrollback(sessionID string, offset int64, limit int) (*ScrollbackChunk, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", ErrInvalidAnchor, offset)
+	}
+	if limit <= 0 {
+		limit = DefaultScrollbackChunk
+	}
+	if limit > MaxScrollbackChunk {
+		limit = MaxScrollbackChunk
+	}
+
+	session.waitMu.Lock()
+	defer session.waitMu.Unlock()
+	buf, bufStart, start := session.retained()
+	end := session.outputOffset
+
+	chunk := &ScrollbackChunk{Offset: min(offset, end), Start: start, End: end}
+	if chunk.Offset < start {
+		chunk.Offset, chunk.Truncated = start, true
+	}
+	to := min(chunk.Offset+int64(limit), end)
+	output, err := session.readOutput(buf, bufStart, chunk.Offset, to)
+	if err != nil {
+		return nil, err
+	}
+	if to < end {
+		output = wholeRunes(output)
+	}
+	chunk.Spilled = chunk.Offset < bufStart
+	chunk.Next = chunk.Offset + int64(len(output))
+	chunk.Output = string(output)
+	return chunk, nil
+}
+
+// WriteLog writes the session's output as it stands, from the oldest
+// retained, spilled output included, to w in chunks. With plain set,
+// escape sequences and control characters are stripped, leaving text fit
+// to read or attach to a bug report. Output newer than the call is left
+// out. It returns how many bytes of output it read.
+func (s *Service) WriteLog(sessionID string, w io.Writer, plain bool) (int64, error) {
+	chunk, err := s.Scrollback(sessionID, 0, MaxScrollbackChunk)
+	if err != nil {
+		return 0, err
+	}
+	end := chunk.End
+	var text plainText
+	var buf []byte
+	var read int64
+	for {
+		output := []byte(chunk.Output)
+		if plain {
+			buf = text.append(buf[:0], output)
+			output = buf
+		}
+		if _, err := w.Write(output); err != nil {
+			return read, err
+		}
+		read += chunk.Next - chunk.Offset
+		if chunk.Next >= end || chunk.Next == chunk.Offset {
+			return read, nil
+		}
+		if chunk, err = s.Scrollback(sessionID, chunk.Next, int(min(end-chunk.Next, MaxScrollbackChunk))); err != nil {
+			return read, err
+		}
+	}
+}
+
+// wholeRunes drops an incomplete UTF-8 sequence from the end of p, which
+// the next chunk then starts with
+func wholeRunes(p []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		if r := p[len(p)-i]; utf8.RuneStart(r) {
+			if !utf8.FullRune(p[len(p)-i:]) && len(p) > i {
+				return p[:len(p)-i]
+			}
+			break
+		}
+	}
+	return p
+}
@@ -0,0 +1,268 @@
+package terminal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+const (
+	// searchScrollback is how many lines above the screen a search covers;
+	// the output buffer rarely holds more
+	searchScrollback   = 10000
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	maxSearchQuery     = 1024
+
+	// maxSearchContext caps the text of the line around each output match
+	maxSearchContext = 512
+)
+
+var ErrInvalidSearch = errors.New("invalid search")
+
+// OutputMatch is a match in a session's output. Offset and End are byte
+// offsets in the output, as bookmarks and scrollback chunks use, and Line
+// counts from 1 at the start of the output searched. Context is the
+// match's line, cut down around the match when it is long.
+type OutputMatch struct {
+	Offset  int64  `json:"offset"`
+	End     int64  `json:"end"`
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Context string `json:"context"`
+}
+
+// OutputSearch lists matches in a session's output, oldest first. Since
+// and Until are the offsets searched; Trimmed is set when output before
+// the asked-for start is no longer retained, and Truncated when there
+// were more matches than the limit.
+type OutputSearch struct {
+	Query     string        `json:"query"`
+	Since     int64         `json:"since"`
+	Until     int64         `json:"until"`
+	Lines     int           `json:"lines"`
+	Matches   []OutputMatch `json:"matches"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Trimmed   bool          `json:"trimmed,omitempty"`
+}
+
+// searchPattern compiles a search's query
+func searchPattern(req protocol.Search) (*regexp.Regexp, error) {
+	if req.Query == "" || len(req.Query) > maxSearchQuery {
+		return nil, fmt.Errorf("%w: query must be 1 to %d bytes", ErrInvalidSearch, maxSearchQuery)
+	}
+	pattern := req.Query
+	if req.Literal {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if req.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+	}
+	return re, nil
+}
+
+func searchLimit(limit int) int {
+	if limit <= 0 {
+		return defaultSearchLimit
+	}
+	return min(limit, maxSearchLimit)
+}
+
+// SearchOutput searches the session's retained output line by line, with
+// escape sequences stripped, unlike Search, which searches the rendered
+// screen. Matches carry output offsets, so a client can fetch what is
+// around them through Scrollback or Transcript.
+func (s *Service) SearchOutput(sessionID string, req protocol.Search) (*OutputSearch, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	re, err := searchPattern(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := searchLimit(req.Limit)
+
+	output, err := s.outputRange(session, req.Since, req.Until)
+	if err != nil {
+		return nil, err
+	}
+
+	// offsets maps each byte of text back to its offset in the output
+	var text []byte
+	var offsets []int
+	var plain plainText
+	plain.strip([]byte(output.Output), func(i int, b byte) {
+		text = append(text, b)
+		offsets = append(offsets, i)
+	})
+
+	results := &OutputSearch{
+		Query:   req.Query,
+		Since:   output.Since,
+		Until:   output.Until,
+		Lines:   bytes.Count(text, []byte{'\n'}),
+		Matches: []OutputMatch{},
+		Trimmed: output.Truncated,
+	}
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		results.Lines++
+	}
+
+	line, lineStart, counted := 1, 0, 0
+	for _, loc := range re.FindAllIndex(text, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		if len(results.Matches) == limit {
+			results.Truncated = true
+			break
+		}
+		for ; counted < loc[0]; counted++ {
+			if text[counted] == '\n' {
+				line++
+				lineStart = counted + 1
+			}
+		}
+		lineEnd := len(text)
+		if i := bytes.IndexByte(text[loc[0]:], '\n'); i >= 0 {
+			lineEnd = loc[0] + i
+		}
+		results.Matches = append(results.Matches, OutputMatch{
+			Offset:  output.Since + int64(offsets[loc[0]]),
+			End:     output.Since + int64(offsets[loc[1]-1]) + 1,
+			Line:    line,
+			Text:    string(text[loc[0]:loc[1]]),
+			Context: searchContext(text, lineStart, lineEnd, loc[0]),
+		})
+	}
+	return results, nil
+}
+
+// searchContext returns text[start:end], the line a match at offset is
+// on, or maxSearchContext bytes of it around the match
+func searchContext(text []byte, start, end, offset int) string {
+	if end-start > maxSearchContext {
+		start = max(start, offset-maxSearchContext/4)
+		end = min(end, start+maxSearchContext)
+		for start < end && !utf8.RuneStart(text[start]) {
+			start++
+		}
+		return string(wholeRunes(text[start:end]))
+	}
+	return string(text[start:end])
+}
+
+// Search runs a search over the session's scrollback and screen, so a
+// client can highlight matches without downloading the whole buffer. With
+// Since or Until only the output between those anchors is searched.
+func (s *Service) Search(sessionID string, req protocol.Search) (*protocol.SearchResults, error) {
+	re, err := searchPattern(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := searchLimit(req.Limit)
+
+	screen, err := s.replay(sessionID, searchScrollback, req.Since, req.Until)
+	if err != nil {
+		return nil, err
+	}
+	results := screen.Search(re, limit)
+	results.ID = req.ID
+	results.Query = req.Query
+	return results, nil
+}
+
+// Search finds up to limit matches of re, oldest first. Rows joined by
+// wrapping are searched as one line, so a match may span them.
+func (s *Screen) Search(re *regexp.Regexp, limit int) *protocol.SearchResults {
+	lines := s.history()
+	results := &protocol.SearchResults{
+		Lines:      len(lines),
+		Scrollback: len(lines) - len(s.grid),
+		Matches:    []protocol.SearchMatch{},
+	}
+
+	for start := 0; start < len(lines); {
+		// Every row of a wrapped line but the last is exactly cols wide, so
+		// character offsets map straight back to rows
+		end := start
+		var text []rune
+		for {
+			text = append(text, lines[end].text...)
+			end++
+			if !lines[end-1].wrapped || end == len(lines) {
+				break
+			}
+		}
+
+		str := string(text)
+		offset, chars := 0, 0
+		for _, loc := range re.FindAllStringIndex(str, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			if len(results.Matches) == limit {
+				results.Truncated = true
+				return results
+			}
+			chars += utf8.RuneCountInString(str[offset:loc[0]])
+			offset = loc[0]
+			results.Matches = append(results.Matches, protocol.SearchMatch{
+				Line:   start + chars/s.cols,
+				Col:    chars % s.cols,
+				Length: utf8.RuneCountInString(str[loc[0]:loc[1]]),
+				Text:   str[loc[0]:loc[1]],
+			})
+		}
+		start = end
+	}
+	return results
+}
+
+func (s *Service) handleSearch(session *Session, cl *client, msg protocol.Message) {
+	var req protocol.Search
+	if err := msg.Decode(&req); err != nil {
+		cl.writeJSON(protocol.Message{
+			Type:      protocol.TypeError,
+			Data:      "Invalid search",
+			Timestamp: time.Now(),
+			SessionID: session.ID,
+		})
+		return
+	}
+
+	started := time.Now()
+	results, err := s.Search(session.ID, req)
+	if err != nil {
+		cl.writeJSON(protocol.Message{
+			Type:      protocol.TypeError,
+			Data:      fmt.Sprintf("Search failed: %v", err),
+			Timestamp: time.Now(),
+			SessionID: session.ID,
+		})
+		return
+	}
+	session.logger.Debug("Searched scrollback",
+		zap.Int("lines", results.Lines),
+		zap.Int("matches", len(results.Matches)),
+		zap.Duration("took", time.Since(started)))
+
+	out, err := protocol.NewMessage(protocol.TypeSearchResults, results)
+	if err != nil {
+		session.logger.Error("Failed to encode search results", zap.Error(err))
+		return
+	}
+	out.SessionID = session.ID
+	cl.writeJSON(out)
+}
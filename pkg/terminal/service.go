@@ -0,0 +1,1744 @@
+// Package terminal runs PTY sessions and streams them to WebSocket and
+// long-poll clients using the frames in package protocol. A Service needs
+// only a config.SessionConfig and a logger; authorization, admission,
+// metering and snippets are optional hooks set after New.
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/meter"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+type Service struct {
+	config    config.SessionConfig
+	logger    *zap.Logger
+	sessions  *sessionMap
+	snippets  SnippetResolver
+	provision Provisioner
+	authz     Authorizer
+	fanout    *fanout
+	traffic   *meter.Service
+	admit     Admission
+	guard     InputGuard
+	inputs    InputRecorder // nil unless input is audited
+	perms     Permissions
+	quotas    Quotas
+	artifacts ArtifactStore
+	banners   Banners
+	ssh       SSHConnector
+	sandbox   Sandbox
+	store     SessionStore
+	reclaimer Reclaimer // nil keeps directories for the janitor's retention
+	prewarm   *prewarmPool // nil without configured pools
+	negotiations negotiations
+	failures  *failureCounter
+
+	// stopping is set by Shutdown, so sessions it kills stay recorded as
+	// running and are restored on the next start
+	stopping atomic.Bool
+
+	// pollers are the attached long-poll clients by ID
+	pollers map[string]*pollTransport
+	pollMu  sync.Mutex
+
+	alertPatterns []*regexp.Regexp
+	handlers      []EventHandler
+	handlersMu    sync.RWMutex
+
+	// createMu guards the per-user limit check; pending counts sessions
+	// that passed the check but are still starting.
+	createMu sync.Mutex
+	pending  map[string]int
+}
+
+type Session struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Command     string     `json:"command"`
+	WorkingDir  string     `json:"working_dir"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastActive  time.Time  `json:"last_active"`
+	Snapshot    string     `json:"snapshot,omitempty"`
+	SSH         *SSHTarget `json:"ssh,omitempty"`
+	Sandbox     string     `json:"sandbox,omitempty"` // profile the process runs under
+	Template    string     `json:"template,omitempty"`
+	HostUser    string     `json:"host_user,omitempty"` // the throwaway account it runs as under ephemeral_users
+	Charset     string     `json:"charset,omitempty"`   // the legacy charset its programs speak; empty for UTF-8
+	traceID     string
+	shell       string // empty for $SHELL
+	login       bool   // start shell with -l
+	limits      Limits
+	
+	// Internal fields
+	cmd         *exec.Cmd
+	pty         *os.File
+	ctx         context.Context
+	cancel      context.CancelFunc
+	connections map[transport]*client
+	connMu      sync.RWMutex
+	inputSem    chan struct{} // held while writing input
+	outputBuf   *CircularBuffer
+	spill       *spill // output that has left outputBuf; nil unless spilling
+	hostUser    *hostUser // nil unless ephemeral_users is on
+	env         []string // added by the provisioner
+	logger      *zap.Logger
+	logs        *logRing // nil unless log capture is enabled
+	recorder    *recorder // nil unless recording is enabled
+	screen      *Screen   // rendered for attaching clients, guarded by waitMu
+	banner      *Banner   // nil without one
+	charset     *transcoder // nil for UTF-8
+
+	// Expect waiters, bookmarks, elevated mode and the running count of
+	// output bytes
+	waiters      map[*expectWaiter]struct{}
+	bookmarks    []Bookmark
+	elevated     *elevation
+	outputOffset int64
+	waitMu       sync.Mutex
+
+	// driver holds control and is the only one who may type; empty lets
+	// anyone with write access type. requests wait for control, oldest
+	// first.
+	driver    string
+	requests  []string
+	controlMu sync.Mutex
+
+	activity activity // recent output, for the session list
+	traffic  traffic  // bytes in and out of the PTY
+	titling  titling  // the title and foreground command
+	sizing   sizing   // the PTY's size, its policy and history
+
+	// presenting is set by the owner and read by every connection
+	presenting atomic.Bool
+
+	lastAlert  time.Time
+	idleWarned bool
+
+	// Status and how the session ended, written only by the state
+	// goroutine once the process has started
+	status     Status
+	exitCode   *int
+	exitReason ExitReason
+	exitSignal string
+	endedAt    time.Time
+	name       string   // guarded by stateMu since it can be renamed
+	tags       []string // sorted, guarded by stateMu like name
+	oomKills   int64 // the cgroup's OOM kill count when the process started
+	stateMu    sync.Mutex
+	events   chan stateEvent
+	done     chan struct{}
+	reaped   chan struct{} // closed once the process has been waited for
+}
+
+var (
+	ErrCommandNotAllowed = errors.New("command not allowed")
+	ErrCommandBlocked    = errors.New("command is blocked")
+	ErrSessionLimit      = errors.New("user has reached maximum session limit")
+	ErrServerAtCapacity  = errors.New("server has reached maximum session capacity")
+	ErrPolicyDenied      = errors.New("denied by policy")
+)
+
+// QuotaError is returned when a session limit refuses a session, with the
+// limit and how many sessions count against it; it wraps ErrSessionLimit
+// or ErrServerAtCapacity
+type QuotaError struct {
+	Err   error
+	Limit int
+	Used  int
+}
+
+func (e *QuotaError) Error() string { return fmt.Sprintf("%v (%d)", e.Err, e.Limit) }
+func (e *QuotaError) Unwrap() error { return e.Err }
+
+var (
+	// readBufPool holds PTY read buffers so short-lived sessions do not
+	// each allocate a fresh chunk buffer.
+	readBufPool = sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, 4096)
+			return &buf
+		},
+	}
+
+	// encodeBufPool holds buffers for encoding outgoing WebSocket frames.
+	encodeBufPool = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+)
+
+// SnippetResolver looks up stored snippets for the run-snippet message
+type SnippetResolver interface {
+	ResolveSnippet(ctx context.Context, userID, name string) (string, error)
+}
+
+// Provisioner fills a new session directory from a named snapshot and
+// returns extra environment for the session process
+type Provisioner interface {
+	Provision(ctx context.Context, userID, snapshot, dir string) ([]string, error)
+}
+
+// SSHTarget is the remote end of an SSH session and the stored key to
+// log in with
+type SSHTarget struct {
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"` // zero means 22
+	User string `json:"user"`
+	Key  string `json:"key"` // ID or name of one of the user's keys
+}
+
+// SSHConnector verifies an SSH target's host key, writes the selected key
+// into the session directory and returns the ssh command line to run
+type SSHConnector interface {
+	ConnectSSH(ctx context.Context, userID string, target SSHTarget, dir string) (string, error)
+}
+
+// Sandbox confines session processes. Profile names the user's profile,
+// empty for none, and Wrap returns the command line that runs argv under
+// it.
+type Sandbox interface {
+	Profile(userID string) (string, error)
+	Wrap(profile string, argv []string) ([]string, error)
+}
+
+// Admission can refuse new sessions outright, e.g. during maintenance
+type Admission interface {
+	Admit(userID string) error
+}
+
+// Quotas can give some users a session limit other than the configured
+// one, e.g. service accounts
+type Quotas interface {
+	SessionLimit(userID string) (limit int, ok bool)
+}
+
+// ArtifactStore keeps the files a one-shot command leaves in its directory
+// that match the globs it was given, before the directory is removed
+type ArtifactStore interface {
+	Collect(execID, userID, dir string, globs []string) ([]Artifact, error)
+}
+
+// InputGuard can cut off a client that floods a session with input
+type InputGuard interface {
+	AllowInput(userID string) bool
+}
+
+// InputRecorder keeps what each user typed into a session, for audit
+type InputRecorder interface {
+	RecordInput(sessionID, userID string, input []byte, at time.Time)
+}
+
+// Authorizer defers session and command decisions to an external policy
+type Authorizer interface {
+	Authorize(ctx context.Context, userID, action string, resource map[string]interface{}) (bool, string)
+}
+
+type Status string
+
+const (
+	StatusRunning Status = "running"
+	StatusStopped Status = "stopped"
+	StatusError   Status = "error"
+
+	// StatusInterrupted marks a recorded session that was running when the
+	// server stopped and was not relaunched
+	StatusInterrupted Status = "interrupted"
+)
+
+type CircularBuffer struct {
+	data []byte
+	size int
+	pos  int
+	full bool
+	mu   sync.RWMutex
+}
+
+// NewCircularBuffer keeps the last size bytes written; a size of zero or
+// less keeps nothing
+func NewCircularBuffer(size int) *CircularBuffer {
+	if size < 0 {
+		size = 0
+	}
+	return &CircularBuffer{
+		data: make([]byte, size),
+		size: size,
+	}
+}
+
+func (cb *CircularBuffer) Write(p []byte) (n int, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	n = len(p)
+	if cb.size == 0 {
+		return n, nil
+	}
+	// Only the tail of a write larger than the buffer survives
+	if len(p) >= cb.size {
+		copy(cb.data, p[len(p)-cb.size:])
+		cb.pos, cb.full = 0, true
+		return n, nil
+	}
+	copied := copy(cb.data[cb.pos:], p)
+	if copied < len(p) {
+		cb.pos = copy(cb.data, p[copied:])
+		cb.full = true
+	} else if cb.pos += copied; cb.pos == cb.size {
+		cb.pos, cb.full = 0, true
+	}
+	return n, nil
+}
+
+func (cb *CircularBuffer) Read() []byte {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	if !cb.full && cb.pos == 0 {
+		return nil
+	}
+
+	var result []byte
+	if cb.full {
+		result = make([]byte, cb.size)
+		copy(result, cb.data[cb.pos:])
+		copy(result[cb.size-cb.pos:], cb.data[:cb.pos])
+	} else {
+		result = make([]byte, cb.pos)
+		copy(result, cb.data[:cb.pos])
+	}
+	return result
+}
+
+func New(config config.SessionConfig, logger *zap.Logger) *Service {
+	s := &Service{
+		config:   config,
+		logger:   logger,
+		sessions: newSessionMap(),
+		pending:  make(map[string]int),
+		pollers:  make(map[string]*pollTransport),
+
+		alertPatterns: compileAlertPatterns(config.AlertPatterns, logger),
+		prewarm:       newPrewarmPool(config.Prewarm),
+		failures:      newFailureCounter(),
+	}
+
+	// Without workers, output is written directly from each PTY reader
+	if config.FanoutWorkers > 0 {
+		quantum := config.FanoutQuantumKB * 1024
+		if quantum <= 0 {
+			quantum = 32 * 1024
+		}
+		maxQueue := config.FanoutQueueKB * 1024
+		if maxQueue <= 0 {
+			maxQueue = 256 * 1024
+		}
+		s.fanout = newFanout(config.FanoutWorkers, quantum, maxQueue, s.broadcastOutput)
+	}
+
+	// Pooled processes would run as the server's user, not the session's
+	if config.EphemeralUsers {
+		if s.prewarm != nil {
+			logger.Warn("Prewarm pools are disabled with ephemeral users")
+			s.prewarm = nil
+		}
+		s.sweepHostUsers()
+	}
+
+	return s
+}
+
+// CreateOptions carries the optional parameters of a new session
+type CreateOptions struct {
+	Command    string
+	WorkingDir string
+
+	// Name labels the session for people; see Rename
+	Name string
+
+	// Tags organize sessions for filtered listing; see SetTags
+	Tags []string
+
+	// TraceID is the W3C trace the session was created in, if any; it is
+	// attached to the session's failure metrics as an exemplar
+	TraceID string
+
+	// Snapshot to unpack into the session directory before start
+	Snapshot string
+
+	// Initial terminal size; zero values fall back to the configured default
+	Cols uint16
+	Rows uint16
+
+	// SSH connects to a remote host instead of running Command locally
+	SSH *SSHTarget
+
+	// Template is the ID of the admin-defined template the options come
+	// from. An admin approved its command, so it skips the allowed and
+	// blocked command lists, though not the authorizer.
+	Template string
+
+	// Shell runs the command instead of $SHELL, and must be one of
+	// allowed_shells unless a template names it; Env adds KEY=value
+	// variables, after any from the snapshot
+	Shell string
+	Env   []string
+
+	// UserEnv are variables the user asked for, checked against
+	// allowed_env_vars and blocked_env_vars. They come after Env and the
+	// configured environment_vars, so they win over both.
+	UserEnv map[string]string
+
+	// Login starts the shell as a login shell, with -l; nil uses
+	// login_shell
+	Login *bool
+
+	// Limits caps the process's resources
+	Limits Limits
+
+	// Scrollback is the output kept in memory, in bytes; zero uses the
+	// configured size
+	Scrollback int
+
+	// ResizePolicy decides the PTY's size when several clients with
+	// different windows attach; empty uses the configured policy
+	ResizePolicy string
+
+	// Charset is the IANA name of the charset the session's programs read
+	// and write, such as ISO-8859-1 or Shift_JIS. Output is decoded to
+	// UTF-8 for clients and their input encoded back. Empty means UTF-8.
+	Charset string
+
+	// relaunch restarts a recorded session under its old ID and directory
+	relaunch *SessionRecord
+}
+
+func (s *Service) CreateSession(ctx context.Context, userID, command, workingDir string) (*Session, error) {
+	return s.CreateSessionWithOptions(ctx, userID, CreateOptions{
+		Command:    command,
+		WorkingDir: workingDir,
+	})
+}
+
+// CreateSessionWithOptions starts a session. ctx bounds the checks and
+// process start only; the session itself runs until killed or timed out.
+func (s *Service) CreateSessionWithOptions(ctx context.Context, userID string, opts CreateOptions) (*Session, error) {
+	command, workingDir := opts.Command, opts.WorkingDir
+	if opts.SSH != nil {
+		if s.ssh == nil {
+			return nil, fmt.Errorf("ssh sessions are not available")
+		}
+		// The command lists and policies see "ssh"; the full command line
+		// is built once the host key is verified
+		command = "ssh"
+	}
+
+	name, err := normalizeName(opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := normalizeTags(opts.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.Limits.Validate(); err != nil {
+		return nil, err
+	}
+	if err := validResizePolicy(opts.ResizePolicy); err != nil {
+		return nil, err
+	}
+	scrollback, err := s.scrollbackSize(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.UserEnv) > 0 {
+		userEnv, err := s.checkEnv(opts.UserEnv)
+		if err != nil {
+			return nil, err
+		}
+		opts.Env = append(append([]string{}, opts.Env...), userEnv...)
+	}
+	if opts.relaunch != nil {
+		name, tags = opts.relaunch.Name, opts.relaunch.Tags
+		opts.Template, opts.Shell, opts.Limits = opts.relaunch.Template, opts.relaunch.Shell, opts.relaunch.Limits
+		opts.Login = &opts.relaunch.Login
+		opts.Charset = opts.relaunch.Charset
+	}
+	charset, transcoder, err := lookupCharset(opts.Charset)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.admit != nil {
+		if err := s.admit.Admit(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate command against configured policies
+	if opts.Template == "" {
+		if err := s.checkCommand(command, false); err != nil {
+			return nil, err
+		}
+		// A template's shell was chosen by an admin too
+		if opts.Shell, err = s.ResolveShell(opts.Shell); err != nil {
+			return nil, err
+		}
+	}
+	resource := map[string]interface{}{
+		"command":     command,
+		"working_dir": workingDir,
+	}
+	if opts.Template != "" {
+		resource["template"] = opts.Template
+	}
+	if opts.SSH != nil {
+		resource["ssh_host"] = opts.SSH.Host
+		resource["ssh_user"] = opts.SSH.User
+	}
+	if err := s.authorize(ctx, userID, "session.create", resource); err != nil {
+		return nil, err
+	}
+	// Restored sessions were shown theirs when they were first created
+	var banner *Banner
+	if opts.relaunch == nil {
+		if banner, err = s.banner(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check session limits
+	if err := s.reserveSlot(userID); err != nil {
+		s.failures.add(FailureQuota, "", opts.TraceID)
+		return nil, err
+	}
+	defer s.releaseSlot(userID)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Setup working directory
+	if workingDir == "" {
+		workingDir = s.config.WorkingDirectory
+	}
+
+	var profile string
+	if s.sandbox != nil {
+		var err error
+		if profile, err = s.sandbox.Profile(userID); err != nil {
+			return nil, fmt.Errorf("failed to select sandbox profile: %w", err)
+		}
+	}
+
+	// A pooled process comes with its session ID and directory
+	var sessionID, sessionWorkDir string
+	var warm *warmProcess
+	if opts.relaunch == nil && profile == "" {
+		warm = s.claimWarm(command, workingDir, opts)
+	}
+	switch {
+	case warm != nil:
+		sessionID, sessionWorkDir = warm.id, warm.dir
+	case opts.relaunch != nil:
+		sessionID, sessionWorkDir = opts.relaunch.ID, opts.relaunch.WorkingDir
+		if err := os.MkdirAll(sessionWorkDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create session directory: %w", err)
+		}
+	default:
+		sessionID = generateSessionID()
+		sessionWorkDir = filepath.Join(workingDir, "sessions", sessionID)
+		if err := os.MkdirAll(sessionWorkDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create session directory: %w", err)
+		}
+	}
+
+	if opts.SSH != nil {
+		var err error
+		if command, err = s.ssh.ConnectSSH(ctx, userID, *opts.SSH, sessionWorkDir); err != nil {
+			os.RemoveAll(sessionWorkDir)
+			return nil, fmt.Errorf("failed to prepare ssh session: %w", err)
+		}
+	}
+
+	var env []string
+	if opts.relaunch != nil {
+		env = opts.relaunch.Env
+	}
+	if opts.Snapshot != "" {
+		if s.provision == nil {
+			os.RemoveAll(sessionWorkDir)
+			return nil, fmt.Errorf("snapshots are not available")
+		}
+		var err error
+		env, err = s.provision.Provision(ctx, userID, opts.Snapshot, sessionWorkDir)
+		if err != nil {
+			os.RemoveAll(sessionWorkDir)
+			return nil, fmt.Errorf("failed to provision snapshot %s: %w", opts.Snapshot, err)
+		}
+	}
+	env = append(env, opts.Env...)
+
+	// Create context for session
+	sessionCtx, cancel := context.WithCancel(context.Background())
+	if warm != nil {
+		sessionCtx, cancel = warm.ctx, warm.cancel
+	}
+	logger, logs := s.sessionLogger(sessionID, userID)
+
+	// Create session
+	session := &Session{
+		ID:          sessionID,
+		UserID:      userID,
+		Command:     command,
+		WorkingDir:  sessionWorkDir,
+		Snapshot:    opts.Snapshot,
+		SSH:         opts.SSH,
+		Sandbox:     profile,
+		Template:    opts.Template,
+		Charset:     charset,
+		charset:     transcoder,
+		traceID:     opts.TraceID,
+		shell:       opts.Shell,
+		login:       s.loginShell(opts),
+		limits:      opts.Limits,
+		CreatedAt:   time.Now(),
+		LastActive:  time.Now(),
+		ctx:         sessionCtx,
+		cancel:      cancel,
+		connections: make(map[transport]*client),
+		outputBuf:   NewCircularBuffer(scrollback),
+		waiters:     make(map[*expectWaiter]struct{}),
+		inputSem:    make(chan struct{}, 1),
+		env:         env,
+		logger:      logger,
+		logs:        logs,
+		status:      StatusRunning,
+		name:        name,
+		tags:        tags,
+		banner:      banner,
+		events:      make(chan stateEvent, 4),
+		done:        make(chan struct{}),
+		reaped:      make(chan struct{}),
+	}
+
+	// Start the process, recording from its first byte of output
+	size := s.initialSize(opts)
+	session.sizing.policy = opts.ResizePolicy
+	session.sizing.record(SizeChange{
+		At:     session.CreatedAt,
+		Cols:   size.Cols,
+		Rows:   size.Rows,
+		Policy: s.sizePolicy(session),
+		Reason: SizeStarted,
+		UserID: userID,
+	})
+	session.recorder = s.startRecording(session, size)
+	session.spill = s.startSpill(session)
+	session.screen = NewScreen(int(size.Cols), int(size.Rows))
+	session.screen.SetScrollback(snapshotScrollback)
+	if banner != nil && banner.Print {
+		s.printBanner(session)
+	}
+	if warm != nil {
+		s.adoptWarm(session, warm, size)
+	} else if err := s.startProcess(session, size); err != nil {
+		s.failures.add(FailurePTYStart, sessionID, opts.TraceID)
+		cancel()
+		session.recorder.close()
+		session.spill.remove()
+		session.hostUser.remove()
+		return nil, fmt.Errorf("failed to start process: %w", err)
+	}
+
+	s.sessions.set(session)
+
+	// The caller gave up while the process started; nobody will attach
+	if err := ctx.Err(); err != nil {
+		s.KillSession(sessionID)
+		return nil, err
+	}
+
+	s.persist(session)
+
+	session.logger.Info("Created new terminal session",
+		zap.String("command", command),
+	)
+
+	return session, nil
+}
+
+// checkCommand applies the allowed and blocked command lists to every
+// program a command line runs; see commandPrograms. An allowed or elevated
+// entry matches the whole line, or one program when every program the line
+// runs is listed, and a blocked entry the whole line or any program in it.
+// In elevated mode the elevated commands pass both lists.
+func (s *Service) checkCommand(command string, elevated bool) error {
+	programs, complete := commandPrograms(command)
+	if elevated && allowedBy(s.config.ElevatedCommands, command, programs, complete) {
+		return nil
+	}
+
+	if len(s.config.AllowedCommands) > 0 && !allowedBy(s.config.AllowedCommands, command, programs, complete) {
+		return fmt.Errorf("%w: %s", ErrCommandNotAllowed, command)
+	}
+
+	// Check blocked commands
+	for _, blockedCmd := range s.config.BlockedCommands {
+		if command == blockedCmd || slices.Contains(programs, blockedCmd) {
+			return fmt.Errorf("%w: %s", ErrCommandBlocked, command)
+		}
+	}
+	return nil
+}
+
+// SetAuthorizer routes session creation and snippet commands through an
+// external policy in addition to the configured command lists
+func (s *Service) SetAuthorizer(authz Authorizer) {
+	s.authz = authz
+}
+
+func (s *Service) authorize(ctx context.Context, userID, action string, resource map[string]interface{}) error {
+	if s.authz == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if allowed, reason := s.authz.Authorize(ctx, userID, action, resource); !allowed {
+		if reason == "" {
+			reason = action
+		}
+		return fmt.Errorf("%w: %s", ErrPolicyDenied, reason)
+	}
+	return nil
+}
+
+// SetAdmission gates session creation before any other check
+func (s *Service) SetAdmission(admit Admission) {
+	s.admit = admit
+}
+
+// SetQuotas overrides session.max_sessions for the users quotas has a
+// limit for
+func (s *Service) SetQuotas(quotas Quotas) {
+	s.quotas = quotas
+}
+
+// SetArtifactStore lets one-shot commands declare artifacts
+func (s *Service) SetArtifactStore(store ArtifactStore) {
+	s.artifacts = store
+}
+
+// SetInputRecorder records all input written to sessions and who sent it
+func (s *Service) SetInputRecorder(recorder InputRecorder) {
+	s.inputs = recorder
+}
+
+// SetInputGuard disconnects clients whose input the guard refuses
+func (s *Service) SetInputGuard(guard InputGuard) {
+	s.guard = guard
+}
+
+// SetMeter counts WebSocket traffic and applies per-role bandwidth caps to
+// connections attached from then on.
+func (s *Service) SetMeter(traffic *meter.Service) {
+	s.traffic = traffic
+}
+
+// SetProvisioner enables creating sessions from snapshots
+func (s *Service) SetProvisioner(provisioner Provisioner) {
+	s.provision = provisioner
+}
+
+// SetSandbox runs each user's session processes under their sandbox
+// profile. Confined users never get pre-warmed processes, which start
+// unconfined.
+func (s *Service) SetSandbox(sandbox Sandbox) {
+	s.sandbox = sandbox
+}
+
+// SetSSHConnector enables sessions that connect to remote hosts over SSH
+func (s *Service) SetSSHConnector(connector SSHConnector) {
+	s.ssh = connector
+}
+
+// SetSnippetResolver enables the run-snippet WebSocket message
+func (s *Service) SetSnippetResolver(resolver SnippetResolver) {
+	s.snippets = resolver
+}
+
+// RunSnippet types a stored snippet into the session. Every non-empty line
+// must pass the session command policy before anything is sent.
+func (s *Service) RunSnippet(ctx context.Context, sessionID, name string) error {
+	return s.runSnippet(ctx, sessionID, "", name)
+}
+
+// runSnippet types the snippet on behalf of userID; empty means the owner
+func (s *Service) runSnippet(ctx context.Context, sessionID, userID, name string) error {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+	if s.snippets == nil {
+		return fmt.Errorf("snippets are not available")
+	}
+
+	content, err := s.snippets.ResolveSnippet(ctx, session.UserID, name)
+	if err != nil {
+		return err
+	}
+
+	_, elevated := session.ElevatedUntil()
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.checkCommand(line, elevated); err != nil {
+			return err
+		}
+		if err := s.authorize(ctx, session.UserID, "command.run", map[string]interface{}{
+			"command":    line,
+			"session_id": session.ID,
+			"snippet":    name,
+			"elevated":   elevated,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return s.SendInputAs(ctx, sessionID, userID, []byte(content))
+}
+
+// reserveSlot counts the user's running and starting sessions against the
+// configured limit and, if there is room, holds a slot until releaseSlot.
+func (s *Service) reserveSlot(userID string) error {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+
+	userSessions := s.pending[userID]
+	totalSessions := 0
+	for _, n := range s.pending {
+		totalSessions += n
+	}
+	for _, sess := range s.sessions.snapshot() {
+		if sess.Status() != StatusRunning {
+			continue
+		}
+		totalSessions++
+		if sess.UserID == userID {
+			userSessions++
+		}
+	}
+
+	if s.config.MaxTotalSessions > 0 && totalSessions >= s.config.MaxTotalSessions {
+		return &QuotaError{Err: ErrServerAtCapacity, Limit: s.config.MaxTotalSessions, Used: totalSessions}
+	}
+	limit := s.config.MaxSessions
+	if s.quotas != nil {
+		if n, ok := s.quotas.SessionLimit(userID); ok {
+			limit = n
+		}
+	}
+	if userSessions >= limit {
+		return &QuotaError{Err: ErrSessionLimit, Limit: limit, Used: userSessions}
+	}
+
+	s.pending[userID]++
+	return nil
+}
+
+func (s *Service) releaseSlot(userID string) {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+
+	if s.pending[userID]--; s.pending[userID] <= 0 {
+		delete(s.pending, userID)
+	}
+}
+
+func (s *Service) GetSession(sessionID string) (*Session, bool) {
+	return s.sessions.get(sessionID)
+}
+
+// SessionExists reports whether a session is live, for cleanup of leftover
+// session directories
+func (s *Service) SessionExists(sessionID string) bool {
+	// Pooled processes have their directories already
+	_, exists := s.sessions.get(sessionID)
+	return exists || s.prewarm.holds(sessionID)
+}
+
+func (s *Service) ListSessions(userID string) []*Session {
+	var userSessions []*Session
+	for _, session := range s.sessions.snapshot() {
+		if session.UserID == userID {
+			userSessions = append(userSessions, session)
+		}
+	}
+	return userSessions
+}
+
+// ListAllSessions returns every session regardless of owner
+func (s *Service) ListAllSessions() []*Session {
+	return s.sessions.snapshot()
+}
+
+func (s *Service) KillSession(sessionID string) error {
+	return s.EndSession(sessionID, ExitKilled)
+}
+
+// SendInput writes to the session's PTY. A program that stops reading its
+// input fills the PTY buffer and blocks the write; SendInput then gives up
+// once ctx is done, though the input is still delivered if the program
+// resumes reading.
+func (s *Service) SendInput(ctx context.Context, sessionID string, input []byte) error {
+	return s.SendInputAs(ctx, sessionID, "", input)
+}
+
+// SendInputAs writes input to the session's PTY on behalf of userID, who
+// the input audit records as having typed it; empty means the owner
+func (s *Service) SendInputAs(ctx context.Context, sessionID, userID string, input []byte) error {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+	if userID == "" {
+		userID = session.UserID
+	}
+
+	if session.Status() != StatusRunning {
+		return fmt.Errorf("session is not running")
+	}
+
+	if session.pty == nil {
+		return fmt.Errorf("session PTY not available")
+	}
+
+	session.LastActive = time.Now()
+	session.activity.input(session.LastActive)
+
+	// One write at a time, so inputs are not interleaved and a stuck write
+	// holds back later ones instead of piling up goroutines
+	select {
+	case session.inputSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// The encoder is only used while holding inputSem
+	raw := input
+	if session.charset != nil {
+		raw = session.charset.encode(input)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		n, err := session.pty.Write(raw)
+		s.countInput(session, n)
+		if err == nil && s.inputs != nil {
+			s.inputs.RecordInput(session.ID, userID, input, time.Now())
+		}
+		<-session.inputSem
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AttachWebSocket attaches a connection on behalf of the session owner
+func (s *Service) AttachWebSocket(sessionID string, conn *websocket.Conn) error {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+	return s.AttachWebSocketAs(sessionID, session.UserID, conn)
+}
+
+// AttachWebSocketAs attaches a connection for the given user, who may be
+// the owner or a viewer.
+func (s *Service) AttachWebSocketAs(sessionID, userID string, conn *websocket.Conn) error {
+	return s.AttachWebSocketSince(sessionID, userID, "", conn)
+}
+
+// AttachWebSocketSince attaches a connection that replays output from the
+// since anchor, a bookmark or byte offset, rather than the whole buffer
+func (s *Service) AttachWebSocketSince(sessionID, userID, since string, conn *websocket.Conn) error {
+	return s.AttachWebSocketWith(sessionID, userID, AttachOptions{Since: since}, conn)
+}
+
+// AttachWebSocketWith attaches a connection with the replay anchor and
+// output framing the client asked for
+func (s *Service) AttachWebSocketWith(sessionID, userID string, opts AttachOptions, conn *websocket.Conn) error {
+	// Set connection limits
+	conn.SetReadLimit(maxClientMessage)
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	_, err := s.attach(sessionID, userID, opts, conn, meter.KindWebSocket)
+	if err != nil {
+		rejectWebSocket(conn, sessionID, err)
+	}
+	return err
+}
+
+// AttachWebSocketShared attaches a guest holding a share link the owner
+// issued, with the access the link grants rather than any of its own.
+// guestID only names it in logs, metering and Disconnect.
+func (s *Service) AttachWebSocketShared(sessionID, guestID string, access Access, opts AttachOptions, conn *websocket.Conn) error {
+	opts.shared = access
+	return s.AttachWebSocketWith(sessionID, guestID, opts, conn)
+}
+
+// attach registers a client on any transport, replays the session so far
+// (or from the since anchor) and starts reading its messages
+func (s *Service) attach(sessionID, userID string, opts AttachOptions, conn transport, kind string) (*client, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+
+	if session.Status() != StatusRunning {
+		return nil, fmt.Errorf("%w: %s", ErrSessionExited, sessionID)
+	}
+
+	// Decided once per connection; read-only clients never get to type
+	access := s.Access(session, userID)
+	if opts.shared != AccessNone {
+		access = opts.shared
+	}
+	if opts.ReadOnly && access > AccessRead {
+		access = AccessRead
+	}
+	if access < AccessRead {
+		return nil, fmt.Errorf("%w: %s", ErrForbidden, sessionID)
+	}
+
+	backlog := s.snapshot(session)
+	if opts.Since != "" {
+		r, err := s.outputRange(session, opts.Since, "")
+		if err != nil {
+			return nil, err
+		}
+		backlog = []byte(r.Output)
+	}
+
+	cl := newClient(conn, userID)
+	cl.access = access
+	if opts.MaxFrame > 0 || opts.FlushInterval > 0 {
+		cl.viewport = newViewport(cl, sessionID, opts, func(err error) {
+			s.failures.add(FailureWSWrite, session.ID, session.traceID)
+			session.logger.Error("Failed to send output to client", zap.Error(err))
+			conn.Close()
+		})
+	}
+	if s.traffic != nil {
+		cl.traffic = s.traffic.Connection(userID, sessionID, kind)
+	}
+	session.connMu.Lock()
+	session.connections[conn] = cl
+	session.connMu.Unlock()
+
+	session.logger.Info("Client attached to session", 
+		zap.String("transport", kind),
+		zap.String("client_user_id", userID),
+		zap.Bool("read_only", access < AccessWrite),
+		zap.Int("total_connections", len(session.connections)))
+
+	// Send welcome message
+	welcomeMsg := protocol.Message{
+		Type:      protocol.TypeOutput,
+		Data:      fmt.Sprintf("\r\n🌐 WebTunnel connected to session %s\r\n", sessionID),
+		Timestamp: time.Now(),
+		SessionID: sessionID,
+	}
+	if err := cl.writeJSON(welcomeMsg); err != nil {
+		s.failures.add(FailureWSWrite, session.ID, session.traceID)
+		session.logger.Error("Failed to send welcome message", zap.Error(err))
+	}
+	if session.banner != nil && session.banner.Notice {
+		if err := s.sendBanner(session, cl); err != nil {
+			s.failures.add(FailureWSWrite, session.ID, session.traceID)
+			session.logger.Error("Failed to send banner", zap.Error(err))
+		}
+	}
+
+	// Send existing output buffer, in frames the client can take
+	if len(backlog) > 0 && cl.viewport != nil {
+		if err := cl.viewport.send(backlog); err != nil {
+			s.failures.add(FailureWSWrite, session.ID, session.traceID)
+			session.logger.Error("Failed to send buffer to client", zap.Error(err))
+		}
+	} else if len(backlog) > 0 {
+		msg := protocol.Message{
+			Type:      protocol.TypeOutput, 
+			Data:      string(backlog),
+			Timestamp: time.Now(),
+			SessionID: sessionID,
+		}
+		if err := cl.writeJSON(msg); err != nil {
+			s.failures.add(FailureWSWrite, session.ID, session.traceID)
+			session.logger.Error("Failed to send buffer to client", zap.Error(err))
+		}
+	}
+
+	// Everyone learns who joined; the new client also learns who is here,
+	// the size to render at and the title
+	s.announce(session, protocol.PresenceJoined, userID)
+	if err := s.sendSize(session, cl); err != nil {
+		s.failures.add(FailureWSWrite, session.ID, session.traceID)
+		session.logger.Error("Failed to send terminal size", zap.Error(err))
+	}
+	if err := s.sendTitle(session, cl); err != nil {
+		s.failures.add(FailureWSWrite, session.ID, session.traceID)
+		session.logger.Error("Failed to send terminal title", zap.Error(err))
+	}
+
+	// Handle client messages in goroutine
+	go s.handleMessages(session, cl)
+	if interval := s.probeInterval(); interval > 0 {
+		go s.probeLoop(session, cl, interval)
+	}
+
+	return cl, nil
+}
+
+func (s *Service) handleMessages(session *Session, cl *client) {
+	conn := cl.conn
+	defer func() {
+		session.connMu.Lock()
+		delete(session.connections, conn)
+		remaining := len(session.connections)
+		session.connMu.Unlock()
+		session.activity.left(time.Now())
+		cl.close()
+		s.left(session, cl.userID)
+		session.logger.Info("Client disconnected from session", 
+			zap.Int("remaining_connections", remaining))
+	}()
+
+	for {
+		var msg protocol.Message
+		if err := cl.readJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				session.logger.Error("WebSocket unexpected close", zap.Error(err))
+			} else {
+				session.logger.Debug("WebSocket connection closed", zap.Error(err))
+			}
+			break
+		}
+
+		// Reset read deadline on successful message
+		if ws, ok := conn.(*websocket.Conn); ok {
+			ws.SetReadDeadline(time.Now().Add(60 * time.Second))
+		}
+
+		// Handle different message types
+		switch msg.Type {
+		case protocol.TypeInput:
+			if !s.requireWrite(session, cl, "input") {
+				continue
+			}
+			if !s.requireControl(session, cl, "input") {
+				continue
+			}
+			if session.Presenting() && !cl.isOwner(session) {
+				cl.writeJSON(protocol.Message{
+					Type:      protocol.TypeError,
+					Data:      "Session is in presentation mode; input is disabled for viewers",
+					Timestamp: time.Now(),
+					SessionID: session.ID,
+				})
+				continue
+			}
+			if s.guard != nil && !s.guard.AllowInput(cl.userID) {
+				session.logger.Warn("Disconnecting client flooding input", zap.String("client_user_id", cl.userID))
+				cl.writeJSON(protocol.Message{
+					Type:      protocol.TypeError,
+					Data:      "Too much input; you are temporarily banned",
+					Timestamp: time.Now(),
+					SessionID: session.ID,
+				})
+				return
+			}
+			cl.latency.input(time.Now())
+			if err := s.SendInputAs(session.ctx, session.ID, cl.userID, []byte(msg.Data)); err != nil {
+				session.logger.Error("Failed to send input to session", zap.Error(err))
+				
+				// Send error back to client
+				errorMsg := protocol.Message{
+					Type:      protocol.TypeError,
+					Data:      fmt.Sprintf("Failed to send input: %v", err),
+					Timestamp: time.Now(),
+					SessionID: session.ID,
+				}
+				cl.writeJSON(errorMsg)
+			}
+
+		case protocol.TypeResize:
+			if !s.requireWrite(session, cl, "resize") {
+				continue
+			}
+			// Record the client's window; the resize policy decides what
+			// the shared PTY's size becomes
+			var resizeData protocol.Resize
+			if err := msg.Decode(&resizeData); err == nil {
+				if resizeData.Cols <= 0 || resizeData.Rows <= 0 || resizeData.Cols > 0xffff || resizeData.Rows > 0xffff {
+					session.logger.Warn("Ignoring invalid resize",
+						zap.Int("cols", resizeData.Cols),
+						zap.Int("rows", resizeData.Rows))
+				} else {
+					s.reportSize(session, cl, uint16(resizeData.Cols), uint16(resizeData.Rows))
+				}
+			}
+
+		case protocol.TypeRunSnippet:
+			if !s.requireWrite(session, cl, "snippets") {
+				continue
+			}
+			if !s.requireControl(session, cl, "snippets") {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(session.ctx, 5*time.Second)
+			err := s.runSnippet(ctx, session.ID, cl.userID, msg.Data)
+			cancel()
+			if err != nil {
+				session.logger.Warn("Failed to run snippet",
+					zap.Error(err),
+					zap.String("snippet", msg.Data))
+
+				errorMsg := protocol.Message{
+					Type:      protocol.TypeError,
+					Data:      fmt.Sprintf("Failed to run snippet: %v", err),
+					Timestamp: time.Now(),
+					SessionID: session.ID,
+				}
+				cl.writeJSON(errorMsg)
+			}
+
+		case protocol.TypeHighlight, protocol.TypeClearHighlight:
+			s.handleHighlight(session, cl, msg)
+
+		case protocol.TypeSearch:
+			s.handleSearch(session, cl, msg)
+
+		case protocol.TypeMark:
+			s.handleMark(session, cl, msg)
+
+		case protocol.TypeProbe:
+			s.handleProbe(session, cl, msg.Data)
+
+		case protocol.TypePing:
+			// Respond to ping with pong
+			pongMsg := protocol.Message{
+				Type:      protocol.TypePong,
+				Timestamp: time.Now(),
+				SessionID: session.ID,
+			}
+			if err := cl.writeJSON(pongMsg); err != nil {
+				session.logger.Error("Failed to send pong", zap.Error(err))
+			}
+
+		default:
+			session.logger.Warn("Unknown message type", zap.String("type", msg.Type))
+		}
+	}
+}
+
+func (s *Service) CleanupStaleSessions() {
+	timeout := s.idleTimeout()
+	warnAt := timeout - s.idleWarning()
+	now := time.Now()
+
+	for _, session := range s.sessions.snapshot() {
+		idle := now.Sub(s.idleSince(session, now))
+		if idle <= warnAt {
+			session.idleWarned = false
+		} else if idle <= timeout && !session.idleWarned {
+			session.idleWarned = true
+			s.warnIdle(session, idle, timeout-idle)
+		}
+
+		if idle > timeout {
+			if _, exists := s.sessions.remove(session.ID); !exists {
+				continue
+			}
+			session.logger.Info("Cleaning up stale session")
+			
+			session.sendKilled(ExitTimeout)
+			go s.terminate(session)
+		}
+	}
+}
+
+// idleTimeout is how long a session may go without activity before
+// CleanupStaleSessions removes it.
+func (s *Service) idleTimeout() time.Duration {
+	timeout, err := time.ParseDuration(s.config.SessionTimeout)
+	if err != nil || timeout <= 0 {
+		return 1 * time.Hour // default
+	}
+	return timeout
+}
+
+// idleWarning is how long before the idle timeout EventIdleWarning fires.
+func (s *Service) idleWarning() time.Duration {
+	warning, err := time.ParseDuration(s.config.IdleWarning)
+	if err != nil || warning <= 0 {
+		return 10 * time.Minute // default
+	}
+	return warning
+}
+
+// FanoutStats reports per-user output bandwidth, or nil when output is not
+// routed through the fair scheduler.
+func (s *Service) FanoutStats() []FanoutUserStats {
+	if s.fanout == nil {
+		return nil
+	}
+	return s.fanout.stats()
+}
+
+// Notice sends a server message to every attached client of every session
+func (s *Service) Notice(text string) {
+	msg := protocol.Message{
+		Type:      protocol.TypeNotice,
+		Data:      text,
+		Timestamp: time.Now(),
+	}
+	for _, session := range s.sessions.snapshot() {
+		msg.SessionID = session.ID
+		s.broadcast(session, msg, nil)
+	}
+}
+
+// SessionStats counts live sessions for diagnostics
+type SessionStats struct {
+	Total       int            `json:"total"`
+	ByStatus    map[Status]int `json:"by_status"`
+	Users       int            `json:"users"`
+	Connections int            `json:"connections"`
+	Presenting  int            `json:"presenting"`
+	Pending     int            `json:"pending"`
+}
+
+func (s *Service) Stats() SessionStats {
+	stats := SessionStats{ByStatus: make(map[Status]int)}
+	users := make(map[string]bool)
+
+	for _, session := range s.sessions.snapshot() {
+		stats.Total++
+		stats.ByStatus[session.Status()]++
+		users[session.UserID] = true
+		if session.Presenting() {
+			stats.Presenting++
+		}
+
+		session.connMu.RLock()
+		stats.Connections += len(session.connections)
+		session.connMu.RUnlock()
+	}
+	stats.Users = len(users)
+
+	s.createMu.Lock()
+	for _, n := range s.pending {
+		stats.Pending += n
+	}
+	s.createMu.Unlock()
+
+	return stats
+}
+
+func (s *Service) Shutdown() {
+	s.stopping.Store(true)
+	if s.fanout != nil {
+		s.fanout.stop()
+	}
+	if s.prewarm != nil {
+		s.prewarm.drain()
+	}
+
+	sessions := s.sessions.removeAll()
+	for _, session := range sessions {
+		session.sendKilled(ExitShutdown)
+		session.logger.Info("Shutdown session")
+	}
+	s.terminateAll(sessions)
+}
+
+// initialSize picks the PTY size a session starts with, so the shell sees
+// the client's dimensions before it prints its first prompt.
+func (s *Service) initialSize(opts CreateOptions) *pty.Winsize {
+	size := &pty.Winsize{
+		Cols: uint16(s.config.DefaultCols),
+		Rows: uint16(s.config.DefaultRows),
+	}
+	if size.Cols == 0 {
+		size.Cols = 80
+	}
+	if size.Rows == 0 {
+		size.Rows = 24
+	}
+	if opts.Cols > 0 {
+		size.Cols = opts.Cols
+	}
+	if opts.Rows > 0 {
+		size.Rows = opts.Rows
+	}
+	return size
+}
+
+// Resize changes the PTY window size; the kernel delivers SIGWINCH to the
+// foreground process.
+func (s *Service) Resize(sessionID string, cols, rows uint16) error {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+	if cols == 0 || rows == 0 {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+	if session.pty == nil {
+		return fmt.Errorf("session PTY not available")
+	}
+
+	if err := pty.Setsize(session.pty, &pty.Winsize{
+		Rows: rows,
+		Cols: cols,
+	}); err != nil {
+		return err
+	}
+	session.recorder.resize(cols, rows)
+	session.waitMu.Lock()
+	if session.screen != nil {
+		session.screen.Resize(int(cols), int(rows))
+	}
+	session.waitMu.Unlock()
+	return nil
+}
+
+func (s *Service) startProcess(session *Session, size *pty.Winsize) error {
+	account, err := s.newHostUser(session)
+	if err != nil {
+		return err
+	}
+	if account != nil {
+		session.hostUser, session.HostUser = account, account.name
+	}
+	proc, err := s.spawn(session.ctx, session.ID, session.UserID, session.Command, session.WorkingDir, session.Sandbox, session.shell, session.login, session.env, size, account)
+	if err != nil {
+		return err
+	}
+	if err := applyLimits(proc.cmd.Process.Pid, session.limits); err != nil {
+		proc.cmd.Process.Kill()
+		proc.pty.Close()
+		return err
+	}
+	session.cmd, session.pty = proc.cmd, proc.pty
+	session.oomKills, _ = oomKillCount()
+
+	session.logger.Info("Started PTY session", 
+		zap.String("command", session.Command),
+		zap.String("shell", proc.shell),
+		zap.Bool("login_shell", session.login),
+		zap.Uint16("cols", size.Cols),
+		zap.Uint16("rows", size.Rows),
+		zap.Int("pid", session.cmd.Process.Pid))
+
+	s.watchProcess(session, proc.exited)
+	return nil
+}
+
+// process is a command running on a PTY
+type process struct {
+	cmd    *exec.Cmd
+	pty    *os.File
+	shell  string
+	exited chan error // receives the exit status once
+}
+
+// spawn starts command in dir on a new PTY, under the sandbox profile if
+// one is named and as the account if one is given. An empty shell uses
+// $SHELL, or bash; login starts it as a login shell. userID is empty for
+// processes started ahead of time for the pre-warmed pool.
+func (s *Service) spawn(ctx context.Context, sessionID, userID, command, dir, profile, shell string, login bool, extraEnv []string, size *pty.Winsize, account *hostUser) (*process, error) {
+	cmd, shell, err := s.command(ctx, sessionID, userID, command, dir, profile, shell, login, extraEnv)
+	if err != nil {
+		return nil, err
+	}
+	account.apply(cmd)
+
+	// Start the command with PTY, sized before the process runs
+	ptmx, err := pty.StartWithSize(cmd, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start PTY: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	return &process{cmd: cmd, pty: ptmx, shell: shell, exited: exited}, nil
+}
+
+// command builds the command line and environment spawn and Exec start
+// command with, and returns the shell it runs in
+func (s *Service) command(ctx context.Context, sessionID, userID, command, dir, profile, shell string, login bool, extraEnv []string) (*exec.Cmd, string, error) {
+	// Determine the shell and command to run
+	if shell == "" {
+		shell = defaultShell()
+	}
+	var args []string
+	if login {
+		// bash, zsh, fish and sh all take -l, before -c
+		args = append(args, "-l")
+	}
+
+	var cmd *exec.Cmd
+	if isShell(command) {
+		// Start interactive shell
+		cmd = exec.CommandContext(ctx, shell, args...)
+	} else {
+		// Run specific command in shell
+		cmd = exec.CommandContext(ctx, shell, append(args, "-c", command)...)
+	}
+	if profile != "" {
+		argv, err := s.sandbox.Wrap(profile, append([]string{cmd.Path}, cmd.Args[1:]...))
+		if err != nil {
+			return nil, "", err
+		}
+		cmd = exec.CommandContext(ctx, argv[0], argv[1:]...)
+	}
+
+	cmd.Dir = dir
+
+	// Set environment variables
+	env := os.Environ()
+	for key, value := range s.config.EnvironmentVars {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	env = withLocale(env, s.config.Locale)
+	// Add session-specific environment
+	env = append(env, fmt.Sprintf("WEBTUNNEL_SESSION_ID=%s", sessionID))
+	if userID != "" {
+		env = append(env, fmt.Sprintf("WEBTUNNEL_USER_ID=%s", userID))
+	}
+	env = append(env, extraEnv...)
+	cmd.Env = env
+	return cmd, shell, nil
+}
+
+// isShell reports whether command asks for an interactive shell
+func isShell(command string) bool {
+	return command == "bash" || command == "sh" || command == ""
+}
+
+// watchProcess streams the session's output and starts its state
+// goroutine, which stops the session once the process exits
+func (s *Service) watchProcess(session *Session, exited <-chan error) {
+	go s.runState(session)
+
+	// Start output monitoring in goroutine
+	go s.monitorOutput(session)
+
+	// Monitor process completion
+	go func() {
+		err := <-exited
+		close(session.reaped)
+		if err != nil {
+			session.logger.Info("Session process exited", zap.Error(err))
+		} else {
+			session.logger.Info("Session process completed normally")
+		}
+		session.sendState(stateExited, err)
+	}()
+}
+
+func (s *Service) monitorOutput(session *Session) {
+	var readErr error
+	defer func() {
+		if session.pty != nil {
+			session.pty.Close()
+		}
+		session.recorder.close()
+		session.logger.Info("Session output monitoring stopped")
+		session.sendState(stateOutputClosed, readErr)
+	}()
+
+	// Use a pooled buffer to read PTY output in chunks
+	bufPtr := readBufPool.Get().(*[]byte)
+	defer readBufPool.Put(bufPtr)
+	buffer := *bufPtr
+	
+	for {
+		select {
+		case <-session.ctx.Done():
+			return
+		default:
+			// Set read timeout to avoid blocking indefinitely
+			session.pty.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			
+			n, err := session.pty.Read(buffer)
+			if err != nil {
+				if os.IsTimeout(err) {
+					continue // Timeout is expected, continue reading
+				}
+				// Linux reports EIO once the last process holding the
+				// terminal has closed it
+				if err == io.EOF || errors.Is(err, syscall.EIO) {
+					session.logger.Info("PTY EOF reached")
+					return
+				}
+				readErr = err
+				return
+			}
+			
+			if n > 0 {
+				output := buffer[:n]
+				if session.charset != nil {
+					// Everything past here sees UTF-8; traffic counts
+					// what the PTY sent
+					output = session.charset.decode(output)
+				}
+				
+				// Buffer the output and advance the offset
+				s.feedWaiters(session, output)
+				session.recorder.output(output)
+				s.observeEcho(session, time.Now())
+				s.checkAlerts(session, output)
+				s.trackTitle(session, output)
+				
+				// Send to all connected WebSockets
+				if s.fanout != nil {
+					s.fanout.enqueue(session, output)
+				} else {
+					s.broadcastOutput(session, output)
+				}
+				
+				// Update last active time
+				session.LastActive = time.Now()
+				session.activity.output(n, session.LastActive)
+				s.countOutput(session, n)
+			}
+		}
+	}
+}
+
+func (s *Service) broadcastOutput(session *Session, output []byte) {
+	s.broadcast(session, protocol.Message{
+		Type:      protocol.TypeOutput,
+		Data:      string(output),
+		Timestamp: time.Now(),
+		SessionID: session.ID,
+	}, nil)
+}
+
+// broadcast encodes the message once and writes the same frame to every
+// attached connection except skip. Output for clients with a viewport is
+// reframed for them instead, and their pending output is flushed ahead of
+// any other message.
+func (s *Service) broadcast(session *Session, msg protocol.Message, skip *client) {
+	buf := encodeBufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer encodeBufPool.Put(buf)
+
+	if err := json.NewEncoder(buf).Encode(msg); err != nil {
+		s.logger.Error("Failed to encode message", zap.Error(err), zap.String("type", msg.Type))
+		return
+	}
+
+	var failed []transport
+	session.connMu.RLock()
+	for conn, cl := range session.connections {
+		if cl == skip {
+			continue
+		}
+		var err error
+		switch {
+		case cl.viewport != nil && msg.Type == protocol.TypeOutput:
+			err = cl.viewport.add([]byte(msg.Data))
+		case cl.viewport != nil:
+			if err = cl.viewport.flush(); err == nil {
+				err = cl.writeMessage(websocket.TextMessage, buf.Bytes())
+			}
+		default:
+			err = cl.writeMessage(websocket.TextMessage, buf.Bytes())
+		}
+		if err != nil {
+			s.failures.add(FailureWSWrite, session.ID, session.traceID)
+			session.logger.Error("Failed to send output to WebSocket", zap.Error(err))
+			failed = append(failed, conn)
+		}
+	}
+	session.connMu.RUnlock()
+
+	// Remove failed connections
+	if len(failed) > 0 {
+		session.connMu.Lock()
+		for _, conn := range failed {
+			delete(session.connections, conn)
+			conn.Close()
+		}
+		session.connMu.Unlock()
+	}
+}
+
+// withLocale adds LANG when neither the host nor the configured environment
+// chose a locale, so shells default to UTF-8 output.
+func withLocale(env []string, locale string) []string {
+	if locale == "" {
+		return env
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "LANG=") || strings.HasPrefix(kv, "LC_ALL=") {
+			return env
+		}
+	}
+	return append(env, "LANG="+locale)
+}
+
+func generateSessionID() string {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Sprintf("sess_%d_%d", time.Now().Unix(), time.Now().UnixNano()%1000000)
+	}
+	return fmt.Sprintf("sess_%d_%s", time.Now().Unix(), hex.EncodeToString(suffix))
+}
\ No newline at end of file
@@ -0,0 +1,197 @@
+package terminal
+
+// Reference numbers, single vCPU, go test -bench . -count 2:
+//
+//	                                  before (global map)    after (sharded map, pooled frames)
+//	BenchmarkCreateSessionParallel    917291-1007776 ns/op   741965-816582 ns/op
+//	BenchmarkGetSessionParallel       28.9-30.1 ns/op        53.1-54.0 ns/op
+//	BenchmarkGetSessionDuringCreate   93519-118754 ns/op     96.2-112.0 ns/op
+//	BenchmarkAttachWebSocket          11732-12678 ns/op      9638-10641 ns/op
+//	BenchmarkBroadcastOutput          45292 ns/op, 43 allocs 25916 ns/op, 30 allocs
+//
+// Uncontended lookups pay a few extra nanoseconds for shard selection; in
+// exchange they no longer block behind a session that is starting.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+func newBenchService(b *testing.B) *Service {
+	cfg := config.SessionConfig{
+		MaxSessions:      1 << 20,
+		SessionTimeout:   "30m",
+		WorkingDirectory: b.TempDir(),
+	}
+	return New(cfg, zap.NewNop())
+}
+
+// wsPair returns a server-side connection and its client counterpart. The
+// client side drains everything it receives until closed.
+func wsPair(b *testing.B) (*websocket.Conn, func()) {
+	serverConns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			b.Errorf("upgrade failed: %v", err)
+			return
+		}
+		serverConns <- conn
+	}))
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		b.Fatalf("dial failed: %v", err)
+	}
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn := <-serverConns
+	return conn, func() {
+		client.Close()
+		conn.Close()
+		srv.Close()
+	}
+}
+
+func BenchmarkCreateSessionParallel(b *testing.B) {
+	service := newBenchService(b)
+	defer service.Shutdown()
+
+	var users int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		userID := fmt.Sprintf("user%d", atomic.AddInt64(&users, 1))
+		for pb.Next() {
+			session, err := service.CreateSession(context.Background(), userID, "sleep 60", "")
+			if err != nil {
+				b.Errorf("create failed: %v", err)
+				return
+			}
+			service.KillSession(session.ID)
+		}
+	})
+}
+
+func BenchmarkGetSessionParallel(b *testing.B) {
+	service := newBenchService(b)
+	defer service.Shutdown()
+
+	var ids []string
+	for i := 0; i < 64; i++ {
+		session, err := service.CreateSession(context.Background(), fmt.Sprintf("user%d", i), "sleep 60", "")
+		if err != nil {
+			b.Fatalf("create failed: %v", err)
+		}
+		ids = append(ids, session.ID)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			service.GetSession(ids[i%len(ids)])
+			i++
+		}
+	})
+}
+
+// BenchmarkGetSessionDuringCreate measures lookups while other goroutines
+// keep creating sessions, which is where a single service-wide lock held
+// across process start hurt the most.
+func BenchmarkGetSessionDuringCreate(b *testing.B) {
+	service := newBenchService(b)
+	defer service.Shutdown()
+
+	session, err := service.CreateSession(context.Background(), "reader", "sleep 60", "")
+	if err != nil {
+		b.Fatalf("create failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if created, err := service.CreateSession(context.Background(), "writer", "sleep 60", ""); err == nil {
+				service.KillSession(created.ID)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		service.GetSession(session.ID)
+	}
+}
+
+func BenchmarkAttachWebSocket(b *testing.B) {
+	service := newBenchService(b)
+	defer service.Shutdown()
+
+	session, err := service.CreateSession(context.Background(), "user123", "sleep 60", "")
+	if err != nil {
+		b.Fatalf("create failed: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		conn, closeFn := wsPair(b)
+		b.StartTimer()
+
+		if err := service.AttachWebSocket(session.ID, conn); err != nil {
+			b.Fatalf("attach failed: %v", err)
+		}
+
+		b.StopTimer()
+		closeFn()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkBroadcastOutput(b *testing.B) {
+	service := newBenchService(b)
+	defer service.Shutdown()
+
+	session, err := service.CreateSession(context.Background(), "user123", "sleep 60", "")
+	if err != nil {
+		b.Fatalf("create failed: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		conn, closeFn := wsPair(b)
+		defer closeFn()
+		session.connMu.Lock()
+		session.connections[conn] = newClient(conn, session.UserID)
+		session.connMu.Unlock()
+	}
+
+	chunk := []byte(strings.Repeat("output line with some text\r\n", 64))
+	time.Sleep(10 * time.Millisecond)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		service.broadcastOutput(session, chunk)
+	}
+}
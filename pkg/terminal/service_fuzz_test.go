@@ -0,0 +1,164 @@
+package terminal
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"go.uber.org/zap"
+)
+
+// FuzzCheckCommand checks that a blocked program stays blocked however
+// the rest of the command line goes, and that only allowed programs pass
+// an allow list
+func FuzzCheckCommand(f *testing.F) {
+	for _, seed := range []string{"", "ls", "ls -la", "rm", "rm -rf /", "rm;ls", "ls;rm", "ls && rm", "ls\nrm", "echo $(rm)", "rm&&ls", "rm|cat", "(rm)", "\\rm", "'rm' -rf", "\"r\"m", "  rm", "\trm\n", "rm -rf", "sudo\x00", "/bin/rm"} {
+		f.Add(seed)
+	}
+
+	blocked := New(config.SessionConfig{BlockedCommands: []string{"rm"}}, zap.NewNop())
+	allowed := New(config.SessionConfig{AllowedCommands: []string{"ls"}}, zap.NewNop())
+	f.Fuzz(func(t *testing.T, command string) {
+		programs, _ := commandPrograms(command)
+
+		err := blocked.checkCommand(command, false)
+		if command == "rm" || slices.Contains(programs, "rm") {
+			assert.ErrorIs(t, err, ErrCommandBlocked, "%q", command)
+		} else {
+			assert.NoError(t, err, "%q", command)
+		}
+
+		// rm with anything after a word boundary is rm, in a subshell or
+		// group too, and rm after any other command is still run
+		for _, sep := range []string{" ", "\t", "\n", ";", "&", "|", "(", ")", "<", ">"} {
+			assert.ErrorIs(t, blocked.checkCommand("rm"+sep+command, false), ErrCommandBlocked)
+			assert.ErrorIs(t, blocked.checkCommand("( { rm"+sep+command, false), ErrCommandBlocked)
+		}
+		for _, sep := range []string{"\n", ";", "&&", "||", "|"} {
+			if !strings.ContainsAny(command, "'\"\\`") {
+				assert.ErrorIs(t, blocked.checkCommand(command+sep+"rm", false), ErrCommandBlocked, "%q", command)
+			}
+		}
+
+		// Only ls itself, or a line that runs nothing but ls, is allowed
+		if allowed.checkCommand(command, false) == nil && command != "ls" {
+			programs, complete := commandPrograms(command)
+			assert.True(t, complete, "%q", command)
+			assert.NotEmpty(t, programs, "%q", command)
+			for _, p := range programs {
+				assert.Equal(t, "ls", p, "%q", command)
+			}
+		}
+	})
+}
+
+// FuzzScanTitle checks that the title never holds control characters or
+// more than maxTitle bytes, and that splitting output across reads does
+// not change what is found
+func FuzzScanTitle(f *testing.F) {
+	for _, seed := range []string{"\x1b]0;vim\x07", "\x1b]2;a\x1b\\", "\x1b]8;;x\x07", "\x1b\x1b]0;t\x07", "\x1b]0;a\x1b]2;b\x07", "\x1b]0;\x1b", "plain"} {
+		f.Add([]byte(seed), 3)
+	}
+	f.Fuzz(func(t *testing.T, output []byte, split int) {
+		var whole, parts titling
+		whole.scan(output)
+		if split < 0 {
+			split = -split
+		}
+		if len(output) > 0 {
+			split %= len(output) + 1
+		} else {
+			split = 0
+		}
+		parts.scan(output[:split])
+		parts.scan(output[split:])
+
+		assert.Equal(t, whole.title, parts.title)
+		assert.LessOrEqual(t, len(whole.title), maxTitle)
+		for _, r := range whole.title {
+			assert.False(t, r < 0x20 || r == 0x7f, "control character in %q", whole.title)
+		}
+	})
+}
+
+// FuzzCircularBuffer checks the buffer against the obvious model: after
+// any sequence of writes it holds the last size bytes written
+func FuzzCircularBuffer(f *testing.F) {
+	f.Add(8, []byte("hello"), []byte(" world"), []byte("!"))
+	f.Add(5, []byte("12345"), []byte(""), []byte("6"))
+	f.Add(4, []byte("abcdefghij"), []byte("k"), []byte("lmnop"))
+	f.Add(1, []byte("a"), []byte("b"), []byte("c"))
+	f.Add(0, []byte("a"), []byte(""), []byte("b"))
+	f.Add(-1, []byte("a"), []byte(""), []byte("b"))
+	f.Fuzz(func(t *testing.T, size int, a, b, c []byte) {
+		if size > 1<<16 {
+			size %= 1 << 16
+		}
+		cb := NewCircularBuffer(size)
+		var written []byte
+		for _, p := range [][]byte{a, b, c} {
+			n, err := cb.Write(p)
+			assert.NoError(t, err)
+			assert.Equal(t, len(p), n)
+			written = append(written, p...)
+
+			want := written
+			if keep := max(size, 0); len(want) > keep {
+				want = want[len(want)-keep:]
+			}
+			got := cb.Read()
+			if !bytes.Equal(want, got) {
+				t.Fatalf("size %d after %q: got %q, want %q", size, written, got, want)
+			}
+		}
+	})
+}
+
+func TestCircularBufferConcurrent(t *testing.T) {
+	cb := NewCircularBuffer(64)
+	chunk := bytes.Repeat([]byte("x"), 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				cb.Write(chunk)
+				if got := cb.Read(); len(got) > 64 || bytes.ContainsFunc(got, func(r rune) bool { return r != 'x' }) {
+					t.Errorf("read %q", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, cb.Read(), 64)
+}
+
+// FuzzTranscoder checks that splitting output across reads does not change
+// how it decodes
+func FuzzTranscoder(f *testing.F) {
+	for _, seed := range []string{"abc", "\x93\xfa\x96\x7b", "\x82\xa0\x82", "\xff\x80", "\x1b[0m\x93"} {
+		f.Add([]byte(seed), 1)
+	}
+	f.Fuzz(func(t *testing.T, output []byte, split int) {
+		_, whole, err := lookupCharset("Shift_JIS")
+		require.NoError(t, err)
+		_, parts, _ := lookupCharset("Shift_JIS")
+		if split < 0 {
+			split = -split
+		}
+		split %= len(output) + 1
+
+		want := whole.decode(output)
+		got := append(parts.decode(output[:split]), parts.decode(output[split:])...)
+		assert.Equal(t, string(want), string(got), "%q split at %d", output, split)
+		assert.Equal(t, whole.pending, parts.pending)
+	})
+}
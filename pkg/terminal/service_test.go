@@ -0,0 +1,3222 @@
+package terminal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yourusername/webtunnel/pkg/config"
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+func TestNewService(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		BlockedCommands:  []string{"rm", "sudo"},
+	}
+	logger := zap.NewNop()
+
+	service := New(cfg, logger)
+
+	assert.NotNil(t, service)
+	assert.Equal(t, cfg, service.config)
+	assert.NotNil(t, service.sessions)
+	assert.NotNil(t, service.logger)
+}
+
+func TestCreateSession(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	// Test successful session creation
+	session, err := service.CreateSession(context.Background(), "user123", "echo", "/tmp")
+	require.NoError(t, err)
+	assert.NotEmpty(t, session.ID)
+	assert.Equal(t, "user123", session.UserID)
+	assert.Equal(t, "echo", session.Command)
+	assert.NotNil(t, session.cmd)
+	assert.NotNil(t, session.pty)
+
+	// Clean up
+	err = service.KillSession(session.ID)
+	assert.NoError(t, err)
+}
+
+func TestBlockedCommands(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		BlockedCommands:  []string{"rm", "sudo"},
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	// Test blocked command
+	_, err := service.CreateSession(context.Background(), "user123", "sudo", "/tmp")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "command is blocked")
+}
+
+func TestGetSession(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	// Create a session
+	session, err := service.CreateSession(context.Background(), "user123", "echo", "/tmp")
+	require.NoError(t, err)
+
+	// Get existing session
+	retrieved, exists := service.GetSession(session.ID)
+	assert.True(t, exists)
+	assert.Equal(t, session.ID, retrieved.ID)
+	assert.Equal(t, session.UserID, retrieved.UserID)
+
+	// Get non-existent session
+	_, exists = service.GetSession("non-existent")
+	assert.False(t, exists)
+
+	// Clean up
+	service.KillSession(session.ID)
+}
+
+func TestKillSession(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	// Create a session
+	session, err := service.CreateSession(context.Background(), "user123", "sleep", "/tmp")
+	require.NoError(t, err)
+
+	// Kill the session
+	err = service.KillSession(session.ID)
+	assert.NoError(t, err)
+
+	// Verify session is removed
+	_, exists := service.GetSession(session.ID)
+	assert.False(t, exists)
+
+	// Try to kill non-existent session
+	err = service.KillSession("non-existent")
+	assert.Error(t, err)
+}
+
+func TestSendInput(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	// Create a session with bash
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+
+	// Send input - should not error
+	err = service.SendInput(context.Background(), session.ID, []byte("echo test\n"))
+	assert.NoError(t, err)
+
+	// Clean up
+	service.KillSession(session.ID)
+}
+
+func TestContextCancellation(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	defer service.Shutdown()
+
+	t.Run("create with cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := service.CreateSession(ctx, "user123", "bash", "/tmp")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, service.ListSessions("user123"))
+	})
+
+	t.Run("input to a program that stops reading", func(t *testing.T) {
+		// sleep never reads, so the PTY input queue fills up and the
+		// write blocks until the deadline
+		session, err := service.CreateSession(context.Background(), "user123", "sleep 60", "/tmp")
+		require.NoError(t, err)
+		defer service.KillSession(session.ID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		chunk := []byte(strings.Repeat("x", 1023) + "\n")
+		start := time.Now()
+		for err == nil && time.Since(start) < 5*time.Second {
+			err = service.SendInput(ctx, session.ID, chunk)
+		}
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 2*time.Second)
+
+		// Later input waits behind the stuck write for its own deadline
+		ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, service.SendInput(ctx, session.ID, []byte("x")), context.DeadlineExceeded)
+	})
+}
+
+type fakeSnippets map[string]string
+
+func (f fakeSnippets) ResolveSnippet(ctx context.Context, userID, name string) (string, error) {
+	content, exists := f[name]
+	if !exists {
+		return "", fmt.Errorf("snippet not found: %s", name)
+	}
+	return content, nil
+}
+
+func TestRunSnippet(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		BlockedCommands:  []string{"rm", "sudo"},
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	// Snippets are unavailable until a resolver is configured
+	err = service.RunSnippet(context.Background(), session.ID, "hello")
+	assert.Error(t, err)
+
+	service.SetSnippetResolver(fakeSnippets{
+		"hello":   "# greet\necho hello",
+		"cleanup": "cd /tmp\nrm -rf build",
+	})
+
+	err = service.RunSnippet(context.Background(), session.ID, "hello")
+	assert.NoError(t, err)
+
+	// Any blocked line rejects the whole snippet
+	err = service.RunSnippet(context.Background(), session.ID, "cleanup")
+	assert.ErrorIs(t, err, ErrCommandBlocked)
+
+	err = service.RunSnippet(context.Background(), session.ID, "missing")
+	assert.Error(t, err)
+}
+
+// fakeAuthorizer allows only elevated command runs
+type fakeAuthorizer struct{}
+
+func (fakeAuthorizer) Authorize(ctx context.Context, userID, action string, resource map[string]interface{}) (bool, string) {
+	if action != "command.run" {
+		return true, ""
+	}
+	elevated, _ := resource["elevated"].(bool)
+	return elevated, "needs elevated mode"
+}
+
+func TestElevation(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		BlockedCommands:  []string{"rm", "sudo"},
+		ElevatedCommands: []string{"sudo"},
+	}
+	service := New(cfg, zap.NewNop())
+	service.SetSnippetResolver(fakeSnippets{
+		"restart": "sudo true",
+		"cleanup": "rm -rf build",
+	})
+	events := make(chan Event, 10)
+	service.OnEvent(func(event Event) { events <- event })
+
+	session, err := service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	assert.ErrorIs(t, service.RunSnippet(context.Background(), session.ID, "restart"), ErrCommandBlocked)
+	assert.ErrorIs(t, service.EndElevation(session.ID), ErrNotElevated)
+
+	until, err := service.Elevate(session.ID, "admin", time.Minute)
+	require.NoError(t, err)
+	got, ok := session.ElevatedUntil()
+	assert.True(t, ok)
+	assert.Equal(t, until, got)
+	data, err := json.Marshal(session)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"elevated_until"`)
+
+	// Only the elevated commands are lifted
+	assert.NoError(t, service.RunSnippet(context.Background(), session.ID, "restart"))
+	assert.ErrorIs(t, service.RunSnippet(context.Background(), session.ID, "cleanup"), ErrCommandBlocked)
+
+	// Policies see the elevation too
+	service.SetAuthorizer(fakeAuthorizer{})
+	assert.NoError(t, service.RunSnippet(context.Background(), session.ID, "restart"))
+
+	require.NoError(t, service.EndElevation(session.ID))
+	_, ok = session.ElevatedUntil()
+	assert.False(t, ok)
+	assert.ErrorIs(t, service.RunSnippet(context.Background(), session.ID, "restart"), ErrCommandBlocked)
+
+	// Elevation runs out by itself
+	_, err = service.Elevate(session.ID, "user123", 50*time.Millisecond)
+	require.NoError(t, err)
+	var ended Event
+	for ended.Type != EventElevationEnded || ended.Detail["how"] != "expired" {
+		select {
+		case ended = <-events:
+		case <-time.After(5 * time.Second):
+			t.Fatal("elevation did not expire")
+		}
+	}
+	assert.Equal(t, "user123", ended.Detail["granted_by"])
+	_, ok = session.ElevatedUntil()
+	assert.False(t, ok)
+}
+
+type fakeProvisioner map[string]string
+
+func (f fakeProvisioner) Provision(ctx context.Context, userID, snapshot, dir string) ([]string, error) {
+	content, exists := f[snapshot]
+	if !exists {
+		return nil, fmt.Errorf("snapshot not found: %s", snapshot)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "marker"), []byte(content), 0644); err != nil {
+		return nil, err
+	}
+	return []string{"SNAPSHOT_MARKER=" + content}, nil
+}
+
+func TestCreateSessionFromSnapshot(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+	}
+	service := New(cfg, zap.NewNop())
+	ctx := context.Background()
+
+	// Snapshots need a provisioner
+	_, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "bash", Snapshot: "python"})
+	assert.Error(t, err)
+
+	service.SetProvisioner(fakeProvisioner{"python": "py311"})
+
+	_, err = service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "bash", Snapshot: "missing"})
+	assert.Error(t, err)
+	entries, _ := os.ReadDir(filepath.Join(cfg.WorkingDirectory, "sessions"))
+	assert.Empty(t, entries, "failed sessions leave no directory")
+
+	session, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "bash", Snapshot: "python"})
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	assert.Equal(t, "python", session.Snapshot)
+
+	data, err := os.ReadFile(filepath.Join(session.WorkingDir, "marker"))
+	require.NoError(t, err)
+	assert.Equal(t, "py311", string(data))
+
+	require.NoError(t, service.SendInput(ctx, session.ID, []byte("echo env=$SNAPSHOT_MARKER\n")))
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = service.Expect(waitCtx, session.ID, `env=py311`, true)
+	assert.NoError(t, err)
+}
+
+func TestCreateSessionFromTemplate(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		AllowedCommands:  []string{"bash"},
+	}
+	store := &fakeStore{records: make(map[string]SessionRecord)}
+	service := New(cfg, zap.NewNop())
+	service.SetSessionStore(store)
+	ctx := context.Background()
+	opts := CreateOptions{
+		Command: "cat",
+		Shell:   "/bin/sh",
+		Env:     []string{"GREETING=hello"},
+		Limits:  Limits{OpenFiles: 64},
+	}
+
+	// Only a template's command skips the command lists
+	_, err := service.CreateSessionWithOptions(ctx, "user123", opts)
+	assert.ErrorIs(t, err, ErrCommandNotAllowed)
+
+	opts.Template = "tmpl-1"
+	session, err := service.CreateSessionWithOptions(ctx, "user123", opts)
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	assert.Equal(t, "tmpl-1", session.Template)
+
+	record := store.get(session.ID)
+	assert.Equal(t, "tmpl-1", record.Template)
+	assert.Equal(t, "/bin/sh", record.Shell)
+	assert.Equal(t, Limits{OpenFiles: 64}, record.Limits)
+	assert.Contains(t, record.Env, "GREETING=hello")
+
+	shell, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{
+		Template: "tmpl-2",
+		Env:      []string{"GREETING=hello"},
+		Limits:   Limits{OpenFiles: 64},
+	})
+	require.NoError(t, err)
+	defer service.KillSession(shell.ID)
+	require.NoError(t, service.SendInput(ctx, shell.ID, []byte("echo \"$GREETING:$(ulimit -n)\"\n")))
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = service.Expect(waitCtx, shell.ID, `hello:64`, true)
+	assert.NoError(t, err)
+
+	_, err = service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Template: "tmpl-1", Limits: Limits{MemoryMB: -1}})
+	assert.ErrorIs(t, err, ErrInvalidLimits)
+}
+
+func TestCheckCommand(t *testing.T) {
+	service := New(config.SessionConfig{
+		AllowedCommands:  []string{"ls", "cat", "echo", "ls -la /etc"},
+		ElevatedCommands: []string{"sudo"},
+	}, zap.NewNop())
+
+	for command, allowed := range map[string]bool{
+		"ls":                      true,
+		"ls -la":                  true,
+		"ls -la /etc":             true,
+		"ls | cat":                true,
+		"ls >out 2>&1; echo done": true,
+		"FOO=1 ls":                true,
+		"echo 'a; rm -rf ~'":      true,
+		"ls; rm":                  false,
+		"ls; rm -rf ~":            false,
+		"ls && bash":              false,
+		"ls || bash":              false,
+		"ls & bash":               false,
+		"ls\nbash":                false,
+		"ls $(rm -rf ~)":          false,
+		"ls `rm -rf ~`":           false,
+		"echo \"$(rm)\"":          false,
+		"ls <(rm -rf ~)":          false,
+		"if ls; then rm; fi":      false,
+		"ls 'unclosed":            false,
+		"bash":                    false,
+		"":                        false,
+	} {
+		err := service.checkCommand(command, false)
+		if allowed {
+			assert.NoError(t, err, "%q", command)
+		} else {
+			assert.ErrorIs(t, err, ErrCommandNotAllowed, "%q", command)
+		}
+	}
+
+	// Elevated commands stand in for the allow list, on the same terms
+	assert.NoError(t, service.checkCommand("sudo ls", true))
+	assert.NoError(t, service.checkCommand("sudo a; sudo b", true))
+	assert.ErrorIs(t, service.checkCommand("sudo ls; rm", true), ErrCommandNotAllowed)
+
+	// Every program a line runs is checked against the block list
+	blocked := New(config.SessionConfig{BlockedCommands: []string{"rm"}}, zap.NewNop())
+	for _, command := range []string{"rm", "ls;rm", "ls; rm -rf ~", "ls && rm", "ls || rm", "ls | rm", "ls\nrm", "ls $(rm)", "ls `rm`", "if ls; then \\rm; fi", "FOO=1 'rm'", "ls >out; 2>&1 rm"} {
+		assert.ErrorIs(t, blocked.checkCommand(command, false), ErrCommandBlocked, "%q", command)
+	}
+	for _, command := range []string{"ls", "echo rm", "echo 'x; rm'", "ls > rm", "lsrm"} {
+		assert.NoError(t, blocked.checkCommand(command, false), "%q", command)
+	}
+}
+
+func TestExec(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      1,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		BlockedCommands:  []string{"rm"},
+	}
+	service := New(cfg, zap.NewNop())
+	ctx := context.Background()
+
+	result, err := service.Exec(ctx, "user123", ExecOptions{Command: "echo out; echo err >&2; cat; exit 3", Stdin: "in"})
+	require.NoError(t, err)
+	assert.Equal(t, "out\nin", result.Stdout)
+	assert.Equal(t, "err\n", result.Stderr)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.False(t, result.TimedOut)
+	entries, _ := os.ReadDir(filepath.Join(cfg.WorkingDirectory, "sessions"))
+	assert.Empty(t, entries, "exec directories are removed")
+
+	// A timeout kills the command and what it started
+	start := time.Now()
+	result, err = service.Exec(ctx, "user123", ExecOptions{Command: "sleep 30 & sleep 30", Timeout: 200 * time.Millisecond})
+	require.NoError(t, err)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, "SIGKILL", result.Signal)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	result, err = service.Exec(ctx, "user123", ExecOptions{Command: "head -c 2000000 /dev/zero"})
+	require.NoError(t, err)
+	assert.True(t, result.Truncated)
+	assert.Len(t, result.Stdout, maxExecOutput)
+
+	_, err = service.Exec(ctx, "user123", ExecOptions{Command: "rm -rf x"})
+	assert.ErrorIs(t, err, ErrCommandBlocked)
+	_, err = service.Exec(ctx, "user123", ExecOptions{Command: " "})
+	assert.ErrorIs(t, err, ErrInvalidExec)
+
+	// It takes one of the user's session slots while it runs
+	session, err := service.CreateSession(ctx, "user123", "cat", "")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	_, err = service.Exec(ctx, "user123", ExecOptions{Command: "true"})
+	assert.ErrorIs(t, err, ErrSessionLimit)
+}
+
+type fakeArtifactStore struct {
+	globs []string
+	files []string
+}
+
+func (f *fakeArtifactStore) Collect(execID, userID, dir string, globs []string) ([]Artifact, error) {
+	f.globs = globs
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		f.files = append(f.files, entry.Name())
+	}
+	return []Artifact{{Name: "out.txt", Size: 3}}, nil
+}
+
+func TestExecArtifacts(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      1,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+	}
+	service := New(cfg, zap.NewNop())
+	ctx := context.Background()
+
+	_, err := service.Exec(ctx, "user123", ExecOptions{Command: "true", Artifacts: []string{"*.txt"}})
+	assert.ErrorIs(t, err, ErrInvalidExec, "artifacts need a store")
+
+	store := &fakeArtifactStore{}
+	service.SetArtifactStore(store)
+	for _, glob := range []string{"/etc/*", "../*.txt", "a/../../b", "[", ""} {
+		_, err := service.Exec(ctx, "user123", ExecOptions{Command: "true", Artifacts: []string{glob}})
+		assert.ErrorIs(t, err, ErrInvalidExec, glob)
+	}
+
+	// Collected before the directory is removed, even when the command fails
+	result, err := service.Exec(ctx, "user123", ExecOptions{Command: "echo hi > out.txt; exit 1", Artifacts: []string{"*.txt"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.ExitCode)
+	assert.Equal(t, []string{"*.txt"}, store.globs)
+	assert.Equal(t, []string{"out.txt"}, store.files)
+	assert.Equal(t, []Artifact{{Name: "out.txt", Size: 3}}, result.Artifacts)
+}
+
+func TestSessionLogs(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		LogLines:         3,
+	}
+	// Debug lines are captured even though the server logs nothing
+	service := New(cfg, zap.NewNop())
+
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	logs, err := service.SessionLogs(session.ID)
+	require.NoError(t, err)
+	require.NotEmpty(t, logs)
+	assert.Equal(t, "Created new terminal session", logs[len(logs)-1].Message)
+	assert.Equal(t, "bash", logs[len(logs)-1].Fields["command"])
+
+	for i := 1; i <= 4; i++ {
+		session.logger.Debug("step", zap.Int("n", i))
+	}
+	logs, err = service.SessionLogs(session.ID)
+	require.NoError(t, err)
+	require.Len(t, logs, 3, "only the last lines are kept")
+	assert.Equal(t, "debug", logs[0].Level)
+	assert.Equal(t, int64(2), logs[0].Fields["n"])
+	assert.Equal(t, int64(4), logs[2].Fields["n"])
+
+	_, err = service.SessionLogs("missing")
+	assert.Error(t, err)
+
+	// Capture is off without log_lines
+	plain := New(config.SessionConfig{MaxSessions: 10, SessionTimeout: "30m", WorkingDirectory: "/tmp"}, zap.NewNop())
+	other, err := plain.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+	defer plain.KillSession(other.ID)
+	_, err = plain.SessionLogs(other.ID)
+	assert.Error(t, err)
+}
+
+func TestExpect(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	// The echoed input does not match, only the command output does
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte("printf 'ab%sd=%d\\n' c 42\n")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := service.Expect(ctx, session.ID, `abcd=(\d+)`, true)
+	require.NoError(t, err)
+	assert.Equal(t, "abcd=42", result.Match)
+	assert.Equal(t, []string{"42"}, result.Groups)
+	assert.Greater(t, result.Offset, int64(0))
+
+	// Timeouts are reported distinctly
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = service.Expect(ctx, session.ID, "never-printed", false)
+	assert.ErrorIs(t, err, ErrExpectTimeout)
+
+	_, err = service.Expect(context.Background(), session.ID, "(", false)
+	assert.Error(t, err)
+}
+
+func TestCreateSessionInitialSize(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		DefaultCols:      132,
+		DefaultRows:      40,
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	// Configured default
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	size, err := pty.GetsizeFull(session.pty)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(132), size.Cols)
+	assert.Equal(t, uint16(40), size.Rows)
+
+	// Requested size wins over the default
+	sized, err := service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{Command: "bash", Cols: 200, Rows: 50})
+	require.NoError(t, err)
+	defer service.KillSession(sized.ID)
+
+	size, err = pty.GetsizeFull(sized.pty)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(200), size.Cols)
+	assert.Equal(t, uint16(50), size.Rows)
+
+	require.NoError(t, service.Resize(sized.ID, 100, 30))
+	size, err = pty.GetsizeFull(sized.pty)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(100), size.Cols)
+
+	assert.Error(t, service.Resize(sized.ID, 0, 30))
+}
+
+func TestWithLocale(t *testing.T) {
+	env := withLocale([]string{"PATH=/bin"}, "C.UTF-8")
+	assert.Contains(t, env, "LANG=C.UTF-8")
+
+	// An explicit locale is left alone
+	env = withLocale([]string{"LANG=de_DE.UTF-8"}, "C.UTF-8")
+	assert.Equal(t, []string{"LANG=de_DE.UTF-8"}, env)
+}
+
+func TestSetPresentation(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	// Only the owner may present
+	err = service.SetPresentation(session.ID, "viewer", true)
+	assert.Error(t, err)
+	assert.False(t, session.Presenting())
+
+	require.NoError(t, service.SetPresentation(session.ID, "user123", true))
+	assert.True(t, session.Presenting())
+
+	require.NoError(t, service.SetPresentation(session.ID, "user123", false))
+	assert.False(t, session.Presenting())
+}
+
+func TestRename(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+	}
+	store := &fakeStore{records: make(map[string]SessionRecord)}
+	service := New(cfg, zap.NewNop())
+	service.SetSessionStore(store)
+
+	session, err := service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{
+		Command: "cat",
+		Name:    "  build  ",
+	})
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	assert.Equal(t, "build", session.Name())
+	assert.Equal(t, "build", store.get(session.ID).Name)
+
+	_, err = service.Rename(session.ID, "deploy")
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", session.Name())
+	assert.Equal(t, "deploy", store.get(session.ID).Name)
+	data, err := json.Marshal(session)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"name":"deploy"`)
+
+	// Names are printable and short; empty clears them
+	_, err = service.Rename(session.ID, "bad\x1b[2J")
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = service.Rename(session.ID, strings.Repeat("x", 65))
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{
+		Command: "cat",
+		Name:    "tab\there",
+	})
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = service.Rename(session.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, session.Name())
+	data, err = json.Marshal(session)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"name"`)
+
+	_, err = service.Rename("missing", "x")
+	assert.Error(t, err)
+}
+
+func TestTags(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+	}
+	store := &fakeStore{records: make(map[string]SessionRecord)}
+	service := New(cfg, zap.NewNop())
+	service.SetSessionStore(store)
+
+	build, err := service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{
+		Command: "cat",
+		Tags:    []string{"ci", "build", "ci"},
+	})
+	require.NoError(t, err)
+	defer service.KillSession(build.ID)
+	assert.Equal(t, []string{"build", "ci"}, build.Tags())
+	assert.Equal(t, []string{"build", "ci"}, store.get(build.ID).Tags)
+
+	done, err := service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{
+		Command: "exit 0",
+		Tags:    []string{"build"},
+	})
+	require.NoError(t, err)
+	<-done.Done()
+	other, err := service.CreateSession(context.Background(), "other", "cat", "")
+	require.NoError(t, err)
+	defer service.KillSession(other.ID)
+	_, err = service.SetTags(other.ID, []string{"build"})
+	require.NoError(t, err)
+
+	ids := func(sessions []*Session) []string {
+		var result []string
+		for _, session := range sessions {
+			result = append(result, session.ID)
+		}
+		return result
+	}
+	assert.ElementsMatch(t, []string{build.ID, done.ID}, ids(service.FindSessions("user123", SessionFilter{Tags: []string{"build"}})))
+	assert.Equal(t, []string{build.ID}, ids(service.FindSessions("user123", SessionFilter{Tags: []string{"build"}, Status: StatusRunning})))
+	assert.Equal(t, []string{build.ID}, ids(service.FindSessions("user123", SessionFilter{Tags: []string{"build", "ci"}})))
+	assert.Empty(t, service.FindSessions("user123", SessionFilter{Tags: []string{"deploy"}}))
+	assert.Len(t, service.FindSessions("user123", SessionFilter{}), 2)
+
+	// An invalid update changes nothing
+	name, tags := "renamed", []string{"has space"}
+	_, err = service.Update(build.ID, SessionUpdate{Name: &name, Tags: &tags})
+	assert.ErrorIs(t, err, ErrInvalidTags)
+	assert.Empty(t, build.Name())
+	assert.Equal(t, []string{"build", "ci"}, build.Tags())
+	_, err = service.SetTags(build.ID, []string{"a,b"})
+	assert.ErrorIs(t, err, ErrInvalidTags)
+	many := make([]string, maxTags+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("t%d", i)
+	}
+	_, err = service.SetTags(build.ID, many)
+	assert.ErrorIs(t, err, ErrInvalidTags)
+
+	tags = []string{}
+	_, err = service.Update(build.ID, SessionUpdate{Name: &name, Tags: &tags})
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", build.Name())
+	assert.Empty(t, build.Tags())
+	assert.Empty(t, store.get(build.ID).Tags)
+}
+
+func TestSessionPage(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sessions := []*Session{
+		{ID: "c", CreatedAt: base, LastActive: base.Add(3 * time.Hour)},
+		{ID: "a", CreatedAt: base.Add(time.Hour), LastActive: base.Add(time.Hour)},
+		{ID: "b", CreatedAt: base, LastActive: base.Add(2 * time.Hour)},
+	}
+	ids := func(list *SessionList) []string {
+		var result []string
+		for _, session := range list.Sessions {
+			result = append(result, session.ID)
+		}
+		return result
+	}
+
+	// Ties on the sort key are broken by ID
+	list, err := SessionPage{}.Page(sessions)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "a"}, ids(list))
+	assert.Equal(t, 3, list.Total)
+	assert.Equal(t, DefaultPageLimit, list.Limit)
+	assert.Empty(t, list.NextCursor)
+
+	list, err = SessionPage{Sort: "-last_active", Limit: 2, Offset: 1}.Page(sessions)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, ids(list))
+	assert.Equal(t, 1, list.Offset)
+
+	// Cursors resume after the last session of the page, even once it is gone
+	page := SessionPage{Sort: "-created_at", Limit: 1}
+	var seen []string
+	for {
+		list, err := page.Page(sessions)
+		require.NoError(t, err)
+		assert.Equal(t, 3, list.Total)
+		seen = append(seen, ids(list)...)
+		if list.NextCursor == "" {
+			break
+		}
+		page.Cursor = list.NextCursor
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, seen)
+
+	list, err = SessionPage{Limit: 1}.Page(sessions)
+	require.NoError(t, err)
+	list, err = SessionPage{Limit: 1, Cursor: list.NextCursor}.Page(sessions[:2])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, ids(list))
+
+	for _, bad := range []SessionPage{
+		{Limit: -1},
+		{Limit: MaxPageLimit + 1},
+		{Offset: -1},
+		{Sort: "name"},
+		{Cursor: "not a cursor"},
+		{Offset: 1, Cursor: encodeSessionCursor("", base, "a")},
+		{Sort: "last_active", Cursor: encodeSessionCursor("created_at", base, "a")},
+	} {
+		_, err := bad.Page(sessions)
+		assert.ErrorIs(t, err, ErrInvalidPage, "%+v", bad)
+	}
+}
+
+func TestSessionEvents(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		IdleWarning:      "10m",
+		WorkingDirectory: "/tmp",
+		AlertPatterns:    []string{`panic: \w+`},
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	events := make(chan Event, 10)
+	service.OnEvent(func(event Event) { events <- event })
+
+	next := func() Event {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return Event{}
+		}
+	}
+
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+
+	// Idle sessions are warned once before they are removed
+	session.LastActive = time.Now().Add(-25 * time.Minute)
+	service.CleanupStaleSessions()
+	service.CleanupStaleSessions()
+	event := next()
+	assert.Equal(t, EventIdleWarning, event.Type)
+	assert.Equal(t, "user123", event.UserID)
+
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte("echo \"panic: \"boom\n")))
+	event = next()
+	assert.Equal(t, EventOutputAlert, event.Type)
+	assert.Equal(t, "panic: boom", event.Detail["match"])
+
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte("exit\n")))
+	event = next()
+	assert.Equal(t, EventSessionExited, event.Type)
+	assert.Equal(t, session.ID, event.SessionID)
+	assert.Equal(t, "true", event.Detail["detached"])
+}
+
+func TestStats(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	for _, userID := range []string{"user1", "user1", "user2"} {
+		session, err := service.CreateSession(context.Background(), userID, "bash", "/tmp")
+		require.NoError(t, err)
+		defer service.KillSession(session.ID)
+	}
+
+	stats := service.Stats()
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, 3, stats.ByStatus[StatusRunning])
+	assert.Equal(t, 2, stats.Users)
+	assert.Equal(t, 0, stats.Connections)
+	assert.Equal(t, 0, stats.Pending)
+}
+
+func TestPrewarm(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		Prewarm:          []config.PrewarmPool{{Command: "bash", Size: 1}},
+	}
+	logger := zap.NewNop()
+	service := New(cfg, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.Prewarm(ctx)
+		close(done)
+	}()
+	idle := func() int {
+		service.prewarm.mu.Lock()
+		defer service.prewarm.mu.Unlock()
+		return len(service.prewarm.idle["bash"])
+	}
+	require.Eventually(t, func() bool { return idle() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// The pooled shell keeps its directory through janitor sweeps
+	service.prewarm.mu.Lock()
+	warmID := service.prewarm.idle["bash"][0].id
+	service.prewarm.mu.Unlock()
+	assert.True(t, service.SessionExists(warmID))
+
+	// An empty command is the interactive shell too
+	session, err := service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{Cols: 120, Rows: 30})
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	assert.Equal(t, warmID, session.ID)
+	assert.DirExists(t, session.WorkingDir)
+
+	size, err := pty.GetsizeFull(session.pty)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(120), size.Cols)
+
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte("echo warm-$WEBTUNNEL_SESSION_ID\n")))
+	expectCtx, expectCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer expectCancel()
+	_, err = service.Expect(expectCtx, session.ID, "warm-"+session.ID, true)
+	require.NoError(t, err)
+
+	// The pool is refilled in the background
+	require.Eventually(t, func() bool { return idle() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// Other commands and directories start fresh, and only count when pooled
+	other, err := service.CreateSession(context.Background(), "user123", "bash", t.TempDir())
+	require.NoError(t, err)
+	defer service.KillSession(other.ID)
+	assert.NotEqual(t, warmID, other.ID)
+
+	var buf strings.Builder
+	require.NoError(t, service.WriteMetrics(&buf))
+	assert.Contains(t, buf.String(), `webtunnel_prewarm_claims_total{command="bash",result="hit"} 1`)
+	assert.Contains(t, buf.String(), `webtunnel_prewarm_claims_total{command="bash",result="miss"} 0`)
+	assert.Contains(t, buf.String(), `webtunnel_prewarm_idle{command="bash"} 1`)
+
+	// Stopping drains the pool
+	service.prewarm.mu.Lock()
+	spare := service.prewarm.idle["bash"][0]
+	service.prewarm.mu.Unlock()
+	cancel()
+	<-done
+	assert.Equal(t, 0, idle())
+	assert.NoDirExists(t, spare.dir)
+}
+
+func TestLatency(t *testing.T) {
+	l := newLatency()
+	start := time.Now()
+
+	id := l.probe(start)
+	assert.False(t, l.ack("999", start), "unknown probe")
+	assert.True(t, l.ack(id, start.Add(40*time.Millisecond)))
+	assert.False(t, l.ack(id, start.Add(50*time.Millisecond)), "probe answered twice")
+
+	// Only the first input before an echo is timed
+	l.input(start)
+	l.input(start.Add(5 * time.Millisecond))
+	l.output(start.Add(20 * time.Millisecond))
+	l.output(start.Add(90 * time.Millisecond))
+
+	q := l.quality()
+	assert.Equal(t, 40.0, q.RTTMs)
+	assert.Equal(t, 20.0, q.EchoMs)
+	assert.Equal(t, 60.0, q.InputMs)
+	assert.Equal(t, "good", q.Rating)
+
+	// Unanswered probes are forgotten
+	for i := 0; i < 100; i++ {
+		l.probe(start)
+	}
+	assert.LessOrEqual(t, len(l.probes), maxOutstandingProbes)
+}
+
+func TestScreen(t *testing.T) {
+	screen := NewScreen(20, 4)
+	screen.Write([]byte("hello\r\nworld"))
+	assert.Equal(t, []string{"hello", "world", "", ""}, screen.Text())
+
+	// Cursor addressing and erase
+	screen.Write([]byte("\x1b[2J\x1b[2;3Hmid\x1b[1;1Htop\x1b[1;2H\x1b[K"))
+	assert.Equal(t, []string{"t", "  mid", "", ""}, screen.Text())
+
+	// Scrolling off the bottom drops the first line
+	screen.Write([]byte("\x1b[4;1Hlast\nnext"))
+	assert.Equal(t, []string{"  mid", "", "last", "    next"}, screen.Text())
+
+	// Long lines wrap
+	wrap := NewScreen(5, 2)
+	wrap.Write([]byte("abcdefg"))
+	assert.Equal(t, []string{"abcde", "fg"}, wrap.Text())
+
+	// The alternate screen is discarded on exit
+	alt := NewScreen(10, 2)
+	alt.Write([]byte("$ vim\x1b[?1049h\x1b[Heditor\x1b[?1049l"))
+	assert.Equal(t, []string{"$ vim", ""}, alt.Text())
+
+	// Sequences may be split across writes; UTF-8 too
+	split := NewScreen(10, 1)
+	split.Write([]byte("\x1b[3"))
+	split.Write([]byte("1mr\xc3"))
+	split.Write([]byte("\xa9d\x1b[0m!\x1b]0;title\x07"))
+	lines := split.Lines()
+	require.Len(t, lines[0], 2)
+	assert.Equal(t, "réd", lines[0][0].Text)
+	assert.Equal(t, "color:#cd3131", lines[0][0].Style.CSS())
+	assert.Equal(t, "!", lines[0][1].Text)
+	assert.Equal(t, "", lines[0][1].Style.CSS())
+}
+
+func TestStyleCSS(t *testing.T) {
+	screen := NewScreen(10, 1)
+	screen.Write([]byte("\x1b[1;38;5;196;48;2;0;16;32ma\x1b[0;7mb\x1b[0;4;9;38;5;240mc"))
+	runs := screen.Lines()[0]
+	require.Len(t, runs, 3)
+	assert.Equal(t, "color:#ff0000;background-color:#001020;font-weight:bold", runs[0].Style.CSS())
+	assert.Equal(t, "color:"+DefaultBackground+";background-color:"+DefaultForeground, runs[1].Style.CSS())
+	assert.Equal(t, "color:#585858;text-decoration:underline line-through", runs[2].Style.CSS())
+}
+
+func TestScreenRender(t *testing.T) {
+	screen := NewScreen(10, 3)
+	screen.SetScrollback(100)
+	screen.Write([]byte("one\r\n0123456789ab\r\n\x1b[1;31mred\x1b[0m\r\nlast\x1b[2;1H\x1b[4m"))
+
+	// Replaying the rendering on a blank screen reproduces it
+	replayed := NewScreen(10, 3)
+	replayed.SetScrollback(100)
+	replayed.Write(screen.Render())
+	assert.Equal(t, screen.history(), replayed.history())
+	assert.Equal(t, screen.Lines(), replayed.Lines())
+	replayed.Write([]byte("x"))
+	assert.Equal(t, "xed", replayed.Text()[1])
+	assert.True(t, replayed.Lines()[1][0].Style.Underline, "current style is restored")
+
+	// The alternate screen is drawn over the primary, which comes back on exit
+	alt := NewScreen(10, 2)
+	alt.Write([]byte("$ top\x1b[?1049h\x1b[Hrunning"))
+	replayed = NewScreen(10, 2)
+	replayed.Write(alt.Render())
+	assert.Equal(t, []string{"running", ""}, replayed.Text())
+	replayed.Write([]byte("\x1b[?1049l"))
+	assert.Equal(t, []string{"$ top", ""}, replayed.Text())
+}
+
+func TestScreenResize(t *testing.T) {
+	screen := NewScreen(10, 4)
+	screen.SetScrollback(100)
+	screen.Write([]byte("a\r\nb\r\nc\r\nd"))
+
+	// Shrinking keeps the cursor row, scrolling rows above it into history
+	screen.Resize(3, 2)
+	assert.Equal(t, []string{"c", "d"}, screen.Text())
+	assert.Equal(t, "a", string(screen.history()[0].text))
+	screen.Write([]byte("xy"))
+	assert.Equal(t, []string{"c", "dxy"}, screen.Text())
+
+	// Growing adds blank rows below
+	screen.Resize(6, 3)
+	assert.Equal(t, []string{"c", "dxy", ""}, screen.Text())
+	cols, rows := screen.Size()
+	assert.Equal(t, 6, cols)
+	assert.Equal(t, 3, rows)
+}
+
+func TestSessionScreen(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	session.outputBuf.Write([]byte("\x1b[32mready\x1b[0m\r\n"))
+	screen, err := service.Screen(session.ID)
+	require.NoError(t, err)
+	cols, rows := screen.Size()
+	assert.Equal(t, 80, cols)
+	assert.Equal(t, 24, rows)
+	assert.Contains(t, strings.Join(screen.Text(), "\n"), "ready")
+
+	// Attaching clients get the live screen rendered
+	service.feedWaiters(session, []byte("\x1b[2J\x1b[Hrendered"))
+	assert.Contains(t, string(service.snapshot(session)), "rendered")
+
+	_, err = service.Screen("missing")
+	assert.Error(t, err)
+}
+
+func TestScreenSearch(t *testing.T) {
+	screen := NewScreen(10, 3)
+	screen.SetScrollback(100)
+	screen.Write([]byte("error one\r\nok\r\nerror two\r\n0123456789errxr\r\nlast"))
+
+	re := regexp.MustCompile(`err\w*`)
+	results := screen.Search(re, 10)
+	assert.Equal(t, 6, results.Lines)
+	assert.Equal(t, 3, results.Scrollback)
+	require.Len(t, results.Matches, 3)
+	assert.Equal(t, protocol.SearchMatch{Line: 0, Col: 0, Length: 5, Text: "error"}, results.Matches[0])
+	assert.Equal(t, protocol.SearchMatch{Line: 2, Col: 0, Length: 5, Text: "error"}, results.Matches[1])
+	// Found on the continuation of a wrapped line
+	assert.Equal(t, protocol.SearchMatch{Line: 4, Col: 0, Length: 5, Text: "errxr"}, results.Matches[2])
+
+	// Matches spanning a wrap start on the first row
+	spanning := screen.Search(regexp.MustCompile(`89er`), 10)
+	require.Len(t, spanning.Matches, 1)
+	assert.Equal(t, 3, spanning.Matches[0].Line)
+	assert.Equal(t, 8, spanning.Matches[0].Col)
+
+	limited := screen.Search(re, 2)
+	assert.Len(t, limited.Matches, 2)
+	assert.True(t, limited.Truncated)
+
+	// Columns count characters, not bytes
+	utf := NewScreen(20, 1)
+	utf.Write([]byte("héllo wörld"))
+	matches := utf.Search(regexp.MustCompile(`w.rld`), 10).Matches
+	require.Len(t, matches, 1)
+	assert.Equal(t, 6, matches[0].Col)
+	assert.Equal(t, 5, matches[0].Length)
+
+	// Scrollback is bounded
+	bounded := NewScreen(10, 2)
+	bounded.SetScrollback(5)
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(bounded, "line %d\r\n", i)
+	}
+	results = bounded.Search(regexp.MustCompile(`line`), 100)
+	assert.Equal(t, 5, results.Scrollback)
+	assert.Equal(t, "line 44", string(bounded.history()[0].text))
+}
+
+func TestSearch(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+
+	session, err := service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	session.outputBuf.Write([]byte("\r\nBuild FAILED: 3 errors\r\n"))
+
+	results, err := service.Search(session.ID, protocol.Search{ID: "s1", Query: "failed", IgnoreCase: true})
+	require.NoError(t, err)
+	assert.Equal(t, "s1", results.ID)
+	require.NotEmpty(t, results.Matches)
+	assert.Equal(t, "FAILED", results.Matches[0].Text)
+
+	results, err = service.Search(session.ID, protocol.Search{Query: "3 errors.", Literal: true})
+	require.NoError(t, err)
+	assert.Empty(t, results.Matches)
+
+	_, err = service.Search(session.ID, protocol.Search{Query: "(unclosed"})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+	_, err = service.Search(session.ID, protocol.Search{})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+	_, err = service.Search("missing", protocol.Search{Query: "x"})
+	assert.Error(t, err)
+
+	// Over a client connection
+	clientID, err := service.AttachLongPoll(session.ID, "user123")
+	require.NoError(t, err)
+	defer service.DetachLongPoll(session.ID, "user123", clientID)
+	search, err := protocol.NewMessage(protocol.TypeSearch, protocol.Search{ID: "s2", Query: "FAIL"})
+	require.NoError(t, err)
+	raw, err := json.Marshal(search)
+	require.NoError(t, err)
+	require.NoError(t, service.PostMessages(context.Background(), session.ID, "user123", clientID, []json.RawMessage{raw}))
+
+	var found *protocol.SearchResults
+	var cursor uint64
+	deadline := time.Now().Add(5 * time.Second)
+	for found == nil && time.Now().Before(deadline) {
+		result, err := service.Poll(context.Background(), session.ID, "user123", clientID, cursor, time.Second)
+		require.NoError(t, err)
+		for _, raw := range result.Messages {
+			var msg protocol.Message
+			require.NoError(t, json.Unmarshal(raw, &msg))
+			if msg.Type == protocol.TypeSearchResults {
+				found = &protocol.SearchResults{}
+				require.NoError(t, msg.Decode(found))
+			}
+		}
+		cursor = result.Cursor
+	}
+	require.NotNil(t, found)
+	assert.Equal(t, "s2", found.ID)
+	assert.NotEmpty(t, found.Matches)
+}
+
+func TestFanoutFairness(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	gate := make(chan struct{})
+	f := newFanout(1, 1024, 1<<20, func(session *Session, output []byte) {
+		// Hold the single worker until both users have queued output
+		<-gate
+		mu.Lock()
+		order = append(order, session.UserID)
+		mu.Unlock()
+	})
+	defer f.stop()
+
+	chatty := &Session{ID: "s1", UserID: "chatty"}
+	quiet := &Session{ID: "s2", UserID: "quiet"}
+
+	for i := 0; i < 20; i++ {
+		f.enqueue(chatty, make([]byte, 512))
+	}
+	f.enqueue(quiet, make([]byte, 512))
+	close(gate)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 21
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The quiet user is served after one or two chatty turns, not after
+	// everything the chatty user queued
+	position := 0
+	for i, user := range order {
+		if user == "quiet" {
+			position = i
+		}
+	}
+	assert.LessOrEqual(t, position, 4)
+
+	stats := f.stats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "chatty", stats[0].UserID)
+	assert.Equal(t, uint64(20*512), stats[0].BytesSent)
+	assert.InDelta(t, 20.0/21.0, stats[0].Share, 0.001)
+}
+
+func TestPollTransport(t *testing.T) {
+	tr := newPollTransport("c1", "sess", "user123")
+	ctx := context.Background()
+
+	// Nothing queued: the poll times out empty
+	result := tr.poll(ctx, 0, 10*time.Millisecond)
+	assert.Empty(t, result.Messages)
+	assert.Equal(t, uint64(0), result.Cursor)
+
+	require.NoError(t, tr.WriteMessage(1, []byte(`{"type":"output","data":"a"}`+"\n")))
+	require.NoError(t, tr.WriteMessage(1, []byte(`{"type":"output","data":"b"}`)))
+
+	result = tr.poll(ctx, 0, time.Second)
+	require.Len(t, result.Messages, 2)
+	assert.JSONEq(t, `{"type":"output","data":"a"}`, string(result.Messages[0]))
+	assert.Equal(t, uint64(2), result.Cursor)
+
+	// Polling with the old cursor resends, as after a lost response
+	assert.Len(t, tr.poll(ctx, 0, time.Second).Messages, 2)
+
+	// A waiting poll wakes up for new output
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tr.WriteMessage(1, []byte(`{"type":"output","data":"c"}`))
+	}()
+	result = tr.poll(ctx, 2, time.Second)
+	require.Len(t, result.Messages, 1)
+	assert.Equal(t, uint64(3), result.Cursor)
+	assert.Equal(t, len(`{"type":"output","data":"c"}`), tr.size, "acknowledged frames are released")
+
+	// Backlog is bounded
+	assert.ErrorIs(t, tr.WriteMessage(1, make([]byte, maxPollBacklog)), ErrPollBacklog)
+
+	tr.Close()
+	result = tr.poll(ctx, 3, time.Second)
+	assert.True(t, result.Closed)
+	_, _, err := tr.NextReader()
+	assert.Error(t, err)
+}
+
+func TestLongPoll(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+
+	session, err := service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	_, err = service.AttachLongPoll("missing", "user123")
+	assert.Error(t, err)
+
+	clientID, err := service.AttachLongPoll(session.ID, "user123")
+	require.NoError(t, err)
+
+	// Only the user who attached may use the client
+	_, err = service.Poll(context.Background(), session.ID, "mallory", clientID, 0, time.Millisecond)
+	assert.ErrorIs(t, err, ErrPollClientNotFound)
+
+	err = service.PostMessages(context.Background(), session.ID, "user123", clientID, []json.RawMessage{
+		json.RawMessage(`{"type":"input","data":"hello over polling\n"}`),
+	})
+	require.NoError(t, err)
+
+	var output strings.Builder
+	var cursor uint64
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(output.String(), "hello over polling") && time.Now().Before(deadline) {
+		result, err := service.Poll(context.Background(), session.ID, "user123", clientID, cursor, time.Second)
+		require.NoError(t, err)
+		for _, raw := range result.Messages {
+			var msg protocol.Message
+			require.NoError(t, json.Unmarshal(raw, &msg))
+			output.WriteString(msg.Data)
+		}
+		cursor = result.Cursor
+	}
+	assert.Contains(t, output.String(), "WebTunnel connected")
+	assert.Contains(t, output.String(), "hello over polling")
+
+	err = service.PostMessages(context.Background(), session.ID, "user123", clientID, []json.RawMessage{
+		json.RawMessage(`{"type":"input","data":"` + strings.Repeat("x", maxClientMessage) + `"}`),
+	})
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+
+	require.NoError(t, service.DetachLongPoll(session.ID, "user123", clientID))
+	assert.Eventually(t, func() bool {
+		_, err := service.Poll(context.Background(), session.ID, "user123", clientID, cursor, time.Millisecond)
+		return errors.Is(err, ErrPollClientNotFound)
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		session.connMu.RLock()
+		defer session.connMu.RUnlock()
+		return len(session.connections) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+type fakeBanners struct {
+	banner *Banner
+	acked  map[string]bool
+}
+
+func (f *fakeBanners) Banner(ctx context.Context, userID string) (*Banner, error) {
+	return f.banner, nil
+}
+
+func (f *fakeBanners) Acknowledged(ctx context.Context, userID, bannerID string) (bool, error) {
+	return f.acked[userID+":"+bannerID], nil
+}
+
+func TestBanner(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	banners := &fakeBanners{
+		banner: &Banner{ID: "b1", Text: "Authorized use only.\nActivity is logged.", RequireAck: true, Notice: true, Print: true},
+		acked:  map[string]bool{},
+	}
+	service.SetBanners(banners)
+	ctx := context.Background()
+
+	_, err := service.CreateSession(ctx, "user123", "cat", "/tmp")
+	var unacknowledged *BannerError
+	require.ErrorAs(t, err, &unacknowledged)
+	assert.ErrorIs(t, err, ErrBannerNotAcknowledged)
+	assert.Equal(t, "b1", unacknowledged.Banner.ID)
+
+	banners.acked["user123:b1"] = true
+	session, err := service.CreateSession(ctx, "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	clientID, err := service.AttachLongPoll(session.ID, "user123")
+	require.NoError(t, err)
+	result, err := service.Poll(ctx, session.ID, "user123", clientID, 0, time.Second)
+	require.NoError(t, err)
+	var notices, output []string
+	for _, raw := range result.Messages {
+		var msg protocol.Message
+		require.NoError(t, json.Unmarshal(raw, &msg))
+		switch msg.Type {
+		case protocol.TypeNotice:
+			notices = append(notices, msg.Data)
+		case protocol.TypeOutput:
+			output = append(output, msg.Data)
+		}
+	}
+	assert.Equal(t, []string{"Authorized use only.\nActivity is logged."}, notices)
+	assert.Contains(t, strings.Join(output, ""), "Authorized use only.\r\nActivity is logged.\r\n",
+		"printed ahead of the process's output")
+}
+
+func TestControl(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	service.SetPermissions(fakePermissions{"bob": AccessWrite, "carol": AccessRead})
+	ctx := context.Background()
+
+	session, err := service.CreateSession(ctx, "alice", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	alice, err := service.AttachLongPoll(session.ID, "alice")
+	require.NoError(t, err)
+	bob, err := service.AttachLongPoll(session.ID, "bob")
+	require.NoError(t, err)
+	_, err = service.AttachLongPoll(session.ID, "carol")
+	require.NoError(t, err)
+
+	presence, err := service.Presence(session.ID)
+	require.NoError(t, err)
+	require.Len(t, presence.Participants, 3)
+	assert.Equal(t, protocol.RoleDriver, presence.Participants[0].Role, "anyone with write access drives while nobody holds control")
+	assert.Equal(t, protocol.RoleDriver, presence.Participants[1].Role)
+	assert.Equal(t, protocol.RoleObserver, presence.Participants[2].Role)
+	assert.True(t, presence.Participants[2].ReadOnly)
+
+	presence, err = service.RequestControl(session.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, protocol.PresenceGranted, presence.Event)
+	assert.Equal(t, "alice", presence.Driver)
+	assert.Equal(t, protocol.RoleObserver, presence.Participants[1].Role)
+	assert.ErrorIs(t, service.CheckControl(session, "bob"), ErrNotDriver)
+
+	// Bob's keystrokes are refused while Alice drives
+	require.NoError(t, service.PostMessages(ctx, session.ID, "bob", bob, []json.RawMessage{
+		json.RawMessage(`{"type":"input","data":"from bob\n"}`),
+	}))
+	var refused bool
+	var cursor uint64
+	deadline := time.Now().Add(5 * time.Second)
+	for !refused && time.Now().Before(deadline) {
+		result, err := service.Poll(ctx, session.ID, "bob", bob, cursor, time.Second)
+		require.NoError(t, err)
+		for _, raw := range result.Messages {
+			var msg protocol.Message
+			require.NoError(t, json.Unmarshal(raw, &msg))
+			refused = refused || (msg.Type == protocol.TypeError && strings.Contains(msg.Data, "given control"))
+		}
+		cursor = result.Cursor
+	}
+	assert.True(t, refused)
+
+	presence, err = service.RequestControl(session.ID, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, protocol.PresenceRequested, presence.Event)
+	assert.Equal(t, []string{"bob"}, presence.Requests)
+
+	_, err = service.GrantControl(session.ID, "bob", "bob")
+	assert.ErrorIs(t, err, ErrForbidden, "only the driver or the owner hands over control")
+	_, err = service.GrantControl(session.ID, "alice", "carol")
+	assert.ErrorIs(t, err, ErrCannotDrive)
+	_, err = service.RequestControl(session.ID, "carol")
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	presence, err = service.GrantControl(session.ID, "alice", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", presence.Driver)
+	assert.Empty(t, presence.Requests)
+	assert.ErrorIs(t, service.CheckControl(session, "alice"), ErrNotDriver)
+	assert.NoError(t, service.CheckControl(session, "bob"))
+
+	presence, err = service.ReleaseControl(session.ID, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, protocol.PresenceReleased, presence.Event)
+	assert.Empty(t, presence.Driver)
+	assert.NoError(t, service.CheckControl(session, "alice"))
+
+	// Leaving gives control up
+	_, err = service.RequestControl(session.ID, "bob")
+	require.NoError(t, err)
+	require.NoError(t, service.DetachLongPoll(session.ID, "bob", bob))
+	assert.Eventually(t, func() bool {
+		return service.CheckControl(session, "alice") == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Alice heard about it all
+	var events []string
+	result, err := service.Poll(ctx, session.ID, "alice", alice, 0, time.Second)
+	require.NoError(t, err)
+	for _, raw := range result.Messages {
+		var msg protocol.Message
+		require.NoError(t, json.Unmarshal(raw, &msg))
+		if msg.Type == protocol.TypePresence {
+			var presence protocol.Presence
+			require.NoError(t, msg.Decode(&presence))
+			events = append(events, presence.Event+" "+presence.UserID)
+		}
+	}
+	assert.Subset(t, events, []string{"joined bob", "granted alice", "requested bob", "granted bob", "released bob", "left bob"})
+}
+
+func TestResizePolicy(t *testing.T) {
+	resize := func(service *Service, sessionID, userID, clientID string, cols, rows int) {
+		raw := fmt.Sprintf(`{"type":"resize","data":"{\"cols\":%d,\"rows\":%d}"}`, cols, rows)
+		require.NoError(t, service.PostMessages(context.Background(), sessionID, userID, clientID, []json.RawMessage{json.RawMessage(raw)}))
+	}
+	sizeIs := func(session *Session, cols, rows uint16) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			size, err := pty.GetsizeFull(session.pty)
+			return err == nil && size.Cols == cols && size.Rows == rows
+		}, 2*time.Second, 10*time.Millisecond, "want %dx%d", cols, rows)
+	}
+
+	for _, policy := range []string{"", ResizeDriver} {
+		cfg := config.SessionConfig{
+			MaxSessions:      10,
+			SessionTimeout:   "30m",
+			WorkingDirectory: "/tmp",
+			ResizePolicy:     policy,
+		}
+		service := New(cfg, zap.NewNop())
+		service.SetPermissions(fakePermissions{"bob": AccessWrite, "carol": AccessRead})
+
+		session, err := service.CreateSession(context.Background(), "alice", "cat", "/tmp")
+		require.NoError(t, err)
+		alice, err := service.AttachLongPoll(session.ID, "alice")
+		require.NoError(t, err)
+		bob, err := service.AttachLongPoll(session.ID, "bob")
+		require.NoError(t, err)
+		_, err = service.AttachLongPoll(session.ID, "carol")
+		require.NoError(t, err)
+
+		resize(service, session.ID, "alice", alice, 120, 40)
+		sizeIs(session, 120, 40)
+		resize(service, session.ID, "bob", bob, 60, 50)
+		sizeIs(session, 60, 40)
+
+		if policy == ResizeDriver {
+			// The driver's window wins over a smaller one
+			_, err = service.RequestControl(session.ID, "alice")
+			require.NoError(t, err)
+			sizeIs(session, 120, 40)
+			resize(service, session.ID, "bob", bob, 40, 20)
+			time.Sleep(50 * time.Millisecond)
+			sizeIs(session, 120, 40)
+			_, err = service.ReleaseControl(session.ID, "alice")
+			require.NoError(t, err)
+			sizeIs(session, 40, 20)
+		}
+
+		// The terminal grows back when the smallest window leaves
+		require.NoError(t, service.DetachLongPoll(session.ID, "bob", bob))
+		sizeIs(session, 120, 40)
+		service.KillSession(session.ID)
+	}
+}
+
+func TestSizeNegotiation(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	service.SetPermissions(fakePermissions{"bob": AccessWrite})
+	ctx := context.Background()
+
+	_, err := service.CreateSessionWithOptions(ctx, "alice", CreateOptions{Command: "cat", ResizePolicy: "widest"})
+	assert.ErrorIs(t, err, ErrInvalidResizePolicy)
+
+	session, err := service.CreateSessionWithOptions(ctx, "alice", CreateOptions{
+		Command:      "cat",
+		Cols:         100,
+		Rows:         30,
+		ResizePolicy: ResizeFixed,
+	})
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	alice, err := service.AttachLongPoll(session.ID, "alice")
+	require.NoError(t, err)
+	bob, err := service.AttachLongPoll(session.ID, "bob")
+	require.NoError(t, err)
+
+	// A fixed size ignores the windows
+	raw := `{"type":"resize","data":"{\"cols\":60,\"rows\":20}"}`
+	require.NoError(t, service.PostMessages(ctx, session.ID, "bob", bob, []json.RawMessage{json.RawMessage(raw)}))
+	time.Sleep(50 * time.Millisecond)
+	size, err := service.Size(session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ResizeFixed, size.Policy)
+	assert.Equal(t, uint16(100), size.Cols)
+	assert.Equal(t, uint16(30), size.Rows)
+
+	// Only the owner changes the policy, and only fixed keeps a size
+	_, err = service.SetResizePolicy(session.ID, "bob", ResizeSmallest, 0, 0)
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, err = service.SetResizePolicy(session.ID, "alice", ResizeSmallest, 80, 24)
+	assert.ErrorIs(t, err, ErrInvalidResizePolicy)
+	_, err = service.SetResizePolicy(session.ID, "alice", ResizeFixed, 90, 0)
+	assert.ErrorIs(t, err, ErrInvalidResizePolicy)
+
+	// Back to the configured policy, bob's window counts
+	size, err = service.SetResizePolicy(session.ID, "alice", "", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, ResizeSmallest, size.Policy)
+	assert.Equal(t, uint16(60), size.Cols)
+	assert.Equal(t, uint16(20), size.Rows)
+	pinned, err := service.SetResizePolicy(session.ID, "alice", ResizeFixed, 132, 43)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(132), pinned.Cols)
+	current, err := pty.GetsizeFull(session.pty)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(132), current.Cols)
+	assert.Equal(t, uint16(43), current.Rows)
+
+	var reasons []string
+	for _, change := range pinned.History {
+		reasons = append(reasons, fmt.Sprintf("%s %dx%d %s", change.Reason, change.Cols, change.Rows, change.UserID))
+	}
+	assert.Equal(t, []string{"started 100x30 alice", "policy 60x20 alice", "policy 132x43 alice"}, reasons)
+
+	// Clients hear the size on attach and on every change
+	var sizes []protocol.Size
+	result, err := service.Poll(ctx, session.ID, "alice", alice, 0, time.Second)
+	require.NoError(t, err)
+	for _, raw := range result.Messages {
+		var msg protocol.Message
+		require.NoError(t, json.Unmarshal(raw, &msg))
+		if msg.Type == protocol.TypeSize {
+			var size protocol.Size
+			require.NoError(t, msg.Decode(&size))
+			sizes = append(sizes, size)
+		}
+	}
+	assert.Equal(t, []protocol.Size{
+		{Cols: 100, Rows: 30, Policy: ResizeFixed},
+		{Cols: 60, Rows: 20, Policy: ResizeSmallest, Reason: SizePolicy},
+		{Cols: 132, Rows: 43, Policy: ResizeFixed, Reason: SizePolicy},
+	}, sizes)
+
+	for i := 0; i < maxSizeHistory; i++ {
+		_, err = service.SetResizePolicy(session.ID, "alice", ResizeFixed, uint16(10+i), 10)
+		require.NoError(t, err)
+	}
+	size, err = service.Size(session.ID)
+	require.NoError(t, err)
+	assert.Len(t, size.History, maxSizeHistory)
+	assert.Equal(t, uint16(10), size.History[0].Cols)
+}
+
+func TestScrollback(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.SessionConfig{
+		MaxSessions:       10,
+		SessionTimeout:    "30m",
+		WorkingDirectory:  dir,
+		ScrollbackSize:    16,
+		MaxScrollbackSize: 64,
+		ScrollbackSpill:   true,
+		StopGrace:         "0",
+	}
+	service := New(cfg, zap.NewNop())
+	ctx := context.Background()
+
+	_, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "sleep 30", Scrollback: 65})
+	assert.ErrorIs(t, err, ErrInvalidScrollback)
+
+	session, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "sleep 30"})
+	require.NoError(t, err)
+	assert.Equal(t, 16, session.outputBuf.size)
+	output := "0123456789abcdefghij" + "héllo wörld" + "0123456789"
+	service.feedWaiters(session, []byte(output))
+
+	// The transcript only has what is left in memory
+	transcript, err := service.Transcript(session.ID, "0", "")
+	require.NoError(t, err)
+	assert.True(t, transcript.Truncated)
+	assert.Len(t, transcript.Output, 16)
+
+	// Chunks read from the spill join up, never splitting a character
+	var all string
+	offset := int64(0)
+	for {
+		chunk, err := service.Scrollback(session.ID, offset, 12)
+		require.NoError(t, err)
+		assert.Zero(t, chunk.Start)
+		assert.Equal(t, int64(len(output)), chunk.End)
+		assert.True(t, utf8.ValidString(chunk.Output), "chunk %q", chunk.Output)
+		all += chunk.Output
+		if chunk.Next == chunk.End {
+			break
+		}
+		offset = chunk.Next
+	}
+	assert.Equal(t, output, all)
+
+	chunk, err := service.Scrollback(session.ID, 4, 4)
+	require.NoError(t, err)
+	assert.True(t, chunk.Spilled)
+	assert.Equal(t, "4567", chunk.Output)
+	chunk, err = service.Scrollback(session.ID, int64(len(output))-4, 0)
+	require.NoError(t, err)
+	assert.False(t, chunk.Spilled)
+	assert.Equal(t, "6789", chunk.Output)
+	_, err = service.Scrollback(session.ID, -1, 0)
+	assert.ErrorIs(t, err, ErrInvalidAnchor)
+
+	// The spill goes with the session
+	path := filepath.Join(dir, "scrollback", session.ID+".out")
+	require.FileExists(t, path)
+	service.KillSession(session.ID)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return os.IsNotExist(err)
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Without a spill only memory counts
+	cfg.ScrollbackSpill = false
+	service = New(cfg, zap.NewNop())
+	session, err = service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "sleep 30", Scrollback: 32})
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	service.feedWaiters(session, []byte(output))
+	chunk, err = service.Scrollback(session.ID, 0, 0)
+	require.NoError(t, err)
+	assert.True(t, chunk.Truncated)
+	assert.Equal(t, int64(len(output)-32), chunk.Start)
+	assert.Equal(t, output[len(output)-32:], chunk.Output)
+}
+
+func TestSearchOutput(t *testing.T) {
+	service := New(config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		StopGrace:        "0",
+	}, zap.NewNop())
+	defer service.Shutdown()
+	ctx := context.Background()
+
+	session, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "sleep 30"})
+	require.NoError(t, err)
+	output := "$ make\r\n" +
+		"\x1b]0;building\x07compiling main.go\r\n" +
+		"main.go:12: \x1b[1;31merror\x1b[0m: undefined: foo\r\n" +
+		"progress 50%\rprogress 100%\r\n" +
+		"\x1b[32mERROR\x1b[0m twice"
+	service.feedWaiters(session, []byte(output))
+
+	results, err := service.SearchOutput(session.ID, protocol.Search{Query: "error", IgnoreCase: true})
+	require.NoError(t, err)
+	require.Len(t, results.Matches, 2)
+	assert.Equal(t, 6, results.Lines)
+	assert.Equal(t, int64(len(output)), results.Until)
+
+	// Escape sequences neither split a match nor show in it
+	first := results.Matches[0]
+	assert.Equal(t, 3, first.Line)
+	assert.Equal(t, "error", first.Text)
+	assert.Equal(t, "main.go:12: error: undefined: foo", first.Context)
+	assert.Equal(t, "error", output[first.Offset:first.End])
+
+	second := results.Matches[1]
+	assert.Equal(t, 6, second.Line)
+	assert.Equal(t, "ERROR twice", second.Context)
+	assert.Equal(t, "ERROR", output[second.Offset:second.End])
+
+	// Each redraw of a progress line is a line of its own
+	results, err = service.SearchOutput(session.ID, protocol.Search{Query: "progress", Literal: true, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, results.Matches, 1)
+	assert.True(t, results.Truncated)
+	assert.Equal(t, 4, results.Matches[0].Line)
+	assert.Equal(t, "progress 50%", results.Matches[0].Context)
+
+	// Anchors narrow the output searched; lines count from the first
+	since := strconv.Itoa(strings.Index(output, "main.go:12"))
+	results, err = service.SearchOutput(session.ID, protocol.Search{Query: "error", Since: since})
+	require.NoError(t, err)
+	require.Len(t, results.Matches, 1)
+	assert.Equal(t, 1, results.Matches[0].Line)
+
+	_, err = service.SearchOutput(session.ID, protocol.Search{Query: "("})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+	_, err = service.SearchOutput("missing", protocol.Search{Query: "error"})
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestWriteLog(t *testing.T) {
+	service := New(config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		ScrollbackSize:   16,
+		ScrollbackSpill:  true,
+		StopGrace:        "0",
+	}, zap.NewNop())
+	defer service.Shutdown()
+	ctx := context.Background()
+
+	session, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "sleep 30"})
+	require.NoError(t, err)
+	output := "\x1b]0;build\x07$ make\r\n\x1b[1;31mFAILED\x1b[0m\r\n\x1b[?25hdone\a\r\n"
+	service.feedWaiters(session, []byte(output))
+
+	// Raw is the output as it was, spilled part included
+	var raw bytes.Buffer
+	n, err := service.WriteLog(session.ID, &raw, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(output)), n)
+	assert.Equal(t, output, raw.String())
+
+	var text bytes.Buffer
+	_, err = service.WriteLog(session.ID, &text, true)
+	require.NoError(t, err)
+	assert.Equal(t, "$ make\nFAILED\ndone\n", text.String())
+
+	_, err = service.WriteLog("missing", &text, true)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestEphemeralUsers(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to create users")
+	}
+	if _, err := exec.LookPath("useradd"); err != nil {
+		t.Skip("needs useradd")
+	}
+	// The users need to reach their session directories
+	dir := t.TempDir()
+	require.NoError(t, os.Chmod(filepath.Dir(dir), 0755))
+	require.NoError(t, os.Chmod(dir, 0755))
+	service := New(config.SessionConfig{
+		MaxSessions:         10,
+		SessionTimeout:      "30m",
+		WorkingDirectory:    dir,
+		StopGrace:           "0",
+		EphemeralUsers:      true,
+		EphemeralUserPrefix: "wttest-",
+	}, zap.NewNop())
+	defer service.Shutdown()
+	ctx := context.Background()
+
+	// A background job that leaves the PTY's session still goes, as does
+	// one that keeps forking while it is killed
+	session, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{
+		Command: "setsid sleep 300 & setsid sh -c 'while :; do sleep 300 & done' & echo \"me=$(id -un) home=$HOME\"; sleep 30",
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, session.HostUser)
+	assert.True(t, strings.HasPrefix(session.HostUser, "wttest-"))
+	account, err := user.Lookup(session.HostUser)
+	require.NoError(t, err)
+	assert.NotEqual(t, "0", account.Uid)
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(string(session.outputBuf.Read()), "me="+session.HostUser+" home="+session.WorkingDir)
+	}, 5*time.Second, 10*time.Millisecond)
+	info, err := os.Stat(session.WorkingDir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	// The account and everything it ran go with the session
+	uid := session.hostUser.uid
+	require.NoError(t, service.KillSession(session.ID))
+	assert.Eventually(t, func() bool {
+		_, err := user.Lookup(session.HostUser)
+		return err != nil && len(userProcesses(uid)) == 0
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestShellSelection(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("needs bash")
+	}
+	service := New(config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		AllowedShells:    []string{"bash", "/bin/sh", "no-such-shell"},
+		StopGrace:        "0",
+	}, zap.NewNop())
+	defer service.Shutdown()
+	ctx := context.Background()
+
+	// Names resolve on PATH; paths must be the listed one
+	path, err := service.ResolveShell("bash")
+	require.NoError(t, err)
+	assert.Equal(t, bash, path)
+	path, err = service.ResolveShell("sh")
+	require.NoError(t, err)
+	assert.Equal(t, "/bin/sh", path)
+	path, err = service.ResolveShell("")
+	require.NoError(t, err)
+	assert.Empty(t, path)
+	for _, name := range []string{"zsh", "/tmp/bash", "no-such-shell", "../bin/sh"} {
+		_, err := service.ResolveShell(name)
+		assert.ErrorIs(t, err, ErrShellNotAllowed, name)
+	}
+	_, err = service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "bash", Shell: "zsh"})
+	assert.ErrorIs(t, err, ErrShellNotAllowed)
+
+	// A template's shell is not checked
+	session, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "echo templated", Shell: "/bin/sh", Template: "tpl-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "/bin/sh", session.cmd.Path)
+
+	// Login shells get -l, by request or by default
+	login := true
+	session, err = service.CreateSessionWithOptions(ctx, "user123", CreateOptions{
+		Command: "shopt -q login_shell && echo LOGIN-$((40+2))",
+		Shell:   "bash",
+		Login:   &login,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{bash, "-l", "-c", "shopt -q login_shell && echo LOGIN-$((40+2))"}, session.cmd.Args)
+	require.Eventually(t, func() bool {
+		return strings.Contains(string(session.outputBuf.Read()), "LOGIN-42")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	service.config.LoginShell = true
+	session, err = service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "bash", Shell: "bash"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{bash, "-l"}, session.cmd.Args)
+	login = false
+	session, err = service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "bash", Shell: "bash", Login: &login})
+	require.NoError(t, err)
+	assert.Equal(t, []string{bash}, session.cmd.Args)
+}
+
+func TestTraffic(t *testing.T) {
+	service := New(config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		InputThresholds:  []int64{4, 1 << 20},
+		OutputThresholds: []int64{10},
+		StopGrace:        "0",
+	}, zap.NewNop())
+	defer service.Shutdown()
+	events := make(chan Event, 10)
+	service.OnEvent(func(event Event) {
+		if event.Type == EventTrafficThreshold {
+			events <- event
+		}
+	})
+
+	session, err := service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{Command: "cat"})
+	require.NoError(t, err)
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte("hello\n")))
+	// The PTY's echo and cat's copy
+	require.Eventually(t, func() bool {
+		_, output := session.Traffic()
+		return output >= int64(len("hello\r\nhello\r\n"))
+	}, 5*time.Second, 20*time.Millisecond)
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte("x\n")))
+	input, _ := session.Traffic()
+	assert.Equal(t, int64(len("hello\nx\n")), input)
+
+	// Each threshold fires once, when it is passed
+	crossed := map[string]string{}
+	for range 2 {
+		select {
+		case event := <-events:
+			crossed[event.Detail["direction"]] = event.Detail["threshold"]
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for traffic event")
+		}
+	}
+	assert.Equal(t, map[string]string{TrafficInput: "4", TrafficOutput: "10"}, crossed)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	data, err := json.Marshal(session)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"input_bytes":8`)
+
+	var buf strings.Builder
+	require.NoError(t, service.WriteMetrics(&buf))
+	assert.Contains(t, buf.String(), fmt.Sprintf("webtunnel_session_input_bytes_total{session=%q,user=\"user123\"} 8", session.ID))
+	buf.Reset()
+	require.NoError(t, service.WriteOpenMetrics(&buf))
+	assert.Contains(t, buf.String(), "# TYPE webtunnel_session_output_bytes counter")
+}
+
+func TestUserEnv(t *testing.T) {
+	service := New(config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		EnvironmentVars:  map[string]string{"STAGE": "prod", "REGION": "eu"},
+		AllowedEnvVars:   []string{"STAGE", "LC_*", "LD_*", "WEBTUNNEL_*"},
+		BlockedEnvVars:   []string{"LD_*"},
+		StopGrace:        "0",
+	}, zap.NewNop())
+	defer service.Shutdown()
+	ctx := context.Background()
+
+	// The user's variables win over the configured ones
+	session, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{
+		Command: `echo "env=$STAGE/$REGION/$LC_TIME"`,
+		UserEnv: map[string]string{"STAGE": "dev", "LC_TIME": "C"},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return strings.Contains(string(session.outputBuf.Read()), "env=dev/eu/C")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	for name, vars := range map[string]map[string]string{
+		"not allowed": {"REGION": "us"},
+		"blocked":     {"LD_PRELOAD": "/tmp/evil.so"},
+		"reserved":    {"WEBTUNNEL_SESSION_ID": "other"},
+		"bad name":    {"LC_TIME=C LC": "x"},
+		"NUL":         {"LC_ALL": "C\x00"},
+	} {
+		_, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "true", UserEnv: vars})
+		assert.ErrorIs(t, err, ErrEnvNotAllowed, name)
+	}
+}
+
+type fakeReclaimer chan string
+
+func (f fakeReclaimer) Reclaim(sessionID, dir string) {
+	f <- dir
+}
+
+func TestEndSession(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		StopGrace:        "0s",
+	}
+	service := New(cfg, zap.NewNop())
+	reclaimed := make(fakeReclaimer, 1)
+	service.SetReclaimer(reclaimed)
+
+	session, err := service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{Command: "cat"})
+	require.NoError(t, err)
+	require.NoError(t, service.EndSession(session.ID, ExitDiskQuota))
+	<-session.Done()
+	reason, _ := session.ExitReason()
+	assert.Equal(t, ExitDiskQuota, reason)
+	assert.False(t, service.SessionExists(session.ID))
+
+	// The directory goes to the reclaimer once the processes are gone
+	select {
+	case dir := <-reclaimed:
+		assert.Equal(t, session.WorkingDir, dir)
+	case <-time.After(5 * time.Second):
+		t.Fatal("directory was not reclaimed")
+	}
+	assert.Error(t, service.EndSession(session.ID, ExitDiskQuota))
+}
+
+func TestTitleParsing(t *testing.T) {
+	var titling titling
+	titling.scan([]byte("plain output"))
+	assert.Empty(t, titling.title)
+
+	// Split across reads, ended by BEL
+	titling.scan([]byte("\x1b]0;vim ma"))
+	titling.scan([]byte("in.go\x07$ "))
+	assert.Equal(t, "vim main.go", titling.title)
+
+	// Ended by ST, with control characters dropped
+	titling.scan([]byte("\x1b]2;build\tlog\x1b\\"))
+	assert.Equal(t, "buildlog", titling.title)
+
+	// Hyperlinks and other OSC strings leave it alone
+	titling.scan([]byte("\x1b]8;;https://example.com\x07link\x1b]8;;\x07"))
+	assert.Equal(t, "buildlog", titling.title)
+
+	titling.scan([]byte("\x1b]0;" + strings.Repeat("é", maxTitle) + "\x07"))
+	assert.Len(t, titling.title, maxTitle)
+}
+
+func TestTitle(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	session, err := service.CreateSession(context.Background(), "user123", "bash", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	err = service.SendInput(context.Background(), session.ID, []byte("printf '\\033]0;my title\\007'; sleep 30\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return session.Title() == "my title" && session.CurrentCommand() == "sleep 30"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	data, err := json.Marshal(session)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"title":"my title"`)
+	assert.Contains(t, string(data), `"current_command":"sleep 30"`)
+
+	// Interrupting it hands the foreground back to the shell
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte{3}))
+	require.Eventually(t, func() bool {
+		return session.CurrentCommand() == "bash"
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestSessionEnded(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		EndedGrace:       "1s",
+	}
+	service := New(cfg, zap.NewNop())
+	ctx := context.Background()
+
+	var exits []Event
+	var mu sync.Mutex
+	service.OnEvent(func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		exits = append(exits, event)
+	})
+
+	session, err := service.CreateSession(ctx, "user123", "sleep 0.2; exit 3", "/tmp")
+	require.NoError(t, err)
+	clientID, err := service.AttachLongPoll(session.ID, "user123")
+	require.NoError(t, err)
+	<-session.Done()
+
+	// Attached clients get the exit and how long the session ran
+	var ended *protocol.SessionEnded
+	require.Eventually(t, func() bool {
+		result, err := service.Poll(ctx, session.ID, "user123", clientID, 0, 100*time.Millisecond)
+		if err != nil {
+			return false
+		}
+		for _, raw := range result.Messages {
+			var msg protocol.Message
+			require.NoError(t, json.Unmarshal(raw, &msg))
+			if msg.Type == protocol.TypeSessionEnded {
+				ended = &protocol.SessionEnded{}
+				require.NoError(t, msg.Decode(ended))
+			}
+		}
+		return ended != nil
+	}, 5*time.Second, 50*time.Millisecond)
+	require.NotNil(t, ended.Code)
+	assert.Equal(t, 3, *ended.Code)
+	assert.Equal(t, string(ExitNormal), ended.Reason)
+	assert.GreaterOrEqual(t, ended.Duration, 0.2)
+	require.NotNil(t, ended.ViewableUntil)
+	assert.WithinDuration(t, ended.EndedAt.Add(time.Second), *ended.ViewableUntil, time.Millisecond)
+
+	// Its output stays viewable for the grace period, then it is removed
+	_, exists := service.GetSession(session.ID)
+	assert.True(t, exists)
+	require.Eventually(t, func() bool {
+		_, exists := service.GetSession(session.ID)
+		return !exists
+	}, 5*time.Second, 50*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, exits, 1)
+	assert.Equal(t, "3", exits[0].Detail["exit_code"])
+	assert.NotEmpty(t, exits[0].Detail["duration"])
+}
+
+func TestTranscoder(t *testing.T) {
+	_, _, err := lookupCharset("klingon")
+	assert.ErrorIs(t, err, ErrInvalidCharset)
+	for _, name := range []string{"", "utf-8", "UTF-8"} {
+		charset, transcoder, err := lookupCharset(name)
+		require.NoError(t, err, name)
+		assert.Empty(t, charset)
+		assert.Nil(t, transcoder)
+	}
+
+	charset, transcoder, err := lookupCharset("shift_jis")
+	require.NoError(t, err)
+	assert.Equal(t, "Shift_JIS", charset)
+
+	// 日本 split in the middle of 本 comes out whole
+	assert.Equal(t, "a日", string(transcoder.decode([]byte{'a', 0x93, 0xfa, 0x96})))
+	assert.Equal(t, "本b", string(transcoder.decode([]byte{0x7b, 'b'})))
+	assert.Equal(t, []byte{0x93, 0xfa, 0x96, 0x7b}, transcoder.encode([]byte("日本")))
+}
+
+func TestCharset(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	ctx := context.Background()
+
+	_, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{Command: "bash", Charset: "klingon"})
+	assert.ErrorIs(t, err, ErrInvalidCharset)
+
+	// The program gets ISO-8859-1 and clients see UTF-8 both ways
+	session, err := service.CreateSessionWithOptions(ctx, "user123", CreateOptions{
+		Command:    "printf 'caf\\351\\n'; od -An -tx1",
+		WorkingDir: "/tmp",
+		Charset:    "latin1",
+	})
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	assert.Equal(t, "ISO-8859-1", session.Charset)
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(string(session.outputBuf.Read()), "café")
+	}, 5*time.Second, 50*time.Millisecond)
+	require.NoError(t, service.SendInput(ctx, session.ID, []byte("é\n\x04")))
+	require.Eventually(t, func() bool {
+		return strings.Contains(string(session.outputBuf.Read()), "e9 0a")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	data, err := json.Marshal(session)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"charset":"ISO-8859-1"`)
+}
+
+func TestActivity(t *testing.T) {
+	var a activity
+	start := time.Unix(1700000000, 0)
+	a.output(500, start)
+	a.output(500, start.Add(time.Second))
+	rate, last := a.rate(start.Add(2 * time.Second))
+	assert.Equal(t, 100.0, rate)
+	assert.Equal(t, start.Add(time.Second), last)
+	rate, _ = a.rate(start.Add(activityWindow * time.Second))
+	assert.Equal(t, 50.0, rate, "the first second has left the window")
+	rate, _ = a.rate(start.Add(time.Minute))
+	assert.Zero(t, rate)
+
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	ctx := context.Background()
+	quiet, err := service.CreateSession(ctx, "user123", "sleep 30", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(quiet.ID)
+	busy, err := service.CreateSession(ctx, "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(busy.ID)
+
+	_, err = service.AttachLongPoll(busy.ID, "user123")
+	require.NoError(t, err)
+	require.NoError(t, service.SendInput(ctx, busy.ID, []byte("hello\n")))
+	assert.Eventually(t, func() bool {
+		return busy.Activity().OutputBytesPerSec > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	activity := busy.Activity()
+	assert.Equal(t, 1, activity.Viewers)
+	require.NotNil(t, activity.LastOutputAt)
+	assert.Zero(t, quiet.Activity().Viewers)
+
+	summaries := Summaries([]*Session{quiet, busy})
+	require.Len(t, summaries, 2)
+	assert.Equal(t, busy.ID, summaries[0].ID, "busiest first")
+	assert.Equal(t, StatusRunning, summaries[0].Status)
+
+	data, err := json.Marshal(busy)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"viewers":1`)
+	assert.Contains(t, string(data), `"last_output_at"`)
+}
+
+func TestNegotiate(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	info := NegotiationInfo{UserID: "user123", SessionID: "s1", Transport: "websocket", UserAgent: "webtunnel-go"}
+
+	n, err := service.Negotiate([]string{"chat", "webtunnel.v1"}, info)
+	require.NoError(t, err)
+	assert.Equal(t, protocol.Version1, n.Version)
+	assert.Equal(t, "webtunnel.v1", n.Subprotocol())
+	assert.Empty(t, n.Reason)
+
+	// A client ahead of the server gets the newest version both speak
+	n, err = service.Negotiate([]string{"webtunnel.v2", "webtunnel.v1"}, info)
+	require.NoError(t, err)
+	assert.Equal(t, protocol.Version1, n.Version)
+	assert.Equal(t, []int{2, 1}, n.Offered)
+	assert.Equal(t, DowngradeUnsupported, n.Reason)
+
+	info.Transport = "long-poll"
+	n, err = service.Negotiate(nil, info)
+	require.NoError(t, err)
+	assert.Equal(t, protocol.Version0, n.Version)
+	assert.Empty(t, n.Subprotocol())
+	assert.Equal(t, DowngradeNoOffer, n.Reason)
+
+	// Retiring clients that do not negotiate
+	service.config.MinProtocolVersion = protocol.Version1
+	_, err = service.Negotiate(nil, info)
+	assert.ErrorIs(t, err, ErrProtocolTooOld)
+	assert.Equal(t, protocol.CodeProtocolTooOld, AttachErrorCode(err))
+	service.config.MinProtocolVersion = 9
+	assert.Equal(t, protocol.LatestVersion, service.MinProtocolVersion(), "never above what the server speaks")
+
+	report := service.ProtocolReport()
+	assert.Equal(t, map[string]int64{"v0": 1, "v1": 2}, report.Negotiated)
+	assert.Equal(t, map[string]int64{DowngradeNoOffer: 2, DowngradeUnsupported: 1}, report.Downgrades)
+	assert.Equal(t, int64(1), report.Refused)
+	require.Len(t, report.Recent, 3)
+	assert.True(t, report.Recent[0].Refused, "newest first")
+	assert.Equal(t, "webtunnel-go", report.Recent[2].UserAgent)
+
+	var metrics strings.Builder
+	require.NoError(t, service.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), `webtunnel_protocol_negotiations_total{transport="websocket",version="1"} 2`)
+	assert.Contains(t, metrics.String(), `webtunnel_protocol_negotiations_total{transport="long-poll",version="0"} 1`)
+	assert.Contains(t, metrics.String(), `webtunnel_protocol_downgrades_total{reason="no_offer"} 2`)
+	assert.Contains(t, metrics.String(), `webtunnel_protocol_refused_total 1`)
+}
+
+func TestIdlePolicy(t *testing.T) {
+	ctx := context.Background()
+	newService := func(policy string) (*Service, *Session) {
+		service := New(config.SessionConfig{
+			MaxSessions:      10,
+			SessionTimeout:   "30m",
+			IdleWarning:      "10m",
+			IdlePolicy:       policy,
+			WorkingDirectory: "/tmp",
+		}, zap.NewNop())
+		session, err := service.CreateSession(ctx, "alice", "cat", "/tmp")
+		require.NoError(t, err)
+		t.Cleanup(func() { service.KillSession(session.ID) })
+		session.CreatedAt = time.Now().Add(-2 * time.Hour)
+		return service, session
+	}
+	now := time.Now()
+	recent, old := now.Add(-time.Minute), now.Add(-time.Hour)
+
+	service, session := newService(IdleOutput)
+	session.activity.mu.Lock()
+	session.activity.last, session.activity.lastInput = old, recent
+	session.activity.mu.Unlock()
+	assert.Equal(t, old, service.idleSince(session, now), "typing does not keep it alive")
+
+	service, session = newService(IdleInput)
+	session.activity.mu.Lock()
+	session.activity.last, session.activity.lastInput = recent, old
+	session.activity.mu.Unlock()
+	assert.Equal(t, old, service.idleSince(session, now), "output does not keep it alive")
+
+	service, session = newService(IdleActivity)
+	session.LastActive = recent
+	assert.Equal(t, recent, service.idleSince(session, now))
+
+	// Never-attached sessions count from their start; attached ones are busy
+	service, session = newService(IdleDetached)
+	assert.Equal(t, session.CreatedAt, service.idleSince(session, now))
+	id, err := service.AttachLongPoll(session.ID, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, now, service.idleSince(session, now))
+
+	// Attached clients are told before it is terminated
+	session.LastActive = now
+	service.config.IdlePolicy = IdleInput
+	session.activity.mu.Lock()
+	session.activity.lastInput = now.Add(-25 * time.Minute)
+	session.activity.mu.Unlock()
+	service.CleanupStaleSessions()
+	var notice string
+	var cursor uint64
+	deadline := time.Now().Add(5 * time.Second)
+	for notice == "" && time.Now().Before(deadline) {
+		result, err := service.Poll(ctx, session.ID, "alice", id, cursor, time.Second)
+		require.NoError(t, err)
+		for _, raw := range result.Messages {
+			var msg protocol.Message
+			require.NoError(t, json.Unmarshal(raw, &msg))
+			if msg.Type == protocol.TypeNotice {
+				notice = msg.Data
+			}
+		}
+		cursor = result.Cursor
+	}
+	assert.Contains(t, notice, "idle (no input) for 25m0s and will be terminated in 5m0s")
+
+	session.activity.mu.Lock()
+	session.activity.lastInput = now.Add(-31 * time.Minute)
+	session.activity.mu.Unlock()
+	service.CleanupStaleSessions()
+	_, exists := service.GetSession(session.ID)
+	assert.False(t, exists)
+}
+
+func TestTerminate(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		StopGrace:        "500ms",
+	}
+	service := New(cfg, zap.NewNop())
+	dir := t.TempDir()
+	saved := filepath.Join(dir, "saved")
+
+	// The shell saves its state on SIGTERM; a job it started ignores
+	// everything but SIGKILL
+	command := fmt.Sprintf(`trap "echo saved > %s; exit 0" TERM HUP; (trap "" TERM HUP; exec sleep 300) & sleep 300 & wait`, saved)
+	session, err := service.CreateSession(context.Background(), "alice", command, "/tmp")
+	require.NoError(t, err)
+	pid := session.cmd.Process.Pid
+	require.Eventually(t, func() bool {
+		members, _ := sessionMembers(pid)
+		return len(members) == 3
+	}, 5*time.Second, 10*time.Millisecond, "the shell and two sleeps")
+
+	start := time.Now()
+	require.NoError(t, service.KillSession(session.ID))
+	assert.Eventually(t, func() bool {
+		data, _ := os.ReadFile(saved)
+		return string(data) == "saved\n"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		members, _ := sessionMembers(pid)
+		return len(members) == 0
+	}, 5*time.Second, 10*time.Millisecond, "the job that ignored the signals is killed too")
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond, "once the grace period is over")
+}
+
+type fakeInputs struct {
+	mu     sync.Mutex
+	inputs []string
+}
+
+func (f *fakeInputs) RecordInput(sessionID, userID string, input []byte, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inputs = append(f.inputs, userID+": "+string(input))
+}
+
+func (f *fakeInputs) recorded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.inputs...)
+}
+
+func TestInputRecorder(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	service.SetPermissions(fakePermissions{"bob": AccessWrite})
+	inputs := &fakeInputs{}
+	service.SetInputRecorder(inputs)
+	ctx := context.Background()
+
+	session, err := service.CreateSession(ctx, "alice", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	require.NoError(t, service.SendInput(ctx, session.ID, []byte("by the owner\n")))
+	bob, err := service.AttachLongPoll(session.ID, "bob")
+	require.NoError(t, err)
+	require.NoError(t, service.PostMessages(ctx, session.ID, "bob", bob, []json.RawMessage{
+		json.RawMessage(`{"type":"input","data":"from bob\n"}`),
+	}))
+
+	assert.Eventually(t, func() bool { return len(inputs.recorded()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"alice: by the owner\n", "bob: from bob\n"}, inputs.recorded())
+}
+
+type fakePermissions map[string]Access
+
+func (f fakePermissions) SessionGrant(userID string) Access {
+	return f[userID]
+}
+
+func TestAttachErrors(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	service.SetPermissions(fakePermissions{})
+
+	session, err := service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	_, err = service.AttachLongPoll("missing", "user123")
+	assert.Equal(t, protocol.CodeSessionNotFound, AttachErrorCode(err))
+	_, err = service.AttachLongPoll(session.ID, "mallory")
+	assert.Equal(t, protocol.CodeForbidden, AttachErrorCode(err))
+	_, err = service.AttachLongPollSince(session.ID, "user123", "nope")
+	assert.Equal(t, protocol.CodeInvalidAnchor, AttachErrorCode(err))
+
+	session.setStatus(StatusStopped)
+	_, err = service.AttachLongPoll(session.ID, "user123")
+	assert.ErrorIs(t, err, ErrSessionExited)
+	assert.Equal(t, protocol.CodeSessionExited, AttachErrorCode(err))
+}
+
+func TestAccess(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	service.SetPermissions(fakePermissions{"auditor": AccessRead, "admin": AccessWrite})
+
+	session, err := service.CreateSession(context.Background(), "owner", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	for _, tc := range []struct {
+		user   string
+		access Access
+	}{
+		{"owner", AccessWrite},
+		{"admin", AccessWrite},
+		{"auditor", AccessRead},
+		{"stranger", AccessNone},
+	} {
+		assert.Equal(t, tc.access, service.Access(session, tc.user), tc.user)
+	}
+
+	_, err = service.CheckAccess(session.ID, "auditor", AccessWrite)
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, err = service.CheckAccess("missing", "owner", AccessRead)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	assert.Len(t, service.ListReadableSessions("auditor"), 1)
+	assert.Empty(t, service.ListReadableSessions("stranger"))
+
+	_, err = service.AttachLongPoll(session.ID, "stranger")
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	// A watch-only connection sees output but may not type
+	clientID, err := service.AttachLongPoll(session.ID, "auditor")
+	require.NoError(t, err)
+	require.NoError(t, service.PostMessages(context.Background(), session.ID, "auditor", clientID, []json.RawMessage{
+		json.RawMessage(`{"type":"input","data":"from-auditor\n"}`),
+	}))
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte("from-owner\n")))
+
+	var output, errs strings.Builder
+	var cursor uint64
+	deadline := time.Now().Add(5 * time.Second)
+	for !(strings.Contains(output.String(), "from-owner") && errs.Len() > 0) && time.Now().Before(deadline) {
+		result, err := service.Poll(context.Background(), session.ID, "auditor", clientID, cursor, time.Second)
+		require.NoError(t, err)
+		for _, raw := range result.Messages {
+			var msg protocol.Message
+			require.NoError(t, json.Unmarshal(raw, &msg))
+			if msg.Type == protocol.TypeError {
+				errs.WriteString(msg.Data)
+			} else {
+				output.WriteString(msg.Data)
+			}
+		}
+		cursor = result.Cursor
+	}
+	assert.Contains(t, output.String(), "from-owner")
+	assert.NotContains(t, output.String(), "from-auditor")
+	assert.Contains(t, errs.String(), "Read-only access")
+
+	// The owner can watch read-only too, and is refused the same way
+	ownerID, err := service.AttachLongPollWith(session.ID, "owner", AttachOptions{ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, service.PostMessages(context.Background(), session.ID, "owner", ownerID, []json.RawMessage{
+		json.RawMessage(`{"type":"resize","data":"{\"cols\":100,\"rows\":30}"}`),
+	}))
+	errs.Reset()
+	cursor = 0
+	deadline = time.Now().Add(5 * time.Second)
+	for !strings.Contains(errs.String(), "resize is disabled") && time.Now().Before(deadline) {
+		result, err := service.Poll(context.Background(), session.ID, "owner", ownerID, cursor, time.Second)
+		require.NoError(t, err)
+		for _, raw := range result.Messages {
+			var msg protocol.Message
+			require.NoError(t, json.Unmarshal(raw, &msg))
+			if msg.Type == protocol.TypeError {
+				errs.WriteString(msg.Data)
+			}
+		}
+		cursor = result.Cursor
+	}
+	assert.Contains(t, errs.String(), "resize is disabled")
+
+	// A share link grants its guest what the owner chose, and revoking it
+	// disconnects them
+	guestID, err := service.AttachLongPollWith(session.ID, "share:abc", AttachOptions{shared: AccessWrite})
+	require.NoError(t, err)
+	assert.Equal(t, 0, service.Disconnect(session.ID, "revoked", func(userID string) bool { return userID == "share:other" }))
+	assert.Equal(t, 1, service.Disconnect(session.ID, "revoked", func(userID string) bool { return userID == "share:abc" }))
+	deadline = time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := service.Poll(context.Background(), session.ID, "share:abc", guestID, 0, 100*time.Millisecond); err != nil {
+			break
+		}
+	}
+	_, err = service.Poll(context.Background(), session.ID, "share:abc", guestID, 0, 0)
+	assert.ErrorIs(t, err, ErrPollClientNotFound)
+
+	// Presenting does not open the session to everyone
+	require.NoError(t, service.SetPresentation(session.ID, "owner", true))
+	assert.Equal(t, AccessNone, service.Access(session, "stranger"))
+	assert.Equal(t, AccessRead, service.Access(session, "auditor"))
+}
+
+func TestBookmarks(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+
+	session, err := service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+
+	service.feedWaiters(session, []byte("setup done\r\n"))
+	before, err := service.Mark(session.ID, "user123", "before-migration")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), before.Offset)
+	service.feedWaiters(session, []byte("migrating FAILED\r\n"))
+	_, err = service.Mark(session.ID, "user123", "after-migration")
+	require.NoError(t, err)
+	service.feedWaiters(session, []byte("cleanup\r\n"))
+
+	_, err = service.Mark(session.ID, "user123", "before-migration")
+	assert.ErrorIs(t, err, ErrBookmarkExists)
+	for _, name := range []string{"", "1st", "has space", strings.Repeat("a", 65)} {
+		_, err = service.Mark(session.ID, "user123", name)
+		assert.ErrorIs(t, err, ErrInvalidBookmark, name)
+	}
+
+	bookmarks, err := service.Bookmarks(session.ID)
+	require.NoError(t, err)
+	require.Len(t, bookmarks, 2)
+	assert.Equal(t, "after-migration", bookmarks[1].Name)
+
+	transcript, err := service.Transcript(session.ID, "before-migration", "after-migration")
+	require.NoError(t, err)
+	assert.Equal(t, "migrating FAILED\r\n", transcript.Output)
+	assert.False(t, transcript.Truncated)
+
+	transcript, err = service.Transcript(session.ID, "after-migration", "")
+	require.NoError(t, err)
+	assert.Equal(t, "cleanup\r\n", transcript.Output)
+
+	transcript, err = service.Transcript(session.ID, "", "6")
+	require.NoError(t, err)
+	assert.Equal(t, "setup ", transcript.Output)
+
+	_, err = service.Transcript(session.ID, "after-migration", "before-migration")
+	assert.ErrorIs(t, err, ErrInvalidAnchor)
+	_, err = service.Transcript(session.ID, "nope", "")
+	assert.ErrorIs(t, err, ErrInvalidAnchor)
+
+	// Searches only see the range
+	results, err := service.Search(session.ID, protocol.Search{Query: "FAILED", Since: "after-migration"})
+	require.NoError(t, err)
+	assert.Empty(t, results.Matches)
+	results, err = service.Search(session.ID, protocol.Search{Query: "FAILED", Since: "before-migration", Until: "after-migration"})
+	require.NoError(t, err)
+	assert.Len(t, results.Matches, 1)
+
+	// Replays start at the anchor, and marks reach attached clients
+	_, err = service.AttachLongPollSince(session.ID, "user123", "nope")
+	assert.ErrorIs(t, err, ErrInvalidAnchor)
+	clientID, err := service.AttachLongPollSince(session.ID, "user123", "after-migration")
+	require.NoError(t, err)
+	defer service.DetachLongPoll(session.ID, "user123", clientID)
+	require.NoError(t, service.PostMessages(context.Background(), session.ID, "user123", clientID, []json.RawMessage{
+		json.RawMessage(`{"type":"mark","data":"checkpoint"}`),
+	}))
+
+	var output strings.Builder
+	var marked *protocol.Bookmark
+	var cursor uint64
+	deadline := time.Now().Add(5 * time.Second)
+	for marked == nil && time.Now().Before(deadline) {
+		result, err := service.Poll(context.Background(), session.ID, "user123", clientID, cursor, time.Second)
+		require.NoError(t, err)
+		for _, raw := range result.Messages {
+			var msg protocol.Message
+			require.NoError(t, json.Unmarshal(raw, &msg))
+			switch msg.Type {
+			case protocol.TypeOutput:
+				output.WriteString(msg.Data)
+			case protocol.TypeBookmark:
+				marked = &protocol.Bookmark{}
+				require.NoError(t, msg.Decode(marked))
+			}
+		}
+		cursor = result.Cursor
+	}
+	assert.Contains(t, output.String(), "cleanup")
+	assert.NotContains(t, output.String(), "FAILED")
+	require.NotNil(t, marked)
+	assert.Equal(t, "checkpoint", marked.Name)
+}
+
+func TestRecording(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+		RecordSessions:   true,
+		RecordingDir:     t.TempDir(),
+	}
+	service := New(cfg, zap.NewNop())
+
+	session, err := service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	path, err := service.Recording(session.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, service.SendInput(context.Background(), session.ID, []byte("recorded\n")))
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(path)
+		return strings.Contains(string(data), "recorded")
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, service.Resize(session.ID, 100, 30))
+	require.NoError(t, service.KillSession(session.ID))
+
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(path)
+		return strings.Contains(string(data), `"r","100x30"`)
+	}, 5*time.Second, 10*time.Millisecond)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	var header castHeader
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+	assert.Equal(t, 2, header.Version)
+	assert.Equal(t, uint16(80), header.Width)
+	assert.Equal(t, uint16(24), header.Height)
+	for _, line := range lines[1:] {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event), line)
+		require.Len(t, event, 3)
+	}
+
+	// Without the flag nothing is recorded
+	service = New(config.SessionConfig{MaxSessions: 10, SessionTimeout: "30m", WorkingDirectory: "/tmp"}, zap.NewNop())
+	session, err = service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	_, err = service.Recording(session.ID)
+	assert.ErrorIs(t, err, ErrNoRecording)
+}
+
+func TestRecorderSplitsUTF8(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "cast"))
+	require.NoError(t, err)
+	r := &recorder{path: file.Name(), file: file, start: time.Now(), logger: zap.NewNop()}
+
+	euro := []byte("€")
+	r.output(append([]byte("price "), euro[:1]...))
+	r.output(euro[1:])
+	r.close()
+
+	data, err := os.ReadFile(r.path)
+	require.NoError(t, err)
+	var text strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		text.WriteString(event[2].(string))
+	}
+	assert.Equal(t, "price €", text.String())
+}
+
+func TestSessionState(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+
+	// The exit notification comes after the last of the output
+	exits := make(chan string, 1)
+	service.OnEvent(func(event Event) {
+		if event.Type == EventSessionExited {
+			session, _ := service.GetSession(event.SessionID)
+			exits <- string(session.outputBuf.Read()) + "|" + event.Detail["exit_code"]
+		}
+	})
+
+	session, err := service.CreateSession(context.Background(), "user123", "echo last-words; exit 3", "/tmp")
+	require.NoError(t, err)
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not stop")
+	}
+	assert.Equal(t, StatusStopped, session.Status())
+	code, ok := session.ExitCode()
+	assert.True(t, ok)
+	assert.Equal(t, 3, code)
+	select {
+	case exit := <-exits:
+		assert.Contains(t, exit, "last-words")
+		assert.True(t, strings.HasSuffix(exit, "|3"), exit)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no exit event")
+	}
+
+	data, err := json.Marshal(session)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"status":"stopped"`)
+	assert.Contains(t, string(data), `"exit_code":3`)
+
+	// Killed sessions stop without an exit code
+	session, err = service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	require.NoError(t, service.KillSession(session.ID))
+	<-session.Done()
+	assert.Equal(t, StatusStopped, session.Status())
+	_, ok = session.ExitCode()
+	assert.False(t, ok)
+	reason, _ := session.ExitReason()
+	assert.Equal(t, ExitKilled, reason)
+}
+
+func TestExitReasons(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: "/tmp",
+	}
+	service := New(cfg, zap.NewNop())
+	wait := func(session *Session) {
+		t.Helper()
+		select {
+		case <-session.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("session did not stop")
+		}
+	}
+
+	session, err := service.CreateSession(context.Background(), "user123", "exit 0", "/tmp")
+	require.NoError(t, err)
+	wait(session)
+	reason, signal := session.ExitReason()
+	assert.Equal(t, ExitNormal, reason)
+	assert.Empty(t, signal)
+
+	// The shell execs a lone command, so the signal ends the process itself
+	session, err = service.CreateSession(context.Background(), "user123", "kill -TERM $$", "/tmp")
+	require.NoError(t, err)
+	wait(session)
+	reason, signal = session.ExitReason()
+	assert.Equal(t, ExitSignal, reason)
+	assert.Equal(t, "SIGTERM", signal)
+	code, ok := session.ExitCode()
+	assert.True(t, ok)
+	assert.Equal(t, -1, code)
+
+	// Idle sessions are timed out
+	session, err = service.CreateSession(context.Background(), "user123", "cat", "/tmp")
+	require.NoError(t, err)
+	session.LastActive = time.Now().Add(-time.Hour)
+	service.CleanupStaleSessions()
+	wait(session)
+	reason, _ = session.ExitReason()
+	assert.Equal(t, ExitTimeout, reason)
+	_, ok = session.ExitCode()
+	assert.False(t, ok)
+}
+
+type fakeStore struct {
+	records map[string]SessionRecord
+	mu      sync.Mutex
+}
+
+func (f *fakeStore) SaveSession(_ context.Context, record SessionRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records[record.ID] = record
+	return nil
+}
+
+func (f *fakeStore) SessionHistory(_ context.Context, userID string) ([]SessionRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []SessionRecord
+	for _, record := range f.records {
+		if record.UserID == userID {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeStore) RunningSessions(_ context.Context) ([]SessionRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []SessionRecord
+	for _, record := range f.records {
+		if record.Status == StatusRunning {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeStore) get(id string) SessionRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.records[id]
+}
+
+func TestSessionStore(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+	}
+	store := &fakeStore{records: make(map[string]SessionRecord)}
+	service := New(cfg, zap.NewNop())
+	service.SetSessionStore(store)
+
+	// Ended sessions keep their final status and exit code
+	session, err := service.CreateSession(context.Background(), "user123", "exit 3", "")
+	require.NoError(t, err)
+	<-session.Done()
+	record := store.get(session.ID)
+	assert.Equal(t, StatusStopped, record.Status)
+	require.NotNil(t, record.ExitCode)
+	assert.Equal(t, 3, *record.ExitCode)
+	assert.NotNil(t, record.EndedAt)
+	history, err := service.History(context.Background(), "user123")
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	// Sessions cut off by shutdown stay running in the store
+	session, err = service.CreateSession(context.Background(), "user123", "cat", "")
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, store.get(session.ID).Status)
+	require.NoError(t, os.WriteFile(filepath.Join(session.WorkingDir, "kept"), []byte("x"), 0644))
+	service.Shutdown()
+	<-session.Done()
+	assert.Equal(t, StatusRunning, store.get(session.ID).Status)
+
+	// The next server relaunches them under the same ID and directory
+	cfg.RelaunchOnRestart = true
+	restarted := New(cfg, zap.NewNop())
+	restarted.SetSessionStore(store)
+	restarted.Restore(context.Background())
+	relaunched, exists := restarted.GetSession(session.ID)
+	require.True(t, exists)
+	defer restarted.KillSession(session.ID)
+	assert.Equal(t, session.WorkingDir, relaunched.WorkingDir)
+	assert.FileExists(t, filepath.Join(relaunched.WorkingDir, "kept"))
+	assert.Equal(t, StatusRunning, relaunched.Status())
+
+	// Without relaunching they are marked interrupted
+	restarted.Shutdown()
+	<-relaunched.Done()
+	cfg.RelaunchOnRestart = false
+	restarted = New(cfg, zap.NewNop())
+	restarted.SetSessionStore(store)
+	restarted.Restore(context.Background())
+	_, exists = restarted.GetSession(session.ID)
+	assert.False(t, exists)
+	assert.Equal(t, StatusInterrupted, store.get(session.ID).Status)
+
+	// Without a store there is no history
+	_, err = New(cfg, zap.NewNop()).History(context.Background(), "user123")
+	assert.ErrorIs(t, err, ErrNoSessionStore)
+}
+
+// fakeSandbox marks wrapped processes through the environment
+type fakeSandbox map[string]string
+
+func (f fakeSandbox) Profile(userID string) (string, error) {
+	return f[userID], nil
+}
+
+func (f fakeSandbox) Wrap(profile string, argv []string) ([]string, error) {
+	return append([]string{"env", "SANDBOX_PROFILE=" + profile}, argv...), nil
+}
+
+func TestSandbox(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      10,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+		Prewarm:          []config.PrewarmPool{{Command: "bash", Size: 1}},
+	}
+	service := New(cfg, zap.NewNop())
+	service.SetSandbox(fakeSandbox{"alice": "strict"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.Prewarm(ctx)
+
+	session, err := service.CreateSession(context.Background(), "alice", "echo profile=$SANDBOX_PROFILE", "")
+	require.NoError(t, err)
+	<-session.Done()
+	assert.Equal(t, "strict", session.Sandbox)
+	assert.Contains(t, string(session.outputBuf.Read()), "profile=strict")
+
+	// Confined users never get a process started ahead of time
+	idle := func() int {
+		service.prewarm.mu.Lock()
+		defer service.prewarm.mu.Unlock()
+		return len(service.prewarm.idle["bash"])
+	}
+	require.Eventually(t, func() bool { return idle() == 1 }, 5*time.Second, 10*time.Millisecond)
+	service.prewarm.mu.Lock()
+	warmID := service.prewarm.idle["bash"][0].id
+	service.prewarm.mu.Unlock()
+	session, err = service.CreateSession(context.Background(), "alice", "bash", "")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	assert.NotEqual(t, warmID, session.ID)
+	assert.True(t, service.prewarm.holds(warmID))
+}
+
+// brokenSandbox wraps every process in a program that does not exist
+type brokenSandbox struct{}
+
+func (brokenSandbox) Profile(userID string) (string, error) { return "broken", nil }
+
+func (brokenSandbox) Wrap(profile string, argv []string) ([]string, error) {
+	return []string{"/nonexistent/sandbox"}, nil
+}
+
+func TestFailureMetrics(t *testing.T) {
+	cfg := config.SessionConfig{
+		MaxSessions:      1,
+		SessionTimeout:   "30m",
+		WorkingDirectory: t.TempDir(),
+	}
+	service := New(cfg, zap.NewNop())
+	trace := "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	session, err := service.CreateSession(context.Background(), "user123", "cat", "")
+	require.NoError(t, err)
+	defer service.KillSession(session.ID)
+	_, err = service.CreateSessionWithOptions(context.Background(), "user123", CreateOptions{Command: "cat", TraceID: trace})
+	assert.ErrorIs(t, err, ErrSessionLimit)
+	var quota *QuotaError
+	require.ErrorAs(t, err, &quota)
+	assert.Equal(t, 1, quota.Limit)
+	assert.Equal(t, 1, quota.Used)
+
+	service.SetSandbox(brokenSandbox{})
+	_, err = service.CreateSessionWithOptions(context.Background(), "other", CreateOptions{Command: "cat", TraceID: trace})
+	require.Error(t, err)
+
+	assert.Equal(t, map[string]int64{FailurePTYStart: 1, FailureWSWrite: 0, FailureQuota: 1}, service.Failures())
+
+	// The Prometheus format has no exemplars
+	var text strings.Builder
+	require.NoError(t, service.WriteMetrics(&text))
+	assert.Contains(t, text.String(), "# TYPE webtunnel_session_failures_total counter\n")
+	assert.Contains(t, text.String(), "webtunnel_session_failures_total{reason=\"quota_rejected\"} 1\n")
+	assert.NotContains(t, text.String(), "trace_id")
+
+	// OpenMetrics points each counter at the latest failure
+	var om strings.Builder
+	require.NoError(t, service.WriteOpenMetrics(&om))
+	assert.Contains(t, om.String(), "# TYPE webtunnel_session_failures counter\n")
+	assert.Regexp(t, `webtunnel_session_failures_total\{reason="quota_rejected"\} 1 # \{trace_id="`+trace+`"\} 1 \d+\.\d{3}\n`, om.String())
+	assert.Regexp(t, `webtunnel_session_failures_total\{reason="pty_start_failed"\} 1 # \{session_id="[^"]+",trace_id="`+trace+`"\} 1 `, om.String())
+	assert.Contains(t, om.String(), "webtunnel_session_failures_total{reason=\"ws_write_failed\"} 0\n")
+}
+
+func TestViewport(t *testing.T) {
+	tr := newPollTransport("c1", "sess", "user123")
+	cl := newClient(tr, "user123")
+	defer cl.close()
+	opts := AttachOptions{MaxFrame: MinFrame, FlushInterval: 50 * time.Millisecond}
+	require.NoError(t, opts.Validate())
+	cl.viewport = newViewport(cl, "sess", opts, func(err error) { t.Error(err) })
+
+	frames := func(cursor uint64, want int) ([]string, uint64) {
+		var data []string
+		deadline := time.Now().Add(5 * time.Second)
+		for len(data) < want && time.Now().Before(deadline) {
+			result := tr.poll(context.Background(), cursor, time.Second)
+			for _, raw := range result.Messages {
+				var msg protocol.Message
+				require.NoError(t, json.Unmarshal(raw, &msg))
+				data = append(data, msg.Data)
+			}
+			cursor = result.Cursor
+		}
+		return data, cursor
+	}
+
+	// A full frame goes out at once, split on a rune boundary; the rest
+	// waits for the flush interval
+	output := strings.Repeat("é", 100) + strings.Repeat("x", 100)
+	start := time.Now()
+	require.NoError(t, cl.viewport.add([]byte(output)))
+	got, cursor := frames(0, 2)
+	require.Len(t, got, 2)
+	assert.GreaterOrEqual(t, time.Since(start), opts.FlushInterval)
+	for _, frame := range got {
+		assert.LessOrEqual(t, len(frame), MinFrame)
+		assert.True(t, utf8.ValidString(frame))
+	}
+	assert.Equal(t, output, strings.Join(got, ""))
+
+	// Small writes coalesce, and a flush sends them early
+	require.NoError(t, cl.viewport.add([]byte("a")))
+	require.NoError(t, cl.viewport.add([]byte("b")))
+	require.NoError(t, cl.viewport.flush())
+	got, _ = frames(cursor, 1)
+	assert.Equal(t, []string{"ab"}, got)
+
+	assert.ErrorIs(t, AttachOptions{MaxFrame: 10}.Validate(), ErrInvalidAttachOptions)
+	assert.ErrorIs(t, AttachOptions{FlushInterval: time.Minute}.Validate(), ErrInvalidAttachOptions)
+}
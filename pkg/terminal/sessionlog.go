@@ -0,0 +1,126 @@
+package terminal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogEntry is one log line captured for a session
+type LogEntry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// logRing keeps the most recent entries of one session
+type logRing struct {
+	entries []LogEntry
+	next    int
+	full    bool
+	mu      sync.Mutex
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{entries: make([]LogEntry, size)}
+}
+
+func (r *logRing) add(entry LogEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = entry
+	if r.next++; r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// lines returns the entries oldest first
+func (r *logRing) lines() []LogEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]LogEntry{}, r.entries[:r.next]...)
+	}
+	return append(append([]LogEntry{}, r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// captureCore copies every entry, debug included, into a session's ring
+// regardless of the server log level, so attach and I/O problems can be
+// looked at after the fact without turning on debug logging globally
+type captureCore struct {
+	ring   *logRing
+	fields []zapcore.Field
+}
+
+func (c *captureCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *captureCore) With(fields []zapcore.Field) zapcore.Core {
+	return &captureCore{
+		ring:   c.ring,
+		fields: append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *captureCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checked.AddCore(entry, c)
+}
+
+func (c *captureCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	line := LogEntry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+	}
+	if len(enc.Fields) > 0 {
+		line.Fields = enc.Fields
+	}
+	c.ring.add(line)
+	return nil
+}
+
+func (c *captureCore) Sync() error {
+	return nil
+}
+
+// sessionLogger returns a logger that tags every entry with the session and
+// user, and also records it in the session's log buffer when enabled
+func (s *Service) sessionLogger(sessionID, userID string) (*zap.Logger, *logRing) {
+	logger := s.logger.With(zap.String("session_id", sessionID), zap.String("user_id", userID))
+	if s.config.LogLines <= 0 {
+		return logger, nil
+	}
+
+	ring := newLogRing(s.config.LogLines)
+	logger = logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, &captureCore{ring: ring})
+	}))
+	return logger, ring
+}
+
+// SessionLogs returns the captured log lines of a running session, oldest
+// first
+func (s *Service) SessionLogs(sessionID string) ([]LogEntry, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+	if session.logs == nil {
+		return nil, fmt.Errorf("session log capture is disabled")
+	}
+	return session.logs.lines(), nil
+}
@@ -0,0 +1,92 @@
+package terminal
+
+import "sync"
+
+const sessionShardCount = 16
+
+// sessionMap shards sessions by ID so lookups on the input/output hot path
+// do not contend on a single service-wide lock.
+type sessionMap struct {
+	shards [sessionShardCount]sessionShard
+}
+
+type sessionShard struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+func newSessionMap() *sessionMap {
+	m := &sessionMap{}
+	for i := range m.shards {
+		m.shards[i].sessions = make(map[string]*Session)
+	}
+	return m
+}
+
+// shard picks a shard with an inline FNV-1a hash, avoiding the hasher
+// allocation that hash/fnv would add to every lookup.
+func (m *sessionMap) shard(sessionID string) *sessionShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(sessionID); i++ {
+		h ^= uint32(sessionID[i])
+		h *= 16777619
+	}
+	return &m.shards[h%sessionShardCount]
+}
+
+func (m *sessionMap) get(sessionID string) (*Session, bool) {
+	shard := m.shard(sessionID)
+	shard.mu.RLock()
+	session, exists := shard.sessions[sessionID]
+	shard.mu.RUnlock()
+	return session, exists
+}
+
+func (m *sessionMap) set(session *Session) {
+	shard := m.shard(session.ID)
+	shard.mu.Lock()
+	shard.sessions[session.ID] = session
+	shard.mu.Unlock()
+}
+
+// remove deletes and returns the session, reporting whether it was present.
+func (m *sessionMap) remove(sessionID string) (*Session, bool) {
+	shard := m.shard(sessionID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	session, exists := shard.sessions[sessionID]
+	if exists {
+		delete(shard.sessions, sessionID)
+	}
+	return session, exists
+}
+
+// snapshot returns every session at the time of the call.
+func (m *sessionMap) snapshot() []*Session {
+	var sessions []*Session
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mu.RLock()
+		for _, session := range shard.sessions {
+			sessions = append(sessions, session)
+		}
+		shard.mu.RUnlock()
+	}
+	return sessions
+}
+
+// removeAll empties the map and returns the sessions it held.
+func (m *sessionMap) removeAll() []*Session {
+	var sessions []*Session
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mu.Lock()
+		for _, session := range shard.sessions {
+			sessions = append(sessions, session)
+		}
+		shard.sessions = make(map[string]*Session)
+		shard.mu.Unlock()
+	}
+	return sessions
+}
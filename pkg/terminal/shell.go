@@ -0,0 +1,58 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var ErrShellNotAllowed = errors.New("shell not allowed")
+
+// defaultShell is the shell sessions run in unless they ask for another:
+// the server's $SHELL, or bash
+func defaultShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/bash"
+}
+
+// ResolveShell checks a shell a session or user asked for against
+// allowed_shells and returns its path. name is a name such as "zsh",
+// looked up on the server's PATH, or an absolute path; entries of the list
+// are too. An empty name is the default shell, which needs no entry.
+func (s *Service) ResolveShell(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	for _, allowed := range s.config.AllowedShells {
+		path := allowed
+		if !filepath.IsAbs(allowed) {
+			resolved, err := exec.LookPath(allowed)
+			if err != nil {
+				if name == allowed {
+					return "", fmt.Errorf("%w: %s is not installed", ErrShellNotAllowed, name)
+				}
+				continue
+			}
+			path = resolved
+		}
+		// A bare name picks the allowed shell by that name; a path has to
+		// be the allowed one
+		if name == allowed || name == path || (!strings.Contains(name, "/") && name == filepath.Base(path)) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrShellNotAllowed, name)
+}
+
+// loginShell decides whether a session's shell starts as a login shell
+func (s *Service) loginShell(opts CreateOptions) bool {
+	if opts.Login != nil {
+		return *opts.Login
+	}
+	return s.config.LoginShell
+}
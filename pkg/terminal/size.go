@@ -0,0 +1,280 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// Resize policies decide the shared PTY's size from the sizes its attached
+// connections report
+const (
+	// ResizeSmallest fits the terminal into every writer's window, as tmux
+	// does; it grows back when the smallest one leaves
+	ResizeSmallest = "smallest"
+	// ResizeDriver follows the driver's window, falling back to the
+	// smallest while nobody holds control
+	ResizeDriver = "driver"
+	// ResizeFixed keeps the size the session was created or last set
+	// with, whatever size the windows are
+	ResizeFixed = "fixed"
+)
+
+// Why the PTY's size changed, as recorded in its history
+const (
+	SizeStarted  = "started"  // the size the session was created with
+	SizeReported = "reported" // a client's window changed
+	SizeControl  = "control"  // control changed hands
+	SizeLeft     = "left"     // a client detached
+	SizePolicy   = "policy"   // the owner changed the policy or fixed size
+)
+
+// maxSizeHistory is how many size changes a session remembers
+const maxSizeHistory = 50
+
+var ErrInvalidResizePolicy = errors.New("invalid resize policy")
+
+// SizeChange is one change of a session's PTY size. UserID is whose
+// window or action caused it, if anyone's.
+type SizeChange struct {
+	At     time.Time `json:"at"`
+	Cols   uint16    `json:"cols"`
+	Rows   uint16    `json:"rows"`
+	Policy string    `json:"policy"`
+	Reason string    `json:"reason"`
+	UserID string    `json:"user_id,omitempty"`
+}
+
+// SizeInfo is a session's PTY size and policy, with its recent changes
+// oldest first
+type SizeInfo struct {
+	Cols    uint16       `json:"cols"`
+	Rows    uint16       `json:"rows"`
+	Policy  string       `json:"policy"`
+	History []SizeChange `json:"history"`
+}
+
+// sizing is the PTY's size as last set and how it got there. mu is held
+// across each resize, so changes reach clients in the order they happen.
+type sizing struct {
+	mu         sync.Mutex
+	policy     string // empty for the configured one
+	cols, rows uint16
+	history    []SizeChange
+}
+
+func (z *sizing) record(change SizeChange) {
+	z.cols, z.rows = change.Cols, change.Rows
+	if len(z.history) >= maxSizeHistory {
+		z.history = append(z.history[:0], z.history[1:]...)
+	}
+	z.history = append(z.history, change)
+}
+
+// validResizePolicy admits the policies a session can be given; empty
+// leaves it with the configured one
+func validResizePolicy(policy string) error {
+	switch policy {
+	case "", ResizeSmallest, ResizeDriver, ResizeFixed:
+		return nil
+	}
+	return fmt.Errorf("%w %q; want %s, %s or %s", ErrInvalidResizePolicy, policy, ResizeSmallest, ResizeDriver, ResizeFixed)
+}
+
+// Size returns the session's PTY size, its policy and how the size got
+// there
+func (s *Service) Size(sessionID string) (*SizeInfo, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	policy := s.sizePolicy(session)
+	session.sizing.mu.Lock()
+	defer session.sizing.mu.Unlock()
+	return &SizeInfo{
+		Cols:    session.sizing.cols,
+		Rows:    session.sizing.rows,
+		Policy:  policy,
+		History: append([]SizeChange{}, session.sizing.history...),
+	}, nil
+}
+
+// SetResizePolicy changes how the session's size is picked; empty goes
+// back to the configured policy. Under ResizeFixed, non-zero cols and rows
+// set the size to keep; otherwise the PTY is refitted to the windows at
+// once. Only the owner may change it.
+func (s *Service) SetResizePolicy(sessionID, userID, policy string, cols, rows uint16) (*SizeInfo, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	if session.UserID != userID {
+		return nil, fmt.Errorf("%w: only the session owner can change the resize policy", ErrForbidden)
+	}
+	if err := validResizePolicy(policy); err != nil {
+		return nil, err
+	}
+	fixed := cols != 0 || rows != 0
+	if fixed && (cols == 0 || rows == 0) {
+		return nil, fmt.Errorf("%w: a fixed size needs both cols and rows", ErrInvalidResizePolicy)
+	}
+
+	effective := policy
+	if effective == "" {
+		effective = s.resizePolicy()
+	}
+	if fixed && effective != ResizeFixed {
+		return nil, fmt.Errorf("%w: only the %s policy keeps a size", ErrInvalidResizePolicy, ResizeFixed)
+	}
+
+	session.sizing.mu.Lock()
+	session.sizing.policy = policy
+	session.sizing.mu.Unlock()
+	session.logger.Info("Resize policy changed", zap.String("policy", effective))
+	if fixed {
+		if err := s.setSize(session, cols, rows, effective, SizePolicy, userID); err != nil {
+			return nil, err
+		}
+	} else {
+		s.arbitrateSize(session, SizePolicy, userID)
+	}
+	return s.Size(sessionID)
+}
+
+// reportSize records the window size a client reported and resizes the
+// PTY if that changes the size the policy picks
+func (s *Service) reportSize(session *Session, cl *client, cols, rows uint16) {
+	session.connMu.Lock()
+	cl.cols, cl.rows = cols, rows
+	session.connMu.Unlock()
+	s.arbitrateSize(session, SizeReported, cl.userID)
+}
+
+// arbitrateSize resizes the PTY to the size the policy picks from the
+// attached connections. Read-only viewers never count, nothing changes
+// until some writer has reported a size, and a fixed size never changes.
+func (s *Service) arbitrateSize(session *Session, reason, userID string) {
+	policy := s.sizePolicy(session)
+	if policy == ResizeFixed {
+		return
+	}
+	cols, rows := s.pickSize(session, policy)
+	if cols == 0 || rows == 0 || session.pty == nil {
+		return
+	}
+	if err := s.setSize(session, cols, rows, policy, reason, userID); err != nil {
+		session.logger.Debug("Failed to resize PTY", zap.Error(err))
+	}
+}
+
+// setSize resizes the PTY unless it already has the size, records the
+// change and tells every attached client the size to render at
+func (s *Service) setSize(session *Session, cols, rows uint16, policy, reason, userID string) error {
+	session.sizing.mu.Lock()
+	defer session.sizing.mu.Unlock()
+	if session.sizing.cols == cols && session.sizing.rows == rows {
+		return nil
+	}
+	if err := s.Resize(session.ID, cols, rows); err != nil {
+		return err
+	}
+	session.sizing.record(SizeChange{
+		At:     time.Now(),
+		Cols:   cols,
+		Rows:   rows,
+		Policy: policy,
+		Reason: reason,
+		UserID: userID,
+	})
+	session.logger.Debug("PTY resized",
+		zap.String("policy", policy),
+		zap.String("reason", reason),
+		zap.Uint16("cols", cols),
+		zap.Uint16("rows", rows))
+
+	msg, err := protocol.NewMessage(protocol.TypeSize, protocol.Size{
+		Cols:   int(cols),
+		Rows:   int(rows),
+		Policy: policy,
+		Reason: reason,
+	})
+	if err != nil {
+		return err
+	}
+	msg.SessionID = session.ID
+	s.broadcast(session, msg, nil)
+	return nil
+}
+
+// sendSize tells a client that just attached the size to render at
+func (s *Service) sendSize(session *Session, cl *client) error {
+	policy := s.sizePolicy(session)
+	session.sizing.mu.Lock()
+	size := protocol.Size{
+		Cols:   int(session.sizing.cols),
+		Rows:   int(session.sizing.rows),
+		Policy: policy,
+	}
+	session.sizing.mu.Unlock()
+	msg, err := protocol.NewMessage(protocol.TypeSize, size)
+	if err != nil {
+		return err
+	}
+	msg.SessionID = session.ID
+	return cl.writeJSON(msg)
+}
+
+// pickSize returns the size the policy picks, or zeros for none
+func (s *Service) pickSize(session *Session, policy string) (cols, rows uint16) {
+	session.controlMu.Lock()
+	driver := session.driver
+	session.controlMu.Unlock()
+
+	var driverCols, driverRows uint16
+	session.connMu.RLock()
+	defer session.connMu.RUnlock()
+	for _, cl := range session.connections {
+		if cl.access < AccessWrite || cl.cols == 0 || cl.rows == 0 {
+			continue
+		}
+		cols, rows = smaller(cols, cl.cols), smaller(rows, cl.rows)
+		if cl.userID == driver {
+			driverCols, driverRows = smaller(driverCols, cl.cols), smaller(driverRows, cl.rows)
+		}
+	}
+	if policy == ResizeDriver && driverCols != 0 {
+		return driverCols, driverRows
+	}
+	return cols, rows
+}
+
+// sizePolicy is the session's own policy, or the configured one
+func (s *Service) sizePolicy(session *Session) string {
+	session.sizing.mu.Lock()
+	policy := session.sizing.policy
+	session.sizing.mu.Unlock()
+	if policy != "" {
+		return policy
+	}
+	return s.resizePolicy()
+}
+
+func (s *Service) resizePolicy() string {
+	switch s.config.ResizePolicy {
+	case ResizeDriver, ResizeFixed:
+		return s.config.ResizePolicy
+	}
+	return ResizeSmallest
+}
+
+// smaller returns the smaller of a and b, treating zero as unset
+func smaller(a, b uint16) uint16 {
+	if a == 0 || b < a {
+		return b
+	}
+	return a
+}
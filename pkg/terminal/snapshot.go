@@ -0,0 +1,146 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// snapshotScrollback is how many lines that scrolled off a session's
+// screen are kept for reattaching clients
+const snapshotScrollback = 1000
+
+// Render serializes the screen as output that redraws it on a client
+// terminal of the same size. Scrollback and the primary screen are printed
+// line by line, so the scrollback ends up in the client's history; the
+// alternate screen, if active, is drawn over them, and the cursor, scroll
+// region and current style are restored last.
+func (s *Screen) Render() []byte {
+	var b bytes.Buffer
+	b.WriteString("\x1b[0m\r")
+
+	primary := s.grid
+	if s.primary != nil {
+		primary = s.primary
+	}
+	kept := s.scrollback
+	if len(kept) > s.maxScrollback {
+		kept = kept[len(kept)-s.maxScrollback:]
+	}
+	for _, line := range kept {
+		b.WriteString(string(line.text))
+		if !line.wrapped {
+			b.WriteString("\r\n")
+		}
+	}
+	s.renderRows(&b, primary, false)
+
+	if s.primary != nil {
+		b.WriteString("\x1b[?1049h")
+		s.renderRows(&b, s.grid, true)
+	}
+
+	if s.top != 0 || s.bot != s.rows-1 {
+		fmt.Fprintf(&b, "\x1b[%d;%dr", s.top+1, s.bot+1)
+	}
+	fmt.Fprintf(&b, "\x1b[%d;%dH", s.y+1, s.x+1)
+	b.WriteString(s.style.sgr())
+	return b.Bytes()
+}
+
+// renderRows writes every row of grid, blank ones included, so the last
+// row drawn is the bottom of the client's screen. Positioned rows are
+// drawn in place with cursor addressing instead of line feeds.
+func (s *Screen) renderRows(b *bytes.Buffer, grid []*row, positioned bool) {
+	for y, r := range grid {
+		if positioned {
+			fmt.Fprintf(b, "\x1b[%d;1H", y+1)
+		} else if y > 0 {
+			b.WriteString("\r\n")
+		}
+		style := defaultStyle
+		for _, run := range runs(r.cells) {
+			if run.Style != style {
+				b.WriteString(run.Style.sgr())
+				style = run.Style
+			}
+			b.WriteString(run.Text)
+		}
+		if style != defaultStyle {
+			b.WriteString("\x1b[0m")
+		}
+	}
+}
+
+// runs groups a row's cells into runs of one style, dropping trailing
+// unstyled blanks
+func runs(row []cell) []Run {
+	end := len(row)
+	for end > 0 && row[end-1].r == ' ' && row[end-1].style == defaultStyle {
+		end--
+	}
+
+	var result []Run
+	var text strings.Builder
+	for x := 0; x < end; x++ {
+		if x > 0 && row[x].style != row[x-1].style {
+			result = append(result, Run{Text: text.String(), Style: row[x-1].style})
+			text.Reset()
+		}
+		text.WriteRune(row[x].r)
+	}
+	if end > 0 {
+		result = append(result, Run{Text: text.String(), Style: row[end-1].style})
+	}
+	return result
+}
+
+// sgr returns the escape sequence that selects the style from a reset
+func (st Style) sgr() string {
+	params := []string{"0"}
+	for _, attr := range []struct {
+		on   bool
+		code string
+	}{
+		{st.Bold, "1"}, {st.Faint, "2"}, {st.Italic, "3"}, {st.Underline, "4"}, {st.Inverse, "7"}, {st.Strike, "9"},
+	} {
+		if attr.on {
+			params = append(params, attr.code)
+		}
+	}
+	if st.FG != ColorDefault {
+		params = append(params, st.FG.sgr(30, 90, "38"))
+	}
+	if st.BG != ColorDefault {
+		params = append(params, st.BG.sgr(40, 100, "48"))
+	}
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+// sgr returns the color's SGR parameters given the bases for the normal
+// and bright palettes and the extended color introducer
+func (c Color) sgr(base, bright int, extended string) string {
+	switch {
+	case c&colorRGB != 0:
+		rgb := int32(c &^ colorRGB)
+		return fmt.Sprintf("%s;2;%d;%d;%d", extended, rgb>>16&0xff, rgb>>8&0xff, rgb&0xff)
+	case c < 8:
+		return strconv.Itoa(base + int(c))
+	case c < 16:
+		return strconv.Itoa(bright + int(c) - 8)
+	default:
+		return fmt.Sprintf("%s;5;%d", extended, c)
+	}
+}
+
+// snapshot is what a newly attached client is sent: the rendered screen
+// when the session tracks one, else the raw buffered output
+func (s *Service) snapshot(session *Session) []byte {
+	session.waitMu.Lock()
+	defer session.waitMu.Unlock()
+	if session.screen == nil {
+		return session.outputBuf.Read()
+	}
+	return session.screen.Render()
+}
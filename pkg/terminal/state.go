@@ -0,0 +1,259 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// outputDrainGrace is how long a session whose process exited waits for
+// the rest of its output before stopping anyway. A background child that
+// inherited the terminal can keep it open long after the process is gone.
+const outputDrainGrace = 2 * time.Second
+
+// What happened to a session. Every status change goes through one of
+// these, applied in order by the session's state goroutine.
+type stateEventKind int
+
+const (
+	stateExited       stateEventKind = iota // the process exited; err is its exit status
+	stateOutputClosed                       // the PTY stopped giving output; err is set if reading failed
+	stateKilled                             // the session was killed or the server is shutting down; reason says which
+)
+
+type stateEvent struct {
+	kind   stateEventKind
+	err    error
+	reason ExitReason
+}
+
+// ExitReason says why a session ended
+type ExitReason string
+
+const (
+	ExitNormal    ExitReason = "exited"     // the process exited on its own, with an exit code
+	ExitSignal    ExitReason = "signal"     // a signal ended the process
+	ExitOOM       ExitReason = "oom"        // the kernel killed the process for running out of memory
+	ExitTimeout   ExitReason = "timeout"    // the session was idle past session_timeout
+	ExitKilled    ExitReason = "killed"     // the session was killed through the API
+	ExitShutdown  ExitReason = "shutdown"   // the server shut down
+	ExitError     ExitReason = "error"      // the session's output could not be read
+	ExitDiskQuota ExitReason = "disk_quota" // the session's directory outgrew janitor.quota_mb
+)
+
+// Status returns the session's current status
+func (s *Session) Status() Status {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.status
+}
+
+// ExitCode returns the process's exit code once it has exited, and false
+// while it runs or when the session was killed. A process ended by a
+// signal reports -1.
+func (s *Session) ExitCode() (int, bool) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.exitCode == nil {
+		return 0, false
+	}
+	return *s.exitCode, true
+}
+
+// ExitReason returns why the session ended, empty while it runs, and the
+// name of the signal that ended the process, if one did
+func (s *Session) ExitReason() (ExitReason, string) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.exitReason, s.exitSignal
+}
+
+// Done is closed once the session has reached its final status
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// MarshalJSON adds the status and exit code, which are guarded by the
+// state lock, to the exported fields
+func (s *Session) MarshalJSON() ([]byte, error) {
+	type fields Session
+	s.stateMu.Lock()
+	status, exitCode, reason, signal, name, tags := s.status, s.exitCode, s.exitReason, s.exitSignal, s.name, s.tags
+	var endedAt *time.Time
+	if !s.endedAt.IsZero() {
+		ended := s.endedAt
+		endedAt = &ended
+	}
+	s.stateMu.Unlock()
+	var elevatedUntil *time.Time
+	if until, ok := s.ElevatedUntil(); ok {
+		elevatedUntil = &until
+	}
+	presence := s.presence("", "")
+	activity := s.Activity()
+	input, output := s.Traffic()
+	s.titling.mu.Lock()
+	title, command := s.titling.title, s.titling.command
+	s.titling.mu.Unlock()
+	return json.Marshal(struct {
+		*fields
+		Presenting     bool       `json:"presenting"`
+		Name           string     `json:"name,omitempty"`
+		Tags           []string   `json:"tags,omitempty"`
+		Status         Status     `json:"status"`
+		ExitCode       *int       `json:"exit_code,omitempty"`
+		ExitReason     ExitReason `json:"exit_reason,omitempty"`
+		ExitSignal     string     `json:"exit_signal,omitempty"`
+		EndedAt        *time.Time `json:"ended_at,omitempty"`
+		ElevatedUntil  *time.Time `json:"elevated_until,omitempty"`
+		InputBytes     int64      `json:"input_bytes"`
+		OutputBytes    int64      `json:"output_bytes"`
+		Title          string     `json:"title,omitempty"`
+		CurrentCommand string     `json:"current_command,omitempty"`
+
+		Driver       string                 `json:"driver,omitempty"`
+		Participants []protocol.Participant `json:"participants"`
+		Activity
+	}{(*fields)(s), s.Presenting(), name, tags, status, exitCode, reason, signal, endedAt, elevatedUntil, input, output, title, command, presence.Driver, presence.Participants, activity})
+}
+
+func (s *Session) setStatus(status Status) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.status = status
+}
+
+// sendState hands an event to the session's state goroutine. Events after
+// the session reached its final status are dropped.
+func (s *Session) sendState(kind stateEventKind, err error) {
+	s.send(stateEvent{kind: kind, err: err})
+}
+
+// sendKilled tells the state goroutine the session is being killed. It is
+// sent before the process is killed, so the reason wins over the exit.
+func (s *Session) sendKilled(reason ExitReason) {
+	s.send(stateEvent{kind: stateKilled, reason: reason})
+}
+
+func (s *Session) send(ev stateEvent) {
+	select {
+	case s.events <- ev:
+	case <-s.done:
+	}
+}
+
+// runState is the only writer of a running session's status. A session
+// stops once its process has exited and its output has been read to the
+// end, so the exit notification follows the last output; it stops at once
+// when killed, and fails if its output cannot be read while the process
+// still runs.
+func (s *Service) runState(session *Session) {
+	var exited, drained bool
+	var exitErr error
+	var grace <-chan time.Time
+
+	for {
+		select {
+		case ev := <-session.events:
+			switch ev.kind {
+			case stateKilled:
+				s.finish(session, StatusStopped, ev.reason, nil, false)
+				return
+			case stateExited:
+				exited, exitErr = true, ev.err
+				if drained {
+					s.finish(session, StatusStopped, "", exitErr, true)
+					return
+				}
+				timer := time.NewTimer(outputDrainGrace)
+				defer timer.Stop()
+				grace = timer.C
+			case stateOutputClosed:
+				drained = true
+				switch {
+				case exited:
+					s.finish(session, StatusStopped, "", exitErr, true)
+					return
+				case ev.err != nil:
+					session.logger.Error("Error reading from PTY", zap.Error(ev.err))
+					s.finish(session, StatusError, ExitError, nil, false)
+					return
+				}
+			}
+		case <-grace:
+			session.logger.Debug("Output still open after process exit, stopping anyway")
+			s.finish(session, StatusStopped, "", exitErr, true)
+			return
+		}
+	}
+}
+
+// finish records the final status, why the session ended and, for a
+// process that exited on its own, its exit code and any signal. It
+// persists them unless the server is shutting down, then tells attached
+// clients and subscribers.
+func (s *Service) finish(session *Session, status Status, reason ExitReason, exitErr error, exited bool) {
+	var signal string
+	if exited {
+		reason, signal = s.exitReason(session, exitErr)
+	}
+
+	session.stateMu.Lock()
+	session.status = status
+	session.exitReason, session.exitSignal = reason, signal
+	session.endedAt = time.Now()
+	if exited {
+		code := exitCode(exitErr)
+		session.exitCode = &code
+	}
+	session.stateMu.Unlock()
+	if !s.stopping.Load() {
+		s.persist(session)
+	}
+	close(session.done)
+
+	// Sessions killed through the API are gone from the map already; those
+	// that exited on their own stay, for ended_grace if set
+	_, lingers := s.GetSession(session.ID)
+	lingers = lingers && exited
+
+	session.logger.Info("Session finished",
+		zap.String("status", string(status)),
+		zap.String("reason", string(reason)))
+	s.sendExit(session, lingers)
+	if lingers {
+		session.connMu.RLock()
+		detached := len(session.connections) == 0
+		session.connMu.RUnlock()
+
+		detail := map[string]string{
+			"detached":  strconv.FormatBool(detached),
+			"exit_code": strconv.Itoa(*session.exitCode),
+			"reason":    string(reason),
+			"duration":  session.Duration().Round(time.Second).String(),
+		}
+		if signal != "" {
+			detail["signal"] = signal
+		}
+		s.emit(session, EventSessionExited, detail)
+		s.expireEnded(session)
+	}
+}
+
+// exitCode is the code for cmd.Wait's result: 0, the process's own code,
+// or -1 when a signal ended it
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
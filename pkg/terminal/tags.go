@@ -0,0 +1,135 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	maxTags   = 20
+	maxTagLen = 64
+)
+
+var ErrInvalidTags = errors.New("invalid session tags")
+
+// normalizeTags checks tags are short, printable and free of spaces and
+// commas, and returns them sorted without duplicates
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLen {
+			return nil, fmt.Errorf("%w: tags must be 1 to %d characters", ErrInvalidTags, maxTagLen)
+		}
+		for _, r := range tag {
+			if !unicode.IsPrint(r) || unicode.IsSpace(r) || r == ',' {
+				return nil, fmt.Errorf("%w: %q has a space, comma or unprintable character", ErrInvalidTags, tag)
+			}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	if len(result) > maxTags {
+		return nil, fmt.Errorf("%w: at most %d tags", ErrInvalidTags, maxTags)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Tags returns the session's tags, sorted
+func (s *Session) Tags() []string {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return append([]string(nil), s.tags...)
+}
+
+// SetTags replaces a session's tags; an empty list removes them all
+func (s *Service) SetTags(sessionID string, tags []string) (*Session, error) {
+	return s.Update(sessionID, SessionUpdate{Tags: &tags})
+}
+
+// SessionUpdate changes a session's labels. Nil fields are kept.
+type SessionUpdate struct {
+	Name *string
+	Tags *[]string
+}
+
+// Update checks every field of the update before applying any, so an
+// invalid tag does not leave the session renamed
+func (s *Service) Update(sessionID string, update SessionUpdate) (*Session, error) {
+	session, exists := s.GetSession(sessionID)
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+	var name string
+	var tags []string
+	var err error
+	if update.Name != nil {
+		if name, err = normalizeName(*update.Name); err != nil {
+			return nil, err
+		}
+	}
+	if update.Tags != nil {
+		if tags, err = normalizeTags(*update.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	session.stateMu.Lock()
+	if update.Name != nil {
+		session.name = name
+	}
+	if update.Tags != nil {
+		session.tags = tags
+	}
+	name, tags = session.name, session.tags
+	session.stateMu.Unlock()
+
+	s.persist(session)
+	session.logger.Info("Session updated", zap.String("name", name), zap.Strings("tags", tags))
+	return session, nil
+}
+
+// SessionFilter narrows a session listing. Zero fields match everything.
+type SessionFilter struct {
+	Tags   []string // the session has every one of these
+	Status Status
+}
+
+// Matches reports whether the session passes the filter
+func (f SessionFilter) Matches(session *Session) bool {
+	if f.Status != "" && session.Status() != f.Status {
+		return false
+	}
+	if len(f.Tags) == 0 {
+		return true
+	}
+	tags := session.Tags()
+	for _, want := range f.Tags {
+		i := sort.SearchStrings(tags, want)
+		if i == len(tags) || tags[i] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// FindSessions lists the sessions the user may read that match the filter
+func (s *Service) FindSessions(userID string, filter SessionFilter) []*Session {
+	result := []*Session{}
+	for _, session := range s.ListReadableSessions(userID) {
+		if filter.Matches(session) {
+			result = append(result, session)
+		}
+	}
+	return result
+}
@@ -0,0 +1,173 @@
+package terminal
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// terminate ends a session that has been removed from the map. Every
+// process on the session's PTY, background jobs and editors included, gets
+// the stop signal, and the shell a SIGHUP as well, since interactive shells
+// ignore SIGTERM. Whatever is still running on the PTY after the grace
+// period is killed, orphaned jobs included, so none outlives the session.
+// Only then are the PTY closed and the session cancelled, which would
+// otherwise kill the shell at once, its spilled scrollback deleted and
+// its throwaway user, with anything it still runs elsewhere, removed,
+// and its directory handed to the reclaimer.
+func (s *Service) terminate(session *Session) {
+	defer func() {
+		session.cancel()
+		if session.pty != nil {
+			session.pty.Close()
+		}
+		session.spill.remove()
+		session.hostUser.remove()
+		s.reclaim(session)
+	}()
+	if session.cmd == nil || session.cmd.Process == nil {
+		return
+	}
+
+	pid := session.cmd.Process.Pid
+	grace := s.stopGrace()
+	forced := false
+	if grace > 0 {
+		signal := s.stopSignal()
+		signalSession(pid, signal)
+		if signal != syscall.SIGHUP {
+			syscall.Kill(pid, syscall.SIGHUP)
+		}
+		forced = !waitSession(pid, session.reaped, grace)
+	}
+	if n := signalSession(pid, syscall.SIGKILL); n > 0 {
+		forced = true
+	}
+	session.logger.Info("Stopped session processes",
+		zap.Duration("grace", grace),
+		zap.Bool("forced", forced))
+}
+
+// waitSession waits up to grace for every process in the terminal session
+// led by pid to exit, or without /proc for the leader to be reaped, and
+// reports whether they did
+func waitSession(pid int, reaped <-chan struct{}, grace time.Duration) bool {
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-deadline.C:
+			return false
+		case <-ticker.C:
+			members, err := sessionMembers(pid)
+			if err != nil {
+				select {
+				case <-reaped:
+					return true
+				default:
+				}
+			} else if len(members) == 0 {
+				return true
+			}
+		}
+	}
+}
+
+// terminateAll terminates sessions side by side and waits for them all
+func (s *Service) terminateAll(sessions []*Session) {
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *Session) {
+			defer wg.Done()
+			s.terminate(session)
+		}(session)
+	}
+	wg.Wait()
+}
+
+// stopGrace is how long processes get to exit after the stop signal
+// before they are killed; 0 kills them at once
+func (s *Service) stopGrace() time.Duration {
+	if s.config.StopGrace == "" {
+		return 5 * time.Second // default
+	}
+	grace, err := time.ParseDuration(s.config.StopGrace)
+	if err != nil || grace < 0 {
+		return 5 * time.Second
+	}
+	return grace
+}
+
+// stopSignal is the signal processes get first, SIGTERM by default
+func (s *Service) stopSignal() syscall.Signal {
+	name := strings.ToUpper(s.config.StopSignal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if signal := unix.SignalNum(name); signal != 0 {
+		return signal
+	}
+	return syscall.SIGTERM
+}
+
+// signalSession sends signal to every process in the terminal session led
+// by pid, whatever process group it has moved to, and returns how many it
+// reached. Without /proc it signals the leader's process group.
+func signalSession(pid int, signal syscall.Signal) int {
+	members, err := sessionMembers(pid)
+	if err != nil {
+		if syscall.Kill(-pid, signal) == nil {
+			return 1
+		}
+		return 0
+	}
+	sent := 0
+	for _, member := range members {
+		if syscall.Kill(member, signal) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// sessionMembers lists the live processes whose session ID is sid
+func sessionMembers(sid int) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var members []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid pgrp session ...; comm may hold spaces
+		// and parentheses, so split after the last ')'
+		i := strings.LastIndexByte(string(data), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(data[i+1:]))
+		if len(fields) < 4 || fields[0] == "Z" {
+			continue
+		}
+		if session, _ := strconv.Atoi(fields[3]); session == sid {
+			members = append(members, pid)
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,238 @@
+package terminal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// titleDelay is how long after output the foreground process is
+	// looked up and changes are sent, so bursts of output or titles
+	// coalesce into one frame
+	titleDelay = 100 * time.Millisecond
+	// maxTitle caps OSC strings and commands, in bytes
+	maxTitle = 256
+)
+
+// OSC parser states
+const (
+	titleGround    = iota
+	titleEscape    // after ESC
+	titleOSC       // in an OSC string
+	titleOSCEscape // after ESC in an OSC string, which ESC \ ends
+)
+
+// titling tracks the title programs set with OSC 0 and OSC 2 and the
+// command running in the foreground of the PTY
+type titling struct {
+	mu      sync.Mutex
+	state   int
+	osc     []byte // the OSC string so far, cut at maxTitle
+	title   string
+	command string
+	sent    protocol.Title // what clients were last told
+	pending bool           // a lookup is scheduled
+}
+
+// Title returns the title the session's programs last set, if any
+func (s *Session) Title() string {
+	s.titling.mu.Lock()
+	defer s.titling.mu.Unlock()
+	return s.titling.title
+}
+
+// CurrentCommand returns the command line of the process in the PTY's
+// foreground, e.g. "vim main.go", as of the latest output
+func (s *Session) CurrentCommand() string {
+	s.titling.mu.Lock()
+	defer s.titling.mu.Unlock()
+	return s.titling.command
+}
+
+// trackTitle picks titles out of output and schedules a lookup of the
+// foreground process, which tells clients what changed
+func (s *Service) trackTitle(session *Session, output []byte) {
+	t := &session.titling
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scan(output)
+	if !t.pending {
+		t.pending = true
+		time.AfterFunc(titleDelay, func() { s.updateTitle(session) })
+	}
+}
+
+// scan feeds output through the OSC parser. Sequences may be split across
+// reads.
+func (t *titling) scan(output []byte) {
+	if t.state == titleGround && bytes.IndexByte(output, 0x1b) < 0 {
+		return
+	}
+	for _, b := range output {
+		switch t.state {
+		case titleGround:
+			if b == 0x1b {
+				t.state = titleEscape
+			}
+		case titleEscape:
+			if b == ']' {
+				t.state, t.osc = titleOSC, t.osc[:0]
+			} else if b != 0x1b {
+				t.state = titleGround
+			}
+		case titleOSC:
+			switch b {
+			case 0x07:
+				t.endOSC()
+			case 0x1b:
+				t.state = titleOSCEscape
+			default:
+				if len(t.osc) < maxTitle+2 {
+					t.osc = append(t.osc, b)
+				}
+			}
+		case titleOSCEscape:
+			if b == '\\' {
+				t.endOSC()
+			} else {
+				// ESC cancels the string and may start another sequence
+				t.state = titleEscape
+				if b == ']' {
+					t.state, t.osc = titleOSC, t.osc[:0]
+				}
+			}
+		}
+	}
+}
+
+// endOSC applies a finished OSC string: 0 sets the icon name and title, 2
+// the title alone. Other strings, such as hyperlinks, are ignored.
+func (t *titling) endOSC() {
+	t.state = titleGround
+	kind, title, ok := strings.Cut(string(t.osc), ";")
+	if !ok || (kind != "0" && kind != "2") {
+		return
+	}
+	t.title = truncateTitle(strings.Map(printable, title))
+}
+
+// printable drops control characters from titles
+func printable(r rune) rune {
+	if r < 0x20 || r == 0x7f {
+		return -1
+	}
+	return r
+}
+
+// truncateTitle cuts s to maxTitle bytes without splitting a character
+func truncateTitle(s string) string {
+	if len(s) <= maxTitle {
+		return s
+	}
+	for i := maxTitle; i > 0; i-- {
+		if !isContinuation(s[i]) {
+			return s[:i]
+		}
+	}
+	return ""
+}
+
+func isContinuation(b byte) bool {
+	return b&0xc0 == 0x80
+}
+
+// updateTitle looks up the foreground process and tells attached clients
+// when it or the title changed. Under the fair scheduler the frame is
+// queued behind the output that prompted it.
+func (s *Service) updateTitle(session *Session) {
+	command, err := foregroundCommand(session.pty)
+	if err != nil {
+		session.logger.Debug("Failed to look up foreground process", zap.Error(err))
+	}
+
+	t := &session.titling
+	t.mu.Lock()
+	t.pending = false
+	if err == nil {
+		t.command = command
+	}
+	current := protocol.Title{Title: t.title, CurrentCommand: t.command}
+	changed := current != t.sent
+	t.sent = current
+	t.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	msg, err := protocol.NewMessage(protocol.TypeTitle, current)
+	if err != nil {
+		return
+	}
+	msg.SessionID = session.ID
+	if s.fanout != nil {
+		s.fanout.then(session, func() { s.broadcast(session, msg, nil) })
+		return
+	}
+	s.broadcast(session, msg, nil)
+}
+
+// sendTitle tells a client that just attached what the session shows,
+// once anything is known
+func (s *Service) sendTitle(session *Session, cl *client) error {
+	session.titling.mu.Lock()
+	current := session.titling.sent
+	session.titling.mu.Unlock()
+	if current == (protocol.Title{}) {
+		return nil
+	}
+	msg, err := protocol.NewMessage(protocol.TypeTitle, current)
+	if err != nil {
+		return err
+	}
+	msg.SessionID = session.ID
+	return cl.writeJSON(msg)
+}
+
+// foregroundCommand returns the command line of the leader of the PTY's
+// foreground process group. argv[0] is shortened to its base name, without
+// the dash a login shell's starts with. It needs /proc.
+func foregroundCommand(ptmx *os.File) (string, error) {
+	if ptmx == nil {
+		return "", fmt.Errorf("no PTY")
+	}
+	// Fd would put the PTY in blocking mode and break read deadlines
+	conn, err := ptmx.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var pgrp int
+	var ioctlErr error
+	if err := conn.Control(func(fd uintptr) {
+		pgrp, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	}); err != nil {
+		return "", err
+	}
+	if ioctlErr != nil {
+		return "", ioctlErr
+	}
+
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pgrp))
+	if err != nil {
+		return "", err
+	}
+	args := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	if len(args) == 0 || args[0] == "" {
+		return "", fmt.Errorf("process %d has no command line", pgrp)
+	}
+	args[0] = strings.TrimPrefix(filepath.Base(args[0]), "-")
+	return truncateTitle(strings.Map(printable, strings.Join(args, " "))), nil
+}
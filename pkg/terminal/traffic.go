@@ -0,0 +1,83 @@
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// Directions of PTY traffic
+const (
+	TrafficInput  = "input"
+	TrafficOutput = "output"
+)
+
+// traffic counts the bytes written to and read from a session's PTY over
+// its whole life
+type traffic struct {
+	in  atomic.Int64
+	out atomic.Int64
+}
+
+// Traffic returns the bytes written to the session's PTY as input and read
+// from it as output so far
+func (s *Session) Traffic() (input, output int64) {
+	return s.traffic.in.Load(), s.traffic.out.Load()
+}
+
+// countInput adds written input to the session's total and emits
+// EventTrafficThreshold for each input threshold it crossed
+func (s *Service) countInput(session *Session, n int) {
+	total := session.traffic.in.Add(int64(n))
+	s.checkTraffic(session, TrafficInput, s.config.InputThresholds, total-int64(n), total)
+}
+
+// countOutput is countInput for output read from the PTY
+func (s *Service) countOutput(session *Session, n int) {
+	total := session.traffic.out.Add(int64(n))
+	s.checkTraffic(session, TrafficOutput, s.config.OutputThresholds, total-int64(n), total)
+}
+
+// checkTraffic emits an event for each threshold a total passed on its way
+// from before to after. Totals only grow, so each fires once per session.
+func (s *Service) checkTraffic(session *Session, direction string, thresholds []int64, before, after int64) {
+	for _, threshold := range thresholds {
+		if threshold > 0 && before < threshold && after >= threshold {
+			session.logger.Info("Session passed a traffic threshold",
+				zap.String("direction", direction),
+				zap.Int64("threshold", threshold))
+			s.emit(session, EventTrafficThreshold, map[string]string{
+				"direction": direction,
+				"threshold": strconv.FormatInt(threshold, 10),
+				"bytes":     strconv.FormatInt(after, 10),
+			})
+		}
+	}
+}
+
+// trafficLines renders each live session's byte counters
+func (s *Service) trafficLines(openMetrics bool) []string {
+	sessions := s.sessions.snapshot()
+	var lines []string
+	for _, counter := range []struct {
+		direction, help string
+		count           func(*Session) int64
+	}{
+		{TrafficInput, "Bytes written to each session's PTY as input.", func(s *Session) int64 { return s.traffic.in.Load() }},
+		{TrafficOutput, "Bytes read from each session's PTY as output.", func(s *Session) int64 { return s.traffic.out.Load() }},
+	} {
+		// OpenMetrics names the counter family without the _total suffix
+		name := "webtunnel_session_" + counter.direction + "_bytes"
+		family := name
+		if !openMetrics {
+			family += "_total"
+		}
+		lines = append(lines, "# HELP "+family+" "+counter.help, "# TYPE "+family+" counter")
+		for _, session := range sessions {
+			lines = append(lines, fmt.Sprintf("%s_total{session=%q,user=%q} %d", name, session.ID, session.UserID, counter.count(session)))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,188 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yourusername/webtunnel/pkg/protocol"
+)
+
+const (
+	// MinFrame and MaxFrame bound the output frame size a client may ask for
+	MinFrame = 256
+	MaxFrame = 1 << 20
+
+	// MaxFlushInterval bounds how long a client may have output held back
+	MaxFlushInterval = time.Second
+
+	// maxCoalesce flushes a client that coalesces without a frame size
+	// once this much output is pending
+	maxCoalesce = 64 << 10
+)
+
+var ErrInvalidAttachOptions = errors.New("invalid attach options")
+
+// AttachOptions are what a client asks for when it attaches. The zero
+// value replays the whole buffer, sends output as it arrives and gives the
+// client whatever access the user has.
+type AttachOptions struct {
+	// Since replays output from a bookmark or byte offset
+	Since string
+
+	// MaxFrame splits output into frames of at most this many bytes, so
+	// small clients are not handed one giant replay; zero leaves frames
+	// whole
+	MaxFrame int
+
+	// FlushInterval coalesces output and sends it at most this often;
+	// zero sends it at once
+	FlushInterval time.Duration
+
+	// ReadOnly attaches as a viewer, without input or resize, even when
+	// the user could write
+	ReadOnly bool
+
+	// shared is the access a share link the owner issued grants its
+	// guest, whatever the guest's own permissions
+	shared Access
+}
+
+func (o AttachOptions) Validate() error {
+	if o.MaxFrame != 0 && (o.MaxFrame < MinFrame || o.MaxFrame > MaxFrame) {
+		return fmt.Errorf("%w: max frame must be %d to %d bytes", ErrInvalidAttachOptions, MinFrame, MaxFrame)
+	}
+	if o.FlushInterval < 0 || o.FlushInterval > MaxFlushInterval {
+		return fmt.Errorf("%w: flush interval must be at most %s", ErrInvalidAttachOptions, MaxFlushInterval)
+	}
+	return nil
+}
+
+// viewport shapes the output frames of one client that asked for a frame
+// size or flush interval. Every output frame the client gets goes through
+// it, and other frames flush it first, so output stays in order.
+type viewport struct {
+	cl        *client
+	sessionID string
+	maxFrame  int
+	interval  time.Duration
+	// failed is called when a timed flush cannot write to the client
+	failed func(error)
+
+	mu      sync.Mutex
+	pending []byte
+	timer   *time.Timer
+}
+
+func newViewport(cl *client, sessionID string, opts AttachOptions, failed func(error)) *viewport {
+	return &viewport{
+		cl:        cl,
+		sessionID: sessionID,
+		maxFrame:  opts.MaxFrame,
+		interval:  opts.FlushInterval,
+		failed:    failed,
+	}
+}
+
+// add queues output. Full frames go out at once; the rest waits for the
+// flush interval, if there is one.
+func (v *viewport) add(output []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.pending = append(v.pending, output...)
+	switch {
+	case v.interval == 0:
+		return v.write(true)
+	case v.maxFrame > 0 && len(v.pending) >= v.maxFrame:
+		if err := v.write(false); err != nil {
+			return err
+		}
+	case v.maxFrame == 0 && len(v.pending) >= maxCoalesce:
+		return v.write(true)
+	}
+	if len(v.pending) > 0 && v.timer == nil {
+		v.timer = time.AfterFunc(v.interval, v.tick)
+	}
+	return nil
+}
+
+// send writes output at once, after anything pending
+func (v *viewport) send(output []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.pending = append(v.pending, output...)
+	return v.write(true)
+}
+
+// flush sends whatever output is pending
+func (v *viewport) flush() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.write(true)
+}
+
+func (v *viewport) tick() {
+	select {
+	case <-v.cl.done:
+		return
+	default:
+	}
+	v.mu.Lock()
+	v.timer = nil
+	err := v.write(true)
+	v.mu.Unlock()
+	if err != nil {
+		v.failed(err)
+	}
+}
+
+// stop drops pending output when the client goes away
+func (v *viewport) stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.timer != nil {
+		v.timer.Stop()
+		v.timer = nil
+	}
+	v.pending = nil
+}
+
+// write sends pending output in frames of at most maxFrame bytes; unless
+// all is set, a short last frame is kept back. Callers hold mu.
+func (v *viewport) write(all bool) error {
+	for len(v.pending) > 0 && (all || len(v.pending) >= v.maxFrame) {
+		n := frameSplit(v.pending, v.maxFrame)
+		err := v.cl.writeJSON(protocol.Message{
+			Type:      protocol.TypeOutput,
+			Data:      string(v.pending[:n]),
+			Timestamp: time.Now(),
+			SessionID: v.sessionID,
+		})
+		if err != nil {
+			v.pending = nil
+			return err
+		}
+		v.pending = v.pending[n:]
+	}
+	if len(v.pending) == 0 {
+		v.pending = nil
+	}
+	return nil
+}
+
+// frameSplit returns how much of data fits in a frame of max bytes without
+// cutting a UTF-8 sequence in two. A max of zero takes all of it.
+func frameSplit(data []byte, max int) int {
+	if max <= 0 || len(data) <= max {
+		return len(data)
+	}
+	for cut := max; cut > 0 && cut > max-utf8.UTFMax; cut-- {
+		if utf8.RuneStart(data[cut]) {
+			return cut
+		}
+	}
+	return max
+}
@@ -0,0 +1,53 @@
+package terminal
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Reclaimer disposes of a session's directory once the session has ended,
+// e.g. the janitor under janitor.on_end
+type Reclaimer interface {
+	Reclaim(sessionID, dir string)
+}
+
+// SetReclaimer hands each session's directory to reclaimer once the
+// session is removed and its processes have stopped. Sessions ended by a
+// shutdown keep theirs, to be restored on the next start.
+func (s *Service) SetReclaimer(reclaimer Reclaimer) {
+	s.reclaimer = reclaimer
+}
+
+// EndSession kills a session for reason, e.g. ExitDiskQuota, which
+// clients are told and its record keeps
+func (s *Service) EndSession(sessionID string, reason ExitReason) error {
+	session, exists := s.sessions.remove(sessionID)
+	if !exists {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	session.sendKilled(reason)
+
+	// Stop the processes, giving them the grace period to save state
+	go s.terminate(session)
+
+	// Close all client connections
+	session.connMu.Lock()
+	for conn := range session.connections {
+		conn.Close()
+	}
+	session.connMu.Unlock()
+
+	session.logger.Info("Ended terminal session", zap.String("reason", string(reason)))
+	return nil
+}
+
+// reclaim passes the directory of a session that has been terminated to
+// the reclaimer
+func (s *Service) reclaim(session *Session) {
+	if s.reclaimer == nil || s.stopping.Load() || session.WorkingDir == "" {
+		return
+	}
+	s.reclaimer.Reclaim(session.ID, session.WorkingDir)
+}